@@ -0,0 +1,139 @@
+package access
+
+import (
+	"bigpot/storage"
+	"bigpot/system"
+)
+
+// BitmapHeapScan visits the heap tuples of a TIDBitmap, such as one built
+// from the results of index scans, in the physical order of the heap, as
+// postgres' BitmapHeapScan.  The keys are checked only with the tuples
+// whose page needs a recheck, which lossy pages always do.  It implements
+// Scan.
+type BitmapHeapScan struct {
+	rel      *HeapRelation
+	ScanKeys []ScanKey
+	bufMgr   storage.BufferManager
+	iterator *TBMIterator
+	nBlocks  system.BlockNumber
+	// the page being visited, and the buffer holding it
+	cPage *TBMIterateResult
+	cBuf  storage.Buffer
+	// position of the next tid in cPage.Offsets, or the next offset on a
+	// lossy page
+	next int
+	// currently scanning tuple
+	cTuple *HeapTuple
+}
+
+// Begins a scan of the heap tuples in the bitmap.  The keys are the quals
+// to recheck the tuples with.
+func (rel *HeapRelation) BeginBitmapScan(bitmap *TIDBitmap, keys []ScanKey,
+	bufMgr storage.BufferManager) (*BitmapHeapScan, error) {
+	nBlocks, err := rel.GetNumberOfBlocks()
+	if err != nil {
+		return nil, err
+	}
+	return &BitmapHeapScan{
+		rel:      rel,
+		ScanKeys: keys,
+		bufMgr:   bufMgr,
+		iterator: bitmap.BeginIterate(),
+		nBlocks:  nBlocks,
+		cBuf:     storage.InvalidBuffer(),
+		cTuple: &HeapTuple{
+			tableOid: rel.RelId,
+			tupdesc:  rel.RelDesc,
+		},
+	}, nil
+}
+
+// Returns the next heap tuple, or nil at the end of the scan.  The tuple
+// is valid until the next call.
+func (scan *BitmapHeapScan) Next() (Tuple, error) {
+	for {
+		if scan.cPage == nil {
+			page := scan.iterator.Next()
+			if page == nil {
+				scan.releaseBuffer()
+				return nil, nil
+			}
+			// the heap may have been truncated since the bitmap was built
+			if page.Block >= scan.nBlocks {
+				continue
+			}
+			scan.releaseBuffer()
+			buf, err := scan.bufMgr.ReadBuffer(scan.rel.RelNode, page.Block)
+			if err != nil {
+				return nil, err
+			}
+			scan.cPage, scan.cBuf = page, buf
+			scan.next = 0
+			if page.Offsets == nil {
+				scan.next = system.FirstOffsetNumber
+			}
+		}
+
+		if tuple := scan.nextOnPage(); tuple != nil {
+			return tuple, nil
+		}
+		scan.cPage = nil
+	}
+}
+
+// Returns the next tuple of the current page that satisfies the keys, or
+// nil if there are no more.
+func (scan *BitmapHeapScan) nextOnPage() Tuple {
+	scan.cBuf.RLock()
+	defer scan.cBuf.RUnlock()
+
+	page := scan.cBuf.GetPage()
+	maxOff := page.MaxOffsetNumber()
+	lossy := scan.cPage.Offsets == nil
+	for {
+		var lineOff system.OffsetNumber
+		if lossy {
+			if scan.next > int(maxOff) {
+				return nil
+			}
+			lineOff = system.OffsetNumber(scan.next)
+		} else {
+			if scan.next >= len(scan.cPage.Offsets) {
+				return nil
+			}
+			lineOff = scan.cPage.Offsets[scan.next]
+		}
+		scan.next++
+
+		if lineOff < system.FirstOffsetNumber || lineOff > maxOff {
+			continue
+		}
+		itemId := page.ItemId(lineOff)
+		if !itemId.IsNormal() {
+			continue
+		}
+		tuple := scan.cTuple
+		tuple.SetData(page.Item(itemId), system.MakeItemPointer(scan.cPage.Block, lineOff))
+		if !HeapTupleSatisfiesVisibility(tuple) {
+			continue
+		}
+		if !scan.cPage.Recheck || HeapKeyTest(tuple, scan.ScanKeys) {
+			return tuple
+		}
+	}
+}
+
+func (scan *BitmapHeapScan) releaseBuffer() {
+	if scan.cBuf.IsValid() {
+		scan.bufMgr.ReleaseBuffer(scan.cBuf)
+		scan.cBuf = storage.InvalidBuffer()
+	}
+}
+
+// Releases the buffer the scan holds, if any.  The last tuple returned
+// by Next is no longer valid after this.
+func (scan *BitmapHeapScan) EndScan() error {
+	scan.releaseBuffer()
+	scan.cPage = nil
+	return nil
+}
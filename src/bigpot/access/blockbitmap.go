@@ -0,0 +1,72 @@
+package access
+
+import (
+	"bigpot/system"
+)
+
+// BlockBitmap is a set of heap block numbers, such as the blocks an index
+// found may hold matching tuples.
+type BlockBitmap struct {
+	words []uint64
+}
+
+func NewBlockBitmap() *BlockBitmap {
+	return &BlockBitmap{}
+}
+
+// Adds the block to the set.
+func (bitmap *BlockBitmap) Add(block system.BlockNumber) {
+	word := int(block / 64)
+	for len(bitmap.words) <= word {
+		bitmap.words = append(bitmap.words, 0)
+	}
+	bitmap.words[word] |= 1 << (block % 64)
+}
+
+// Adds the blocks from start up to, but not including, end.
+func (bitmap *BlockBitmap) AddRange(start, end system.BlockNumber) {
+	for block := start; block < end; block++ {
+		bitmap.Add(block)
+	}
+}
+
+// Returns true if the block is in the set.
+func (bitmap *BlockBitmap) Contains(block system.BlockNumber) bool {
+	word := int(block / 64)
+	if word >= len(bitmap.words) {
+		return false
+	}
+	return bitmap.words[word]&(1<<(block%64)) != 0
+}
+
+// Returns the smallest block in the set that is >= from.  The second
+// result is false if there is none.
+func (bitmap *BlockBitmap) NextMember(from system.BlockNumber) (system.BlockNumber, bool) {
+	for word := int(from / 64); word < len(bitmap.words); word++ {
+		bits := bitmap.words[word]
+		if word == int(from/64) {
+			bits &^= 1<<(from%64) - 1
+		}
+		if bits == 0 {
+			continue
+		}
+		block := system.BlockNumber(word * 64)
+		for bits&1 == 0 {
+			bits >>= 1
+			block++
+		}
+		return block, true
+	}
+	return system.InvalidBlockNumber, false
+}
+
+// Returns the number of blocks in the set.
+func (bitmap *BlockBitmap) Count() int {
+	count := 0
+	for _, bits := range bitmap.words {
+		for ; bits != 0; bits &= bits - 1 {
+			count++
+		}
+	}
+	return count
+}
@@ -0,0 +1,92 @@
+package access
+
+import (
+	"sort"
+
+	"bigpot/system"
+)
+
+// CatalogRow is a row of a system catalog.  Oid is InvalidOid for the rows
+// of the catalogs without oids.
+type CatalogRow struct {
+	Oid    system.Oid
+	Values []system.Datum
+}
+
+// Returns the rows each catalog starts with, by catalog relid, as the DATA
+// lines of postgres' catalog headers.  They describe the catalogs
+// themselves, the built-in types and functions, the index access methods,
+// the namespaces and the template database.
+func BootstrapRows() map[system.Oid][]CatalogRow {
+	rows := map[system.Oid][]CatalogRow{}
+	add := func(relid, oid system.Oid, values ...system.Datum) {
+		rows[relid] = append(rows[relid], CatalogRow{Oid: oid, Values: values})
+	}
+
+	add(NamespaceRelId, CatalogNamespaceId, system.Name("bp_catalog"), BootstrapSuperuserId)
+	add(NamespaceRelId, PublicNamespaceId, system.Name("public"), BootstrapSuperuserId)
+
+	add(DatabaseRelId, TemplateDbId, system.Name("template1"), BootstrapSuperuserId,
+		system.Bool(true), system.Bool(true), system.Oid(system.DefaultTableSpaceOid))
+
+	for _, am := range []struct {
+		oid  system.Oid
+		name string
+	}{{BTreeAmOid, "btree"}, {HashAmOid, "hash"}, {GinAmOid, "gin"}, {BrinAmOid, "brin"}} {
+		add(AmRelId, am.oid, system.Name(am.name), AmTypeIndex)
+	}
+
+	arrays := map[system.Oid]system.Oid{}
+	var typids []system.Oid
+	for typid, typ := range system.TypeRegistry {
+		typids = append(typids, typid)
+		if typ.Elem != system.InvalidOid {
+			arrays[typ.Elem] = typid
+		}
+	}
+	sortOids(typids)
+	for _, typid := range typids {
+		typ := system.TypeRegistry[typid]
+		byval := typ.Len == 1 || typ.Len == 2 || typ.Len == 4 || typ.Len == 8
+		add(TypeRelId, typid, typ.Name, CatalogNamespaceId, BootstrapSuperuserId,
+			system.Int4(typ.Len), system.Bool(byval), TypTypeBase, typ.Elem, arrays[typid])
+	}
+
+	var procids []system.Oid
+	for procid := range system.ProcRegistry {
+		procids = append(procids, procid)
+	}
+	sortOids(procids)
+	for _, procid := range procids {
+		proc := system.ProcRegistry[procid]
+		var argTypes []system.Datum
+		for _, typid := range proc.ArgTypes {
+			argTypes = append(argTypes, typid)
+		}
+		add(ProcRelId, procid, proc.Name, CatalogNamespaceId, BootstrapSuperuserId,
+			system.Bool(proc.Strict), system.Int4(len(proc.ArgTypes)), proc.RetType,
+			system.MakeArray(system.OidType, argTypes...), system.Text(proc.Name))
+	}
+
+	for _, catalog := range Catalogs {
+		var tablespace system.Oid
+		if catalog.Shared {
+			tablespace = system.GlobalTableSpaceOid
+		}
+		add(ClassRelId, catalog.RelId, catalog.Name, CatalogNamespaceId, system.InvalidOid,
+			BootstrapSuperuserId, system.InvalidOid, catalog.RelId, tablespace, system.Int4(0),
+			system.Float8(0), system.Bool(false), system.Bool(catalog.Shared), RelKindRelation,
+			system.Int4(len(catalog.Desc.Attrs)), system.Bool(catalog.Desc.hasOid))
+		for i, attr := range catalog.Desc.Attrs {
+			add(AttributeRelId, system.InvalidOid, catalog.RelId, attr.Name, attr.TypeId,
+				system.Int4(attr.Type.Len), system.Int4(i+1), system.Bool(attr.NotNull),
+				system.Bool(attr.HasDefault), system.Bool(attr.IsDropped), system.Bool(false),
+				system.Text(""))
+		}
+	}
+	return rows
+}
+
+func sortOids(oids []system.Oid) {
+	sort.Slice(oids, func(i, j int) bool { return oids[i] < oids[j] })
+}
@@ -0,0 +1,366 @@
+package access
+
+import (
+	"unsafe"
+
+	"bigpot/storage"
+	"bigpot/system"
+)
+
+// The BRIN access method, as postgres' block range indexes with the minmax
+// operator classes.  The heap is divided in ranges of pagesPerRange
+// blocks, and the index keeps a summary of each range: the minimum and
+// maximum values of every column, and whether it has NULLs.  The summaries
+// only tell which ranges may hold matching tuples, so BRIN indexes support
+// bitmap scans only.  They are tiny and cheap to maintain, and work well
+// for tables whose values follow the physical order, such as append-only
+// tables of events.
+//
+// Block 0 is the metapage.  The revmap pages map each range to the tid of
+// its summary tuple, which lives on a regular page.  Unlike postgres, where
+// the revmap follows the metapage and regular pages are moved away to make
+// room for it, revmap pages are allocated anywhere and the metapage keeps
+// their block numbers.  An insert holds the metapage lock exclusively
+// throughout, and a scan holds it shared.  Ranges that have no summary are
+// always returned by scans.
+type brin struct {
+	pagesPerRange system.BlockNumber
+}
+
+// The default number of heap blocks summarized together.
+const BrinDefaultPagesPerRange = 128
+
+// The BRIN IndexAm, with the default range size.
+var BrinAm IndexAm = brin{pagesPerRange: BrinDefaultPagesPerRange}
+
+// Returns the BRIN IndexAm summarizing ranges of pagesPerRange blocks, as
+// postgres' pages_per_range storage parameter.
+func NewBrinAm(pagesPerRange system.BlockNumber) IndexAm {
+	return brin{pagesPerRange: pagesPerRange}
+}
+
+// brinSpecialSpace is kept in the special space of every BRIN page, as
+// postgres' BrinSpecialSpace.
+type brinSpecialSpace struct {
+	pageType uint16
+}
+
+// brinSpecialSpace page types
+const (
+	brinPageTypeMeta    = 0xF091
+	brinPageTypeRevmap  = 0xF092
+	brinPageTypeRegular = 0xF093
+)
+
+// The number of revmap pages the metapage has room for.
+const brinMaxRevmapPages = 512
+
+// brinMetaPageData is kept in the contents of the metapage, as postgres'
+// BrinMetaPageData.
+type brinMetaPageData struct {
+	magic         uint32
+	version       uint32
+	pagesPerRange system.BlockNumber
+	// the regular page new summary tuples are added to
+	lastRegularPage system.BlockNumber
+	nRevmapPages    uint32
+	revmap          [brinMaxRevmapPages]system.BlockNumber
+}
+
+const (
+	brinMetaBlock = system.BlockNumber(0)
+	brinMagic     = 0xA8109CFA
+	brinVersion   = 1
+)
+
+var sizeOfBrinSpecialSpace = unsafe.Sizeof(brinSpecialSpace{})
+
+func brinSpecial(page *storage.Page) *brinSpecialSpace {
+	special := page.SpecialSpace()
+	return (*brinSpecialSpace)(unsafe.Pointer(&special[0]))
+}
+
+func brinMeta(page *storage.Page) *brinMetaPageData {
+	contents := page.Contents()
+	return (*brinMetaPageData)(unsafe.Pointer(&contents[0]))
+}
+
+// brinSummary is the summary of a block range, as postgres' BrinMemTuple.
+// min and max of a column are nil while allNulls is set, which it is
+// until a non-NULL value is added.
+type brinSummary struct {
+	min      []system.Datum
+	max      []system.Datum
+	hasNulls []bool
+	allNulls []bool
+}
+
+// Returns the summary of an empty range.
+func newBrinSummary(natts int) *brinSummary {
+	summary := &brinSummary{
+		min:      make([]system.Datum, natts),
+		max:      make([]system.Datum, natts),
+		hasNulls: make([]bool, natts),
+		allNulls: make([]bool, natts),
+	}
+	for i := range summary.allNulls {
+		summary.allNulls[i] = true
+	}
+	return summary
+}
+
+// Widens the summary to cover the values, as postgres' brin_minmax_add_value.
+// Returns true if the summary changed.
+func (summary *brinSummary) addValues(values []system.Datum, procs []system.CompareFunc) bool {
+	changed := false
+	for i, val := range values {
+		switch {
+		case val == nil:
+			if !summary.hasNulls[i] {
+				summary.hasNulls[i] = true
+				changed = true
+			}
+		case summary.allNulls[i]:
+			summary.min[i] = val
+			summary.max[i] = val
+			summary.allNulls[i] = false
+			changed = true
+		default:
+			if procs[i](val, summary.min[i]) < 0 {
+				summary.min[i] = val
+				changed = true
+			}
+			if procs[i](val, summary.max[i]) > 0 {
+				summary.max[i] = val
+				changed = true
+			}
+		}
+	}
+	return changed
+}
+
+// Returns true if the range may hold tuples satisfying the key, as
+// postgres' brin_minmax_consistent.
+func (summary *brinSummary) consistent(key *ScanKey) bool {
+	i := key.AttNum - 1
+	if key.Flags&SkSearchNull != 0 {
+		return summary.hasNulls[i] || summary.allNulls[i]
+	} else if key.Flags&SkSearchNotNull != 0 {
+		return !summary.allNulls[i]
+	} else if key.Flags&SkIsNull != 0 || summary.allNulls[i] {
+		// the operators are strict
+		return false
+	}
+
+	switch key.Strategy {
+	case BTLessStrategyNumber:
+		return key.Func(summary.min[i], key.Val) < 0
+	case BTLessEqualStrategyNumber:
+		return key.Func(summary.min[i], key.Val) <= 0
+	case BTEqualStrategyNumber:
+		return key.Func(summary.min[i], key.Val) <= 0 && key.Func(summary.max[i], key.Val) >= 0
+	case BTGreaterEqualStrategyNumber:
+		return key.Func(summary.max[i], key.Val) >= 0
+	case BTGreaterStrategyNumber:
+		return key.Func(summary.max[i], key.Val) > 0
+	}
+	return true
+}
+
+// Returns the descriptor of the summary tuples of the index: the minimum,
+// the maximum, and the NULL flags of each column, as postgres' BrinDesc.
+func brinSummaryDesc(index *IndexRelation) *TupleDesc {
+	tupdesc := &TupleDesc{}
+	for _, attr := range index.RelDesc.Attrs {
+		tupdesc.Attrs = append(tupdesc.Attrs,
+			&Attribute{Name: attr.Name + "_min", TypeId: attr.TypeId},
+			&Attribute{Name: attr.Name + "_max", TypeId: attr.TypeId},
+			&Attribute{Name: attr.Name + "_hasnulls", TypeId: system.BoolType},
+			&Attribute{Name: attr.Name + "_allnulls", TypeId: system.BoolType})
+	}
+	initTupleDesc(tupdesc)
+	return tupdesc
+}
+
+// Forms the summary tuple of the range starting at rangeStart, which is
+// kept in the tid of the tuple, as postgres' brin_form_tuple.
+func brinFormTuple(summary *brinSummary, rangeStart system.BlockNumber, tupdesc *TupleDesc) (IndexTuple, error) {
+	var values []system.Datum
+	for i := range summary.min {
+		values = append(values, summary.min[i], summary.max[i],
+			system.Bool(summary.hasNulls[i]), system.Bool(summary.allNulls[i]))
+	}
+	itup, err := FormIndexTuple(values, tupdesc)
+	if err != nil {
+		return nil, err
+	}
+	itup.SetTid(system.MakeItemPointer(rangeStart, system.InvalidOffsetNumber))
+	return itup, nil
+}
+
+// Extracts the summary from the tuple, as postgres' brin_deform_tuple.
+func brinDeformTuple(itup IndexTuple, tupdesc *TupleDesc) *brinSummary {
+	values := itup.Values(tupdesc)
+	summary := newBrinSummary(len(values) / 4)
+	for i := range summary.min {
+		summary.min[i] = values[i*4]
+		summary.max[i] = values[i*4+1]
+		summary.hasNulls[i] = bool(values[i*4+2].(system.Bool))
+		summary.allNulls[i] = bool(values[i*4+3].(system.Bool))
+	}
+	return summary
+}
+
+// Returns the comparison function of each index column.
+func brinGetProcs(index *IndexRelation) ([]system.CompareFunc, error) {
+	procs := make([]system.CompareFunc, len(index.RelDesc.Attrs))
+	for i, attr := range index.RelDesc.Attrs {
+		proc, err := system.LookupCompare(attr.TypeId, attr.TypeId)
+		if err != nil {
+			return nil, err
+		}
+		procs[i] = proc
+	}
+	return procs, nil
+}
+
+// Returns the first block of the range holding the heap block.
+func brinRangeStart(meta *brinMetaPageData, heapBlk system.BlockNumber) system.BlockNumber {
+	return heapBlk - heapBlk%meta.pagesPerRange
+}
+
+// Implements IndexAm.Build.  The heap scan returns the tuples in block
+// order, so each range is summarized in turn and stored once the scan
+// moves past it, as postgres' brinbuild.
+func (b brin) Build(heap *HeapRelation, index *IndexRelation, bufMgr storage.BufferManager) error {
+	procs, err := brinGetProcs(index)
+	if err != nil {
+		return err
+	}
+	if b.pagesPerRange == 0 {
+		return system.Ereport(system.InvalidParameterValue,
+			"value %d out of bounds for option \"pages_per_range\"", b.pagesPerRange)
+	}
+	if err := brinInitMetaPage(index, b.pagesPerRange, bufMgr); err != nil {
+		return err
+	}
+	metaBuf, meta, err := brinGetMeta(index, brinWrite, bufMgr)
+	if err != nil {
+		return err
+	}
+	defer brinRelBuf(bufMgr, metaBuf, brinWrite)
+	metaBuf.MarkDirty()
+
+	tupdesc := brinSummaryDesc(index)
+	var summary *brinSummary
+	var rangeStart system.BlockNumber
+	err = indexBuildHeapScan(heap, index, bufMgr,
+		func(values []system.Datum, tid system.ItemPointer) error {
+			start := brinRangeStart(meta, tid.BlockNumber())
+			if summary != nil && start != rangeStart {
+				err := brinPutSummary(index, meta, rangeStart, system.InvalidItemPointer,
+					summary, tupdesc, bufMgr)
+				if err != nil {
+					return err
+				}
+				summary = nil
+			}
+			if summary == nil {
+				summary = newBrinSummary(len(procs))
+				rangeStart = start
+			}
+			summary.addValues(values, procs)
+			return nil
+		})
+	if err == nil && summary != nil {
+		err = brinPutSummary(index, meta, rangeStart, system.InvalidItemPointer,
+			summary, tupdesc, bufMgr)
+	}
+	return err
+}
+
+// Implements IndexAm.Insert.  The summary of the range holding the heap
+// tuple is widened to cover the values, as postgres' brininsert.  BRIN
+// indexes can't be unique.
+func (b brin) Insert(index *IndexRelation, values []system.Datum, tid system.ItemPointer,
+	heap *HeapRelation, bufMgr storage.BufferManager) error {
+	if index.Unique {
+		return system.Ereport(system.FeatureNotSupported,
+			"access method \"brin\" does not support unique indexes")
+	}
+	procs, err := brinGetProcs(index)
+	if err != nil {
+		return err
+	}
+	metaBuf, meta, err := brinGetMeta(index, brinWrite, bufMgr)
+	if err != nil {
+		return err
+	}
+	defer brinRelBuf(bufMgr, metaBuf, brinWrite)
+
+	tupdesc := brinSummaryDesc(index)
+	rangeStart := brinRangeStart(meta, tid.BlockNumber())
+	summary, oldTid, err := brinGetSummary(index, meta, rangeStart, tupdesc, bufMgr)
+	if err != nil {
+		return err
+	}
+	if summary == nil {
+		summary = newBrinSummary(len(procs))
+	}
+	if !summary.addValues(values, procs) && oldTid.BlockNumber().IsValid() {
+		// the summary covers the values already
+		return nil
+	}
+	metaBuf.MarkDirty()
+	return brinPutSummary(index, meta, rangeStart, oldTid, summary, tupdesc, bufMgr)
+}
+
+// Implements IndexAm.BeginScan.  The summaries can't tell which tuples
+// match; use IndexGetBitmap instead.
+func (b brin) BeginScan(index *IndexRelation, keys []ScanKey, bufMgr storage.BufferManager) (IndexScanDesc, error) {
+	return nil, system.Ereport(system.FeatureNotSupported,
+		"access method \"brin\" supports only bitmap scans")
+}
+
+// Implements BitmapIndexAm.GetBitmap.  The pages of every range up to the
+// end of the heap whose summary is consistent with all the keys are added
+// lossy, as postgres' bringetbitmap.
+func (b brin) GetBitmap(index *IndexRelation, heap *HeapRelation, keys []ScanKey, tbm *TIDBitmap,
+	bufMgr storage.BufferManager) error {
+	nBlocks, err := heap.GetNumberOfBlocks()
+	if err != nil {
+		return err
+	}
+	metaBuf, meta, err := brinGetMeta(index, brinRead, bufMgr)
+	if err != nil {
+		return err
+	}
+	defer brinRelBuf(bufMgr, metaBuf, brinRead)
+
+	tupdesc := brinSummaryDesc(index)
+	for rangeStart := system.BlockNumber(0); rangeStart < nBlocks; rangeStart += meta.pagesPerRange {
+		summary, _, err := brinGetSummary(index, meta, rangeStart, tupdesc, bufMgr)
+		if err != nil {
+			return err
+		}
+		addRange := true
+		if summary != nil {
+			for i := range keys {
+				if !summary.consistent(&keys[i]) {
+					addRange = false
+					break
+				}
+			}
+		}
+		if addRange {
+			end := rangeStart + meta.pagesPerRange
+			if end > nBlocks {
+				end = nBlocks
+			}
+			for block := rangeStart; block < end; block++ {
+				tbm.AddPage(block)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,270 @@
+package access
+
+import (
+	"unsafe"
+
+	"bigpot/storage"
+	"bigpot/system"
+)
+
+// Lock modes of BRIN buffers.
+const (
+	brinRead  = 1
+	brinWrite = 2
+)
+
+// brinRevmapItem is a revmap slot, the tid of the summary tuple of a
+// range, or an invalid block if the range has none.
+type brinRevmapItem struct {
+	block  system.BlockNumber
+	offset system.OffsetNumber
+}
+
+var sizeOfBrinRevmapItem = unsafe.Sizeof(brinRevmapItem{})
+
+// The number of revmap slots on a revmap page.
+var revmapItemsPerPage system.BlockNumber
+
+func init() {
+	page := storage.NewPage(new(storage.Block))
+	page.Init(sizeOfBrinSpecialSpace)
+	revmapItemsPerPage = system.BlockNumber(
+		uintptr(page.Special()-page.ContentsOffset(0)) / sizeOfBrinRevmapItem)
+}
+
+func brinGetBuf(index *IndexRelation, blkno system.BlockNumber, access int,
+	bufMgr storage.BufferManager) (storage.Buffer, error) {
+	buf, err := bufMgr.ReadBuffer(index.RelNode, blkno)
+	if err != nil {
+		return storage.InvalidBuffer(), err
+	}
+	if access == brinWrite {
+		buf.Lock()
+	} else {
+		buf.RLock()
+	}
+	return buf, nil
+}
+
+func brinRelBuf(bufMgr storage.BufferManager, buf storage.Buffer, access int) {
+	if access == brinWrite {
+		buf.Unlock()
+	} else {
+		buf.RUnlock()
+	}
+	bufMgr.ReleaseBuffer(buf)
+}
+
+// Initializes a BRIN page of the type, as postgres' brin_page_init.
+func brinPageInit(page *storage.Page, pageType uint16) {
+	page.Init(sizeOfBrinSpecialSpace)
+	brinSpecial(page).pageType = pageType
+}
+
+func brinRevmapItemAt(page *storage.Page, slot system.BlockNumber) *brinRevmapItem {
+	contents := page.Contents()
+	return (*brinRevmapItem)(unsafe.Pointer(&contents[uintptr(slot)*sizeOfBrinRevmapItem]))
+}
+
+// Initializes the metapage of an empty index, as postgres'
+// brin_metapage_init.
+func brinInitMetaPage(index *IndexRelation, pagesPerRange system.BlockNumber,
+	bufMgr storage.BufferManager) error {
+	metaBuf, err := brinGetBuf(index, storage.NewBlock, brinWrite, bufMgr)
+	if err != nil {
+		return err
+	}
+	defer brinRelBuf(bufMgr, metaBuf, brinWrite)
+	if metaBuf.BlockNumber() != brinMetaBlock {
+		return system.Elog("index \"%s\" is not empty", index.RelName)
+	}
+
+	page := metaBuf.GetPage()
+	brinPageInit(page, brinPageTypeMeta)
+	meta := brinMeta(page)
+	meta.magic = brinMagic
+	meta.version = brinVersion
+	meta.pagesPerRange = pagesPerRange
+	meta.lastRegularPage = system.InvalidBlockNumber
+	meta.nRevmapPages = 0
+	for i := range meta.revmap {
+		meta.revmap[i] = system.InvalidBlockNumber
+	}
+	page.SetLower(page.ContentsOffset(unsafe.Sizeof(brinMetaPageData{})))
+	metaBuf.MarkDirty()
+	return nil
+}
+
+// Returns the metapage locked in the access mode.
+func brinGetMeta(index *IndexRelation, access int,
+	bufMgr storage.BufferManager) (storage.Buffer, *brinMetaPageData, error) {
+	buf, err := brinGetBuf(index, brinMetaBlock, access, bufMgr)
+	if err != nil {
+		return storage.InvalidBuffer(), nil, err
+	}
+	page := buf.GetPage()
+	meta := brinMeta(page)
+	if brinSpecial(page).pageType != brinPageTypeMeta || meta.magic != brinMagic {
+		brinRelBuf(bufMgr, buf, access)
+		return storage.InvalidBuffer(), nil, system.Elog("index \"%s\" is not a BRIN index", index.RelName)
+	}
+	if meta.version != brinVersion {
+		brinRelBuf(bufMgr, buf, access)
+		return storage.InvalidBuffer(), nil, system.Elog(
+			"index \"%s\" has wrong BRIN version", index.RelName)
+	}
+	return buf, meta, nil
+}
+
+// Returns the tid of the summary tuple of the range, or an invalid tid if
+// the range has no summary, as postgres' brinGetTupleForHeapBlock.
+func brinRevmapGet(index *IndexRelation, meta *brinMetaPageData, rangeStart system.BlockNumber,
+	bufMgr storage.BufferManager) (system.ItemPointer, error) {
+	rangeNo := rangeStart / meta.pagesPerRange
+	mapPage := rangeNo / revmapItemsPerPage
+	if mapPage >= system.BlockNumber(meta.nRevmapPages) {
+		return system.InvalidItemPointer, nil
+	}
+	buf, err := brinGetBuf(index, meta.revmap[mapPage], brinRead, bufMgr)
+	if err != nil {
+		return system.InvalidItemPointer, err
+	}
+	defer brinRelBuf(bufMgr, buf, brinRead)
+	item := brinRevmapItemAt(buf.GetPage(), rangeNo%revmapItemsPerPage)
+	if !item.block.IsValid() {
+		return system.InvalidItemPointer, nil
+	}
+	return system.MakeItemPointer(item.block, item.offset), nil
+}
+
+// Points the revmap slot of the range to the summary tuple, adding revmap
+// pages as needed, as postgres' brinSetHeapBlockItemptr.  The metapage
+// must be locked exclusively.
+func brinRevmapSet(index *IndexRelation, meta *brinMetaPageData, rangeStart system.BlockNumber,
+	tid system.ItemPointer, bufMgr storage.BufferManager) error {
+	rangeNo := rangeStart / meta.pagesPerRange
+	mapPage := rangeNo / revmapItemsPerPage
+	if mapPage >= brinMaxRevmapPages {
+		return system.Ereport(system.ProgramLimitExceeded,
+			"index \"%s\" has too many block ranges", index.RelName)
+	}
+	for system.BlockNumber(meta.nRevmapPages) <= mapPage {
+		buf, err := brinGetBuf(index, storage.NewBlock, brinWrite, bufMgr)
+		if err != nil {
+			return err
+		}
+		page := buf.GetPage()
+		brinPageInit(page, brinPageTypeRevmap)
+		for slot := system.BlockNumber(0); slot < revmapItemsPerPage; slot++ {
+			brinRevmapItemAt(page, slot).block = system.InvalidBlockNumber
+		}
+		page.SetLower(page.ContentsOffset(uintptr(revmapItemsPerPage) * sizeOfBrinRevmapItem))
+		meta.revmap[meta.nRevmapPages] = buf.BlockNumber()
+		meta.nRevmapPages++
+		buf.MarkDirty()
+		brinRelBuf(bufMgr, buf, brinWrite)
+	}
+
+	buf, err := brinGetBuf(index, meta.revmap[mapPage], brinWrite, bufMgr)
+	if err != nil {
+		return err
+	}
+	item := brinRevmapItemAt(buf.GetPage(), rangeNo%revmapItemsPerPage)
+	item.block = tid.BlockNumber()
+	item.offset = tid.OffsetNumber()
+	buf.MarkDirty()
+	brinRelBuf(bufMgr, buf, brinWrite)
+	return nil
+}
+
+// Returns the summary of the range and the tid of its tuple, or nil if
+// the range has no summary.
+func brinGetSummary(index *IndexRelation, meta *brinMetaPageData, rangeStart system.BlockNumber,
+	tupdesc *TupleDesc, bufMgr storage.BufferManager) (*brinSummary, system.ItemPointer, error) {
+	tid, err := brinRevmapGet(index, meta, rangeStart, bufMgr)
+	if err != nil || tid == system.InvalidItemPointer {
+		return nil, system.InvalidItemPointer, err
+	}
+	buf, err := brinGetBuf(index, tid.BlockNumber(), brinRead, bufMgr)
+	if err != nil {
+		return nil, system.InvalidItemPointer, err
+	}
+	defer brinRelBuf(bufMgr, buf, brinRead)
+
+	page := buf.GetPage()
+	if brinSpecial(page).pageType != brinPageTypeRegular || tid.OffsetNumber() > page.MaxOffsetNumber() {
+		return nil, system.InvalidItemPointer, system.Elog(
+			"corrupted BRIN index \"%s\": no summary tuple at %s", index.RelName, tid.ToString())
+	}
+	itemId := page.ItemId(tid.OffsetNumber())
+	itup := IndexTuple(page.Item(itemId))
+	if !itemId.IsNormal() || itup.Tid().BlockNumber() != rangeStart {
+		return nil, system.InvalidItemPointer, system.Elog(
+			"corrupted BRIN index \"%s\": no summary tuple at %s", index.RelName, tid.ToString())
+	}
+	return brinDeformTuple(itup, tupdesc), tid, nil
+}
+
+// Stores the summary of the range, replacing the tuple at oldTid if it's
+// valid, as postgres' brin_doupdate and brin_doinsert.  A tuple of the
+// same size is overwritten in place.  Otherwise the old tuple is removed
+// and the new one added to the last regular page, or a new one if it's
+// full.  The metapage must be locked exclusively.
+func brinPutSummary(index *IndexRelation, meta *brinMetaPageData, rangeStart system.BlockNumber,
+	oldTid system.ItemPointer, summary *brinSummary, tupdesc *TupleDesc,
+	bufMgr storage.BufferManager) error {
+	itup, err := brinFormTuple(summary, rangeStart, tupdesc)
+	if err != nil {
+		return err
+	}
+
+	if oldTid.BlockNumber().IsValid() {
+		buf, err := brinGetBuf(index, oldTid.BlockNumber(), brinWrite, bufMgr)
+		if err != nil {
+			return err
+		}
+		page := buf.GetPage()
+		itemId := page.ItemId(oldTid.OffsetNumber())
+		if int(itemId.Length()) == len(itup) {
+			copy(page.Item(itemId), itup)
+			buf.MarkDirty()
+			brinRelBuf(bufMgr, buf, brinWrite)
+			return nil
+		}
+		// The space isn't reclaimed, but the line pointer is reused.
+		itemId.SetUnused()
+		page.SetHasFreeLinePointers()
+		buf.MarkDirty()
+		brinRelBuf(bufMgr, buf, brinWrite)
+	}
+
+	buf := storage.InvalidBuffer()
+	itemSize := uint(system.MaxAlign(uintptr(len(itup))))
+	if meta.lastRegularPage.IsValid() {
+		buf, err = brinGetBuf(index, meta.lastRegularPage, brinWrite, bufMgr)
+		if err != nil {
+			return err
+		}
+		if buf.GetPage().FreeSpace() < itemSize {
+			brinRelBuf(bufMgr, buf, brinWrite)
+			buf = storage.InvalidBuffer()
+		}
+	}
+	if !buf.IsValid() {
+		buf, err = brinGetBuf(index, storage.NewBlock, brinWrite, bufMgr)
+		if err != nil {
+			return err
+		}
+		brinPageInit(buf.GetPage(), brinPageTypeRegular)
+		meta.lastRegularPage = buf.BlockNumber()
+	}
+
+	offset := buf.GetPage().AddItem(itup, system.InvalidOffsetNumber, false, false)
+	blkno := buf.BlockNumber()
+	buf.MarkDirty()
+	brinRelBuf(bufMgr, buf, brinWrite)
+	if offset == system.InvalidOffsetNumber {
+		return system.Elog("failed to add BRIN tuple to \"%s\"", index.RelName)
+	}
+	return brinRevmapSet(index, meta, rangeStart, system.MakeItemPointer(blkno, offset), bufMgr)
+}
@@ -0,0 +1,106 @@
+package access
+
+import (
+	"fmt"
+	. "launchpad.net/gocheck"
+	"os"
+
+	"bigpot/system"
+)
+
+func (s *MySuite) TestBlockBitmap(c *C) {
+	bitmap := NewBlockBitmap()
+	_, ok := bitmap.NextMember(0)
+	c.Check(ok, Equals, false)
+
+	bitmap.Add(3)
+	bitmap.AddRange(62, 130)
+	bitmap.Add(1000)
+	c.Check(bitmap.Count(), Equals, 70)
+	c.Check(bitmap.Contains(3), Equals, true)
+	c.Check(bitmap.Contains(4), Equals, false)
+	c.Check(bitmap.Contains(129), Equals, true)
+	c.Check(bitmap.Contains(130), Equals, false)
+	c.Check(bitmap.Contains(5000), Equals, false)
+
+	var members []system.BlockNumber
+	for block, ok := bitmap.NextMember(0); ok; block, ok = bitmap.NextMember(block + 1) {
+		members = append(members, block)
+	}
+	c.Check(members, HasLen, 70)
+	c.Check(members[0], Equals, system.BlockNumber(3))
+	c.Check(members[1], Equals, system.BlockNumber(62))
+	c.Check(members[68], Equals, system.BlockNumber(129))
+	c.Check(members[69], Equals, system.BlockNumber(1000))
+}
+
+func (s *MySuite) TestBrinBuildAndScan(c *C) {
+	os.MkdirAll("base/1", 0700)
+	defer os.RemoveAll("base")
+	defer os.RemoveAll("global")
+	bufMgr := newBufferManager(c, 64)
+
+	// ids in the physical order, and a NULL now and then
+	const nrows = 5000
+	var rows [][]system.Datum
+	for i := 0; i < nrows; i++ {
+		id := system.Datum(system.Int4(i))
+		if i%1000 == 999 {
+			id = nil
+		}
+		rows = append(rows, []system.Datum{id, system.Name(fmt.Sprintf("name%05d", i))})
+	}
+	heap := makeTestHeap(c, bufMgr, 20000, rows)
+	nBlocks, err := heap.GetNumberOfBlocks()
+	c.Assert(err, IsNil)
+	c.Assert(nBlocks > 20, Equals, true)
+	index := makeTestIndex(c, heap, 20001, 1, false)
+	index.Am = NewBrinAm(2)
+	c.Assert(index.Build(heap, bufMgr), IsNil)
+
+	key := func(strategy StrategyNumber, id int) ScanKey {
+		key, err := MakeScanKey(1, strategy, system.Int4Type, system.Int4Type, system.Int4(int32(id)))
+		c.Assert(err, IsNil)
+		return key
+	}
+	// the pages are all lossy
+	lookup := func(keys ...ScanKey) (*BlockBitmap, []system.Datum) {
+		tbm := NewTIDBitmap(0)
+		c.Assert(IndexGetBitmap(heap, index, keys, tbm, bufMgr), IsNil)
+		c.Assert(tbm.pages, HasLen, 0)
+		return tbm.lossy, bitmapScanAll(c, heap, tbm, keys, bufMgr)
+	}
+
+	// an equality key finds one range
+	blocks, ids := lookup(key(BTEqualStrategyNumber, 2500))
+	c.Check(blocks.Count(), Equals, 2)
+	c.Check(ids, DeepEquals, []system.Datum{system.Int4(2500)})
+
+	blocks, ids = lookup(key(BTGreaterEqualStrategyNumber, 4990))
+	c.Check(blocks.Count() <= 2, Equals, true)
+	c.Check(ids, HasLen, 9)
+	blocks, ids = lookup(key(BTLessStrategyNumber, 10), key(BTGreaterStrategyNumber, 5))
+	c.Check(blocks.Count(), Equals, 2)
+	c.Check(ids, HasLen, 4)
+	blocks, _ = lookup(key(BTGreaterStrategyNumber, nrows))
+	c.Check(blocks.Count(), Equals, 0)
+
+	// the ranges with NULLs, and every one without a key
+	blocks, ids = lookup(MakeNullScanKey(1, true))
+	c.Check(blocks.Count(), Equals, 10)
+	c.Check(ids, HasLen, 5)
+	blocks, _ = lookup()
+	c.Check(blocks.Count(), Equals, int(nBlocks))
+
+	// an insert widens the summary of the range
+	tid := system.MakeItemPointer(nBlocks-1, 1)
+	c.Assert(index.Insert([]system.Datum{system.Int4(-1)}, tid, heap, bufMgr), IsNil)
+	blocks, _ = lookup(key(BTLessStrategyNumber, 0))
+	c.Check(blocks.Count(), Equals, 2)
+	c.Check(blocks.Contains(nBlocks-1), Equals, true)
+
+	_, err = IndexBeginScan(heap, index, nil, bufMgr)
+	c.Check(err, ErrorMatches, "access method \"brin\" supports only bitmap scans")
+	err = IndexGetBitmap(heap, makeTestIndex(c, heap, 20002, 2, false), nil, NewTIDBitmap(0), bufMgr)
+	c.Check(err, ErrorMatches, "index \"t_name_idx\" does not support bitmap scans")
+}
@@ -1,61 +1,558 @@
 package access
 
 import (
+	"os"
+	"sort"
+
+	"bigpot/storage"
 	"bigpot/system"
 )
 
-var ClassRelId system.Oid = 1259
-var ClassTupleDesc = &TupleDesc{
-	Attrs: []*Attribute{
-		{
-			Name:   "relname",
-			TypeId: system.NameType,
-		},
-		{
-			Name:   "relfilenode",
-			TypeId: system.OidType,
-		},
-	},
-	typid:  ClassRelId,
-	hasOid: true,
+// The system catalogs, as postgres' catalog headers.  Their TupleDescs
+// are fixed here, so that they can be read before anything else is known,
+// and the catalogs describe themselves as well as every other relation.
+
+// Oids of the objects made at bootstrap, as postgres' fixed oids.
+const (
+	BootstrapSuperuserId system.Oid = 10
+	CatalogNamespaceId   system.Oid = 11
+	PublicNamespaceId    system.Oid = 2200
+	TemplateDbId         system.Oid = 1
+)
+
+// Values of bp_class.relkind.
+const (
+	RelKindRelation = system.Char('r')
+	RelKindIndex    = system.Char('i')
+)
+
+// Values of bp_type.typtype.
+const (
+	TypTypeBase = system.Char('b')
+)
+
+// Values of bp_am.amtype.
+const (
+	AmTypeIndex = system.Char('i')
+)
+
+// Oids of the index access methods in bp_am.
+const (
+	BTreeAmOid system.Oid = 403
+	HashAmOid  system.Oid = 405
+	GinAmOid   system.Oid = 2742
+	BrinAmOid  system.Oid = 3580
+)
+
+// CatalogInfo describes a system catalog.  A shared catalog is one for the
+// whole cluster, living in the global tablespace.
+type CatalogInfo struct {
+	RelId  system.Oid
+	Name   system.Name
+	Shared bool
+	Desc   *TupleDesc
+}
+
+// Makes the TupleDesc of a catalog.  Catalog columns are all NOT NULL.
+func catalogDesc(relid system.Oid, hasOid bool, attrs ...*Attribute) *TupleDesc {
+	for _, attr := range attrs {
+		attr.NotNull = true
+	}
+	return &TupleDesc{
+		Attrs:  attrs,
+		typid:  relid,
+		hasOid: hasOid,
+	}
 }
 
+var TypeRelId system.Oid = 1247
+var TypeTupleDesc = catalogDesc(TypeRelId, true,
+	&Attribute{Name: "typname", TypeId: system.NameType},
+	&Attribute{Name: "typnamespace", TypeId: system.OidType},
+	&Attribute{Name: "typowner", TypeId: system.OidType},
+	&Attribute{Name: "typlen", TypeId: system.Int4Type},
+	&Attribute{Name: "typbyval", TypeId: system.BoolType},
+	&Attribute{Name: "typtype", TypeId: system.CharType},
+	&Attribute{Name: "typelem", TypeId: system.OidType},
+	&Attribute{Name: "typarray", TypeId: system.OidType},
+)
+
 const (
-	Anum_class_relname     = 1
-	Anum_clasS_relfilenode = 2
+	Anum_type_typname      = 1
+	Anum_type_typnamespace = 2
+	Anum_type_typowner     = 3
+	Anum_type_typlen       = 4
+	Anum_type_typbyval     = 5
+	Anum_type_typtype      = 6
+	Anum_type_typelem      = 7
+	Anum_type_typarray     = 8
 )
 
 var AttributeRelId system.Oid = 1249
-var AttributeTupleDesc = &TupleDesc{
-	Attrs: []*Attribute{
-		{
-			Name:   "attrelid",
-			TypeId: system.OidType,
-		},
-		{
-			Name:   "attname",
-			TypeId: system.NameType,
-		},
-		{
-			Name:   "attnum",
-			TypeId: system.Int4Type,
-		},
-		{
-			Name:   "atttypid",
-			TypeId: system.OidType,
-		},
-	},
-	typid:  AttributeRelId,
-	hasOid: false,
-}
+var AttributeTupleDesc = catalogDesc(AttributeRelId, false,
+	&Attribute{Name: "attrelid", TypeId: system.OidType},
+	&Attribute{Name: "attname", TypeId: system.NameType},
+	&Attribute{Name: "atttypid", TypeId: system.OidType},
+	&Attribute{Name: "attlen", TypeId: system.Int4Type},
+	&Attribute{Name: "attnum", TypeId: system.Int4Type},
+	&Attribute{Name: "attnotnull", TypeId: system.BoolType},
+	&Attribute{Name: "atthasdef", TypeId: system.BoolType},
+	&Attribute{Name: "attisdropped", TypeId: system.BoolType},
+	&Attribute{Name: "atthasmissing", TypeId: system.BoolType},
+	&Attribute{Name: "attmissingval", TypeId: system.TextType},
+)
+
+const (
+	Anum_attribute_attrelid      = 1
+	Anum_attribute_attname       = 2
+	Anum_attribute_atttypid      = 3
+	Anum_attribute_attlen        = 4
+	Anum_attribute_attnum        = 5
+	Anum_attribute_attnotnull    = 6
+	Anum_attribute_atthasdef     = 7
+	Anum_attribute_attisdropped  = 8
+	Anum_attribute_atthasmissing = 9
+	Anum_attribute_attmissingval = 10
+)
+
+var ProcRelId system.Oid = 1255
+var ProcTupleDesc = catalogDesc(ProcRelId, true,
+	&Attribute{Name: "proname", TypeId: system.NameType},
+	&Attribute{Name: "pronamespace", TypeId: system.OidType},
+	&Attribute{Name: "proowner", TypeId: system.OidType},
+	&Attribute{Name: "proisstrict", TypeId: system.BoolType},
+	&Attribute{Name: "pronargs", TypeId: system.Int4Type},
+	&Attribute{Name: "prorettype", TypeId: system.OidType},
+	&Attribute{Name: "proargtypes", TypeId: system.OidArrayType},
+	&Attribute{Name: "prosrc", TypeId: system.TextType},
+)
+
+const (
+	Anum_proc_proname      = 1
+	Anum_proc_pronamespace = 2
+	Anum_proc_proowner     = 3
+	Anum_proc_proisstrict  = 4
+	Anum_proc_pronargs     = 5
+	Anum_proc_prorettype   = 6
+	Anum_proc_proargtypes  = 7
+	Anum_proc_prosrc       = 8
+)
+
+var ClassRelId system.Oid = 1259
+var ClassTupleDesc = catalogDesc(ClassRelId, true,
+	&Attribute{Name: "relname", TypeId: system.NameType},
+	&Attribute{Name: "relnamespace", TypeId: system.OidType},
+	&Attribute{Name: "reltype", TypeId: system.OidType},
+	&Attribute{Name: "relowner", TypeId: system.OidType},
+	&Attribute{Name: "relam", TypeId: system.OidType},
+	&Attribute{Name: "relfilenode", TypeId: system.OidType},
+	&Attribute{Name: "reltablespace", TypeId: system.OidType},
+	&Attribute{Name: "relpages", TypeId: system.Int4Type},
+	&Attribute{Name: "reltuples", TypeId: system.Float8Type},
+	&Attribute{Name: "relhasindex", TypeId: system.BoolType},
+	&Attribute{Name: "relisshared", TypeId: system.BoolType},
+	&Attribute{Name: "relkind", TypeId: system.CharType},
+	&Attribute{Name: "relnatts", TypeId: system.Int4Type},
+	&Attribute{Name: "relhasoids", TypeId: system.BoolType},
+)
+
+const (
+	Anum_class_relname       = 1
+	Anum_class_relnamespace  = 2
+	Anum_class_reltype       = 3
+	Anum_class_relowner      = 4
+	Anum_class_relam         = 5
+	Anum_class_relfilenode   = 6
+	Anum_class_reltablespace = 7
+	Anum_class_relpages      = 8
+	Anum_class_reltuples     = 9
+	Anum_class_relhasindex   = 10
+	Anum_class_relisshared   = 11
+	Anum_class_relkind       = 12
+	Anum_class_relnatts      = 13
+	Anum_class_relhasoids    = 14
+)
+
+var DatabaseRelId system.Oid = 1262
+var DatabaseTupleDesc = catalogDesc(DatabaseRelId, true,
+	&Attribute{Name: "datname", TypeId: system.NameType},
+	&Attribute{Name: "datdba", TypeId: system.OidType},
+	&Attribute{Name: "datistemplate", TypeId: system.BoolType},
+	&Attribute{Name: "datallowconn", TypeId: system.BoolType},
+	&Attribute{Name: "dattablespace", TypeId: system.OidType},
+)
+
+const (
+	Anum_database_datname       = 1
+	Anum_database_datdba        = 2
+	Anum_database_datistemplate = 3
+	Anum_database_datallowconn  = 4
+	Anum_database_dattablespace = 5
+)
+
+var AmRelId system.Oid = 2601
+var AmTupleDesc = catalogDesc(AmRelId, true,
+	&Attribute{Name: "amname", TypeId: system.NameType},
+	&Attribute{Name: "amtype", TypeId: system.CharType},
+)
+
+const (
+	Anum_am_amname = 1
+	Anum_am_amtype = 2
+)
+
+var AttrDefaultRelId system.Oid = 2604
+var AttrDefaultTupleDesc = catalogDesc(AttrDefaultRelId, true,
+	&Attribute{Name: "adrelid", TypeId: system.OidType},
+	&Attribute{Name: "adnum", TypeId: system.Int4Type},
+	&Attribute{Name: "adsrc", TypeId: system.TextType},
+)
 
 const (
-	Anum_attribute_attrelid = 1
-	Anum_attribute_attname  = 2
-	Anum_attribute_attnum   = 3
-	Anum_attribute_atttypid = 4
+	Anum_attrdef_adrelid = 1
+	Anum_attrdef_adnum   = 2
+	Anum_attrdef_adsrc   = 3
 )
 
+var IndexRelId system.Oid = 2610
+var IndexTupleDesc = catalogDesc(IndexRelId, false,
+	&Attribute{Name: "indexrelid", TypeId: system.OidType},
+	&Attribute{Name: "indrelid", TypeId: system.OidType},
+	&Attribute{Name: "indnatts", TypeId: system.Int4Type},
+	&Attribute{Name: "indisunique", TypeId: system.BoolType},
+	&Attribute{Name: "indisprimary", TypeId: system.BoolType},
+	&Attribute{Name: "indkey", TypeId: system.Int4ArrayType},
+)
+
+const (
+	Anum_index_indexrelid   = 1
+	Anum_index_indrelid     = 2
+	Anum_index_indnatts     = 3
+	Anum_index_indisunique  = 4
+	Anum_index_indisprimary = 5
+	Anum_index_indkey       = 6
+)
+
+var NamespaceRelId system.Oid = 2615
+var NamespaceTupleDesc = catalogDesc(NamespaceRelId, true,
+	&Attribute{Name: "nspname", TypeId: system.NameType},
+	&Attribute{Name: "nspowner", TypeId: system.OidType},
+)
+
+const (
+	Anum_namespace_nspname  = 1
+	Anum_namespace_nspowner = 2
+)
+
+// Catalogs lists the system catalogs in the order of their oids.
+var Catalogs = []*CatalogInfo{
+	{RelId: TypeRelId, Name: "bp_type", Desc: TypeTupleDesc},
+	{RelId: AttributeRelId, Name: "bp_attribute", Desc: AttributeTupleDesc},
+	{RelId: ProcRelId, Name: "bp_proc", Desc: ProcTupleDesc},
+	{RelId: ClassRelId, Name: "bp_class", Desc: ClassTupleDesc},
+	{RelId: DatabaseRelId, Name: "bp_database", Shared: true, Desc: DatabaseTupleDesc},
+	{RelId: AmRelId, Name: "bp_am", Desc: AmTupleDesc},
+	{RelId: AttrDefaultRelId, Name: "bp_attrdef", Desc: AttrDefaultTupleDesc},
+	{RelId: IndexRelId, Name: "bp_index", Desc: IndexTupleDesc},
+	{RelId: NamespaceRelId, Name: "bp_namespace", Desc: NamespaceTupleDesc},
+}
+
+var catalogsByRelId = map[system.Oid]*CatalogInfo{}
+
+// Returns the catalog relid, or nil if relid is not a system catalog.
+func LookupCatalog(relid system.Oid) *CatalogInfo {
+	return catalogsByRelId[relid]
+}
+
+// The index access methods by their bp_am oid.
+var indexAms = map[system.Oid]IndexAm{
+	BTreeAmOid: BTreeAm,
+	HashAmOid:  HashAm,
+	GinAmOid:   GinAm,
+	BrinAmOid:  BrinAm,
+}
+
+// ClassForm is a row of bp_class, as postgres' Form_pg_class.
+type ClassForm struct {
+	RelName       system.Name
+	RelNamespace  system.Oid
+	RelType       system.Oid
+	RelOwner      system.Oid
+	RelAm         system.Oid
+	RelFileNode   system.Oid
+	RelTablespace system.Oid
+	RelPages      int32
+	RelTuples     float64
+	RelHasIndex   bool
+	RelIsShared   bool
+	RelKind       system.Char
+	RelNatts      int
+	RelHasOids    bool
+}
+
+func classFormFromTuple(tuple Tuple) *ClassForm {
+	return &ClassForm{
+		RelName:       tuple.Fetch(Anum_class_relname).(system.Name),
+		RelNamespace:  tuple.Fetch(Anum_class_relnamespace).(system.Oid),
+		RelType:       tuple.Fetch(Anum_class_reltype).(system.Oid),
+		RelOwner:      tuple.Fetch(Anum_class_relowner).(system.Oid),
+		RelAm:         tuple.Fetch(Anum_class_relam).(system.Oid),
+		RelFileNode:   tuple.Fetch(Anum_class_relfilenode).(system.Oid),
+		RelTablespace: tuple.Fetch(Anum_class_reltablespace).(system.Oid),
+		RelPages:      int32(tuple.Fetch(Anum_class_relpages).(system.Int4)),
+		RelTuples:     float64(tuple.Fetch(Anum_class_reltuples).(system.Float8)),
+		RelHasIndex:   bool(tuple.Fetch(Anum_class_relhasindex).(system.Bool)),
+		RelIsShared:   bool(tuple.Fetch(Anum_class_relisshared).(system.Bool)),
+		RelKind:       tuple.Fetch(Anum_class_relkind).(system.Char),
+		RelNatts:      int(tuple.Fetch(Anum_class_relnatts).(system.Int4)),
+		RelHasOids:    bool(tuple.Fetch(Anum_class_relhasoids).(system.Bool)),
+	}
+}
+
+// Returns the file node of the relation relid of the database dbid that
+// the row describes.
+func (form *ClassForm) relFileNode(relid, dbid system.Oid) system.RelFileNode {
+	node := system.RelFileNode{
+		Dbid:  dbid,
+		Tsid:  form.RelTablespace,
+		Relid: form.RelFileNode,
+	}
+	if form.RelIsShared {
+		node.Dbid = system.InvalidOid
+	}
+	if node.Tsid == system.InvalidOid {
+		node.Tsid = system.DefaultTableSpaceOid
+	}
+	if node.Relid == system.InvalidOid {
+		node.Relid = relid
+	}
+	return node
+}
+
+// Calls fn with each tuple of the catalog that satisfies the keys, as
+// postgres' systable scans.  The tuple is only valid during the call.
+func scanCatalog(relid, dbid system.Oid, keys []ScanKey, bufMgr storage.BufferManager,
+	fn func(tuple Tuple) error) error {
+	rel, err := HeapOpen(relid, dbid, bufMgr)
+	if err != nil {
+		return err
+	}
+	defer rel.Close()
+	scan, err := rel.BeginScan(keys, bufMgr)
+	if err != nil {
+		return err
+	}
+	defer scan.EndScan()
+	for {
+		tuple, err := scan.Next()
+		if err != nil {
+			return err
+		} else if tuple == nil {
+			return nil
+		}
+		if err := fn(tuple); err != nil {
+			return err
+		}
+	}
+}
+
+// Returns a key for attnum = oid.
+func oidScanKey(attnum system.AttrNumber, oid system.Oid) ScanKey {
+	key, err := MakeScanKey(attnum, BTEqualStrategyNumber, system.OidType, system.OidType, oid)
+	if err != nil {
+		panic(err)
+	}
+	return key
+}
+
+// Returns a key for attnum = name.
+func nameScanKey(attnum system.AttrNumber, name system.Name) ScanKey {
+	key, err := MakeScanKey(attnum, BTEqualStrategyNumber, system.NameType, system.NameType, name)
+	if err != nil {
+		panic(err)
+	}
+	return key
+}
+
+// Returns a key for attnum = value.
+func int4ScanKey(attnum system.AttrNumber, value system.Int4) ScanKey {
+	key, err := MakeScanKey(attnum, BTEqualStrategyNumber, system.Int4Type, system.Int4Type, value)
+	if err != nil {
+		panic(err)
+	}
+	return key
+}
+
+// Reads the bp_class row of the relation relid of the database dbid.
+func readClassForm(relid, dbid system.Oid, bufMgr storage.BufferManager) (*ClassForm, error) {
+	var form *ClassForm
+	keys := []ScanKey{oidScanKey(system.OidAttrNumber, relid)}
+	err := scanCatalog(ClassRelId, dbid, keys, bufMgr, func(tuple Tuple) error {
+		form = classFormFromTuple(tuple)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	} else if form == nil {
+		return nil, system.Elog("could not open relation with OID %d", relid)
+	}
+	return form, nil
+}
+
+// Builds the TupleDesc of the relation relid from its bp_attribute and
+// bp_attrdef rows, as postgres' RelationBuildTupleDesc.  Dropped columns
+// stay in the TupleDesc, as the tuples written before the drop still hold
+// them.
+func buildTupleDesc(relid system.Oid, form *ClassForm, dbid system.Oid,
+	bufMgr storage.BufferManager) (*TupleDesc, error) {
+	attrs := make([]*Attribute, form.RelNatts)
+	hasDefault := false
+	keys := []ScanKey{oidScanKey(Anum_attribute_attrelid, relid)}
+	err := scanCatalog(AttributeRelId, dbid, keys, bufMgr, func(tuple Tuple) error {
+		attnum := int(tuple.Fetch(Anum_attribute_attnum).(system.Int4))
+		if attnum <= 0 {
+			return nil
+		} else if attnum > len(attrs) {
+			return system.Elog("invalid attribute number %d for %s", attnum, form.RelName)
+		}
+		typid := tuple.Fetch(Anum_attribute_atttypid).(system.Oid)
+		typ, ok := system.TypeRegistry[typid]
+		if !ok {
+			return system.Elog("cache lookup failed for type %d", typid)
+		}
+		attrs[attnum-1] = &Attribute{
+			Name:       tuple.Fetch(Anum_attribute_attname).(system.Name),
+			TypeId:     typid,
+			Type:       typ,
+			NotNull:    bool(tuple.Fetch(Anum_attribute_attnotnull).(system.Bool)),
+			HasDefault: bool(tuple.Fetch(Anum_attribute_atthasdef).(system.Bool)),
+			IsDropped:  bool(tuple.Fetch(Anum_attribute_attisdropped).(system.Bool)),
+		}
+		if bool(tuple.Fetch(Anum_attribute_atthasmissing).(system.Bool)) {
+			missing := string(tuple.Fetch(Anum_attribute_attmissingval).(system.Text))
+			value, err := system.DatumFromString(missing, typid)
+			if err != nil {
+				return err
+			}
+			attrs[attnum-1].Missing = value
+		}
+		hasDefault = hasDefault || attrs[attnum-1].HasDefault
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for i, attr := range attrs {
+		if attr == nil {
+			return nil, system.Elog("catalog is missing attribute %d for relid %d", i+1, relid)
+		}
+	}
+	tupdesc := &TupleDesc{
+		Attrs:  attrs,
+		typid:  form.RelType,
+		hasOid: form.RelHasOids,
+	}
+	if !hasDefault {
+		return tupdesc, nil
+	}
+
+	tupdesc.Constr = &TupleConstr{}
+	keys = []ScanKey{oidScanKey(Anum_attrdef_adrelid, relid)}
+	err = scanCatalog(AttrDefaultRelId, dbid, keys, bufMgr, func(tuple Tuple) error {
+		tupdesc.Constr.Defaults = append(tupdesc.Constr.Defaults, AttrDefault{
+			AttNum: system.AttrNumber(tuple.Fetch(Anum_attrdef_adnum).(system.Int4)),
+			Src:    string(tuple.Fetch(Anum_attrdef_adsrc).(system.Text)),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(tupdesc.Constr.Defaults, func(i, j int) bool {
+		return tupdesc.Constr.Defaults[i].AttNum < tupdesc.Constr.Defaults[j].AttNum
+	})
+	return tupdesc, nil
+}
+
+// Forms a tuple of the catalog relid, setting its oid if the catalog has
+// oids.
+func FormCatalogTuple(relid system.Oid, oid system.Oid, values []system.Datum) *HeapTuple {
+	tuple := FormHeapTuple(values, catalogsByRelId[relid].Desc)
+	if oid != system.InvalidOid {
+		tuple.SetOid(oid)
+	}
+	return tuple
+}
+
+// Returns the oid of the database dbname, as postgres' get_database_oid.
+// As bp_database is shared, it is read before any database is chosen,
+// as postgres' GetDatabaseTuple does at the start of a session.
+func GetDatabaseOid(dbname system.Name, bufMgr storage.BufferManager) (system.Oid, error) {
+	dbid := system.InvalidOid
+	keys := []ScanKey{nameScanKey(Anum_database_datname, dbname)}
+	err := scanCatalog(DatabaseRelId, system.InvalidOid, keys, bufMgr, func(tuple Tuple) error {
+		dbid = tuple.Fetch(system.OidAttrNumber).(system.Oid)
+		return nil
+	})
+	if err != nil {
+		return system.InvalidOid, err
+	} else if dbid == system.InvalidOid {
+		return system.InvalidOid, system.Ereport(system.InvalidCatalogName, "database \"%s\" does not exist", dbname)
+	}
+	return dbid, nil
+}
+
+// Returns a new oid not used by any row of the catalog relid of the
+// database dbid, as postgres' GetNewOidWithIndex.
+func GetNewOid(vars *TransamVariables, relid, dbid system.Oid, bufMgr storage.BufferManager) (system.Oid, error) {
+	for {
+		oid, err := vars.GetNewObjectId()
+		if err != nil {
+			return system.InvalidOid, err
+		}
+		found := false
+		keys := []ScanKey{oidScanKey(system.OidAttrNumber, oid)}
+		err = scanCatalog(relid, dbid, keys, bufMgr, func(tuple Tuple) error {
+			found = true
+			return nil
+		})
+		if err != nil {
+			return system.InvalidOid, err
+		} else if !found {
+			return oid, nil
+		}
+	}
+}
+
+// Returns a new file node of the database dbid in the tablespace, as
+// postgres' GetNewRelFileNode.  The oid is not used by a file of the
+// tablespace, and, if classCheck, not by a row of bp_class either, so that
+// it can be the oid of a new relation too.  A file node in the global
+// tablespace is for a shared relation.
+func GetNewRelFileNode(vars *TransamVariables, dbid, tsid system.Oid, classCheck bool,
+	bufMgr storage.BufferManager) (system.RelFileNode, error) {
+	node := system.RelFileNode{Dbid: dbid, Tsid: tsid}
+	if tsid == system.GlobalTableSpaceOid {
+		node.Dbid = system.InvalidOid
+	}
+	for {
+		var err error
+		if classCheck {
+			node.Relid, err = GetNewOid(vars, ClassRelId, dbid, bufMgr)
+		} else {
+			node.Relid, err = vars.GetNewObjectId()
+		}
+		if err != nil {
+			return node, err
+		}
+		if _, err := os.Stat(system.RelPath(node)); os.IsNotExist(err) {
+			return node, nil
+		} else if err != nil {
+			return node, err
+		}
+	}
+}
+
 func initTupleDesc(tupdesc *TupleDesc) {
 	for _, attr := range tupdesc.Attrs {
 		attr.Type = system.TypeRegistry[attr.TypeId]
@@ -63,6 +560,8 @@ func initTupleDesc(tupdesc *TupleDesc) {
 }
 
 func init() {
-	initTupleDesc(ClassTupleDesc)
-	initTupleDesc(AttributeTupleDesc)
+	for _, catalog := range Catalogs {
+		initTupleDesc(catalog.Desc)
+		catalogsByRelId[catalog.RelId] = catalog
+	}
 }
@@ -0,0 +1,190 @@
+package access
+
+import (
+	. "launchpad.net/gocheck"
+	"os"
+
+	"bigpot/storage"
+	"bigpot/system"
+)
+
+// Returns the values of a bp_class row for a relation in the public
+// namespace.
+func classRow(name string, relfilenode, relam system.Oid, natts int, kind system.Char) []system.Datum {
+	return []system.Datum{
+		system.Name(name), PublicNamespaceId, system.InvalidOid, BootstrapSuperuserId, relam,
+		relfilenode, system.InvalidOid, system.Int4(0), system.Float8(0), system.Bool(false),
+		system.Bool(false), kind, system.Int4(natts), system.Bool(false),
+	}
+}
+
+// Creates the catalog files and inserts the bootstrap rows into them.  The
+// caller removes the global directory along with base.
+func loadBootstrapCatalogs(c *C, bufMgr storage.BufferManager) {
+	os.MkdirAll("global", 0700)
+	rows := BootstrapRows()
+	for _, catalog := range Catalogs {
+		rel, err := HeapOpen(catalog.RelId, TemplateDbId, bufMgr)
+		c.Assert(err, IsNil)
+		c.Assert(rel.CreateStorage(), IsNil)
+		for _, row := range rows[catalog.RelId] {
+			c.Assert(rel.Insert(FormCatalogTuple(catalog.RelId, row.Oid, row.Values), bufMgr), IsNil)
+		}
+	}
+}
+
+func (s *MySuite) TestBootstrapCatalogs(c *C) {
+	os.MkdirAll("base/1", 0700)
+	defer os.RemoveAll("base")
+	defer os.RemoveAll("global")
+	bufMgr := newBufferManager(c, 64)
+	loadBootstrapCatalogs(c, bufMgr)
+
+	// the catalogs describe themselves
+	for _, catalog := range Catalogs {
+		form, err := readClassForm(catalog.RelId, TemplateDbId, bufMgr)
+		c.Assert(err, IsNil)
+		c.Check(form.RelName, Equals, catalog.Name)
+		c.Check(form.RelIsShared, Equals, catalog.Shared)
+		rel, err := HeapOpen(catalog.RelId, TemplateDbId, bufMgr)
+		c.Assert(err, IsNil)
+		c.Check(form.relFileNode(catalog.RelId, TemplateDbId), Equals, rel.RelNode)
+		tupdesc, err := buildTupleDesc(catalog.RelId, form, TemplateDbId, bufMgr)
+		c.Assert(err, IsNil)
+		c.Check(tupdesc.Attrs, DeepEquals, catalog.Desc.Attrs)
+		c.Check(tupdesc.hasOid, Equals, catalog.Desc.hasOid)
+	}
+
+	// and the built-in objects
+	var typnames []system.Datum
+	keys := []ScanKey{oidScanKey(Anum_type_typelem, system.Int4Type)}
+	err := scanCatalog(TypeRelId, TemplateDbId, keys, bufMgr, func(tuple Tuple) error {
+		typnames = append(typnames, tuple.Fetch(Anum_type_typname))
+		c.Check(tuple.Fetch(system.OidAttrNumber), Equals, system.Int4ArrayType)
+		return nil
+	})
+	c.Assert(err, IsNil)
+	c.Check(typnames, DeepEquals, []system.Datum{system.Name("_int4")})
+
+	proc, err := system.LookupProc("int4pl", []system.Oid{system.Int4Type, system.Int4Type})
+	c.Assert(err, IsNil)
+	var argTypes system.Datum
+	keys = []ScanKey{oidScanKey(system.OidAttrNumber, proc.Id)}
+	err = scanCatalog(ProcRelId, TemplateDbId, keys, bufMgr, func(tuple Tuple) error {
+		argTypes = tuple.Fetch(Anum_proc_proargtypes)
+		return nil
+	})
+	c.Assert(err, IsNil)
+	c.Check(argTypes, DeepEquals, system.MakeArray(system.OidType, system.Int4Type, system.Int4Type))
+
+	var datnames []system.Datum
+	err = scanCatalog(DatabaseRelId, TemplateDbId, nil, bufMgr, func(tuple Tuple) error {
+		datnames = append(datnames, tuple.Fetch(Anum_database_datname))
+		return nil
+	})
+	c.Assert(err, IsNil)
+	c.Check(datnames, DeepEquals, []system.Datum{system.Name("template1")})
+}
+
+func (s *MySuite) TestIndexOpen(c *C) {
+	os.MkdirAll("base/1", 0700)
+	defer os.RemoveAll("base")
+	defer os.RemoveAll("global")
+	bufMgr := newBufferManager(c, 64)
+	loadBootstrapCatalogs(c, bufMgr)
+
+	classRel, _ := HeapOpen(ClassRelId, TemplateDbId, bufMgr)
+	fillHeap(c, bufMgr, classRel, []*HeapTuple{
+		FormCatalogTuple(ClassRelId, 20001, classRow("t", 20001, system.InvalidOid, 2, RelKindRelation)),
+		FormCatalogTuple(ClassRelId, 20002, classRow("t_name_idx", 20102, HashAmOid, 1, RelKindIndex)),
+	})
+	attrRel, _ := HeapOpen(AttributeRelId, TemplateDbId, bufMgr)
+	var attrTuples []*HeapTuple
+	for _, attr := range []struct {
+		relid  system.Oid
+		name   string
+		typid  system.Oid
+		attnum int
+	}{{20001, "id", system.Int4Type, 1}, {20001, "name", system.NameType, 2}, {20002, "name", system.NameType, 1}} {
+		attrTuples = append(attrTuples, FormCatalogTuple(AttributeRelId, system.InvalidOid, []system.Datum{
+			attr.relid, system.Name(attr.name), attr.typid, system.Int4(system.TypeRegistry[attr.typid].Len),
+			system.Int4(attr.attnum), system.Bool(false), system.Bool(false), system.Bool(false),
+			system.Bool(false), system.Text(""),
+		}))
+	}
+	fillHeap(c, bufMgr, attrRel, attrTuples)
+	indexRel, _ := HeapOpen(IndexRelId, TemplateDbId, bufMgr)
+	fillHeap(c, bufMgr, indexRel, []*HeapTuple{FormCatalogTuple(IndexRelId, system.InvalidOid, []system.Datum{
+		system.Oid(20002), system.Oid(20001), system.Int4(1), system.Bool(true), system.Bool(false),
+		system.MakeArray(system.Int4Type, system.Int4(2)),
+	})})
+
+	index, err := IndexOpen(20002, TemplateDbId, bufMgr)
+	c.Assert(err, IsNil)
+	c.Check(index.RelName, Equals, system.Name("t_name_idx"))
+	c.Check(index.RelNode.Relid, Equals, system.Oid(20102))
+	c.Check(index.Am, Equals, HashAm)
+	c.Check(index.Unique, Equals, true)
+	c.Check(index.HeapAttrs, DeepEquals, []system.AttrNumber{2})
+	c.Check(index.RelDesc.Attrs[0].TypeId, Equals, system.NameType)
+
+	_, err = IndexOpen(20001, TemplateDbId, bufMgr)
+	c.Check(err, ErrorMatches, "\"t\" is not an index")
+	_, err = HeapOpen(20002, TemplateDbId, bufMgr)
+	c.Check(err, ErrorMatches, "\"t_name_idx\" is an index")
+}
+
+func (s *MySuite) TestGetNewOid(c *C) {
+	os.MkdirAll("base/1", 0700)
+	defer os.RemoveAll("base")
+	defer os.RemoveAll("global")
+	bufMgr := newBufferManager(c, 64)
+	loadBootstrapCatalogs(c, bufMgr)
+
+	ctl := storage.NewControlFileData()
+	ctl.NextOid = 20001
+	c.Assert(storage.WriteControlFile(ctl), IsNil)
+	vars, err := NewTransamVariables()
+	c.Assert(err, IsNil)
+
+	// the counter is kept a prefetch ahead in the control file
+	oid, err := vars.GetNewObjectId()
+	c.Assert(err, IsNil)
+	c.Check(oid, Equals, system.Oid(20001))
+	ctl, err = storage.ReadControlFile()
+	c.Assert(err, IsNil)
+	c.Check(ctl.NextOid, Equals, system.Oid(20001+OidPrefetch))
+	restarted, err := NewTransamVariables()
+	c.Assert(err, IsNil)
+	oid, err = restarted.GetNewObjectId()
+	c.Assert(err, IsNil)
+	c.Check(oid, Equals, system.Oid(20001+OidPrefetch))
+
+	// oids used in the catalog, and files of the tablespace, are skipped
+	classRel, _ := HeapOpen(ClassRelId, TemplateDbId, bufMgr)
+	c.Assert(classRel.Insert(FormCatalogTuple(ClassRelId, 20002,
+		classRow("t", 20002, system.InvalidOid, 0, RelKindRelation)), bufMgr), IsNil)
+	oid, err = GetNewOid(vars, ClassRelId, TemplateDbId, bufMgr)
+	c.Assert(err, IsNil)
+	c.Check(oid, Equals, system.Oid(20003))
+	createRelFile(c, system.RelFileNode{Dbid: 1, Tsid: system.DefaultTableSpaceOid, Relid: 20004})
+	node, err := GetNewRelFileNode(vars, TemplateDbId, system.DefaultTableSpaceOid, true, bufMgr)
+	c.Assert(err, IsNil)
+	c.Check(node, Equals, system.RelFileNode{Dbid: 1, Tsid: system.DefaultTableSpaceOid, Relid: 20005})
+	node, err = GetNewRelFileNode(vars, TemplateDbId, system.GlobalTableSpaceOid, false, bufMgr)
+	c.Assert(err, IsNil)
+	c.Check(node, Equals, system.RelFileNode{Tsid: system.GlobalTableSpaceOid, Relid: 20006})
+
+	// wraparound skips the oids of the built-in objects
+	vars.nextOid = 0
+	oid, err = vars.GetNewObjectId()
+	c.Assert(err, IsNil)
+	c.Check(oid, Equals, system.FirstNormalObjectId)
+
+	// the databases are found before any is chosen
+	dbid, err := GetDatabaseOid("template1", bufMgr)
+	c.Assert(err, IsNil)
+	c.Check(dbid, Equals, TemplateDbId)
+	_, err = GetDatabaseOid("nosuchdb", bufMgr)
+	c.Check(err, ErrorMatches, "database \"nosuchdb\" does not exist")
+}
@@ -19,18 +19,47 @@ type Tuple interface {
 }
 
 type Attribute struct {
-	Name   system.Name
-	TypeId system.Oid
-	Type   *system.TypeInfo
+	Name       system.Name
+	TypeId     system.Oid
+	Type       *system.TypeInfo
+	NotNull    bool
+	HasDefault bool
+	// A dropped column is still in the tuples, but no longer visible.
+	IsDropped bool
+	// The value of the column in the tuples written before it was added,
+	// as postgres' attmissingval.  Those tuples have fewer attributes than
+	// the TupleDesc.
+	Missing system.Datum
 }
 
 type TupleDesc struct {
 	Attrs  []*Attribute
+	Constr *TupleConstr
 	typid  system.Oid
 	hasOid bool
 }
 
-type ScanKey struct {
-	AttNum int32
-	Val    system.Datum
+// Makes a TupleDesc of the attributes, filling in their types.
+func NewTupleDesc(attrs []*Attribute, hasOid bool) *TupleDesc {
+	tupdesc := &TupleDesc{Attrs: attrs, hasOid: hasOid}
+	initTupleDesc(tupdesc)
+	return tupdesc
+}
+
+// Returns true if the tuples have oids.
+func (tupdesc *TupleDesc) HasOid() bool {
+	return tupdesc.hasOid
+}
+
+// TupleConstr holds the column defaults of a TupleDesc, as postgres'
+// TupleConstr.
+type TupleConstr struct {
+	Defaults []AttrDefault
+}
+
+// AttrDefault is the default expression of a column, as the source text
+// in bp_attrdef.
+type AttrDefault struct {
+	AttNum system.AttrNumber
+	Src    string
 }
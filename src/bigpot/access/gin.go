@@ -0,0 +1,614 @@
+package access
+
+import (
+	"encoding/binary"
+	"sort"
+	"unsafe"
+
+	"bigpot/storage"
+	"bigpot/system"
+)
+
+// The GIN access method, as postgres' generalized inverted indexes.  The
+// operator class extracts keys from each indexed value, such as the
+// elements of an array or the lexemes of a text, and the index maps every
+// key to the heap tids of the values having it.
+//
+// Block 0 is the metapage, and block 1 the root of the entry tree, a
+// btree of the keys.  Each entry holds the tids of its key in a posting
+// list, or the root of a posting tree, a btree of tids, once the list
+// outgrows the entry.  Values without keys, such as empty arrays, are
+// indexed under an "empty item" entry.  NULLs are not indexed.
+//
+// With fast update, inserts append their entries to the pending list
+// instead, which is moved into the entry tree once it reaches the pending
+// list limit, as the tree is much cheaper to update in bulk.  Scans read
+// the pending list as well.
+//
+// Unlike postgres, which locks pages, an insert holds the metapage lock
+// exclusively throughout, and a scan holds it shared, so the trees are
+// updated top-down without right-links to chase.  Only single-column
+// indexes are supported, and the scan returns a bitmap only.
+type gin struct {
+	fastUpdate       bool
+	pendingListLimit int
+}
+
+// The default pending list limit in kilobytes, as postgres'
+// gin_pending_list_limit.
+const GinDefaultPendingListLimit = 4096
+
+// The GIN IndexAm, with fast update.
+var GinAm IndexAm = gin{fastUpdate: true, pendingListLimit: GinDefaultPendingListLimit}
+
+// Returns the GIN IndexAm with the fastupdate and gin_pending_list_limit
+// storage parameters.  The limit is in kilobytes.
+func NewGinAm(fastUpdate bool, pendingListLimit int) IndexAm {
+	return gin{fastUpdate: fastUpdate, pendingListLimit: pendingListLimit}
+}
+
+// Strategies of the array operator class.
+const (
+	GinOverlapStrategyNumber   StrategyNumber = 1 // &&
+	GinContainsStrategyNumber  StrategyNumber = 2 // @>
+	GinContainedStrategyNumber StrategyNumber = 3 // <@
+	GinEqualStrategyNumber     StrategyNumber = 4 // =
+)
+
+// Strategy of the text operator class.
+const GinTextMatchStrategyNumber StrategyNumber = 1 // @@
+
+// ginSearchMode tells which items a query has to look at besides those
+// having its keys, as postgres' GIN_SEARCH_MODE_*.
+type ginSearchMode int
+
+const (
+	ginSearchModeDefault      ginSearchMode = iota
+	ginSearchModeIncludeEmpty               // also the items without keys
+	ginSearchModeAll                        // every item
+)
+
+// ginOpClass is a GIN operator class, as the support functions of
+// postgres' GIN opclasses.
+type ginOpClass struct {
+	// the operators of the strategies
+	operators map[StrategyNumber]string
+	keyType   system.Oid
+	// Returns the keys of the indexed value, sorted and without
+	// duplicates.
+	extractValue func(val system.Datum) []system.Datum
+	// Returns the keys to look up for the query.
+	extractQuery func(query system.Datum, strategy StrategyNumber) ([]system.Datum, ginSearchMode)
+	// Tells whether an item matches the query, given which of the query
+	// keys it has, and whether the heap tuple has to be checked as well.
+	consistent func(strategy StrategyNumber, check []bool) (match, recheck bool)
+}
+
+// Returns the operator class of arrays of the element type, as postgres'
+// array_ops.
+func ginArrayOpClass(elemType system.Oid) (*ginOpClass, error) {
+	cmp, err := system.LookupCompare(elemType, elemType)
+	if err != nil {
+		return nil, err
+	}
+	// the elements sorted and without duplicates, as postgres'
+	// ginarrayextract
+	extract := func(val system.Datum) []system.Datum {
+		return ginSortKeys(val.(system.Array).Elems, cmp)
+	}
+	return &ginOpClass{
+		operators: map[StrategyNumber]string{
+			GinOverlapStrategyNumber:   "&&",
+			GinContainsStrategyNumber:  "@>",
+			GinContainedStrategyNumber: "<@",
+			GinEqualStrategyNumber:     "=",
+		},
+		keyType:      elemType,
+		extractValue: extract,
+		extractQuery: func(query system.Datum, strategy StrategyNumber) ([]system.Datum, ginSearchMode) {
+			keys := extract(query)
+			switch strategy {
+			case GinContainsStrategyNumber:
+				if len(keys) == 0 {
+					// everything contains the empty array
+					return keys, ginSearchModeAll
+				}
+			case GinContainedStrategyNumber:
+				// the empty array is contained in anything
+				return keys, ginSearchModeIncludeEmpty
+			case GinEqualStrategyNumber:
+				if len(keys) == 0 {
+					return keys, ginSearchModeIncludeEmpty
+				}
+			}
+			return keys, ginSearchModeDefault
+		},
+		// as postgres' ginarrayconsistent
+		consistent: func(strategy StrategyNumber, check []bool) (bool, bool) {
+			switch strategy {
+			case GinOverlapStrategyNumber:
+				for _, c := range check {
+					if c {
+						return true, false
+					}
+				}
+				return false, false
+			case GinContainsStrategyNumber:
+				for _, c := range check {
+					if !c {
+						return false, false
+					}
+				}
+				return true, false
+			case GinContainedStrategyNumber:
+				// the item may have keys that aren't in the query
+				return true, true
+			case GinEqualStrategyNumber:
+				for _, c := range check {
+					if !c {
+						return false, false
+					}
+				}
+				return true, true
+			}
+			return false, false
+		},
+	}, nil
+}
+
+// The operator class of text search over the lexemes of a text.
+var ginTextOpClass = &ginOpClass{
+	operators: map[StrategyNumber]string{
+		GinTextMatchStrategyNumber: "@@",
+	},
+	keyType:      system.TextType,
+	extractValue: ginTextLexemes,
+	extractQuery: func(query system.Datum, strategy StrategyNumber) ([]system.Datum, ginSearchMode) {
+		return ginTextLexemes(query), ginSearchModeDefault
+	},
+	consistent: func(strategy StrategyNumber, check []bool) (bool, bool) {
+		for _, c := range check {
+			if !c {
+				return false, false
+			}
+		}
+		// a query without lexemes matches nothing
+		return len(check) > 0, false
+	},
+}
+
+func ginTextLexemes(val system.Datum) []system.Datum {
+	var keys []system.Datum
+	for _, lexeme := range system.TextLexemes(val.(system.Text)) {
+		keys = append(keys, lexeme)
+	}
+	return keys
+}
+
+// Returns the default operator class of the type.
+func ginGetOpClass(typid system.Oid) (*ginOpClass, error) {
+	if typid == system.TextType {
+		return ginTextOpClass, nil
+	}
+	if typ, ok := system.TypeRegistry[typid]; ok && typ.Elem != system.InvalidOid {
+		return ginArrayOpClass(typ.Elem)
+	}
+	return nil, system.Ereport(system.UndefinedObject,
+		"data type %s has no default operator class for access method \"gin\"", typeNameOf(typid))
+}
+
+func typeNameOf(typid system.Oid) string {
+	if typ, ok := system.TypeRegistry[typid]; ok {
+		return string(typ.Name)
+	}
+	return typid.ToString()
+}
+
+// Sorts the keys and removes duplicates.
+func ginSortKeys(keys []system.Datum, cmp system.CompareFunc) []system.Datum {
+	sorted := append([]system.Datum(nil), keys...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return cmp(sorted[i], sorted[j]) < 0
+	})
+	var result []system.Datum
+	for i, key := range sorted {
+		if i == 0 || cmp(result[len(result)-1], key) != 0 {
+			result = append(result, key)
+		}
+	}
+	return result
+}
+
+// Makes a scan key of the operator of the strategy in the operator class
+// of the index column type, such as "column @> val".
+func MakeGinScanKey(attnum system.AttrNumber, strategy StrategyNumber,
+	atttypid system.Oid, val system.Datum) (ScanKey, error) {
+	opclass, err := ginGetOpClass(atttypid)
+	if err != nil {
+		return ScanKey{}, err
+	}
+	opname, ok := opclass.operators[strategy]
+	if !ok {
+		return ScanKey{}, system.Ereport(system.InvalidParameterValue,
+			"invalid strategy number %d", strategy)
+	}
+	opr, err := system.LookupOperator(opname, atttypid, atttypid)
+	if err != nil {
+		return ScanKey{}, err
+	}
+	key := ScanKeyInit(attnum, strategy, nil, val)
+	key.Proc = opr.Proc.Func
+	return key, nil
+}
+
+// ginPageOpaque is kept in the special space of every GIN page, as
+// postgres' GinPageOpaqueData.
+type ginPageOpaque struct {
+	rightlink system.BlockNumber // next page on the same level or list
+	maxoff    system.OffsetNumber
+	flags     uint16
+}
+
+// ginPageOpaque flags
+const (
+	ginData     = 1 << 0 // a posting tree page
+	ginLeaf     = 1 << 1
+	ginDeleted  = 1 << 2 // on the free list
+	ginMetaPage = 1 << 3
+	ginList     = 1 << 4 // a pending list page
+)
+
+// ginMetaPageData is kept in the contents of the metapage, as postgres'
+// GinMetaPageData.
+type ginMetaPageData struct {
+	magic   uint32
+	version uint32
+	// the pending list
+	head               system.BlockNumber
+	tail               system.BlockNumber
+	nPendingPages      uint32
+	nPendingHeapTuples uint32
+	// the first page of the free list
+	firstFree system.BlockNumber
+	// the number of distinct keys in the entry tree
+	nEntries uint32
+}
+
+const (
+	ginMetaBlock = system.BlockNumber(0)
+	ginRootBlock = system.BlockNumber(1)
+	ginMagic     = 0x6E1D01F
+	ginVersion   = 1
+)
+
+var sizeOfGinPageOpaque = unsafe.Sizeof(ginPageOpaque{})
+
+func ginOpaque(page *storage.Page) *ginPageOpaque {
+	special := page.SpecialSpace()
+	return (*ginPageOpaque)(unsafe.Pointer(&special[0]))
+}
+
+func ginMeta(page *storage.Page) *ginMetaPageData {
+	contents := page.Contents()
+	return (*ginMetaPageData)(unsafe.Pointer(&contents[0]))
+}
+
+// The maximum size of an entry tuple, which ensures at least three fit on
+// a page, as postgres' GinMaxItemSize.
+var ginMaxItemSize int
+
+func init() {
+	page := storage.NewPage(new(storage.Block))
+	page.Init(sizeOfGinPageOpaque)
+	free := page.FreeSpace() - 2*uint(unsafe.Sizeof(storage.ItemId(0)))
+	ginMaxItemSize = int(free/3) &^ (system.MaximumAlignof - 1)
+}
+
+// Categories of entries, as postgres' GinNullCategory.  Entries sort by
+// category, and then by key.
+const (
+	ginCatNormKey   = 0 // a key extracted from a value
+	ginCatEmptyItem = 2 // the entry of the values without keys
+)
+
+// ginEntryKey is the key of an entry.  key is nil for the empty item.
+type ginEntryKey struct {
+	category int32
+	key      system.Datum
+}
+
+// The tid of an entry tuple on a leaf page tells how its posting list is
+// kept, and the tid of a tuple on an internal page points to the child.
+const (
+	// The tid block is the number of tids in the posting list that
+	// follows the tuple.
+	ginPostingList = 0xFFFE
+	// The tid block is the root of the posting tree.
+	ginPostingTree = 0xFFFF
+)
+
+// ginState is what index operations need to know about the index, as
+// postgres' GinState.
+type ginState struct {
+	index   *IndexRelation
+	opclass *ginOpClass
+	compare system.CompareFunc
+	// the descriptor of entry tuples: the category and the key
+	tupdesc *TupleDesc
+	bufMgr  storage.BufferManager
+}
+
+func initGinState(index *IndexRelation, bufMgr storage.BufferManager) (*ginState, error) {
+	if len(index.RelDesc.Attrs) != 1 {
+		return nil, system.Ereport(system.FeatureNotSupported,
+			"access method \"gin\" does not support multicolumn indexes")
+	}
+	opclass, err := ginGetOpClass(index.RelDesc.Attrs[0].TypeId)
+	if err != nil {
+		return nil, err
+	}
+	cmp, err := system.LookupCompare(opclass.keyType, opclass.keyType)
+	if err != nil {
+		return nil, err
+	}
+	tupdesc := &TupleDesc{
+		Attrs: []*Attribute{
+			{Name: "category", TypeId: system.Int4Type},
+			{Name: "key", TypeId: opclass.keyType},
+		},
+	}
+	initTupleDesc(tupdesc)
+	return &ginState{
+		index:   index,
+		opclass: opclass,
+		compare: cmp,
+		tupdesc: tupdesc,
+		bufMgr:  bufMgr,
+	}, nil
+}
+
+// Compares the entry keys, as postgres' ginCompareEntries.
+func (state *ginState) compareEntries(a, b ginEntryKey) int {
+	if a.category != b.category {
+		if a.category < b.category {
+			return -1
+		}
+		return 1
+	}
+	if a.category != ginCatNormKey {
+		return 0
+	}
+	return state.compare(a.key, b.key)
+}
+
+// Returns the entry keys of the indexed value, as postgres'
+// ginExtractEntries.
+func (state *ginState) extractEntries(val system.Datum) []ginEntryKey {
+	keys := state.opclass.extractValue(val)
+	if len(keys) == 0 {
+		return []ginEntryKey{{category: ginCatEmptyItem}}
+	}
+	entries := make([]ginEntryKey, len(keys))
+	for i, key := range keys {
+		entries[i] = ginEntryKey{category: ginCatNormKey, key: key}
+	}
+	return entries
+}
+
+// Forms an entry tuple of the key with the tid left invalid, as postgres'
+// GinFormTuple.
+func (state *ginState) formTuple(key ginEntryKey) (IndexTuple, error) {
+	itup, err := FormIndexTuple([]system.Datum{system.Int4(key.category), key.key}, state.tupdesc)
+	if err != nil {
+		return nil, err
+	}
+	if len(itup) > ginMaxItemSize {
+		return nil, system.Ereport(system.ProgramLimitExceeded,
+			"index row size %d exceeds maximum %d for index \"%s\"",
+			len(itup), ginMaxItemSize, state.index.RelName)
+	}
+	return itup, nil
+}
+
+// Forms a leaf entry tuple of the key with the posting list.  It returns
+// nil if the tuple would be too large, so the tids need a posting tree.
+func (state *ginState) formLeafTuple(key ginEntryKey, tids []system.ItemPointer) (IndexTuple, error) {
+	itup, err := state.formTuple(key)
+	if err != nil {
+		return nil, err
+	}
+	posting := ginEncodePostingList(tids)
+	if len(itup)+len(posting) > ginMaxItemSize {
+		return nil, nil
+	}
+	itup.SetTid(system.MakeItemPointer(system.BlockNumber(len(tids)), ginPostingList))
+	return append(itup, posting...), nil
+}
+
+// Returns the key of the entry tuple.
+func (state *ginState) tupleKey(itup IndexTuple) ginEntryKey {
+	values := itup.Values(state.tupdesc)
+	return ginEntryKey{category: int32(values[0].(system.Int4)), key: values[1]}
+}
+
+func ginIsPostingTree(itup IndexTuple) bool {
+	return itup.Tid().OffsetNumber() == ginPostingTree
+}
+
+// Returns the tids of the posting list following the leaf entry tuple.
+func ginReadPostingList(itup IndexTuple) []system.ItemPointer {
+	n := int(itup.Tid().BlockNumber())
+	return ginDecodePostingList(itup[itup.Size():], n)
+}
+
+// Encodes the sorted tids as varbyte deltas, as postgres'
+// ginCompressPostingList.
+func ginEncodePostingList(tids []system.ItemPointer) []byte {
+	buf := make([]byte, 0, len(tids)*3)
+	var varbyte [binary.MaxVarintLen64]byte
+	prev := uint64(0)
+	for _, tid := range tids {
+		val := ginItemPointerToUint64(tid)
+		n := binary.PutUvarint(varbyte[:], val-prev)
+		buf = append(buf, varbyte[:n]...)
+		prev = val
+	}
+	return buf
+}
+
+func ginDecodePostingList(data []byte, n int) []system.ItemPointer {
+	tids := make([]system.ItemPointer, n)
+	val := uint64(0)
+	for i := range tids {
+		delta, size := binary.Uvarint(data)
+		data = data[size:]
+		val += delta
+		tids[i] = system.MakeItemPointer(system.BlockNumber(val>>16), system.OffsetNumber(val&0xFFFF))
+	}
+	return tids
+}
+
+func ginItemPointerToUint64(tid system.ItemPointer) uint64 {
+	return uint64(tid.BlockNumber())<<16 | uint64(tid.OffsetNumber())
+}
+
+func ginCompareItemPointers(a, b system.ItemPointer) int {
+	av, bv := ginItemPointerToUint64(a), ginItemPointerToUint64(b)
+	if av < bv {
+		return -1
+	} else if av > bv {
+		return 1
+	}
+	return 0
+}
+
+// Merges the sorted tid lists, removing duplicates, as postgres'
+// ginMergeItemPointers.
+func ginMergeItemPointers(a, b []system.ItemPointer) []system.ItemPointer {
+	result := make([]system.ItemPointer, 0, len(a)+len(b))
+	for len(a) > 0 || len(b) > 0 {
+		var c int
+		if len(a) == 0 {
+			c = 1
+		} else if len(b) == 0 {
+			c = -1
+		} else {
+			c = ginCompareItemPointers(a[0], b[0])
+		}
+		if c <= 0 {
+			result = append(result, a[0])
+			a = a[1:]
+			if c == 0 {
+				b = b[1:]
+			}
+		} else {
+			result = append(result, b[0])
+			b = b[1:]
+		}
+	}
+	return result
+}
+
+// Implements IndexAm.Build.  The entries of the whole heap are
+// accumulated in memory, and each key is added to the entry tree once
+// with all its tids, as postgres' ginbuild.
+func (g gin) Build(heap *HeapRelation, index *IndexRelation, bufMgr storage.BufferManager) error {
+	state, err := initGinState(index, bufMgr)
+	if err != nil {
+		return err
+	}
+	if err := ginInitMetaPage(index, bufMgr); err != nil {
+		return err
+	}
+	metaBuf, meta, err := ginGetMeta(index, ginWrite, bufMgr)
+	if err != nil {
+		return err
+	}
+	defer ginRelBuf(bufMgr, metaBuf, ginWrite)
+	metaBuf.MarkDirty()
+
+	accum := newGinAccumulator(state)
+	err = indexBuildHeapScan(heap, index, bufMgr,
+		func(values []system.Datum, tid system.ItemPointer) error {
+			if values[0] != nil {
+				accum.add(state.extractEntries(values[0]), tid)
+			}
+			return nil
+		})
+	if err != nil {
+		return err
+	}
+	return accum.flush(meta)
+}
+
+// Implements IndexAm.Insert, as postgres' gininsert.  GIN indexes can't
+// be unique.
+func (g gin) Insert(index *IndexRelation, values []system.Datum, tid system.ItemPointer,
+	heap *HeapRelation, bufMgr storage.BufferManager) error {
+	if index.Unique {
+		return system.Ereport(system.FeatureNotSupported,
+			"access method \"gin\" does not support unique indexes")
+	}
+	state, err := initGinState(index, bufMgr)
+	if err != nil {
+		return err
+	}
+	if values[0] == nil {
+		// NULLs are not indexed
+		return nil
+	}
+	entries := state.extractEntries(values[0])
+
+	metaBuf, meta, err := ginGetMeta(index, ginWrite, bufMgr)
+	if err != nil {
+		return err
+	}
+	defer ginRelBuf(bufMgr, metaBuf, ginWrite)
+	metaBuf.MarkDirty()
+
+	if g.fastUpdate {
+		if err := ginHeapTupleFastInsert(state, meta, entries, tid); err != nil {
+			return err
+		}
+		if int(meta.nPendingPages)*system.BlockSize/1024 >= g.pendingListLimit {
+			return ginInsertCleanup(state, meta)
+		}
+		return nil
+	}
+	for _, entry := range entries {
+		if err := ginEntryInsert(state, meta, entry, []system.ItemPointer{tid}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Implements IndexAm.BeginScan.  Use IndexGetBitmap instead.
+func (g gin) BeginScan(index *IndexRelation, keys []ScanKey, bufMgr storage.BufferManager) (IndexScanDesc, error) {
+	return nil, system.Ereport(system.FeatureNotSupported,
+		"access method \"gin\" supports only bitmap scans")
+}
+
+// Implements BitmapIndexAm.GetBitmap, as postgres' gingetbitmap.
+func (g gin) GetBitmap(index *IndexRelation, heap *HeapRelation, keys []ScanKey, tbm *TIDBitmap,
+	bufMgr storage.BufferManager) error {
+	state, err := initGinState(index, bufMgr)
+	if err != nil {
+		return err
+	}
+	items, err := ginGetItems(state, keys)
+	if err != nil {
+		return err
+	}
+	var exact, recheck []system.ItemPointer
+	for _, item := range items {
+		if item.recheck {
+			recheck = append(recheck, item.tid)
+		} else {
+			exact = append(exact, item.tid)
+		}
+	}
+	tbm.AddTuples(exact, false)
+	tbm.AddTuples(recheck, true)
+	return nil
+}
@@ -0,0 +1,223 @@
+package access
+
+import (
+	"fmt"
+	. "launchpad.net/gocheck"
+	"os"
+
+	"bigpot/storage"
+	"bigpot/system"
+)
+
+// Makes a heap of (id int4, tags int4[], body text) and fills it with the
+// rows.
+func makeGinTestHeap(c *C, bufMgr storage.BufferManager, relid system.Oid, rows [][]system.Datum) *HeapRelation {
+	tupdesc := &TupleDesc{
+		Attrs: []*Attribute{
+			{Name: "id", TypeId: system.Int4Type},
+			{Name: "tags", TypeId: system.Int4ArrayType},
+			{Name: "body", TypeId: system.TextType},
+		},
+	}
+	initTupleDesc(tupdesc)
+	rel := &HeapRelation{RelId: relid, RelName: "docs", RelDesc: tupdesc}
+	rel.initRelFileNode(TemplateDbId)
+	createRelFile(c, rel.RelNode)
+
+	var tuples []*HeapTuple
+	for _, row := range rows {
+		tuples = append(tuples, FormHeapTuple(row, tupdesc))
+	}
+	fillHeap(c, bufMgr, rel, tuples)
+	return rel
+}
+
+// Rows whose tags are the divisors of the id up to 10 and 1000 for all,
+// except some empty and NULL ones, and whose body names some of them.
+func ginTestRows(n int) [][]system.Datum {
+	var rows [][]system.Datum
+	for i := 0; i < n; i++ {
+		var tags system.Datum
+		switch {
+		case i%97 == 0:
+			tags = nil
+		case i%89 == 0:
+			tags = system.MakeArray(system.Int4Type)
+		default:
+			arr := system.MakeArray(system.Int4Type, system.Int4(1000))
+			for d := 10; d >= 2; d-- {
+				if i%d == 0 {
+					arr.Elems = append(arr.Elems, system.Int4(d))
+				}
+			}
+			tags = arr
+		}
+		body := fmt.Sprintf("Row %d is", i)
+		if i%2 == 0 {
+			body += " even,"
+		} else {
+			body += " odd,"
+		}
+		if i%5 == 0 {
+			body += " and Round"
+		}
+		rows = append(rows, []system.Datum{system.Int4(i), tags, system.Text(body)})
+	}
+	return rows
+}
+
+// Makes an empty GIN index on the heap column.
+func makeGinTestIndex(c *C, heap *HeapRelation, relid system.Oid, attnum system.AttrNumber,
+	am IndexAm) *IndexRelation {
+	index := makeTestIndex(c, heap, relid, attnum, false)
+	index.Am = am
+	return index
+}
+
+// Checks that the bitmap scan returns the same rows as a heap scan for
+// each of the keys, among the rows with ids below nrows, which are the
+// ones indexed.
+func checkGinScans(c *C, heap *HeapRelation, index *IndexRelation, nrows int, keys []ScanKey,
+	bufMgr storage.BufferManager) {
+	indexed := func(ids []system.Datum) []system.Datum {
+		var result []system.Datum
+		for _, id := range ids {
+			if int(id.(system.Int4)) < nrows {
+				result = append(result, id)
+			}
+		}
+		return result
+	}
+	for _, key := range keys {
+		keys := []ScanKey{key}
+		expected := indexed(scanAll(c, heap, keys, bufMgr))
+		tbm := NewTIDBitmap(0)
+		c.Assert(IndexGetBitmap(heap, index, keys, tbm, bufMgr), IsNil)
+		c.Check(indexed(bitmapScanAll(c, heap, tbm, keys, bufMgr)), DeepEquals, expected,
+			Commentf("strategy %d, %s", key.Strategy, key.Val.ToString()))
+	}
+}
+
+func (s *MySuite) TestGinArrays(c *C) {
+	os.MkdirAll("base/1", 0700)
+	defer os.RemoveAll("base")
+	defer os.RemoveAll("global")
+	bufMgr := newBufferManager(c, 64)
+
+	const nrows = 3000
+	heap := makeGinTestHeap(c, bufMgr, 20000, ginTestRows(nrows))
+	index := makeGinTestIndex(c, heap, 20001, 2, GinAm)
+	c.Assert(index.Build(heap, bufMgr), IsNil)
+
+	// the entry of 1000 has a posting tree of several leaves
+	state, err := initGinState(index, bufMgr)
+	c.Assert(err, IsNil)
+	itup, err := ginEntryFind(state, ginEntryKey{category: ginCatNormKey, key: system.Int4(1000)})
+	c.Assert(err, IsNil)
+	c.Assert(ginIsPostingTree(itup), Equals, true)
+	tids, err := ginEntryItemPointers(state, itup)
+	c.Assert(err, IsNil)
+	c.Check(len(tids) > ginDataLeafCapacity, Equals, true)
+
+	key := func(strategy StrategyNumber, elems ...int) ScanKey {
+		arr := system.MakeArray(system.Int4Type)
+		for _, elem := range elems {
+			arr.Elems = append(arr.Elems, system.Int4(elem))
+		}
+		key, err := MakeGinScanKey(2, strategy, system.Int4ArrayType, arr)
+		c.Assert(err, IsNil)
+		return key
+	}
+	checkGinScans(c, heap, index, nrows, []ScanKey{
+		key(GinContainsStrategyNumber, 3),
+		key(GinContainsStrategyNumber, 2, 5),
+		key(GinContainsStrategyNumber, 7, 1000),
+		key(GinContainsStrategyNumber, 11),
+		key(GinContainsStrategyNumber),
+		key(GinOverlapStrategyNumber, 9, 10),
+		key(GinContainedStrategyNumber, 1000, 2, 3),
+		key(GinContainedStrategyNumber),
+		key(GinEqualStrategyNumber, 1000, 5, 7),
+		key(GinEqualStrategyNumber),
+	}, bufMgr)
+
+	// all the keys must match
+	tbm := NewTIDBitmap(0)
+	c.Assert(IndexGetBitmap(heap, index, []ScanKey{
+		key(GinContainsStrategyNumber, 4), key(GinContainsStrategyNumber, 6)}, tbm, bufMgr), IsNil)
+	ids := bitmapScanAll(c, heap, tbm, []ScanKey{key(GinContainsStrategyNumber, 4, 6)}, bufMgr)
+	c.Check(ids, DeepEquals, scanAll(c, heap, []ScanKey{key(GinContainsStrategyNumber, 4, 6)}, bufMgr))
+
+	_, err = IndexBeginScan(heap, index, nil, bufMgr)
+	c.Check(err, ErrorMatches, "access method \"gin\" supports only bitmap scans")
+	err = IndexGetBitmap(heap, index, nil, NewTIDBitmap(0), bufMgr)
+	c.Check(err, ErrorMatches, "GIN indexes do not support whole-index scans")
+	_, err = MakeGinScanKey(1, GinContainsStrategyNumber, system.Int4Type, system.Int4(1))
+	c.Check(err, ErrorMatches, "data type int4 has no default operator class for access method \"gin\"")
+}
+
+func (s *MySuite) TestGinFastUpdate(c *C) {
+	os.MkdirAll("base/1", 0700)
+	defer os.RemoveAll("base")
+	defer os.RemoveAll("global")
+	bufMgr := newBufferManager(c, 64)
+
+	// the index is built empty, and the rows are inserted one by one
+	const nrows = 2000
+	heap := makeGinTestHeap(c, bufMgr, 20000, ginTestRows(nrows))
+	empty := makeGinTestHeap(c, bufMgr, 20001, nil)
+	index := makeGinTestIndex(c, heap, 20002, 3, NewGinAm(true, 64))
+	c.Assert(index.Build(empty, bufMgr), IsNil)
+
+	pending := func() (uint32, uint32) {
+		metaBuf, meta, err := ginGetMeta(index, ginRead, bufMgr)
+		c.Assert(err, IsNil)
+		defer ginRelBuf(bufMgr, metaBuf, ginRead)
+		return meta.nPendingPages, meta.nEntries
+	}
+	key := func(query string) ScanKey {
+		key, err := MakeGinScanKey(3, GinTextMatchStrategyNumber, system.TextType, system.Text(query))
+		c.Assert(err, IsNil)
+		return key
+	}
+	keys := []ScanKey{key("even"), key("odd round"), key("ROUND, row"), key("1999"), key("nothing"), key("")}
+
+	scan, err := heap.BeginScan(nil, bufMgr)
+	c.Assert(err, IsNil)
+	cleanups := 0
+	for n := 0; ; n++ {
+		tuple, err := scan.Next()
+		c.Assert(err, IsNil)
+		if tuple == nil {
+			break
+		}
+		htup := tuple.(*HeapTuple)
+		before, _ := pending()
+		c.Assert(index.Insert(index.FormIndexDatum(htup), htup.self, heap, bufMgr), IsNil)
+		if after, _ := pending(); after < before {
+			cleanups++
+		}
+		if n == 500 {
+			// rows both in the tree and the pending list
+			checkGinScans(c, heap, index, n+1, keys, bufMgr)
+		}
+	}
+	c.Assert(scan.EndScan(), IsNil)
+	c.Check(cleanups > 0, Equals, true)
+	nPending, _ := pending()
+	c.Check(nPending > 0, Equals, true)
+	checkGinScans(c, heap, index, nrows, keys, bufMgr)
+
+	c.Assert(GinCleanPendingList(index, bufMgr), IsNil)
+	nPending, nEntries := pending()
+	c.Check(nPending, Equals, uint32(0))
+	// "row", "is", "even", "odd", "and", "round" and the ids
+	c.Check(nEntries, Equals, uint32(nrows+6))
+	checkGinScans(c, heap, index, nrows, keys, bufMgr)
+
+	// the pages of the pending list are reused
+	metaBuf, meta, err := ginGetMeta(index, ginRead, bufMgr)
+	c.Assert(err, IsNil)
+	c.Check(meta.firstFree.IsValid(), Equals, true)
+	ginRelBuf(bufMgr, metaBuf, ginRead)
+}
@@ -0,0 +1,295 @@
+package access
+
+import (
+	"encoding/binary"
+
+	"bigpot/storage"
+	"bigpot/system"
+)
+
+// A posting tree is a btree of the heap tids of one key.  Leaf pages hold
+// a sorted array of tids in their contents, and internal pages an array of
+// posting items, each pointing to a child and holding the upper bound of
+// the tids below it.  Tids greater than every bound of an internal page
+// belong to its last child.  The number of items is kept in maxoff.
+//
+// As with the entry tree, pages are changed in memory and written back,
+// and the root stays at its block, which the entry tuple points to.
+// Insertions are done in batches: a page receiving more tids than fit is
+// split into as many pages as needed.
+
+// ginPostingItem is an item of an internal posting tree page, as postgres'
+// PostingItem.
+type ginPostingItem struct {
+	child system.BlockNumber
+	key   system.ItemPointer
+}
+
+const (
+	sizeOfGinDataLeafItem     = 6  // block and offset
+	sizeOfGinDataInternalItem = 10 // child, block and offset
+)
+
+// The number of items that fit on a leaf and an internal data page.
+var ginDataLeafCapacity, ginDataInternalCapacity int
+
+func init() {
+	page := storage.NewPage(new(storage.Block))
+	page.Init(sizeOfGinPageOpaque)
+	space := int(page.Special() - page.ContentsOffset(0))
+	ginDataLeafCapacity = space / sizeOfGinDataLeafItem
+	ginDataInternalCapacity = space / sizeOfGinDataInternalItem
+}
+
+func ginDataLeafItems(page *storage.Page) []system.ItemPointer {
+	contents := page.Contents()
+	tids := make([]system.ItemPointer, ginOpaque(page).maxoff)
+	for i := range tids {
+		b := contents[i*sizeOfGinDataLeafItem:]
+		tids[i] = system.MakeItemPointer(system.BlockNumber(binary.LittleEndian.Uint32(b)),
+			system.OffsetNumber(binary.LittleEndian.Uint16(b[4:])))
+	}
+	return tids
+}
+
+func ginDataInternalItems(page *storage.Page) []ginPostingItem {
+	contents := page.Contents()
+	items := make([]ginPostingItem, ginOpaque(page).maxoff)
+	for i := range items {
+		b := contents[i*sizeOfGinDataInternalItem:]
+		items[i].child = system.BlockNumber(binary.LittleEndian.Uint32(b))
+		items[i].key = system.MakeItemPointer(system.BlockNumber(binary.LittleEndian.Uint32(b[4:])),
+			system.OffsetNumber(binary.LittleEndian.Uint16(b[8:])))
+	}
+	return items
+}
+
+// Rewrites the leaf data page with the tids.
+func ginWriteDataLeaf(buf storage.Buffer, rightlink system.BlockNumber, tids []system.ItemPointer) {
+	page := buf.GetPage()
+	ginPageInit(page, ginData|ginLeaf)
+	opaque := ginOpaque(page)
+	opaque.rightlink = rightlink
+	opaque.maxoff = system.OffsetNumber(len(tids))
+	contents := page.Contents()
+	for i, tid := range tids {
+		b := contents[i*sizeOfGinDataLeafItem:]
+		binary.LittleEndian.PutUint32(b, uint32(tid.BlockNumber()))
+		binary.LittleEndian.PutUint16(b[4:], uint16(tid.OffsetNumber()))
+	}
+	page.SetLower(page.ContentsOffset(uintptr(len(tids) * sizeOfGinDataLeafItem)))
+	buf.MarkDirty()
+}
+
+// Rewrites the internal data page with the posting items.
+func ginWriteDataInternal(buf storage.Buffer, rightlink system.BlockNumber, items []ginPostingItem) {
+	page := buf.GetPage()
+	ginPageInit(page, ginData)
+	opaque := ginOpaque(page)
+	opaque.rightlink = rightlink
+	opaque.maxoff = system.OffsetNumber(len(items))
+	contents := page.Contents()
+	for i, item := range items {
+		b := contents[i*sizeOfGinDataInternalItem:]
+		binary.LittleEndian.PutUint32(b, uint32(item.child))
+		binary.LittleEndian.PutUint32(b[4:], uint32(item.key.BlockNumber()))
+		binary.LittleEndian.PutUint16(b[8:], uint16(item.key.OffsetNumber()))
+	}
+	page.SetLower(page.ContentsOffset(uintptr(len(items) * sizeOfGinDataInternalItem)))
+	buf.MarkDirty()
+}
+
+// Creates a posting tree of the sorted tids and returns its root, as
+// postgres' createPostingTree.  The metapage must be locked exclusively.
+func ginCreatePostingTree(state *ginState, meta *ginMetaPageData, tids []system.ItemPointer) (system.BlockNumber, error) {
+	buf, err := ginNewBuffer(state, meta, ginData|ginLeaf)
+	if err != nil {
+		return system.InvalidBlockNumber, err
+	}
+	root := buf.BlockNumber()
+	ginWriteDataLeaf(buf, system.InvalidBlockNumber, nil)
+	ginRelBuf(state.bufMgr, buf, ginWrite)
+
+	return root, ginInsertItemPointers(state, meta, root, tids)
+}
+
+// Adds the sorted tids to the posting tree, as postgres'
+// ginInsertItemPointers.  The metapage must be locked exclusively.
+func ginInsertItemPointers(state *ginState, meta *ginMetaPageData, root system.BlockNumber,
+	tids []system.ItemPointer) error {
+	if len(tids) == 0 {
+		return nil
+	}
+	_, err := ginDataInsertRec(state, meta, root, root, tids)
+	return err
+}
+
+// Adds the tids to the subtree at blkno.  If the page had to be split, it
+// returns the posting items of all the pages it was split into, the first
+// being blkno itself.
+func ginDataInsertRec(state *ginState, meta *ginMetaPageData, root, blkno system.BlockNumber,
+	tids []system.ItemPointer) ([]ginPostingItem, error) {
+	buf, err := ginGetBuf(state.index, blkno, ginWrite, state.bufMgr)
+	if err != nil {
+		return nil, err
+	}
+	defer ginRelBuf(state.bufMgr, buf, ginWrite)
+	page := buf.GetPage()
+	isLeaf := ginOpaque(page).flags&ginLeaf != 0
+
+	if isLeaf {
+		merged := ginMergeItemPointers(ginDataLeafItems(page), tids)
+		if len(merged) <= ginDataLeafCapacity {
+			ginWriteDataLeaf(buf, ginOpaque(page).rightlink, merged)
+			return nil, nil
+		}
+		chunks := ginDataChunks(len(merged), ginDataLeafCapacity)
+		leafItems := make([]ginPostingItem, len(chunks))
+		for i, chunk := range chunks {
+			leafItems[i].key = merged[chunk[1]-1]
+		}
+		return ginDataSplit(state, meta, buf, root, leafItems, chunks,
+			func(buf storage.Buffer, rightlink system.BlockNumber, chunk [2]int) {
+				ginWriteDataLeaf(buf, rightlink, merged[chunk[0]:chunk[1]])
+			})
+	}
+
+	// hand each child the tids up to its bound
+	items := ginDataInternalItems(page)
+	var newItems []ginPostingItem
+	for i, item := range items {
+		n := len(tids)
+		if i < len(items)-1 {
+			n = 0
+			for n < len(tids) && ginCompareItemPointers(tids[n], item.key) <= 0 {
+				n++
+			}
+		}
+		if n == 0 {
+			newItems = append(newItems, item)
+			continue
+		}
+		split, err := ginDataInsertRec(state, meta, root, item.child, tids[:n])
+		if err != nil {
+			return nil, err
+		}
+		tids = tids[n:]
+		if split == nil {
+			newItems = append(newItems, item)
+			continue
+		}
+		// the last page keeps the bound of the original
+		split[len(split)-1].key = item.key
+		newItems = append(newItems, split...)
+	}
+
+	if len(newItems) <= ginDataInternalCapacity {
+		ginWriteDataInternal(buf, ginOpaque(page).rightlink, newItems)
+		return nil, nil
+	}
+	chunks := ginDataChunks(len(newItems), ginDataInternalCapacity)
+	downlinks := make([]ginPostingItem, len(chunks))
+	for i, chunk := range chunks {
+		downlinks[i].key = newItems[chunk[1]-1].key
+	}
+	return ginDataSplit(state, meta, buf, root, downlinks, chunks,
+		func(buf storage.Buffer, rightlink system.BlockNumber, chunk [2]int) {
+			ginWriteDataInternal(buf, rightlink, newItems[chunk[0]:chunk[1]])
+		})
+}
+
+// Divides n items into the fewest chunks of at most capacity items, of
+// about equal size.  Each chunk is the range [start, end).
+func ginDataChunks(n, capacity int) [][2]int {
+	nchunks := (n + capacity - 1) / capacity
+	var chunks [][2]int
+	for i := 0; i < nchunks; i++ {
+		chunks = append(chunks, [2]int{n * i / nchunks, n * (i + 1) / nchunks})
+	}
+	return chunks
+}
+
+// Writes the chunks of a page that overflowed to the page and new right
+// siblings, filling in the child of each posting item.  If the page is the
+// root, the chunks all go to new pages, and the root becomes an internal
+// page pointing to them.
+func ginDataSplit(state *ginState, meta *ginMetaPageData, buf storage.Buffer, root system.BlockNumber,
+	items []ginPostingItem, chunks [][2]int,
+	write func(buf storage.Buffer, rightlink system.BlockNumber, chunk [2]int)) ([]ginPostingItem, error) {
+	isRoot := buf.BlockNumber() == root
+	bufs := make([]storage.Buffer, len(chunks))
+	for i := range chunks {
+		if i == 0 && !isRoot {
+			bufs[i] = buf
+			continue
+		}
+		newBuf, err := ginNewBuffer(state, meta, ginData)
+		if err != nil {
+			return nil, err
+		}
+		defer ginRelBuf(state.bufMgr, newBuf, ginWrite)
+		bufs[i] = newBuf
+	}
+
+	rightlink := ginOpaque(buf.GetPage()).rightlink
+	for i := len(chunks) - 1; i >= 0; i-- {
+		write(bufs[i], rightlink, chunks[i])
+		items[i].child = bufs[i].BlockNumber()
+		rightlink = bufs[i].BlockNumber()
+	}
+	if !isRoot {
+		return items, nil
+	}
+
+	// the tree grows a level; with enough chunks, more than one
+	for len(items) > ginDataInternalCapacity {
+		chunks := ginDataChunks(len(items), ginDataInternalCapacity)
+		var upper []ginPostingItem
+		rightlink := system.BlockNumber(system.InvalidBlockNumber)
+		for i := len(chunks) - 1; i >= 0; i-- {
+			newBuf, err := ginNewBuffer(state, meta, ginData)
+			if err != nil {
+				return nil, err
+			}
+			ginWriteDataInternal(newBuf, rightlink, items[chunks[i][0]:chunks[i][1]])
+			rightlink = newBuf.BlockNumber()
+			upper = append([]ginPostingItem{{child: rightlink, key: items[chunks[i][1]-1].key}}, upper...)
+			ginRelBuf(state.bufMgr, newBuf, ginWrite)
+		}
+		items = upper
+	}
+	ginWriteDataInternal(buf, system.InvalidBlockNumber, items)
+	return nil, nil
+}
+
+// Returns all the tids of the posting tree in order.
+func ginScanPostingTree(state *ginState, root system.BlockNumber) ([]system.ItemPointer, error) {
+	// descend to the leftmost leaf
+	blkno := root
+	for {
+		buf, err := ginGetBuf(state.index, blkno, ginRead, state.bufMgr)
+		if err != nil {
+			return nil, err
+		}
+		page := buf.GetPage()
+		if ginOpaque(page).flags&ginLeaf != 0 {
+			ginRelBuf(state.bufMgr, buf, ginRead)
+			break
+		}
+		blkno = ginDataInternalItems(page)[0].child
+		ginRelBuf(state.bufMgr, buf, ginRead)
+	}
+
+	var tids []system.ItemPointer
+	for blkno.IsValid() {
+		buf, err := ginGetBuf(state.index, blkno, ginRead, state.bufMgr)
+		if err != nil {
+			return nil, err
+		}
+		page := buf.GetPage()
+		tids = append(tids, ginDataLeafItems(page)...)
+		blkno = ginOpaque(page).rightlink
+		ginRelBuf(state.bufMgr, buf, ginRead)
+	}
+	return tids, nil
+}
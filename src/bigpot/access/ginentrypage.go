@@ -0,0 +1,322 @@
+package access
+
+import (
+	"unsafe"
+
+	"bigpot/storage"
+	"bigpot/system"
+)
+
+// The entry tree is a btree of entry tuples.  On leaf pages each tuple
+// carries the posting list or posting tree root of its key.  On internal
+// pages each tuple points to a child and holds the upper bound of the keys
+// below it.  Keys greater than every bound of an internal page, which can
+// only happen on the rightmost page of a level, belong to its last child.
+//
+// Pages are read whole, changed in memory and written back, and a page
+// that no longer fits is split in two.  The root stays at ginRootBlock;
+// when it splits, its contents move to two new pages.
+
+// The space for items on an empty GIN page.
+var ginPageSpace int
+
+func init() {
+	page := storage.NewPage(new(storage.Block))
+	page.Init(sizeOfGinPageOpaque)
+	ginPageSpace = int(page.Upper() - page.Lower())
+}
+
+// Returns copies of the tuples on the entry page.
+func ginEntryPageItems(page *storage.Page) []IndexTuple {
+	var items []IndexTuple
+	for offset := system.OffsetNumber(system.FirstOffsetNumber); offset <= page.MaxOffsetNumber(); offset++ {
+		items = append(items, IndexTuple(page.Item(page.ItemId(offset))).Copy())
+	}
+	return items
+}
+
+func ginEntryItemSize(itup IndexTuple) int {
+	return int(system.MaxAlign(uintptr(len(itup)))) + int(unsafe.Sizeof(storage.ItemId(0)))
+}
+
+func ginEntryItemsFit(items []IndexTuple) bool {
+	size := 0
+	for _, itup := range items {
+		size += ginEntryItemSize(itup)
+	}
+	return size <= ginPageSpace
+}
+
+// Rewrites the entry page with the tuples.
+func ginWriteEntryPage(state *ginState, buf storage.Buffer, flags uint16, rightlink system.BlockNumber,
+	items []IndexTuple) error {
+	page := buf.GetPage()
+	ginPageInit(page, flags)
+	ginOpaque(page).rightlink = rightlink
+	for _, itup := range items {
+		if page.AddItem(itup, system.InvalidOffsetNumber, false, false) == system.InvalidOffsetNumber {
+			return system.Elog("failed to add item to index page in \"%s\"", state.index.RelName)
+		}
+	}
+	buf.MarkDirty()
+	return nil
+}
+
+// Forms an internal tuple pointing to the child with the bound.
+func (state *ginState) formDownlink(bound ginEntryKey, child system.BlockNumber) (IndexTuple, error) {
+	itup, err := state.formTuple(bound)
+	if err != nil {
+		return nil, err
+	}
+	itup.SetTid(system.MakeItemPointer(child, system.InvalidOffsetNumber))
+	return itup, nil
+}
+
+// Returns the position of the first tuple whose key is >= the key.
+func (state *ginState) entrySearch(items []IndexTuple, key ginEntryKey) int {
+	low, high := 0, len(items)
+	for low < high {
+		mid := low + (high-low)/2
+		if state.compareEntries(state.tupleKey(items[mid]), key) < 0 {
+			low = mid + 1
+		} else {
+			high = mid
+		}
+	}
+	return low
+}
+
+// Returns the position of the downlink to follow for the key on an
+// internal page, as postgres' entryLocateEntry.  The bound of the last
+// downlink is not looked at, as it is stale on the rightmost page of a
+// level, where the keys beyond it were added.
+func (state *ginState) entryFindChild(items []IndexTuple, key ginEntryKey) int {
+	return state.entrySearch(items[:len(items)-1], key)
+}
+
+// Forms the leaf tuple of the key with the tids, moving them to a new
+// posting tree if they don't fit in the tuple, as postgres'
+// buildFreshLeafTuple.
+func ginEntryLeafTuple(state *ginState, meta *ginMetaPageData, key ginEntryKey,
+	tids []system.ItemPointer) (IndexTuple, error) {
+	itup, err := state.formLeafTuple(key, tids)
+	if err != nil || itup != nil {
+		return itup, err
+	}
+	root, err := ginCreatePostingTree(state, meta, tids)
+	if err != nil {
+		return nil, err
+	}
+	itup, err = state.formTuple(key)
+	if err != nil {
+		return nil, err
+	}
+	itup.SetTid(system.MakeItemPointer(root, ginPostingTree))
+	return itup, nil
+}
+
+// Adds the sorted tids to the entry of the key, adding the entry if there
+// is none, as postgres' ginEntryInsert.  The metapage must be locked
+// exclusively.
+func ginEntryInsert(state *ginState, meta *ginMetaPageData, key ginEntryKey, tids []system.ItemPointer) error {
+	_, err := ginEntryInsertRec(state, meta, ginRootBlock, key, tids)
+	return err
+}
+
+// ginEntrySplit tells the parent a page was split: the original page keeps
+// the keys up to leftBound, and the rest moved to the new right page.
+type ginEntrySplit struct {
+	leftBound ginEntryKey
+	right     system.BlockNumber
+}
+
+func ginEntryInsertRec(state *ginState, meta *ginMetaPageData, blkno system.BlockNumber,
+	key ginEntryKey, tids []system.ItemPointer) (*ginEntrySplit, error) {
+	buf, err := ginGetBuf(state.index, blkno, ginWrite, state.bufMgr)
+	if err != nil {
+		return nil, err
+	}
+	defer ginRelBuf(state.bufMgr, buf, ginWrite)
+	page := buf.GetPage()
+	opaque := ginOpaque(page)
+	items := ginEntryPageItems(page)
+
+	if opaque.flags&ginLeaf == 0 {
+		i := state.entryFindChild(items, key)
+		child := items[i].Tid().BlockNumber()
+		split, err := ginEntryInsertRec(state, meta, child, key, tids)
+		if err != nil || split == nil {
+			return nil, err
+		}
+		left, err := state.formDownlink(split.leftBound, child)
+		if err != nil {
+			return nil, err
+		}
+		right := items[i].Copy()
+		right.SetTid(system.MakeItemPointer(split.right, system.InvalidOffsetNumber))
+		items = append(items[:i], append([]IndexTuple{left, right}, items[i+1:]...)...)
+	} else {
+		i := state.entrySearch(items, key)
+		if i < len(items) && state.compareEntries(state.tupleKey(items[i]), key) == 0 {
+			if ginIsPostingTree(items[i]) {
+				// the entry doesn't change
+				return nil, ginInsertItemPointers(state, meta, items[i].Tid().BlockNumber(), tids)
+			}
+			merged := ginMergeItemPointers(ginReadPostingList(items[i]), tids)
+			itup, err := ginEntryLeafTuple(state, meta, key, merged)
+			if err != nil {
+				return nil, err
+			}
+			items[i] = itup
+		} else {
+			itup, err := ginEntryLeafTuple(state, meta, key, tids)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items[:i], append([]IndexTuple{itup}, items[i:]...)...)
+			meta.nEntries++
+		}
+	}
+
+	return ginEntryPlaceItems(state, meta, buf, items)
+}
+
+// Writes the tuples back to the write-locked entry page, splitting it if
+// they don't fit, as postgres' entrySplitPage.
+func ginEntryPlaceItems(state *ginState, meta *ginMetaPageData, buf storage.Buffer,
+	items []IndexTuple) (*ginEntrySplit, error) {
+	opaque := ginOpaque(buf.GetPage())
+	flags, rightlink := opaque.flags, opaque.rightlink
+	if ginEntryItemsFit(items) {
+		return nil, ginWriteEntryPage(state, buf, flags, rightlink, items)
+	}
+
+	// split at about half the size
+	total := 0
+	for _, itup := range items {
+		total += ginEntryItemSize(itup)
+	}
+	splitAt, size := 0, 0
+	for splitAt < len(items)-1 && size+ginEntryItemSize(items[splitAt]) <= total/2 {
+		size += ginEntryItemSize(items[splitAt])
+		splitAt++
+	}
+	if splitAt == 0 {
+		splitAt = 1
+	}
+	leftItems, rightItems := items[:splitAt], items[splitAt:]
+	leftBound := state.tupleKey(leftItems[len(leftItems)-1])
+
+	if buf.BlockNumber() == ginRootBlock {
+		// the root moves its contents to two new pages and points to them
+		lbuf, err := ginNewBuffer(state, meta, flags)
+		if err != nil {
+			return nil, err
+		}
+		defer ginRelBuf(state.bufMgr, lbuf, ginWrite)
+		rbuf, err := ginNewBuffer(state, meta, flags)
+		if err != nil {
+			return nil, err
+		}
+		defer ginRelBuf(state.bufMgr, rbuf, ginWrite)
+		if err := ginWriteEntryPage(state, lbuf, flags, rbuf.BlockNumber(), leftItems); err != nil {
+			return nil, err
+		}
+		if err := ginWriteEntryPage(state, rbuf, flags, system.InvalidBlockNumber, rightItems); err != nil {
+			return nil, err
+		}
+		left, err := state.formDownlink(leftBound, lbuf.BlockNumber())
+		if err != nil {
+			return nil, err
+		}
+		right, err := state.formDownlink(state.tupleKey(rightItems[len(rightItems)-1]), rbuf.BlockNumber())
+		if err != nil {
+			return nil, err
+		}
+		return nil, ginWriteEntryPage(state, buf, flags&^ginLeaf, system.InvalidBlockNumber,
+			[]IndexTuple{left, right})
+	}
+
+	rbuf, err := ginNewBuffer(state, meta, flags)
+	if err != nil {
+		return nil, err
+	}
+	defer ginRelBuf(state.bufMgr, rbuf, ginWrite)
+	if err := ginWriteEntryPage(state, rbuf, flags, rightlink, rightItems); err != nil {
+		return nil, err
+	}
+	if err := ginWriteEntryPage(state, buf, flags, rbuf.BlockNumber(), leftItems); err != nil {
+		return nil, err
+	}
+	return &ginEntrySplit{leftBound: leftBound, right: rbuf.BlockNumber()}, nil
+}
+
+// Returns a copy of the leaf tuple of the key, or nil if there is none,
+// as postgres' ginFindLeafPage followed by entryLocateLeafEntry.
+func ginEntryFind(state *ginState, key ginEntryKey) (IndexTuple, error) {
+	blkno := ginRootBlock
+	for {
+		buf, err := ginGetBuf(state.index, blkno, ginRead, state.bufMgr)
+		if err != nil {
+			return nil, err
+		}
+		page := buf.GetPage()
+		isLeaf := ginOpaque(page).flags&ginLeaf != 0
+		items := ginEntryPageItems(page)
+		ginRelBuf(state.bufMgr, buf, ginRead)
+
+		if !isLeaf {
+			blkno = items[state.entryFindChild(items, key)].Tid().BlockNumber()
+			continue
+		}
+		i := state.entrySearch(items, key)
+		if i < len(items) && state.compareEntries(state.tupleKey(items[i]), key) == 0 {
+			return items[i], nil
+		}
+		return nil, nil
+	}
+}
+
+// Calls fn with every leaf tuple of the entry tree in key order.
+func ginEntryScanAll(state *ginState, fn func(itup IndexTuple) error) error {
+	// descend to the leftmost leaf
+	blkno := ginRootBlock
+	for {
+		buf, err := ginGetBuf(state.index, blkno, ginRead, state.bufMgr)
+		if err != nil {
+			return err
+		}
+		page := buf.GetPage()
+		if ginOpaque(page).flags&ginLeaf != 0 {
+			ginRelBuf(state.bufMgr, buf, ginRead)
+			break
+		}
+		blkno = IndexTuple(page.Item(page.ItemId(system.FirstOffsetNumber))).Tid().BlockNumber()
+		ginRelBuf(state.bufMgr, buf, ginRead)
+	}
+
+	for blkno.IsValid() {
+		buf, err := ginGetBuf(state.index, blkno, ginRead, state.bufMgr)
+		if err != nil {
+			return err
+		}
+		page := buf.GetPage()
+		items := ginEntryPageItems(page)
+		blkno = ginOpaque(page).rightlink
+		ginRelBuf(state.bufMgr, buf, ginRead)
+		for _, itup := range items {
+			if err := fn(itup); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Returns the tids of the leaf tuple, from its posting list or tree.
+func ginEntryItemPointers(state *ginState, itup IndexTuple) ([]system.ItemPointer, error) {
+	if ginIsPostingTree(itup) {
+		return ginScanPostingTree(state, itup.Tid().BlockNumber())
+	}
+	return ginReadPostingList(itup), nil
+}
@@ -0,0 +1,139 @@
+package access
+
+import (
+	"bigpot/storage"
+	"bigpot/system"
+)
+
+// The pending list is a chain of list pages from meta.head to meta.tail,
+// holding entry tuples whose tid is the heap tid rather than a posting
+// list, in insertion order.  It is moved into the entry tree in bulk by
+// ginInsertCleanup.
+
+// Appends the entries of the heap tuple to the pending list, as postgres'
+// ginHeapTupleFastInsert.  The metapage must be locked exclusively.
+func ginHeapTupleFastInsert(state *ginState, meta *ginMetaPageData, entries []ginEntryKey,
+	tid system.ItemPointer) error {
+	for _, entry := range entries {
+		itup, err := state.formTuple(entry)
+		if err != nil {
+			return err
+		}
+		itup.SetTid(tid)
+		if err := ginPendingAdd(state, meta, itup); err != nil {
+			return err
+		}
+	}
+	meta.nPendingHeapTuples++
+	return nil
+}
+
+// Adds the tuple to the tail page of the pending list, or to a new tail
+// page if it is full.
+func ginPendingAdd(state *ginState, meta *ginMetaPageData, itup IndexTuple) error {
+	var buf storage.Buffer
+	if meta.tail.IsValid() {
+		var err error
+		buf, err = ginGetBuf(state.index, meta.tail, ginWrite, state.bufMgr)
+		if err != nil {
+			return err
+		}
+		if buf.GetPage().FreeSpace() < uint(system.MaxAlign(uintptr(len(itup)))) {
+			newBuf, err := ginNewBuffer(state, meta, ginList)
+			if err != nil {
+				ginRelBuf(state.bufMgr, buf, ginWrite)
+				return err
+			}
+			ginOpaque(buf.GetPage()).rightlink = newBuf.BlockNumber()
+			buf.MarkDirty()
+			ginRelBuf(state.bufMgr, buf, ginWrite)
+			buf = newBuf
+			meta.tail = buf.BlockNumber()
+			meta.nPendingPages++
+		}
+	} else {
+		var err error
+		buf, err = ginNewBuffer(state, meta, ginList)
+		if err != nil {
+			return err
+		}
+		meta.head = buf.BlockNumber()
+		meta.tail = buf.BlockNumber()
+		meta.nPendingPages = 1
+	}
+	defer ginRelBuf(state.bufMgr, buf, ginWrite)
+
+	if buf.GetPage().AddItem(itup, system.InvalidOffsetNumber, false, false) == system.InvalidOffsetNumber {
+		return system.Elog("failed to add item to index page in \"%s\"", state.index.RelName)
+	}
+	buf.MarkDirty()
+	return nil
+}
+
+// Calls fn with every tuple in the pending list, in insertion order.
+func ginScanPendingList(state *ginState, meta *ginMetaPageData, fn func(itup IndexTuple) error) error {
+	for blkno := meta.head; blkno.IsValid(); {
+		buf, err := ginGetBuf(state.index, blkno, ginRead, state.bufMgr)
+		if err != nil {
+			return err
+		}
+		page := buf.GetPage()
+		items := ginEntryPageItems(page)
+		blkno = ginOpaque(page).rightlink
+		ginRelBuf(state.bufMgr, buf, ginRead)
+		for _, itup := range items {
+			if err := fn(itup); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Moves the pending list into the entry tree, and puts its pages on the
+// free list, as postgres' ginInsertCleanup.  The metapage must be locked
+// exclusively.
+func ginInsertCleanup(state *ginState, meta *ginMetaPageData) error {
+	accum := newGinAccumulator(state)
+	err := ginScanPendingList(state, meta, func(itup IndexTuple) error {
+		accum.add([]ginEntryKey{state.tupleKey(itup)}, itup.Tid())
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if err := accum.flush(meta); err != nil {
+		return err
+	}
+
+	for blkno := meta.head; blkno.IsValid(); {
+		buf, err := ginGetBuf(state.index, blkno, ginWrite, state.bufMgr)
+		if err != nil {
+			return err
+		}
+		blkno = ginOpaque(buf.GetPage()).rightlink
+		ginFreePage(meta, buf)
+		ginRelBuf(state.bufMgr, buf, ginWrite)
+	}
+	meta.head = system.InvalidBlockNumber
+	meta.tail = system.InvalidBlockNumber
+	meta.nPendingPages = 0
+	meta.nPendingHeapTuples = 0
+	return nil
+}
+
+// Moves the pending list of the GIN index into the entry tree, as
+// postgres' gin_clean_pending_list.
+func GinCleanPendingList(index *IndexRelation, bufMgr storage.BufferManager) error {
+	state, err := initGinState(index, bufMgr)
+	if err != nil {
+		return err
+	}
+	metaBuf, meta, err := ginGetMeta(index, ginWrite, bufMgr)
+	if err != nil {
+		return err
+	}
+	defer ginRelBuf(bufMgr, metaBuf, ginWrite)
+	metaBuf.MarkDirty()
+	return ginInsertCleanup(state, meta)
+}
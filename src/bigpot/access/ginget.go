@@ -0,0 +1,164 @@
+package access
+
+import (
+	"sort"
+
+	"bigpot/system"
+)
+
+// ginItem is a heap tid a scan returns, and whether the heap tuple has to
+// be checked with the keys.
+type ginItem struct {
+	tid     system.ItemPointer
+	recheck bool
+}
+
+// Returns the items satisfying all the keys in tid order, as postgres'
+// gingetbitmap.  For each key, the tids of the entries of its query keys
+// are collected from the entry tree and the pending list, along with
+// which query keys each has, and the operator class tells which match.
+func ginGetItems(state *ginState, keys []ScanKey) ([]ginItem, error) {
+	if len(keys) == 0 {
+		return nil, system.Ereport(system.FeatureNotSupported,
+			"GIN indexes do not support whole-index scans")
+	}
+	for i := range keys {
+		if keys[i].Flags&(SkSearchNull|SkSearchNotNull) != 0 {
+			return nil, system.Ereport(system.FeatureNotSupported,
+				"GIN indexes do not support IS NULL scans")
+		}
+		if keys[i].Flags&SkIsNull != 0 {
+			// the operators are strict
+			return nil, nil
+		}
+	}
+
+	metaBuf, meta, err := ginGetMeta(state.index, ginRead, state.bufMgr)
+	if err != nil {
+		return nil, err
+	}
+	defer ginRelBuf(state.bufMgr, metaBuf, ginRead)
+
+	var result map[system.ItemPointer]bool
+	for i := range keys {
+		matches, err := ginScanKey(state, meta, &keys[i])
+		if err != nil {
+			return nil, err
+		}
+		if result == nil {
+			result = matches
+			continue
+		}
+		// all the keys must match
+		for tid, recheck := range result {
+			if keyRecheck, ok := matches[tid]; !ok {
+				delete(result, tid)
+			} else {
+				result[tid] = recheck || keyRecheck
+			}
+		}
+	}
+
+	items := make([]ginItem, 0, len(result))
+	for tid, recheck := range result {
+		items = append(items, ginItem{tid: tid, recheck: recheck})
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return ginCompareItemPointers(items[i].tid, items[j].tid) < 0
+	})
+	return items, nil
+}
+
+// Returns the tids matching the key, each with whether it needs a
+// recheck.
+func ginScanKey(state *ginState, meta *ginMetaPageData, key *ScanKey) (map[system.ItemPointer]bool, error) {
+	queryKeys, mode := state.opclass.extractQuery(key.Val, key.Strategy)
+	entries := make([]ginEntryKey, len(queryKeys))
+	for i, qkey := range queryKeys {
+		entries[i] = ginEntryKey{category: ginCatNormKey, key: qkey}
+	}
+
+	// which query keys each candidate has
+	checks := make(map[system.ItemPointer][]bool)
+	candidate := func(tid system.ItemPointer) []bool {
+		check, ok := checks[tid]
+		if !ok {
+			check = make([]bool, len(entries))
+			checks[tid] = check
+		}
+		return check
+	}
+	// Returns the query key of the entry, or -1 if none, and whether the
+	// search mode takes the items of the entry regardless.
+	classify := func(entry ginEntryKey) (int, bool) {
+		if entry.category == ginCatEmptyItem {
+			return -1, mode != ginSearchModeDefault
+		}
+		i := sort.Search(len(entries), func(i int) bool {
+			return state.compareEntries(entries[i], entry) >= 0
+		})
+		if i < len(entries) && state.compareEntries(entries[i], entry) == 0 {
+			return i, true
+		}
+		return -1, mode == ginSearchModeAll
+	}
+	addTids := func(entry ginEntryKey, tids []system.ItemPointer) {
+		i, take := classify(entry)
+		if !take {
+			return
+		}
+		for _, tid := range tids {
+			check := candidate(tid)
+			if i >= 0 {
+				check[i] = true
+			}
+		}
+	}
+
+	if mode == ginSearchModeAll {
+		err := ginEntryScanAll(state, func(itup IndexTuple) error {
+			tids, err := ginEntryItemPointers(state, itup)
+			if err == nil {
+				addTids(state.tupleKey(itup), tids)
+			}
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		lookup := entries
+		if mode == ginSearchModeIncludeEmpty {
+			lookup = append(lookup[:len(lookup):len(lookup)], ginEntryKey{category: ginCatEmptyItem})
+		}
+		for _, entry := range lookup {
+			itup, err := ginEntryFind(state, entry)
+			if err != nil {
+				return nil, err
+			} else if itup == nil {
+				continue
+			}
+			tids, err := ginEntryItemPointers(state, itup)
+			if err != nil {
+				return nil, err
+			}
+			addTids(entry, tids)
+		}
+	}
+
+	err := ginScanPendingList(state, meta, func(itup IndexTuple) error {
+		addTids(state.tupleKey(itup), []system.ItemPointer{itup.Tid()})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	matches := make(map[system.ItemPointer]bool)
+	for tid, check := range checks {
+		if match, recheck := state.opclass.consistent(key.Strategy, check); match {
+			matches[tid] = recheck
+		}
+	}
+	return matches, nil
+}
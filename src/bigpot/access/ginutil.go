@@ -0,0 +1,204 @@
+package access
+
+import (
+	"sort"
+	"unsafe"
+
+	"bigpot/storage"
+	"bigpot/system"
+)
+
+// Lock modes of GIN buffers.
+const (
+	ginRead  = 1
+	ginWrite = 2
+)
+
+func ginGetBuf(index *IndexRelation, blkno system.BlockNumber, access int,
+	bufMgr storage.BufferManager) (storage.Buffer, error) {
+	buf, err := bufMgr.ReadBuffer(index.RelNode, blkno)
+	if err != nil {
+		return storage.InvalidBuffer(), err
+	}
+	if access == ginWrite {
+		buf.Lock()
+	} else {
+		buf.RLock()
+	}
+	return buf, nil
+}
+
+func ginRelBuf(bufMgr storage.BufferManager, buf storage.Buffer, access int) {
+	if access == ginWrite {
+		buf.Unlock()
+	} else {
+		buf.RUnlock()
+	}
+	bufMgr.ReleaseBuffer(buf)
+}
+
+// Initializes a GIN page with the flags, as postgres' GinInitPage.
+func ginPageInit(page *storage.Page, flags uint16) {
+	page.Init(sizeOfGinPageOpaque)
+	opaque := ginOpaque(page)
+	opaque.rightlink = system.InvalidBlockNumber
+	opaque.maxoff = 0
+	opaque.flags = flags
+}
+
+// Returns a write-locked page initialized with the flags, reusing a page
+// on the free list if there is one, as postgres' GinNewBuffer.  The
+// metapage must be locked exclusively.
+func ginNewBuffer(state *ginState, meta *ginMetaPageData, flags uint16) (storage.Buffer, error) {
+	blkno := meta.firstFree
+	if !blkno.IsValid() {
+		blkno = storage.NewBlock
+	}
+	buf, err := ginGetBuf(state.index, blkno, ginWrite, state.bufMgr)
+	if err != nil {
+		return storage.InvalidBuffer(), err
+	}
+	if blkno != storage.NewBlock {
+		meta.firstFree = ginOpaque(buf.GetPage()).rightlink
+	}
+	ginPageInit(buf.GetPage(), flags)
+	buf.MarkDirty()
+	return buf, nil
+}
+
+// Puts the write-locked page on the free list.  The metapage must be
+// locked exclusively.
+func ginFreePage(meta *ginMetaPageData, buf storage.Buffer) {
+	page := buf.GetPage()
+	ginPageInit(page, ginDeleted)
+	ginOpaque(page).rightlink = meta.firstFree
+	meta.firstFree = buf.BlockNumber()
+	buf.MarkDirty()
+}
+
+// Initializes the metapage and the empty root of the entry tree, as
+// postgres' GinInitMetabuffer.
+func ginInitMetaPage(index *IndexRelation, bufMgr storage.BufferManager) error {
+	metaBuf, err := ginGetBuf(index, storage.NewBlock, ginWrite, bufMgr)
+	if err != nil {
+		return err
+	}
+	defer ginRelBuf(bufMgr, metaBuf, ginWrite)
+	if metaBuf.BlockNumber() != ginMetaBlock {
+		return system.Elog("index \"%s\" is not empty", index.RelName)
+	}
+
+	page := metaBuf.GetPage()
+	ginPageInit(page, ginMetaPage)
+	meta := ginMeta(page)
+	meta.magic = ginMagic
+	meta.version = ginVersion
+	meta.head = system.InvalidBlockNumber
+	meta.tail = system.InvalidBlockNumber
+	meta.nPendingPages = 0
+	meta.nPendingHeapTuples = 0
+	meta.firstFree = system.InvalidBlockNumber
+	meta.nEntries = 0
+	page.SetLower(page.ContentsOffset(unsafe.Sizeof(ginMetaPageData{})))
+	metaBuf.MarkDirty()
+
+	rootBuf, err := ginGetBuf(index, storage.NewBlock, ginWrite, bufMgr)
+	if err != nil {
+		return err
+	}
+	ginPageInit(rootBuf.GetPage(), ginLeaf)
+	rootBuf.MarkDirty()
+	ginRelBuf(bufMgr, rootBuf, ginWrite)
+	return nil
+}
+
+// Returns the metapage locked in the access mode.
+func ginGetMeta(index *IndexRelation, access int,
+	bufMgr storage.BufferManager) (storage.Buffer, *ginMetaPageData, error) {
+	buf, err := ginGetBuf(index, ginMetaBlock, access, bufMgr)
+	if err != nil {
+		return storage.InvalidBuffer(), nil, err
+	}
+	page := buf.GetPage()
+	meta := ginMeta(page)
+	if ginOpaque(page).flags&ginMetaPage == 0 || meta.magic != ginMagic {
+		ginRelBuf(bufMgr, buf, access)
+		return storage.InvalidBuffer(), nil, system.Elog("index \"%s\" is not a GIN index", index.RelName)
+	}
+	if meta.version != ginVersion {
+		ginRelBuf(bufMgr, buf, access)
+		return storage.InvalidBuffer(), nil, system.Elog(
+			"index \"%s\" has wrong GIN version", index.RelName)
+	}
+	return buf, meta, nil
+}
+
+// ginAccumulator collects the tids of each key in memory, as postgres'
+// BuildAccumulator.
+type ginAccumulator struct {
+	state   *ginState
+	entries []ginEntryKey
+	tids    [][]system.ItemPointer
+}
+
+func newGinAccumulator(state *ginState) *ginAccumulator {
+	return &ginAccumulator{state: state}
+}
+
+// Adds the tid to the entries.  Tids are expected in increasing order, as
+// a heap scan returns them; others are sorted when flushed.
+func (accum *ginAccumulator) add(entries []ginEntryKey, tid system.ItemPointer) {
+	for _, entry := range entries {
+		i := accum.search(entry)
+		if i < len(accum.entries) && accum.state.compareEntries(accum.entries[i], entry) == 0 {
+			accum.tids[i] = append(accum.tids[i], tid)
+			continue
+		}
+		accum.entries = append(accum.entries, ginEntryKey{})
+		copy(accum.entries[i+1:], accum.entries[i:])
+		accum.entries[i] = entry
+		accum.tids = append(accum.tids, nil)
+		copy(accum.tids[i+1:], accum.tids[i:])
+		accum.tids[i] = []system.ItemPointer{tid}
+	}
+}
+
+// Returns the position of the first entry >= the key.
+func (accum *ginAccumulator) search(key ginEntryKey) int {
+	low, high := 0, len(accum.entries)
+	for low < high {
+		mid := low + (high-low)/2
+		if accum.state.compareEntries(accum.entries[mid], key) < 0 {
+			low = mid + 1
+		} else {
+			high = mid
+		}
+	}
+	return low
+}
+
+// Inserts the accumulated entries into the entry tree in key order.  The
+// metapage must be locked exclusively.
+func (accum *ginAccumulator) flush(meta *ginMetaPageData) error {
+	for i, entry := range accum.entries {
+		if err := ginEntryInsert(accum.state, meta, entry, ginSortItemPointers(accum.tids[i])); err != nil {
+			return err
+		}
+	}
+	accum.entries = nil
+	accum.tids = nil
+	return nil
+}
+
+// Sorts the tids in place unless they are sorted already.
+func ginSortItemPointers(tids []system.ItemPointer) []system.ItemPointer {
+	for i := 1; i < len(tids); i++ {
+		if ginCompareItemPointers(tids[i-1], tids[i]) > 0 {
+			sort.Slice(tids, func(i, j int) bool {
+				return ginCompareItemPointers(tids[i], tids[j]) < 0
+			})
+			break
+		}
+	}
+	return tids
+}
@@ -0,0 +1,213 @@
+package access
+
+import (
+	"math/bits"
+	"unsafe"
+
+	"bigpot/storage"
+	"bigpot/system"
+)
+
+// The hash access method, as postgres' hash indexes: linear hashing of the
+// key's hash code into buckets, which split one at a time as the index
+// grows.  Block 0 is the metapage, holding the bucket masks.  The number
+// of buckets doubles in splitpoints, and the primary pages of the buckets
+// of a splitpoint are allocated together when its first bucket is; the
+// metapage records the overflow pages before each splitpoint, from which
+// the block of a bucket is computed.  A bucket that outgrows its primary
+// page chains overflow pages to it.  Entries store only the hash code, kept in
+// order on each page, so an index scan has to recheck its keys against
+// the heap tuples.
+//
+// Unlike postgres, which locks buckets, an insert holds the metapage lock
+// exclusively throughout, and a scan holds it shared while it collects the
+// entries of the bucket.  NULLs are not indexed, and only single-column
+// indexes are supported.
+type hash struct{}
+
+// The hash IndexAm.
+var HashAm IndexAm = hash{}
+
+// hashPageOpaque is kept in the special space of every hash page except
+// the metapage, as postgres' HashPageOpaqueData.
+type hashPageOpaque struct {
+	prev   system.BlockNumber // previous page in the bucket chain
+	next   system.BlockNumber // next page in the bucket chain
+	bucket uint32             // bucket number this page belongs to
+	flags  uint16
+	pageId uint16 // for identification of hash indexes
+}
+
+// hashPageOpaque flags
+const (
+	lhUnusedPage   = 0
+	lhOverflowPage = 1 << 0
+	lhBucketPage   = 1 << 1
+	lhMetaPage     = 1 << 3
+)
+
+const hashoPageId = 0xFF80
+
+// The number of splitpoints, as postgres' HASH_MAX_SPLITPOINTS: one for
+// bucket 0, and one for each bit of the bucket number.
+const hashMaxSplitpoints = 33
+
+// hashMetaPageData is kept in the contents of the metapage, as postgres'
+// HashMetaPageData.
+type hashMetaPageData struct {
+	magic     uint32
+	version   uint32
+	ntuples   uint32 // number of tuples in the index
+	ffactor   uint32 // target fill factor (tuples/bucket)
+	maxbucket uint32 // ID of maximum bucket in use
+	highmask  uint32 // mask to modulo into entire table
+	lowmask   uint32 // mask to modulo into lower half of table
+	firstfree system.BlockNumber
+	// the number of overflow pages before the buckets of each
+	// splitpoint, as postgres' hashm_spares
+	spares [hashMaxSplitpoints]uint32
+}
+
+const (
+	hashMetaBlock = system.BlockNumber(0)
+	hashMagic     = 0x6440640
+	hashVersion   = 2
+)
+
+var sizeOfHashPageOpaque = unsafe.Sizeof(hashPageOpaque{})
+
+// Hash index entries have a single column, the hash code.
+var hashKeyTupleDesc = &TupleDesc{
+	Attrs: []*Attribute{
+		{
+			Name:   "hashkey",
+			TypeId: system.Int4Type,
+		},
+	},
+}
+
+func init() {
+	initTupleDesc(hashKeyTupleDesc)
+}
+
+func hashOpaque(page *storage.Page) *hashPageOpaque {
+	special := page.SpecialSpace()
+	return (*hashPageOpaque)(unsafe.Pointer(&special[0]))
+}
+
+func hashMeta(page *storage.Page) *hashMetaPageData {
+	contents := page.Contents()
+	return (*hashMetaPageData)(unsafe.Pointer(&contents[0]))
+}
+
+// Maps the hash code to a bucket, as postgres' _hash_hashkey2bucket.
+func hashKey2Bucket(hashcode, maxbucket, highmask, lowmask uint32) uint32 {
+	bucket := hashcode & highmask
+	if bucket > maxbucket {
+		bucket = bucket & lowmask
+	}
+	return bucket
+}
+
+// Returns the splitpoint the bucket belongs to, as postgres'
+// _hash_spareindex: bucket 0 is of splitpoint 0, and the buckets from
+// 2^(i-1) to 2^i-1 are of splitpoint i.
+func hashSpareIndex(bucket uint32) int {
+	return bits.Len32(bucket)
+}
+
+// Returns the block of the primary page of the bucket, as postgres'
+// BUCKET_TO_BLKNO: after the metapage, the buckets before it, and the
+// overflow pages allocated before its splitpoint.
+func hashBucketToBlkno(meta *hashMetaPageData, bucket uint32) system.BlockNumber {
+	return system.BlockNumber(1 + bucket + meta.spares[hashSpareIndex(bucket)])
+}
+
+// Returns the hash function of the index column.
+func hashGetProc(index *IndexRelation) (system.HashFunc, error) {
+	if len(index.RelDesc.Attrs) != 1 {
+		return nil, system.Ereport(system.FeatureNotSupported,
+			"access method \"hash\" does not support multicolumn indexes")
+	}
+	return system.LookupHash(index.RelDesc.Attrs[0].TypeId)
+}
+
+// Forms the entry of the hash code.
+func hashFormTuple(hashcode uint32, tid system.ItemPointer) IndexTuple {
+	itup, _ := FormIndexTuple([]system.Datum{system.Int4(int32(hashcode))}, hashKeyTupleDesc)
+	itup.SetTid(tid)
+	return itup
+}
+
+func hashGetKey(itup IndexTuple) uint32 {
+	return uint32(itup.Fetch(1, hashKeyTupleDesc).(system.Int4))
+}
+
+// Returns the offset of the first item on the page whose hash code is >=
+// hashcode, as postgres' _hash_binsearch.
+func hashBinSearch(page *storage.Page, hashcode uint32) system.OffsetNumber {
+	low := system.OffsetNumber(system.FirstOffsetNumber)
+	high := page.MaxOffsetNumber() + 1
+	for high > low {
+		mid := low + (high-low)/2
+		if hashGetKey(IndexTuple(page.Item(page.ItemId(mid)))) < hashcode {
+			low = mid + 1
+		} else {
+			high = mid
+		}
+	}
+	return low
+}
+
+// Implements IndexAm.Build.
+func (h hash) Build(heap *HeapRelation, index *IndexRelation, bufMgr storage.BufferManager) error {
+	if _, err := hashGetProc(index); err != nil {
+		return err
+	}
+	if err := hashInitMetaPage(index, bufMgr); err != nil {
+		return err
+	}
+	return indexBuildHeapScan(heap, index, bufMgr,
+		func(values []system.Datum, tid system.ItemPointer) error {
+			return h.Insert(index, values, tid, heap, bufMgr)
+		})
+}
+
+// Implements IndexAm.Insert.  Hash indexes can't be unique.
+func (h hash) Insert(index *IndexRelation, values []system.Datum, tid system.ItemPointer,
+	heap *HeapRelation, bufMgr storage.BufferManager) error {
+	if index.Unique {
+		return system.Ereport(system.FeatureNotSupported,
+			"access method \"hash\" does not support unique indexes")
+	}
+	proc, err := hashGetProc(index)
+	if err != nil {
+		return err
+	}
+	if values[0] == nil {
+		// NULLs are not indexed
+		return nil
+	}
+	return hashDoInsert(index, hashFormTuple(proc(values[0]), tid), bufMgr)
+}
+
+// Implements IndexAm.BeginScan.
+func (h hash) BeginScan(index *IndexRelation, keys []ScanKey, bufMgr storage.BufferManager) (IndexScanDesc, error) {
+	proc, err := hashGetProc(index)
+	if err != nil {
+		return nil, err
+	}
+	for i := range keys {
+		if keys[i].Strategy != BTEqualStrategyNumber || keys[i].Flags&(SkSearchNull|SkSearchNotNull) != 0 {
+			return nil, system.Ereport(system.FeatureNotSupported,
+				"hash indexes support only equality scans")
+		}
+	}
+	scan := &hashScan{
+		index:  index,
+		keys:   keys,
+		proc:   proc,
+		bufMgr: bufMgr,
+	}
+	return scan, nil
+}
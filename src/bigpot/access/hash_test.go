@@ -0,0 +1,154 @@
+package access
+
+import (
+	"fmt"
+	. "launchpad.net/gocheck"
+	"os"
+
+	"bigpot/system"
+)
+
+func (s *MySuite) TestHashBuildAndScan(c *C) {
+	os.MkdirAll("base/1", 0700)
+	defer os.RemoveAll("base")
+	defer os.RemoveAll("global")
+	bufMgr := newBufferManager(c, 64)
+
+	const nrows = 5000
+	var rows [][]system.Datum
+	for i := 0; i < nrows; i++ {
+		rows = append(rows, []system.Datum{system.Int4(i), system.Name(fmt.Sprintf("name%05d", i))})
+	}
+	heap := makeTestHeap(c, bufMgr, 20000, rows)
+	index := makeTestIndex(c, heap, 20001, 2, false)
+	index.Am = HashAm
+	c.Assert(index.Build(heap, bufMgr), IsNil)
+
+	// the buckets have split as the index grew
+	metaBuf, meta, err := hashGetMeta(index, hashRead, bufMgr)
+	c.Assert(err, IsNil)
+	c.Check(meta.ntuples, Equals, uint32(nrows))
+	c.Check(meta.maxbucket+1 >= nrows/meta.ffactor, Equals, true)
+	c.Check(meta.highmask, Equals, meta.lowmask<<1|1)
+	hashRelBuf(bufMgr, metaBuf, hashRead)
+
+	lookup := func(name string) []system.Datum {
+		key, err := MakeScanKey(1, BTEqualStrategyNumber, system.NameType, system.NameType, system.Name(name))
+		c.Assert(err, IsNil)
+		return indexScanAll(c, heap, index, []ScanKey{key}, ForwardScanDirection, 1, bufMgr)
+	}
+	for _, i := range []int{0, 1, 17, 2500, 4999} {
+		c.Check(lookup(fmt.Sprintf("name%05d", i)), DeepEquals, []system.Datum{system.Int4(i)})
+	}
+	c.Check(lookup("name99999"), HasLen, 0)
+
+	// inserts after the build go to the bucket of the current masks
+	tid := system.MakeItemPointer(0, 1)
+	c.Assert(index.Insert([]system.Datum{system.Name("name00000")}, tid, heap, bufMgr), IsNil)
+	c.Check(lookup("name00000"), DeepEquals, []system.Datum{system.Int4(0), system.Int4(0)})
+
+	// an entry whose heap tuple doesn't have the key is filtered out by
+	// the recheck
+	c.Assert(index.Insert([]system.Datum{system.Name("name00003")}, tid, heap, bufMgr), IsNil)
+	c.Check(lookup("name00003"), DeepEquals, []system.Datum{system.Int4(3)})
+}
+
+func (s *MySuite) TestHashDuplicates(c *C) {
+	os.MkdirAll("base/1", 0700)
+	defer os.RemoveAll("base")
+	defer os.RemoveAll("global")
+	bufMgr := newBufferManager(c, 16)
+
+	// runs of equal keys longer than a page, and NULLs that aren't indexed
+	var rows [][]system.Datum
+	for i := 0; i < 3000; i++ {
+		id := system.Datum(system.Int4(i % 10))
+		if i%7 == 0 {
+			id = nil
+		}
+		rows = append(rows, []system.Datum{id, system.Name(fmt.Sprintf("%d", i))})
+	}
+	heap := makeTestHeap(c, bufMgr, 20000, rows)
+	index := makeTestIndex(c, heap, 20001, 1, false)
+	index.Am = HashAm
+	c.Assert(index.Build(heap, bufMgr), IsNil)
+
+	metaBuf, meta, err := hashGetMeta(index, hashRead, bufMgr)
+	c.Assert(err, IsNil)
+	c.Check(meta.ntuples, Equals, uint32(3000-429))
+	hashRelBuf(bufMgr, metaBuf, hashRead)
+
+	key := func(id system.Datum) ScanKey {
+		key, err := MakeScanKey(1, BTEqualStrategyNumber, system.Int4Type, system.Int4Type, id)
+		c.Assert(err, IsNil)
+		return key
+	}
+	for _, dir := range []ScanDirection{ForwardScanDirection, BackwardScanDirection} {
+		ids := indexScanAll(c, heap, index, []ScanKey{key(system.Int4(4))}, dir, 1, bufMgr)
+		c.Check(ids, HasLen, 257)
+		for _, id := range ids {
+			c.Assert(id, Equals, system.Datum(system.Int4(4)))
+		}
+	}
+	c.Check(indexScanAll(c, heap, index, []ScanKey{key(nil)}, ForwardScanDirection, 1, bufMgr), HasLen, 0)
+
+	_, err = IndexBeginScan(heap, index, []ScanKey{MakeNullScanKey(1, true)}, bufMgr)
+	c.Check(err, ErrorMatches, "hash indexes support only equality scans")
+	lt, _ := MakeScanKey(1, BTLessStrategyNumber, system.Int4Type, system.Int4Type, system.Int4(1))
+	_, err = IndexBeginScan(heap, index, []ScanKey{lt}, bufMgr)
+	c.Check(err, ErrorMatches, "hash indexes support only equality scans")
+
+	scan, err := IndexBeginScan(heap, index, nil, bufMgr)
+	c.Assert(err, IsNil)
+	_, err = scan.Next()
+	c.Check(err, ErrorMatches, "hash indexes do not support whole-index scans")
+
+	index.Unique = true
+	err = index.Insert([]system.Datum{system.Int4(1)}, system.MakeItemPointer(0, 1), heap, bufMgr)
+	c.Check(err, ErrorMatches, "access method \"hash\" does not support unique indexes")
+}
+
+func (s *MySuite) TestHashManySplitpoints(c *C) {
+	os.MkdirAll("base/1", 0700)
+	defer os.RemoveAll("base")
+	defer os.RemoveAll("global")
+	bufMgr := newBufferManager(c, 64)
+
+	const nrows = 100000
+	var rows [][]system.Datum
+	for i := 0; i < nrows; i++ {
+		rows = append(rows, []system.Datum{system.Int4(i), system.Name(fmt.Sprintf("name%06d", i))})
+	}
+	heap := makeTestHeap(c, bufMgr, 20000, rows)
+	index := makeTestIndex(c, heap, 20001, 1, false)
+	index.Am = HashAm
+	c.Assert(index.Build(heap, bufMgr), IsNil)
+
+	// the buckets have kept splitting through many splitpoints
+	metaBuf, meta, err := hashGetMeta(index, hashRead, bufMgr)
+	c.Assert(err, IsNil)
+	c.Check(nrows > 512*meta.ffactor, Equals, true)
+	c.Check(meta.maxbucket+1 >= nrows/meta.ffactor, Equals, true)
+	c.Check(meta.maxbucket >= 512, Equals, true)
+	// every bucket is at the block the spares map it to
+	for bucket := uint32(0); bucket <= meta.maxbucket; bucket++ {
+		buf, err := hashGetBuf(index, hashBucketToBlkno(meta, bucket), hashRead, bufMgr)
+		c.Assert(err, IsNil)
+		opaque := hashOpaque(buf.GetPage())
+		c.Check(opaque.flags, Equals, uint16(lhBucketPage))
+		c.Check(opaque.bucket, Equals, bucket)
+		hashRelBuf(bufMgr, buf, hashRead)
+	}
+	hashRelBuf(bufMgr, metaBuf, hashRead)
+
+	lookup := func(id int) []system.Datum {
+		key, err := MakeScanKey(1, BTEqualStrategyNumber, system.Int4Type, system.Int4Type, system.Int4(id))
+		c.Assert(err, IsNil)
+		return indexScanAll(c, heap, index, []ScanKey{key}, ForwardScanDirection, 2, bufMgr)
+	}
+	for i := 0; i < nrows; i += 997 {
+		c.Check(lookup(i), DeepEquals, []system.Datum{system.Name(fmt.Sprintf("name%06d", i))})
+	}
+	c.Check(lookup(nrows-1), DeepEquals, []system.Datum{system.Name(fmt.Sprintf("name%06d", nrows-1))})
+	c.Check(lookup(nrows), HasLen, 0)
+}
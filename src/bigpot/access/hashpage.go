@@ -0,0 +1,298 @@
+package access
+
+import (
+	"unsafe"
+
+	"bigpot/storage"
+	"bigpot/system"
+)
+
+// Lock modes of hash buffers.
+const (
+	hashRead  = 1
+	hashWrite = 2
+)
+
+func hashGetBuf(index *IndexRelation, blkno system.BlockNumber, access int,
+	bufMgr storage.BufferManager) (storage.Buffer, error) {
+	buf, err := bufMgr.ReadBuffer(index.RelNode, blkno)
+	if err != nil {
+		return storage.InvalidBuffer(), err
+	}
+	if access == hashWrite {
+		buf.Lock()
+	} else {
+		buf.RLock()
+	}
+	return buf, nil
+}
+
+func hashRelBuf(bufMgr storage.BufferManager, buf storage.Buffer, access int) {
+	if access == hashWrite {
+		buf.Unlock()
+	} else {
+		buf.RUnlock()
+	}
+	bufMgr.ReleaseBuffer(buf)
+}
+
+// Initializes a hash page with the opaque data.
+func hashPageInit(page *storage.Page, bucket uint32, flags uint16) {
+	page.Init(sizeOfHashPageOpaque)
+	opaque := hashOpaque(page)
+	opaque.prev = system.InvalidBlockNumber
+	opaque.next = system.InvalidBlockNumber
+	opaque.bucket = bucket
+	opaque.flags = flags
+	opaque.pageId = hashoPageId
+}
+
+// Initializes the metapage and the first two buckets of an empty index,
+// as postgres' _hash_metapinit.
+func hashInitMetaPage(index *IndexRelation, bufMgr storage.BufferManager) error {
+	metaBuf, err := hashGetBuf(index, storage.NewBlock, hashWrite, bufMgr)
+	if err != nil {
+		return err
+	}
+	defer hashRelBuf(bufMgr, metaBuf, hashWrite)
+	if metaBuf.BlockNumber() != hashMetaBlock {
+		return system.Elog("index \"%s\" is not empty", index.RelName)
+	}
+
+	page := metaBuf.GetPage()
+	hashPageInit(page, 0, lhMetaPage)
+	meta := hashMeta(page)
+	meta.magic = hashMagic
+	meta.version = hashVersion
+	meta.ntuples = 0
+
+	// Aim for 75% full buckets.
+	itemSize := uint(system.MaxAlign(uintptr(len(hashFormTuple(0, system.InvalidItemPointer))))) +
+		uint(unsafe.Sizeof(storage.ItemId(0)))
+	empty := storage.NewPage(new(storage.Block))
+	empty.Init(sizeOfHashPageOpaque)
+	meta.ffactor = uint32(empty.FreeSpace() * 75 / 100 / itemSize)
+
+	// We start with two buckets, 0 and 1.
+	meta.maxbucket = 1
+	meta.highmask = 3
+	meta.lowmask = 1
+	meta.firstfree = system.InvalidBlockNumber
+	for bucket := uint32(0); bucket <= meta.maxbucket; bucket++ {
+		if err := hashAllocBuckets(index, meta, bucket, bufMgr); err != nil {
+			return err
+		}
+	}
+
+	page.SetLower(page.ContentsOffset(unsafe.Sizeof(hashMetaPageData{})))
+	metaBuf.MarkDirty()
+	return nil
+}
+
+// Allocates the primary pages of the buckets of the splitpoint that
+// starts with firstBucket, at the end of the index, as postgres'
+// _hash_alloc_buckets.  The overflow pages allocated so far are recorded
+// in the spares of the splitpoint.  The pages are initialized as empty
+// buckets, which the masks map nothing to until they are split into.
+func hashAllocBuckets(index *IndexRelation, meta *hashMetaPageData, firstBucket uint32,
+	bufMgr storage.BufferManager) error {
+	// a splitpoint doubles the buckets, but for bucket 0
+	nbuckets := firstBucket
+	if nbuckets == 0 {
+		nbuckets = 1
+	}
+	for i := uint32(0); i < nbuckets; i++ {
+		buf, err := hashGetBuf(index, storage.NewBlock, hashWrite, bufMgr)
+		if err != nil {
+			return err
+		}
+		if i == 0 {
+			meta.spares[hashSpareIndex(firstBucket)] = uint32(buf.BlockNumber()) - 1 - firstBucket
+		}
+		hashPageInit(buf.GetPage(), firstBucket+i, lhBucketPage)
+		buf.MarkDirty()
+		hashRelBuf(bufMgr, buf, hashWrite)
+	}
+	return nil
+}
+
+// Returns the metapage locked in the access mode.
+func hashGetMeta(index *IndexRelation, access int,
+	bufMgr storage.BufferManager) (storage.Buffer, *hashMetaPageData, error) {
+	buf, err := hashGetBuf(index, hashMetaBlock, access, bufMgr)
+	if err != nil {
+		return storage.InvalidBuffer(), nil, err
+	}
+	page := buf.GetPage()
+	meta := hashMeta(page)
+	if hashOpaque(page).flags&lhMetaPage == 0 || meta.magic != hashMagic {
+		hashRelBuf(bufMgr, buf, access)
+		return storage.InvalidBuffer(), nil, system.Elog("index \"%s\" is not a hash index", index.RelName)
+	}
+	if meta.version != hashVersion {
+		hashRelBuf(bufMgr, buf, access)
+		return storage.InvalidBuffer(), nil, system.Elog(
+			"index \"%s\" has wrong hash version", index.RelName)
+	}
+	return buf, meta, nil
+}
+
+// Inserts the entry into the index, and splits a bucket if the index
+// is fuller than the fill factor, as postgres' _hash_doinsert.
+func hashDoInsert(index *IndexRelation, itup IndexTuple, bufMgr storage.BufferManager) error {
+	metaBuf, meta, err := hashGetMeta(index, hashWrite, bufMgr)
+	if err != nil {
+		return err
+	}
+	defer hashRelBuf(bufMgr, metaBuf, hashWrite)
+
+	bucket := hashKey2Bucket(hashGetKey(itup), meta.maxbucket, meta.highmask, meta.lowmask)
+	if err := hashAddTuple(index, meta, bucket, itup, bufMgr); err != nil {
+		return err
+	}
+	meta.ntuples++
+	metaBuf.MarkDirty()
+
+	if meta.ntuples > meta.ffactor*(meta.maxbucket+1) {
+		return hashExpandTable(index, meta, bufMgr)
+	}
+	return nil
+}
+
+// Adds the entry to the first page of the bucket chain with room for it,
+// chaining a new overflow page if none has.  The metapage must be locked
+// exclusively.
+func hashAddTuple(index *IndexRelation, meta *hashMetaPageData, bucket uint32, itup IndexTuple,
+	bufMgr storage.BufferManager) error {
+	itemSize := uint(system.MaxAlign(uintptr(len(itup))))
+	blkno := hashBucketToBlkno(meta, bucket)
+	for {
+		buf, err := hashGetBuf(index, blkno, hashWrite, bufMgr)
+		if err != nil {
+			return err
+		}
+		page := buf.GetPage()
+		opaque := hashOpaque(page)
+
+		if page.FreeSpace() < itemSize {
+			if opaque.next.IsValid() {
+				blkno = opaque.next
+				hashRelBuf(bufMgr, buf, hashWrite)
+				continue
+			}
+			// end of the chain; add an overflow page
+			obuf, err := hashAddOvflPage(index, meta, buf, bufMgr)
+			hashRelBuf(bufMgr, buf, hashWrite)
+			if err != nil {
+				return err
+			}
+			buf = obuf
+			page = buf.GetPage()
+		}
+
+		offset := hashBinSearch(page, hashGetKey(itup))
+		if page.AddItem(itup, offset, false, false) == system.InvalidOffsetNumber {
+			hashRelBuf(bufMgr, buf, hashWrite)
+			return system.Elog("failed to add index item to \"%s\"", index.RelName)
+		}
+		buf.MarkDirty()
+		hashRelBuf(bufMgr, buf, hashWrite)
+		return nil
+	}
+}
+
+// Chains an overflow page to the write-locked last page of a bucket, as
+// postgres' _hash_addovflpage.  A freed overflow page is reused if there
+// is one.  The new page is returned write-locked.
+func hashAddOvflPage(index *IndexRelation, meta *hashMetaPageData, buf storage.Buffer,
+	bufMgr storage.BufferManager) (storage.Buffer, error) {
+	blkno := meta.firstfree
+	if !blkno.IsValid() {
+		blkno = storage.NewBlock
+	}
+	obuf, err := hashGetBuf(index, blkno, hashWrite, bufMgr)
+	if err != nil {
+		return storage.InvalidBuffer(), err
+	}
+	if blkno != storage.NewBlock {
+		meta.firstfree = hashOpaque(obuf.GetPage()).next
+	}
+
+	opaque := hashOpaque(buf.GetPage())
+	hashPageInit(obuf.GetPage(), opaque.bucket, lhOverflowPage)
+	hashOpaque(obuf.GetPage()).prev = buf.BlockNumber()
+	opaque.next = obuf.BlockNumber()
+	buf.MarkDirty()
+	obuf.MarkDirty()
+	return obuf, nil
+}
+
+// Puts the write-locked overflow page on the free list, as postgres'
+// _hash_freeovflpage.  The caller unlinks it from the bucket chain.
+func hashFreeOvflPage(meta *hashMetaPageData, buf storage.Buffer) {
+	page := buf.GetPage()
+	hashPageInit(page, 0, lhUnusedPage)
+	hashOpaque(page).next = meta.firstfree
+	meta.firstfree = buf.BlockNumber()
+	buf.MarkDirty()
+}
+
+// Adds a bucket, moving to it the entries of the bucket it splits from, as
+// postgres' _hash_expandtable.  The first bucket of a splitpoint
+// allocates the pages of the splitpoint.  The metapage must be locked
+// exclusively.
+func hashExpandTable(index *IndexRelation, meta *hashMetaPageData, bufMgr storage.BufferManager) error {
+	newBucket := meta.maxbucket + 1
+	oldBucket := newBucket & meta.lowmask
+
+	if newBucket&(newBucket-1) == 0 {
+		if err := hashAllocBuckets(index, meta, newBucket, bufMgr); err != nil {
+			return err
+		}
+	}
+	meta.maxbucket = newBucket
+	if newBucket > meta.highmask {
+		// starting a new doubling
+		meta.lowmask = meta.highmask
+		meta.highmask = newBucket | meta.lowmask
+	}
+
+	return hashSplitBucket(index, meta, oldBucket, bufMgr)
+}
+
+// Redistributes the entries of the old bucket between it and the new
+// bucket the masks now map some of them to, as postgres'
+// _hash_splitbucket.  The old bucket chain is emptied, its overflow
+// pages are freed, and every entry is added again.
+func hashSplitBucket(index *IndexRelation, meta *hashMetaPageData, oldBucket uint32,
+	bufMgr storage.BufferManager) error {
+	var tuples []IndexTuple
+	primary := hashBucketToBlkno(meta, oldBucket)
+	blkno := primary
+	for blkno.IsValid() {
+		buf, err := hashGetBuf(index, blkno, hashWrite, bufMgr)
+		if err != nil {
+			return err
+		}
+		page := buf.GetPage()
+		for offset := system.OffsetNumber(system.FirstOffsetNumber); offset <= page.MaxOffsetNumber(); offset++ {
+			tuples = append(tuples, IndexTuple(page.Item(page.ItemId(offset))).Copy())
+		}
+		blkno = hashOpaque(page).next
+		if buf.BlockNumber() == primary {
+			hashPageInit(page, oldBucket, lhBucketPage)
+			buf.MarkDirty()
+		} else {
+			hashFreeOvflPage(meta, buf)
+		}
+		hashRelBuf(bufMgr, buf, hashWrite)
+	}
+
+	for _, itup := range tuples {
+		bucket := hashKey2Bucket(hashGetKey(itup), meta.maxbucket, meta.highmask, meta.lowmask)
+		if err := hashAddTuple(index, meta, bucket, itup, bufMgr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
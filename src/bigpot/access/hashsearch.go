@@ -0,0 +1,102 @@
+package access
+
+import (
+	"bigpot/storage"
+	"bigpot/system"
+)
+
+// hashScan implements IndexScanDesc for hash indexes.  The entries of the
+// bucket with the matching hash code are collected at once, under the
+// shared metapage lock so that no split moves them meanwhile.
+type hashScan struct {
+	index   *IndexRelation
+	keys    []ScanKey
+	proc    system.HashFunc
+	bufMgr  storage.BufferManager
+	started bool
+	items   []system.ItemPointer
+	// next item to return in each direction
+	itemIndex int
+}
+
+// Implements IndexScanDesc.Next.
+func (scan *hashScan) Next(dir ScanDirection) (system.ItemPointer, error) {
+	if !scan.started {
+		scan.started = true
+		if err := scan.first(); err != nil {
+			return system.InvalidItemPointer, err
+		}
+		if dir == BackwardScanDirection {
+			scan.itemIndex = len(scan.items) - 1
+		}
+	}
+
+	if scan.itemIndex < 0 || scan.itemIndex >= len(scan.items) {
+		return system.InvalidItemPointer, nil
+	}
+	tid := scan.items[scan.itemIndex]
+	if dir == BackwardScanDirection {
+		scan.itemIndex--
+	} else {
+		scan.itemIndex++
+	}
+	return tid, nil
+}
+
+// Collects the entries with the hash code of the key, as postgres'
+// _hash_first.
+func (scan *hashScan) first() error {
+	scan.items = nil
+	scan.itemIndex = 0
+	if len(scan.keys) == 0 {
+		return system.Ereport(system.FeatureNotSupported,
+			"hash indexes do not support whole-index scans")
+	}
+	for i := range scan.keys {
+		if scan.keys[i].Flags&SkIsNull != 0 {
+			// NULLs are not indexed, and "= NULL" never matches anyway
+			return nil
+		}
+	}
+	hashcode := scan.proc(scan.keys[0].Val)
+
+	metaBuf, meta, err := hashGetMeta(scan.index, hashRead, scan.bufMgr)
+	if err != nil {
+		return err
+	}
+	defer hashRelBuf(scan.bufMgr, metaBuf, hashRead)
+
+	bucket := hashKey2Bucket(hashcode, meta.maxbucket, meta.highmask, meta.lowmask)
+	blkno := hashBucketToBlkno(meta, bucket)
+	for blkno.IsValid() {
+		buf, err := hashGetBuf(scan.index, blkno, hashRead, scan.bufMgr)
+		if err != nil {
+			return err
+		}
+		page := buf.GetPage()
+		maxOff := page.MaxOffsetNumber()
+		for offset := hashBinSearch(page, hashcode); offset <= maxOff; offset++ {
+			itup := IndexTuple(page.Item(page.ItemId(offset)))
+			if hashGetKey(itup) != hashcode {
+				break
+			}
+			scan.items = append(scan.items, itup.Tid())
+		}
+		blkno = hashOpaque(page).next
+		hashRelBuf(scan.bufMgr, buf, hashRead)
+	}
+	return nil
+}
+
+// Implements IndexScanDesc.Recheck.  Entries only tell the hash code
+// matches.
+func (scan *hashScan) Recheck() bool {
+	return true
+}
+
+// Implements IndexScanDesc.EndScan.
+func (scan *hashScan) EndScan() error {
+	scan.items = nil
+	scan.started = false
+	return nil
+}
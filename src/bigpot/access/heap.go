@@ -3,16 +3,25 @@ package access
 import (
 	"fmt"
 	"os"
+	"unsafe"
 
 	"bigpot/storage"
 	"bigpot/system"
 )
 
+// HeapRelation is an open table.  The fields after RelNode come from its
+// bp_class row.
 type HeapRelation struct {
-	RelId   system.Oid
-	RelName system.Name
-	RelDesc *TupleDesc
-	RelNode system.RelFileNode
+	RelId        system.Oid
+	RelName      system.Name
+	RelDesc      *TupleDesc
+	RelNode      system.RelFileNode
+	RelNamespace system.Oid
+	RelOwner     system.Oid
+	RelKind      system.Char
+	RelPages     int32
+	RelTuples    float64
+	RelHasIndex  bool
 }
 
 type HeapScan struct {
@@ -23,6 +32,10 @@ type HeapScan struct {
 	nBlocks    system.BlockNumber
 	startBlock system.BlockNumber
 	bufMgr     storage.BufferManager
+	// the number of line pointers of the last block when the scan began;
+	// the tuples added after it are not seen, as postgres' snapshot hides
+	// those the scanning command inserts itself
+	lastLines system.OffsetNumber
 	// currently scanning buffer
 	cBuf storage.Buffer
 	// currently scanning block
@@ -31,93 +44,176 @@ type HeapScan struct {
 	cTuple *HeapTuple
 }
 
-func (rel *HeapRelation) initRelFileNode() {
-	rel.RelNode.Dbid = 1 // TODO
+// Sets the file node of a relation of the database dbid whose file is
+// named by its oid, as the catalogs' are.
+func (rel *HeapRelation) initRelFileNode(dbid system.Oid) {
+	rel.RelNode.Dbid = dbid
 	rel.RelNode.Tsid = system.DefaultTableSpaceOid
 	rel.RelNode.Relid = rel.RelId
+	if catalog := LookupCatalog(rel.RelId); catalog != nil && catalog.Shared {
+		rel.RelNode.Dbid = system.InvalidOid
+		rel.RelNode.Tsid = system.GlobalTableSpaceOid
+	}
 }
 
-func HeapOpen(relid system.Oid, bufMgr storage.BufferManager) (*HeapRelation, error) {
-	if relid == ClassRelId {
-		relation := &HeapRelation{
-			RelId:   relid,
-			RelName: "bp_class",
-			RelDesc: ClassTupleDesc,
-		}
-		relation.initRelFileNode()
-		return relation, nil
-	} else if relid == AttributeRelId {
+// Opens the table relid of the database dbid, as postgres' heap_open.  The
+// catalogs have fixed descriptions, and any other table is described by
+// its rows in bp_class, bp_attribute and bp_attrdef.
+func HeapOpen(relid, dbid system.Oid, bufMgr storage.BufferManager) (*HeapRelation, error) {
+	if catalog := LookupCatalog(relid); catalog != nil {
 		relation := &HeapRelation{
-			RelId:   relid,
-			RelName: "bp_attribute",
-			RelDesc: AttributeTupleDesc,
+			RelId:        relid,
+			RelName:      catalog.Name,
+			RelDesc:      catalog.Desc,
+			RelNamespace: CatalogNamespaceId,
+			RelOwner:     BootstrapSuperuserId,
+			RelKind:      RelKindRelation,
 		}
-		relation.initRelFileNode()
+		relation.initRelFileNode(dbid)
 		return relation, nil
 	}
 
-	/*
-	 * Collect class information.  Currently, nothing but name is stored.
-	 */
-	class_rel, err := HeapOpen(ClassRelId, bufMgr)
+	form, err := readClassForm(relid, dbid, bufMgr)
 	if err != nil {
 		return nil, err
+	} else if form.RelKind == RelKindIndex {
+		return nil, system.Ereport(system.WrongObjectType, "\"%s\" is an index", form.RelName)
 	}
-	defer class_rel.Close()
-	var scan_keys []ScanKey
-	scan_keys = []ScanKey{
-		{system.OidAttrNumber, system.Datum(relid)},
-	}
-
-	class_scan, err := class_rel.BeginScan(scan_keys, bufMgr)
+	tupdesc, err := buildTupleDesc(relid, form, dbid, bufMgr)
 	if err != nil {
 		return nil, err
 	}
-	defer class_scan.EndScan()
-	class_tuple, err := class_scan.Next()
-	relation := &HeapRelation{
-		RelId:   relid,
-		RelName: class_tuple.Fetch(Anum_class_relname).(system.Name),
+	return &HeapRelation{
+		RelId:        relid,
+		RelName:      form.RelName,
+		RelDesc:      tupdesc,
+		RelNode:      form.relFileNode(relid, dbid),
+		RelNamespace: form.RelNamespace,
+		RelOwner:     form.RelOwner,
+		RelKind:      form.RelKind,
+		RelPages:     form.RelPages,
+		RelTuples:    form.RelTuples,
+		RelHasIndex:  form.RelHasIndex,
+	}, nil
+}
+
+// Makes the descriptor of a new table of the database dbid, as postgres'
+// RelationBuildLocalRelation.  Its file is named by relfilenode, which is
+// allocated apart from relid, and is made by CreateStorage.  A shared
+// table lives in the global tablespace.
+func BuildLocalRelation(relid, relfilenode, dbid system.Oid, relname system.Name, namespace system.Oid,
+	tupdesc *TupleDesc, shared bool) *HeapRelation {
+	rel := &HeapRelation{
+		RelId:        relid,
+		RelName:      relname,
+		RelDesc:      tupdesc,
+		RelNamespace: namespace,
+		RelOwner:     BootstrapSuperuserId,
+		RelKind:      RelKindRelation,
+	}
+	rel.RelNode = system.RelFileNode{Dbid: dbid, Tsid: system.DefaultTableSpaceOid, Relid: relfilenode}
+	if shared {
+		rel.RelNode.Dbid = system.InvalidOid
+		rel.RelNode.Tsid = system.GlobalTableSpaceOid
+	}
+	return rel
+}
+
+// Creates the empty file of the relation outside of any transaction, as
+// at bootstrap.
+func (rel *HeapRelation) CreateStorage() error {
+	return smgr.GetRelation(rel.RelNode).Create()
+}
+
+// Gives the table a new, empty file, as postgres'
+// RelationSetNewRelfilenode, for TRUNCATE and the commands that rewrite
+// the table.  The bp_class row is updated and the relation is
+// invalidated, so the table must be opened again to see the new file.  The
+// old file is removed when the transaction commits, and the new one if it
+// aborts.
+func (rel *HeapRelation) SetNewRelFileNode(tx *Transaction) (system.RelFileNode, error) {
+	if LookupCatalog(rel.RelId) != nil {
+		return rel.RelNode, system.Elog("cannot change the file of system catalog \"%s\"", rel.RelName)
 	}
+	return tx.setNewRelFileNode(rel.RelId, rel.RelNode)
+}
 
-	attr_rel, err := HeapOpen(AttributeRelId, bufMgr)
+// Gives the relation relid, whose file is oldNode, a new file.
+func (tx *Transaction) setNewRelFileNode(relid system.Oid, oldNode system.RelFileNode) (system.RelFileNode, error) {
+	node, err := GetNewRelFileNode(tx.vars, tx.dbid, oldNode.Tsid, false, tx.bufMgr)
 	if err != nil {
-		return nil, err
+		return node, err
 	}
-	defer attr_rel.Close()
-	scan_keys = []ScanKey{
-		{Anum_attribute_attrelid, system.Datum(relid)},
+	if err := tx.CreateStorage(node); err != nil {
+		return node, err
 	}
 
-	/*
-	 * Collect attributes
-	 */
-	attr_scan, err := attr_rel.BeginScan(scan_keys, bufMgr)
+	err = UpdateClassTuple(tx, relid, map[system.AttrNumber]system.Datum{Anum_class_relfilenode: node.Relid})
 	if err != nil {
-		return nil, err
+		return node, err
 	}
-	defer attr_scan.EndScan()
-	var attributes []*Attribute
-	for {
-		attr_tuple, err := attr_scan.Next()
+	tx.DropStorage(oldNode)
+	return node, nil
+}
+
+// Inserts the tuple, as postgres' heap_insert, and sets its tid.  The
+// tuple goes to the last page if it fits there, or to a new page.
+func (rel *HeapRelation) Insert(tuple *HeapTuple, bufMgr storage.BufferManager) error {
+	if len(tuple.bytes) > storage.MaxHeapTupleSize {
+		return system.Ereport(system.ProgramLimitExceeded,
+			"row is too big: size %d, maximum size %d", len(tuple.bytes), storage.MaxHeapTupleSize)
+	}
+	nBlocks, err := rel.GetNumberOfBlocks()
+	if err != nil {
+		return err
+	}
+
+	// TODO: a free space map, as postgres' RelationGetBufferForTuple
+	if nBlocks > 0 {
+		buf, err := bufMgr.ReadBuffer(rel.RelNode, nBlocks-1)
 		if err != nil {
-			break
+			return err
 		}
-		typid := attr_tuple.Fetch(Anum_attribute_atttypid).(system.Oid)
-		attribute := &Attribute{
-			Name:   attr_tuple.Fetch(Anum_attribute_attname).(system.Name),
-			TypeId: typid,
-			Type:   system.TypeRegistry[typid],
+		placed := rel.putTuple(buf, tuple)
+		bufMgr.ReleaseBuffer(buf)
+		if placed {
+			return nil
 		}
-		attributes = append(attributes, attribute)
-	}
-	relation.RelDesc = &TupleDesc{
-		Attrs: attributes,
 	}
 
-	relation.initRelFileNode()
+	buf, err := bufMgr.ReadBuffer(rel.RelNode, storage.NewBlock)
+	if err != nil {
+		return err
+	}
+	defer bufMgr.ReleaseBuffer(buf)
+	buf.Lock()
+	buf.GetPage().Init(0)
+	buf.Unlock()
+	if !rel.putTuple(buf, tuple) {
+		return system.Elog("could not add tuple to a new page of \"%s\"", rel.RelName)
+	}
+	return nil
+}
 
-	return relation, nil
+// Adds the tuple to the page in the buffer if it fits, as postgres'
+// RelationPutHeapTuple.
+func (rel *HeapRelation) putTuple(buf storage.Buffer, tuple *HeapTuple) bool {
+	buf.Lock()
+	defer buf.Unlock()
+	page := buf.GetPage()
+	offset := page.AddItem(tuple.bytes, system.InvalidOffsetNumber, false, true)
+	if offset == system.InvalidOffsetNumber {
+		return false
+	}
+	buf.MarkDirty()
+
+	tid := system.MakeItemPointer(buf.BlockNumber(), offset)
+	tuple.self = tid
+	tuple.tableOid = rel.RelId
+	tuple.data.ctid = tid
+	item := page.Item(page.ItemId(offset))
+	(*HeapTupleHeader)(unsafe.Pointer(&item[0])).ctid = tid
+	return true
 }
 
 func (rel *HeapRelation) GetNumberOfBlocks() (system.BlockNumber, error) {
@@ -128,7 +224,7 @@ func (rel *HeapRelation) GetNumberOfBlocks() (system.BlockNumber, error) {
 	}
 	size := fi.Size()
 	if size%system.BlockSize != 0 {
-		return 0, fmt.Errorf("size of %s = %d is not module BlockSize", size, relpath)
+		return 0, fmt.Errorf("size of %s = %d is not multiple of BlockSize", relpath, size)
 	}
 
 	return system.BlockNumber(size / system.BlockSize), nil
@@ -143,6 +239,11 @@ func (rel *HeapRelation) BeginScan(keys []ScanKey, bufMgr storage.BufferManager)
 		Forward:  true,
 		ScanKeys: keys,
 		bufMgr:   bufMgr,
+		cBuf:     storage.InvalidBuffer(),
+		cTuple: &HeapTuple{
+			tableOid: rel.RelId,
+			tupdesc:  rel.RelDesc,
+		},
 	}
 	nBlocks, err := rel.GetNumberOfBlocks()
 	if err != nil {
@@ -150,10 +251,56 @@ func (rel *HeapRelation) BeginScan(keys []ScanKey, bufMgr storage.BufferManager)
 	}
 	scan.startBlock = 0
 	scan.nBlocks = nBlocks
+	if nBlocks > 0 {
+		buf, err := bufMgr.ReadBuffer(rel.RelNode, nBlocks-1)
+		if err != nil {
+			return nil, err
+		}
+		buf.RLock()
+		scan.lastLines = buf.GetPage().MaxOffsetNumber()
+		buf.RUnlock()
+		bufMgr.ReleaseBuffer(buf)
+	}
 
 	return Scan(scan), nil
 }
 
+// Fetches the tuple at tid, as postgres' heap_fetch.  The returned buffer
+// is pinned and holds the tuple data, so the caller must release it when
+// done with the tuple.  If there is no tuple at tid, the returned tuple is
+// nil and so is the buffer.
+func (rel *HeapRelation) Fetch(tid system.ItemPointer, bufMgr storage.BufferManager) (*HeapTuple, storage.Buffer, error) {
+	buf, err := bufMgr.ReadBuffer(rel.RelNode, tid.BlockNumber())
+	if err != nil {
+		return nil, storage.InvalidBuffer(), err
+	}
+	buf.RLock()
+	defer buf.RUnlock()
+
+	page := buf.GetPage()
+	offset := tid.OffsetNumber()
+	if offset < system.FirstOffsetNumber || offset > page.MaxOffsetNumber() {
+		bufMgr.ReleaseBuffer(buf)
+		return nil, storage.InvalidBuffer(), nil
+	}
+	itemId := page.ItemId(offset)
+	if !itemId.IsNormal() {
+		bufMgr.ReleaseBuffer(buf)
+		return nil, storage.InvalidBuffer(), nil
+	}
+
+	tuple := &HeapTuple{
+		tableOid: rel.RelId,
+		tupdesc:  rel.RelDesc,
+	}
+	tuple.SetData(page.Item(itemId), tid)
+	if !HeapTupleSatisfiesVisibility(tuple) {
+		bufMgr.ReleaseBuffer(buf)
+		return nil, storage.InvalidBuffer(), nil
+	}
+	return tuple, buf, nil
+}
+
 func (scan *HeapScan) getBuffer(blockNum system.BlockNumber) (storage.Buffer, system.BlockNumber, error) {
 
 	// release previous scan buffer, if any
@@ -179,7 +326,6 @@ func (scan *HeapScan) Next() (Tuple, error) {
 	if !scan.inited {
 		// return immediately if relation is empty
 		if scan.nBlocks == 0 {
-			tuple.SetData(nil, system.InvalidItemPointer)
 			return nil, nil
 		}
 
@@ -200,7 +346,7 @@ func (scan *HeapScan) Next() (Tuple, error) {
 	scan.cBuf.RLock()
 
 	page := scan.cBuf.GetPage()
-	nLines := page.MaxOffsetNumber()
+	nLines := scan.pageLines(page, cBlock)
 	linesLeft := nLines - lineOff + 1
 
 	itemId := page.ItemId(lineOff)
@@ -210,9 +356,10 @@ func (scan *HeapScan) Next() (Tuple, error) {
 				tid := system.MakeItemPointer(cBlock, lineOff)
 				tuple.SetData(page.Item(itemId), tid)
 
-				// TODO: valid = HeapTupleSatisfyiesVisibility()
-
-				scan.cBuf.Unlock()
+				if HeapTupleSatisfiesVisibility(tuple) && HeapKeyTest(tuple, scan.ScanKeys) {
+					scan.cBuf.RUnlock()
+					return tuple, nil
+				}
 			}
 
 			// otherwise move to the next item on the page
@@ -224,7 +371,7 @@ func (scan *HeapScan) Next() (Tuple, error) {
 
 		// if we get here, it means we've exhausted the items on this page and
 		// it's time to move to the next.
-		scan.cBuf.Unlock()
+		scan.cBuf.RUnlock()
 
 		cBlock++
 		if cBlock >= scan.nBlocks {
@@ -238,7 +385,6 @@ func (scan *HeapScan) Next() (Tuple, error) {
 			}
 			scan.cBuf = storage.InvalidBuffer()
 			scan.cBlock = system.InvalidBlockNumber
-			tuple.SetData(nil, system.InvalidItemPointer)
 			scan.inited = false
 			return nil, nil
 		}
@@ -252,14 +398,29 @@ func (scan *HeapScan) Next() (Tuple, error) {
 		scan.cBuf.RLock()
 
 		page = scan.cBuf.GetPage()
-		nLines = page.MaxOffsetNumber()
+		nLines = scan.pageLines(page, cBlock)
 		linesLeft = nLines
 		lineOff = system.FirstOffsetNumber
 		itemId = page.ItemId(lineOff)
 	}
 }
 
+// Returns the number of line pointers of the block the scan reads.
+func (scan *HeapScan) pageLines(page *storage.Page, block system.BlockNumber) system.OffsetNumber {
+	nLines := page.MaxOffsetNumber()
+	if block == scan.nBlocks-1 && nLines > scan.lastLines {
+		nLines = scan.lastLines
+	}
+	return nLines
+}
+
+// Releases the buffer the scan holds, if any.  The last tuple returned
+// by Next is no longer valid after this.
 func (scan *HeapScan) EndScan() error {
-	// TODO:
+	if scan.cBuf.IsValid() {
+		scan.bufMgr.ReleaseBuffer(scan.cBuf)
+		scan.cBuf = storage.InvalidBuffer()
+	}
+	scan.inited = false
 	return nil
 }
@@ -0,0 +1,248 @@
+package access
+
+import (
+	"fmt"
+	. "launchpad.net/gocheck"
+	"os"
+
+	"bigpot/storage"
+	"bigpot/system"
+)
+
+// Writes the tuples into new pages of the relation, extending the file.
+func fillHeap(c *C, bufMgr storage.BufferManager, rel *HeapRelation, tuples []*HeapTuple) {
+	var buf storage.Buffer = storage.InvalidBuffer()
+	var page *storage.Page
+	for _, tuple := range tuples {
+		if buf.IsValid() {
+			if page.AddItem(tuple.bytes, system.InvalidOffsetNumber, false, true) != system.InvalidOffsetNumber {
+				continue
+			}
+			buf.MarkDirty()
+			bufMgr.ReleaseBuffer(buf)
+		}
+		var err error
+		buf, err = bufMgr.ReadBuffer(rel.RelNode, storage.NewBlock)
+		c.Assert(err, IsNil)
+		page = buf.GetPage()
+		page.Init(0)
+		offset := page.AddItem(tuple.bytes, system.InvalidOffsetNumber, false, true)
+		c.Assert(offset, Not(Equals), system.OffsetNumber(system.InvalidOffsetNumber))
+	}
+	if buf.IsValid() {
+		buf.MarkDirty()
+		bufMgr.ReleaseBuffer(buf)
+	}
+}
+
+// Makes a buffer manager, writing the control file it checks.  The caller
+// removes the global directory.
+func newBufferManager(c *C, nBuffers int) storage.BufferManager {
+	c.Assert(os.MkdirAll("global", 0700), IsNil)
+	c.Assert(storage.WriteControlFile(storage.NewControlFileData()), IsNil)
+	bufMgr, err := storage.NewBufferManager(nBuffers)
+	c.Assert(err, IsNil)
+	return bufMgr
+}
+
+func createRelFile(c *C, node system.RelFileNode) {
+	file, err := os.Create(system.RelPath(node))
+	c.Assert(err, IsNil)
+	file.Close()
+}
+
+func scanAll(c *C, rel *HeapRelation, keys []ScanKey, bufMgr storage.BufferManager) []system.Datum {
+	scan, err := rel.BeginScan(keys, bufMgr)
+	c.Assert(err, IsNil)
+	defer scan.EndScan()
+	var result []system.Datum
+	for {
+		tuple, err := scan.Next()
+		c.Assert(err, IsNil)
+		if tuple == nil {
+			break
+		}
+		result = append(result, tuple.Fetch(1))
+	}
+	return result
+}
+
+func (s *MySuite) TestHeapScanKeys(c *C) {
+	os.MkdirAll("base/1", 0700)
+	defer os.RemoveAll("base")
+	defer os.RemoveAll("global")
+	bufMgr := newBufferManager(c, 16)
+
+	tupdesc := &TupleDesc{
+		Attrs: []*Attribute{
+			{Name: "id", TypeId: system.Int4Type},
+			{Name: "val", TypeId: system.Int4Type},
+		},
+	}
+	initTupleDesc(tupdesc)
+	rel := &HeapRelation{RelId: 20000, RelName: "t", RelDesc: tupdesc}
+	rel.initRelFileNode(TemplateDbId)
+	createRelFile(c, rel.RelNode)
+
+	// enough tuples to span several pages; every third val is NULL
+	var tuples []*HeapTuple
+	for i := 0; i < 1000; i++ {
+		val := system.Datum(system.Int4(i % 10))
+		if i%3 == 0 {
+			val = nil
+		}
+		tuples = append(tuples, FormHeapTuple([]system.Datum{system.Int4(i), val}, tupdesc))
+	}
+	fillHeap(c, bufMgr, rel, tuples)
+	nBlocks, err := rel.GetNumberOfBlocks()
+	c.Assert(err, IsNil)
+	c.Check(nBlocks > 1, Equals, true)
+
+	c.Check(scanAll(c, rel, nil, bufMgr), HasLen, 1000)
+
+	key, err := MakeScanKey(1, BTEqualStrategyNumber, system.Int4Type, system.Int4Type, system.Int4(500))
+	c.Assert(err, IsNil)
+	c.Check(scanAll(c, rel, []ScanKey{key}, bufMgr), DeepEquals, []system.Datum{system.Int4(500)})
+
+	// 990 <= id < 995
+	lower, _ := MakeScanKey(1, BTGreaterEqualStrategyNumber, system.Int4Type, system.Int4Type, system.Int4(990))
+	upper, _ := MakeScanKey(1, BTLessStrategyNumber, system.Int4Type, system.Int4Type, system.Int4(995))
+	c.Check(scanAll(c, rel, []ScanKey{lower, upper}, bufMgr), DeepEquals, []system.Datum{
+		system.Int4(990), system.Int4(991), system.Int4(992), system.Int4(993), system.Int4(994),
+	})
+
+	// id < 10 AND val IS NULL
+	upper, _ = MakeScanKey(1, BTLessStrategyNumber, system.Int4Type, system.Int4Type, system.Int4(10))
+	c.Check(scanAll(c, rel, []ScanKey{upper, MakeNullScanKey(2, true)}, bufMgr), DeepEquals, []system.Datum{
+		system.Int4(0), system.Int4(3), system.Int4(6), system.Int4(9),
+	})
+	c.Check(scanAll(c, rel, []ScanKey{MakeNullScanKey(2, false)}, bufMgr), HasLen, 666)
+
+	// val > 8 never matches NULLs, nor does a NULL argument
+	key, _ = MakeScanKey(2, BTGreaterStrategyNumber, system.Int4Type, system.Int4Type, system.Int4(8))
+	c.Check(scanAll(c, rel, []ScanKey{key}, bufMgr), HasLen, 66)
+	key, _ = MakeScanKey(2, BTEqualStrategyNumber, system.Int4Type, system.Int4Type, nil)
+	c.Check(scanAll(c, rel, []ScanKey{key}, bufMgr), HasLen, 0)
+
+	_, err = MakeScanKey(1, BTEqualStrategyNumber, system.Int4Type, system.NameType, system.Name("x"))
+	c.Check(err, ErrorMatches, "could not identify a comparison function .*")
+	_, err = MakeScanKey(1, 6, system.Int4Type, system.Int4Type, system.Int4(1))
+	c.Check(err, ErrorMatches, "invalid strategy number 6")
+}
+
+func (s *MySuite) TestHeapOpen(c *C) {
+	os.MkdirAll("base/1", 0700)
+	defer os.RemoveAll("base")
+	defer os.RemoveAll("global")
+	bufMgr := newBufferManager(c, 16)
+
+	classRel, err := HeapOpen(ClassRelId, TemplateDbId, bufMgr)
+	c.Assert(err, IsNil)
+	attrRel, err := HeapOpen(AttributeRelId, TemplateDbId, bufMgr)
+	c.Assert(err, IsNil)
+	attrDefRel, err := HeapOpen(AttrDefaultRelId, TemplateDbId, bufMgr)
+	c.Assert(err, IsNil)
+	createRelFile(c, classRel.RelNode)
+	createRelFile(c, attrRel.RelNode)
+	createRelFile(c, attrDefRel.RelNode)
+
+	// the relation we look for is not the first one in bp_class
+	var classTuples []*HeapTuple
+	for i, name := range []string{"foo", "bar", "baz"} {
+		classTuples = append(classTuples, FormCatalogTuple(ClassRelId, system.Oid(20001+i),
+			classRow(name, system.Oid(30001+i), system.InvalidOid, 2, RelKindRelation)))
+	}
+	fillHeap(c, bufMgr, classRel, classTuples)
+
+	// bar's columns are out of order, and its second one has a default
+	var attrTuples []*HeapTuple
+	for _, attr := range []struct {
+		relid  system.Oid
+		attnum int
+	}{{20001, 1}, {20002, 2}, {20002, 1}, {20003, 1}} {
+		attrTuples = append(attrTuples, FormCatalogTuple(AttributeRelId, system.InvalidOid, []system.Datum{
+			attr.relid, system.Name(fmt.Sprintf("col%d", attr.attnum)), system.Int4Type, system.Int4(4),
+			system.Int4(attr.attnum), system.Bool(attr.attnum == 1), system.Bool(attr.attnum == 2),
+			system.Bool(false), system.Bool(false), system.Text(""),
+		}))
+	}
+	fillHeap(c, bufMgr, attrRel, attrTuples)
+	fillHeap(c, bufMgr, attrDefRel, []*HeapTuple{FormCatalogTuple(AttrDefaultRelId, 40001, []system.Datum{
+		system.Oid(20002), system.Int4(2), system.Text("42"),
+	})})
+
+	rel, err := HeapOpen(20002, TemplateDbId, bufMgr)
+	c.Assert(err, IsNil)
+	c.Check(rel.RelName, Equals, system.Name("bar"))
+	c.Check(rel.RelKind, Equals, RelKindRelation)
+	c.Check(rel.RelNamespace, Equals, PublicNamespaceId)
+	c.Check(rel.RelNode, Equals, system.RelFileNode{Dbid: 1, Tsid: system.DefaultTableSpaceOid, Relid: 30002})
+	c.Check(rel.RelDesc.Attrs, HasLen, 2)
+	c.Check(rel.RelDesc.Attrs[0].Name, Equals, system.Name("col1"))
+	c.Check(rel.RelDesc.Attrs[0].Type, Equals, system.TypeRegistry[system.Int4Type])
+	c.Check(rel.RelDesc.Attrs[0].NotNull, Equals, true)
+	c.Check(rel.RelDesc.Attrs[1].HasDefault, Equals, true)
+	c.Check(rel.RelDesc.Constr, DeepEquals, &TupleConstr{Defaults: []AttrDefault{{AttNum: 2, Src: "42"}}})
+
+	_, err = HeapOpen(30000, TemplateDbId, bufMgr)
+	c.Check(err, ErrorMatches, "could not open relation with OID 30000")
+}
+
+func (s *MySuite) TestSetNewRelFileNode(c *C) {
+	os.MkdirAll("base/1", 0700)
+	defer os.RemoveAll("base")
+	defer os.RemoveAll("global")
+	bufMgr := newBufferManager(c, 64)
+	loadBootstrapCatalogs(c, bufMgr)
+	vars, err := NewTransamVariables()
+	c.Assert(err, IsNil)
+
+	classRel, _ := HeapOpen(ClassRelId, TemplateDbId, bufMgr)
+	c.Assert(classRel.Insert(FormCatalogTuple(ClassRelId, 20001,
+		classRow("t", 20001, system.InvalidOid, 0, RelKindRelation)), bufMgr), IsNil)
+	queue := NewSharedInvalQueue()
+	relcache := NewRelCache(bufMgr, queue, TemplateDbId)
+	defer relcache.Close()
+	rel, err := relcache.HeapOpen(20001)
+	c.Assert(err, IsNil)
+	createRelFile(c, rel.RelNode)
+	c.Assert(rel.Insert(FormHeapTuple([]system.Datum{}, rel.RelDesc), bufMgr), IsNil)
+
+	tx, err := BeginTransaction(vars, queue, bufMgr, TemplateDbId)
+	c.Assert(err, IsNil)
+	node, err := rel.SetNewRelFileNode(tx)
+	c.Assert(err, IsNil)
+	c.Check(node.Relid, Equals, system.FirstNormalObjectId)
+	c.Check(rel.RelNode.Relid, Equals, system.Oid(20001))
+	reopened, err := relcache.HeapOpen(20001)
+	c.Assert(err, IsNil)
+	c.Check(reopened.RelNode, Equals, node)
+	c.Check(reopened.RelName, Equals, system.Name("t"))
+	nBlocks, err := reopened.GetNumberOfBlocks()
+	c.Assert(err, IsNil)
+	c.Check(nBlocks, Equals, system.BlockNumber(0))
+
+	// at abort the table goes back to its old file
+	c.Assert(tx.Abort(), IsNil)
+	reopened, err = relcache.HeapOpen(20001)
+	c.Assert(err, IsNil)
+	c.Check(reopened.RelNode, Equals, rel.RelNode)
+	c.Check(storage.NewMdSmgr().GetRelation(node).Exists(), Equals, false)
+
+	// at commit the old file is removed
+	tx, err = BeginTransaction(vars, queue, bufMgr, TemplateDbId)
+	c.Assert(err, IsNil)
+	node, err = rel.SetNewRelFileNode(tx)
+	c.Assert(err, IsNil)
+	c.Assert(tx.Commit(), IsNil)
+	reopened, err = relcache.HeapOpen(20001)
+	c.Assert(err, IsNil)
+	c.Check(reopened.RelNode, Equals, node)
+	c.Check(storage.NewMdSmgr().GetRelation(rel.RelNode).Exists(), Equals, false)
+
+	tx, err = BeginTransaction(vars, queue, bufMgr, TemplateDbId)
+	c.Assert(err, IsNil)
+	defer tx.Abort()
+	_, err = classRel.SetNewRelFileNode(tx)
+	c.Check(err, ErrorMatches, "cannot change the file of system catalog \"bp_class\"")
+}
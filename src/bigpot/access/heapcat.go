@@ -0,0 +1,300 @@
+package access
+
+import (
+	"fmt"
+
+	"bigpot/storage"
+	"bigpot/system"
+)
+
+// Makes a table with its bp_class and bp_attribute rows and its file, as
+// postgres' heap_create_with_catalog, and returns its oid.  The oid is
+// also the first relfilenode of the table.
+func HeapCreateWithCatalog(tx *Transaction, relname system.Name, namespace system.Oid,
+	tupdesc *TupleDesc) (system.Oid, error) {
+	exists := false
+	keys := []ScanKey{
+		nameScanKey(Anum_class_relname, relname),
+		oidScanKey(Anum_class_relnamespace, namespace),
+	}
+	err := scanCatalog(ClassRelId, tx.dbid, keys, tx.bufMgr, func(tuple Tuple) error {
+		exists = true
+		return nil
+	})
+	if err != nil {
+		return system.InvalidOid, err
+	} else if exists {
+		return system.InvalidOid, system.Ereport(system.DuplicateTable,
+			"relation \"%s\" already exists", relname)
+	}
+
+	node, err := GetNewRelFileNode(tx.vars, tx.dbid, system.DefaultTableSpaceOid, true, tx.bufMgr)
+	if err != nil {
+		return system.InvalidOid, err
+	}
+	rel := BuildLocalRelation(node.Relid, node.Relid, tx.dbid, relname, namespace, tupdesc, false)
+	if err := tx.CreateStorage(rel.RelNode); err != nil {
+		return system.InvalidOid, err
+	}
+	if err := addNewRelationTuple(tx, rel); err != nil {
+		return system.InvalidOid, err
+	}
+	for i, attr := range tupdesc.Attrs {
+		if err := InsertAttributeTuple(tx, rel.RelId, system.AttrNumber(i+1), attr); err != nil {
+			return system.InvalidOid, err
+		}
+	}
+	if tupdesc.Constr != nil {
+		for _, def := range tupdesc.Constr.Defaults {
+			if err := StoreAttrDefault(tx, rel.RelId, def.AttNum, def.Src); err != nil {
+				return system.InvalidOid, err
+			}
+		}
+	}
+	return rel.RelId, nil
+}
+
+// Inserts the bp_class row of a new relation, as postgres'
+// AddNewRelationTuple.
+func addNewRelationTuple(tx *Transaction, rel *HeapRelation) error {
+	var tablespace system.Oid
+	if rel.RelNode.Tsid != system.DefaultTableSpaceOid {
+		tablespace = rel.RelNode.Tsid
+	}
+	return tx.CatalogInsert(ClassRelId, FormCatalogTuple(ClassRelId, rel.RelId, []system.Datum{
+		rel.RelName, rel.RelNamespace, system.InvalidOid, rel.RelOwner, system.InvalidOid,
+		rel.RelNode.Relid, tablespace, system.Int4(0), system.Float8(0), system.Bool(false),
+		system.Bool(rel.RelNode.Tsid == system.GlobalTableSpaceOid), rel.RelKind,
+		system.Int4(len(rel.RelDesc.Attrs)), system.Bool(rel.RelDesc.hasOid),
+	}))
+}
+
+// Inserts the bp_attribute row of a column, as postgres'
+// InsertPgAttributeTuple.
+func InsertAttributeTuple(tx *Transaction, relid system.Oid, attnum system.AttrNumber, attr *Attribute) error {
+	missing := system.Text("")
+	if attr.Missing != nil {
+		missing = system.Text(attr.Missing.ToString())
+	}
+	return tx.CatalogInsert(AttributeRelId, FormCatalogTuple(AttributeRelId, system.InvalidOid, []system.Datum{
+		relid, attr.Name, attr.TypeId, system.Int4(attr.Type.Len), system.Int4(attnum),
+		system.Bool(attr.NotNull), system.Bool(attr.HasDefault), system.Bool(attr.IsDropped),
+		system.Bool(attr.Missing != nil), missing,
+	}))
+}
+
+// Sets the columns of the bp_attribute row of the column to the values,
+// by their attribute numbers in bp_attribute.
+func UpdateAttributeTuple(tx *Transaction, relid system.Oid, attnum system.AttrNumber,
+	values map[system.AttrNumber]system.Datum) error {
+	keys := []ScanKey{
+		oidScanKey(Anum_attribute_attrelid, relid),
+		int4ScanKey(Anum_attribute_attnum, system.Int4(attnum)),
+	}
+	return updateCatalogTuple(tx, AttributeRelId, keys, values)
+}
+
+// Sets the columns of the bp_class row of the relation to the values, by
+// their attribute numbers in bp_class.
+func UpdateClassTuple(tx *Transaction, relid system.Oid, values map[system.AttrNumber]system.Datum) error {
+	keys := []ScanKey{oidScanKey(system.OidAttrNumber, relid)}
+	return updateCatalogTuple(tx, ClassRelId, keys, values)
+}
+
+// Updates the single tuple of the catalog that satisfies the keys.
+func updateCatalogTuple(tx *Transaction, relid system.Oid, keys []ScanKey,
+	values map[system.AttrNumber]system.Datum) error {
+	var tuple *HeapTuple
+	err := scanCatalog(relid, tx.dbid, keys, tx.bufMgr, func(found Tuple) error {
+		tuple = found.(*HeapTuple).Copy()
+		return nil
+	})
+	if err != nil {
+		return err
+	} else if tuple == nil {
+		return system.Elog("cache lookup failed for a tuple of catalog %d", relid)
+	}
+	natts := len(LookupCatalog(relid).Desc.Attrs)
+	newValues := make([]system.Datum, natts)
+	replace := make([]bool, natts)
+	for attnum, value := range values {
+		newValues[attnum-1] = value
+		replace[attnum-1] = true
+	}
+	return tx.CatalogUpdate(relid, tuple, HeapModifyTuple(tuple, newValues, replace))
+}
+
+// Stores the default of the column, as postgres' StoreAttrDefault.  src is
+// the source text of the default expression.
+func StoreAttrDefault(tx *Transaction, relid system.Oid, attnum system.AttrNumber, src string) error {
+	oid, err := GetNewOid(tx.vars, AttrDefaultRelId, tx.dbid, tx.bufMgr)
+	if err != nil {
+		return err
+	}
+	err = tx.CatalogInsert(AttrDefaultRelId, FormCatalogTuple(AttrDefaultRelId, oid, []system.Datum{
+		relid, system.Int4(attnum), system.Text(src),
+	}))
+	if err != nil {
+		return err
+	}
+	return UpdateAttributeTuple(tx, relid, attnum, map[system.AttrNumber]system.Datum{
+		Anum_attribute_atthasdef: system.Bool(true),
+	})
+}
+
+// Removes the default of the column, if it has one, as postgres'
+// RemoveAttrDefault.
+func RemoveAttrDefault(tx *Transaction, relid system.Oid, attnum system.AttrNumber) error {
+	deleted, err := deleteCatalogTuples(tx, AttrDefaultRelId,
+		oidScanKey(Anum_attrdef_adrelid, relid), int4ScanKey(Anum_attrdef_adnum, system.Int4(attnum)))
+	if err != nil || len(deleted) == 0 {
+		return err
+	}
+	return UpdateAttributeTuple(tx, relid, attnum, map[system.AttrNumber]system.Datum{
+		Anum_attribute_atthasdef: system.Bool(false),
+	})
+}
+
+// Marks the column dropped, as postgres' RemoveAttributeById.  The column
+// stays in the tuples, so its type is kept, but it is renamed out of the
+// way of new columns.
+func RemoveAttributeById(tx *Transaction, relid system.Oid, attnum system.AttrNumber) error {
+	if err := RemoveAttrDefault(tx, relid, attnum); err != nil {
+		return err
+	}
+	return UpdateAttributeTuple(tx, relid, attnum, map[system.AttrNumber]system.Datum{
+		Anum_attribute_attname:       system.Name(fmt.Sprintf("........bp.dropped.%d........", attnum)),
+		Anum_attribute_attnotnull:    system.Bool(false),
+		Anum_attribute_attisdropped:  system.Bool(true),
+		Anum_attribute_atthasmissing: system.Bool(false),
+		Anum_attribute_attmissingval: system.Text(""),
+	})
+}
+
+// Returns the oids of the indexes of the table relid of the database dbid,
+// as postgres' RelationGetIndexList.
+func RelationGetIndexList(relid, dbid system.Oid, bufMgr storage.BufferManager) ([]system.Oid, error) {
+	var indexes []system.Oid
+	keys := []ScanKey{oidScanKey(Anum_index_indrelid, relid)}
+	err := scanCatalog(IndexRelId, dbid, keys, bufMgr, func(tuple Tuple) error {
+		indexes = append(indexes, tuple.Fetch(Anum_index_indexrelid).(system.Oid))
+		return nil
+	})
+	return indexes, err
+}
+
+// Drops the index, as postgres' index_drop.
+func IndexDrop(tx *Transaction, indexrelid system.Oid) error {
+	if _, err := deleteCatalogTuples(tx, IndexRelId, oidScanKey(Anum_index_indexrelid, indexrelid)); err != nil {
+		return err
+	}
+	return indexDropStorage(tx, indexrelid)
+}
+
+// Gives each index of the table a new file and builds it again from the
+// table, as postgres' reindex_relation after a rewrite of the table.
+func ReindexRelation(tx *Transaction, relid system.Oid) error {
+	indexes, err := RelationGetIndexList(relid, tx.dbid, tx.bufMgr)
+	if err != nil {
+		return err
+	}
+	for _, indexrelid := range indexes {
+		index, err := IndexOpen(indexrelid, tx.dbid, tx.bufMgr)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.setNewRelFileNode(indexrelid, index.RelNode); err != nil {
+			return err
+		}
+	}
+	heap, err := HeapOpen(relid, tx.dbid, tx.bufMgr)
+	if err != nil {
+		return err
+	}
+	for _, indexrelid := range indexes {
+		index, err := IndexOpen(indexrelid, tx.dbid, tx.bufMgr)
+		if err != nil {
+			return err
+		}
+		if err := index.Build(heap, tx.bufMgr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Drops the table, its indexes and their catalog rows, as postgres'
+// heap_drop_with_catalog.  The files are removed when the transaction
+// commits.
+func HeapDropWithCatalog(tx *Transaction, relid system.Oid) error {
+	rel, err := HeapOpen(relid, tx.dbid, tx.bufMgr)
+	if err != nil {
+		return err
+	}
+	indexes, err := deleteCatalogTuples(tx, IndexRelId, oidScanKey(Anum_index_indrelid, relid))
+	if err != nil {
+		return err
+	}
+	for _, tuple := range indexes {
+		if err := indexDropStorage(tx, tuple.Fetch(Anum_index_indexrelid).(system.Oid)); err != nil {
+			return err
+		}
+	}
+	if err := deleteRelationTuples(tx, relid); err != nil {
+		return err
+	}
+	tx.DropStorage(rel.RelNode)
+	return nil
+}
+
+// Deletes the catalog rows of an index whose bp_index row is gone, and
+// drops its file.
+func indexDropStorage(tx *Transaction, indexrelid system.Oid) error {
+	form, err := readClassForm(indexrelid, tx.dbid, tx.bufMgr)
+	if err != nil {
+		return err
+	}
+	if err := deleteRelationTuples(tx, indexrelid); err != nil {
+		return err
+	}
+	tx.DropStorage(form.relFileNode(indexrelid, tx.dbid))
+	return nil
+}
+
+// Deletes the bp_attrdef, bp_attribute and bp_class rows of the relation,
+// as postgres' RemoveAttrDefault, DeleteAttributeTuples and
+// DeleteRelationTuple.
+func deleteRelationTuples(tx *Transaction, relid system.Oid) error {
+	if _, err := deleteCatalogTuples(tx, AttrDefaultRelId, oidScanKey(Anum_attrdef_adrelid, relid)); err != nil {
+		return err
+	}
+	if _, err := deleteCatalogTuples(tx, AttributeRelId, oidScanKey(Anum_attribute_attrelid, relid)); err != nil {
+		return err
+	}
+	deleted, err := deleteCatalogTuples(tx, ClassRelId, oidScanKey(system.OidAttrNumber, relid))
+	if err != nil {
+		return err
+	} else if len(deleted) == 0 {
+		return system.Elog("cache lookup failed for relation %d", relid)
+	}
+	return nil
+}
+
+// Deletes the tuples of the catalog that satisfy the keys, and returns
+// them.
+func deleteCatalogTuples(tx *Transaction, relid system.Oid, keys ...ScanKey) ([]*HeapTuple, error) {
+	var tuples []*HeapTuple
+	err := scanCatalog(relid, tx.dbid, keys, tx.bufMgr, func(tuple Tuple) error {
+		tuples = append(tuples, tuple.(*HeapTuple).Copy())
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, tuple := range tuples {
+		if err := tx.CatalogDelete(relid, tuple); err != nil {
+			return nil, err
+		}
+	}
+	return tuples, nil
+}
@@ -0,0 +1,278 @@
+package access
+
+import (
+	"bigpot/storage"
+	"bigpot/system"
+)
+
+// ScanDirection tells which way a scan moves, as postgres' ScanDirection.
+type ScanDirection int
+
+const (
+	BackwardScanDirection   ScanDirection = -1
+	NoMovementScanDirection ScanDirection = 0
+	ForwardScanDirection    ScanDirection = 1
+)
+
+// IndexRelation is an open index.  RelDesc describes the index columns,
+// and HeapAttrs holds the heap column each of them is taken from, as
+// postgres' indkey.
+type IndexRelation struct {
+	RelId     system.Oid
+	RelName   system.Name
+	RelDesc   *TupleDesc
+	RelNode   system.RelFileNode
+	HeapAttrs []system.AttrNumber
+	Unique    bool
+	Am        IndexAm
+}
+
+// IndexAm is the interface of an index access method, as postgres'
+// IndexAmRoutine.
+type IndexAm interface {
+	// Initializes the index storage and inserts entries for all the
+	// tuples in the heap.  The index file must exist and be empty.
+	Build(heap *HeapRelation, index *IndexRelation, bufMgr storage.BufferManager) error
+	// Inserts an entry pointing to the heap tuple tid.  heap is used to
+	// check uniqueness if the index is unique.
+	Insert(index *IndexRelation, values []system.Datum, tid system.ItemPointer,
+		heap *HeapRelation, bufMgr storage.BufferManager) error
+	// Starts a scan returning the entries that satisfy all the keys.  Key
+	// attribute numbers are those of the index columns.
+	BeginScan(index *IndexRelation, keys []ScanKey, bufMgr storage.BufferManager) (IndexScanDesc, error)
+}
+
+// IndexScanDesc is an access method specific scan over an index.
+type IndexScanDesc interface {
+	// Returns the heap tid of the next matching entry, or
+	// InvalidItemPointer at the end of the scan.
+	Next(dir ScanDirection) (system.ItemPointer, error)
+	// Returns true if the entries may not satisfy the keys, so that the
+	// keys have to be checked again with the heap tuples.
+	Recheck() bool
+	EndScan() error
+}
+
+// BitmapIndexAm is implemented by access methods that can return all the
+// matching heap tids at once, as postgres' amgetbitmap.
+type BitmapIndexAm interface {
+	// Adds the heap tids of the entries satisfying all the keys to the
+	// bitmap.  Those that may not satisfy them are added for recheck, or
+	// as whole lossy pages.
+	GetBitmap(index *IndexRelation, heap *HeapRelation, keys []ScanKey, tbm *TIDBitmap,
+		bufMgr storage.BufferManager) error
+}
+
+// Sets the file node of an index of the database dbid whose file is named
+// by its oid.
+func (index *IndexRelation) initRelFileNode(dbid system.Oid) {
+	index.RelNode.Dbid = dbid
+	index.RelNode.Tsid = system.DefaultTableSpaceOid
+	index.RelNode.Relid = index.RelId
+}
+
+// Opens the index relid of the database dbid, as postgres' index_open.
+// The index is described by its rows in bp_class, bp_index and
+// bp_attribute, and its access method by bp_class.relam.  The access
+// methods are opened with their default parameters, as those are not kept
+// in the catalogs.
+func IndexOpen(relid, dbid system.Oid, bufMgr storage.BufferManager) (*IndexRelation, error) {
+	form, err := readClassForm(relid, dbid, bufMgr)
+	if err != nil {
+		return nil, err
+	} else if form.RelKind != RelKindIndex {
+		return nil, system.Ereport(system.WrongObjectType, "\"%s\" is not an index", form.RelName)
+	}
+	am, ok := indexAms[form.RelAm]
+	if !ok {
+		return nil, system.Elog("cache lookup failed for access method %d", form.RelAm)
+	}
+	tupdesc, err := buildTupleDesc(relid, form, dbid, bufMgr)
+	if err != nil {
+		return nil, err
+	}
+	index := &IndexRelation{
+		RelId:   relid,
+		RelName: form.RelName,
+		RelDesc: tupdesc,
+		RelNode: form.relFileNode(relid, dbid),
+		Am:      am,
+	}
+
+	found := false
+	keys := []ScanKey{oidScanKey(Anum_index_indexrelid, relid)}
+	err = scanCatalog(IndexRelId, dbid, keys, bufMgr, func(tuple Tuple) error {
+		found = true
+		index.Unique = bool(tuple.Fetch(Anum_index_indisunique).(system.Bool))
+		for _, attnum := range tuple.Fetch(Anum_index_indkey).(system.Array).Elems {
+			index.HeapAttrs = append(index.HeapAttrs, system.AttrNumber(attnum.(system.Int4)))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	} else if !found {
+		return nil, system.Elog("cache lookup failed for index %d", relid)
+	}
+	return index, nil
+}
+
+func (index *IndexRelation) Close() {
+}
+
+// Extracts the index column values from the heap tuple, as postgres'
+// FormIndexDatum.
+func (index *IndexRelation) FormIndexDatum(tuple Tuple) []system.Datum {
+	values := make([]system.Datum, len(index.HeapAttrs))
+	for i, attnum := range index.HeapAttrs {
+		values[i] = tuple.Fetch(attnum)
+	}
+	return values
+}
+
+// Builds the index from the heap contents.
+func (index *IndexRelation) Build(heap *HeapRelation, bufMgr storage.BufferManager) error {
+	return index.Am.Build(heap, index, bufMgr)
+}
+
+// Inserts an index entry for the heap tuple, as postgres' index_insert.
+func (index *IndexRelation) Insert(values []system.Datum, tid system.ItemPointer,
+	heap *HeapRelation, bufMgr storage.BufferManager) error {
+	return index.Am.Insert(index, values, tid, heap, bufMgr)
+}
+
+// Adds the heap tids of the index entries satisfying the keys to the
+// bitmap, as postgres' index_getbitmap.  Indexes whose access method
+// doesn't implement BitmapIndexAm can fill a bitmap from an IndexScan.
+func IndexGetBitmap(heap *HeapRelation, index *IndexRelation, keys []ScanKey, tbm *TIDBitmap,
+	bufMgr storage.BufferManager) error {
+	am, ok := index.Am.(BitmapIndexAm)
+	if !ok {
+		return system.Ereport(system.FeatureNotSupported,
+			"index \"%s\" does not support bitmap scans", index.RelName)
+	}
+	return am.GetBitmap(index, heap, keys, tbm, bufMgr)
+}
+
+// Calls fn with every tuple in the heap, as postgres'
+// IndexBuildHeapScan.
+func indexBuildHeapScan(heap *HeapRelation, index *IndexRelation, bufMgr storage.BufferManager,
+	fn func(values []system.Datum, tid system.ItemPointer) error) error {
+	scan, err := heap.BeginScan(nil, bufMgr)
+	if err != nil {
+		return err
+	}
+	defer scan.EndScan()
+	for {
+		tuple, err := scan.Next()
+		if err != nil {
+			return err
+		} else if tuple == nil {
+			return nil
+		}
+		htup := tuple.(*HeapTuple)
+		if err := fn(index.FormIndexDatum(htup), htup.self); err != nil {
+			return err
+		}
+	}
+}
+
+// IndexScan fetches the heap tuples an index scan points to.  It
+// implements Scan.
+type IndexScan struct {
+	heap      *HeapRelation
+	index     *IndexRelation
+	Direction ScanDirection
+	desc      IndexScanDesc
+	bufMgr    storage.BufferManager
+	// the keys on the heap columns, to recheck the heap tuples
+	heapKeys []ScanKey
+	// buffer holding the last returned heap tuple
+	cBuf storage.Buffer
+}
+
+// Starts a scan of the heap tuples whose index entries satisfy the keys,
+// as postgres' index_beginscan.
+func IndexBeginScan(heap *HeapRelation, index *IndexRelation, keys []ScanKey,
+	bufMgr storage.BufferManager) (*IndexScan, error) {
+	desc, err := index.Am.BeginScan(index, keys, bufMgr)
+	if err != nil {
+		return nil, err
+	}
+	scan := &IndexScan{
+		heap:      heap,
+		index:     index,
+		Direction: ForwardScanDirection,
+		desc:      desc,
+		bufMgr:    bufMgr,
+		cBuf:      storage.InvalidBuffer(),
+	}
+	if desc.Recheck() {
+		scan.heapKeys = make([]ScanKey, len(keys))
+		for i, key := range keys {
+			key.AttNum = index.HeapAttrs[key.AttNum-1]
+			scan.heapKeys[i] = key
+		}
+	}
+	return scan, nil
+}
+
+// Returns the next heap tid from the index, without fetching the tuple.
+func (scan *IndexScan) NextTid() (system.ItemPointer, error) {
+	return scan.desc.Next(scan.Direction)
+}
+
+// Adds the heap tids of the rest of the scan to the bitmap, without
+// fetching the tuples, as postgres' MultiExecBitmapIndexScan.  They are
+// added for recheck if the index entries may not satisfy the keys.
+func (scan *IndexScan) GetBitmap(tbm *TIDBitmap) error {
+	var tids []system.ItemPointer
+	for {
+		tid, err := scan.desc.Next(scan.Direction)
+		if err != nil {
+			return err
+		} else if tid == system.InvalidItemPointer {
+			break
+		}
+		tids = append(tids, tid)
+	}
+	tbm.AddTuples(tids, scan.desc.Recheck())
+	return nil
+}
+
+// Returns the next heap tuple, or nil at the end of the scan.  The tuple
+// is valid until the next call.
+func (scan *IndexScan) Next() (Tuple, error) {
+	for {
+		tid, err := scan.desc.Next(scan.Direction)
+		if err != nil {
+			return nil, err
+		} else if tid == system.InvalidItemPointer {
+			scan.releaseBuffer()
+			return nil, nil
+		}
+
+		scan.releaseBuffer()
+		tuple, buf, err := scan.heap.Fetch(tid, scan.bufMgr)
+		if err != nil {
+			return nil, err
+		}
+		scan.cBuf = buf
+		if tuple != nil && HeapKeyTest(tuple, scan.heapKeys) {
+			return tuple, nil
+		}
+		// the heap tuple is gone or doesn't match; move on to the next
+	}
+}
+
+func (scan *IndexScan) releaseBuffer() {
+	if scan.cBuf.IsValid() {
+		scan.bufMgr.ReleaseBuffer(scan.cBuf)
+		scan.cBuf = storage.InvalidBuffer()
+	}
+}
+
+func (scan *IndexScan) EndScan() error {
+	scan.releaseBuffer()
+	return scan.desc.EndScan()
+}
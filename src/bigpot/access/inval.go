@@ -0,0 +1,185 @@
+package access
+
+import (
+	"sync"
+
+	"bigpot/system"
+)
+
+// InvalKind tells which cache entries an InvalMessage flushes.
+type InvalKind int
+
+const (
+	// the description of a relation in the relcache
+	RelcacheInval InvalKind = iota
+	// the tuples of a syscache whose keys hash to a value
+	CatcacheInval
+)
+
+// InvalMessage tells the sessions to flush cache entries that a catalog
+// change made stale, as postgres' SharedInvalidationMessage.
+type InvalMessage struct {
+	Kind InvalKind
+	// the relation, for RelcacheInval
+	RelId system.Oid
+	// the cache and the hash of the tuple keys, for CatcacheInval
+	CacheId   SysCacheId
+	HashValue uint32
+}
+
+// Number of messages the queue holds before the sessions furthest behind
+// are reset, as postgres' MAXNUMMESSAGES.
+const MaxInvalQueueSize = 4096
+
+// SharedInvalQueue passes invalidation messages between the sessions, as
+// postgres' shared invalidation queue.  Each session's caches read it
+// through their own InvalReader.
+type SharedInvalQueue struct {
+	lock sync.Mutex
+	msgs []InvalMessage
+	// number of msgs[0], counting from the first message ever sent
+	minMsgNum int64
+	readers   map[*InvalReader]struct{}
+}
+
+// InvalReader is the position of a reader in a SharedInvalQueue.  A reset
+// reader has missed messages and must flush everything it caches.
+type InvalReader struct {
+	queue      *SharedInvalQueue
+	nextMsgNum int64
+	reset      bool
+}
+
+func NewSharedInvalQueue() *SharedInvalQueue {
+	return &SharedInvalQueue{readers: make(map[*InvalReader]struct{})}
+}
+
+// Adds a reader that receives the messages sent from now on.
+func (queue *SharedInvalQueue) NewReader() *InvalReader {
+	queue.lock.Lock()
+	defer queue.lock.Unlock()
+	reader := &InvalReader{
+		queue:      queue,
+		nextMsgNum: queue.maxMsgNum(),
+	}
+	queue.readers[reader] = struct{}{}
+	return reader
+}
+
+func (queue *SharedInvalQueue) maxMsgNum() int64 {
+	return queue.minMsgNum + int64(len(queue.msgs))
+}
+
+// Sends the messages to every reader, as postgres' SIInsertDataEntries.
+// If the queue overflows, the readers furthest behind are reset rather
+// than holding the messages for them.
+func (queue *SharedInvalQueue) SendMessages(msgs ...InvalMessage) {
+	queue.lock.Lock()
+	defer queue.lock.Unlock()
+	queue.msgs = append(queue.msgs, msgs...)
+	if len(queue.msgs) > MaxInvalQueueSize {
+		limit := queue.maxMsgNum() - MaxInvalQueueSize/2
+		for reader := range queue.readers {
+			if reader.nextMsgNum < limit {
+				reader.reset = true
+				reader.nextMsgNum = queue.maxMsgNum()
+			}
+		}
+	}
+	queue.cleanup()
+}
+
+// Drops the messages every reader has read.
+func (queue *SharedInvalQueue) cleanup() {
+	min := queue.maxMsgNum()
+	for reader := range queue.readers {
+		if reader.nextMsgNum < min {
+			min = reader.nextMsgNum
+		}
+	}
+	queue.msgs = append([]InvalMessage(nil), queue.msgs[min-queue.minMsgNum:]...)
+	queue.minMsgNum = min
+}
+
+// Returns the messages sent since the last call, as postgres'
+// SIGetDataEntries.  If reset is true, messages were missed and the
+// caller must flush everything instead.
+func (reader *InvalReader) ReadMessages() (msgs []InvalMessage, reset bool) {
+	queue := reader.queue
+	queue.lock.Lock()
+	defer queue.lock.Unlock()
+	if reader.reset {
+		reader.reset = false
+		return nil, true
+	}
+	msgs = append(msgs, queue.msgs[reader.nextMsgNum-queue.minMsgNum:]...)
+	reader.nextMsgNum = queue.maxMsgNum()
+	queue.cleanup()
+	return msgs, false
+}
+
+// Removes the reader from its queue.
+func (reader *InvalReader) Close() {
+	queue := reader.queue
+	queue.lock.Lock()
+	defer queue.lock.Unlock()
+	delete(queue.readers, reader)
+	queue.cleanup()
+}
+
+// Sends a message flushing the relcache entry of relid, as postgres'
+// CacheInvalidateRelcacheByRelid.
+func CacheInvalidateRelcache(queue *SharedInvalQueue, relid system.Oid) {
+	queue.SendMessages(InvalMessage{Kind: RelcacheInval, RelId: relid})
+}
+
+// Sends the messages a change of the catalog tuple calls for, as postgres'
+// CacheInvalidateHeapTuple: one for each syscache on the catalog, flushing
+// the entries with the keys of the tuple, and one for the relation that
+// the tuple describes, if any.  The caller sends them for both the old and
+// the new version of an updated tuple.  They are sent right away rather
+// than at commit, as the tuple is changed in place.
+func CacheInvalidateHeapTuple(queue *SharedInvalQueue, relid system.Oid, tuple Tuple) error {
+	msgs, err := heapTupleInvalMessages(relid, tuple)
+	if err != nil {
+		return err
+	}
+	queue.SendMessages(msgs...)
+	return nil
+}
+
+// Returns the messages CacheInvalidateHeapTuple sends.
+func heapTupleInvalMessages(relid system.Oid, tuple Tuple) ([]InvalMessage, error) {
+	var msgs []InvalMessage
+	for id, info := range cacheInfo {
+		if info.relid != relid {
+			continue
+		}
+		values := make([]system.Datum, len(info.keys))
+		for i, attnum := range info.keys {
+			values[i] = tuple.Fetch(attnum)
+		}
+		hash, err := computeCacheHash(SysCacheId(id), values)
+		if err != nil {
+			return nil, err
+		}
+		msgs = append(msgs, InvalMessage{Kind: CatcacheInval, CacheId: SysCacheId(id), HashValue: hash})
+	}
+
+	var relids []system.Oid
+	switch relid {
+	case ClassRelId:
+		relids = append(relids, tuple.Fetch(system.OidAttrNumber).(system.Oid))
+	case AttributeRelId:
+		relids = append(relids, tuple.Fetch(Anum_attribute_attrelid).(system.Oid))
+	case AttrDefaultRelId:
+		relids = append(relids, tuple.Fetch(Anum_attrdef_adrelid).(system.Oid))
+	case IndexRelId:
+		relids = append(relids, tuple.Fetch(Anum_index_indexrelid).(system.Oid),
+			tuple.Fetch(Anum_index_indrelid).(system.Oid))
+	}
+	for _, relid := range relids {
+		msgs = append(msgs, InvalMessage{Kind: RelcacheInval, RelId: relid})
+	}
+	return msgs, nil
+}
@@ -0,0 +1,156 @@
+package access
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	"bigpot/system"
+)
+
+// IndexTuple is an index entry, as postgres' IndexTupleData.  It begins
+// with the heap tid (block and offset), followed by a 2-byte info word
+// holding the tuple size and flags.  If any column is NULL, a null bitmap
+// follows, in which a set bit means the column is not NULL as with heap
+// tuples.  Column values start at the max-aligned data offset.
+type IndexTuple []byte
+
+const (
+	indexSizeMask = 0x1FFF
+	indexVarMask  = 0x4000
+	indexNullMask = 0x8000
+
+	indexInfoOffset = 6
+	// size of the fixed part of an index tuple
+	indexTupleHeaderSize = 8
+)
+
+// Forms an index tuple from the column values.  The tid is left invalid.
+func FormIndexTuple(values []system.Datum, tupdesc *TupleDesc) (IndexTuple, error) {
+	natts := len(tupdesc.Attrs)
+	hasnull := false
+	hasvar := false
+	for i, val := range values {
+		if val == nil {
+			hasnull = true
+		} else if tupdesc.Attrs[i].Type.IsVarlen() {
+			hasvar = true
+		}
+	}
+
+	hoff := uintptr(indexTupleHeaderSize)
+	if hasnull {
+		hoff += uintptr(bitmapLength(natts))
+	}
+	hoff = system.MaxAlign(hoff)
+
+	var buf bytes.Buffer
+	buf.Write(make([]byte, hoff))
+	for _, val := range values {
+		if val == nil {
+			continue
+		}
+		if _, err := val.ToBytes(&buf); err != nil {
+			return nil, err
+		}
+	}
+
+	itup := IndexTuple(buf.Bytes())
+	size := len(itup)
+	if size&indexSizeMask != size {
+		return nil, system.Ereport(system.ProgramLimitExceeded,
+			"index row requires %d bytes, maximum size is %d", size, indexSizeMask)
+	}
+
+	info := uint16(size)
+	if hasvar {
+		info |= indexVarMask
+	}
+	if hasnull {
+		info |= indexNullMask
+		bits := itup[indexTupleHeaderSize:]
+		for i, val := range values {
+			if val != nil {
+				bits[i>>3] |= 1 << uint(i&0x07)
+			}
+		}
+	}
+	binary.LittleEndian.PutUint16(itup[indexInfoOffset:], info)
+	itup.SetTid(system.InvalidItemPointer)
+
+	return itup, nil
+}
+
+// Returns a copy of the tuple that doesn't share the page memory.
+func (itup IndexTuple) Copy() IndexTuple {
+	return IndexTuple(append([]byte(nil), itup...))
+}
+
+func (itup IndexTuple) Tid() system.ItemPointer {
+	block := binary.LittleEndian.Uint32(itup[0:])
+	offset := binary.LittleEndian.Uint16(itup[4:])
+	return system.MakeItemPointer(system.BlockNumber(block), system.OffsetNumber(offset))
+}
+
+func (itup IndexTuple) SetTid(tid system.ItemPointer) {
+	binary.LittleEndian.PutUint32(itup[0:], uint32(tid.BlockNumber()))
+	binary.LittleEndian.PutUint16(itup[4:], uint16(tid.OffsetNumber()))
+}
+
+func (itup IndexTuple) info() uint16 {
+	return binary.LittleEndian.Uint16(itup[indexInfoOffset:])
+}
+
+func (itup IndexTuple) Size() int {
+	return int(itup.info() & indexSizeMask)
+}
+
+func (itup IndexTuple) HasNulls() bool {
+	return itup.info()&indexNullMask != 0
+}
+
+func (itup IndexTuple) HasVarWidths() bool {
+	return itup.info()&indexVarMask != 0
+}
+
+func (itup IndexTuple) IsNull(attnum system.AttrNumber) bool {
+	if !itup.HasNulls() {
+		return false
+	}
+	bit := itup[indexTupleHeaderSize+int(attnum-1)>>3]
+	return bit&(1<<uint((attnum-1)&0x07)) == 0
+}
+
+func (itup IndexTuple) dataOffset(natts int) int {
+	hoff := uintptr(indexTupleHeaderSize)
+	if itup.HasNulls() {
+		hoff += uintptr(bitmapLength(natts))
+	}
+	return int(system.MaxAlign(hoff))
+}
+
+// Returns the value of the index column.
+func (itup IndexTuple) Fetch(attnum system.AttrNumber, tupdesc *TupleDesc) system.Datum {
+	if itup.IsNull(attnum) {
+		return nil
+	}
+	reader := bytes.NewReader(itup[itup.dataOffset(len(tupdesc.Attrs)):itup.Size()])
+	for i := system.AttrNumber(1); i < attnum; i++ {
+		if !itup.IsNull(i) {
+			system.DatumFromBytes(reader, tupdesc.Attrs[i-1].TypeId)
+		}
+	}
+	return system.DatumFromBytes(reader, tupdesc.Attrs[attnum-1].TypeId)
+}
+
+// Returns the values of all the index columns, as postgres'
+// index_deform_tuple.
+func (itup IndexTuple) Values(tupdesc *TupleDesc) []system.Datum {
+	values := make([]system.Datum, len(tupdesc.Attrs))
+	reader := bytes.NewReader(itup[itup.dataOffset(len(tupdesc.Attrs)):itup.Size()])
+	for i, attr := range tupdesc.Attrs {
+		if !itup.IsNull(system.AttrNumber(i + 1)) {
+			values[i] = system.DatumFromBytes(reader, attr.TypeId)
+		}
+	}
+	return values
+}
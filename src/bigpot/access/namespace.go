@@ -0,0 +1,52 @@
+package access
+
+import (
+	"bigpot/system"
+)
+
+// The namespaces unqualified names are looked up in, as postgres'
+// search_path with bp_catalog first.  New objects go to the first one
+// after bp_catalog.
+var searchPath = []system.Oid{CatalogNamespaceId, PublicNamespaceId}
+
+// Returns the oid of the namespace, as postgres' LookupExplicitNamespace.
+func LookupNamespace(nspname system.Name, syscache *SysCache) (system.Oid, error) {
+	nspid, err := syscache.GetSysCacheOid(NamespaceNameCache, nspname)
+	if err != nil {
+		return system.InvalidOid, err
+	} else if nspid == system.InvalidOid {
+		return system.InvalidOid, system.Ereport(system.InvalidSchemaName,
+			"schema \"%s\" does not exist", nspname)
+	}
+	return nspid, nil
+}
+
+// Returns the oid of the relation named relname in the namespace
+// nspname, or in the search path if nspname is empty, as postgres'
+// RangeVarGetRelid.  It is InvalidOid if there is no such relation.
+func RangeVarGetRelid(nspname, relname system.Name, syscache *SysCache) (system.Oid, error) {
+	namespaces := searchPath
+	if nspname != "" {
+		nspid, err := LookupNamespace(nspname, syscache)
+		if err != nil {
+			return system.InvalidOid, err
+		}
+		namespaces = []system.Oid{nspid}
+	}
+	for _, nspid := range namespaces {
+		relid, err := syscache.GetSysCacheOid(RelNameNspCache, relname, nspid)
+		if err != nil || relid != system.InvalidOid {
+			return relid, err
+		}
+	}
+	return system.InvalidOid, nil
+}
+
+// Returns the namespace a new object named with nspname goes to, as
+// postgres' RangeVarGetCreationNamespace.
+func RangeVarGetCreationNamespace(nspname system.Name, syscache *SysCache) (system.Oid, error) {
+	if nspname != "" {
+		return LookupNamespace(nspname, syscache)
+	}
+	return searchPath[1], nil
+}
@@ -0,0 +1,406 @@
+package access
+
+import (
+	"bigpot/storage"
+	"bigpot/system"
+)
+
+// Inserts the index tuple into the tree, as postgres' _bt_doinsert.
+//
+// In a unique index, the new item goes before the equal keys, so that the
+// uniqueness check and the insertion happen on the same locked page.
+// Otherwise it goes after them.
+func btDoInsert(index *IndexRelation, itup IndexTuple, heap *HeapRelation,
+	bufMgr storage.BufferManager) error {
+	if itup.Size() > btMaxItemSize {
+		return system.Ereport(system.ProgramLimitExceeded,
+			"index row size %d exceeds maximum %d for index \"%s\"",
+			itup.Size(), btMaxItemSize, index.RelName)
+	}
+
+	keys, err := btMakeScanKey(index, itup.Values(index.RelDesc))
+	if err != nil {
+		return err
+	}
+
+	nextKey := !index.Unique
+	stack, buf, err := btSearch(index, keys, nextKey, btWrite, bufMgr)
+	if err != nil {
+		return err
+	}
+
+	offset := btBinSrch(index, buf.GetPage(), keys, nextKey)
+
+	// NULLs never conflict with each other.
+	if index.Unique && !itup.HasNulls() {
+		if err := btCheckUnique(index, keys, heap, buf, offset, bufMgr); err != nil {
+			btRelBuf(bufMgr, buf, btWrite)
+			return err
+		}
+	}
+
+	return btInsertOnPage(index, buf, stack, itup, offset, bufMgr)
+}
+
+// Checks that no live heap tuple has the same key, as postgres'
+// _bt_check_unique.  The equal keys start at the offset of the
+// write-locked leaf page, and may continue on the right siblings.
+func btCheckUnique(index *IndexRelation, keys []ScanKey, heap *HeapRelation, buf storage.Buffer,
+	offset system.OffsetNumber, bufMgr storage.BufferManager) error {
+	page := buf.GetPage()
+	nbuf := storage.InvalidBuffer()
+	defer func() {
+		if nbuf.IsValid() {
+			btRelBuf(bufMgr, nbuf, btRead)
+		}
+	}()
+
+	for {
+		opaque := btOpaque(page)
+		maxOff := page.MaxOffsetNumber()
+		for ; offset <= maxOff; offset++ {
+			if btCompare(index, keys, page, offset) != 0 {
+				// no more equal keys
+				return nil
+			}
+			tid := btItem(page, offset).Tid()
+			if heap != nil {
+				tuple, hbuf, err := heap.Fetch(tid, bufMgr)
+				if err != nil {
+					return err
+				}
+				if tuple == nil {
+					// the heap tuple is gone; this entry is dead
+					continue
+				}
+				bufMgr.ReleaseBuffer(hbuf)
+			}
+			return system.Ereport(system.UniqueViolation,
+				"duplicate key value violates unique constraint \"%s\"", index.RelName)
+		}
+
+		// The equal keys may continue on the right sibling if the key
+		// equals the high key.
+		if opaque.isRightmost() || btCompare(index, keys, page, btHikey) != 0 {
+			return nil
+		}
+		next := opaque.next
+		if nbuf.IsValid() {
+			btRelBuf(bufMgr, nbuf, btRead)
+			nbuf = storage.InvalidBuffer()
+		}
+		var err error
+		if nbuf, err = btGetBuf(index, next, btRead, bufMgr); err != nil {
+			nbuf = storage.InvalidBuffer()
+			return err
+		}
+		page = nbuf.GetPage()
+		offset = btOpaque(page).firstDataKey()
+	}
+}
+
+// Inserts the item at the offset of the write-locked page, splitting it if
+// it doesn't fit, as postgres' _bt_insertonpg.  The buffer is released.
+func btInsertOnPage(index *IndexRelation, buf storage.Buffer, stack *btStack, itup IndexTuple,
+	offset system.OffsetNumber, bufMgr storage.BufferManager) error {
+	page := buf.GetPage()
+	opaque := btOpaque(page)
+
+	if page.FreeSpace() < uint(system.MaxAlign(uintptr(len(itup)))) {
+		isRoot := opaque.isRoot()
+		rbuf, err := btSplit(index, buf, offset, itup, bufMgr)
+		if err != nil {
+			btRelBuf(bufMgr, buf, btWrite)
+			return err
+		}
+		return btInsertParent(index, buf, rbuf, stack, isRoot, bufMgr)
+	}
+
+	if page.AddItem(itup, offset, false, false) == system.InvalidOffsetNumber {
+		btRelBuf(bufMgr, buf, btWrite)
+		return system.Elog("failed to add new item to block %d in index \"%s\"",
+			buf.BlockNumber(), index.RelName)
+	}
+	buf.MarkDirty()
+	btRelBuf(bufMgr, buf, btWrite)
+	return nil
+}
+
+// Splits the write-locked page, putting the new item at the offset, as
+// postgres' _bt_split.  The left half stays on the original page and the
+// right half moves to a new page, which is returned write-locked.  The
+// first key on the right page becomes the high key of the left page.
+func btSplit(index *IndexRelation, buf storage.Buffer, newItemOff system.OffsetNumber, newItem IndexTuple,
+	bufMgr storage.BufferManager) (storage.Buffer, error) {
+	origPage := buf.GetPage()
+	oopaque := btOpaque(origPage)
+
+	// Collect the data items with the new one in place.
+	var items []IndexTuple
+	firstKey := oopaque.firstDataKey()
+	maxOff := origPage.MaxOffsetNumber()
+	for offset := firstKey; offset <= maxOff; offset++ {
+		if offset == newItemOff {
+			items = append(items, newItem)
+		}
+		items = append(items, btItem(origPage, offset).Copy())
+	}
+	if newItemOff > maxOff {
+		items = append(items, newItem)
+	}
+	var oldHikey IndexTuple
+	if !oopaque.isRightmost() {
+		oldHikey = btItem(origPage, btHikey).Copy()
+	}
+
+	firstRight := btFindSplitLoc(origPage, items, oldHikey)
+
+	// Build the left page in a temporary page, to copy back at the end.
+	leftPage := storage.NewPage(new(storage.Block))
+	btPageInit(leftPage)
+	lopaque := btOpaque(leftPage)
+	*lopaque = *oopaque
+	lopaque.flags &^= btpRoot
+
+	rbuf, rightPage, err := btNewPage(index, oopaque.level, lopaque.flags, bufMgr)
+	if err != nil {
+		return storage.InvalidBuffer(), err
+	}
+	ropaque := btOpaque(rightPage)
+	ropaque.prev = buf.BlockNumber()
+	ropaque.next = oopaque.next
+	lopaque.next = rbuf.BlockNumber()
+
+	failed := func() (storage.Buffer, error) {
+		btRelBuf(bufMgr, rbuf, btWrite)
+		return storage.InvalidBuffer(), system.Elog("failed to add item to the page in index \"%s\"",
+			index.RelName)
+	}
+
+	// The right page keeps the original high key, if any.
+	if oldHikey != nil {
+		if rightPage.AddItem(oldHikey, btHikey, false, false) == system.InvalidOffsetNumber {
+			return failed()
+		}
+	}
+	for _, item := range items[firstRight:] {
+		if rightPage.AddItem(item, system.InvalidOffsetNumber, false, false) == system.InvalidOffsetNumber {
+			return failed()
+		}
+	}
+
+	// The left page's high key is the first key on the right page.
+	if leftPage.AddItem(items[firstRight], btHikey, false, false) == system.InvalidOffsetNumber {
+		return failed()
+	}
+	for _, item := range items[:firstRight] {
+		if leftPage.AddItem(item, system.InvalidOffsetNumber, false, false) == system.InvalidOffsetNumber {
+			return failed()
+		}
+	}
+
+	// Fix the left-link of the page that was to the right of the
+	// original page.
+	if !ropaque.isRightmost() {
+		sbuf, err := btGetBuf(index, ropaque.next, btWrite, bufMgr)
+		if err != nil {
+			btRelBuf(bufMgr, rbuf, btWrite)
+			return storage.InvalidBuffer(), err
+		}
+		sopaque := btOpaque(sbuf.GetPage())
+		if sopaque.prev != buf.BlockNumber() {
+			btRelBuf(bufMgr, sbuf, btWrite)
+			btRelBuf(bufMgr, rbuf, btWrite)
+			return storage.InvalidBuffer(), system.Elog(
+				"right sibling's left-link doesn't match: block %d links to %d instead of expected %d in index \"%s\"",
+				ropaque.next, sopaque.prev, buf.BlockNumber(), index.RelName)
+		}
+		sopaque.prev = rbuf.BlockNumber()
+		sbuf.MarkDirty()
+		btRelBuf(bufMgr, sbuf, btWrite)
+	}
+
+	origPage.CopyFrom(leftPage)
+	buf.MarkDirty()
+	rbuf.MarkDirty()
+	return rbuf, nil
+}
+
+// Chooses the index of the first item going to the right page, so that
+// both pages get about the same amount of data, as postgres'
+// _bt_findsplitloc.  Each side keeps at least one item.
+func btFindSplitLoc(page *storage.Page, items []IndexTuple, oldHikey IndexTuple) int {
+	itemSize := func(item IndexTuple) int {
+		return int(system.MaxAlign(uintptr(len(item)))) + 4 // with its line pointer
+	}
+
+	total := 0
+	for _, item := range items {
+		total += itemSize(item)
+	}
+	rightSize := total
+	if oldHikey != nil {
+		rightSize += itemSize(oldHikey)
+	}
+
+	best := 1
+	bestDelta := -1
+	leftSize := 0
+	for i := 1; i < len(items); i++ {
+		leftSize += itemSize(items[i-1])
+		rightSize -= itemSize(items[i-1])
+		// the left page also gets the new high key, a copy of items[i]
+		delta := leftSize + itemSize(items[i]) - rightSize
+		if delta < 0 {
+			delta = -delta
+		}
+		if bestDelta < 0 || delta < bestDelta {
+			best, bestDelta = i, delta
+		}
+	}
+	return best
+}
+
+// Inserts the downlink to the new right page into the parent after a
+// split, as postgres' _bt_insert_parent.  Both buffers are released.
+func btInsertParent(index *IndexRelation, buf, rbuf storage.Buffer, stack *btStack, isRoot bool,
+	bufMgr storage.BufferManager) error {
+	if isRoot {
+		err := btNewRoot(index, buf, rbuf, bufMgr)
+		btRelBuf(bufMgr, rbuf, btWrite)
+		btRelBuf(bufMgr, buf, btWrite)
+		return err
+	}
+
+	page := buf.GetPage()
+	level := btOpaque(page).level
+
+	// The downlink is keyed by the left page's new high key.
+	ritem := btItem(page, btHikey).Copy()
+	ritem.SetTid(system.MakeItemPointer(rbuf.BlockNumber(), btHikey))
+
+	if stack == nil {
+		// The root was split by someone else after our descent started,
+		// so we didn't see the parent level.  Start from its leftmost
+		// page and let btGetStackBuf move right.
+		var err error
+		if stack, err = btFakeStack(index, level+1, bufMgr); err != nil {
+			btRelBuf(bufMgr, rbuf, btWrite)
+			btRelBuf(bufMgr, buf, btWrite)
+			return err
+		}
+	}
+
+	pbuf, err := btGetStackBuf(index, stack, buf.BlockNumber(), bufMgr)
+	btRelBuf(bufMgr, rbuf, btWrite)
+	btRelBuf(bufMgr, buf, btWrite)
+	if err != nil {
+		return err
+	}
+	return btInsertOnPage(index, pbuf, stack.parent, ritem, stack.offset+1, bufMgr)
+}
+
+// Makes a stack entry of the leftmost page on the level.
+func btFakeStack(index *IndexRelation, level uint32, bufMgr storage.BufferManager) (*btStack, error) {
+	buf, err := btGetRoot(index, btRead, bufMgr)
+	if err != nil {
+		return nil, err
+	}
+	buf, err = btGetEndpoint(index, buf, level, false, bufMgr)
+	if err != nil {
+		return nil, err
+	}
+	stack := &btStack{
+		blkno:  buf.BlockNumber(),
+		offset: btOpaque(buf.GetPage()).firstDataKey(),
+	}
+	btRelBuf(bufMgr, buf, btRead)
+	return stack, nil
+}
+
+// Returns the write-locked parent page with the downlink to the child, as
+// postgres' _bt_getstackbuf.  The parent may have split since the
+// descent, so this moves right as needed.  The stack entry is updated to
+// the current location of the downlink.
+func btGetStackBuf(index *IndexRelation, stack *btStack, child system.BlockNumber,
+	bufMgr storage.BufferManager) (storage.Buffer, error) {
+	blkno := stack.blkno
+	start := stack.offset
+	for {
+		buf, err := btGetBuf(index, blkno, btWrite, bufMgr)
+		if err != nil {
+			return storage.InvalidBuffer(), err
+		}
+		page := buf.GetPage()
+		opaque := btOpaque(page)
+		minOff := opaque.firstDataKey()
+		maxOff := page.MaxOffsetNumber()
+		if start < minOff {
+			start = minOff
+		}
+
+		// Look forward from where the downlink was, then backward.
+		for offset := start; offset <= maxOff; offset++ {
+			if btItem(page, offset).Tid().BlockNumber() == child {
+				stack.blkno, stack.offset = blkno, offset
+				return buf, nil
+			}
+		}
+		for offset := start - 1; offset >= minOff && offset <= maxOff; offset-- {
+			if btItem(page, offset).Tid().BlockNumber() == child {
+				stack.blkno, stack.offset = blkno, offset
+				return buf, nil
+			}
+		}
+
+		if opaque.isRightmost() {
+			btRelBuf(bufMgr, buf, btWrite)
+			return storage.InvalidBuffer(), system.Elog(
+				"failed to re-find parent key in index \"%s\" for split pages %d",
+				index.RelName, child)
+		}
+		blkno = opaque.next
+		start = system.FirstOffsetNumber
+		btRelBuf(bufMgr, buf, btWrite)
+	}
+}
+
+// Makes a new root above the two halves of the split root, as postgres'
+// _bt_newroot.  The buffers remain locked.
+func btNewRoot(index *IndexRelation, lbuf, rbuf storage.Buffer, bufMgr storage.BufferManager) error {
+	lpage := lbuf.GetPage()
+	level := btOpaque(lpage).level + 1
+
+	metaBuf, err := btGetBuf(index, btMetaBlock, btWrite, bufMgr)
+	if err != nil {
+		return err
+	}
+	defer btRelBuf(bufMgr, metaBuf, btWrite)
+	meta, err := btReadMeta(index, metaBuf.GetPage())
+	if err != nil {
+		return err
+	}
+
+	rootBuf, rootPage, err := btNewPage(index, level, btpRoot, bufMgr)
+	if err != nil {
+		return err
+	}
+	defer btRelBuf(bufMgr, rootBuf, btWrite)
+
+	// The first item points to the left page.  Its key is minus infinity,
+	// so any key does; use the same as the right one.
+	ritem := btItem(lpage, btHikey).Copy()
+	litem := ritem.Copy()
+	litem.SetTid(system.MakeItemPointer(lbuf.BlockNumber(), btHikey))
+	ritem.SetTid(system.MakeItemPointer(rbuf.BlockNumber(), btHikey))
+	if rootPage.AddItem(litem, btHikey, false, false) == system.InvalidOffsetNumber ||
+		rootPage.AddItem(ritem, btFirstKey, false, false) == system.InvalidOffsetNumber {
+		return system.Elog("failed to add items to the new root page in index \"%s\"", index.RelName)
+	}
+	rootBuf.MarkDirty()
+
+	meta.root = rootBuf.BlockNumber()
+	meta.level = level
+	metaBuf.MarkDirty()
+	return nil
+}
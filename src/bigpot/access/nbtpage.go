@@ -0,0 +1,171 @@
+package access
+
+import (
+	"unsafe"
+
+	"bigpot/storage"
+	"bigpot/system"
+)
+
+// Lock modes of btree buffers.
+const (
+	btRead  = 1
+	btWrite = 2
+)
+
+// Initializes the metapage of an empty index, as postgres' _bt_initmetapage.
+func btInitMetaPage(index *IndexRelation, bufMgr storage.BufferManager) error {
+	buf, err := bufMgr.ReadBuffer(index.RelNode, storage.NewBlock)
+	if err != nil {
+		return err
+	}
+	if buf.BlockNumber() != btMetaBlock {
+		bufMgr.ReleaseBuffer(buf)
+		return system.Elog("index \"%s\" is not empty", index.RelName)
+	}
+	buf.Lock()
+	page := buf.GetPage()
+	btPageInit(page)
+	opaque := btOpaque(page)
+	opaque.flags = btpMeta
+
+	meta := btMeta(page)
+	meta.magic = btMagic
+	meta.version = btVersion
+	meta.root = pNone
+	meta.level = 0
+
+	// Set pd_lower just past the end of the metadata, so the page looks
+	// like it has its contents in use.
+	page.SetLower(page.ContentsOffset(unsafe.Sizeof(btMetaPageData{})))
+
+	buf.MarkDirty()
+	btRelBuf(bufMgr, buf, btWrite)
+	return nil
+}
+
+// Initializes a new btree page.
+func btPageInit(page *storage.Page) {
+	page.Init(sizeOfBtPageOpaque)
+}
+
+// Reads the block and locks it in the access mode, as postgres' _bt_getbuf.
+// Pass storage.NewBlock to allocate a new page; the new page is not
+// initialized.
+func btGetBuf(index *IndexRelation, blkno system.BlockNumber, access int,
+	bufMgr storage.BufferManager) (storage.Buffer, error) {
+	buf, err := bufMgr.ReadBuffer(index.RelNode, blkno)
+	if err != nil {
+		return storage.InvalidBuffer(), err
+	}
+	if access == btWrite {
+		buf.Lock()
+	} else {
+		buf.RLock()
+	}
+	return buf, nil
+}
+
+// Releases the lock on the buffer and moves to the block, as postgres'
+// _bt_relandgetbuf.
+func btRelAndGetBuf(index *IndexRelation, buf storage.Buffer, blkno system.BlockNumber, access int,
+	bufMgr storage.BufferManager) (storage.Buffer, error) {
+	btRelBuf(bufMgr, buf, access)
+	return btGetBuf(index, blkno, access, bufMgr)
+}
+
+// Unlocks and releases the buffer, as postgres' _bt_relbuf.
+func btRelBuf(bufMgr storage.BufferManager, buf storage.Buffer, access int) {
+	if access == btWrite {
+		buf.Unlock()
+	} else {
+		buf.RUnlock()
+	}
+	bufMgr.ReleaseBuffer(buf)
+}
+
+func btReadMeta(index *IndexRelation, page *storage.Page) (*btMetaPageData, error) {
+	meta := btMeta(page)
+	if btOpaque(page).flags&btpMeta == 0 || meta.magic != btMagic {
+		return nil, system.Elog("index \"%s\" is not a btree", index.RelName)
+	}
+	if meta.version != btVersion {
+		return nil, system.Elog("version mismatch in index \"%s\": file version %d, code version %d",
+			index.RelName, meta.version, btVersion)
+	}
+	return meta, nil
+}
+
+// Returns the root page locked in the access mode, as postgres'
+// _bt_getroot.  If the index is empty, a leaf root page is created when
+// access is btWrite, or an invalid buffer is returned for btRead.
+func btGetRoot(index *IndexRelation, access int, bufMgr storage.BufferManager) (storage.Buffer, error) {
+	metaBuf, err := btGetBuf(index, btMetaBlock, btRead, bufMgr)
+	if err != nil {
+		return storage.InvalidBuffer(), err
+	}
+	meta, err := btReadMeta(index, metaBuf.GetPage())
+	if err != nil {
+		btRelBuf(bufMgr, metaBuf, btRead)
+		return storage.InvalidBuffer(), err
+	}
+
+	if meta.root == pNone {
+		if access == btRead {
+			btRelBuf(bufMgr, metaBuf, btRead)
+			return storage.InvalidBuffer(), nil
+		}
+
+		// Get write lock on the metapage and check again, as someone else
+		// may have created the root in the meantime.
+		metaBuf.RUnlock()
+		metaBuf.Lock()
+		if meta.root == pNone {
+			rootBuf, err := btGetBuf(index, storage.NewBlock, btWrite, bufMgr)
+			if err != nil {
+				btRelBuf(bufMgr, metaBuf, btWrite)
+				return storage.InvalidBuffer(), err
+			}
+			rootPage := rootBuf.GetPage()
+			btPageInit(rootPage)
+			opaque := btOpaque(rootPage)
+			opaque.prev, opaque.next = pNone, pNone
+			opaque.level = 0
+			opaque.flags = btpLeaf | btpRoot
+			rootBuf.MarkDirty()
+
+			meta.root = rootBuf.BlockNumber()
+			meta.level = 0
+			metaBuf.MarkDirty()
+			btRelBuf(bufMgr, metaBuf, btWrite)
+			return rootBuf, nil
+		}
+		// someone else made the root; fall through with the write lock
+		metaBuf.Unlock()
+		metaBuf.RLock()
+	}
+
+	rootBlk := meta.root
+	btRelBuf(bufMgr, metaBuf, btRead)
+
+	// The root may have split since we read the metapage, in which case
+	// the new root is above it.  Descending from a former root still works
+	// thanks to the right-links, as it's the leftmost page of its level.
+	return btGetBuf(index, rootBlk, access, bufMgr)
+}
+
+// Allocates a new page, initializes it and returns it write-locked.
+func btNewPage(index *IndexRelation, level uint32, flags uint16,
+	bufMgr storage.BufferManager) (storage.Buffer, *storage.Page, error) {
+	buf, err := btGetBuf(index, storage.NewBlock, btWrite, bufMgr)
+	if err != nil {
+		return storage.InvalidBuffer(), nil, err
+	}
+	page := buf.GetPage()
+	btPageInit(page)
+	opaque := btOpaque(page)
+	opaque.prev, opaque.next = pNone, pNone
+	opaque.level = level
+	opaque.flags = flags
+	return buf, page, nil
+}
@@ -0,0 +1,207 @@
+package access
+
+import (
+	"unsafe"
+
+	"bigpot/storage"
+	"bigpot/system"
+)
+
+// The btree access method, after Lehman and Yao's high-concurrency btree
+// as postgres' nbtree.  Every page but the rightmost one on each level has
+// a high key, an upper bound of the keys on the page, and a right-link to
+// its right sibling.  A search that finds its key beyond the high key
+// moves right, which is how a reader copes with a page split concurrent
+// with its descent, without locking the parent.
+//
+// Block 0 is the metapage that locates the root.  Each of the other pages
+// keeps btPageOpaque in its special space.  Items on leaf pages point to
+// heap tuples, and items on internal pages point to the child pages,
+// keyed by the lower bound of the keys in the child.  The first data item
+// on an internal page is treated as minus infinity whatever its key is.
+//
+// Duplicate keys are allowed unless the index is unique.  A run of equal
+// keys may span pages, so the lower bound on a downlink means the child
+// may also hold keys equal to the separator on its left sibling.
+type btree struct{}
+
+// The btree IndexAm.
+var BTreeAm IndexAm = btree{}
+
+// btPageOpaque is kept in the special space of every btree page except
+// the metapage, as postgres' BTPageOpaqueData.
+type btPageOpaque struct {
+	prev  system.BlockNumber // left sibling, or P_NONE if leftmost
+	next  system.BlockNumber // right sibling, or P_NONE if rightmost
+	level uint32             // tree level, zero for leaf pages
+	flags uint16
+	cycle uint16 // vacuum cycle ID, unused for now
+}
+
+// btPageOpaque flags
+const (
+	btpLeaf    = 1 << 0 // leaf page
+	btpRoot    = 1 << 1 // root page
+	btpDeleted = 1 << 2 // page has been deleted from tree
+	btpMeta    = 1 << 3 // meta-page
+)
+
+const pNone = system.BlockNumber(0)
+
+// Offsets of the high key and the first data key on a page that isn't
+// rightmost.  On the rightmost page, data starts at btHikey.
+const (
+	btHikey     = system.OffsetNumber(1)
+	btFirstKey  = system.OffsetNumber(2)
+	btMetaBlock = system.BlockNumber(0)
+)
+
+// btMetaPageData is kept in the contents of the metapage.
+type btMetaPageData struct {
+	magic   uint32
+	version uint32
+	root    system.BlockNumber // current root location
+	level   uint32             // tree level of the root page
+}
+
+const (
+	btMagic   = 0x053162
+	btVersion = 1
+)
+
+var sizeOfBtPageOpaque = unsafe.Sizeof(btPageOpaque{})
+
+// The maximum size of an index tuple, which ensures at least three items
+// fit on a page, as postgres' BTMaxItemSize.
+var btMaxItemSize int
+
+func init() {
+	page := storage.NewPage(new(storage.Block))
+	page.Init(sizeOfBtPageOpaque)
+	free := page.FreeSpace() - 2*uint(unsafe.Sizeof(storage.ItemId(0)))
+	btMaxItemSize = int(free/3) &^ (system.MaximumAlignof - 1)
+}
+
+func btOpaque(page *storage.Page) *btPageOpaque {
+	special := page.SpecialSpace()
+	return (*btPageOpaque)(unsafe.Pointer(&special[0]))
+}
+
+func btMeta(page *storage.Page) *btMetaPageData {
+	contents := page.Contents()
+	return (*btMetaPageData)(unsafe.Pointer(&contents[0]))
+}
+
+func (opaque *btPageOpaque) isLeaf() bool {
+	return opaque.flags&btpLeaf != 0
+}
+
+func (opaque *btPageOpaque) isRoot() bool {
+	return opaque.flags&btpRoot != 0
+}
+
+func (opaque *btPageOpaque) isLeftmost() bool {
+	return opaque.prev == pNone
+}
+
+func (opaque *btPageOpaque) isRightmost() bool {
+	return opaque.next == pNone
+}
+
+// Returns the offset of the first data item.
+func (opaque *btPageOpaque) firstDataKey() system.OffsetNumber {
+	if opaque.isRightmost() {
+		return btHikey
+	}
+	return btFirstKey
+}
+
+// Returns the index tuple at the offset of the page.
+func btItem(page *storage.Page, offset system.OffsetNumber) IndexTuple {
+	return IndexTuple(page.Item(page.ItemId(offset)))
+}
+
+// Makes the insertion scan key to find the position of the index tuple,
+// as postgres' _bt_mkscankey.  The keys compare each column with the
+// column of the same type.
+func btMakeScanKey(index *IndexRelation, values []system.Datum) ([]ScanKey, error) {
+	keys := make([]ScanKey, len(index.RelDesc.Attrs))
+	for i, attr := range index.RelDesc.Attrs {
+		fn, err := system.LookupCompare(attr.TypeId, attr.TypeId)
+		if err != nil {
+			return nil, err
+		}
+		keys[i] = ScanKeyInit(system.AttrNumber(i+1), BTEqualStrategyNumber, fn, values[i])
+	}
+	return keys, nil
+}
+
+// Compares the insertion scan key with the item at the offset, as
+// postgres' _bt_compare.  The result is negative, zero or positive when
+// the key is less than, equal to or greater than the item.  keys may cover
+// a prefix of the index columns.  NULLs sort after all the other values.
+func btCompare(index *IndexRelation, keys []ScanKey, page *storage.Page, offset system.OffsetNumber) int {
+	opaque := btOpaque(page)
+
+	// Force the first data key on internal pages to be minus infinity.
+	if !opaque.isLeaf() && offset == opaque.firstDataKey() {
+		return 1
+	}
+
+	itup := btItem(page, offset)
+	for i := range keys {
+		key := &keys[i]
+		val := itup.Fetch(key.AttNum, index.RelDesc)
+
+		var result int
+		if key.Flags&SkIsNull != 0 {
+			if val == nil {
+				result = 0 // NULL "=" NULL
+			} else {
+				result = 1 // NULL ">" NOT_NULL
+			}
+		} else if val == nil {
+			result = -1 // NOT_NULL "<" NULL
+		} else {
+			result = -key.Func(val, key.Val)
+		}
+		if result != 0 {
+			return result
+		}
+	}
+	return 0
+}
+
+// Implements IndexAm.Build.  Unlike postgres, this doesn't sort the heap
+// to load the leaves bottom up, but just inserts every tuple.
+func (bt btree) Build(heap *HeapRelation, index *IndexRelation, bufMgr storage.BufferManager) error {
+	if err := btInitMetaPage(index, bufMgr); err != nil {
+		return err
+	}
+	return indexBuildHeapScan(heap, index, bufMgr,
+		func(values []system.Datum, tid system.ItemPointer) error {
+			return bt.Insert(index, values, tid, heap, bufMgr)
+		})
+}
+
+// Implements IndexAm.Insert.
+func (bt btree) Insert(index *IndexRelation, values []system.Datum, tid system.ItemPointer,
+	heap *HeapRelation, bufMgr storage.BufferManager) error {
+	itup, err := FormIndexTuple(values, index.RelDesc)
+	if err != nil {
+		return err
+	}
+	itup.SetTid(tid)
+	return btDoInsert(index, itup, heap, bufMgr)
+}
+
+// Implements IndexAm.BeginScan.
+func (bt btree) BeginScan(index *IndexRelation, keys []ScanKey, bufMgr storage.BufferManager) (IndexScanDesc, error) {
+	scan := &btScan{
+		index:  index,
+		keys:   keys,
+		bufMgr: bufMgr,
+	}
+	scan.preprocessKeys()
+	return scan, nil
+}
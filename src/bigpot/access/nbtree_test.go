@@ -0,0 +1,259 @@
+package access
+
+import (
+	"fmt"
+	. "launchpad.net/gocheck"
+	"math/rand"
+	"os"
+
+	"bigpot/storage"
+	"bigpot/system"
+)
+
+// Makes a heap of (id int4, name name) and fills it with the rows.
+func makeTestHeap(c *C, bufMgr storage.BufferManager, relid system.Oid, rows [][]system.Datum) *HeapRelation {
+	tupdesc := &TupleDesc{
+		Attrs: []*Attribute{
+			{Name: "id", TypeId: system.Int4Type},
+			{Name: "name", TypeId: system.NameType},
+		},
+	}
+	initTupleDesc(tupdesc)
+	rel := &HeapRelation{RelId: relid, RelName: "t", RelDesc: tupdesc}
+	rel.initRelFileNode(TemplateDbId)
+	createRelFile(c, rel.RelNode)
+
+	var tuples []*HeapTuple
+	for _, row := range rows {
+		tuples = append(tuples, FormHeapTuple(row, tupdesc))
+	}
+	fillHeap(c, bufMgr, rel, tuples)
+	return rel
+}
+
+// Makes an empty btree index on the heap column.
+func makeTestIndex(c *C, heap *HeapRelation, relid system.Oid, attnum system.AttrNumber, unique bool) *IndexRelation {
+	attr := *heap.RelDesc.Attrs[attnum-1]
+	index := &IndexRelation{
+		RelId:     relid,
+		RelName:   system.Name(fmt.Sprintf("t_%s_idx", attr.Name)),
+		RelDesc:   &TupleDesc{Attrs: []*Attribute{&attr}},
+		HeapAttrs: []system.AttrNumber{attnum},
+		Unique:    unique,
+		Am:        BTreeAm,
+	}
+	index.initRelFileNode(TemplateDbId)
+	createRelFile(c, index.RelNode)
+	return index
+}
+
+// Runs an index scan and returns the heap column values.
+func indexScanAll(c *C, heap *HeapRelation, index *IndexRelation, keys []ScanKey, dir ScanDirection,
+	attnum system.AttrNumber, bufMgr storage.BufferManager) []system.Datum {
+	scan, err := IndexBeginScan(heap, index, keys, bufMgr)
+	c.Assert(err, IsNil)
+	defer scan.EndScan()
+	scan.Direction = dir
+
+	var result []system.Datum
+	for {
+		tuple, err := scan.Next()
+		c.Assert(err, IsNil)
+		if tuple == nil {
+			break
+		}
+		result = append(result, tuple.Fetch(attnum))
+	}
+	return result
+}
+
+func btreeLevel(c *C, index *IndexRelation, bufMgr storage.BufferManager) uint32 {
+	buf, err := btGetBuf(index, btMetaBlock, btRead, bufMgr)
+	c.Assert(err, IsNil)
+	defer btRelBuf(bufMgr, buf, btRead)
+	meta, err := btReadMeta(index, buf.GetPage())
+	c.Assert(err, IsNil)
+	return meta.level
+}
+
+func (s *MySuite) TestBTreeBuildAndScan(c *C) {
+	os.MkdirAll("base/1", 0700)
+	defer os.RemoveAll("base")
+	defer os.RemoveAll("global")
+	bufMgr := newBufferManager(c, 64)
+
+	// names are long in the index, so a few thousand make a three-level tree
+	const nrows = 5000
+	var rows [][]system.Datum
+	for _, i := range rand.New(rand.NewSource(1)).Perm(nrows) {
+		rows = append(rows, []system.Datum{system.Int4(i), system.Name(fmt.Sprintf("name%05d", i))})
+	}
+	heap := makeTestHeap(c, bufMgr, 20000, rows)
+	index := makeTestIndex(c, heap, 20001, 2, true)
+	c.Assert(index.Build(heap, bufMgr), IsNil)
+	c.Check(btreeLevel(c, index, bufMgr), Equals, uint32(2))
+
+	// full scans come back in order
+	names := indexScanAll(c, heap, index, nil, ForwardScanDirection, 2, bufMgr)
+	c.Assert(names, HasLen, nrows)
+	for i, name := range names {
+		c.Assert(name, Equals, system.Name(fmt.Sprintf("name%05d", i)))
+	}
+	names = indexScanAll(c, heap, index, nil, BackwardScanDirection, 2, bufMgr)
+	c.Assert(names, HasLen, nrows)
+	c.Check(names[0], Equals, system.Name("name04999"))
+	c.Check(names[nrows-1], Equals, system.Name("name00000"))
+
+	key := func(strategy StrategyNumber, name string) ScanKey {
+		key, err := MakeScanKey(1, strategy, system.NameType, system.NameType, system.Name(name))
+		c.Assert(err, IsNil)
+		return key
+	}
+
+	ids := indexScanAll(c, heap, index, []ScanKey{key(BTEqualStrategyNumber, "name03210")},
+		ForwardScanDirection, 1, bufMgr)
+	c.Check(ids, DeepEquals, []system.Datum{system.Int4(3210)})
+	ids = indexScanAll(c, heap, index, []ScanKey{key(BTEqualStrategyNumber, "name9")},
+		ForwardScanDirection, 1, bufMgr)
+	c.Check(ids, HasLen, 0)
+
+	// name00998 < name <= name01002, both ways
+	keys := []ScanKey{key(BTGreaterStrategyNumber, "name00998"), key(BTLessEqualStrategyNumber, "name01002")}
+	ids = indexScanAll(c, heap, index, keys, ForwardScanDirection, 1, bufMgr)
+	c.Check(ids, DeepEquals, []system.Datum{
+		system.Int4(999), system.Int4(1000), system.Int4(1001), system.Int4(1002),
+	})
+	ids = indexScanAll(c, heap, index, keys, BackwardScanDirection, 1, bufMgr)
+	c.Check(ids, DeepEquals, []system.Datum{
+		system.Int4(1002), system.Int4(1001), system.Int4(1000), system.Int4(999),
+	})
+
+	// open ranges
+	ids = indexScanAll(c, heap, index, []ScanKey{key(BTGreaterEqualStrategyNumber, "name04998")},
+		ForwardScanDirection, 1, bufMgr)
+	c.Check(ids, DeepEquals, []system.Datum{system.Int4(4998), system.Int4(4999)})
+	ids = indexScanAll(c, heap, index, []ScanKey{key(BTLessStrategyNumber, "name00002")},
+		BackwardScanDirection, 1, bufMgr)
+	c.Check(ids, DeepEquals, []system.Datum{system.Int4(1), system.Int4(0)})
+	ids = indexScanAll(c, heap, index, []ScanKey{key(BTLessStrategyNumber, "name00002")},
+		ForwardScanDirection, 1, bufMgr)
+	c.Check(ids, DeepEquals, []system.Datum{system.Int4(0), system.Int4(1)})
+}
+
+func (s *MySuite) TestBTreeUnique(c *C) {
+	os.MkdirAll("base/1", 0700)
+	defer os.RemoveAll("base")
+	defer os.RemoveAll("global")
+	bufMgr := newBufferManager(c, 16)
+
+	heap := makeTestHeap(c, bufMgr, 20000, [][]system.Datum{
+		{system.Int4(1), system.Name("a")},
+		{system.Int4(2), system.Name("b")},
+		{system.Int4(1), system.Name("c")},
+	})
+	index := makeTestIndex(c, heap, 20001, 1, true)
+	err := index.Build(heap, bufMgr)
+	c.Check(err, ErrorMatches, "duplicate key value violates unique constraint \"t_id_idx\"")
+	c.Check(err.(*system.Error).Code(), Equals, system.UniqueViolation)
+
+	// a dead heap tuple doesn't conflict, and NULLs never do
+	index = makeTestIndex(c, heap, 20002, 1, true)
+	c.Assert(btInitMetaPage(index, bufMgr), IsNil)
+	c.Check(index.Insert([]system.Datum{system.Int4(1)}, system.MakeItemPointer(0, 1), heap, bufMgr), IsNil)
+	c.Check(index.Insert([]system.Datum{system.Int4(3)}, system.MakeItemPointer(0, 99), heap, bufMgr), IsNil)
+	c.Check(index.Insert([]system.Datum{system.Int4(3)}, system.MakeItemPointer(0, 2), heap, bufMgr), IsNil)
+	c.Check(index.Insert([]system.Datum{nil}, system.MakeItemPointer(0, 2), heap, bufMgr), IsNil)
+	c.Check(index.Insert([]system.Datum{nil}, system.MakeItemPointer(0, 3), heap, bufMgr), IsNil)
+	err = index.Insert([]system.Datum{system.Int4(1)}, system.MakeItemPointer(0, 3), heap, bufMgr)
+	c.Check(err, ErrorMatches, "duplicate key value violates unique constraint \"t_id_idx\"")
+}
+
+func (s *MySuite) TestBTreeDuplicatesAndNulls(c *C) {
+	os.MkdirAll("base/1", 0700)
+	defer os.RemoveAll("base")
+	defer os.RemoveAll("global")
+	bufMgr := newBufferManager(c, 64)
+
+	// 3000 rows of 10 distinct ids, so that runs of equal keys span pages;
+	// every 7th id is NULL
+	var rows [][]system.Datum
+	nulls := 0
+	for i := 0; i < 3000; i++ {
+		id := system.Datum(system.Int4(i % 10))
+		if i%7 == 0 {
+			id = nil
+			nulls++
+		}
+		rows = append(rows, []system.Datum{id, system.Name(fmt.Sprintf("%d", i))})
+	}
+	heap := makeTestHeap(c, bufMgr, 20000, rows)
+	index := makeTestIndex(c, heap, 20001, 1, false)
+	c.Assert(index.Build(heap, bufMgr), IsNil)
+	c.Check(btreeLevel(c, index, bufMgr) > 0, Equals, true)
+
+	count := func(dir ScanDirection, keys ...ScanKey) int {
+		return len(indexScanAll(c, heap, index, keys, dir, 1, bufMgr))
+	}
+	key := func(strategy StrategyNumber, id int) ScanKey {
+		key, err := MakeScanKey(1, strategy, system.Int4Type, system.Int4Type, system.Int4(id))
+		c.Assert(err, IsNil)
+		return key
+	}
+
+	for _, dir := range []ScanDirection{ForwardScanDirection, BackwardScanDirection} {
+		c.Check(count(dir), Equals, 3000)
+		c.Check(count(dir, key(BTEqualStrategyNumber, 4)), Equals, 257)
+		c.Check(count(dir, key(BTGreaterEqualStrategyNumber, 5), key(BTLessStrategyNumber, 7)), Equals, 514)
+		c.Check(count(dir, MakeNullScanKey(1, true)), Equals, nulls)
+		c.Check(count(dir, MakeNullScanKey(1, false)), Equals, 3000-nulls)
+		c.Check(count(dir, MakeNullScanKey(1, false), key(BTGreaterStrategyNumber, 8)), Equals, 257)
+	}
+
+	// NULLs sort last
+	ids := indexScanAll(c, heap, index, nil, ForwardScanDirection, 1, bufMgr)
+	c.Check(ids[0], Equals, system.Datum(system.Int4(0)))
+	c.Check(ids[2999], IsNil)
+	ids = indexScanAll(c, heap, index, nil, BackwardScanDirection, 1, bufMgr)
+	c.Check(ids[0], IsNil)
+	c.Check(ids[2999], Equals, system.Datum(system.Int4(0)))
+
+	// the tids of a run of duplicates come back in insertion order
+	scan, err := IndexBeginScan(heap, index, []ScanKey{key(BTEqualStrategyNumber, 3)}, bufMgr)
+	c.Assert(err, IsNil)
+	prev := system.MakeItemPointer(0, 0)
+	cmp, _ := system.LookupCompare(system.TidType, system.TidType)
+	for {
+		tid, err := scan.NextTid()
+		c.Assert(err, IsNil)
+		if tid == system.InvalidItemPointer {
+			break
+		}
+		c.Assert(cmp(prev, tid) < 0, Equals, true)
+		prev = tid
+	}
+	scan.EndScan()
+}
+
+func (s *MySuite) TestIndexTuple(c *C) {
+	tupdesc := &TupleDesc{
+		Attrs: []*Attribute{
+			{Name: "a", TypeId: system.Int4Type},
+			{Name: "b", TypeId: system.TextType},
+			{Name: "c", TypeId: system.Int4Type},
+			{Name: "d", TypeId: system.NameType},
+		},
+	}
+	initTupleDesc(tupdesc)
+	values := []system.Datum{system.Int4(7), system.Text("hello"), nil, system.Name("x")}
+	itup, err := FormIndexTuple(values, tupdesc)
+	c.Assert(err, IsNil)
+	itup.SetTid(system.MakeItemPointer(3, 4))
+	c.Check(itup.Size(), Equals, len(itup))
+	c.Check(itup.HasNulls(), Equals, true)
+	c.Check(itup.HasVarWidths(), Equals, true)
+	c.Check(itup.Tid(), Equals, system.MakeItemPointer(3, 4))
+	c.Check(itup.Fetch(2, tupdesc), Equals, system.Datum(system.Text("hello")))
+	c.Check(itup.Fetch(3, tupdesc), IsNil)
+	c.Check(itup.Fetch(4, tupdesc), Equals, system.Datum(system.Name("x")))
+	c.Check(itup.Values(tupdesc), DeepEquals, values)
+}
@@ -0,0 +1,512 @@
+package access
+
+import (
+	"bigpot/storage"
+	"bigpot/system"
+)
+
+// btStack records the path of a descent, one entry per internal level
+// from the bottom up, as postgres' BTStack.  Each entry holds the parent
+// page and the offset of the downlink followed.
+type btStack struct {
+	blkno  system.BlockNumber
+	offset system.OffsetNumber
+	parent *btStack
+}
+
+// Descends the tree to the leaf page the key belongs to, as postgres'
+// _bt_search.  The leaf is returned locked in the access mode, along with
+// the stack of parents, which are not locked.  If nextKey is true, the
+// leaf is where the key would go after all the equal keys, otherwise
+// before them.  Returns an invalid buffer if the tree is empty.
+func btSearch(index *IndexRelation, keys []ScanKey, nextKey bool, access int,
+	bufMgr storage.BufferManager) (*btStack, storage.Buffer, error) {
+	// Lock the root in write mode only if it's also the leaf we want.
+	buf, err := btGetRoot(index, access, bufMgr)
+	if err != nil || !buf.IsValid() {
+		return nil, buf, err
+	}
+	if access == btWrite && !btOpaque(buf.GetPage()).isLeaf() {
+		buf.Unlock()
+		buf.RLock()
+	}
+	pageAccess := btRead
+	if btOpaque(buf.GetPage()).isLeaf() {
+		pageAccess = access
+	}
+
+	var stack *btStack
+	for {
+		// The page may have split since we read the pointer to it.
+		buf, err = btMoveRight(index, buf, keys, nextKey, pageAccess, bufMgr)
+		if err != nil {
+			return nil, storage.InvalidBuffer(), err
+		}
+
+		page := buf.GetPage()
+		opaque := btOpaque(page)
+		if opaque.isLeaf() {
+			break
+		}
+
+		offset := btBinSrch(index, page, keys, nextKey)
+		child := btItem(page, offset).Tid().BlockNumber()
+		stack = &btStack{
+			blkno:  buf.BlockNumber(),
+			offset: offset,
+			parent: stack,
+		}
+
+		// Internal pages are read-locked; the child is the leaf if we are
+		// on level 1.
+		btRelBuf(bufMgr, buf, btRead)
+		if opaque.level == 1 {
+			pageAccess = access
+		}
+		buf, err = btGetBuf(index, child, pageAccess, bufMgr)
+		if err != nil {
+			return nil, storage.InvalidBuffer(), err
+		}
+	}
+	return stack, buf, nil
+}
+
+// Moves right from the page as long as the key is beyond its high key, as
+// postgres' _bt_moveright.  With nextKey, equal to the high key is beyond.
+// Returns the page locked in the access mode.
+func btMoveRight(index *IndexRelation, buf storage.Buffer, keys []ScanKey, nextKey bool, access int,
+	bufMgr storage.BufferManager) (storage.Buffer, error) {
+	for {
+		page := buf.GetPage()
+		opaque := btOpaque(page)
+		if opaque.isRightmost() {
+			return buf, nil
+		}
+		c := btCompare(index, keys, page, btHikey)
+		if c < 0 || (c == 0 && !nextKey) {
+			return buf, nil
+		}
+		var err error
+		buf, err = btRelAndGetBuf(index, buf, opaque.next, access, bufMgr)
+		if err != nil {
+			return storage.InvalidBuffer(), err
+		}
+	}
+}
+
+// Binary searches the page for the key, as postgres' _bt_binsrch.
+//
+// On a leaf page, returns the offset of the first item >= key, or > key
+// when nextKey is true.  This may be one past the last item.
+//
+// On an internal page, returns the offset of the last item < key, or
+// <= key when nextKey is true; that is, the downlink to follow.  The
+// first data item is minus infinity, so there is always one.
+func btBinSrch(index *IndexRelation, page *storage.Page, keys []ScanKey, nextKey bool) system.OffsetNumber {
+	opaque := btOpaque(page)
+	low := opaque.firstDataKey()
+	high := page.MaxOffsetNumber()
+
+	// If there are no keys on the page, return the first available slot.
+	// Note this covers two cases: the page is really empty (no keys), or
+	// it contains only a high key.
+	if high < low {
+		return low
+	}
+
+	// Binary search to find the first key on the page >= scan key, or
+	// first key > scankey when nextKey is true.
+	high++ // establish the loop invariant for high
+	cmpVal := 1
+	if nextKey {
+		cmpVal = 0
+	}
+	for high > low {
+		mid := low + (high-low)/2
+		if btCompare(index, keys, page, mid) >= cmpVal {
+			low = mid + 1
+		} else {
+			high = mid
+		}
+	}
+
+	if opaque.isLeaf() {
+		return low
+	}
+	return low - 1
+}
+
+// btScan implements IndexScanDesc for btree, as postgres' BTScanOpaque.
+// Matching items of a leaf page are collected at once, so the page
+// doesn't have to stay locked between calls.
+type btScan struct {
+	index  *IndexRelation
+	keys   []ScanKey
+	bufMgr storage.BufferManager
+	// keys that end the scan once failed, for each direction
+	requiredForward  []bool
+	requiredBackward []bool
+	// false if the keys can never be satisfied
+	qualOk bool
+
+	started bool
+	// current position
+	currPage system.BlockNumber
+	prevPage system.BlockNumber
+	nextPage system.BlockNumber
+	// whether there may be more matching items beyond the current page
+	moreLeft  bool
+	moreRight bool
+	items     []system.ItemPointer
+	itemIndex int
+	dir       ScanDirection
+}
+
+// Sorts out the scan keys, as postgres' _bt_preprocess_keys.  Keys on the
+// leading columns up to the first one without an equality key are
+// required: once such a key fails while moving in the direction the key
+// bounds, no later entry can satisfy it.
+func (scan *btScan) preprocessKeys() {
+	scan.qualOk = true
+	scan.requiredForward = make([]bool, len(scan.keys))
+	scan.requiredBackward = make([]bool, len(scan.keys))
+
+	for attnum := system.AttrNumber(1); attnum <= system.AttrNumber(len(scan.index.RelDesc.Attrs)); attnum++ {
+		hasEqual := false
+		for i := range scan.keys {
+			key := &scan.keys[i]
+			if key.AttNum != attnum {
+				continue
+			}
+			if key.Flags&SkIsNull != 0 && key.Flags&(SkSearchNull|SkSearchNotNull) == 0 {
+				// comparison with NULL never succeeds
+				scan.qualOk = false
+			}
+			switch {
+			case key.Flags&SkSearchNull != 0:
+				// NULLs are sorted last.  As the start position isn't
+				// based on this key, later columns don't count on it.
+				scan.requiredForward[i] = true
+				scan.requiredBackward[i] = true
+			case key.Flags&SkSearchNotNull != 0:
+				// only the NULLs at the end fail this, so it bounds a
+				// forward scan
+				scan.requiredForward[i] = true
+			case key.Strategy == BTEqualStrategyNumber:
+				hasEqual = true
+				scan.requiredForward[i] = true
+				scan.requiredBackward[i] = true
+			case key.Strategy == BTLessStrategyNumber || key.Strategy == BTLessEqualStrategyNumber:
+				scan.requiredForward[i] = true
+			case key.Strategy == BTGreaterStrategyNumber || key.Strategy == BTGreaterEqualStrategyNumber:
+				scan.requiredBackward[i] = true
+			}
+		}
+		if !hasEqual {
+			break
+		}
+	}
+}
+
+// Tests the index tuple against the keys, as postgres' _bt_checkkeys.
+// Returns whether it matches, and whether the scan should go on in the
+// direction.
+func (scan *btScan) checkKeys(itup IndexTuple, dir ScanDirection) (bool, bool) {
+	for i := range scan.keys {
+		key := &scan.keys[i]
+		val := itup.Fetch(key.AttNum, scan.index.RelDesc)
+		if key.Test(val) {
+			continue
+		}
+
+		if val == nil && key.Flags&(SkSearchNull|SkSearchNotNull) == 0 {
+			// NULLs sort last, so in a backward scan we have yet to pass
+			// the NULLs, and the non-NULL values that may satisfy the key
+			// are still ahead.
+			if dir == ForwardScanDirection && scan.requiredForward[i] {
+				return false, false
+			}
+			return false, true
+		}
+		if key.Flags&SkSearchNull != 0 && val != nil {
+			// non-NULLs come before the NULLs
+			if dir == BackwardScanDirection && scan.requiredBackward[i] {
+				return false, false
+			}
+			return false, true
+		}
+
+		if (dir == ForwardScanDirection && scan.requiredForward[i]) ||
+			(dir == BackwardScanDirection && scan.requiredBackward[i]) {
+			return false, false
+		}
+		return false, true
+	}
+	return true, true
+}
+
+// Implements IndexScanDesc.Next.
+func (scan *btScan) Next(dir ScanDirection) (system.ItemPointer, error) {
+	if dir == NoMovementScanDirection {
+		dir = ForwardScanDirection
+	}
+	if !scan.started || dir != scan.dir {
+		// Changing direction in the middle of a scan restarts it, unlike
+		// postgres which can step back from the current position.
+		scan.started = true
+		scan.dir = dir
+		if err := scan.first(); err != nil {
+			return system.InvalidItemPointer, err
+		}
+	}
+
+	for scan.itemIndex >= len(scan.items) {
+		ok, err := scan.steppage()
+		if err != nil {
+			return system.InvalidItemPointer, err
+		} else if !ok {
+			return system.InvalidItemPointer, nil
+		}
+	}
+	tid := scan.items[scan.itemIndex]
+	scan.itemIndex++
+	return tid, nil
+}
+
+// Positions the scan at the first leaf page to look at, as postgres'
+// _bt_first, and collects its matching items.
+func (scan *btScan) first() error {
+	scan.items = nil
+	scan.itemIndex = 0
+	scan.moreLeft, scan.moreRight = false, false
+	if !scan.qualOk {
+		return nil
+	}
+
+	// Choose the keys that bound the start of the scan: equality keys on
+	// the leading columns, followed by at most one inequality key in the
+	// direction we start from.
+	var startKeys []ScanKey
+	strategy := BTEqualStrategyNumber
+	for attnum := system.AttrNumber(1); attnum <= system.AttrNumber(len(scan.index.RelDesc.Attrs)); attnum++ {
+		var chosen *ScanKey
+		for i := range scan.keys {
+			key := &scan.keys[i]
+			if key.AttNum != attnum || key.Flags&SkIsNull != 0 {
+				continue
+			}
+			if key.Strategy == BTEqualStrategyNumber {
+				chosen = key
+				break
+			}
+			if scan.dir == ForwardScanDirection &&
+				(key.Strategy == BTGreaterStrategyNumber || key.Strategy == BTGreaterEqualStrategyNumber) {
+				chosen = key
+			} else if scan.dir == BackwardScanDirection &&
+				(key.Strategy == BTLessStrategyNumber || key.Strategy == BTLessEqualStrategyNumber) {
+				chosen = key
+			}
+		}
+		if chosen == nil {
+			break
+		}
+		startKeys = append(startKeys, *chosen)
+		strategy = chosen.Strategy
+		if chosen.Strategy != BTEqualStrategyNumber {
+			break
+		}
+	}
+
+	if len(startKeys) == 0 {
+		return scan.endpoint()
+	}
+
+	// Find the first item >= the start keys ("nextKey" false), or > them
+	// ("nextKey" true).  A backward scan starts from the item before that.
+	var nextKey, goBack bool
+	switch strategy {
+	case BTLessStrategyNumber:
+		nextKey, goBack = false, true
+	case BTLessEqualStrategyNumber:
+		nextKey, goBack = true, true
+	case BTEqualStrategyNumber:
+		if scan.dir == BackwardScanDirection {
+			nextKey, goBack = true, true
+		} else {
+			nextKey, goBack = false, false
+		}
+	case BTGreaterEqualStrategyNumber:
+		nextKey, goBack = false, false
+	case BTGreaterStrategyNumber:
+		nextKey, goBack = true, false
+	}
+
+	_, buf, err := btSearch(scan.index, startKeys, nextKey, btRead, scan.bufMgr)
+	if err != nil || !buf.IsValid() {
+		return err
+	}
+	page := buf.GetPage()
+	offset := btBinSrch(scan.index, page, startKeys, nextKey)
+	if goBack {
+		offset--
+	}
+	return scan.readPage(buf, offset)
+}
+
+// Positions the scan at the first or last item of the index, for a scan
+// without start keys, as postgres' _bt_endpoint.
+func (scan *btScan) endpoint() error {
+	buf, err := btGetRoot(scan.index, btRead, scan.bufMgr)
+	if err != nil || !buf.IsValid() {
+		return err
+	}
+	buf, err = btGetEndpoint(scan.index, buf, 0, scan.dir == BackwardScanDirection, scan.bufMgr)
+	if err != nil {
+		return err
+	}
+	page := buf.GetPage()
+	opaque := btOpaque(page)
+	offset := opaque.firstDataKey()
+	if scan.dir == BackwardScanDirection {
+		offset = page.MaxOffsetNumber()
+	}
+	return scan.readPage(buf, offset)
+}
+
+// Descends from the read-locked page to the leftmost or rightmost page on
+// the level, as postgres' _bt_get_endpoint.  Returns it read-locked.
+func btGetEndpoint(index *IndexRelation, buf storage.Buffer, level uint32, rightmost bool,
+	bufMgr storage.BufferManager) (storage.Buffer, error) {
+	var err error
+	for {
+		page := buf.GetPage()
+		opaque := btOpaque(page)
+
+		if rightmost {
+			for !opaque.isRightmost() {
+				if buf, err = btRelAndGetBuf(index, buf, opaque.next, btRead, bufMgr); err != nil {
+					return storage.InvalidBuffer(), err
+				}
+				page = buf.GetPage()
+				opaque = btOpaque(page)
+			}
+		}
+		if opaque.level == level {
+			return buf, nil
+		}
+
+		offset := opaque.firstDataKey()
+		if rightmost {
+			offset = page.MaxOffsetNumber()
+		}
+		child := btItem(page, offset).Tid().BlockNumber()
+		if buf, err = btRelAndGetBuf(index, buf, child, btRead, bufMgr); err != nil {
+			return storage.InvalidBuffer(), err
+		}
+	}
+}
+
+// Collects the matching items of the read-locked leaf page, starting at
+// the offset and moving in the scan direction, as postgres' _bt_readpage.
+// The buffer is released.
+func (scan *btScan) readPage(buf storage.Buffer, offset system.OffsetNumber) error {
+	page := buf.GetPage()
+	opaque := btOpaque(page)
+	scan.currPage = buf.BlockNumber()
+	scan.prevPage = opaque.prev
+	scan.nextPage = opaque.next
+	scan.items = scan.items[:0]
+	scan.itemIndex = 0
+
+	minOff := opaque.firstDataKey()
+	maxOff := page.MaxOffsetNumber()
+	cont := true
+	if scan.dir == ForwardScanDirection {
+		for ; offset <= maxOff; offset++ {
+			itup := btItem(page, offset)
+			var match bool
+			match, cont = scan.checkKeys(itup, scan.dir)
+			if match {
+				scan.items = append(scan.items, itup.Tid())
+			}
+			if !cont {
+				break
+			}
+		}
+		scan.moreRight = cont && !opaque.isRightmost()
+	} else {
+		for ; offset >= minOff && offset > system.InvalidOffsetNumber; offset-- {
+			itup := btItem(page, offset)
+			var match bool
+			match, cont = scan.checkKeys(itup, scan.dir)
+			if match {
+				scan.items = append(scan.items, itup.Tid())
+			}
+			if !cont {
+				break
+			}
+		}
+		scan.moreLeft = cont && !opaque.isLeftmost()
+	}
+
+	btRelBuf(scan.bufMgr, buf, btRead)
+	return nil
+}
+
+// Moves to the next page in the scan direction and collects its matching
+// items, as postgres' _bt_steppage.  Returns false at the end.
+func (scan *btScan) steppage() (bool, error) {
+	if scan.dir == ForwardScanDirection {
+		if !scan.moreRight {
+			return false, nil
+		}
+		buf, err := btGetBuf(scan.index, scan.nextPage, btRead, scan.bufMgr)
+		if err != nil {
+			return false, err
+		}
+		return true, scan.readPage(buf, btOpaque(buf.GetPage()).firstDataKey())
+	}
+
+	if !scan.moreLeft {
+		return false, nil
+	}
+	buf, err := scan.walkLeft()
+	if err != nil {
+		return false, err
+	}
+	return true, scan.readPage(buf, buf.GetPage().MaxOffsetNumber())
+}
+
+// Steps to the left sibling of the current page, as postgres'
+// _bt_walk_left.  The left-link may be stale if the left sibling split
+// after we left it, in which case the page we want is the one whose
+// right-link points to the current page, found by moving right.
+func (scan *btScan) walkLeft() (storage.Buffer, error) {
+	buf, err := btGetBuf(scan.index, scan.prevPage, btRead, scan.bufMgr)
+	if err != nil {
+		return storage.InvalidBuffer(), err
+	}
+	for {
+		opaque := btOpaque(buf.GetPage())
+		if opaque.next == scan.currPage || opaque.isRightmost() {
+			return buf, nil
+		}
+		buf, err = btRelAndGetBuf(scan.index, buf, opaque.next, btRead, scan.bufMgr)
+		if err != nil {
+			return storage.InvalidBuffer(), err
+		}
+	}
+}
+
+// Implements IndexScanDesc.Recheck.  Btree entries hold the keys
+// themselves.
+func (scan *btScan) Recheck() bool {
+	return false
+}
+
+// Implements IndexScanDesc.EndScan.
+func (scan *btScan) EndScan() error {
+	scan.items = nil
+	scan.started = false
+	return nil
+}
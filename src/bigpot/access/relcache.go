@@ -0,0 +1,85 @@
+package access
+
+import (
+	"bigpot/storage"
+	"bigpot/system"
+)
+
+// RelCache caches the descriptions of the relations a session opens, by
+// oid, as postgres' relcache.  Entries are flushed by the invalidation
+// messages read at each lookup.  A flushed description is not changed, so
+// those who opened the relation before keep a consistent, if stale, copy.
+type RelCache struct {
+	bufMgr  storage.BufferManager
+	dbid    system.Oid
+	inval   *InvalReader
+	heaps   map[system.Oid]*HeapRelation
+	indexes map[system.Oid]*IndexRelation
+}
+
+func NewRelCache(bufMgr storage.BufferManager, queue *SharedInvalQueue, dbid system.Oid) *RelCache {
+	return &RelCache{
+		bufMgr:  bufMgr,
+		dbid:    dbid,
+		inval:   queue.NewReader(),
+		heaps:   make(map[system.Oid]*HeapRelation),
+		indexes: make(map[system.Oid]*IndexRelation),
+	}
+}
+
+// Reads the invalidation messages and flushes the entries they name, as
+// postgres' AcceptInvalidationMessages.
+func (rc *RelCache) AcceptInvalidationMessages() {
+	msgs, reset := rc.inval.ReadMessages()
+	if reset {
+		rc.ResetCache()
+		return
+	}
+	for _, msg := range msgs {
+		if msg.Kind == RelcacheInval {
+			delete(rc.heaps, msg.RelId)
+			delete(rc.indexes, msg.RelId)
+		}
+	}
+}
+
+// Flushes every entry, as postgres' RelationCacheInvalidate.
+func (rc *RelCache) ResetCache() {
+	rc.heaps = make(map[system.Oid]*HeapRelation)
+	rc.indexes = make(map[system.Oid]*IndexRelation)
+}
+
+// Opens the table relid, reading its description from the catalogs only
+// if it is not cached.  The relation must not be changed.
+func (rc *RelCache) HeapOpen(relid system.Oid) (*HeapRelation, error) {
+	rc.AcceptInvalidationMessages()
+	if rel, ok := rc.heaps[relid]; ok {
+		return rel, nil
+	}
+	rel, err := HeapOpen(relid, rc.dbid, rc.bufMgr)
+	if err != nil {
+		return nil, err
+	}
+	rc.heaps[relid] = rel
+	return rel, nil
+}
+
+// Opens the index relid, reading its description from the catalogs only
+// if it is not cached.  The relation must not be changed.
+func (rc *RelCache) IndexOpen(relid system.Oid) (*IndexRelation, error) {
+	rc.AcceptInvalidationMessages()
+	if index, ok := rc.indexes[relid]; ok {
+		return index, nil
+	}
+	index, err := IndexOpen(relid, rc.dbid, rc.bufMgr)
+	if err != nil {
+		return nil, err
+	}
+	rc.indexes[relid] = index
+	return index, nil
+}
+
+// Releases the cache's place in the invalidation queue.
+func (rc *RelCache) Close() {
+	rc.inval.Close()
+}
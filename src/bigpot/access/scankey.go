@@ -0,0 +1,130 @@
+package access
+
+import (
+	"bigpot/system"
+)
+
+// StrategyNumber identifies the operator of a scan key within an index
+// method, as postgres' StrategyNumber.  The heap scan understands btree
+// strategies only.
+type StrategyNumber uint16
+
+const (
+	InvalidStrategy              StrategyNumber = 0
+	BTLessStrategyNumber         StrategyNumber = 1
+	BTLessEqualStrategyNumber    StrategyNumber = 2
+	BTEqualStrategyNumber        StrategyNumber = 3
+	BTGreaterEqualStrategyNumber StrategyNumber = 4
+	BTGreaterStrategyNumber      StrategyNumber = 5
+	BTMaxStrategyNumber          StrategyNumber = 5
+)
+
+// ScanKey flags
+const (
+	SkIsNull        = 0x0001 // Val is NULL
+	SkSearchNull    = 0x0040 // scankey represents "col IS NULL"
+	SkSearchNotNull = 0x0080 // scankey represents "col IS NOT NULL"
+)
+
+// ScanKey is a qualification "attribute op argument" that scans check
+// before returning a tuple.  The comparison function takes the attribute
+// value as its first argument.  Operators that aren't btree comparisons,
+// such as GIN's containment operators, set Proc to the boolean operator
+// function instead of Func, and the strategy is that of their index
+// method.
+type ScanKey struct {
+	AttNum   system.AttrNumber
+	Strategy StrategyNumber
+	Flags    uint16
+	Func     system.CompareFunc
+	Proc     system.ProcFunc
+	Val      system.Datum
+}
+
+// Initializes a scan key with the comparison function, as postgres'
+// ScanKeyInit.  A nil val makes the key never match, as the operators are
+// strict.
+func ScanKeyInit(attnum system.AttrNumber, strategy StrategyNumber,
+	fn system.CompareFunc, val system.Datum) ScanKey {
+	key := ScanKey{
+		AttNum:   attnum,
+		Strategy: strategy,
+		Func:     fn,
+		Val:      val,
+	}
+	if val == nil {
+		key.Flags |= SkIsNull
+	}
+	return key
+}
+
+// Makes a scan key looking up the comparison function of the attribute
+// type and the argument type.
+func MakeScanKey(attnum system.AttrNumber, strategy StrategyNumber,
+	atttypid, argtypid system.Oid, val system.Datum) (ScanKey, error) {
+	if strategy == InvalidStrategy || strategy > BTMaxStrategyNumber {
+		return ScanKey{}, system.Ereport(system.InvalidParameterValue,
+			"invalid strategy number %d", strategy)
+	}
+	fn, err := system.LookupCompare(atttypid, argtypid)
+	if err != nil {
+		return ScanKey{}, err
+	}
+	return ScanKeyInit(attnum, strategy, fn, val), nil
+}
+
+// Makes an "IS NULL" scan key, or "IS NOT NULL" if isNull is false.
+func MakeNullScanKey(attnum system.AttrNumber, isNull bool) ScanKey {
+	key := ScanKey{
+		AttNum: attnum,
+		Flags:  SkIsNull | SkSearchNull,
+	}
+	if !isNull {
+		key.Flags = SkIsNull | SkSearchNotNull
+	}
+	return key
+}
+
+// Returns true if the value satisfies the key.
+func (key *ScanKey) Test(val system.Datum) bool {
+	if key.Flags&SkSearchNull != 0 {
+		return val == nil
+	} else if key.Flags&SkSearchNotNull != 0 {
+		return val != nil
+	}
+	if val == nil || key.Flags&SkIsNull != 0 {
+		return false
+	}
+
+	if key.Proc != nil {
+		result, err := key.Proc(val, key.Val)
+		return err == nil && result != nil && bool(result.(system.Bool))
+	}
+
+	c := key.Func(val, key.Val)
+	switch key.Strategy {
+	case BTLessStrategyNumber:
+		return c < 0
+	case BTLessEqualStrategyNumber:
+		return c <= 0
+	case BTEqualStrategyNumber:
+		return c == 0
+	case BTGreaterEqualStrategyNumber:
+		return c >= 0
+	case BTGreaterStrategyNumber:
+		return c > 0
+	}
+	return false
+}
+
+// Returns true if the tuple satisfies all the keys, as postgres'
+// HeapKeyTest.
+func HeapKeyTest(tuple Tuple, keys []ScanKey) bool {
+	for i := range keys {
+		key := &keys[i]
+		if !key.Test(tuple.Fetch(key.AttNum)) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,220 @@
+package access
+
+import (
+	"bigpot/storage"
+	"bigpot/system"
+)
+
+// SysCacheId names a catalog cache of a SysCache, as postgres'
+// SysCacheIdentifier.
+type SysCacheId int
+
+const (
+	AmNameCache SysCacheId = iota
+	AmOidCache
+	AttNameCache
+	AttNumCache
+	DatabaseNameCache
+	DatabaseOidCache
+	IndexRelIdCache
+	NamespaceNameCache
+	NamespaceOidCache
+	ProcOidCache
+	RelNameNspCache
+	RelOidCache
+	TypeNameNspCache
+	TypeOidCache
+)
+
+// The catalog and the key columns of each cache, as postgres' cacheinfo.
+var cacheInfo = []struct {
+	relid system.Oid
+	keys  []system.AttrNumber
+}{
+	AmNameCache:        {AmRelId, []system.AttrNumber{Anum_am_amname}},
+	AmOidCache:         {AmRelId, []system.AttrNumber{system.OidAttrNumber}},
+	AttNameCache:       {AttributeRelId, []system.AttrNumber{Anum_attribute_attrelid, Anum_attribute_attname}},
+	AttNumCache:        {AttributeRelId, []system.AttrNumber{Anum_attribute_attrelid, Anum_attribute_attnum}},
+	DatabaseNameCache:  {DatabaseRelId, []system.AttrNumber{Anum_database_datname}},
+	DatabaseOidCache:   {DatabaseRelId, []system.AttrNumber{system.OidAttrNumber}},
+	IndexRelIdCache:    {IndexRelId, []system.AttrNumber{Anum_index_indexrelid}},
+	NamespaceNameCache: {NamespaceRelId, []system.AttrNumber{Anum_namespace_nspname}},
+	NamespaceOidCache:  {NamespaceRelId, []system.AttrNumber{system.OidAttrNumber}},
+	ProcOidCache:       {ProcRelId, []system.AttrNumber{system.OidAttrNumber}},
+	RelNameNspCache:    {ClassRelId, []system.AttrNumber{Anum_class_relname, Anum_class_relnamespace}},
+	RelOidCache:        {ClassRelId, []system.AttrNumber{system.OidAttrNumber}},
+	TypeNameNspCache:   {TypeRelId, []system.AttrNumber{Anum_type_typname, Anum_type_typnamespace}},
+	TypeOidCache:       {TypeRelId, []system.AttrNumber{system.OidAttrNumber}},
+}
+
+// SysCache caches catalog tuples by their keys for a session, as postgres'
+// syscache.  Lookups that find nothing are cached too, as negative
+// entries.  Entries are flushed by the invalidation messages read at each
+// lookup.
+type SysCache struct {
+	bufMgr storage.BufferManager
+	dbid   system.Oid
+	inval  *InvalReader
+	caches []*catCache
+}
+
+// catCache is one cache of a SysCache, as postgres' CatCache.
+type catCache struct {
+	relid system.Oid
+	keys  []system.AttrNumber
+	cmps  []system.CompareFunc
+	// entries by the hash of their keys
+	buckets map[uint32][]*catCTup
+}
+
+// catCTup is a cached tuple, or a negative entry if tuple is nil.
+type catCTup struct {
+	keys  []system.Datum
+	tuple *HeapTuple
+}
+
+func NewSysCache(bufMgr storage.BufferManager, queue *SharedInvalQueue, dbid system.Oid) *SysCache {
+	return &SysCache{
+		bufMgr: bufMgr,
+		dbid:   dbid,
+		inval:  queue.NewReader(),
+		caches: make([]*catCache, len(cacheInfo)),
+	}
+}
+
+// Returns the types of the key columns of the cache.
+func cacheKeyTypes(id SysCacheId) []system.Oid {
+	info := cacheInfo[id]
+	desc := LookupCatalog(info.relid).Desc
+	typids := make([]system.Oid, len(info.keys))
+	for i, attnum := range info.keys {
+		typids[i] = system.OidType
+		if attnum > 0 {
+			typids[i] = desc.Attrs[attnum-1].TypeId
+		}
+	}
+	return typids
+}
+
+// Returns the hash of the keys of the cache, as postgres'
+// CatalogCacheComputeHashValue.
+func computeCacheHash(id SysCacheId, keys []system.Datum) (uint32, error) {
+	var hash uint32
+	for i, typid := range cacheKeyTypes(id) {
+		fn, err := system.LookupHash(typid)
+		if err != nil {
+			return 0, err
+		}
+		hash = (hash<<1 | hash>>31) ^ fn(keys[i])
+	}
+	return hash, nil
+}
+
+// Returns the cache, setting it up on first use.
+func (sc *SysCache) getCache(id SysCacheId) (*catCache, error) {
+	if cache := sc.caches[id]; cache != nil {
+		return cache, nil
+	}
+	info := cacheInfo[id]
+	cache := &catCache{
+		relid:   info.relid,
+		keys:    info.keys,
+		buckets: make(map[uint32][]*catCTup),
+	}
+	for _, typid := range cacheKeyTypes(id) {
+		cmp, err := system.LookupCompare(typid, typid)
+		if err != nil {
+			return nil, err
+		}
+		cache.cmps = append(cache.cmps, cmp)
+	}
+	sc.caches[id] = cache
+	return cache, nil
+}
+
+// Reads the invalidation messages and flushes the entries they name, as
+// postgres' AcceptInvalidationMessages.
+func (sc *SysCache) AcceptInvalidationMessages() {
+	msgs, reset := sc.inval.ReadMessages()
+	if reset {
+		sc.ResetCaches()
+		return
+	}
+	for _, msg := range msgs {
+		if msg.Kind != CatcacheInval {
+			continue
+		}
+		if cache := sc.caches[msg.CacheId]; cache != nil {
+			delete(cache.buckets, msg.HashValue)
+		}
+	}
+}
+
+// Flushes every entry, as postgres' ResetCatalogCaches.
+func (sc *SysCache) ResetCaches() {
+	for id := range sc.caches {
+		sc.caches[id] = nil
+	}
+}
+
+// Returns the catalog tuple with the keys, or nil if there is none, as
+// postgres' SearchSysCache.  The keys are of the types of the key columns,
+// and the tuple must not be changed.
+func (sc *SysCache) SearchSysCache(id SysCacheId, keys ...system.Datum) (*HeapTuple, error) {
+	sc.AcceptInvalidationMessages()
+	cache, err := sc.getCache(id)
+	if err != nil {
+		return nil, err
+	}
+	if len(keys) != len(cache.keys) {
+		return nil, system.Elog("wrong number of keys for cache %d: %d", id, len(keys))
+	}
+	hash, err := computeCacheHash(id, keys)
+	if err != nil {
+		return nil, err
+	}
+	for _, ct := range cache.buckets[hash] {
+		if cache.matches(ct.keys, keys) {
+			return ct.tuple, nil
+		}
+	}
+
+	scanKeys := make([]ScanKey, len(keys))
+	for i, attnum := range cache.keys {
+		scanKeys[i] = ScanKeyInit(attnum, BTEqualStrategyNumber, cache.cmps[i], keys[i])
+	}
+	ct := &catCTup{keys: keys}
+	err = scanCatalog(cache.relid, sc.dbid, scanKeys, sc.bufMgr, func(tuple Tuple) error {
+		ct.tuple = tuple.(*HeapTuple).Copy()
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	cache.buckets[hash] = append(cache.buckets[hash], ct)
+	return ct.tuple, nil
+}
+
+func (cache *catCache) matches(a, b []system.Datum) bool {
+	for i, cmp := range cache.cmps {
+		if cmp(a[i], b[i]) != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns the oid of the catalog tuple with the keys, or InvalidOid if
+// there is none, as postgres' GetSysCacheOid.
+func (sc *SysCache) GetSysCacheOid(id SysCacheId, keys ...system.Datum) (system.Oid, error) {
+	tuple, err := sc.SearchSysCache(id, keys...)
+	if err != nil || tuple == nil {
+		return system.InvalidOid, err
+	}
+	return tuple.Fetch(system.OidAttrNumber).(system.Oid), nil
+}
+
+// Releases the cache's place in the invalidation queue.
+func (sc *SysCache) Close() {
+	sc.inval.Close()
+}
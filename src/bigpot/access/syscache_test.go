@@ -0,0 +1,143 @@
+package access
+
+import (
+	. "launchpad.net/gocheck"
+	"os"
+
+	"bigpot/system"
+)
+
+func (s *MySuite) TestSharedInvalQueue(c *C) {
+	queue := NewSharedInvalQueue()
+	msg := func(relid int) InvalMessage {
+		return InvalMessage{Kind: RelcacheInval, RelId: system.Oid(relid)}
+	}
+
+	queue.SendMessages(msg(1))
+	a := queue.NewReader()
+	b := queue.NewReader()
+	queue.SendMessages(msg(2), msg(3))
+	msgs, reset := a.ReadMessages()
+	c.Check(reset, Equals, false)
+	c.Check(msgs, DeepEquals, []InvalMessage{msg(2), msg(3)})
+	queue.SendMessages(msg(4))
+	msgs, _ = a.ReadMessages()
+	c.Check(msgs, DeepEquals, []InvalMessage{msg(4)})
+	msgs, _ = b.ReadMessages()
+	c.Check(msgs, DeepEquals, []InvalMessage{msg(2), msg(3), msg(4)})
+	c.Check(queue.msgs, HasLen, 0)
+
+	// a reader too far behind is reset, and the queue doesn't keep the
+	// messages for it
+	for i := 0; i < MaxInvalQueueSize; i++ {
+		queue.SendMessages(msg(i))
+		a.ReadMessages()
+	}
+	c.Check(len(queue.msgs) <= MaxInvalQueueSize, Equals, true)
+	queue.SendMessages(msg(5))
+	_, reset = b.ReadMessages()
+	c.Check(reset, Equals, true)
+	msgs, reset = b.ReadMessages()
+	c.Check(reset, Equals, false)
+	c.Check(msgs, HasLen, 0)
+
+	a.Close()
+	b.Close()
+	queue.SendMessages(msg(6))
+	c.Check(queue.msgs, HasLen, 0)
+}
+
+func (s *MySuite) TestSysCache(c *C) {
+	os.MkdirAll("base/1", 0700)
+	defer os.RemoveAll("base")
+	defer os.RemoveAll("global")
+	bufMgr := newBufferManager(c, 64)
+	loadBootstrapCatalogs(c, bufMgr)
+
+	queue := NewSharedInvalQueue()
+	sc := NewSysCache(bufMgr, queue, TemplateDbId)
+	defer sc.Close()
+
+	tuple, err := sc.SearchSysCache(TypeOidCache, system.Int4Type)
+	c.Assert(err, IsNil)
+	c.Check(tuple.Fetch(Anum_type_typname), Equals, system.Name("int4"))
+	again, err := sc.SearchSysCache(TypeOidCache, system.Int4Type)
+	c.Assert(err, IsNil)
+	c.Check(again, Equals, tuple)
+
+	relid, err := sc.GetSysCacheOid(RelNameNspCache, system.Name("bp_class"), CatalogNamespaceId)
+	c.Assert(err, IsNil)
+	c.Check(relid, Equals, ClassRelId)
+	relid, err = sc.GetSysCacheOid(RelNameNspCache, system.Name("bp_class"), PublicNamespaceId)
+	c.Assert(err, IsNil)
+	c.Check(relid, Equals, system.InvalidOid)
+	attr, err := sc.SearchSysCache(AttNameCache, ClassRelId, system.Name("relkind"))
+	c.Assert(err, IsNil)
+	c.Check(attr.Fetch(Anum_attribute_attnum), Equals, system.Int4(Anum_class_relkind))
+
+	// a new relation is not seen until the negative entry is flushed
+	_, err = sc.SearchSysCache(RelNameNspCache, system.Name("foo"))
+	c.Check(err, ErrorMatches, "wrong number of keys for cache .*")
+	relid, err = sc.GetSysCacheOid(RelNameNspCache, system.Name("foo"), PublicNamespaceId)
+	c.Assert(err, IsNil)
+	c.Check(relid, Equals, system.InvalidOid)
+	classRel, _ := HeapOpen(ClassRelId, TemplateDbId, bufMgr)
+	foo := FormCatalogTuple(ClassRelId, 20001, classRow("foo", 20001, system.InvalidOid, 0, RelKindRelation))
+	fillHeap(c, bufMgr, classRel, []*HeapTuple{foo})
+	relid, _ = sc.GetSysCacheOid(RelNameNspCache, system.Name("foo"), PublicNamespaceId)
+	c.Check(relid, Equals, system.InvalidOid)
+
+	c.Assert(CacheInvalidateHeapTuple(queue, ClassRelId, foo), IsNil)
+	relid, _ = sc.GetSysCacheOid(RelNameNspCache, system.Name("foo"), PublicNamespaceId)
+	c.Check(relid, Equals, system.Oid(20001))
+	tuple, _ = sc.SearchSysCache(RelOidCache, system.Oid(20001))
+	c.Check(tuple.Fetch(Anum_class_relname), Equals, system.Name("foo"))
+}
+
+func (s *MySuite) TestRelCache(c *C) {
+	os.MkdirAll("base/1", 0700)
+	defer os.RemoveAll("base")
+	defer os.RemoveAll("global")
+	bufMgr := newBufferManager(c, 64)
+	loadBootstrapCatalogs(c, bufMgr)
+
+	classRel, _ := HeapOpen(ClassRelId, TemplateDbId, bufMgr)
+	foo := FormCatalogTuple(ClassRelId, 20001, classRow("foo", 20001, system.InvalidOid, 1, RelKindRelation))
+	fillHeap(c, bufMgr, classRel, []*HeapTuple{foo})
+	attrRel, _ := HeapOpen(AttributeRelId, TemplateDbId, bufMgr)
+	fillHeap(c, bufMgr, attrRel, []*HeapTuple{FormCatalogTuple(AttributeRelId, system.InvalidOid, []system.Datum{
+		system.Oid(20001), system.Name("id"), system.Int4Type, system.Int4(4), system.Int4(1),
+		system.Bool(false), system.Bool(false), system.Bool(false), system.Bool(false), system.Text(""),
+	})})
+
+	// two sessions
+	queue := NewSharedInvalQueue()
+	rc1 := NewRelCache(bufMgr, queue, TemplateDbId)
+	defer rc1.Close()
+	rc2 := NewRelCache(bufMgr, queue, TemplateDbId)
+	defer rc2.Close()
+
+	rel1, err := rc1.HeapOpen(20001)
+	c.Assert(err, IsNil)
+	c.Check(rel1.RelName, Equals, system.Name("foo"))
+	rel2, err := rc2.HeapOpen(20001)
+	c.Assert(err, IsNil)
+	again, _ := rc1.HeapOpen(20001)
+	c.Check(again, Equals, rel1)
+	catalog, _ := rc1.HeapOpen(ClassRelId)
+	c.Check(catalog.RelDesc, Equals, ClassTupleDesc)
+
+	// a change in the first session flushes the entry in both
+	c.Assert(CacheInvalidateHeapTuple(queue, ClassRelId, foo), IsNil)
+	again, _ = rc1.HeapOpen(20001)
+	c.Check(again, Not(Equals), rel1)
+	c.Check(again.RelDesc.Attrs, DeepEquals, rel1.RelDesc.Attrs)
+	again, _ = rc2.HeapOpen(20001)
+	c.Check(again, Not(Equals), rel2)
+
+	// a message for another relation keeps the entry
+	CacheInvalidateRelcache(queue, 30000)
+	_, err = rc2.HeapOpen(30000)
+	c.Check(err, ErrorMatches, "could not open relation with OID 30000")
+	c.Check(rc2.heaps, HasLen, 1)
+}
@@ -0,0 +1,233 @@
+package access
+
+import (
+	"sort"
+
+	"bigpot/system"
+)
+
+// TIDBitmap is a set of heap tids, as postgres' TIDBitmap.  Each heap page
+// in it is either exact, holding the offsets of its tids, or lossy,
+// standing for every tuple on the page.  The tuples of lossy pages, and of
+// exact pages added with recheck, have to be checked with the quals.
+//
+// Exact pages cost memory by the offset, so when there are more of them
+// than maxPages, some are turned lossy, as postgres' tbm_lossify.
+type TIDBitmap struct {
+	maxPages int
+	pages    map[system.BlockNumber]*tbmPage
+	lossy    *BlockBitmap
+}
+
+// tbmPage is an exact page, as postgres' PagetableEntry.
+type tbmPage struct {
+	words   []uint64
+	recheck bool
+}
+
+// Makes an empty bitmap keeping up to maxPages exact pages, or any number
+// of them if maxPages is 0.
+func NewTIDBitmap(maxPages int) *TIDBitmap {
+	return &TIDBitmap{
+		maxPages: maxPages,
+		pages:    make(map[system.BlockNumber]*tbmPage),
+		lossy:    NewBlockBitmap(),
+	}
+}
+
+func (page *tbmPage) add(offset system.OffsetNumber) {
+	word := int(offset / 64)
+	for len(page.words) <= word {
+		page.words = append(page.words, 0)
+	}
+	page.words[word] |= 1 << (offset % 64)
+}
+
+// Returns the offsets on the page in order.
+func (page *tbmPage) offsets() []system.OffsetNumber {
+	var offsets []system.OffsetNumber
+	for word, bits := range page.words {
+		for offset := system.OffsetNumber(word * 64); bits != 0; bits >>= 1 {
+			if bits&1 != 0 {
+				offsets = append(offsets, offset)
+			}
+			offset++
+		}
+	}
+	return offsets
+}
+
+// Returns true if no offset is set.
+func (page *tbmPage) isEmpty() bool {
+	for _, bits := range page.words {
+		if bits != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (page *tbmPage) copy() *tbmPage {
+	return &tbmPage{words: append([]uint64(nil), page.words...), recheck: page.recheck}
+}
+
+// Adds the tids to the bitmap, as postgres' tbm_add_tuples.  If recheck
+// is true, the tuples have to be checked with the quals.
+func (tbm *TIDBitmap) AddTuples(tids []system.ItemPointer, recheck bool) {
+	for _, tid := range tids {
+		block := tid.BlockNumber()
+		if tbm.lossy.Contains(block) {
+			continue
+		}
+		page, ok := tbm.pages[block]
+		if !ok {
+			page = &tbmPage{}
+			tbm.pages[block] = page
+		}
+		page.add(tid.OffsetNumber())
+		page.recheck = page.recheck || recheck
+	}
+	tbm.lossify()
+}
+
+// Adds every tuple of the heap page to the bitmap, as postgres'
+// tbm_add_page.
+func (tbm *TIDBitmap) AddPage(block system.BlockNumber) {
+	delete(tbm.pages, block)
+	tbm.lossy.Add(block)
+}
+
+// Adds the tids of other to the bitmap, as postgres' tbm_union.
+func (tbm *TIDBitmap) Union(other *TIDBitmap) {
+	for block, ok := other.lossy.NextMember(0); ok; block, ok = other.lossy.NextMember(block + 1) {
+		tbm.AddPage(block)
+	}
+	for block, opage := range other.pages {
+		if tbm.lossy.Contains(block) {
+			continue
+		}
+		page, ok := tbm.pages[block]
+		if !ok {
+			tbm.pages[block] = opage.copy()
+			continue
+		}
+		for i, bits := range opage.words {
+			if i < len(page.words) {
+				page.words[i] |= bits
+			} else {
+				page.words = append(page.words, bits)
+			}
+		}
+		page.recheck = page.recheck || opage.recheck
+	}
+	tbm.lossify()
+}
+
+// Removes the tids that aren't in other from the bitmap, as postgres'
+// tbm_intersect.  An exact page intersected with a lossy one is kept,
+// but its tuples have to be rechecked.
+func (tbm *TIDBitmap) Intersect(other *TIDBitmap) {
+	for block, page := range tbm.pages {
+		if other.lossy.Contains(block) {
+			page.recheck = true
+			continue
+		}
+		opage, ok := other.pages[block]
+		if !ok {
+			delete(tbm.pages, block)
+			continue
+		}
+		if len(page.words) > len(opage.words) {
+			page.words = page.words[:len(opage.words)]
+		}
+		for i := range page.words {
+			page.words[i] &= opage.words[i]
+		}
+		page.recheck = page.recheck || opage.recheck
+		if page.isEmpty() {
+			delete(tbm.pages, block)
+		}
+	}
+
+	lossy := NewBlockBitmap()
+	for block, ok := tbm.lossy.NextMember(0); ok; block, ok = tbm.lossy.NextMember(block + 1) {
+		if other.lossy.Contains(block) {
+			lossy.Add(block)
+		} else if opage, ok := other.pages[block]; ok {
+			page := opage.copy()
+			page.recheck = true
+			tbm.pages[block] = page
+		}
+	}
+	tbm.lossy = lossy
+	tbm.lossify()
+}
+
+// Returns true if the bitmap holds no tids.
+func (tbm *TIDBitmap) IsEmpty() bool {
+	return len(tbm.pages) == 0 && tbm.lossy.Count() == 0
+}
+
+// Turns exact pages lossy, lowest blocks first, until only half of
+// maxPages are left, if there are more than maxPages of them.  Halving
+// keeps the next few additions from lossifying again right away.
+func (tbm *TIDBitmap) lossify() {
+	if tbm.maxPages <= 0 || len(tbm.pages) <= tbm.maxPages {
+		return
+	}
+	blocks := make([]system.BlockNumber, 0, len(tbm.pages))
+	for block := range tbm.pages {
+		blocks = append(blocks, block)
+	}
+	sort.Slice(blocks, func(i, j int) bool { return blocks[i] < blocks[j] })
+	for _, block := range blocks {
+		if len(tbm.pages) <= tbm.maxPages/2 {
+			break
+		}
+		tbm.AddPage(block)
+	}
+}
+
+// TBMIterateResult is a page of a TIDBitmap, as postgres'
+// TBMIterateResult.  Offsets is nil if the page is lossy, in which case
+// every tuple on the page is a candidate.
+type TBMIterateResult struct {
+	Block   system.BlockNumber
+	Offsets []system.OffsetNumber
+	Recheck bool
+}
+
+// TBMIterator returns the pages of a TIDBitmap in block order.
+type TBMIterator struct {
+	tbm    *TIDBitmap
+	blocks []system.BlockNumber
+	next   int
+}
+
+// Starts iterating over the pages of the bitmap, as postgres'
+// tbm_begin_iterate.  The bitmap must not change until the iteration is
+// done.
+func (tbm *TIDBitmap) BeginIterate() *TBMIterator {
+	var blocks []system.BlockNumber
+	for block := range tbm.pages {
+		blocks = append(blocks, block)
+	}
+	for block, ok := tbm.lossy.NextMember(0); ok; block, ok = tbm.lossy.NextMember(block + 1) {
+		blocks = append(blocks, block)
+	}
+	sort.Slice(blocks, func(i, j int) bool { return blocks[i] < blocks[j] })
+	return &TBMIterator{tbm: tbm, blocks: blocks}
+}
+
+// Returns the next page, or nil at the end, as postgres' tbm_iterate.
+func (iterator *TBMIterator) Next() *TBMIterateResult {
+	if iterator.next >= len(iterator.blocks) {
+		return nil
+	}
+	block := iterator.blocks[iterator.next]
+	iterator.next++
+	if page, ok := iterator.tbm.pages[block]; ok {
+		return &TBMIterateResult{Block: block, Offsets: page.offsets(), Recheck: page.recheck}
+	}
+	return &TBMIterateResult{Block: block, Recheck: true}
+}
@@ -0,0 +1,179 @@
+package access
+
+import (
+	"fmt"
+	. "launchpad.net/gocheck"
+	"os"
+
+	"bigpot/storage"
+	"bigpot/system"
+)
+
+// Returns the id column of the heap tuples in the bitmap that satisfy the
+// keys.
+func bitmapScanAll(c *C, heap *HeapRelation, tbm *TIDBitmap, keys []ScanKey,
+	bufMgr storage.BufferManager) []system.Datum {
+	scan, err := heap.BeginBitmapScan(tbm, keys, bufMgr)
+	c.Assert(err, IsNil)
+	defer scan.EndScan()
+
+	var result []system.Datum
+	for {
+		tuple, err := scan.Next()
+		c.Assert(err, IsNil)
+		if tuple == nil {
+			return result
+		}
+		result = append(result, tuple.Fetch(1))
+	}
+}
+
+func tbmPages(tbm *TIDBitmap) []TBMIterateResult {
+	var pages []TBMIterateResult
+	iterator := tbm.BeginIterate()
+	for page := iterator.Next(); page != nil; page = iterator.Next() {
+		pages = append(pages, *page)
+	}
+	return pages
+}
+
+func (s *MySuite) TestTIDBitmap(c *C) {
+	tid := system.MakeItemPointer
+	offsets := func(offsets ...system.OffsetNumber) []system.OffsetNumber {
+		return offsets
+	}
+
+	tbm := NewTIDBitmap(0)
+	c.Check(tbm.IsEmpty(), Equals, true)
+	tbm.AddTuples([]system.ItemPointer{tid(1, 3), tid(1, 1), tid(5, 2)}, false)
+	tbm.AddTuples([]system.ItemPointer{tid(1, 70)}, true)
+	tbm.AddPage(3)
+	c.Check(tbm.IsEmpty(), Equals, false)
+	c.Check(tbmPages(tbm), DeepEquals, []TBMIterateResult{
+		{Block: 1, Offsets: offsets(1, 3, 70), Recheck: true},
+		{Block: 3, Recheck: true},
+		{Block: 5, Offsets: offsets(2)},
+	})
+
+	// a lossy page absorbs the exact one
+	other := NewTIDBitmap(0)
+	other.AddTuples([]system.ItemPointer{tid(5, 4), tid(3, 1)}, false)
+	other.AddPage(7)
+	tbm.Union(other)
+	c.Check(tbmPages(tbm), DeepEquals, []TBMIterateResult{
+		{Block: 1, Offsets: offsets(1, 3, 70), Recheck: true},
+		{Block: 3, Recheck: true},
+		{Block: 5, Offsets: offsets(2, 4)},
+		{Block: 7, Recheck: true},
+	})
+
+	// exact and lossy pages intersect to the exact tids for recheck
+	other = NewTIDBitmap(0)
+	other.AddTuples([]system.ItemPointer{tid(1, 3), tid(3, 9)}, false)
+	other.AddPage(5)
+	tbm.Intersect(other)
+	c.Check(tbmPages(tbm), DeepEquals, []TBMIterateResult{
+		{Block: 1, Offsets: offsets(3), Recheck: true},
+		{Block: 3, Offsets: offsets(9), Recheck: true},
+		{Block: 5, Offsets: offsets(2, 4), Recheck: true},
+	})
+	tbm.Intersect(NewTIDBitmap(0))
+	c.Check(tbm.IsEmpty(), Equals, true)
+
+	// the lowest pages go lossy beyond the limit
+	tbm = NewTIDBitmap(4)
+	var tids []system.ItemPointer
+	for block := system.BlockNumber(0); block < 10; block++ {
+		tids = append(tids, tid(block, 1))
+	}
+	tbm.AddTuples(tids, false)
+	c.Check(tbm.pages, HasLen, 2)
+	c.Check(tbm.lossy.Count(), Equals, 8)
+	tbm.AddTuples([]system.ItemPointer{tid(0, 2)}, false)
+	c.Check(tbmPages(tbm)[0], DeepEquals, TBMIterateResult{Block: 0, Recheck: true})
+	c.Check(tbmPages(tbm)[9], DeepEquals, TBMIterateResult{Block: 9, Offsets: offsets(1)})
+}
+
+func (s *MySuite) TestBitmapHeapScan(c *C) {
+	os.MkdirAll("base/1", 0700)
+	defer os.RemoveAll("base")
+	defer os.RemoveAll("global")
+	bufMgr := newBufferManager(c, 64)
+
+	// ids in the physical order, and names in another
+	const nrows = 5000
+	var rows [][]system.Datum
+	for i := 0; i < nrows; i++ {
+		rows = append(rows, []system.Datum{system.Int4(i), system.Name(fmt.Sprintf("name%05d", i*7919%nrows))})
+	}
+	heap := makeTestHeap(c, bufMgr, 20000, rows)
+	idIndex := makeTestIndex(c, heap, 20001, 1, false)
+	c.Assert(idIndex.Build(heap, bufMgr), IsNil)
+	nameIndex := makeTestIndex(c, heap, 20002, 2, false)
+	c.Assert(nameIndex.Build(heap, bufMgr), IsNil)
+	hashIndex := makeTestIndex(c, heap, 20003, 2, false)
+	hashIndex.Am = HashAm
+	c.Assert(hashIndex.Build(heap, bufMgr), IsNil)
+
+	idKey := func(strategy StrategyNumber, id int) ScanKey {
+		key, err := MakeScanKey(1, strategy, system.Int4Type, system.Int4Type, system.Int4(int32(id)))
+		c.Assert(err, IsNil)
+		return key
+	}
+	nameKey := func(strategy StrategyNumber, name string) ScanKey {
+		key, err := MakeScanKey(1, strategy, system.NameType, system.NameType, system.Name(name))
+		c.Assert(err, IsNil)
+		return key
+	}
+	bitmap := func(index *IndexRelation, maxPages int, keys ...ScanKey) *TIDBitmap {
+		scan, err := IndexBeginScan(heap, index, keys, bufMgr)
+		c.Assert(err, IsNil)
+		defer scan.EndScan()
+		tbm := NewTIDBitmap(maxPages)
+		c.Assert(scan.GetBitmap(tbm), IsNil)
+		return tbm
+	}
+	// the heap keys of the name keys above
+	heapKeys := func(keys ...ScanKey) []ScanKey {
+		for i := range keys {
+			keys[i].AttNum = 2
+		}
+		return keys
+	}
+	expected := func(keys ...ScanKey) []system.Datum {
+		return scanAll(c, heap, keys, bufMgr)
+	}
+
+	// "id < 100 OR name = 'name00003' OR name = 'name04999'"
+	tbm := bitmap(idIndex, 0, idKey(BTLessStrategyNumber, 100))
+	tbm.Union(bitmap(hashIndex, 0, nameKey(BTEqualStrategyNumber, "name00003")))
+	tbm.Union(bitmap(hashIndex, 0, nameKey(BTEqualStrategyNumber, "name04999")))
+	ids := bitmapScanAll(c, heap, tbm, nil, bufMgr)
+	c.Check(ids, HasLen, 102)
+	c.Check(ids[100:], DeepEquals, append(
+		expected(heapKeys(nameKey(BTEqualStrategyNumber, "name04999"))...),
+		expected(heapKeys(nameKey(BTEqualStrategyNumber, "name00003"))...)...))
+
+	// "id BETWEEN 1000 AND 2999 AND name >= 'name04000'"
+	tbm = bitmap(idIndex, 0, idKey(BTGreaterEqualStrategyNumber, 1000), idKey(BTLessEqualStrategyNumber, 2999))
+	tbm.Intersect(bitmap(nameIndex, 0, nameKey(BTGreaterEqualStrategyNumber, "name04000")))
+	keys := append([]ScanKey{idKey(BTGreaterEqualStrategyNumber, 1000), idKey(BTLessEqualStrategyNumber, 2999)},
+		heapKeys(nameKey(BTGreaterEqualStrategyNumber, "name04000"))...)
+	ids = bitmapScanAll(c, heap, tbm, keys, bufMgr)
+	c.Check(ids, Not(HasLen), 0)
+	c.Check(ids, DeepEquals, expected(keys...))
+
+	// lossy pages hold other tuples, which the recheck filters out
+	tbm = bitmap(idIndex, 4, idKey(BTGreaterEqualStrategyNumber, 2001))
+	c.Check(tbm.lossy.Count() > 0, Equals, true)
+	c.Check(len(bitmapScanAll(c, heap, tbm, nil, bufMgr)) > nrows-2001, Equals, true)
+	keys = []ScanKey{idKey(BTGreaterEqualStrategyNumber, 2001)}
+	c.Check(bitmapScanAll(c, heap, tbm, keys, bufMgr), DeepEquals, expected(keys...))
+
+	// tids beyond the heap are skipped
+	tbm = NewTIDBitmap(0)
+	tbm.AddTuples([]system.ItemPointer{system.MakeItemPointer(0, 1), system.MakeItemPointer(0, 1000),
+		system.MakeItemPointer(10000, 1)}, false)
+	tbm.AddPage(10001)
+	c.Check(bitmapScanAll(c, heap, tbm, nil, bufMgr), DeepEquals, []system.Datum{system.Int4(0)})
+}
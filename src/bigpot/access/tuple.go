@@ -2,6 +2,7 @@ package access
 
 import (
 	"bytes"
+	"encoding/binary"
 	"errors"
 	"unsafe"
 
@@ -77,6 +78,31 @@ func (tuple *HeapTuple) SetData(bytes []byte, tid system.ItemPointer) {
 	tuple.data = (*HeapTupleHeader)(unsafe.Pointer(&bytes[0]))
 }
 
+// Returns a copy of the tuple holding its own data, as postgres'
+// heap_copytuple.
+func (tuple *HeapTuple) Copy() *HeapTuple {
+	copied := NewHeapTuple(append([]byte(nil), tuple.bytes...), tuple.tupdesc, tuple.self)
+	copied.tableOid = tuple.tableOid
+	return copied
+}
+
+// Sets the oid of a tuple of a relation with oids, as postgres'
+// HeapTupleSetOid.
+func (tuple *HeapTuple) SetOid(oid system.Oid) {
+	tuple.data.SetOid(oid)
+}
+
+// Returns the bytes of the tuple, its header first, as postgres' t_data;
+// NewHeapTuple reads them back.
+func (tuple *HeapTuple) Bytes() []byte {
+	return tuple.bytes
+}
+
+// Returns the tid of the tuple, as postgres' t_self.
+func (tuple *HeapTuple) Self() system.ItemPointer {
+	return tuple.self
+}
+
 func (htup *HeapTupleHeader) Xmin() system.Xid {
 	return htup.heap.xmin
 }
@@ -101,6 +127,14 @@ func (htup *HeapTupleHeader) Oid() system.Oid {
 	return system.InvalidOid
 }
 
+func (htup *HeapTupleHeader) SetOid(oid system.Oid) {
+	if htup.infomask&heapHasOid == 0 {
+		panic("tuple has no oid field")
+	}
+	ptr := uintptr(unsafe.Pointer(htup)) + uintptr(htup.hoff) - unsafe.Sizeof(system.Oid(0))
+	*(*system.Oid)(unsafe.Pointer(ptr)) = oid
+}
+
 func (htup *HeapTupleHeader) HasNulls() bool {
 	return htup.infomask&heapHasNull != 0
 }
@@ -146,6 +180,10 @@ func (tuple *HeapTuple) Fetch(attnum system.AttrNumber) system.Datum {
 	} else {
 		td := tuple.data
 
+		// a column added after the tuple was written
+		if attnum > td.Natts() {
+			return tuple.tupdesc.Attrs[attnum-1].Missing
+		}
 		if td.IsNull(attnum) {
 			return nil
 		}
@@ -158,7 +196,8 @@ func (tuple *HeapTuple) Fetch(attnum system.AttrNumber) system.Datum {
 			}
 			attr := tuple.tupdesc.Attrs[i-1]
 			if attr.Type.IsVarlen() {
-				// TODO:
+				// the length word counts itself
+				offset += int(binary.LittleEndian.Uint32(tuple.bytes[offset:]))
 			} else {
 				offset += int(attr.Type.Len)
 			}
@@ -171,6 +210,35 @@ func (tuple *HeapTuple) Fetch(attnum system.AttrNumber) system.Datum {
 	return nil
 }
 
+// Returns the values of all the columns of the tuple, as postgres'
+// heap_deform_tuple.
+func (tuple *HeapTuple) Deform() []system.Datum {
+	values := make([]system.Datum, len(tuple.tupdesc.Attrs))
+	for i := range values {
+		values[i] = tuple.Fetch(system.AttrNumber(i + 1))
+	}
+	return values
+}
+
+// Forms a copy of the tuple with the columns whose replace is true set to
+// values, as postgres' heap_modify_tuple.  The copy keeps the oid, the tid
+// and the table oid of the tuple.
+func HeapModifyTuple(tuple *HeapTuple, values []system.Datum, replace []bool) *HeapTuple {
+	newValues := tuple.Deform()
+	for i := range newValues {
+		if replace[i] {
+			newValues[i] = values[i]
+		}
+	}
+	newTuple := FormHeapTuple(newValues, tuple.tupdesc)
+	if tuple.tupdesc.hasOid {
+		newTuple.SetOid(tuple.data.Oid())
+	}
+	newTuple.self = tuple.self
+	newTuple.tableOid = tuple.tableOid
+	return newTuple
+}
+
 func bitmapLength(n int) int {
 	return (n + 7) / 8
 }
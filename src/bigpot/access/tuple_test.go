@@ -54,3 +54,25 @@ func (s *MySuite) TestHeapTuple(c *C) {
 	c.Check(htuple.data.HasNulls(), Equals, true)
 	c.Check(htuple.data.Natts(), Equals, system.AttrNumber(4))
 }
+
+func (s *MySuite) TestHeapTupleMissingAttrs(c *C) {
+	tupdesc := NewTupleDesc([]*Attribute{
+		{Name: "col1", TypeId: system.Int4Type},
+		{Name: "col2", TypeId: system.TextType},
+	}, false)
+	htuple := FormHeapTuple([]system.Datum{system.Int4(1), nil}, tupdesc)
+
+	// columns added to the description after the tuple was formed
+	tupdesc.Attrs = append(tupdesc.Attrs,
+		&Attribute{Name: "col3", TypeId: system.Int4Type, Type: system.TypeRegistry[system.Int4Type],
+			Missing: system.Int4(7)},
+		&Attribute{Name: "col4", TypeId: system.TextType, Type: system.TypeRegistry[system.TextType]})
+	c.Check(htuple.data.Natts(), Equals, system.AttrNumber(2))
+	c.Check(htuple.Deform(), DeepEquals, []system.Datum{system.Int4(1), nil, system.Int4(7), nil})
+
+	modified := HeapModifyTuple(htuple, []system.Datum{nil, system.Text("x"), nil, system.Text("y")},
+		[]bool{false, true, false, true})
+	c.Check(modified.data.Natts(), Equals, system.AttrNumber(4))
+	c.Check(modified.Deform(), DeepEquals,
+		[]system.Datum{system.Int4(1), system.Text("x"), system.Int4(7), system.Text("y")})
+}
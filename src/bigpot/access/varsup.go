@@ -0,0 +1,87 @@
+package access
+
+import (
+	"sync"
+
+	"bigpot/storage"
+	"bigpot/system"
+)
+
+// The number of oids and xids reserved by each write of the control file,
+// as postgres' VAR_OID_PREFETCH.  After a restart, those not assigned are
+// skipped.
+const (
+	OidPrefetch = 8192
+	XidPrefetch = 8192
+)
+
+// TransamVariables holds the counters the sessions of a data directory
+// share, as postgres' VariableCacheData.  They are kept in the control file
+// across restarts.
+type TransamVariables struct {
+	lock     sync.Mutex
+	nextOid  system.Oid
+	oidCount uint32 // oids left before the control file must be written
+	nextXid  system.Xid
+	xidCount uint32 // likewise for xids
+}
+
+// Reads the counters from the control file, as postgres' StartupXLOG.
+func NewTransamVariables() (*TransamVariables, error) {
+	ctl, err := storage.ReadControlFile()
+	if err != nil {
+		return nil, err
+	}
+	return &TransamVariables{nextOid: ctl.NextOid, nextXid: ctl.NextXid}, nil
+}
+
+// Returns a new oid, as postgres' GetNewObjectId.  The oid is unique only
+// until the counter wraps around, so callers that need it unique in a
+// catalog use GetNewOid instead.
+func (vars *TransamVariables) GetNewObjectId() (system.Oid, error) {
+	vars.lock.Lock()
+	defer vars.lock.Unlock()
+
+	// on wraparound, skip the oids of the built-in objects
+	if vars.nextOid < system.FirstNormalObjectId {
+		vars.nextOid = system.FirstNormalObjectId
+		vars.oidCount = 0
+	}
+	if vars.oidCount == 0 {
+		err := storage.UpdateControlFile(func(ctl *storage.ControlFileData) {
+			ctl.NextOid = vars.nextOid + OidPrefetch
+		})
+		if err != nil {
+			return system.InvalidOid, err
+		}
+		vars.oidCount = OidPrefetch
+	}
+	oid := vars.nextOid
+	vars.nextOid++
+	vars.oidCount--
+	return oid, nil
+}
+
+// Returns a new transaction id, as postgres' GetNewTransactionId.
+func (vars *TransamVariables) GetNewTransactionId() (system.Xid, error) {
+	vars.lock.Lock()
+	defer vars.lock.Unlock()
+
+	if !vars.nextXid.IsNormal() {
+		vars.nextXid = system.FirstNormalXid
+		vars.xidCount = 0
+	}
+	if vars.xidCount == 0 {
+		err := storage.UpdateControlFile(func(ctl *storage.ControlFileData) {
+			ctl.NextXid = vars.nextXid + XidPrefetch
+		})
+		if err != nil {
+			return system.InvalidXid, err
+		}
+		vars.xidCount = XidPrefetch
+	}
+	xid := vars.nextXid
+	vars.nextXid = vars.nextXid.Advance()
+	vars.xidCount--
+	return xid, nil
+}
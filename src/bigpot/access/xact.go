@@ -0,0 +1,284 @@
+package access
+
+import (
+	"unsafe"
+
+	"bigpot/storage"
+	"bigpot/system"
+)
+
+// The storage manager the relation files are made and removed through.
+var smgr = storage.NewMdSmgr()
+
+// Transaction is a transaction of a session, as postgres'
+// TransactionState.  Different from postgres, the tuples it inserts and
+// deletes are changed in place and put back at abort, instead of being
+// told apart by the status of their xmin and xmax; so a deleted tuple is
+// invisible to everyone at once, and what a crashed transaction did stays.
+// The relation files it makes and drops are removed when it ends, as
+// postgres' pending deletes.
+type Transaction struct {
+	xid            system.Xid
+	vars           *TransamVariables
+	bufMgr         storage.BufferManager
+	dbid           system.Oid
+	queue          *SharedInvalQueue
+	undo           []undoRecord
+	pendingDeletes []pendingDelete
+	invals         []InvalMessage
+	ended          bool
+}
+
+// undoRecord is a tuple the transaction inserted or deleted.
+type undoRecord struct {
+	node     system.RelFileNode
+	tid      system.ItemPointer
+	inserted bool
+}
+
+// pendingDelete is a relation file to remove at the end of the
+// transaction, as postgres' PendingRelDelete.
+type pendingDelete struct {
+	node     system.RelFileNode
+	atCommit bool
+}
+
+// Starts a transaction in the database dbid, as postgres' StartTransaction.
+func BeginTransaction(vars *TransamVariables, queue *SharedInvalQueue,
+	bufMgr storage.BufferManager, dbid system.Oid) (*Transaction, error) {
+	xid, err := vars.GetNewTransactionId()
+	if err != nil {
+		return nil, err
+	}
+	return &Transaction{xid: xid, vars: vars, bufMgr: bufMgr, dbid: dbid, queue: queue}, nil
+}
+
+func (tx *Transaction) Xid() system.Xid {
+	return tx.xid
+}
+
+// Returns the buffer manager the transaction reads and writes through.
+func (tx *Transaction) BufMgr() storage.BufferManager {
+	return tx.bufMgr
+}
+
+// Returns the database the transaction runs in, as postgres' MyDatabaseId.
+func (tx *Transaction) DatabaseId() system.Oid {
+	return tx.dbid
+}
+
+// Returns true if the tuple is seen by scans, as postgres'
+// HeapTupleSatisfiesVisibility.  As aborted changes are undone, only the
+// deleted tuples are not.
+func HeapTupleSatisfiesVisibility(tuple *HeapTuple) bool {
+	return !tuple.data.Xmax().IsValid()
+}
+
+// Inserts the tuple into the table as made by the transaction, as
+// postgres' heap_insert.
+func (tx *Transaction) HeapInsert(rel *HeapRelation, tuple *HeapTuple) error {
+	tuple.data.SetXmin(tx.xid)
+	if err := rel.Insert(tuple, tx.bufMgr); err != nil {
+		return err
+	}
+	tx.undo = append(tx.undo, undoRecord{rel.RelNode, tuple.self, true})
+	return nil
+}
+
+// Deletes the tuple at tid, as postgres' heap_delete, by setting its xmax.
+func (tx *Transaction) HeapDelete(rel *HeapRelation, tid system.ItemPointer) error {
+	err := tx.changeTuple(rel.RelNode, tid, func(htup *HeapTupleHeader) error {
+		if htup.Xmax().IsValid() {
+			return system.Elog("tuple (%d,%d) of \"%s\" is already deleted",
+				tid.BlockNumber(), tid.OffsetNumber(), rel.RelName)
+		}
+		htup.SetXmax(tx.xid)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	tx.undo = append(tx.undo, undoRecord{rel.RelNode, tid, false})
+	return nil
+}
+
+// Replaces the tuple at otid with the tuple, as postgres' heap_update.
+// The old version is deleted and the new one goes to a new tid.
+func (tx *Transaction) HeapUpdate(rel *HeapRelation, otid system.ItemPointer, tuple *HeapTuple) error {
+	if err := tx.HeapDelete(rel, otid); err != nil {
+		return err
+	}
+	return tx.HeapInsert(rel, tuple)
+}
+
+// Calls fn with the header of the tuple at tid, under the buffer lock.
+func (tx *Transaction) changeTuple(node system.RelFileNode, tid system.ItemPointer,
+	fn func(htup *HeapTupleHeader) error) error {
+	buf, err := tx.bufMgr.ReadBuffer(node, tid.BlockNumber())
+	if err != nil {
+		return err
+	}
+	defer tx.bufMgr.ReleaseBuffer(buf)
+	buf.Lock()
+	defer buf.Unlock()
+
+	page := buf.GetPage()
+	offset := tid.OffsetNumber()
+	if offset < system.FirstOffsetNumber || offset > page.MaxOffsetNumber() ||
+		!page.ItemId(offset).IsNormal() {
+		return system.Elog("invalid tid (%d,%d)", tid.BlockNumber(), offset)
+	}
+	item := page.Item(page.ItemId(offset))
+	if err := fn((*HeapTupleHeader)(unsafe.Pointer(&item[0]))); err != nil {
+		return err
+	}
+	buf.MarkDirty()
+	return nil
+}
+
+// Inserts the tuple into the catalog relid, as postgres'
+// CatalogTupleInsert, and invalidates the caches of it.
+func (tx *Transaction) CatalogInsert(relid system.Oid, tuple *HeapTuple) error {
+	rel, err := HeapOpen(relid, tx.dbid, tx.bufMgr)
+	if err != nil {
+		return err
+	}
+	if err := tx.HeapInsert(rel, tuple); err != nil {
+		return err
+	}
+	return tx.invalidateHeapTuple(relid, tuple)
+}
+
+// Deletes the tuple of the catalog relid, as postgres'
+// CatalogTupleDelete, and invalidates the caches of it.
+func (tx *Transaction) CatalogDelete(relid system.Oid, tuple *HeapTuple) error {
+	rel, err := HeapOpen(relid, tx.dbid, tx.bufMgr)
+	if err != nil {
+		return err
+	}
+	if err := tx.HeapDelete(rel, tuple.self); err != nil {
+		return err
+	}
+	return tx.invalidateHeapTuple(relid, tuple)
+}
+
+// Replaces the tuple of the catalog relid at oldtup's tid with newtup, as
+// postgres' CatalogTupleUpdate, and invalidates the caches of both.  The
+// new version goes to a new tid.
+func (tx *Transaction) CatalogUpdate(relid system.Oid, oldtup, newtup *HeapTuple) error {
+	rel, err := HeapOpen(relid, tx.dbid, tx.bufMgr)
+	if err != nil {
+		return err
+	}
+	if err := tx.HeapDelete(rel, oldtup.self); err != nil {
+		return err
+	}
+	if err := tx.invalidateHeapTuple(relid, oldtup); err != nil {
+		return err
+	}
+	if err := tx.HeapInsert(rel, newtup); err != nil {
+		return err
+	}
+	return tx.invalidateHeapTuple(relid, newtup)
+}
+
+// Sends the invalidation messages for the catalog tuple, and keeps them
+// to be sent again at abort, when the tuple is put back.
+func (tx *Transaction) invalidateHeapTuple(relid system.Oid, tuple *HeapTuple) error {
+	msgs, err := heapTupleInvalMessages(relid, tuple)
+	if err != nil {
+		return err
+	}
+	tx.queue.SendMessages(msgs...)
+	tx.invals = append(tx.invals, msgs...)
+	return nil
+}
+
+// Makes the file of a new relation, to be removed if the transaction
+// aborts, as postgres' RelationCreateStorage.
+func (tx *Transaction) CreateStorage(node system.RelFileNode) error {
+	if err := smgr.GetRelation(node).Create(); err != nil {
+		return err
+	}
+	tx.pendingDeletes = append(tx.pendingDeletes, pendingDelete{node, false})
+	return nil
+}
+
+// Schedules the file of a dropped relation to be removed when the
+// transaction commits, as postgres' RelationDropStorage.
+func (tx *Transaction) DropStorage(node system.RelFileNode) {
+	tx.pendingDeletes = append(tx.pendingDeletes, pendingDelete{node, true})
+}
+
+// Removes the files pending deletion at commit, or at abort.
+func (tx *Transaction) doPendingDeletes(isCommit bool) error {
+	var firstErr error
+	for _, pending := range tx.pendingDeletes {
+		if pending.atCommit != isCommit {
+			continue
+		}
+		tx.bufMgr.DropRelFileNodeBuffers(pending.node)
+		if err := smgr.GetRelation(pending.node).Unlink(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	tx.pendingDeletes = nil
+	return firstErr
+}
+
+// Commits the transaction, as postgres' CommitTransaction.
+func (tx *Transaction) Commit() error {
+	if tx.ended {
+		return system.Elog("transaction %d has already ended", tx.xid)
+	}
+	tx.ended = true
+	tx.undo = nil
+	tx.invals = nil
+	return tx.doPendingDeletes(true)
+}
+
+// Aborts the transaction, as postgres' AbortTransaction, putting back the
+// tuples it changed in the reverse order and removing the files it made.
+func (tx *Transaction) Abort() error {
+	if tx.ended {
+		return nil
+	}
+	tx.ended = true
+	var firstErr error
+	for i := len(tx.undo) - 1; i >= 0; i-- {
+		rec := tx.undo[i]
+		var err error
+		if rec.inserted {
+			err = tx.killTuple(rec.node, rec.tid)
+		} else {
+			err = tx.changeTuple(rec.node, rec.tid, func(htup *HeapTupleHeader) error {
+				htup.SetXmax(system.InvalidXid)
+				return nil
+			})
+		}
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	tx.undo = nil
+	tx.queue.SendMessages(tx.invals...)
+	tx.invals = nil
+	if err := tx.doPendingDeletes(false); err != nil && firstErr == nil {
+		firstErr = err
+	}
+	return firstErr
+}
+
+// Marks the line pointer of the tuple at tid dead.
+func (tx *Transaction) killTuple(node system.RelFileNode, tid system.ItemPointer) error {
+	buf, err := tx.bufMgr.ReadBuffer(node, tid.BlockNumber())
+	if err != nil {
+		return err
+	}
+	defer tx.bufMgr.ReleaseBuffer(buf)
+	buf.Lock()
+	defer buf.Unlock()
+	buf.GetPage().ItemId(tid.OffsetNumber()).MarkDead()
+	buf.MarkDirty()
+	return nil
+}
@@ -0,0 +1,307 @@
+package bootstrap
+
+import (
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"bigpot/access"
+	"bigpot/storage"
+	"bigpot/system"
+)
+
+// The BKI token of a null value.
+const bkiNull = "_null_"
+
+// bkiToken is a word, a quoted string, or one of "(),=".
+type bkiToken struct {
+	text   string
+	quoted bool
+	line   int
+}
+
+// Returns true if the token is the unquoted text.
+func (token bkiToken) is(text string) bool {
+	return !token.quoted && token.text == text
+}
+
+// Returns true if the token is neither quoted nor one of "(),=", nor the
+// empty token at the end.
+func (token bkiToken) isWord() bool {
+	return !token.quoted && token.text != "" && !strings.ContainsAny(token.text, "(),=")
+}
+
+// Splits the BKI script into tokens.
+func bkiTokens(script string) ([]bkiToken, error) {
+	var tokens []bkiToken
+	runes := []rune(script)
+	line := 1
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case r == '\n':
+			line++
+			i++
+		case unicode.IsSpace(r):
+			i++
+		case r == '(' || r == ')' || r == ',' || r == '=':
+			tokens = append(tokens, bkiToken{text: string(r), line: line})
+			i++
+		case r == '"':
+			var text []rune
+			for i++; ; i++ {
+				if i >= len(runes) {
+					return nil, system.Elog("unterminated quoted string at line %d", line)
+				} else if runes[i] == '"' {
+					break
+				} else if runes[i] == '\\' && i+1 < len(runes) {
+					i++
+				} else if runes[i] == '\n' {
+					line++
+				}
+				text = append(text, runes[i])
+			}
+			tokens = append(tokens, bkiToken{text: string(text), quoted: true, line: line})
+			i++
+		default:
+			start := i
+			for i < len(runes) && !unicode.IsSpace(runes[i]) && runes[i] != '"' &&
+				runes[i] != '(' && runes[i] != ')' && runes[i] != ',' && runes[i] != '=' {
+				i++
+			}
+			tokens = append(tokens, bkiToken{text: string(runes[start:i]), line: line})
+		}
+	}
+	return tokens, nil
+}
+
+// bootstrapper runs a BKI script, as postgres' bootstrap mode.
+type bootstrapper struct {
+	tokens []bkiToken
+	pos    int
+	bufMgr storage.BufferManager
+	// the relation being filled, between create and close
+	rel *access.HeapRelation
+}
+
+// Runs the BKI script, making the catalogs it creates and inserting the
+// rows into them through the heap, as postgres' BootstrapModeMain.  The
+// commands are
+//
+//	create name oid [shared_relation] [without_oids] ( column = type [, ...] )
+//	insert [OID = oid] ( value [...] )
+//	close name
+//
+// where insert adds a row to the relation created last, and values are
+// in the input format of the column types, with _null_ for a null.
+func Bootstrap(bki io.Reader, bufMgr storage.BufferManager) error {
+	script, err := ioutil.ReadAll(bki)
+	if err != nil {
+		return err
+	}
+	tokens, err := bkiTokens(string(script))
+	if err != nil {
+		return err
+	}
+	b := &bootstrapper{tokens: tokens, bufMgr: bufMgr}
+	for !b.atEnd() {
+		token := b.next()
+		switch {
+		case token.is("create"):
+			err = b.create()
+		case token.is("insert"):
+			err = b.insert()
+		case token.is("close"):
+			err = b.close()
+		default:
+			err = b.syntaxError(token)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if b.rel != nil {
+		return system.Elog("relation \"%s\" was not closed", b.rel.RelName)
+	}
+	return nil
+}
+
+func (b *bootstrapper) atEnd() bool {
+	return b.pos >= len(b.tokens)
+}
+
+// Returns the next token, or an empty one at the end of the script.
+func (b *bootstrapper) next() bkiToken {
+	if b.atEnd() {
+		line := 0
+		if len(b.tokens) > 0 {
+			line = b.tokens[len(b.tokens)-1].line
+		}
+		return bkiToken{line: line}
+	}
+	b.pos++
+	return b.tokens[b.pos-1]
+}
+
+// Returns the next token, if it is a word.
+func (b *bootstrapper) word() (string, error) {
+	token := b.next()
+	if !token.isWord() {
+		return "", b.syntaxError(token)
+	}
+	return token.text, nil
+}
+
+// Consumes the next token, which must be text.
+func (b *bootstrapper) expect(text string) error {
+	if token := b.next(); !token.is(text) {
+		return b.syntaxError(token)
+	}
+	return nil
+}
+
+func (b *bootstrapper) oid() (system.Oid, error) {
+	token := b.next()
+	oid, err := strconv.ParseUint(token.text, 10, 32)
+	if token.quoted || err != nil {
+		return system.InvalidOid, b.syntaxError(token)
+	}
+	return system.Oid(oid), nil
+}
+
+func (b *bootstrapper) syntaxError(token bkiToken) error {
+	if token.is("") {
+		return system.Elog("syntax error at end of BKI script")
+	}
+	return system.Elog("syntax error at line %d: unexpected \"%s\"", token.line, token.text)
+}
+
+func (b *bootstrapper) create() error {
+	if b.rel != nil {
+		return system.Elog("relation \"%s\" was not closed", b.rel.RelName)
+	}
+	name, err := b.word()
+	if err != nil {
+		return err
+	}
+	relid, err := b.oid()
+	if err != nil {
+		return err
+	}
+	shared, hasOid := false, true
+	for {
+		token := b.next()
+		if token.is("shared_relation") {
+			shared = true
+		} else if token.is("without_oids") {
+			hasOid = false
+		} else if token.is("(") {
+			break
+		} else {
+			return b.syntaxError(token)
+		}
+	}
+
+	var attrs []*access.Attribute
+	for {
+		attname, err := b.word()
+		if err != nil {
+			return err
+		}
+		if err := b.expect("="); err != nil {
+			return err
+		}
+		typname, err := b.word()
+		if err != nil {
+			return err
+		}
+		typ, err := system.LookupTypeByName(typname)
+		if err != nil {
+			return err
+		}
+		attrs = append(attrs, &access.Attribute{Name: system.Name(attname), TypeId: typ.Id, NotNull: true})
+		if token := b.next(); token.is(")") {
+			break
+		} else if !token.is(",") {
+			return b.syntaxError(token)
+		}
+	}
+
+	rel := access.BuildLocalRelation(relid, relid, access.TemplateDbId, system.Name(name),
+		access.CatalogNamespaceId, access.NewTupleDesc(attrs, hasOid), shared)
+	if err := rel.CreateStorage(); err != nil {
+		return err
+	}
+	b.rel = rel
+	return nil
+}
+
+func (b *bootstrapper) insert() error {
+	if b.rel == nil {
+		return system.Elog("insert with no open relation at line %d", b.next().line)
+	}
+	oid := system.InvalidOid
+	token := b.next()
+	if token.is("OID") {
+		if err := b.expect("="); err != nil {
+			return err
+		}
+		var err error
+		if oid, err = b.oid(); err != nil {
+			return err
+		}
+		token = b.next()
+	}
+	if !token.is("(") {
+		return b.syntaxError(token)
+	}
+
+	tupdesc := b.rel.RelDesc
+	var values []system.Datum
+	for {
+		token := b.next()
+		if token.is(")") {
+			break
+		} else if token.is("") || !token.quoted && !token.isWord() {
+			return b.syntaxError(token)
+		}
+		if len(values) == len(tupdesc.Attrs) {
+			return system.Elog("too many values for \"%s\" at line %d", b.rel.RelName, token.line)
+		}
+		if token.is(bkiNull) {
+			values = append(values, nil)
+			continue
+		}
+		value, err := system.DatumFromString(token.text, tupdesc.Attrs[len(values)].TypeId)
+		if err != nil {
+			return err
+		}
+		values = append(values, value)
+	}
+	if len(values) != len(tupdesc.Attrs) {
+		return system.Elog("too few values for \"%s\" at line %d", b.rel.RelName, token.line)
+	}
+
+	tuple := access.FormHeapTuple(values, tupdesc)
+	if oid != system.InvalidOid {
+		if !tupdesc.HasOid() {
+			return system.Elog("\"%s\" has no oids, at line %d", b.rel.RelName, token.line)
+		}
+		tuple.SetOid(oid)
+	}
+	return b.rel.Insert(tuple, b.bufMgr)
+}
+
+func (b *bootstrapper) close() error {
+	name, err := b.word()
+	if err != nil {
+		return err
+	}
+	if b.rel == nil || string(b.rel.RelName) != name {
+		return system.Elog("close of \"%s\" which is not open", name)
+	}
+	b.rel = nil
+	return nil
+}
@@ -0,0 +1,128 @@
+package bootstrap
+
+import (
+	"bytes"
+	. "launchpad.net/gocheck"
+	"os"
+	"strings"
+	"testing"
+
+	"bigpot/access"
+	"bigpot/storage"
+	"bigpot/system"
+)
+
+// Hook up gocheck into the gotest runner.
+func Test(t *testing.T) {
+	TestingT(t)
+}
+
+type MySuite struct{}
+
+var _ = Suite(&MySuite{})
+
+// Changes to a new empty directory, returning the function to change back.
+func chdirTemp(c *C) func() {
+	cwd, err := os.Getwd()
+	c.Assert(err, IsNil)
+	c.Assert(os.Chdir(c.MkDir()), IsNil)
+	return func() { os.Chdir(cwd) }
+}
+
+// Returns the first column of every row of the catalog.
+func catalogNames(c *C, relid system.Oid, bufMgr storage.BufferManager) []system.Datum {
+	rel, err := access.HeapOpen(relid, access.TemplateDbId, bufMgr)
+	c.Assert(err, IsNil)
+	scan, err := rel.BeginScan(nil, bufMgr)
+	c.Assert(err, IsNil)
+	defer scan.EndScan()
+	var names []system.Datum
+	for {
+		tuple, err := scan.Next()
+		c.Assert(err, IsNil)
+		if tuple == nil {
+			return names
+		}
+		names = append(names, tuple.Fetch(1))
+	}
+}
+
+func (s *MySuite) TestInitDB(c *C) {
+	defer chdirTemp(c)()
+
+	var bki bytes.Buffer
+	c.Assert(GenBKI(&bki), IsNil)
+	c.Check(strings.HasPrefix(bki.String(), "create bp_type 1247\n (\n typname = name ,\n"), Equals, true)
+	c.Check(bki.String(), Matches, "(?s).*\ninsert OID = 16 \\( bool 11 10 1 t b 0 0 \\)\n.*")
+	c.Check(bki.String(), Matches, "(?s).*\ncreate bp_database 1262 shared_relation\n.*")
+	c.Assert(InitDB(&bki), IsNil)
+
+	ctl, err := storage.ReadControlFile()
+	c.Assert(err, IsNil)
+	c.Check(ctl.CatalogVersion, Equals, uint32(system.CatalogVersion))
+	c.Check(ctl.State, Equals, storage.DBShutdowned)
+	_, err = os.Stat("global/1262")
+	c.Check(err, IsNil)
+
+	// the catalogs on disk describe themselves
+	bufMgr, err := storage.NewBufferManager(64)
+	c.Assert(err, IsNil)
+	queue := access.NewSharedInvalQueue()
+	syscache := access.NewSysCache(bufMgr, queue, access.TemplateDbId)
+	defer syscache.Close()
+	relcache := access.NewRelCache(bufMgr, queue, access.TemplateDbId)
+	defer relcache.Close()
+	for _, catalog := range access.Catalogs {
+		relid, err := syscache.GetSysCacheOid(access.RelNameNspCache, catalog.Name, access.CatalogNamespaceId)
+		c.Assert(err, IsNil)
+		c.Check(relid, Equals, catalog.RelId)
+		rel, err := access.HeapOpen(relid, access.TemplateDbId, bufMgr)
+		c.Assert(err, IsNil)
+		tuple, err := syscache.SearchSysCache(access.RelOidCache, relid)
+		c.Assert(err, IsNil)
+		c.Check(tuple.Fetch(access.Anum_class_relnatts), Equals, system.Int4(len(rel.RelDesc.Attrs)))
+	}
+	c.Check(catalogNames(c, access.TypeRelId, bufMgr), HasLen, len(system.TypeRegistry))
+	c.Check(catalogNames(c, access.ProcRelId, bufMgr), HasLen, len(system.ProcRegistry))
+	c.Check(catalogNames(c, access.DatabaseRelId, bufMgr), DeepEquals, []system.Datum{system.Name("template1")})
+}
+
+func (s *MySuite) TestBootstrapScript(c *C) {
+	defer chdirTemp(c)()
+	c.Assert(os.MkdirAll("base/1", 0700), IsNil)
+	c.Assert(os.MkdirAll("global", 0700), IsNil)
+	c.Assert(storage.WriteControlFile(storage.NewControlFileData()), IsNil)
+	bufMgr, err := storage.NewBufferManager(16)
+	c.Assert(err, IsNil)
+
+	err = Bootstrap(strings.NewReader(`
+create bp_namespace 2615
+ ( nspname = name , nspowner = oid )
+insert OID = 11 ( bp_catalog 10 )
+insert OID = 12 ( "two words" 10 )
+insert OID = 13 ( "quo\"te" _null_ )
+insert OID = 14 ( "" "10" )
+close bp_namespace
+`), bufMgr)
+	c.Assert(err, IsNil)
+	c.Check(catalogNames(c, access.NamespaceRelId, bufMgr), DeepEquals, []system.Datum{
+		system.Name("bp_catalog"), system.Name("two words"), system.Name(`quo"te`), system.Name(""),
+	})
+	c.Check(bkiValue(system.Name(`quo"te`)), Equals, `"quo\"te"`)
+	c.Check(bkiValue(system.Name("")), Equals, `""`)
+	c.Check(bkiValue(nil), Equals, "_null_")
+
+	for _, bad := range []struct{ script, err string }{
+		{"create bp_am 2601 ( amname = name )\ninsert ( btree", "syntax error at end of BKI script"},
+		{"create bp_am 2601 ( amname = name )\ninsert ( btree x )", "too many values for \"bp_am\" at line 2"},
+		{"create bp_am 2601 ( amname = foo )", "type \"foo\" does not exist"},
+		{"create bp_am 2601 ( amname = name )\nclose bp_class", "close of \"bp_class\" which is not open"},
+		{"create bp_am 2601 ( amname = name )", "relation \"bp_am\" was not closed"},
+		{"insert ( x )", "insert with no open relation at line 1"},
+		{"create bp_am x", "syntax error at line 1: unexpected \"x\""},
+		{"create \"bp_am", "unterminated quoted string at line 1"},
+	} {
+		os.Remove("base/1/2601")
+		c.Check(Bootstrap(strings.NewReader(bad.script), bufMgr), ErrorMatches, bad.err)
+	}
+}
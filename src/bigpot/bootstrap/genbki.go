@@ -0,0 +1,63 @@
+package bootstrap
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+
+	"bigpot/access"
+	"bigpot/system"
+)
+
+// Writes the BKI script that makes the system catalogs and fills them with
+// their bootstrap rows, as postgres' genbki.pl.
+func GenBKI(w io.Writer) error {
+	out := bufio.NewWriter(w)
+	rows := access.BootstrapRows()
+	for _, catalog := range access.Catalogs {
+		fmt.Fprintf(out, "create %s %d", catalog.Name, catalog.RelId)
+		if catalog.Shared {
+			fmt.Fprint(out, " shared_relation")
+		}
+		if !catalog.Desc.HasOid() {
+			fmt.Fprint(out, " without_oids")
+		}
+		fmt.Fprint(out, "\n (\n")
+		for i, attr := range catalog.Desc.Attrs {
+			sep := " ,"
+			if i == len(catalog.Desc.Attrs)-1 {
+				sep = ""
+			}
+			fmt.Fprintf(out, " %s = %s%s\n", attr.Name, attr.Type.Name, sep)
+		}
+		fmt.Fprint(out, " )\n")
+
+		for _, row := range rows[catalog.RelId] {
+			fmt.Fprint(out, "insert ")
+			if row.Oid != system.InvalidOid {
+				fmt.Fprintf(out, "OID = %d ", row.Oid)
+			}
+			fmt.Fprint(out, "(")
+			for _, value := range row.Values {
+				fmt.Fprintf(out, " %s", bkiValue(value))
+			}
+			fmt.Fprint(out, " )\n")
+		}
+		fmt.Fprintf(out, "close %s\n", catalog.Name)
+	}
+	return out.Flush()
+}
+
+// Returns the value as a BKI token, quoted if it is not a single word.
+func bkiValue(value system.Datum) string {
+	if value == nil {
+		return bkiNull
+	}
+	str := value.ToString()
+	if str != "" && !strings.ContainsAny(str, " \t\n\"\\(),=") {
+		return str
+	}
+	replacer := strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+	return `"` + replacer.Replace(str) + `"`
+}
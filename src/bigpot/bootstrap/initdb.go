@@ -0,0 +1,37 @@
+package bootstrap
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"bigpot/access"
+	"bigpot/storage"
+)
+
+// Number of buffers to bootstrap with.
+const bootstrapBuffers = 64
+
+// Makes a data directory in the current directory, as postgres' initdb:
+// the directories of the shared relations and of the template database,
+// the control file, and the catalogs made and filled by the BKI script.
+// The control file is written first, as postgres' BootStrapXLOG, for the
+// buffer manager checks it, and it records a clean shutdown at the end.
+func InitDB(bki io.Reader) error {
+	for _, dir := range []string{"global", fmt.Sprintf("base/%d", access.TemplateDbId)} {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return err
+		}
+	}
+	if err := storage.WriteControlFile(storage.NewControlFileData()); err != nil {
+		return err
+	}
+	bufMgr, err := storage.NewBufferManager(bootstrapBuffers)
+	if err != nil {
+		return err
+	}
+	if err := Bootstrap(bki, bufMgr); err != nil {
+		return err
+	}
+	return bufMgr.Close()
+}
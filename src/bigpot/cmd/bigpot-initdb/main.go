@@ -0,0 +1,66 @@
+// Command bigpot-initdb creates a data directory, as postgres' initdb.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"bigpot/bootstrap"
+)
+
+func main() {
+	dataDir := flag.String("D", "", "location of the new data directory")
+	bkiFile := flag.String("bki", "", "BKI script to bootstrap with, instead of the built-in one")
+	showBKI := flag.Bool("show-bki", false, "print the built-in BKI script and exit")
+	flag.Parse()
+
+	if *showBKI {
+		if err := bootstrap.GenBKI(os.Stdout); err != nil {
+			fatal(err)
+		}
+		return
+	}
+	if *dataDir == "" {
+		fmt.Fprintln(os.Stderr, "bigpot-initdb: no data directory specified")
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	var bki io.Reader
+	if *bkiFile != "" {
+		file, err := os.Open(*bkiFile)
+		if err != nil {
+			fatal(err)
+		}
+		defer file.Close()
+		bki = file
+	} else {
+		var buf bytes.Buffer
+		if err := bootstrap.GenBKI(&buf); err != nil {
+			fatal(err)
+		}
+		bki = &buf
+	}
+
+	if entries, err := os.ReadDir(*dataDir); err == nil && len(entries) > 0 {
+		fatal(fmt.Errorf("directory \"%s\" exists but is not empty", *dataDir))
+	}
+	if err := os.MkdirAll(*dataDir, 0700); err != nil {
+		fatal(err)
+	}
+	if err := os.Chdir(*dataDir); err != nil {
+		fatal(err)
+	}
+	if err := bootstrap.InitDB(bki); err != nil {
+		fatal(err)
+	}
+	fmt.Printf("Success. The data directory \"%s\" is ready.\n", *dataDir)
+}
+
+func fatal(err error) {
+	fmt.Fprintf(os.Stderr, "bigpot-initdb: %s\n", err)
+	os.Exit(1)
+}
@@ -0,0 +1,472 @@
+package commands
+
+import (
+	"bigpot/access"
+	"bigpot/executor"
+	"bigpot/parser"
+	"bigpot/system"
+)
+
+// Makes the table of a CREATE TABLE, as postgres' DefineRelation, and
+// returns its oid.  The defaults are checked in the session's time zone
+// tz, but kept as source text, to be read again by each INSERT.
+func DefineRelation(stmt *parser.CreateStmt, tx *access.Transaction, syscache *access.SysCache,
+	relcache *access.RelCache, tz *system.TimeZone) (system.Oid, error) {
+	namespace, err := access.RangeVarGetCreationNamespace(stmt.Relation.SchemaName, syscache)
+	if err != nil {
+		return system.InvalidOid, err
+	}
+	seen := map[string]bool{}
+	var attrs []*access.Attribute
+	var defaults []access.AttrDefault
+	for i, coldef := range stmt.TableElts {
+		if seen[coldef.ColName] {
+			return system.InvalidOid, system.Ereport(system.DuplicateColumn,
+				"column \"%s\" specified more than once", coldef.ColName)
+		}
+		seen[coldef.ColName] = true
+		typid, err := parser.TypenameTypeId(coldef.TypeName, syscache)
+		if err != nil {
+			return system.InvalidOid, err
+		}
+		attr := &access.Attribute{
+			Name:    system.Name(coldef.ColName),
+			TypeId:  typid,
+			NotNull: coldef.IsNotNull,
+		}
+		attrs = append(attrs, attr)
+		if coldef.RawDefault != nil {
+			_, src, err := cookDefault(coldef.RawDefault, attr, syscache, relcache, tz)
+			if err != nil {
+				return system.InvalidOid, err
+			} else if src != "" {
+				defaults = append(defaults, access.AttrDefault{AttNum: system.AttrNumber(i + 1), Src: src})
+			}
+		}
+	}
+	tupdesc := access.NewTupleDesc(attrs, false)
+	if len(defaults) > 0 {
+		tupdesc.Constr = &access.TupleConstr{Defaults: defaults}
+	}
+	return access.HeapCreateWithCatalog(tx, stmt.Relation.RelationName, namespace, tupdesc)
+}
+
+// Transforms the default expression of the column attr, coerced to the
+// type of the column, as postgres' cookDefault, and returns it with its
+// source text, which is what bp_attrdef keeps.  Literals are read in the
+// time zone tz.  A NULL default is no default: it is returned as nil,
+// with empty source text.
+func cookDefault(raw parser.Node, attr *access.Attribute, syscache *access.SysCache,
+	relcache *access.RelCache, tz *system.TimeZone) (parser.Expr, string, error) {
+	p := parser.NewParser(relcache, syscache)
+	p.SetTimeZone(tz)
+	expr, err := p.TransformColumnDefault(raw, attr)
+	if err != nil {
+		return nil, "", err
+	} else if c, ok := expr.(*parser.Const); ok && c.Value == nil {
+		return nil, "", nil
+	}
+	return expr, parser.DeparseExpr(raw), nil
+}
+
+// Drops the tables of a DROP TABLE, as postgres' RemoveRelations.  The
+// indexes of a table go with it; nothing else can depend on a table yet,
+// so CASCADE and RESTRICT are the same.
+func RemoveRelations(stmt *parser.DropStmt, tx *access.Transaction, syscache *access.SysCache,
+	relcache *access.RelCache) error {
+	for _, rv := range stmt.Objects {
+		relid, err := access.RangeVarGetRelid(rv.SchemaName, rv.RelationName, syscache)
+		if err != nil {
+			return err
+		} else if relid == system.InvalidOid {
+			if stmt.MissingOk {
+				continue
+			}
+			return system.Ereport(system.UndefinedTable, "table \"%s\" does not exist", rv.RelationName)
+		}
+		if _, err := openAlterableTable(relid, rv, relcache); err != nil {
+			return err
+		}
+		if err := access.HeapDropWithCatalog(tx, relid); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Opens the table relid named by rv, for a command that changes or drops
+// it, as postgres' RangeVarCallbackForDropRelation and
+// RangeVarCallbackForAlterRelation check.  System catalogs cannot be
+// changed.
+func openAlterableTable(relid system.Oid, rv *parser.RangeVar,
+	relcache *access.RelCache) (*access.HeapRelation, error) {
+	if access.LookupCatalog(relid) != nil {
+		return nil, system.Ereport(system.InsufficientPrivilege,
+			"permission denied: \"%s\" is a system catalog", rv.RelationName)
+	}
+	rel, err := relcache.HeapOpen(relid)
+	if err != nil {
+		return nil, err
+	} else if rel.RelKind != access.RelKindRelation {
+		return nil, system.Ereport(system.WrongObjectType, "\"%s\" is not a table", rv.RelationName)
+	}
+	return rel, nil
+}
+
+// Returns the table an ALTER TABLE names, or nil if it does not exist
+// and missingOk is set.
+func lookupAlterableTable(rv *parser.RangeVar, missingOk bool, syscache *access.SysCache,
+	relcache *access.RelCache) (*access.HeapRelation, error) {
+	relid, err := access.RangeVarGetRelid(rv.SchemaName, rv.RelationName, syscache)
+	if err != nil {
+		return nil, err
+	} else if relid == system.InvalidOid {
+		if missingOk {
+			return nil, nil
+		}
+		return nil, system.Ereport(system.UndefinedTable, "relation \"%s\" does not exist", rv.RelationName)
+	}
+	return openAlterableTable(relid, rv, relcache)
+}
+
+// Returns the number of the column of the table named name, or
+// InvalidAttrNumber if it has none, as postgres' get_attnum.  Dropped
+// columns are not found.
+func findColumn(rel *access.HeapRelation, name string) system.AttrNumber {
+	for i, attr := range rel.RelDesc.Attrs {
+		if !attr.IsDropped && attr.Name == system.Name(name) {
+			return system.AttrNumber(i + 1)
+		}
+	}
+	return system.InvalidAttrNumber
+}
+
+// Runs the commands of an ALTER TABLE in order, as postgres' AlterTable.
+// The table is opened again for each of them, to see what the previous
+// ones did.  Values are converted in the session's time zone tz.
+func AlterTable(stmt *parser.AlterTableStmt, tx *access.Transaction, syscache *access.SysCache,
+	relcache *access.RelCache, tz *system.TimeZone) error {
+	for _, cmd := range stmt.Cmds {
+		rel, err := lookupAlterableTable(stmt.Relation, stmt.MissingOk, syscache, relcache)
+		if err != nil || rel == nil {
+			return err
+		}
+		switch cmd.Subtype {
+		case parser.AT_AddColumn:
+			err = atExecAddColumn(tx, rel, cmd.Def, syscache, relcache, tz)
+		case parser.AT_DropColumn:
+			err = atExecDropColumn(tx, rel, cmd)
+		case parser.AT_AlterColumnType:
+			err = atExecAlterColumnType(tx, rel, cmd.Def, syscache, relcache, tz)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Adds a column at the end of the table, as postgres' ATExecAddColumn.
+// The table is not rewritten: the tuples written before have fewer
+// attributes, and read the default of the column, computed once here, as
+// its missing value.
+func atExecAddColumn(tx *access.Transaction, rel *access.HeapRelation, coldef *parser.ColumnDef,
+	syscache *access.SysCache, relcache *access.RelCache, tz *system.TimeZone) error {
+	if findColumn(rel, coldef.ColName) != system.InvalidAttrNumber {
+		return system.Ereport(system.DuplicateColumn,
+			"column \"%s\" of relation \"%s\" already exists", coldef.ColName, rel.RelName)
+	}
+	typid, err := parser.TypenameTypeId(coldef.TypeName, syscache)
+	if err != nil {
+		return err
+	}
+	attr := &access.Attribute{
+		Name:    system.Name(coldef.ColName),
+		TypeId:  typid,
+		Type:    system.TypeRegistry[typid],
+		NotNull: coldef.IsNotNull,
+	}
+	var src string
+	if coldef.RawDefault != nil {
+		var expr parser.Expr
+		if expr, src, err = cookDefault(coldef.RawDefault, attr, syscache, relcache, tz); err != nil {
+			return err
+		} else if expr != nil {
+			state, err := executor.ExecInitExpr(expr)
+			if err != nil {
+				return err
+			}
+			attr.Missing, err = state.Eval(executor.CreateStandaloneExprContext(tx, tz))
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if coldef.IsNotNull && attr.Missing == nil {
+		empty, err := tableIsEmpty(tx, rel)
+		if err != nil {
+			return err
+		} else if !empty {
+			return system.Ereport(system.NotNullViolation,
+				"column \"%s\" of relation \"%s\" contains null values", coldef.ColName, rel.RelName)
+		}
+	}
+
+	attnum := system.AttrNumber(len(rel.RelDesc.Attrs) + 1)
+	if err := access.InsertAttributeTuple(tx, rel.RelId, attnum, attr); err != nil {
+		return err
+	}
+	if src != "" {
+		if err := access.StoreAttrDefault(tx, rel.RelId, attnum, src); err != nil {
+			return err
+		}
+	}
+	return access.UpdateClassTuple(tx, rel.RelId, map[system.AttrNumber]system.Datum{
+		access.Anum_class_relnatts: system.Int4(attnum),
+	})
+}
+
+// Returns true if the table has no rows.
+func tableIsEmpty(tx *access.Transaction, rel *access.HeapRelation) (bool, error) {
+	scan, err := rel.BeginScan(nil, tx.BufMgr())
+	if err != nil {
+		return false, err
+	}
+	defer scan.EndScan()
+	tuple, err := scan.Next()
+	return tuple == nil, err
+}
+
+// Drops a column, as postgres' ATExecDropColumn.  The column stays in the
+// tuples, marked dropped in bp_attribute, and the indexes on it are
+// dropped with it.
+func atExecDropColumn(tx *access.Transaction, rel *access.HeapRelation, cmd *parser.AlterTableCmd) error {
+	attnum := findColumn(rel, cmd.Name)
+	if attnum == system.InvalidAttrNumber {
+		if cmd.MissingOk {
+			return nil
+		}
+		return system.Ereport(system.UndefinedColumn,
+			"column \"%s\" of relation \"%s\" does not exist", cmd.Name, rel.RelName)
+	}
+	indexes, err := access.RelationGetIndexList(rel.RelId, tx.DatabaseId(), tx.BufMgr())
+	if err != nil {
+		return err
+	}
+	for _, indexrelid := range indexes {
+		index, err := access.IndexOpen(indexrelid, tx.DatabaseId(), tx.BufMgr())
+		if err != nil {
+			return err
+		}
+		for _, heapAttr := range index.HeapAttrs {
+			if heapAttr == attnum {
+				if err := access.IndexDrop(tx, indexrelid); err != nil {
+					return err
+				}
+				break
+			}
+		}
+	}
+	return access.RemoveAttributeById(tx, rel.RelId, attnum)
+}
+
+// Changes the type of a column, as postgres' ATExecAlterColumnType, and
+// rewrites the table into a new file with the values converted, as
+// postgres' ATRewriteTable.  Values are converted through their text
+// form, and so is the default of the column.  The indexes are built again
+// on the new file.
+func atExecAlterColumnType(tx *access.Transaction, rel *access.HeapRelation, coldef *parser.ColumnDef,
+	syscache *access.SysCache, relcache *access.RelCache, tz *system.TimeZone) error {
+	attnum := findColumn(rel, coldef.ColName)
+	if attnum == system.InvalidAttrNumber {
+		return system.Ereport(system.UndefinedColumn,
+			"column \"%s\" of relation \"%s\" does not exist", coldef.ColName, rel.RelName)
+	}
+	typid, err := parser.TypenameTypeId(coldef.TypeName, syscache)
+	if err != nil {
+		return err
+	}
+	attr := rel.RelDesc.Attrs[attnum-1]
+	if typid == attr.TypeId {
+		return nil
+	}
+	typ := system.TypeRegistry[typid]
+
+	if attr.HasDefault {
+		if err := convertDefault(tx, rel, attnum, typ, syscache, relcache, tz); err != nil {
+			return err
+		}
+	}
+	err = access.UpdateAttributeTuple(tx, rel.RelId, attnum, map[system.AttrNumber]system.Datum{
+		access.Anum_attribute_atttypid:      typid,
+		access.Anum_attribute_attlen:        system.Int4(typ.Len),
+		access.Anum_attribute_atthasmissing: system.Bool(false),
+		access.Anum_attribute_attmissingval: system.Text(""),
+	})
+	if err != nil {
+		return err
+	}
+	if err := alterIndexColumnType(tx, rel, attnum, typ); err != nil {
+		return err
+	}
+
+	newAttrs := make([]*access.Attribute, len(rel.RelDesc.Attrs))
+	for i, old := range rel.RelDesc.Attrs {
+		newAttr := *old
+		newAttrs[i] = &newAttr
+	}
+	newAttrs[attnum-1].TypeId = typid
+	newAttrs[attnum-1].Type = typ
+	newAttrs[attnum-1].Missing = nil
+	if err := rewriteTable(tx, rel, access.NewTupleDesc(newAttrs, rel.RelDesc.HasOid()), attnum, tz); err != nil {
+		return err
+	}
+	return access.ReindexRelation(tx, rel.RelId)
+}
+
+// Checks that the default of the column converts to the type typ, and
+// stores it again.
+func convertDefault(tx *access.Transaction, rel *access.HeapRelation, attnum system.AttrNumber,
+	typ *system.TypeInfo, syscache *access.SysCache, relcache *access.RelCache, tz *system.TimeZone) error {
+	var src string
+	for _, def := range rel.RelDesc.Constr.Defaults {
+		if def.AttNum == attnum {
+			src = def.Src
+		}
+	}
+	raw, err := parser.RawParseExpr(src)
+	if err != nil {
+		return err
+	}
+	attr := &access.Attribute{Name: rel.RelDesc.Attrs[attnum-1].Name, TypeId: typ.Id, Type: typ}
+	_, newSrc, err := cookDefault(raw, attr, syscache, relcache, tz)
+	if err != nil && typ.Id == system.TextType {
+		// any type goes to text by its output function, as by the
+		// assignment casts of postgres
+		raw = &parser.TypeCast{Arg: raw, TypeName: &parser.TypeName{Name: string(typ.Name)}}
+		_, newSrc, err = cookDefault(raw, attr, syscache, relcache, tz)
+	}
+	if err != nil {
+		return system.Ereport(system.DatatypeMismatch,
+			"default for column \"%s\" cannot be cast automatically to type %s", attr.Name, typ.Name)
+	}
+	if err := access.RemoveAttrDefault(tx, rel.RelId, attnum); err != nil {
+		return err
+	}
+	if newSrc == "" {
+		return nil
+	}
+	return access.StoreAttrDefault(tx, rel.RelId, attnum, newSrc)
+}
+
+// Changes the type of the index columns taken from the column attnum.
+func alterIndexColumnType(tx *access.Transaction, rel *access.HeapRelation, attnum system.AttrNumber,
+	typ *system.TypeInfo) error {
+	indexes, err := access.RelationGetIndexList(rel.RelId, tx.DatabaseId(), tx.BufMgr())
+	if err != nil {
+		return err
+	}
+	for _, indexrelid := range indexes {
+		index, err := access.IndexOpen(indexrelid, tx.DatabaseId(), tx.BufMgr())
+		if err != nil {
+			return err
+		}
+		for i, heapAttr := range index.HeapAttrs {
+			if heapAttr != attnum {
+				continue
+			}
+			err := access.UpdateAttributeTuple(tx, indexrelid, system.AttrNumber(i+1),
+				map[system.AttrNumber]system.Datum{
+					access.Anum_attribute_atttypid: typ.Id,
+					access.Anum_attribute_attlen:   system.Int4(typ.Len),
+				})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Copies the rows of the table into a new file, described by tupdesc,
+// converting the column attnum to its new type by the function of the
+// cast, or else through its text form, in the time zone tz.
+func rewriteTable(tx *access.Transaction, rel *access.HeapRelation, tupdesc *access.TupleDesc,
+	attnum system.AttrNumber, tz *system.TimeZone) error {
+	node, err := rel.SetNewRelFileNode(tx)
+	if err != nil {
+		return err
+	}
+	newRel := *rel
+	newRel.RelNode = node
+	newRel.RelDesc = tupdesc
+	typid := tupdesc.Attrs[attnum-1].TypeId
+	cast := system.LookupCastFunc(rel.RelDesc.Attrs[attnum-1].TypeId, typid)
+
+	scan, err := rel.BeginScan(nil, tx.BufMgr())
+	if err != nil {
+		return err
+	}
+	defer scan.EndScan()
+	for {
+		tuple, err := scan.Next()
+		if err != nil {
+			return err
+		} else if tuple == nil {
+			return nil
+		}
+		values := tuple.(*access.HeapTuple).Deform()
+		for i, attr := range tupdesc.Attrs {
+			if attr.IsDropped {
+				values[i] = nil
+			}
+		}
+		if old := values[attnum-1]; old != nil && cast != nil {
+			if values[attnum-1], err = cast.CallInZone(tz, old); err != nil {
+				return err
+			}
+		} else if old != nil {
+			text := system.DatumToStringInZone(old, tz)
+			if values[attnum-1], err = system.DatumFromStringInZone(text, typid, tz); err != nil {
+				return err
+			}
+		}
+		if err := tx.HeapInsert(&newRel, access.FormHeapTuple(values, tupdesc)); err != nil {
+			return err
+		}
+	}
+}
+
+// Renames a table, as postgres' RenameRelation, or one of its columns, as
+// postgres' renameatt.
+func RenameRelation(stmt *parser.RenameStmt, tx *access.Transaction, syscache *access.SysCache,
+	relcache *access.RelCache) error {
+	rel, err := lookupAlterableTable(stmt.Relation, stmt.MissingOk, syscache, relcache)
+	if err != nil || rel == nil {
+		return err
+	}
+	if stmt.SubName == "" {
+		existing, err := syscache.GetSysCacheOid(access.RelNameNspCache, system.Name(stmt.NewName),
+			rel.RelNamespace)
+		if err != nil {
+			return err
+		} else if existing != system.InvalidOid {
+			return system.Ereport(system.DuplicateTable, "relation \"%s\" already exists", stmt.NewName)
+		}
+		return access.UpdateClassTuple(tx, rel.RelId, map[system.AttrNumber]system.Datum{
+			access.Anum_class_relname: system.Name(stmt.NewName),
+		})
+	}
+
+	attnum := findColumn(rel, stmt.SubName)
+	if attnum == system.InvalidAttrNumber {
+		return system.Ereport(system.UndefinedColumn,
+			"column \"%s\" does not exist", stmt.SubName)
+	} else if findColumn(rel, stmt.NewName) != system.InvalidAttrNumber {
+		return system.Ereport(system.DuplicateColumn,
+			"column \"%s\" of relation \"%s\" already exists", stmt.NewName, rel.RelName)
+	}
+	return access.UpdateAttributeTuple(tx, rel.RelId, attnum, map[system.AttrNumber]system.Datum{
+		access.Anum_attribute_attname: system.Name(stmt.NewName),
+	})
+}
@@ -0,0 +1,485 @@
+package executor
+
+import (
+	"bufio"
+	"os"
+	"sort"
+
+	"bigpot/access"
+	"bigpot/planner"
+	"bigpot/storage"
+	"bigpot/system"
+)
+
+const (
+	// the partitions the rows of the groups left out of a full hash table
+	// are written to, by bits of their hash, as postgres'
+	// HASHAGG_MIN_PARTITIONS
+	hashSpillBits       = 2
+	hashSpillPartitions = 1 << hashSpillBits
+	// the times rows may be written out before the bits of their hash are
+	// used up; past that, the hash table grows past work_mem
+	maxSpillLevel = 32 / hashSpillBits
+)
+
+// aggGroup is a group of the rows of an Agg: the first of them, whose
+// columns the target list reads, and the transition states of the
+// aggregates, as postgres' AggStatePerGroup.  The arguments of a DISTINCT
+// aggregate are kept until the group is done.
+type aggGroup struct {
+	tuple    VirtualTuple
+	states   []system.Datum
+	distinct [][]VirtualTuple
+}
+
+// aggBatch is the rows written to a partition while the hash table was
+// full, which are grouped once the table is done with, as postgres'
+// HashAggBatch.  level is the times they were written out, which tells
+// the bits of their hash they are partitioned by if they are again.
+type aggBatch struct {
+	file  *os.File
+	level int
+}
+
+// Agg computes the aggregates over each group of the rows of its subplan,
+// as postgres' AggState.
+type Agg struct {
+	planner.Agg
+	executor   *ExecutorImpl
+	subplan    Node
+	inputDesc  *access.TupleDesc
+	targetList []*ExprState
+	targetDesc *access.TupleDesc
+	qual       []*ExprState
+	econtext   *ExprContext
+	// the arguments of each aggregate, and the comparison functions of
+	// their types for a DISTINCT one
+	aggArgs          [][]*ExprState
+	distinctCompares [][]system.CompareFunc
+	// the comparison and the hash functions of the columns grouped by
+	compares  []system.CompareFunc
+	hashFuncs []system.HashFunc
+	started   bool
+	// AGG_PLAIN and AGG_SORTED: the first row of the next group, read
+	// already, and whether the rows are done
+	nextTuple access.Tuple
+	done      bool
+	// AGG_HASHED: the groups of the hash table by the hash of their
+	// columns, and all of them in the order they were made, the next of
+	// which is returned; the memory they take, and the batches of rows
+	// left to group
+	table    map[uint32][]*aggGroup
+	groups   []*aggGroup
+	groupPos int
+	memUsed  int64
+	batches  []aggBatch
+}
+
+func (agg *Agg) Init() error {
+	var err error
+	if agg.subplan, err = agg.executor.initExecNode(agg.LeftTree); err != nil {
+		return err
+	}
+	agg.inputDesc = agg.subplan.ResultDesc()
+	if agg.targetList, err = ExecInitTargetList(agg.TargetList); err != nil {
+		return err
+	}
+	agg.targetDesc = ExecTypeFromTL(agg.TargetList)
+	if agg.qual, err = ExecInitQual(agg.Qual); err != nil {
+		return err
+	}
+	for _, aggref := range agg.Aggs {
+		args, err := ExecInitExprList(aggref.Args)
+		if err != nil {
+			return err
+		}
+		var compares []system.CompareFunc
+		if aggref.AggDistinct {
+			for _, arg := range aggref.Args {
+				compare, err := system.LookupCompare(arg.ResultType(), arg.ResultType())
+				if err != nil {
+					return err
+				}
+				compares = append(compares, compare)
+			}
+		}
+		agg.aggArgs = append(agg.aggArgs, args)
+		agg.distinctCompares = append(agg.distinctCompares, compares)
+	}
+	for _, attno := range agg.GroupCols {
+		typid := agg.inputDesc.Attrs[attno-1].TypeId
+		compare, err := system.LookupCompare(typid, typid)
+		if err != nil {
+			return err
+		}
+		agg.compares = append(agg.compares, compare)
+		if agg.AggStrategy == planner.AGG_HASHED {
+			hashFunc, err := system.LookupHash(typid)
+			if err != nil {
+				return err
+			}
+			agg.hashFuncs = append(agg.hashFuncs, hashFunc)
+		}
+	}
+	agg.econtext = agg.executor.createExprContext()
+	agg.econtext.AggValues = make([]system.Datum, len(agg.Aggs))
+	return nil
+}
+
+// Makes the group of the row, the first of it, as postgres'
+// initialize_aggregates.  The group of no rows has a nil row.
+func (agg *Agg) newGroup(tuple access.Tuple) *aggGroup {
+	group := &aggGroup{
+		states:   make([]system.Datum, len(agg.Aggs)),
+		distinct: make([][]VirtualTuple, len(agg.Aggs)),
+	}
+	if tuple != nil {
+		group.tuple = copyTuple(tuple, agg.inputDesc)
+	}
+	for i, aggref := range agg.Aggs {
+		group.states[i] = aggref.Agg.InitValue
+	}
+	return group
+}
+
+// Takes the row into the states of the aggregates of its group, as
+// postgres' advance_aggregates.  The arguments of a DISTINCT aggregate are
+// kept instead, but for those with a NULL that a strict transition
+// function would pass over anyway.
+func (agg *Agg) advanceAggregates(group *aggGroup, tuple access.Tuple) error {
+	agg.econtext.OuterTuple = tuple
+	for i, aggref := range agg.Aggs {
+		args := make(VirtualTuple, len(agg.aggArgs[i]))
+		hasNull := false
+		for j, arg := range agg.aggArgs[i] {
+			var err error
+			if args[j], err = arg.Eval(agg.econtext); err != nil {
+				return err
+			}
+			hasNull = hasNull || args[j] == nil
+		}
+		if aggref.AggDistinct {
+			if !hasNull || !aggref.Agg.TransFn.Strict {
+				group.distinct[i] = append(group.distinct[i], args)
+			}
+			continue
+		}
+		var err error
+		if group.states[i], err = aggref.Agg.Advance(group.states[i], args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Takes the arguments kept for the DISTINCT aggregate into its state, as
+// postgres' process_ordered_aggregate_multi: they are sorted, NULLs last,
+// and each of those equal is taken once.
+func (agg *Agg) advanceDistinct(aggno int, state system.Datum, rows []VirtualTuple) (system.Datum, error) {
+	compares := agg.distinctCompares[aggno]
+	compare := func(a, b VirtualTuple) int {
+		for i, compare := range compares {
+			switch {
+			case a[i] == nil && b[i] == nil:
+				continue
+			case a[i] == nil:
+				return 1
+			case b[i] == nil:
+				return -1
+			}
+			if c := compare(a[i], b[i]); c != 0 {
+				return c
+			}
+		}
+		return 0
+	}
+	sort.SliceStable(rows, func(i, j int) bool {
+		return compare(rows[i], rows[j]) < 0
+	})
+	for i, row := range rows {
+		if i > 0 && compare(rows[i-1], row) == 0 {
+			continue
+		}
+		var err error
+		if state, err = agg.Aggs[aggno].Agg.Advance(state, row...); err != nil {
+			return nil, err
+		}
+	}
+	return state, nil
+}
+
+// Computes the row of the group, as postgres' finalize_aggregates and
+// project_aggregates, or nil if the group fails the HAVING.
+func (agg *Agg) projectGroup(group *aggGroup) (access.Tuple, error) {
+	for i, aggref := range agg.Aggs {
+		state := group.states[i]
+		if aggref.AggDistinct {
+			var err error
+			if state, err = agg.advanceDistinct(i, state, group.distinct[i]); err != nil {
+				return nil, err
+			}
+		}
+		var err error
+		if agg.econtext.AggValues[i], err = aggref.Agg.Finalize(state); err != nil {
+			return nil, err
+		}
+	}
+	agg.econtext.OuterTuple = group.tuple
+	if ok, err := ExecQual(agg.qual, agg.econtext); err != nil || !ok {
+		return nil, err
+	}
+	return ExecProject(agg.targetList, agg.econtext)
+}
+
+// Returns true if the rows are equal in the columns grouped by, NULLs
+// equal to each other, as postgres' execTuplesMatch.
+func (agg *Agg) sameGroup(a, b access.Tuple) bool {
+	for i, attno := range agg.GroupCols {
+		va, vb := a.Fetch(attno), b.Fetch(attno)
+		if va == nil || vb == nil {
+			if va != nil || vb != nil {
+				return false
+			}
+			continue
+		}
+		if agg.compares[i](va, vb) != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns the hash of the columns of the row grouped by, as postgres'
+// TupleHashTableHash.  A NULL adds nothing to it.
+func (agg *Agg) hashGroup(tuple access.Tuple) uint32 {
+	var hash uint32
+	for i, attno := range agg.GroupCols {
+		hash = hash<<1 | hash>>31
+		if value := tuple.Fetch(attno); value != nil {
+			hash ^= agg.hashFuncs[i](value)
+		}
+	}
+	return hash
+}
+
+// Returns the row of the next group that satisfies the HAVING, as
+// postgres' ExecAgg, or nil once the groups are done.
+func (agg *Agg) Exec() (access.Tuple, error) {
+	for {
+		var group *aggGroup
+		var err error
+		if agg.AggStrategy == planner.AGG_HASHED {
+			group, err = agg.nextHashedGroup()
+		} else {
+			group, err = agg.nextSortedGroup()
+		}
+		if err != nil || group == nil {
+			return nil, err
+		}
+		if tuple, err := agg.projectGroup(group); err != nil || tuple != nil {
+			return tuple, err
+		}
+	}
+}
+
+// Reads the rows of the next group, as postgres' agg_retrieve_direct:
+// those equal in the columns grouped by to its first, which come one
+// after the other.  The single group of AGG_PLAIN is all the rows, or
+// none.
+func (agg *Agg) nextSortedGroup() (*aggGroup, error) {
+	if !agg.started {
+		agg.started = true
+		var err error
+		if agg.nextTuple, err = agg.subplan.Exec(); err != nil {
+			return nil, err
+		}
+		agg.done = agg.nextTuple == nil && agg.AggStrategy != planner.AGG_PLAIN
+	}
+	if agg.done {
+		return nil, nil
+	}
+	group := agg.newGroup(agg.nextTuple)
+	for agg.nextTuple != nil {
+		if err := agg.advanceAggregates(group, agg.nextTuple); err != nil {
+			return nil, err
+		}
+		next, err := agg.subplan.Exec()
+		if err != nil {
+			return nil, err
+		}
+		agg.nextTuple = next
+		if next != nil && !agg.sameGroup(group.tuple, next) {
+			break
+		}
+	}
+	agg.done = agg.nextTuple == nil
+	return group, nil
+}
+
+// Returns the next group of the hash table, as postgres'
+// agg_retrieve_hash_table.  The table is filled with the rows of the
+// subplan first, and then with those of each batch written out while it
+// was full, as postgres' agg_refill_hash_table.
+func (agg *Agg) nextHashedGroup() (*aggGroup, error) {
+	for agg.groupPos == len(agg.groups) {
+		if !agg.started {
+			agg.started = true
+			if err := agg.fillHashTable(agg.subplan.Exec, 0); err != nil {
+				return nil, err
+			}
+			continue
+		} else if len(agg.batches) == 0 {
+			return nil, nil
+		}
+		batch := agg.batches[0]
+		agg.batches = agg.batches[1:]
+		if err := agg.refillHashTable(batch); err != nil {
+			return nil, err
+		}
+	}
+	agg.groupPos++
+	return agg.groups[agg.groupPos-1], nil
+}
+
+// Groups the rows next returns in a new hash table, as postgres'
+// agg_fill_hash_table.  Once the groups take more than work_mem, no more
+// are made, as postgres' hash_agg_enter_spill_mode: the rows of the groups
+// in the table still go to them, and the others are written out to the
+// partitions of their hash, each grouped in turn as a batch of its own.
+func (agg *Agg) fillHashTable(next func() (access.Tuple, error), level int) (err error) {
+	agg.table, agg.groups, agg.groupPos, agg.memUsed = map[uint32][]*aggGroup{}, nil, 0, 0
+	var spill *aggSpill
+	defer func() {
+		if err != nil && spill != nil {
+			spill.close()
+		}
+	}()
+	for {
+		tuple, err := next()
+		if err != nil {
+			return err
+		} else if tuple == nil {
+			break
+		}
+		hash := agg.hashGroup(tuple)
+		var group *aggGroup
+		for _, candidate := range agg.table[hash] {
+			if agg.sameGroup(candidate.tuple, tuple) {
+				group = candidate
+				break
+			}
+		}
+		if group == nil && spill != nil {
+			if err := spill.write(tuple, hash); err != nil {
+				return err
+			}
+			continue
+		} else if group == nil {
+			group = agg.newGroup(tuple)
+			agg.table[hash] = append(agg.table[hash], group)
+			agg.groups = append(agg.groups, group)
+			agg.memUsed += tupleSpace(group.tuple) + tupleSpace(VirtualTuple(group.states))
+			if agg.memUsed > int64(agg.executor.workMem)*1024 && level < maxSpillLevel {
+				spill = &aggSpill{level: level, desc: agg.inputDesc}
+			}
+		}
+		if err := agg.advanceAggregates(group, tuple); err != nil {
+			return err
+		}
+	}
+	if spill != nil {
+		batches, err := spill.finish()
+		if err != nil {
+			return err
+		}
+		agg.batches = append(agg.batches, batches...)
+	}
+	return nil
+}
+
+// Groups the rows of the batch in a new hash table.  The file of the batch
+// is removed once they are read.
+func (agg *Agg) refillHashTable(batch aggBatch) error {
+	defer batch.file.Close()
+	if _, err := batch.file.Seek(0, os.SEEK_SET); err != nil {
+		return system.Ereport(system.IoError, "could not seek in temporary file: %v", err)
+	}
+	reader := bufio.NewReaderSize(batch.file, mergeBufferSize)
+	return agg.fillHashTable(func() (access.Tuple, error) {
+		return readTempTuple(reader, agg.inputDesc)
+	}, batch.level)
+}
+
+// Starts the groups over, reading the rows of the subplan again.
+func (agg *Agg) ReScan() error {
+	agg.closeBatches()
+	agg.started, agg.done, agg.nextTuple = false, false, nil
+	agg.table, agg.groups, agg.groupPos = nil, nil, 0
+	return agg.subplan.ReScan()
+}
+
+func (agg *Agg) closeBatches() {
+	for _, batch := range agg.batches {
+		batch.file.Close()
+	}
+	agg.batches = nil
+}
+
+func (agg *Agg) End() {
+	agg.closeBatches()
+	if agg.subplan != nil {
+		agg.subplan.End()
+	}
+}
+
+func (agg *Agg) ResultDesc() *access.TupleDesc {
+	return agg.targetDesc
+}
+
+// aggSpill writes out the rows of the groups left out of a full hash
+// table, to the partitions of the bits of their hash the level tells, as
+// postgres' HashAggSpill.  The file of a partition is made for its first
+// row.
+type aggSpill struct {
+	level   int
+	desc    *access.TupleDesc
+	files   [hashSpillPartitions]*os.File
+	writers [hashSpillPartitions]*bufio.Writer
+}
+
+func (spill *aggSpill) write(tuple access.Tuple, hash uint32) error {
+	partition := hash >> uint(spill.level*hashSpillBits) % hashSpillPartitions
+	if spill.files[partition] == nil {
+		file, err := storage.OpenTemporaryFile()
+		if err != nil {
+			return err
+		}
+		spill.files[partition] = file
+		spill.writers[partition] = bufio.NewWriterSize(file, mergeBufferSize)
+	}
+	return writeTempTuple(spill.writers[partition], tuple, spill.desc)
+}
+
+// Returns the partitions written to as batches, as postgres'
+// hashagg_spill_finish.
+func (spill *aggSpill) finish() ([]aggBatch, error) {
+	var batches []aggBatch
+	for partition, file := range spill.files {
+		if file == nil {
+			continue
+		} else if err := spill.writers[partition].Flush(); err != nil {
+			spill.close()
+			return nil, system.Ereport(system.IoError, "could not write to temporary file: %v", err)
+		}
+		batches = append(batches, aggBatch{file: file, level: spill.level + 1})
+	}
+	return batches, nil
+}
+
+func (spill *aggSpill) close() {
+	for _, file := range spill.files {
+		if file != nil {
+			file.Close()
+		}
+	}
+}
@@ -0,0 +1,318 @@
+package executor
+
+import (
+	"bigpot/access"
+	"bigpot/parser"
+	"bigpot/system"
+)
+
+// ExprContext holds what an expression reads as it is evaluated, as
+// postgres' ExprContext: the tuples its Vars are fetched from, which are
+// the outer and the inner rows in a join, the values of the aggregates of
+// the group an Agg computes, by the AggNo of their Aggrefs, and the value
+// of the CASE it is in.  Params and SubPlans are read from the executor.
+type ExprContext struct {
+	ScanTuple  access.Tuple
+	OuterTuple access.Tuple
+	InnerTuple access.Tuple
+	AggValues  []system.Datum
+	caseValue  system.Datum
+	executor   *ExecutorImpl
+}
+
+// Makes a context for an expression computed apart from any plan, as
+// postgres' CreateStandaloneExprContext, in the transaction tx and the
+// time zone tz.  The expression cannot hold Vars, Params nor SubPlans.
+func CreateStandaloneExprContext(tx *access.Transaction, tz *system.TimeZone) *ExprContext {
+	return &ExprContext{executor: &ExecutorImpl{tx: tx, bufMgr: tx.BufMgr(), timeZone: tz}}
+}
+
+// exprFunc evaluates a compiled expression node.  NULL is a nil Datum.
+type exprFunc func(econtext *ExprContext) (system.Datum, error)
+
+// ExprState is an expression compiled for evaluation, as postgres'
+// ExprState.  The tree of the parser is walked once, by ExecInitExpr, into
+// a tree of closures, so that evaluation does not switch on the node types
+// for every row.
+type ExprState struct {
+	Expr     parser.Expr
+	evalfunc exprFunc
+}
+
+// Compiles the expression, as postgres' ExecInitExpr.
+func ExecInitExpr(expr parser.Expr) (*ExprState, error) {
+	evalfunc, err := compileExpr(expr)
+	if err != nil {
+		return nil, err
+	}
+	return &ExprState{Expr: expr, evalfunc: evalfunc}, nil
+}
+
+// Evaluates the expression, as postgres' ExecEvalExpr.
+func (state *ExprState) Eval(econtext *ExprContext) (system.Datum, error) {
+	return state.evalfunc(econtext)
+}
+
+// Compiles the expressions, as postgres' ExecInitExprList.
+func ExecInitExprList(exprs []parser.Expr) ([]*ExprState, error) {
+	states := make([]*ExprState, len(exprs))
+	for i, expr := range exprs {
+		var err error
+		if states[i], err = ExecInitExpr(expr); err != nil {
+			return nil, err
+		}
+	}
+	return states, nil
+}
+
+// Compiles the conditions of a qual, as postgres' ExecInitQual.
+func ExecInitQual(qual []parser.Expr) ([]*ExprState, error) {
+	return ExecInitExprList(qual)
+}
+
+// Returns true if all the conditions are true, as postgres' ExecQual.  A
+// NULL fails the qual, as false does.  An empty qual is true.
+func ExecQual(qual []*ExprState, econtext *ExprContext) (bool, error) {
+	for _, state := range qual {
+		value, err := state.Eval(econtext)
+		if err != nil {
+			return false, err
+		} else if value == nil || !bool(value.(system.Bool)) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func compileExprs(exprs []parser.Expr) ([]exprFunc, error) {
+	funcs := make([]exprFunc, len(exprs))
+	for i, expr := range exprs {
+		var err error
+		if funcs[i], err = compileExpr(expr); err != nil {
+			return nil, err
+		}
+	}
+	return funcs, nil
+}
+
+func compileExpr(expr parser.Expr) (exprFunc, error) {
+	switch n := expr.(type) {
+	case *parser.Var:
+		attnum := n.VarAttNo
+		switch n.VarNo {
+		case parser.OUTER_VAR:
+			return func(econtext *ExprContext) (system.Datum, error) {
+				return econtext.OuterTuple.Fetch(attnum), nil
+			}, nil
+		case parser.INNER_VAR:
+			return func(econtext *ExprContext) (system.Datum, error) {
+				return econtext.InnerTuple.Fetch(attnum), nil
+			}, nil
+		}
+		return func(econtext *ExprContext) (system.Datum, error) {
+			return econtext.ScanTuple.Fetch(attnum), nil
+		}, nil
+	case *parser.Const:
+		value := n.Value
+		return func(econtext *ExprContext) (system.Datum, error) {
+			return value, nil
+		}, nil
+	case *parser.OpExpr:
+		return compileFuncCall(n.Opr.Proc, n.Args)
+	case *parser.FuncExpr:
+		return compileFuncCall(n.Func, n.Args)
+	case *parser.BoolExpr:
+		return compileBoolExpr(n)
+	case *parser.NullTest:
+		return compileNullTest(n)
+	case *parser.CoerceViaIO:
+		return compileCoerceViaIO(n)
+	case *parser.CaseExpr:
+		return compileCaseExpr(n)
+	case *parser.CaseTestExpr:
+		return func(econtext *ExprContext) (system.Datum, error) {
+			return econtext.caseValue, nil
+		}, nil
+	case *parser.CoalesceExpr:
+		return compileCoalesceExpr(n)
+	case *parser.Aggref:
+		aggno := n.AggNo
+		return func(econtext *ExprContext) (system.Datum, error) {
+			return econtext.AggValues[aggno], nil
+		}, nil
+	case *parser.Param:
+		paramid := n.ParamId
+		return func(econtext *ExprContext) (system.Datum, error) {
+			return econtext.executor.params[paramid], nil
+		}, nil
+	case *parser.SubPlan:
+		return compileSubPlan(n)
+	}
+	return nil, system.Elog("unrecognized expression node type %T", expr)
+}
+
+// Calls the function on the values of the arguments, as postgres'
+// ExecEvalFunc, in the session's time zone.  A strict function returns
+// NULL on a NULL argument without being called.
+func compileFuncCall(proc *system.ProcInfo, argExprs []parser.Expr) (exprFunc, error) {
+	args, err := compileExprs(argExprs)
+	if err != nil {
+		return nil, err
+	}
+	values := make([]system.Datum, len(args))
+	return func(econtext *ExprContext) (system.Datum, error) {
+		for i, arg := range args {
+			value, err := arg(econtext)
+			if err != nil {
+				return nil, err
+			}
+			if value == nil && proc.Strict {
+				return nil, nil
+			}
+			values[i] = value
+		}
+		return proc.CallInZone(econtext.executor.timeZone, values...)
+	}, nil
+}
+
+// Evaluates AND, OR and NOT by the three-valued logic of SQL, as postgres'
+// ExecEvalAnd, ExecEvalOr and ExecEvalNot.  AND is false if any argument
+// is, and otherwise NULL if any argument is; OR likewise with true.  The
+// arguments are evaluated in order until the result is known.
+func compileBoolExpr(expr *parser.BoolExpr) (exprFunc, error) {
+	args, err := compileExprs(expr.Args)
+	if err != nil {
+		return nil, err
+	}
+	if expr.BoolOp == parser.NOT_EXPR {
+		arg := args[0]
+		return func(econtext *ExprContext) (system.Datum, error) {
+			value, err := arg(econtext)
+			if value == nil || err != nil {
+				return nil, err
+			}
+			return !value.(system.Bool), nil
+		}, nil
+	}
+	// the value that decides the result at once
+	decisive := system.Bool(expr.BoolOp == parser.OR_EXPR)
+	return func(econtext *ExprContext) (system.Datum, error) {
+		anyNull := false
+		for _, arg := range args {
+			value, err := arg(econtext)
+			if err != nil {
+				return nil, err
+			} else if value == nil {
+				anyNull = true
+			} else if value.(system.Bool) == decisive {
+				return decisive, nil
+			}
+		}
+		if anyNull {
+			return nil, nil
+		}
+		return !decisive, nil
+	}, nil
+}
+
+func compileNullTest(expr *parser.NullTest) (exprFunc, error) {
+	arg, err := compileExpr(expr.Arg)
+	if err != nil {
+		return nil, err
+	}
+	isNull := expr.NullTestType == parser.IS_NULL
+	return func(econtext *ExprContext) (system.Datum, error) {
+		value, err := arg(econtext)
+		if err != nil {
+			return nil, err
+		}
+		return system.Bool((value == nil) == isNull), nil
+	}, nil
+}
+
+// Converts the value by the output function of its type and the input
+// function of the result type, as postgres' ExecEvalCoerceViaIO, both in
+// the session's time zone.
+func compileCoerceViaIO(expr *parser.CoerceViaIO) (exprFunc, error) {
+	arg, err := compileExpr(expr.Arg)
+	if err != nil {
+		return nil, err
+	}
+	typid := expr.ResultType()
+	return func(econtext *ExprContext) (system.Datum, error) {
+		value, err := arg(econtext)
+		if value == nil || err != nil {
+			return nil, err
+		}
+		tz := econtext.executor.timeZone
+		return system.DatumFromStringInZone(system.DatumToStringInZone(value, tz), typid, tz)
+	}, nil
+}
+
+// Returns the result of the first WHEN that is true, or the default, as
+// postgres' ExecEvalCase.  The value of the CASE argument is kept in the
+// context for the CaseTestExprs, and put back after, as CASEs may nest.
+func compileCaseExpr(expr *parser.CaseExpr) (exprFunc, error) {
+	var caseArg exprFunc
+	if expr.Arg != nil {
+		var err error
+		if caseArg, err = compileExpr(expr.Arg); err != nil {
+			return nil, err
+		}
+	}
+	type caseWhen struct {
+		cond, result exprFunc
+	}
+	whens := make([]caseWhen, len(expr.Args))
+	for i, when := range expr.Args {
+		var err error
+		if whens[i].cond, err = compileExpr(when.Expr); err != nil {
+			return nil, err
+		}
+		if whens[i].result, err = compileExpr(when.Result); err != nil {
+			return nil, err
+		}
+	}
+	defResult, err := compileExpr(expr.DefResult)
+	if err != nil {
+		return nil, err
+	}
+	return func(econtext *ExprContext) (system.Datum, error) {
+		if caseArg != nil {
+			value, err := caseArg(econtext)
+			if err != nil {
+				return nil, err
+			}
+			saved := econtext.caseValue
+			econtext.caseValue = value
+			defer func() { econtext.caseValue = saved }()
+		}
+		for _, when := range whens {
+			cond, err := when.cond(econtext)
+			if err != nil {
+				return nil, err
+			} else if cond != nil && bool(cond.(system.Bool)) {
+				return when.result(econtext)
+			}
+		}
+		return defResult(econtext)
+	}, nil
+}
+
+// Returns the first argument that is not NULL, as postgres'
+// ExecEvalCoalesce.  The arguments after it are not evaluated.
+func compileCoalesceExpr(expr *parser.CoalesceExpr) (exprFunc, error) {
+	args, err := compileExprs(expr.Args)
+	if err != nil {
+		return nil, err
+	}
+	return func(econtext *ExprContext) (system.Datum, error) {
+		for _, arg := range args {
+			value, err := arg(econtext)
+			if value != nil || err != nil {
+				return value, err
+			}
+		}
+		return nil, nil
+	}, nil
+}
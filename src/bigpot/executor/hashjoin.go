@@ -0,0 +1,196 @@
+package executor
+
+import (
+	"bigpot/access"
+	"bigpot/parser"
+	"bigpot/planner"
+	"bigpot/system"
+)
+
+// hashEntry is an inner row of a hash join, and whether it has matched an
+// outer row.
+type hashEntry struct {
+	tuple   access.Tuple
+	matched bool
+}
+
+// HashJoin joins the rows of its outer and inner plans by a hash table of
+// the inner rows, as postgres' HashJoinState.
+type HashJoin struct {
+	planner.HashJoin
+	joinState
+	hashClauses []*ExprState
+	outerKeys   []*ExprState
+	innerKeys   []*ExprState
+	hashFuncs   []system.HashFunc
+	// the inner rows by the hash of their keys, and all of them in order,
+	// as a FULL join returns those that match no outer row at the end
+	table   map[uint32][]*hashEntry
+	entries []*hashEntry
+	// the entries the outer row may match, and the next one to try
+	bucket       []*hashEntry
+	bucketPos    int
+	needOuter    bool
+	matchedOuter bool
+	fillInner    bool
+	done         bool
+}
+
+func (hj *HashJoin) Init() error {
+	if err := hj.initJoin(&hj.Join); err != nil {
+		return err
+	}
+	var err error
+	if hj.hashClauses, err = ExecInitQual(hj.HashClauses); err != nil {
+		return err
+	}
+	for _, clause := range hj.HashClauses {
+		op := clause.(*parser.OpExpr)
+		outerKey, err := ExecInitExpr(op.Args[0])
+		if err != nil {
+			return err
+		}
+		innerKey, err := ExecInitExpr(op.Args[1])
+		if err != nil {
+			return err
+		}
+		hashFunc, err := system.LookupHash(op.Opr.Left)
+		if err != nil {
+			return err
+		}
+		hj.outerKeys = append(hj.outerKeys, outerKey)
+		hj.innerKeys = append(hj.innerKeys, innerKey)
+		hj.hashFuncs = append(hj.hashFuncs, hashFunc)
+	}
+	hj.needOuter = true
+	return nil
+}
+
+// Returns the hash of the keys computed from the rows of the context, as
+// postgres' ExecHashGetHashValue, or false if one is NULL, which no key
+// equals.
+func (hj *HashJoin) hashKeys(keys []*ExprState) (uint32, bool, error) {
+	var hash uint32
+	for i, key := range keys {
+		value, err := key.Eval(hj.econtext)
+		if err != nil || value == nil {
+			return 0, false, err
+		}
+		hash = (hash<<1 | hash>>31) ^ hj.hashFuncs[i](value)
+	}
+	return hash, true, nil
+}
+
+// Builds the hash table of the inner rows, as postgres' MultiExecHash.
+func (hj *HashJoin) buildHashTable() error {
+	hj.table = map[uint32][]*hashEntry{}
+	for {
+		inner, err := hj.innerPlan.Exec()
+		if err != nil {
+			return err
+		} else if inner == nil {
+			return nil
+		}
+		entry := &hashEntry{tuple: inner}
+		hj.entries = append(hj.entries, entry)
+		hj.econtext.InnerTuple = inner
+		hash, ok, err := hj.hashKeys(hj.innerKeys)
+		if err != nil {
+			return err
+		} else if ok {
+			hj.table[hash] = append(hj.table[hash], entry)
+		}
+	}
+}
+
+// Returns the next joined row, as postgres' ExecHashJoin.  The inner rows
+// are all read into the hash table first; each outer row is then joined
+// with those of its bucket that its keys equal.
+func (hj *HashJoin) Exec() (access.Tuple, error) {
+	if hj.table == nil {
+		if err := hj.buildHashTable(); err != nil {
+			return nil, err
+		}
+	}
+	for !hj.done {
+		if hj.fillInner {
+			if hj.bucketPos == len(hj.entries) {
+				hj.done = true
+				continue
+			}
+			entry := hj.entries[hj.bucketPos]
+			hj.bucketPos++
+			if !entry.matched {
+				if tuple, err := hj.projectNullOuter(entry.tuple); err != nil || tuple != nil {
+					return tuple, err
+				}
+			}
+			continue
+		}
+		if hj.needOuter {
+			outer, err := hj.outerPlan.Exec()
+			if err != nil {
+				return nil, err
+			} else if outer == nil {
+				hj.fillInner = hj.JoinType == parser.JOIN_FULL
+				hj.done = !hj.fillInner
+				hj.bucketPos = 0
+				continue
+			}
+			hj.econtext.OuterTuple = outer
+			hash, ok, err := hj.hashKeys(hj.outerKeys)
+			if err != nil {
+				return nil, err
+			}
+			hj.bucket, hj.bucketPos = nil, 0
+			if ok {
+				hj.bucket = hj.table[hash]
+			}
+			hj.needOuter, hj.matchedOuter = false, false
+		}
+		if hj.bucketPos == len(hj.bucket) {
+			hj.needOuter = true
+			if !hj.matchedOuter && hj.JoinType != parser.JOIN_INNER {
+				if tuple, err := hj.projectNullInner(); err != nil || tuple != nil {
+					return tuple, err
+				}
+			}
+			continue
+		}
+		entry := hj.bucket[hj.bucketPos]
+		hj.bucketPos++
+		hj.econtext.InnerTuple = entry.tuple
+		if ok, err := ExecQual(hj.hashClauses, hj.econtext); err != nil {
+			return nil, err
+		} else if !ok {
+			continue
+		}
+		if ok, err := ExecQual(hj.joinQual, hj.econtext); err != nil {
+			return nil, err
+		} else if !ok {
+			continue
+		}
+		hj.matchedOuter, entry.matched = true, true
+		if tuple, err := hj.projectJoin(); err != nil || tuple != nil {
+			return tuple, err
+		}
+	}
+	return nil, nil
+}
+
+// Starts the outer rows over, keeping the hash table of the inner, unless
+// the plan reads Params, whose new values the inner rows may differ by.
+func (hj *HashJoin) ReScan() error {
+	if len(hj.AllParam) > 0 && hj.table != nil {
+		hj.table, hj.entries = nil, nil
+		if err := hj.innerPlan.ReScan(); err != nil {
+			return err
+		}
+	}
+	for _, entry := range hj.entries {
+		entry.matched = false
+	}
+	hj.bucket, hj.bucketPos = nil, 0
+	hj.needOuter, hj.fillInner, hj.done = true, false, false
+	return hj.outerPlan.ReScan()
+}
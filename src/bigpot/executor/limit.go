@@ -0,0 +1,115 @@
+package executor
+
+import (
+	"bigpot/access"
+	"bigpot/parser"
+	"bigpot/planner"
+	"bigpot/system"
+)
+
+// Limit returns some of the rows of its subplan, as postgres' LimitState.
+type Limit struct {
+	planner.Limit
+	executor *ExecutorImpl
+	subplan  Node
+	// the rows to pass over and to return, noCount if there is no LIMIT,
+	// computed once the first row is asked for
+	offset  int
+	count   int
+	noCount bool
+	started bool
+	// the rows of the subplan read so far
+	position int
+}
+
+func (l *Limit) Init() error {
+	var err error
+	l.subplan, err = l.executor.initExecNode(l.LeftTree)
+	return err
+}
+
+// Computes the OFFSET and the LIMIT, as postgres' recompute_limits.  A
+// NULL OFFSET is none, and a NULL LIMIT no limit.  A Sort below needs to
+// keep the rows returned only.
+func (l *Limit) recomputeLimits() error {
+	econtext := l.executor.createExprContext()
+	l.offset, l.count, l.noCount = 0, 0, true
+	if l.LimitOffset != nil {
+		value, err := evalLimit(l.LimitOffset, econtext)
+		if err != nil {
+			return err
+		} else if value != nil {
+			if l.offset = int(value.(system.Int4)); l.offset < 0 {
+				return system.Ereport(system.InvalidRowCountInResultOffsetClause,
+					"OFFSET must not be negative")
+			}
+		}
+	}
+	if l.LimitCount != nil {
+		value, err := evalLimit(l.LimitCount, econtext)
+		if err != nil {
+			return err
+		} else if value != nil {
+			if l.count = int(value.(system.Int4)); l.count < 0 {
+				return system.Ereport(system.InvalidRowCountInLimitClause,
+					"LIMIT must not be negative")
+			}
+			l.noCount = false
+		}
+	}
+	if sort, ok := l.subplan.(*Sort); ok && !l.noCount {
+		sort.bound = l.offset + l.count
+	}
+	return nil
+}
+
+func evalLimit(expr parser.Expr, econtext *ExprContext) (system.Datum, error) {
+	state, err := ExecInitExpr(expr)
+	if err != nil {
+		return nil, err
+	}
+	return state.Eval(econtext)
+}
+
+// Returns the next row of the subplan past the OFFSET, as postgres'
+// ExecLimit, or nil once LIMIT rows are returned.
+func (l *Limit) Exec() (access.Tuple, error) {
+	if !l.started {
+		if err := l.recomputeLimits(); err != nil {
+			return nil, err
+		}
+		l.started = true
+	}
+	for l.position < l.offset {
+		tuple, err := l.subplan.Exec()
+		if err != nil || tuple == nil {
+			return nil, err
+		}
+		l.position++
+	}
+	if !l.noCount && l.position >= l.offset+l.count {
+		return nil, nil
+	}
+	tuple, err := l.subplan.Exec()
+	if err != nil || tuple == nil {
+		return nil, err
+	}
+	l.position++
+	return tuple, nil
+}
+
+// Starts the rows over, computing the OFFSET and the LIMIT again.
+func (l *Limit) ReScan() error {
+	l.started, l.position = false, 0
+	return l.subplan.ReScan()
+}
+
+func (l *Limit) End() {
+	if l.subplan != nil {
+		l.subplan.End()
+	}
+}
+
+func (l *Limit) ResultDesc() *access.TupleDesc {
+	return l.subplan.ResultDesc()
+}
@@ -1,60 +1,142 @@
 package executor
 
-import "fmt"
 import "bigpot/access"
+import "bigpot/parser"
 import "bigpot/planner"
+import "bigpot/storage"
+import "bigpot/system"
 
 type Executor interface {
-	Start()
-	Execute()
+	Start() error
+	Execute(dest Receiver) error
 	End()
 }
 
+// Receiver takes the rows a query returns, as postgres' DestReceiver.
+type Receiver interface {
+	Receive(tuple access.Tuple) error
+}
+
 type ExecutorImpl struct {
 	planRoot  *planner.PlanRoot
 	TupleDesc *access.TupleDesc
+	// the number of rows an INSERT, UPDATE or DELETE changed, as
+	// postgres' es_processed
+	Processed int
 	execRoot  Node
-	scanTuple access.Tuple
+	// the values of the Params of PARAM_EXEC, as postgres'
+	// es_param_exec_vals, and the states of the plans of the SubPlans
+	params   []system.Datum
+	subplans []*subPlanState
+	tx       *access.Transaction
+	relcache *access.RelCache
+	bufMgr   storage.BufferManager
+	// the session's time zone, as postgres' session_timezone
+	timeZone *system.TimeZone
+	// the memory, in kilobytes, a sort or a hash table may take, as
+	// postgres' work_mem
+	workMem int
 }
 
-func NewExecutor(planRoot *planner.PlanRoot) *ExecutorImpl {
+// Makes an executor of the plan, running in the transaction and opening
+// relations through the session's relcache.  Functions of timestamptz are
+// computed in the time zone tz, and sorts and hash tables take up to
+// workMem kilobytes before they write their rows out.
+func NewExecutor(planRoot *planner.PlanRoot, tx *access.Transaction,
+	relcache *access.RelCache, tz *system.TimeZone, workMem int) *ExecutorImpl {
 	return &ExecutorImpl{
 		planRoot: planRoot,
+		tx:       tx,
+		relcache: relcache,
+		bufMgr:   tx.BufMgr(),
+		timeZone: tz,
+		workMem:  workMem,
 	}
 }
 
-func (exec *ExecutorImpl) initExecNode(node planner.Node) Node {
-	switch node.(type) {
+// Makes the state node of the plan node and initializes it, as postgres'
+// ExecInitNode.  The nodes below it are initialized by its Init.
+func (exec *ExecutorImpl) initExecNode(node planner.Node) (Node, error) {
+	var state Node
+	switch node := node.(type) {
 	case *planner.SeqScan:
-		scan := &SeqScan{}
-		scan.SeqScan = *(node.(*planner.SeqScan))
-		scan.executor = exec
-		scan.Init()
-
-		return Node(scan)
+		state = &SeqScan{SeqScan: *node, executor: exec}
+	case *planner.ValuesScan:
+		state = &ValuesScan{ValuesScan: *node, executor: exec}
+	case *planner.SubqueryScan:
+		state = &SubqueryScan{SubqueryScan: *node, executor: exec}
+	case *planner.NestLoop:
+		state = &NestLoop{NestLoop: *node, joinState: joinState{executor: exec}}
+	case *planner.HashJoin:
+		state = &HashJoin{HashJoin: *node, joinState: joinState{executor: exec}}
+	case *planner.MergeJoin:
+		state = &MergeJoin{MergeJoin: *node, joinState: joinState{executor: exec}}
+	case *planner.Sort:
+		state = &Sort{Sort: *node, executor: exec}
+	case *planner.Limit:
+		state = &Limit{Limit: *node, executor: exec}
+	case *planner.Agg:
+		state = &Agg{Agg: *node, executor: exec}
+	case *planner.ModifyTable:
+		state = &ModifyTable{ModifyTable: *node, executor: exec}
+	default:
+		panic("unknown node type")
 	}
-	panic("unknown node type")
+	if err := state.Init(); err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
+// Makes a context for the expressions of a node, as postgres'
+// CreateExprContext.
+func (exec *ExecutorImpl) createExprContext() *ExprContext {
+	return &ExprContext{executor: exec}
 }
 
-func (exec *ExecutorImpl) Start() {
-	exec.execRoot = exec.initExecNode(exec.planRoot.Plan)
+// Initializes the plans of the SubPlans, and then the plan of the query,
+// as postgres' InitPlan.
+func (exec *ExecutorImpl) Start() error {
+	exec.params = make([]system.Datum, exec.planRoot.NParams)
+	for _, plan := range exec.planRoot.SubPlans {
+		state, err := exec.initExecNode(plan)
+		if err != nil {
+			return err
+		}
+		exec.subplans = append(exec.subplans, &subPlanState{planstate: state})
+	}
+	var err error
+	if exec.execRoot, err = exec.initExecNode(exec.planRoot.Plan); err != nil {
+		return err
+	}
+	exec.TupleDesc = exec.execRoot.ResultDesc()
+	if exec.planRoot.CommandType == parser.CMD_SELECT {
+		// the junk columns come last, and are not returned
+		exec.TupleDesc = ExecCleanTypeFromTL(exec.planRoot.TargetList)
+	}
+	return nil
 }
 
-func (exec *ExecutorImpl) Execute() {
+// Sends each row of the plan to dest.
+func (exec *ExecutorImpl) Execute(dest Receiver) error {
 	for {
-		tuple := exec.execRoot.Exec()
-		if tuple == nil {
-			break
+		tuple, err := exec.execRoot.Exec()
+		if err != nil {
+			return err
+		} else if tuple == nil {
+			return nil
 		}
-		/* TODO: Receiver */
-		for attnum, _ := range exec.TupleDesc.Attrs {
-			datum := tuple.Get(int32(attnum + 1))
-			fmt.Printf("%s ", datum.ToString())
+		if err := dest.Receive(tuple); err != nil {
+			return err
 		}
-		fmt.Println("")
 	}
 }
 
 func (exec *ExecutorImpl) End() {
-	exec.execRoot.End()
+	if exec.execRoot != nil {
+		exec.execRoot.End()
+	}
+	for _, subplan := range exec.subplans {
+		subplan.planstate.End()
+	}
 }
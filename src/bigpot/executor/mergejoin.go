@@ -0,0 +1,210 @@
+package executor
+
+import (
+	"bigpot/access"
+	"bigpot/parser"
+	"bigpot/planner"
+	"bigpot/system"
+)
+
+// MergeJoin joins the rows of its outer and inner plans, both in the
+// order of the merge keys, as postgres' MergeJoinState.
+type MergeJoin struct {
+	planner.MergeJoin
+	joinState
+	outerKeys []system.AttrNumber
+	innerKeys []system.AttrNumber
+	// the comparison functions of the outer keys with the inner, and of
+	// the inner keys with each other
+	compares      []system.CompareFunc
+	innerCompares []system.CompareFunc
+	// the inner rows of equal keys the outer rows are compared with,
+	// whether each has matched an outer row, and the inner row after them
+	group        []access.Tuple
+	groupMatched []bool
+	nextInner    access.Tuple
+	// the outer row matches the group, and the next row of it to try
+	matching     bool
+	groupPos     int
+	needOuter    bool
+	matchedOuter bool
+	// the joined rows of inner rows of a FULL join that match no outer
+	// row, to be returned
+	pending []access.Tuple
+	started bool
+	done    bool
+}
+
+func (mj *MergeJoin) Init() error {
+	if err := mj.initJoin(&mj.Join); err != nil {
+		return err
+	}
+	for _, clause := range mj.MergeClauses {
+		op := clause.(*parser.OpExpr)
+		compare, err := system.LookupCompare(op.Opr.Left, op.Opr.Right)
+		if err != nil {
+			return err
+		}
+		innerCompare, err := system.LookupCompare(op.Opr.Right, op.Opr.Right)
+		if err != nil {
+			return err
+		}
+		mj.outerKeys = append(mj.outerKeys, op.Args[0].(*parser.Var).VarAttNo)
+		mj.innerKeys = append(mj.innerKeys, op.Args[1].(*parser.Var).VarAttNo)
+		mj.compares = append(mj.compares, compare)
+		mj.innerCompares = append(mj.innerCompares, innerCompare)
+	}
+	mj.needOuter = true
+	return nil
+}
+
+// Returns true if a key of the row is NULL, which no key equals.
+func keysNull(tuple access.Tuple, keys []system.AttrNumber) bool {
+	for _, attno := range keys {
+		if tuple.Fetch(attno) == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// Compares the keys of the outer row with those of the inner row, as
+// postgres' MJCompare.  The inner row has no NULL key.
+func (mj *MergeJoin) compareOuterInner(outer, inner access.Tuple) int {
+	for i, compare := range mj.compares {
+		if c := compare(outer.Fetch(mj.outerKeys[i]), inner.Fetch(mj.innerKeys[i])); c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+// Returns true if the inner rows have equal keys, none NULL.
+func (mj *MergeJoin) innerEqual(a, b access.Tuple) bool {
+	if keysNull(a, mj.innerKeys) || keysNull(b, mj.innerKeys) {
+		return false
+	}
+	for i, compare := range mj.innerCompares {
+		if compare(a.Fetch(mj.innerKeys[i]), b.Fetch(mj.innerKeys[i])) != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Moves on to the next group of inner rows of equal keys, keeping the
+// rows of a FULL join the group is done with that matched no outer row.
+// The group is empty once the inner rows are done.
+func (mj *MergeJoin) advanceGroup() error {
+	if mj.JoinType == parser.JOIN_FULL {
+		for i, inner := range mj.group {
+			if mj.groupMatched[i] {
+				continue
+			}
+			tuple, err := mj.projectNullOuter(inner)
+			if err != nil {
+				return err
+			} else if tuple != nil {
+				mj.pending = append(mj.pending, tuple)
+			}
+		}
+	}
+	mj.group, mj.groupMatched = nil, nil
+	for mj.nextInner != nil {
+		if len(mj.group) > 0 && !mj.innerEqual(mj.group[0], mj.nextInner) {
+			break
+		}
+		mj.group = append(mj.group, mj.nextInner)
+		mj.groupMatched = append(mj.groupMatched, false)
+		var err error
+		if mj.nextInner, err = mj.innerPlan.Exec(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Returns the next joined row, as postgres' ExecMergeJoin.  For each outer
+// row, the groups of inner rows of lesser keys are passed over; the outer
+// row is joined with the group of equal keys, if it is the next, which is
+// kept for the outer rows after of the same keys.  As NULLs sort last,
+// the inner rows of NULL keys come last, and match no outer row.
+func (mj *MergeJoin) Exec() (access.Tuple, error) {
+	if !mj.started {
+		var err error
+		if mj.nextInner, err = mj.innerPlan.Exec(); err != nil {
+			return nil, err
+		}
+		if err := mj.advanceGroup(); err != nil {
+			return nil, err
+		}
+		mj.started = true
+	}
+	for {
+		if len(mj.pending) > 0 {
+			tuple := mj.pending[0]
+			mj.pending = mj.pending[1:]
+			return tuple, nil
+		} else if mj.done {
+			return nil, nil
+		}
+		if mj.needOuter {
+			outer, err := mj.outerPlan.Exec()
+			if err != nil {
+				return nil, err
+			} else if outer == nil {
+				for mj.JoinType == parser.JOIN_FULL && len(mj.group) > 0 {
+					if err := mj.advanceGroup(); err != nil {
+						return nil, err
+					}
+				}
+				mj.done = true
+				continue
+			}
+			mj.matching = false
+			if !keysNull(outer, mj.outerKeys) {
+				for len(mj.group) > 0 && !keysNull(mj.group[0], mj.innerKeys) &&
+					mj.compareOuterInner(outer, mj.group[0]) > 0 {
+					if err := mj.advanceGroup(); err != nil {
+						return nil, err
+					}
+				}
+				mj.matching = len(mj.group) > 0 && !keysNull(mj.group[0], mj.innerKeys) &&
+					mj.compareOuterInner(outer, mj.group[0]) == 0
+			}
+			mj.econtext.OuterTuple = outer
+			mj.groupPos = 0
+			mj.needOuter, mj.matchedOuter = false, false
+		}
+		if !mj.matching || mj.groupPos == len(mj.group) {
+			mj.needOuter = true
+			if !mj.matchedOuter && mj.JoinType != parser.JOIN_INNER {
+				if tuple, err := mj.projectNullInner(); err != nil || tuple != nil {
+					return tuple, err
+				}
+			}
+			continue
+		}
+		pos := mj.groupPos
+		mj.groupPos++
+		mj.econtext.InnerTuple = mj.group[pos]
+		if ok, err := ExecQual(mj.joinQual, mj.econtext); err != nil {
+			return nil, err
+		} else if !ok {
+			continue
+		}
+		mj.matchedOuter, mj.groupMatched[pos] = true, true
+		if tuple, err := mj.projectJoin(); err != nil || tuple != nil {
+			return tuple, err
+		}
+	}
+}
+
+func (mj *MergeJoin) ReScan() error {
+	mj.group, mj.groupMatched, mj.nextInner, mj.pending = nil, nil, nil, nil
+	mj.needOuter, mj.started, mj.done = true, false, false
+	if err := mj.outerPlan.ReScan(); err != nil {
+		return err
+	}
+	return mj.innerPlan.ReScan()
+}
@@ -0,0 +1,165 @@
+package executor
+
+import (
+	"bigpot/access"
+	"bigpot/parser"
+	"bigpot/planner"
+	"bigpot/system"
+)
+
+// ModifyTable changes the result relation by the rows of its subplan, as
+// postgres' ModifyTableState.
+type ModifyTable struct {
+	planner.ModifyTable
+	executor   *ExecutorImpl
+	subplan    Node
+	relation   *access.HeapRelation
+	returning  []*ExprState
+	resultDesc *access.TupleDesc
+	econtext   *ExprContext
+}
+
+func (mt *ModifyTable) Init() error {
+	var err error
+	if mt.returning, err = ExecInitTargetList(mt.ReturningList); err != nil {
+		return err
+	}
+	mt.econtext = mt.executor.createExprContext()
+	mt.resultDesc = ExecTypeFromTL(mt.ReturningList)
+	if mt.relation, err = mt.executor.relcache.HeapOpen(mt.ResultRelation.RelId); err != nil {
+		return err
+	}
+	mt.subplan, err = mt.executor.initExecNode(mt.LeftTree)
+	return err
+}
+
+// Changes the result relation by the rows of the subplan, as postgres'
+// ExecModifyTable.  With a RETURNING list, it returns after each row
+// changed with the list computed from the stored tuple, or the deleted
+// one; otherwise it changes them all at once and returns nothing.
+func (mt *ModifyTable) Exec() (access.Tuple, error) {
+	for {
+		tuple, err := mt.subplan.Exec()
+		if err != nil || tuple == nil {
+			return nil, err
+		}
+		var changed *access.HeapTuple
+		switch mt.Operation {
+		case parser.CMD_INSERT:
+			changed, err = mt.execInsert(tuple)
+		case parser.CMD_UPDATE:
+			changed, err = mt.execUpdate(tuple)
+		case parser.CMD_DELETE:
+			changed, err = mt.execDelete(tuple)
+		}
+		if err != nil {
+			return nil, err
+		} else if changed == nil {
+			continue
+		}
+		mt.executor.Processed++
+		if len(mt.returning) > 0 {
+			mt.econtext.ScanTuple = changed
+			return ExecProject(mt.returning, mt.econtext)
+		}
+	}
+}
+
+// Inserts the row into the result relation, as postgres' ExecInsert, and
+// returns the tuple stored.
+func (mt *ModifyTable) execInsert(row access.Tuple) (*access.HeapTuple, error) {
+	tupdesc := mt.relation.RelDesc
+	values := make([]system.Datum, len(tupdesc.Attrs))
+	for i := range values {
+		values[i] = row.Fetch(system.AttrNumber(i + 1))
+	}
+	if err := execConstraints(mt.relation, values); err != nil {
+		return nil, err
+	}
+	tuple := access.FormHeapTuple(values, tupdesc)
+	// TODO: the index entries, once tables other than the catalogs have
+	// indexes
+	if err := mt.executor.tx.HeapInsert(mt.relation, tuple); err != nil {
+		return nil, err
+	}
+	return tuple, nil
+}
+
+// Replaces the row of the ctid in the last column of the row with the
+// columns before it, as postgres' ExecUpdate, and returns the tuple
+// stored.  A row the statement has changed already, as when it joins
+// with several rows of the FROM, is left alone, and nil returned.
+func (mt *ModifyTable) execUpdate(row access.Tuple) (*access.HeapTuple, error) {
+	tupdesc := mt.relation.RelDesc
+	tid := row.Fetch(system.AttrNumber(len(tupdesc.Attrs) + 1)).(system.ItemPointer)
+	if old, err := mt.fetchRow(tid); err != nil || old == nil {
+		return nil, err
+	}
+	values := make([]system.Datum, len(tupdesc.Attrs))
+	for i := range values {
+		values[i] = row.Fetch(system.AttrNumber(i + 1))
+	}
+	if err := execConstraints(mt.relation, values); err != nil {
+		return nil, err
+	}
+	tuple := access.FormHeapTuple(values, tupdesc)
+	if err := mt.executor.tx.HeapUpdate(mt.relation, tid, tuple); err != nil {
+		return nil, err
+	}
+	return tuple, nil
+}
+
+// Deletes the row of the ctid in the only column of the row, as
+// postgres' ExecDelete, and returns the deleted tuple.  A row the
+// statement has deleted already is left alone, and nil returned.
+func (mt *ModifyTable) execDelete(row access.Tuple) (*access.HeapTuple, error) {
+	tid := row.Fetch(1).(system.ItemPointer)
+	old, err := mt.fetchRow(tid)
+	if err != nil || old == nil {
+		return nil, err
+	}
+	if err := mt.executor.tx.HeapDelete(mt.relation, tid); err != nil {
+		return nil, err
+	}
+	return old, nil
+}
+
+// Returns a copy of the tuple of the result relation at tid, or nil if it
+// is deleted.
+func (mt *ModifyTable) fetchRow(tid system.ItemPointer) (*access.HeapTuple, error) {
+	tuple, buf, err := mt.relation.Fetch(tid, mt.executor.bufMgr)
+	if err != nil || tuple == nil {
+		return nil, err
+	}
+	defer mt.executor.bufMgr.ReleaseBuffer(buf)
+	return tuple.Copy(), nil
+}
+
+func (mt *ModifyTable) ReScan() error {
+	return system.Elog("ModifyTable cannot be scanned again")
+}
+
+// Checks the NOT NULL constraints of the relation on the row, as
+// postgres' ExecConstraints.
+func execConstraints(rel *access.HeapRelation, values []system.Datum) error {
+	for i, attr := range rel.RelDesc.Attrs {
+		if attr.NotNull && !attr.IsDropped && values[i] == nil {
+			return system.Ereport(system.NotNullViolation,
+				"null value in column \"%s\" violates not-null constraint", attr.Name)
+		}
+	}
+	return nil
+}
+
+func (mt *ModifyTable) End() {
+	if mt.subplan != nil {
+		mt.subplan.End()
+	}
+	if mt.relation != nil {
+		mt.relation.Close()
+	}
+}
+
+func (mt *ModifyTable) ResultDesc() *access.TupleDesc {
+	return mt.resultDesc
+}
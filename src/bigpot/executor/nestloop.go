@@ -0,0 +1,188 @@
+package executor
+
+import (
+	"bigpot/access"
+	"bigpot/parser"
+	"bigpot/planner"
+)
+
+// joinState is what the join nodes share, as postgres' JoinState.
+type joinState struct {
+	executor   *ExecutorImpl
+	outerPlan  Node
+	innerPlan  Node
+	joinQual   []*ExprState
+	qual       []*ExprState
+	targetList []*ExprState
+	targetDesc *access.TupleDesc
+	econtext   *ExprContext
+	// the rows of NULLs an outer join pairs the rows that match none with
+	nullOuter access.Tuple
+	nullInner access.Tuple
+}
+
+func (js *joinState) initJoin(join *planner.Join) error {
+	var err error
+	if js.targetList, err = ExecInitTargetList(join.TargetList); err != nil {
+		return err
+	}
+	if js.joinQual, err = ExecInitQual(join.JoinQual); err != nil {
+		return err
+	}
+	if js.qual, err = ExecInitQual(join.Qual); err != nil {
+		return err
+	}
+	js.econtext = js.executor.createExprContext()
+	js.targetDesc = ExecTypeFromTL(join.TargetList)
+	if js.outerPlan, err = js.executor.initExecNode(join.LeftTree); err != nil {
+		return err
+	}
+	if js.innerPlan, err = js.executor.initExecNode(join.RightTree); err != nil {
+		return err
+	}
+	js.nullOuter = make(VirtualTuple, len(js.outerPlan.ResultDesc().Attrs))
+	js.nullInner = make(VirtualTuple, len(js.innerPlan.ResultDesc().Attrs))
+	return nil
+}
+
+// Returns the target list computed from the outer and inner rows of the
+// context if they satisfy the qual, or nil.
+func (js *joinState) projectJoin() (access.Tuple, error) {
+	if ok, err := ExecQual(js.qual, js.econtext); err != nil || !ok {
+		return nil, err
+	}
+	return ExecProject(js.targetList, js.econtext)
+}
+
+// Returns the target list computed from the outer row of the context and
+// NULLs for the inner, as an outer join returns an outer row that matches
+// no inner row, or nil if it does not satisfy the qual.
+func (js *joinState) projectNullInner() (access.Tuple, error) {
+	js.econtext.InnerTuple = js.nullInner
+	return js.projectJoin()
+}
+
+// Returns the target list computed from the inner row and NULLs for the
+// outer, as a FULL join returns an inner row that matches no outer row,
+// or nil if it does not satisfy the qual.
+func (js *joinState) projectNullOuter(inner access.Tuple) (access.Tuple, error) {
+	js.econtext.OuterTuple = js.nullOuter
+	js.econtext.InnerTuple = inner
+	return js.projectJoin()
+}
+
+func (js *joinState) End() {
+	if js.outerPlan != nil {
+		js.outerPlan.End()
+	}
+	if js.innerPlan != nil {
+		js.innerPlan.End()
+	}
+}
+
+func (js *joinState) ResultDesc() *access.TupleDesc {
+	return js.targetDesc
+}
+
+// NestLoop joins the rows of its outer and inner plans, as postgres'
+// NestLoopState.
+type NestLoop struct {
+	planner.NestLoop
+	joinState
+	// true when the inner plan is done with the outer row, and whether
+	// the outer row has matched an inner row
+	needOuter    bool
+	matchedOuter bool
+	// the inner rows of a FULL join that have matched an outer row, by
+	// their order in the inner plan, which is scanned once more for those
+	// that have not once the outer rows are done
+	innerMatched []bool
+	innerPos     int
+	fillInner    bool
+	done         bool
+}
+
+func (nl *NestLoop) Init() error {
+	nl.needOuter = true
+	return nl.initJoin(&nl.Join)
+}
+
+// Returns the next joined row, as postgres' ExecNestLoop.  The inner plan
+// is scanned over for each outer row.
+func (nl *NestLoop) Exec() (access.Tuple, error) {
+	for !nl.done {
+		if nl.fillInner {
+			inner, err := nl.nextInner()
+			if err != nil {
+				return nil, err
+			} else if inner == nil {
+				nl.done = true
+			} else if !nl.innerMatched[nl.innerPos-1] {
+				if tuple, err := nl.projectNullOuter(inner); err != nil || tuple != nil {
+					return tuple, err
+				}
+			}
+			continue
+		}
+		if nl.needOuter {
+			outer, err := nl.outerPlan.Exec()
+			if err != nil {
+				return nil, err
+			}
+			if err := nl.innerPlan.ReScan(); err != nil {
+				return nil, err
+			}
+			nl.innerPos = 0
+			if outer == nil {
+				nl.fillInner = nl.JoinType == parser.JOIN_FULL
+				nl.done = !nl.fillInner
+				continue
+			}
+			nl.econtext.OuterTuple = outer
+			nl.needOuter, nl.matchedOuter = false, false
+		}
+		inner, err := nl.nextInner()
+		if err != nil {
+			return nil, err
+		} else if inner == nil {
+			nl.needOuter = true
+			if !nl.matchedOuter && nl.JoinType != parser.JOIN_INNER {
+				if tuple, err := nl.projectNullInner(); err != nil || tuple != nil {
+					return tuple, err
+				}
+			}
+			continue
+		}
+		nl.econtext.InnerTuple = inner
+		if ok, err := ExecQual(nl.joinQual, nl.econtext); err != nil {
+			return nil, err
+		} else if !ok {
+			continue
+		}
+		nl.matchedOuter = true
+		nl.innerMatched[nl.innerPos-1] = true
+		if tuple, err := nl.projectJoin(); err != nil || tuple != nil {
+			return tuple, err
+		}
+	}
+	return nil, nil
+}
+
+// Returns the next row of the inner plan, counting them.
+func (nl *NestLoop) nextInner() (access.Tuple, error) {
+	inner, err := nl.innerPlan.Exec()
+	if err != nil || inner == nil {
+		return nil, err
+	}
+	if nl.innerPos == len(nl.innerMatched) {
+		nl.innerMatched = append(nl.innerMatched, false)
+	}
+	nl.innerPos++
+	return inner, nil
+}
+
+func (nl *NestLoop) ReScan() error {
+	nl.needOuter, nl.fillInner, nl.done = true, false, false
+	nl.innerMatched = nil
+	return nl.outerPlan.ReScan()
+}
@@ -6,59 +6,74 @@ import "bigpot/planner"
 import "bigpot/system"
 
 type Node interface {
-	Init()
-	Exec() access.Tuple
+	Init() error
+	Exec() (access.Tuple, error)
 	End()
+	// Starts the rows of the node over, as postgres' ExecReScan.
+	ReScan() error
+	// Returns the TupleDesc of the rows the node returns, as postgres'
+	// ExecGetResultType.
+	ResultDesc() *access.TupleDesc
 }
 
 type Scan interface {
-	GetNext() access.Tuple
+	GetNext() (access.Tuple, error)
 }
 
 type SeqScan struct {
 	planner.SeqScan
-	relation   *access.Relation
-	scan       *access.RelationScan
+	relation   *access.HeapRelation
+	scan       access.Scan
 	executor   *ExecutorImpl
 	targetDesc *access.TupleDesc
+	targetList []*ExprState
+	qual       []*ExprState
+	econtext   *ExprContext
 }
 
-func (scan *SeqScan) Init() {
+func (scan *SeqScan) Init() error {
 	var err error
-	scan.relation, err = access.HeapOpen(scan.RangeTable.RelId)
+	if scan.targetList, err = ExecInitTargetList(scan.SeqScan.TargetList); err != nil {
+		return err
+	}
+	if scan.qual, err = ExecInitQual(scan.SeqScan.Qual); err != nil {
+		return err
+	}
+	scan.econtext = scan.executor.createExprContext()
+	scan.relation, err = scan.executor.relcache.HeapOpen(scan.RangeTable.RelId)
 	if err != nil {
-		/* TODO: do stuff */
+		return err
 	}
-	emptykeys := []access.ScanKey{}
-	scan.scan, err = scan.relation.BeginScan(emptykeys)
+	scan.scan, err = scan.relation.BeginScan(scan.ScanKeys, scan.executor.bufMgr)
 	if err != nil {
-		/* TODO: do stuff */
+		return err
 	}
+	scan.targetDesc = ExecTypeFromTL(scan.SeqScan.TargetList)
+	return nil
+}
 
-	/* Build the output tuple desc */
-	attrs := make([]*access.Attribute, len(scan.SeqScan.TargetList))
-	for i, tle := range scan.SeqScan.TargetList {
-		attrs[i] = &access.Attribute{
-			tle.ResName, tle.Expr.ResultType(),
+// Returns the next row that satisfies the qual, as postgres' ExecScan.
+func (scan *SeqScan) Exec() (access.Tuple, error) {
+	for {
+		tuple, err := scan.GetNext()
+		if err != nil || tuple == nil {
+			return nil, err
+		}
+		scan.econtext.ScanTuple = tuple
+		if ok, err := ExecQual(scan.qual, scan.econtext); err != nil {
+			return nil, err
+		} else if ok {
+			return ExecProject(scan.targetList, scan.econtext)
 		}
 	}
 }
 
-func (scan *SeqScan) Exec() access.Tuple {
-	/* TODO: projection */
-	tuple := scan.GetNext()
-	scan.executor.scanTuple = tuple
-	projected := scan.executor.projection(scan.SeqScan.TargetList,
-		scan.targetDesc)
-	return projected
+func (scan *SeqScan) GetNext() (access.Tuple, error) {
+	return scan.scan.Next()
 }
 
-func (scan *SeqScan) GetNext() access.Tuple {
-	if tuple, err := scan.scan.Next(); err == nil {
-		return tuple
-	}
-
-	return nil
+func (scan *SeqScan) ReScan() error {
+	return scan.scan.EndScan()
 }
 
 func (scan *SeqScan) End() {
@@ -66,29 +81,76 @@ func (scan *SeqScan) End() {
 	scan.relation.Close()
 }
 
+func (scan *SeqScan) ResultDesc() *access.TupleDesc {
+	return scan.targetDesc
+}
+
 // --- will be moved elsewhere
 
-func (executor *ExecutorImpl) projection(tlist []*parser.TargetEntry,
-	tlistDesc *access.TupleDesc) access.Tuple {
-	values := make([]string, len(tlist))
+// VirtualTuple is a row of datums that is not formed into a heap tuple,
+// as postgres' virtual TupleTableSlot.
+type VirtualTuple []system.Datum
+
+func (tuple VirtualTuple) Fetch(attnum system.AttrNumber) system.Datum {
+	return tuple[attnum-1]
+}
+
+// Copies the values of the row of the TupleDesc, as postgres'
+// ExecCopySlot, so that they outlive the row the node below returned.
+func copyTuple(tuple access.Tuple, desc *access.TupleDesc) VirtualTuple {
+	values := make(VirtualTuple, len(desc.Attrs))
+	for i := range values {
+		values[i] = tuple.Fetch(system.AttrNumber(i + 1))
+	}
+	return values
+}
+
+// Builds the TupleDesc of the rows of the target list, as postgres'
+// ExecTypeFromTL.
+func ExecTypeFromTL(tlist []*parser.TargetEntry) *access.TupleDesc {
+	attrs := make([]*access.Attribute, len(tlist))
 	for i, tle := range tlist {
-		values[i] = executor.ExecExpr(tle.Expr).ToString()
+		attrs[i] = &access.Attribute{
+			Name:   tle.ResName,
+			TypeId: tle.Expr.ResultType(),
+		}
 	}
+	return access.NewTupleDesc(attrs, false)
+}
 
-	return access.Tuple(&access.CSVTuple{
-		TupleDesc: tlistDesc,
-		Values:    values,
-	})
+// Builds the TupleDesc of the rows of the target list without its junk
+// entries, as postgres' ExecCleanTypeFromTL.
+func ExecCleanTypeFromTL(tlist []*parser.TargetEntry) *access.TupleDesc {
+	var clean []*parser.TargetEntry
+	for _, tle := range tlist {
+		if !tle.ResJunk {
+			clean = append(clean, tle)
+		}
+	}
+	return ExecTypeFromTL(clean)
 }
 
-func (executor *ExecutorImpl) ExecExpr(expr parser.Expr) system.Datum {
-	switch expr.(type) {
-	case *parser.Var:
-		variable := expr.(*parser.Var)
-		tuple := executor.scanTuple
-		/* TODO: remove int32 */
-		return tuple.Get(int32(variable.VarAttNo))
+// Compiles the expressions of the target list.
+func ExecInitTargetList(tlist []*parser.TargetEntry) ([]*ExprState, error) {
+	states := make([]*ExprState, len(tlist))
+	for i, tle := range tlist {
+		var err error
+		if states[i], err = ExecInitExpr(tle.Expr); err != nil {
+			return nil, err
+		}
 	}
+	return states, nil
+}
 
-	panic("unreachable")
+// Forms the row of the values of the target list, as postgres'
+// ExecProject.
+func ExecProject(targetList []*ExprState, econtext *ExprContext) (access.Tuple, error) {
+	values := make(VirtualTuple, len(targetList))
+	for i, state := range targetList {
+		var err error
+		if values[i], err = state.Eval(econtext); err != nil {
+			return nil, err
+		}
+	}
+	return values, nil
 }
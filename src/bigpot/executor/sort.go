@@ -0,0 +1,107 @@
+package executor
+
+import (
+	"bigpot/access"
+	"bigpot/planner"
+	"bigpot/system"
+)
+
+// Sort returns the rows of its subplan in order, as postgres' SortState.
+type Sort struct {
+	planner.Sort
+	executor *ExecutorImpl
+	subplan  Node
+	compares []system.CompareFunc
+	// the most rows the node above reads, or 0 if it reads them all, as
+	// postgres' bound, set by a Limit above
+	bound  int
+	state  *tuplesortState
+	sorted bool
+}
+
+func (st *Sort) Init() error {
+	for _, key := range st.SortKeys {
+		compare, err := system.LookupCompare(key.TypeId, key.TypeId)
+		if err != nil {
+			return err
+		}
+		st.compares = append(st.compares, compare)
+	}
+	var err error
+	st.subplan, err = st.executor.initExecNode(st.LeftTree)
+	return err
+}
+
+// Compares the rows by the sort keys, as postgres' comparetup_heap.
+func (st *Sort) compareRows(a, b access.Tuple) int {
+	for i, key := range st.SortKeys {
+		va, vb := a.Fetch(key.AttNo), b.Fetch(key.AttNo)
+		switch {
+		case va == nil && vb == nil:
+			continue
+		case va == nil && key.NullsFirst, vb == nil && !key.NullsFirst:
+			return -1
+		case va == nil, vb == nil:
+			return 1
+		}
+		c := st.compares[i](va, vb)
+		if key.Descending {
+			c = -c
+		}
+		if c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+// Returns the next row in order, as postgres' ExecSort.  All the rows of
+// the subplan are read and sorted first.
+func (st *Sort) Exec() (access.Tuple, error) {
+	if !st.sorted {
+		st.state = newTuplesort(st.subplan.ResultDesc(), st.compareRows, st.executor.workMem)
+		st.state.bound = st.bound
+		for {
+			tuple, err := st.subplan.Exec()
+			if err != nil {
+				return nil, err
+			} else if tuple == nil {
+				break
+			}
+			if err := st.state.putTuple(tuple); err != nil {
+				return nil, err
+			}
+		}
+		if err := st.state.performSort(); err != nil {
+			return nil, err
+		}
+		st.sorted = true
+	}
+	return st.state.getTuple()
+}
+
+// Starts the sorted rows over, without sorting them again, unless the
+// plan reads Params, whose new values the rows may differ by.
+func (st *Sort) ReScan() error {
+	if !st.sorted {
+		return nil
+	} else if len(st.AllParam) > 0 {
+		st.state.end()
+		st.state, st.sorted = nil, false
+		return st.subplan.ReScan()
+	}
+	return st.state.rescan()
+}
+
+func (st *Sort) End() {
+	if st.state != nil {
+		st.state.end()
+	}
+	if st.subplan != nil {
+		st.subplan.End()
+	}
+}
+
+func (st *Sort) ResultDesc() *access.TupleDesc {
+	return st.subplan.ResultDesc()
+}
@@ -0,0 +1,111 @@
+package executor
+
+import (
+	"bigpot/parser"
+	"bigpot/system"
+)
+
+// subPlanState is the state of the plan of a SubPlan, as postgres'
+// SubPlanState.  A SubPlan that reads no Params is run once, and its
+// value kept, as postgres runs an initplan.
+type subPlanState struct {
+	planstate Node
+	// whether the plan was run, and must be started over to run again
+	started bool
+	cached  bool
+	value   system.Datum
+}
+
+// Compiles the SubPlan, as postgres' ExecInitSubPlan.  Each time it is
+// evaluated, the values of its arguments are set into the Params of its
+// ParParam, and its plan is run again, as postgres' ExecSubPlan.
+func compileSubPlan(subplan *parser.SubPlan) (exprFunc, error) {
+	args, err := compileExprs(subplan.Args)
+	if err != nil {
+		return nil, err
+	}
+	var testexpr exprFunc
+	if subplan.Testexpr != nil {
+		if testexpr, err = compileExpr(subplan.Testexpr); err != nil {
+			return nil, err
+		}
+	}
+	return func(econtext *ExprContext) (system.Datum, error) {
+		exec := econtext.executor
+		state := exec.subplans[subplan.PlanId-1]
+		if state.cached {
+			return state.value, nil
+		}
+		for i, arg := range args {
+			value, err := arg(econtext)
+			if err != nil {
+				return nil, err
+			}
+			exec.params[subplan.ParParam[i]] = value
+		}
+		if state.started {
+			if err := state.planstate.ReScan(); err != nil {
+				return nil, err
+			}
+		}
+		state.started = true
+		value, err := state.run(subplan, testexpr, econtext)
+		if err != nil {
+			return nil, err
+		}
+		if len(subplan.ExtParam) == 0 && subplan.SubLinkType != parser.ANY_SUBLINK {
+			state.cached, state.value = true, value
+		}
+		return value, nil
+	}, nil
+}
+
+// Runs the plan for the value of the SubPlan.  EXISTS is true if there is
+// a row; an expression is the first column of the only row, or NULL if
+// there is none; ANY is true if the Testexpr is true for some row, or
+// else NULL if it is NULL for some, and false otherwise, as SQL's = ANY.
+func (state *subPlanState) run(subplan *parser.SubPlan, testexpr exprFunc,
+	econtext *ExprContext) (system.Datum, error) {
+	switch subplan.SubLinkType {
+	case parser.EXISTS_SUBLINK:
+		tuple, err := state.planstate.Exec()
+		if err != nil {
+			return nil, err
+		}
+		return system.Bool(tuple != nil), nil
+	case parser.EXPR_SUBLINK:
+		tuple, err := state.planstate.Exec()
+		if err != nil || tuple == nil {
+			return nil, err
+		}
+		value := tuple.Fetch(1)
+		if next, err := state.planstate.Exec(); err != nil {
+			return nil, err
+		} else if next != nil {
+			return nil, system.Ereport(system.CardinalityViolation,
+				"more than one row returned by a subquery used as an expression")
+		}
+		return value, nil
+	}
+	var result system.Datum = system.Bool(false)
+	params := econtext.executor.params
+	for {
+		tuple, err := state.planstate.Exec()
+		if err != nil {
+			return nil, err
+		} else if tuple == nil {
+			return result, nil
+		}
+		for i, paramid := range subplan.ParamIds {
+			params[paramid] = tuple.Fetch(system.AttrNumber(i + 1))
+		}
+		value, err := testexpr(econtext)
+		if err != nil {
+			return nil, err
+		} else if value == nil {
+			result = nil
+		} else if value.(system.Bool) {
+			return value, nil
+		}
+	}
+}
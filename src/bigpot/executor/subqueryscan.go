@@ -0,0 +1,63 @@
+package executor
+
+import (
+	"bigpot/access"
+	"bigpot/planner"
+)
+
+// SubqueryScan returns the rows of the plan of a subquery, as postgres'
+// SubqueryScanState.
+type SubqueryScan struct {
+	planner.SubqueryScan
+	executor   *ExecutorImpl
+	subplan    Node
+	targetList []*ExprState
+	qual       []*ExprState
+	targetDesc *access.TupleDesc
+	econtext   *ExprContext
+}
+
+func (scan *SubqueryScan) Init() error {
+	var err error
+	if scan.targetList, err = ExecInitTargetList(scan.TargetList); err != nil {
+		return err
+	}
+	if scan.qual, err = ExecInitQual(scan.Qual); err != nil {
+		return err
+	}
+	scan.econtext = scan.executor.createExprContext()
+	scan.targetDesc = ExecTypeFromTL(scan.TargetList)
+	scan.subplan, err = scan.executor.initExecNode(scan.LeftTree)
+	return err
+}
+
+// Returns the target list computed from the next row of the subquery
+// that satisfies the qual, as postgres' SubqueryNext.
+func (scan *SubqueryScan) Exec() (access.Tuple, error) {
+	for {
+		tuple, err := scan.subplan.Exec()
+		if err != nil || tuple == nil {
+			return nil, err
+		}
+		scan.econtext.ScanTuple = tuple
+		if ok, err := ExecQual(scan.qual, scan.econtext); err != nil {
+			return nil, err
+		} else if ok {
+			return ExecProject(scan.targetList, scan.econtext)
+		}
+	}
+}
+
+func (scan *SubqueryScan) ReScan() error {
+	return scan.subplan.ReScan()
+}
+
+func (scan *SubqueryScan) End() {
+	if scan.subplan != nil {
+		scan.subplan.End()
+	}
+}
+
+func (scan *SubqueryScan) ResultDesc() *access.TupleDesc {
+	return scan.targetDesc
+}
@@ -0,0 +1,357 @@
+package executor
+
+import (
+	"bufio"
+	"container/heap"
+	"encoding/binary"
+	"io"
+	"os"
+	"sort"
+
+	"bigpot/access"
+	"bigpot/storage"
+	"bigpot/system"
+)
+
+const (
+	// the buffer each run being merged is read through, as postgres'
+	// MERGE_BUFFER_SIZE
+	mergeBufferSize = 32 * system.BlockSize
+	// the fewest runs merged at once, as postgres' MINORDER
+	minMergeOrder = 6
+)
+
+// tuplesortState sorts rows, as postgres' Tuplesortstate.  The rows are
+// sorted in memory as long as they fit in workMem; past that, the rows in
+// memory are sorted and written to a temporary file as a run, and the runs
+// are merged at the end.  Rows of equal keys keep the order they came in.
+type tuplesortState struct {
+	desc    *access.TupleDesc
+	compare func(a, b access.Tuple) int
+	// the memory, in kilobytes, the rows may take before they are
+	// written to temporary files, as postgres' work_mem
+	workMem int
+	// the most rows the sort is to return, or 0 if no limit, as postgres'
+	// bound.  The first rows of a bounded sort are kept in a heap of the
+	// rows so far, the greatest on top, which drops those past the bound.
+	bound int
+	// the rows in memory, and the memory they take, as postgres'
+	// memtuples and availMem
+	memtuples []access.Tuple
+	memUsed   int64
+	// the sorted runs written so far, in the order of their rows
+	runs []*os.File
+	// the sorted rows are those of memtuples from pos, or those the merge
+	// of the runs returns
+	pos   int
+	merge *runMerge
+}
+
+func newTuplesort(desc *access.TupleDesc, compare func(a, b access.Tuple) int, workMem int) *tuplesortState {
+	return &tuplesortState{desc: desc, compare: compare, workMem: workMem}
+}
+
+// Returns the memory the values of a row take, roughly, as postgres'
+// GetMemoryChunkSpace does for a tuple.
+func tupleSpace(values VirtualTuple) int64 {
+	space := int64(24 + 16*len(values))
+	for _, value := range values {
+		if value != nil {
+			space += int64(value.Len())
+		}
+	}
+	return space
+}
+
+// Adds the row to the sort, as postgres' tuplesort_puttupleslot.  Its
+// values are copied.
+func (state *tuplesortState) putTuple(tuple access.Tuple) error {
+	values := copyTuple(tuple, state.desc)
+	if state.bound > 0 {
+		bounded := (*boundedHeap)(state)
+		if len(state.memtuples) < state.bound {
+			heap.Push(bounded, access.Tuple(values))
+		} else if state.compare(values, state.memtuples[0]) < 0 {
+			state.memtuples[0] = values
+			heap.Fix(bounded, 0)
+		}
+		return nil
+	}
+	state.memtuples = append(state.memtuples, values)
+	state.memUsed += tupleSpace(values)
+	if state.memUsed > int64(state.workMem)*1024 {
+		return state.dumpTuples()
+	}
+	return nil
+}
+
+// Sorts the rows in memory, in a stable order.
+func (state *tuplesortState) sortMemTuples() {
+	sort.SliceStable(state.memtuples, func(i, j int) bool {
+		return state.compare(state.memtuples[i], state.memtuples[j]) < 0
+	})
+}
+
+// Sorts the rows in memory and writes them to a new run, as postgres'
+// dumptuples.
+func (state *tuplesortState) dumpTuples() error {
+	state.sortMemTuples()
+	run, err := state.writeRun(state.memtuples)
+	if err != nil {
+		return err
+	}
+	state.runs = append(state.runs, run)
+	state.memtuples, state.memUsed = nil, 0
+	return nil
+}
+
+// Writes the rows to a new temporary file, each as its length and the
+// bytes of a heap tuple of it, as postgres' writetup_heap.
+func (state *tuplesortState) writeRun(tuples []access.Tuple) (*os.File, error) {
+	file, err := storage.OpenTemporaryFile()
+	if err != nil {
+		return nil, err
+	}
+	writer := bufio.NewWriterSize(file, mergeBufferSize)
+	for _, tuple := range tuples {
+		if err := writeTempTuple(writer, tuple, state.desc); err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		file.Close()
+		return nil, system.Ereport(system.IoError, "could not write to temporary file: %v", err)
+	}
+	return file, nil
+}
+
+// Writes the row of the TupleDesc to a temporary file, as its length and
+// the bytes of a heap tuple of it; readTempTuple reads it back.
+func writeTempTuple(writer io.Writer, tuple access.Tuple, desc *access.TupleDesc) error {
+	data := access.FormHeapTuple(copyTuple(tuple, desc), desc).Bytes()
+	if err := binary.Write(writer, binary.LittleEndian, uint32(len(data))); err != nil {
+		return system.Ereport(system.IoError, "could not write to temporary file: %v", err)
+	} else if _, err := writer.Write(data); err != nil {
+		return system.Ereport(system.IoError, "could not write to temporary file: %v", err)
+	}
+	return nil
+}
+
+// Reads the next row of the TupleDesc from a temporary file, as postgres'
+// readtup_heap, or nil at its end.
+func readTempTuple(reader io.Reader, desc *access.TupleDesc) (access.Tuple, error) {
+	var length uint32
+	if err := binary.Read(reader, binary.LittleEndian, &length); err == io.EOF {
+		return nil, nil
+	} else if err != nil {
+		return nil, system.Ereport(system.IoError, "could not read from temporary file: %v", err)
+	}
+	data := make([]byte, length)
+	if _, err := io.ReadFull(reader, data); err != nil {
+		return nil, system.Ereport(system.IoError, "could not read from temporary file: %v", err)
+	}
+	return access.NewHeapTuple(data, desc, system.InvalidItemPointer), nil
+}
+
+// Sorts the rows added, as postgres' tuplesort_performsort.  If runs were
+// written, the rows left in memory are written as the last one, and the
+// runs are merged, as many at a time as workMem has buffers for, as
+// postgres' mergeruns, until they are few enough to be merged as the rows
+// are read.  Each pass merges runs next to each other, so that the rows
+// keep their order.
+func (state *tuplesortState) performSort() error {
+	if state.bound > 0 {
+		// the heap is the wrong way round
+		state.sortMemTuples()
+		return nil
+	} else if len(state.runs) == 0 {
+		state.sortMemTuples()
+		return nil
+	}
+	if len(state.memtuples) > 0 {
+		if err := state.dumpTuples(); err != nil {
+			return err
+		}
+	}
+	order := int(int64(state.workMem) * 1024 / mergeBufferSize)
+	if order < minMergeOrder {
+		order = minMergeOrder
+	}
+	for len(state.runs) > order {
+		var merged []*os.File
+		for len(state.runs) > 0 {
+			n := order
+			if n > len(state.runs) {
+				n = len(state.runs)
+			}
+			run, err := state.mergeRuns(state.runs[:n])
+			if err != nil {
+				state.runs = append(merged, state.runs...)
+				return err
+			}
+			merged = append(merged, run)
+			state.runs = state.runs[n:]
+		}
+		state.runs = merged
+	}
+	return state.startMerge()
+}
+
+// Merges the runs into a new one, which takes their place.
+func (state *tuplesortState) mergeRuns(runs []*os.File) (*os.File, error) {
+	merge, err := state.beginMerge(runs)
+	if err != nil {
+		return nil, err
+	}
+	file, err := storage.OpenTemporaryFile()
+	if err != nil {
+		return nil, err
+	}
+	writer := bufio.NewWriterSize(file, mergeBufferSize)
+	for {
+		tuple, err := merge.next()
+		if err != nil {
+			file.Close()
+			return nil, err
+		} else if tuple == nil {
+			break
+		}
+		if err := writeTempTuple(writer, tuple, state.desc); err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		file.Close()
+		return nil, system.Ereport(system.IoError, "could not write to temporary file: %v", err)
+	}
+	for _, run := range runs {
+		run.Close()
+	}
+	return file, nil
+}
+
+// Starts the merge of all the runs, which returns the sorted rows.
+func (state *tuplesortState) startMerge() error {
+	var err error
+	state.merge, err = state.beginMerge(state.runs)
+	return err
+}
+
+// Returns the next sorted row, as postgres' tuplesort_gettupleslot, or nil
+// once they are done.
+func (state *tuplesortState) getTuple() (access.Tuple, error) {
+	if state.merge != nil {
+		return state.merge.next()
+	} else if state.pos == len(state.memtuples) {
+		return nil, nil
+	}
+	state.pos++
+	return state.memtuples[state.pos-1], nil
+}
+
+// Starts the sorted rows over, as postgres' tuplesort_rescan.  The runs
+// are merged again.
+func (state *tuplesortState) rescan() error {
+	state.pos = 0
+	if state.merge != nil {
+		return state.startMerge()
+	}
+	return nil
+}
+
+// Removes the runs, as postgres' tuplesort_end.
+func (state *tuplesortState) end() {
+	for _, run := range state.runs {
+		run.Close()
+	}
+	state.runs, state.memtuples, state.merge = nil, nil, nil
+}
+
+// boundedHeap is the rows of a bounded sort as a heap, the greatest on
+// top, as postgres' make_bounded_heap.
+type boundedHeap tuplesortState
+
+func (h *boundedHeap) Len() int { return len(h.memtuples) }
+func (h *boundedHeap) Less(i, j int) bool {
+	return h.compare(h.memtuples[i], h.memtuples[j]) > 0
+}
+func (h *boundedHeap) Swap(i, j int) {
+	h.memtuples[i], h.memtuples[j] = h.memtuples[j], h.memtuples[i]
+}
+func (h *boundedHeap) Push(x interface{}) { h.memtuples = append(h.memtuples, x.(access.Tuple)) }
+func (h *boundedHeap) Pop() interface{} {
+	last := h.memtuples[len(h.memtuples)-1]
+	h.memtuples = h.memtuples[:len(h.memtuples)-1]
+	return last
+}
+
+// runMerge merges runs, as postgres' beginmerge and mergeonerun: a heap
+// of the next row of each run returns the least of them, of the earliest
+// run if some are equal.
+type runMerge struct {
+	state   *tuplesortState
+	readers []*bufio.Reader
+	// the next row of each run not done, and the index of the run
+	tuples []access.Tuple
+	runs   []int
+}
+
+func (state *tuplesortState) beginMerge(runs []*os.File) (*runMerge, error) {
+	merge := &runMerge{state: state}
+	for i, run := range runs {
+		if _, err := run.Seek(0, os.SEEK_SET); err != nil {
+			return nil, system.Ereport(system.IoError, "could not seek in temporary file: %v", err)
+		}
+		reader := bufio.NewReaderSize(run, mergeBufferSize)
+		merge.readers = append(merge.readers, reader)
+		tuple, err := readTempTuple(reader, state.desc)
+		if err != nil {
+			return nil, err
+		} else if tuple != nil {
+			merge.tuples = append(merge.tuples, tuple)
+			merge.runs = append(merge.runs, i)
+		}
+	}
+	heap.Init(merge)
+	return merge, nil
+}
+
+// Returns the next row of the merge, or nil once the runs are done.
+func (merge *runMerge) next() (access.Tuple, error) {
+	if len(merge.tuples) == 0 {
+		return nil, nil
+	}
+	tuple := merge.tuples[0]
+	next, err := readTempTuple(merge.readers[merge.runs[0]], merge.state.desc)
+	if err != nil {
+		return nil, err
+	} else if next == nil {
+		heap.Pop(merge)
+	} else {
+		merge.tuples[0] = next
+		heap.Fix(merge, 0)
+	}
+	return tuple, nil
+}
+
+func (merge *runMerge) Len() int { return len(merge.tuples) }
+func (merge *runMerge) Less(i, j int) bool {
+	if c := merge.state.compare(merge.tuples[i], merge.tuples[j]); c != 0 {
+		return c < 0
+	}
+	return merge.runs[i] < merge.runs[j]
+}
+func (merge *runMerge) Swap(i, j int) {
+	merge.tuples[i], merge.tuples[j] = merge.tuples[j], merge.tuples[i]
+	merge.runs[i], merge.runs[j] = merge.runs[j], merge.runs[i]
+}
+func (merge *runMerge) Push(x interface{}) {
+	panic("runs are not added to a merge")
+}
+func (merge *runMerge) Pop() interface{} {
+	last := len(merge.tuples) - 1
+	merge.tuples, merge.runs = merge.tuples[:last], merge.runs[:last]
+	return nil
+}
@@ -0,0 +1,66 @@
+package executor
+
+import (
+	"bigpot/access"
+	"bigpot/planner"
+)
+
+// ValuesScan returns the rows of a VALUES, as postgres' ValuesScanState.
+type ValuesScan struct {
+	planner.ValuesScan
+	executor   *ExecutorImpl
+	exprLists  [][]*ExprState
+	targetList []*ExprState
+	targetDesc *access.TupleDesc
+	econtext   *ExprContext
+	// the index of the next row
+	curr int
+}
+
+func (scan *ValuesScan) Init() error {
+	for _, exprs := range scan.ValuesLists {
+		states, err := ExecInitExprList(exprs)
+		if err != nil {
+			return err
+		}
+		scan.exprLists = append(scan.exprLists, states)
+	}
+	var err error
+	if scan.targetList, err = ExecInitTargetList(scan.TargetList); err != nil {
+		return err
+	}
+	scan.econtext = scan.executor.createExprContext()
+	scan.targetDesc = ExecTypeFromTL(scan.TargetList)
+	return nil
+}
+
+// Evaluates the next row of the VALUES and returns the target list
+// computed from it, as postgres' ValuesNext.
+func (scan *ValuesScan) Exec() (access.Tuple, error) {
+	if scan.curr >= len(scan.exprLists) {
+		return nil, nil
+	}
+	exprs := scan.exprLists[scan.curr]
+	scan.curr++
+	row := make(VirtualTuple, len(exprs))
+	for i, state := range exprs {
+		var err error
+		if row[i], err = state.Eval(scan.econtext); err != nil {
+			return nil, err
+		}
+	}
+	scan.econtext.ScanTuple = row
+	return ExecProject(scan.targetList, scan.econtext)
+}
+
+func (scan *ValuesScan) ReScan() error {
+	scan.curr = 0
+	return nil
+}
+
+func (scan *ValuesScan) End() {
+}
+
+func (scan *ValuesScan) ResultDesc() *access.TupleDesc {
+	return scan.targetDesc
+}
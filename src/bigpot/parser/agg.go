@@ -0,0 +1,134 @@
+package parser
+
+import (
+	"bigpot/system"
+)
+
+// Makes the Aggref of a call of the aggregate, as postgres'
+// transformAggregateCall.  Its arguments are computed for each row of the
+// group, and so cannot call aggregates themselves.  A literal of unknown
+// type, which goes to an argument of any type, is text.
+func (parser *ParserImpl) transformAggregateCall(fn *FuncCall, agg *system.AggregateInfo,
+	args []Expr) (Expr, error) {
+	if len(args) == 0 && !fn.AggStar {
+		return nil, system.Ereport(system.WrongObjectType,
+			"%s(*) must be used to call a parameterless aggregate function", fn.FuncName)
+	}
+	for i, arg := range args {
+		if containsAggregate(arg) {
+			return nil, system.Ereport(system.GroupingError,
+				"aggregate function calls cannot be nested")
+		}
+		if arg.ResultType() == system.UnknownType {
+			var err error
+			if args[i], err = parser.coerceType(arg, system.TextType, false); err != nil {
+				return nil, err
+			}
+		}
+		// the rows of a DISTINCT aggregate are compared by their arguments
+		if fn.AggDistinct {
+			typid := args[i].ResultType()
+			if _, err := system.LookupCompare(typid, typid); err != nil {
+				return nil, system.Ereport(system.UndefinedFunction,
+					"could not identify an ordering operator for type %s", system.FormatType(typid))
+			}
+		}
+	}
+	parser.hasAggs = true
+	return &Aggref{
+		ExprImpl:    ExprImpl{agg.Proc.RetType},
+		Agg:         agg,
+		Args:        args,
+		AggDistinct: fn.AggDistinct,
+	}, nil
+}
+
+// Returns true if the expression calls an aggregate, as postgres'
+// contain_aggs_of_level.
+func containsAggregate(expr Expr) bool {
+	return WalkExpr(expr, func(e Expr) bool {
+		_, ok := e.(*Aggref)
+		return ok
+	})
+}
+
+// Checks that the expression of the construct calls no aggregate, as
+// postgres' check_agglevels_and_constraints does for the constructs whose
+// expressions are computed for each row, or before the rows are read.
+func checkNoAggregates(expr Expr, constructName string) error {
+	if containsAggregate(expr) {
+		return system.Ereport(system.GroupingError,
+			"aggregate functions are not allowed in %s", constructName)
+	}
+	return nil
+}
+
+// Checks that the target list and the HAVING of a query that groups its
+// rows, by GROUP BY or by calling aggregates, refer to the columns of the
+// rows only in the expressions of the GROUP BY or within aggregates, as
+// postgres' parseCheckAggregates.
+func (parser *ParserImpl) parseCheckAggregates(query *Query) error {
+	var groupExprs []Expr
+	for _, clause := range query.GroupClause {
+		groupExprs = append(groupExprs, GetSortGroupClauseTle(clause, query.TargetList).Expr)
+	}
+	for _, tle := range query.TargetList {
+		if err := parser.checkUngroupedColumns(tle.Expr, groupExprs); err != nil {
+			return err
+		}
+	}
+	return parser.checkUngroupedColumns(query.HavingQual, groupExprs)
+}
+
+// Checks that the expression refers to no column but in the grouped
+// expressions and the aggregates, as postgres' check_ungrouped_columns.
+// These are taken out of the expression first, and any Var left is a
+// column of the rows not grouped by.  A subquery may refer to a column
+// grouped by only, as an outer query's.
+func (parser *ParserImpl) checkUngroupedColumns(expr Expr, groupExprs []Expr) error {
+	rest := MutateExpr(expr, func(e Expr) Expr {
+		if _, ok := e.(*Aggref); ok {
+			return &Const{ExprImpl{e.ResultType()}, nil}
+		}
+		for _, groupExpr := range groupExprs {
+			if EqualExpr(e, groupExpr) {
+				return &Const{ExprImpl{e.ResultType()}, nil}
+			}
+		}
+		return nil
+	})
+	var ungrouped *Var
+	var inSubquery bool
+	WalkExprTree(rest, func(e Expr, levelsUp int) bool {
+		v, ok := e.(*Var)
+		if !ok || int(v.VarLevelsUp) != levelsUp {
+			return false
+		} else if levelsUp > 0 {
+			local := *v
+			local.VarLevelsUp = 0
+			for _, groupExpr := range groupExprs {
+				if EqualExpr(&local, groupExpr) {
+					return false
+				}
+			}
+		}
+		ungrouped, inSubquery = v, levelsUp > 0
+		return true
+	})
+	if ungrouped == nil {
+		return nil
+	}
+	rte := parser.rtable[ungrouped.VarNo-1]
+	attname := "ctid"
+	if ungrouped.VarAttNo > 0 {
+		attname = rte.RefAlias.ColumnNames[ungrouped.VarAttNo-1]
+	}
+	if inSubquery {
+		return system.Ereport(system.GroupingError,
+			"subquery uses ungrouped column \"%s.%s\" from outer query",
+			rte.RefAlias.AliasName, attname)
+	}
+	return system.Ereport(system.GroupingError,
+		"column \"%s.%s\" must appear in the GROUP BY clause or be used in an aggregate function",
+		rte.RefAlias.AliasName, attname)
+}
@@ -0,0 +1,244 @@
+package parser
+
+import (
+	"strings"
+
+	"bigpot/system"
+)
+
+type castKey struct {
+	source, target system.Oid
+}
+
+// The casts applied without being written, as the implicit casts of
+// postgres' pg_cast.
+var implicitCasts = map[castKey]bool{
+	{system.Int4Type, system.Float8Type}:           true,
+	{system.NameType, system.TextType}:             true,
+	{system.TextType, system.NameType}:             true,
+	{system.DateType, system.TimestampType}:        true,
+	{system.DateType, system.TimestampTzType}:      true,
+	{system.TimestampType, system.TimestampTzType}: true,
+}
+
+// Returns true if a value of the type source can be used where the type
+// target is called for, as postgres' can_coerce_type.  A literal of
+// unknown type goes anywhere, and any value to an argument of AnyType.
+func canCoerceType(source, target system.Oid) bool {
+	return source == target || source == system.UnknownType ||
+		target == system.AnyType || implicitCasts[castKey{source, target}]
+}
+
+// Converts the expression to the type typid, as postgres' coerce_type.  A
+// literal is converted at once by the input function of the type, in the
+// session's time zone; other expressions are passed to the function of
+// the cast, as postgres' find_coercion_pathway, or wrapped in a
+// CoerceViaIO if there is none.
+// Any type is cast if the cast is explicit, and the implicit casts only
+// otherwise.  An argument of AnyType is left as it is.
+func (parser *ParserImpl) coerceType(expr Expr, typid system.Oid, explicit bool) (Expr, error) {
+	source := expr.ResultType()
+	if source == typid || typid == system.AnyType {
+		return expr, nil
+	}
+	if con, ok := expr.(*Const); ok && source == system.UnknownType {
+		if con.Value == nil {
+			return &Const{ExprImpl{typid}, nil}, nil
+		}
+		value, err := system.DatumFromStringInZone(con.Value.ToString(), typid, parser.timeZone)
+		if err != nil {
+			return nil, err
+		}
+		return &Const{ExprImpl{typid}, value}, nil
+	}
+	if !explicit && !canCoerceType(source, typid) {
+		return nil, system.Ereport(system.CannotCoerce, "cannot cast type %s to %s",
+			system.FormatType(source), system.FormatType(typid))
+	}
+	if proc := system.LookupCastFunc(source, typid); proc != nil {
+		return &FuncExpr{ExprImpl{typid}, proc, []Expr{expr}}, nil
+	}
+	return &CoerceViaIO{ExprImpl{typid}, expr}, nil
+}
+
+// Converts the argument of the construct to boolean, as postgres'
+// coerce_to_boolean.
+func (parser *ParserImpl) coerceToBoolean(expr Expr, constructName string) (Expr, error) {
+	typid := expr.ResultType()
+	if typid != system.BoolType && typid != system.UnknownType {
+		return nil, system.Ereport(system.DatatypeMismatch,
+			"argument of %s must be type boolean, not type %s",
+			constructName, system.FormatType(typid))
+	}
+	return parser.coerceType(expr, system.BoolType, false)
+}
+
+// Converts the argument of the construct to the type typid, as postgres'
+// coerce_to_specific_type.
+func (parser *ParserImpl) coerceToSpecificType(expr Expr, typid system.Oid, constructName string) (Expr, error) {
+	if source := expr.ResultType(); !canCoerceType(source, typid) {
+		return nil, system.Ereport(system.DatatypeMismatch,
+			"argument of %s must be type %s, not type %s",
+			constructName, system.FormatType(typid), system.FormatType(source))
+	}
+	return parser.coerceType(expr, typid, false)
+}
+
+// Makes the OpExpr of the operator applied to the arguments, as postgres'
+// make_op.  left is nil for a prefix operator.
+func (parser *ParserImpl) makeOpExpr(opname string, left, right Expr) (Expr, error) {
+	ltype := system.InvalidOid
+	if left != nil {
+		ltype = left.ResultType()
+	}
+	opr, err := operSelect(opname, ltype, right.ResultType())
+	if err != nil {
+		return nil, err
+	}
+	expr := &OpExpr{ExprImpl: ExprImpl{opr.Result}, Opr: opr}
+	if left != nil {
+		if left, err = parser.coerceType(left, opr.Left, false); err != nil {
+			return nil, err
+		}
+		expr.Args = append(expr.Args, left)
+	}
+	if right, err = parser.coerceType(right, opr.Right, false); err != nil {
+		return nil, err
+	}
+	expr.Args = append(expr.Args, right)
+	return expr, nil
+}
+
+// Returns the type the expressions are all coerced to, as postgres'
+// select_common_type: the first known type, unless a later one can take
+// it implicitly and not the other way round.  Literals of unknown type
+// alone are text.
+func selectCommonType(exprs []Expr, context string) (system.Oid, error) {
+	ptype := system.UnknownType
+	for _, expr := range exprs {
+		typid := expr.ResultType()
+		if typid == system.UnknownType || typid == ptype {
+			continue
+		} else if ptype == system.UnknownType {
+			ptype = typid
+		} else if canCoerceType(ptype, typid) && !canCoerceType(typid, ptype) {
+			ptype = typid
+		} else if !canCoerceType(typid, ptype) {
+			return system.InvalidOid, system.Ereport(system.DatatypeMismatch,
+				"%s types %s and %s cannot be matched", context,
+				system.FormatType(ptype), system.FormatType(typid))
+		}
+	}
+	if ptype == system.UnknownType {
+		ptype = system.TextType
+	}
+	return ptype, nil
+}
+
+// Returns the indexes of the candidates the arguments can be coerced to
+// with the most exact type matches, as postgres'
+// func_select_candidate in short.
+func selectCandidates(argTypes []system.Oid, candidates [][]system.Oid) []int {
+	var best []int
+	bestExact := -1
+	for i, candidate := range candidates {
+		if len(candidate) != len(argTypes) {
+			continue
+		}
+		exact := 0
+		for j, typid := range argTypes {
+			if !canCoerceType(typid, candidate[j]) {
+				exact = -1
+				break
+			} else if typid == candidate[j] {
+				exact++
+			}
+		}
+		if exact < 0 {
+			continue
+		} else if exact > bestExact {
+			best, bestExact = nil, exact
+		}
+		if exact == bestExact {
+			best = append(best, i)
+		}
+	}
+	return best
+}
+
+// Returns the function of the name for the argument types, as postgres'
+// func_get_detail: the one of the exact types if there is one, or else
+// the single best candidate.
+func funcSelect(funcname string, argTypes []system.Oid) (*system.ProcInfo, error) {
+	proc, notFound := system.LookupProc(funcname, argTypes)
+	if notFound == nil {
+		return proc, nil
+	}
+	procs := system.LookupProcCandidates(funcname)
+	candidates := make([][]system.Oid, len(procs))
+	for i, proc := range procs {
+		candidates[i] = proc.ArgTypes
+	}
+	best := selectCandidates(argTypes, candidates)
+	if len(best) == 0 {
+		return nil, notFound
+	} else if len(best) > 1 {
+		names := make([]string, len(argTypes))
+		for i, typid := range argTypes {
+			names[i] = system.FormatType(typid)
+		}
+		return nil, system.Ereport(system.AmbiguousFunction, "function %s(%s) is not unique",
+			funcname, strings.Join(names, ", "))
+	}
+	return procs[best[0]], nil
+}
+
+// Returns the operator of the name for the argument types, as postgres'
+// oper.  The operator of the exact types is taken first, reading an
+// unknown argument as of the type of the other, or as text if both are
+// unknown.  Failing that, the operator the arguments can be coerced to
+// with the most exact matches is, if there is a single one.
+func operSelect(opname string, ltype, rtype system.Oid) (*system.OperatorInfo, error) {
+	exactL, exactR := ltype, rtype
+	if ltype == system.UnknownType && rtype == system.UnknownType {
+		exactL, exactR = system.TextType, system.TextType
+	} else if ltype == system.UnknownType {
+		exactL = rtype
+	} else if rtype == system.UnknownType {
+		exactR = ltype
+	}
+	opr, notFound := system.LookupOperator(opname, exactL, exactR)
+	if notFound == nil {
+		return opr, nil
+	}
+
+	var oprs []*system.OperatorInfo
+	var candidates [][]system.Oid
+	argTypes := []system.Oid{ltype, rtype}
+	if ltype == system.InvalidOid {
+		argTypes = []system.Oid{rtype}
+	}
+	for _, candidate := range system.LookupOperatorCandidates(opname) {
+		if (candidate.Left == system.InvalidOid) != (ltype == system.InvalidOid) {
+			continue
+		} else if ltype == system.InvalidOid {
+			candidates = append(candidates, []system.Oid{candidate.Right})
+		} else {
+			candidates = append(candidates, []system.Oid{candidate.Left, candidate.Right})
+		}
+		oprs = append(oprs, candidate)
+	}
+	best := selectCandidates(argTypes, candidates)
+	if len(best) == 0 {
+		return nil, notFound
+	} else if len(best) > 1 {
+		if ltype == system.InvalidOid {
+			return nil, system.Ereport(system.AmbiguousFunction,
+				"operator is not unique: %s %s", opname, system.FormatType(rtype))
+		}
+		return nil, system.Ereport(system.AmbiguousFunction,
+			"operator is not unique: %s %s %s",
+			system.FormatType(ltype), opname, system.FormatType(rtype))
+	}
+	return oprs[best[0]], nil
+}
@@ -0,0 +1,20 @@
+package parser
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Returns the source text of the constant, as postgres' get_const_expr
+// deparses one.
+func (n *AConst) Deparse() string {
+	switch n.Kind {
+	case ConstInteger:
+		return strconv.Itoa(n.Ival)
+	case ConstFloat:
+		return n.Str
+	case ConstString:
+		return "'" + strings.Replace(n.Str, "'", "''", -1) + "'"
+	}
+	return "NULL"
+}
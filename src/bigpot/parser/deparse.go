@@ -0,0 +1,97 @@
+package parser
+
+import (
+	"strings"
+)
+
+// Returns the source text of the raw expression, as postgres'
+// deparse_expression, such that RawParseExpr reads the same expression
+// back.  Each operator is put in parentheses, so that the text does not
+// depend on the precedences of the grammar.
+func DeparseExpr(node Node) string {
+	switch n := node.(type) {
+	case *AConst:
+		return n.Deparse()
+	case *ColumnRef:
+		name := strings.Join(n.fields, ".")
+		if n.star {
+			name += ".*"
+		}
+		return name
+	case *TypeCast:
+		name := n.TypeName.Name
+		if n.TypeName.IsArray {
+			name += "[]"
+		}
+		return DeparseExpr(n.Arg) + "::" + name
+	case *FuncCall:
+		if n.AggStar {
+			return n.FuncName + "(*)"
+		} else if n.AggDistinct {
+			return n.FuncName + "(DISTINCT " + deparseExprList(n.Args) + ")"
+		}
+		return n.FuncName + "(" + deparseExprList(n.Args) + ")"
+	case *ACase:
+		var buf strings.Builder
+		buf.WriteString("CASE")
+		if n.Arg != nil {
+			buf.WriteString(" " + DeparseExpr(n.Arg))
+		}
+		for _, when := range n.Whens {
+			buf.WriteString(" WHEN " + DeparseExpr(when.Expr) + " THEN " + DeparseExpr(when.Result))
+		}
+		if n.DefResult != nil {
+			buf.WriteString(" ELSE " + DeparseExpr(n.DefResult))
+		}
+		buf.WriteString(" END")
+		return buf.String()
+	case *ACoalesce:
+		return "COALESCE(" + deparseExprList(n.Args) + ")"
+	case *AExpr:
+		return deparseAExpr(n)
+	}
+	panic("unknown node type")
+}
+
+// Returns the source text of the operator expression, in parentheses.
+func deparseAExpr(n *AExpr) string {
+	switch n.Kind {
+	case AEXPR_OP:
+		if n.Lexpr == nil {
+			return "(" + n.Name + " " + DeparseExpr(n.Rexpr) + ")"
+		}
+		return "(" + DeparseExpr(n.Lexpr) + " " + n.Name + " " + DeparseExpr(n.Rexpr) + ")"
+	case AEXPR_AND:
+		return "(" + DeparseExpr(n.Lexpr) + " AND " + DeparseExpr(n.Rexpr) + ")"
+	case AEXPR_OR:
+		return "(" + DeparseExpr(n.Lexpr) + " OR " + DeparseExpr(n.Rexpr) + ")"
+	case AEXPR_NOT:
+		return "(NOT " + DeparseExpr(n.Rexpr) + ")"
+	case AEXPR_IS_NULL:
+		return "(" + DeparseExpr(n.Lexpr) + " IS NULL)"
+	case AEXPR_IS_NOT_NULL:
+		return "(" + DeparseExpr(n.Lexpr) + " IS NOT NULL)"
+	case AEXPR_BETWEEN, AEXPR_NOT_BETWEEN:
+		bounds := n.Rexpr.([]Node)
+		op := " BETWEEN "
+		if n.Kind == AEXPR_NOT_BETWEEN {
+			op = " NOT BETWEEN "
+		}
+		return "(" + DeparseExpr(n.Lexpr) + op + DeparseExpr(bounds[0]) + " AND " + DeparseExpr(bounds[1]) + ")"
+	case AEXPR_IN:
+		op := " IN "
+		if n.Name == "<>" {
+			op = " NOT IN "
+		}
+		return "(" + DeparseExpr(n.Lexpr) + op + "(" + deparseExprList(n.Rexpr.([]Node)) + "))"
+	}
+	panic("unknown node type")
+}
+
+func deparseExprList(nodes []Node) string {
+	texts := make([]string, len(nodes))
+	for i, node := range nodes {
+		texts[i] = DeparseExpr(node)
+	}
+	return strings.Join(texts, ", ")
+}
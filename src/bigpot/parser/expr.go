@@ -0,0 +1,510 @@
+package parser
+
+import (
+	"strings"
+
+	"bigpot/access"
+	"bigpot/system"
+)
+
+// Transforms a raw expression into a typed one, as postgres'
+// transformExpr, resolving column references against the namespace and
+// operators by the types of their arguments.
+func (parser *ParserImpl) transformExpr(node Node) (Expr, error) {
+	switch n := node.(type) {
+	case *ColumnRef:
+		return parser.transformColumnRef(n)
+	case *AConst:
+		return makeConst(n)
+	case *TypeCast:
+		return parser.transformTypeCast(n)
+	case *FuncCall:
+		return parser.transformFuncCall(n)
+	case *ACase:
+		return parser.transformCaseExpr(n)
+	case *ACoalesce:
+		return parser.transformCoalesceExpr(n)
+	case *ASubLink:
+		return parser.transformSubLink(n)
+	case *AExpr:
+		switch n.Kind {
+		case AEXPR_OP:
+			return parser.transformAExprOp(n)
+		case AEXPR_AND:
+			return parser.transformBoolExpr(AND_EXPR, "AND", n.Lexpr, n.Rexpr)
+		case AEXPR_OR:
+			return parser.transformBoolExpr(OR_EXPR, "OR", n.Lexpr, n.Rexpr)
+		case AEXPR_NOT:
+			return parser.transformBoolExpr(NOT_EXPR, "NOT", n.Rexpr)
+		case AEXPR_IS_NULL:
+			return parser.transformNullTest(n.Lexpr, IS_NULL)
+		case AEXPR_IS_NOT_NULL:
+			return parser.transformNullTest(n.Lexpr, IS_NOT_NULL)
+		case AEXPR_BETWEEN, AEXPR_NOT_BETWEEN:
+			return parser.transformAExprBetween(n)
+		case AEXPR_IN:
+			return parser.transformAExprIn(n)
+		}
+	}
+	return nil, parseError("unknown node type")
+}
+
+// Resolves a column reference, as postgres' transformColumnRef.  A bare
+// column is looked for in all the FROM items, and must be in one only;
+// tab.col and schema.tab.col in the FROM item of that name.  Those of the
+// query come first, and then those of the queries it is in, from the
+// nearest, whose columns are Vars of the levels up they are.
+func (parser *ParserImpl) transformColumnRef(colref *ColumnRef) (Expr, error) {
+	if parser.exprKind == exprKindColumnDefault {
+		return nil, system.Ereport(system.InvalidColumnReference,
+			"cannot use column reference in DEFAULT expression")
+	}
+	if colref.star {
+		return nil, system.Ereport(system.FeatureNotSupported,
+			"row expansion via \"*\" is not supported here")
+	}
+	fields := colref.fields
+	colname := fields[len(fields)-1]
+	if len(fields) == 1 {
+		for levelsUp, pstate := 0, parser; pstate != nil; levelsUp, pstate = levelsUp+1, pstate.parent {
+			variable, err := pstate.colNameToVar(colname)
+			if err != nil {
+				return nil, err
+			} else if variable != nil {
+				return incrementVarSublevelsUp(variable, levelsUp), nil
+			}
+		}
+		return nil, system.Ereport(system.UndefinedColumn,
+			"column \"%s\" does not exist", colname)
+	}
+
+	rte, levelsUp, err := parser.refnameRangeTblEntry(fields[:len(fields)-1])
+	if err != nil {
+		return nil, err
+	}
+	variable, err := parser.ancestor(levelsUp).scanRTEForColumn(rte, colname)
+	if err != nil {
+		return nil, err
+	} else if variable == nil {
+		return nil, system.Ereport(system.UndefinedColumn, "column %s.%s does not exist",
+			rte.RefAlias.AliasName, colname)
+	}
+	return incrementVarSublevelsUp(variable, levelsUp), nil
+}
+
+// Returns the Var of the column of the name in the FROM items of the
+// query, which must be in one only, or nil if there is none, as postgres'
+// colNameToVar does for a level.
+func (parser *ParserImpl) colNameToVar(colname string) (Expr, error) {
+	var variable Expr
+	for _, item := range parser.namespace {
+		if !item.colsVisible {
+			continue
+		}
+		found, err := parser.scanRTEForColumn(item.rte, colname)
+		if err != nil {
+			return nil, err
+		} else if found == nil {
+			continue
+		} else if variable != nil {
+			return nil, system.Ereport(system.AmbiguousColumn,
+				"column reference \"%s\" is ambiguous", colname)
+		}
+		variable = found
+	}
+	return variable, nil
+}
+
+// Returns a copy of the expression whose Vars refer to the number of
+// levels further up, as postgres' IncrementVarSublevelsUp, for a column
+// of an outer query found by the parser of that query.
+func incrementVarSublevelsUp(expr Expr, levelsUp int) Expr {
+	if levelsUp == 0 {
+		return expr
+	}
+	return MutateExpr(expr, func(e Expr) Expr {
+		if v, ok := e.(*Var); ok {
+			incremented := *v
+			incremented.VarLevelsUp += uint16(levelsUp)
+			return &incremented
+		}
+		return nil
+	})
+}
+
+// Returns the FROM item the qualifier names, and the number of levels up
+// its query is, as postgres' refnameRangeTblEntry.  tab is matched
+// against the names the items are referred to by; schema.tab against the
+// relations that are not aliased.
+func (parser *ParserImpl) refnameRangeTblEntry(qualifier []string) (*RangeTblEntry, int, error) {
+	if len(qualifier) > 2 {
+		return nil, 0, system.Ereport(system.SyntaxError,
+			"improper qualified name (too many dotted names): %s",
+			strings.Join(qualifier, "."))
+	}
+	refname := qualifier[len(qualifier)-1]
+	relid := system.InvalidOid
+	if len(qualifier) == 2 {
+		var err error
+		relid, err = access.RangeVarGetRelid(system.Name(qualifier[0]),
+			system.Name(refname), parser.syscache)
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+	for levelsUp, pstate := 0, parser; pstate != nil; levelsUp, pstate = levelsUp+1, pstate.parent {
+		for _, item := range pstate.namespace {
+			rte := item.rte
+			if !item.relVisible || rte.RefAlias.AliasName != refname {
+				continue
+			} else if len(qualifier) == 1 || (rte.RteType == RTE_RELATION && rte.RelId == relid) {
+				return rte, levelsUp, nil
+			}
+		}
+	}
+	return nil, 0, system.Ereport(system.UndefinedTable,
+		"missing FROM-clause entry for table \"%s\"", refname)
+}
+
+// Returns the Var of the column of the FROM item, or nil if it has no
+// column of the name, as postgres' scanRTEForColumn.
+func (parser *ParserImpl) scanRTEForColumn(rte *RangeTblEntry, colname string) (Expr, error) {
+	/* TODO: use map instead of linear search? */
+	attidx := -1
+	for i, attname := range rte.RefAlias.ColumnNames {
+		if attname != colname {
+			continue
+		} else if attidx >= 0 {
+			return nil, system.Ereport(system.AmbiguousColumn,
+				"column reference \"%s\" is ambiguous", colname)
+		}
+		attidx = i
+	}
+	if attidx < 0 {
+		// a system column, as postgres' specialAttNum
+		if colname == "ctid" && rte.RteType == RTE_RELATION {
+			return makeCtidVar(parser.rtindex(rte)), nil
+		}
+		return nil, nil
+	}
+	return parser.makeVar(rte, attidx)
+}
+
+// Makes the Var of the column of the range table entry, its index from 0.
+// A column of a join is the expression of it over the tables joined, as
+// postgres' flatten_join_alias_vars makes of it.
+func (parser *ParserImpl) makeVar(rte *RangeTblEntry, attidx int) (Expr, error) {
+	if rte.RteType == RTE_JOIN {
+		return rte.JoinAliasVars[attidx], nil
+	}
+	return MakeVar(uint16(parser.rtindex(rte)), system.AttrNumber(attidx+1), rte.ColTypes[attidx]), nil
+}
+
+// Makes the Const of a literal, as postgres' make_const.  An integer is
+// int4, or float8 if it does not fit, where postgres makes a numeric of
+// it; a float is float8, and a string or NULL is of unknown type.
+func makeConst(con *AConst) (Expr, error) {
+	switch con.Kind {
+	case ConstInteger:
+		if con.Ival == int(int32(con.Ival)) {
+			return &Const{ExprImpl{system.Int4Type}, system.Int4(con.Ival)}, nil
+		}
+		return &Const{ExprImpl{system.Float8Type}, system.Float8(con.Ival)}, nil
+	case ConstFloat:
+		value, err := system.DatumFromString(con.Str, system.Float8Type)
+		if err != nil {
+			return nil, err
+		}
+		return &Const{ExprImpl{system.Float8Type}, value}, nil
+	case ConstString:
+		return &Const{ExprImpl{system.UnknownType}, system.Text(con.Str)}, nil
+	}
+	return &Const{ExprImpl{system.UnknownType}, nil}, nil
+}
+
+func (parser *ParserImpl) transformTypeCast(tc *TypeCast) (Expr, error) {
+	arg, err := parser.transformExpr(tc.Arg)
+	if err != nil {
+		return nil, err
+	}
+	typid, err := TypenameTypeId(tc.TypeName, parser.syscache)
+	if err != nil {
+		return nil, err
+	}
+	return parser.coerceType(arg, typid, true)
+}
+
+func (parser *ParserImpl) transformAExprOp(a *AExpr) (Expr, error) {
+	var left Expr
+	if a.Lexpr != nil {
+		var err error
+		if left, err = parser.transformExpr(a.Lexpr); err != nil {
+			return nil, err
+		}
+	}
+	right, err := parser.transformExpr(a.Rexpr)
+	if err != nil {
+		return nil, err
+	}
+	return parser.makeOpExpr(a.Name, left, right)
+}
+
+// Makes the BoolExpr of the arguments, each of which must be boolean.
+// Nested ANDs and ORs are flattened into one, as postgres' parser does for
+// a AND b AND c.
+func (parser *ParserImpl) transformBoolExpr(boolop BoolExprType, opname string,
+	rawArgs ...Node) (Expr, error) {
+	expr := &BoolExpr{ExprImpl: ExprImpl{system.BoolType}, BoolOp: boolop}
+	for _, rawArg := range rawArgs {
+		arg, err := parser.transformExpr(rawArg)
+		if err != nil {
+			return nil, err
+		}
+		if arg, err = parser.coerceToBoolean(arg, opname); err != nil {
+			return nil, err
+		}
+		if sub, ok := arg.(*BoolExpr); ok && boolop != NOT_EXPR && sub.BoolOp == boolop {
+			expr.Args = append(expr.Args, sub.Args...)
+		} else {
+			expr.Args = append(expr.Args, arg)
+		}
+	}
+	return expr, nil
+}
+
+func (parser *ParserImpl) transformNullTest(rawArg Node, nulltesttype NullTestType) (Expr, error) {
+	arg, err := parser.transformExpr(rawArg)
+	if err != nil {
+		return nil, err
+	}
+	return &NullTest{ExprImpl: ExprImpl{system.BoolType}, Arg: arg, NullTestType: nulltesttype}, nil
+}
+
+// Expands x BETWEEN a AND b into x >= a AND x <= b, and NOT BETWEEN into
+// x < a OR x > b, as postgres' transformAExprBetween.
+func (parser *ParserImpl) transformAExprBetween(a *AExpr) (Expr, error) {
+	bounds := a.Rexpr.([]Node)
+	if a.Kind == AEXPR_BETWEEN {
+		return parser.transformExpr(&AExpr{
+			Kind:  AEXPR_AND,
+			Lexpr: &AExpr{Kind: AEXPR_OP, Name: ">=", Lexpr: a.Lexpr, Rexpr: bounds[0]},
+			Rexpr: &AExpr{Kind: AEXPR_OP, Name: "<=", Lexpr: a.Lexpr, Rexpr: bounds[1]},
+		})
+	}
+	return parser.transformExpr(&AExpr{
+		Kind:  AEXPR_OR,
+		Lexpr: &AExpr{Kind: AEXPR_OP, Name: "<", Lexpr: a.Lexpr, Rexpr: bounds[0]},
+		Rexpr: &AExpr{Kind: AEXPR_OP, Name: ">", Lexpr: a.Lexpr, Rexpr: bounds[1]},
+	})
+}
+
+// Expands x IN (a, b) into x = a OR x = b, and NOT IN into x <> a AND
+// x <> b, as postgres' transformAExprIn does for values that are not
+// constants.
+func (parser *ParserImpl) transformAExprIn(a *AExpr) (Expr, error) {
+	left, err := parser.transformExpr(a.Lexpr)
+	if err != nil {
+		return nil, err
+	}
+	boolop := OR_EXPR
+	if a.Name == "<>" {
+		boolop = AND_EXPR
+	}
+	var args []Expr
+	for _, rawValue := range a.Rexpr.([]Node) {
+		value, err := parser.transformExpr(rawValue)
+		if err != nil {
+			return nil, err
+		}
+		cmp, err := parser.makeOpExpr(a.Name, left, value)
+		if err != nil {
+			return nil, err
+		}
+		if cmp, err = parser.coerceToBoolean(cmp, "IN"); err != nil {
+			return nil, err
+		}
+		args = append(args, cmp)
+	}
+	if len(args) == 1 {
+		return args[0], nil
+	}
+	return &BoolExpr{ExprImpl: ExprImpl{system.BoolType}, BoolOp: boolop, Args: args}, nil
+}
+
+// Transforms a subquery within an expression, as postgres'
+// transformSubLink.  The subquery sees the columns of the query as those
+// of an outer query.  An EXPR_SUBLINK is of the type of the single column
+// of the subquery, and the others are boolean; the Testexpr of an
+// ANY_SUBLINK compares the left expression with the column of each row,
+// a Param, by the operator.
+func (parser *ParserImpl) transformSubLink(sublink *ASubLink) (Expr, error) {
+	if parser.exprKind == exprKindColumnDefault {
+		return nil, system.Ereport(system.FeatureNotSupported, "cannot use subquery in DEFAULT expression")
+	}
+	subquery, err := parser.newSubParser().transformSelectStmt(sublink.Subselect)
+	if err != nil {
+		return nil, err
+	}
+	var cols []*TargetEntry
+	for _, tle := range subquery.TargetList {
+		if !tle.ResJunk {
+			cols = append(cols, tle)
+		}
+	}
+	expr := &SubLink{ExprImpl: ExprImpl{system.BoolType}, SubLinkType: sublink.SubLinkType,
+		Subselect: subquery}
+	switch sublink.SubLinkType {
+	case EXPR_SUBLINK:
+		if len(cols) != 1 {
+			return nil, system.Ereport(system.SyntaxError, "subquery must return only one column")
+		}
+		expr.resultType = cols[0].Expr.ResultType()
+	case ANY_SUBLINK:
+		if len(cols) != 1 {
+			return nil, system.Ereport(system.SyntaxError, "subquery has too many columns")
+		}
+		left, err := parser.transformExpr(sublink.Testexpr)
+		if err != nil {
+			return nil, err
+		}
+		param := MakeParam(PARAM_SUBLINK, 1, cols[0].Expr.ResultType())
+		cmp, err := parser.makeOpExpr(sublink.OperName, left, param)
+		if err != nil {
+			return nil, err
+		}
+		if expr.Testexpr, err = parser.coerceToBoolean(cmp, "IN"); err != nil {
+			return nil, err
+		}
+	}
+	return expr, nil
+}
+
+func (parser *ParserImpl) transformExprList(nodes []Node) ([]Expr, error) {
+	exprs := make([]Expr, len(nodes))
+	for i, node := range nodes {
+		var err error
+		if exprs[i], err = parser.transformExpr(node); err != nil {
+			return nil, err
+		}
+	}
+	return exprs, nil
+}
+
+// Resolves the function by the types of its arguments, as postgres'
+// ParseFuncOrColumn.  An aggregate makes an Aggref; agg(*) and
+// agg(DISTINCT ...) are for aggregates only.
+func (parser *ParserImpl) transformFuncCall(fn *FuncCall) (Expr, error) {
+	args, err := parser.transformExprList(fn.Args)
+	if err != nil {
+		return nil, err
+	}
+	argTypes := make([]system.Oid, len(args))
+	for i, arg := range args {
+		argTypes[i] = arg.ResultType()
+	}
+	proc, err := funcSelect(fn.FuncName, argTypes)
+	if err != nil {
+		return nil, err
+	}
+	for i := range args {
+		if args[i], err = parser.coerceType(args[i], proc.ArgTypes[i], false); err != nil {
+			return nil, err
+		}
+	}
+	if agg := system.LookupAggregate(proc); agg != nil {
+		return parser.transformAggregateCall(fn, agg, args)
+	} else if fn.AggStar {
+		return nil, system.Ereport(system.WrongObjectType,
+			"%s(*) specified, but %s is not an aggregate function", fn.FuncName, fn.FuncName)
+	} else if fn.AggDistinct {
+		return nil, system.Ereport(system.WrongObjectType,
+			"DISTINCT specified, but %s is not an aggregate function", fn.FuncName)
+	}
+	return &FuncExpr{ExprImpl: ExprImpl{proc.RetType}, Func: proc, Args: args}, nil
+}
+
+// Transforms a CASE, as postgres' transformCaseExpr.  The WHEN values of
+// CASE x are compared with x by =, and the results are coerced to their
+// common type.
+func (parser *ParserImpl) transformCaseExpr(c *ACase) (Expr, error) {
+	expr := &CaseExpr{}
+	var placeholder Expr
+	if c.Arg != nil {
+		arg, err := parser.transformExpr(c.Arg)
+		if err != nil {
+			return nil, err
+		}
+		if arg.ResultType() == system.UnknownType {
+			if arg, err = parser.coerceType(arg, system.TextType, false); err != nil {
+				return nil, err
+			}
+		}
+		expr.Arg = arg
+		placeholder = &CaseTestExpr{ExprImpl{arg.ResultType()}}
+	}
+
+	var results []Expr
+	for _, when := range c.Whens {
+		cond, err := parser.transformExpr(when.Expr)
+		if err != nil {
+			return nil, err
+		}
+		if placeholder != nil {
+			if cond, err = parser.makeOpExpr("=", placeholder, cond); err != nil {
+				return nil, err
+			}
+		}
+		if cond, err = parser.coerceToBoolean(cond, "CASE/WHEN"); err != nil {
+			return nil, err
+		}
+		result, err := parser.transformExpr(when.Result)
+		if err != nil {
+			return nil, err
+		}
+		expr.Args = append(expr.Args, &CaseWhen{Expr: cond, Result: result})
+		results = append(results, result)
+	}
+	if c.DefResult != nil {
+		var err error
+		if expr.DefResult, err = parser.transformExpr(c.DefResult); err != nil {
+			return nil, err
+		}
+	} else {
+		expr.DefResult = &Const{ExprImpl{system.UnknownType}, nil}
+	}
+	results = append(results, expr.DefResult)
+
+	typid, err := selectCommonType(results, "CASE")
+	if err != nil {
+		return nil, err
+	}
+	expr.resultType = typid
+	for _, when := range expr.Args {
+		if when.Result, err = parser.coerceType(when.Result, typid, false); err != nil {
+			return nil, err
+		}
+	}
+	if expr.DefResult, err = parser.coerceType(expr.DefResult, typid, false); err != nil {
+		return nil, err
+	}
+	return expr, nil
+}
+
+// Transforms a COALESCE, as postgres' transformCoalesceExpr, coercing the
+// arguments to their common type.
+func (parser *ParserImpl) transformCoalesceExpr(c *ACoalesce) (Expr, error) {
+	args, err := parser.transformExprList(c.Args)
+	if err != nil {
+		return nil, err
+	}
+	typid, err := selectCommonType(args, "COALESCE")
+	if err != nil {
+		return nil, err
+	}
+	for i := range args {
+		if args[i], err = parser.coerceType(args[i], typid, false); err != nil {
+			return nil, err
+		}
+	}
+	return &CoalesceExpr{ExprImpl: ExprImpl{typid}, Args: args}, nil
+}
@@ -4,6 +4,7 @@ package parser
 
 import (
 	"fmt"
+	"strings"
 
 	"bigpot/system"
 )
@@ -17,13 +18,30 @@ type ResTarget struct {
 	val		Node
 }
 
+/*
+ * A column written as col, tab.col or schema.tab.col, or tab.* or * if
+ * star is set.
+ */
 type ColumnRef struct {
-	name	string
+	fields	[]string
+	star	bool
 }
 
+/*
+ * A SELECT, or a VALUES if valuesLists is set.  havingClause, limitOffset
+ * and limitCount are nil if not given.
+ */
 type SelectStmt struct {
-	targetList []*ResTarget
-	fromList   []Node
+	distinct     bool
+	targetList   []*ResTarget
+	fromList     []Node
+	whereClause  Node
+	groupClause  []Node
+	havingClause Node
+	valuesLists  [][]Node
+	sortClause   []*SortBy
+	limitOffset  Node
+	limitCount   Node
 }
 
 var TopList []Node
@@ -35,15 +53,54 @@ var TopList []Node
 	str		string
 	ival	int
 	keyword	string
+	boolean	bool
+	behavior	DropBehavior
+	typnam	*TypeName
+	rangevar	*RangeVar
+	atcmd	*AlterTableCmd
+	strs	[]string
+	alias	*Alias
+	targets	[]*ResTarget
+	selstmt	*SelectStmt
+	target	*ResTarget
+	sortby	*SortBy
+	sortbys	[]*SortBy
 }
 
 %token
 
-%left	'-' '+'
-%left	'*' '/'
-
-%type <list> statements column_list table_list
-%type <node> statement
+%type <list> statements from_list expr_list when_clause_list values_expr_list
+%type <targets> target_list returning_clause
+%type <selstmt> SelectStmt values_clause select_with_parens
+%type <list> OptTableElementList TableElementList qualified_name_list
+%type <list> ColQualList alter_table_cmds
+%type <node> statement CreateStmt DropStmt TransactionStmt columnDef InsertStmt
+%type <node> AlterTableStmt RenameStmt ColConstraintElem where_clause
+%type <node> insert_rest UpdateStmt DeleteStmt VariableSetStmt
+%type <list> from_clause using_clause
+%type <targets> set_clause_list
+%type <target> set_clause
+%type <rangevar> relation_expr_opt_alias
+%type <node> a_expr b_expr c_expr columnref AexprConst func_expr case_expr
+%type <node> case_arg case_default when_clause in_expr values_expr
+%type <atcmd> alter_table_cmd
+%type <str> ColId ColLabel attr_name unreserved_keyword col_name_keyword
+%type <str> type_func_name_keyword reserved_keyword var_value extract_arg
+%type <strs> attrs name_list
+%type <alias> alias_clause opt_alias_clause
+%type <boolean> opt_array_bounds
+%type <behavior> opt_drop_behavior
+%type <typnam> Typename
+%type <target> target_el
+%type <rangevar> qualified_name
+%type <node> table_ref joined_table join_qual
+%type <ival> join_type opt_asc_desc opt_nulls_order
+%type <sortby> sortby
+%type <sortbys> opt_sort_clause sortby_list
+%type <list> opt_select_limit select_limit group_clause
+%type <boolean> opt_distinct
+%type <node> having_clause
+%type <node> limit_clause offset_clause select_limit_value
 
 /*
  * Non-keyword token types.  These are hard-wired into the "flex" lexer.
@@ -59,9 +116,52 @@ var TopList []Node
 %token <ival> ICONST PARAM
 %token        TYPECAST DOT_DOT COLON_EQUALS
 
-%token <keyword> FROM SELECT
+%token <keyword> ADD_P ALL ALTER AND AS ASC BEGIN_P BETWEEN BY CASCADE CASE CAST
+	COALESCE COLUMN COMMIT CREATE CROSS DATA_P DEFAULT DELETE_P DESC DISTINCT DROP
+	ELSE END_P EXISTS EXTRACT FALSE_P FIRST_P FROM FULL GROUP_P HAVING IF_P IN_P
+	INNER_P INSERT INTO IS JOIN LAST_P LEFT LIKE LIMIT NATURAL NOT NULL_P NULLS_P
+	OFFSET ON OR ORDER OUTER_P RENAME RESTRICT RETURNING RIGHT ROLLBACK SELECT SET
+	TABLE THEN TO TRANSACTION TRUE_P TYPE_P UPDATE USING VALUES WHEN WHERE WORK
+
+/*
+ * The lexer emits this first to parse an expression alone, instead of
+ * statements.
+ */
+%token MODE_EXPR
+
+/* Precedence: lowest to highest */
+%nonassoc	SET		/* see relation_expr_opt_alias */
+%left		OR
+%left		AND
+%right		NOT
+%right		'='
+%nonassoc	'<' '>'
+%nonassoc	LIKE
+%nonassoc	BETWEEN
+%nonassoc	IN_P
+%left		Op
+%nonassoc	IS NULL_P
+%left		'+' '-'
+%left		'*' '/' '%'
+%right		UMINUS
+%left		TYPECAST
+/*
+ * These make a JOIN bind to the table_ref before it, so that
+ * a JOIN b JOIN c is (a JOIN b) JOIN c, and the ON or USING of the inner
+ * joins of a JOIN b JOIN c ON ... ON ... go to the nearest JOIN.
+ */
+%left		JOIN CROSS LEFT FULL RIGHT INNER_P NATURAL
 
 %%
+parse_toplevel: statements
+	{
+		TopList = $1
+	}
+		| MODE_EXPR a_expr
+	{
+		TopList = []Node{$2}
+	}
+
 statements: /* empty */
 	{
 		$$ = nil
@@ -69,51 +169,1273 @@ statements: /* empty */
 		| statement
 	{
 		$$ = append(make([]Node, 0), $1)
-		TopList = $$
 	}
 		| statements ';' statement
 	{
 		$$ = append($1, $3)
-		TopList = $$
+	}
+		| statements ';'
+	{
+		$$ = $1
 	}
 ;
 
-statement: SELECT column_list FROM table_list
+statement: SelectStmt
+	{
+		$$ = $1
+	}
+		| InsertStmt
+		| UpdateStmt
+		| DeleteStmt
+		| CreateStmt
+		| DropStmt
+		| AlterTableStmt
+		| RenameStmt
+		| TransactionStmt
+		| VariableSetStmt
+;
+
+SelectStmt: SELECT opt_distinct target_list FROM from_list where_clause
+			group_clause having_clause opt_sort_clause opt_select_limit
 	{
-		target := make([]*ResTarget, len($2), len($2))
-		for i, elem := range $2 {
-			target[i] = elem.(*ResTarget)
-		}
 		$$ = &SelectStmt{
-			targetList: target,
-			fromList: $4,
+			distinct: $2,
+			targetList: $3,
+			fromList: $5,
+			whereClause: $6,
+			groupClause: $7,
+			havingClause: $8,
+			sortClause: $9,
 		}
+		if $10 != nil {
+			$$.limitOffset, $$.limitCount = $10[0], $10[1]
+		}
+	}
+
+/*
+ * A subquery, as postgres' select_with_parens.
+ */
+select_with_parens: '(' SelectStmt ')'
+	{
+		$$ = $2
+	}
+
+opt_distinct: DISTINCT
+	{
+		$$ = true
+	}
+		| ALL
+	{
+		$$ = false
+	}
+		| /* empty */
+	{
+		$$ = false
+	}
+
+values_clause: VALUES '(' values_expr_list ')'
+	{
+		$$ = &SelectStmt{valuesLists: [][]Node{$3}}
+	}
+		| values_clause ',' '(' values_expr_list ')'
+	{
+		$1.valuesLists = append($1.valuesLists, $4)
+		$$ = $1
 	}
 
-column_list: IDENT
+/*
+ * The values of a row of VALUES, any of which may be DEFAULT, as postgres'
+ * ctext_expr_list.
+ */
+values_expr_list: values_expr
 	{
-		ref := &ColumnRef{name: $1}
-		n := &ResTarget{name: $1, val: Node(ref)}
-		$$ = append(make([]Node, 0), Node(n))
+		$$ = []Node{$1}
 	}
-		| column_list ',' IDENT
+		| values_expr_list ',' values_expr
 	{
-		ref := &ColumnRef{name: $3}
-		n := &ResTarget{name: $3, val: Node(ref)}
-		$$ = append($1, Node(n))
+		$$ = append($1, $3)
 	}
 
-table_list: IDENT
+values_expr: a_expr
+		| DEFAULT
 	{
-		n := &RangeVar{RelationName: system.Name($1)}
-		$$ = append(make([]Node, 0), Node(n))
+		$$ = &SetToDefault{}
 	}
-		| table_list ',' IDENT
+
+target_list: target_el
 	{
-		n := &RangeVar{RelationName: system.Name($3)}
-		$$ = append($1, Node(n))
+		$$ = []*ResTarget{$1}
 	}
-%%
+		| target_list ',' target_el
+	{
+		$$ = append($1, $3)
+	}
+
+target_el: a_expr AS ColLabel
+	{
+		$$ = &ResTarget{name: $3, val: $1}
+	}
+		| a_expr IDENT
+	{
+		$$ = &ResTarget{name: $2, val: $1}
+	}
+		| a_expr
+	{
+		$$ = &ResTarget{name: figureColname($1), val: $1}
+	}
+		| '*'
+	{
+		$$ = &ResTarget{val: &ColumnRef{star: true}}
+	}
+
+from_list: table_ref
+	{
+		$$ = []Node{$1}
+	}
+		| from_list ',' table_ref
+	{
+		$$ = append($1, $3)
+	}
+
+table_ref: qualified_name opt_alias_clause
+	{
+		$1.Alias = $2
+		$$ = $1
+	}
+		| select_with_parens opt_alias_clause
+	{
+		$$ = &RangeSubselect{Subquery: $1, Alias: $2}
+	}
+		| joined_table
+		| '(' joined_table ')' alias_clause
+	{
+		$2.(*RangeJoin).Alias = $4
+		$$ = $2
+	}
+
+/*
+ * The JOINs of the FROM clause, as postgres' joined_table.  A
+ * parenthesized join with an alias is a table_ref.
+ */
+joined_table: '(' joined_table ')'
+	{
+		$$ = $2
+	}
+		| table_ref CROSS JOIN table_ref
+	{
+		$$ = &RangeJoin{JoinType: JOIN_INNER, Larg: $1, Rarg: $4}
+	}
+		| table_ref join_type JOIN table_ref join_qual
+	{
+		$$ = makeRangeJoin(JoinType($2), false, $1, $4, $5)
+	}
+		| table_ref JOIN table_ref join_qual
+	{
+		$$ = makeRangeJoin(JOIN_INNER, false, $1, $3, $4)
+	}
+		| table_ref NATURAL join_type JOIN table_ref
+	{
+		$$ = makeRangeJoin(JoinType($3), true, $1, $5, nil)
+	}
+		| table_ref NATURAL JOIN table_ref
+	{
+		$$ = makeRangeJoin(JOIN_INNER, true, $1, $4, nil)
+	}
+
+join_type: FULL opt_outer
+	{
+		$$ = int(JOIN_FULL)
+	}
+		| LEFT opt_outer
+	{
+		$$ = int(JOIN_LEFT)
+	}
+		| RIGHT opt_outer
+	{
+		$$ = int(JOIN_RIGHT)
+	}
+		| INNER_P
+	{
+		$$ = int(JOIN_INNER)
+	}
+
+opt_outer: OUTER_P
+		| /* empty */
+
+/*
+ * USING gives the list of the column names, and ON the condition.
+ */
+join_qual: USING '(' name_list ')'
+	{
+		$$ = $3
+	}
+		| ON a_expr
+	{
+		$$ = $2
+	}
+
+/*
+ * [AS] alias [(column, ...)]
+ */
+alias_clause: AS ColId '(' name_list ')'
+	{
+		$$ = &Alias{AliasName: $2, ColumnNames: $4}
+	}
+		| AS ColId
+	{
+		$$ = &Alias{AliasName: $2}
+	}
+		| ColId '(' name_list ')'
+	{
+		$$ = &Alias{AliasName: $1, ColumnNames: $3}
+	}
+		| ColId
+	{
+		$$ = &Alias{AliasName: $1}
+	}
+
+opt_alias_clause: alias_clause
+		| /* empty */
+	{
+		$$ = nil
+	}
+
+name_list: ColId
+	{
+		$$ = []string{$1}
+	}
+		| name_list ',' ColId
+	{
+		$$ = append($1, $3)
+	}
+
+/*
+ * INSERT INTO table [(column, ...)] {SELECT ... | VALUES ...} [RETURNING ...]
+ * INSERT INTO table DEFAULT VALUES [RETURNING ...]
+ */
+InsertStmt: INSERT INTO qualified_name insert_rest returning_clause
+	{
+		n := $4.(*InsertStmt)
+		n.Relation = $3
+		n.ReturningList = $5
+		$$ = n
+	}
+
+insert_rest: SelectStmt
+	{
+		$$ = &InsertStmt{SelectStmt: $1}
+	}
+		| values_clause
+	{
+		$$ = &InsertStmt{SelectStmt: $1}
+	}
+		| '(' name_list ')' SelectStmt
+	{
+		$$ = &InsertStmt{Cols: $2, SelectStmt: $4}
+	}
+		| '(' name_list ')' values_clause
+	{
+		$$ = &InsertStmt{Cols: $2, SelectStmt: $4}
+	}
+		| DEFAULT VALUES
+	{
+		/* a row of no values, the columns all given their defaults */
+		$$ = &InsertStmt{SelectStmt: &SelectStmt{valuesLists: [][]Node{{}}}}
+	}
+
+/*
+ * UPDATE table [[AS] alias] SET column = value, ... [FROM ...] [WHERE ...]
+ *		[RETURNING ...]
+ */
+UpdateStmt: UPDATE relation_expr_opt_alias SET set_clause_list from_clause
+			where_clause returning_clause
+	{
+		$$ = &UpdateStmt{
+			Relation: $2,
+			TargetList: $4,
+			FromClause: $5,
+			WhereClause: $6,
+			ReturningList: $7,
+		}
+	}
+
+set_clause_list: set_clause
+	{
+		$$ = []*ResTarget{$1}
+	}
+		| set_clause_list ',' set_clause
+	{
+		$$ = append($1, $3)
+	}
+
+set_clause: ColId '=' a_expr
+	{
+		$$ = &ResTarget{name: $1, val: $3}
+	}
+
+from_clause: FROM from_list
+	{
+		$$ = $2
+	}
+		| /* empty */
+	{
+		$$ = nil
+	}
+
+/*
+ * DELETE FROM table [[AS] alias] [USING ...] [WHERE ...] [RETURNING ...]
+ */
+DeleteStmt: DELETE_P FROM relation_expr_opt_alias using_clause where_clause
+			returning_clause
+	{
+		$$ = &DeleteStmt{
+			Relation: $3,
+			UsingClause: $4,
+			WhereClause: $5,
+			ReturningList: $6,
+		}
+	}
+
+using_clause: USING from_list
+	{
+		$$ = $2
+	}
+		| /* empty */
+	{
+		$$ = nil
+	}
+
+/*
+ * The table of an UPDATE or DELETE, with an alias but no column aliases.
+ * As SET is not reserved, UPDATE t SET ... would read SET as the alias;
+ * the precedence of the first rule makes it the end of the table instead.
+ */
+relation_expr_opt_alias: qualified_name %prec UMINUS
+		| qualified_name ColId
+	{
+		$1.Alias = &Alias{AliasName: $2}
+		$$ = $1
+	}
+		| qualified_name AS ColId
+	{
+		$1.Alias = &Alias{AliasName: $3}
+		$$ = $1
+	}
+
+returning_clause: RETURNING target_list
+	{
+		$$ = $2
+	}
+		| /* empty */
+	{
+		$$ = nil
+	}
+
+where_clause: WHERE a_expr
+	{
+		$$ = $2
+	}
+		| /* empty */
+	{
+		$$ = nil
+	}
+
+group_clause: GROUP_P BY expr_list
+	{
+		$$ = $3
+	}
+		| /* empty */
+	{
+		$$ = nil
+	}
+
+having_clause: HAVING a_expr
+	{
+		$$ = $2
+	}
+		| /* empty */
+	{
+		$$ = nil
+	}
+
+opt_sort_clause: ORDER BY sortby_list
+	{
+		$$ = $3
+	}
+		| /* empty */
+	{
+		$$ = nil
+	}
+
+sortby_list: sortby
+	{
+		$$ = []*SortBy{$1}
+	}
+		| sortby_list ',' sortby
+	{
+		$$ = append($1, $3)
+	}
+
+sortby: a_expr opt_asc_desc opt_nulls_order
+	{
+		$$ = &SortBy{Node: $1, SortbyDir: SortByDir($2), SortbyNulls: SortByNulls($3)}
+	}
+
+opt_asc_desc: ASC
+	{
+		$$ = int(SORTBY_ASC)
+	}
+		| DESC
+	{
+		$$ = int(SORTBY_DESC)
+	}
+		| /* empty */
+	{
+		$$ = int(SORTBY_DEFAULT)
+	}
+
+opt_nulls_order: NULLS_P FIRST_P
+	{
+		$$ = int(SORTBY_NULLS_FIRST)
+	}
+		| NULLS_P LAST_P
+	{
+		$$ = int(SORTBY_NULLS_LAST)
+	}
+		| /* empty */
+	{
+		$$ = int(SORTBY_NULLS_DEFAULT)
+	}
+
+/*
+ * The OFFSET and the LIMIT, in this order, either nil if not given.
+ */
+opt_select_limit: select_limit
+		| /* empty */
+	{
+		$$ = nil
+	}
+
+select_limit: limit_clause offset_clause
+	{
+		$$ = []Node{$2, $1}
+	}
+		| offset_clause limit_clause
+	{
+		$$ = []Node{$1, $2}
+	}
+		| limit_clause
+	{
+		$$ = []Node{nil, $1}
+	}
+		| offset_clause
+	{
+		$$ = []Node{$1, nil}
+	}
+
+limit_clause: LIMIT select_limit_value
+	{
+		$$ = $2
+	}
+
+offset_clause: OFFSET a_expr
+	{
+		$$ = $2
+	}
+
+/* LIMIT ALL is LIMIT NULL, no limit */
+select_limit_value: a_expr
+		| ALL
+	{
+		$$ = &AConst{Kind: ConstNull}
+	}
+
+/*
+ * CREATE TABLE relname (column type [NOT NULL], ...)
+ */
+CreateStmt: CREATE TABLE qualified_name '(' OptTableElementList ')'
+	{
+		n := &CreateStmt{Relation: $3}
+		for _, elt := range $5 {
+			n.TableElts = append(n.TableElts, elt.(*ColumnDef))
+		}
+		$$ = n
+	}
+
+OptTableElementList: TableElementList
+		| /* empty */
+	{
+		$$ = nil
+	}
+
+TableElementList: columnDef
+	{
+		$$ = []Node{$1}
+	}
+		| TableElementList ',' columnDef
+	{
+		$$ = append($1, $3)
+	}
+
+columnDef: ColId Typename ColQualList
+	{
+		$$ = columnDef($1, $2, $3, yylex)
+	}
+
+ColQualList: ColQualList ColConstraintElem
+	{
+		$$ = append($1, $2)
+	}
+		| /* empty */
+	{
+		$$ = nil
+	}
+
+ColConstraintElem: NOT NULL_P
+	{
+		$$ = &Constraint{Contype: ConstrNotNull}
+	}
+		| NULL_P
+	{
+		$$ = &Constraint{Contype: ConstrNull}
+	}
+		| DEFAULT b_expr
+	{
+		$$ = &Constraint{Contype: ConstrDefault, RawExpr: $2}
+	}
+
+Typename: IDENT opt_array_bounds
+	{
+		$$ = &TypeName{Name: $1, IsArray: $2}
+	}
+
+opt_array_bounds: '[' ']'
+	{
+		$$ = true
+	}
+		| /* empty */
+	{
+		$$ = false
+	}
+
+/*
+ * DROP TABLE [IF EXISTS] relname [, ...] [CASCADE | RESTRICT]
+ */
+DropStmt: DROP TABLE qualified_name_list opt_drop_behavior
+	{
+		$$ = dropStmt($3, false, $4)
+	}
+		| DROP TABLE IF_P EXISTS qualified_name_list opt_drop_behavior
+	{
+		$$ = dropStmt($5, true, $6)
+	}
+
+opt_drop_behavior: CASCADE
+	{
+		$$ = DropCascade
+	}
+		| RESTRICT
+	{
+		$$ = DropRestrict
+	}
+		| /* empty */
+	{
+		$$ = DropRestrict
+	}
+
+qualified_name_list: qualified_name
+	{
+		$$ = []Node{$1}
+	}
+		| qualified_name_list ',' qualified_name
+	{
+		$$ = append($1, $3)
+	}
+
+qualified_name: ColId
+	{
+		$$ = &RangeVar{RelationName: system.Name($1)}
+	}
+		| ColId '.' ColId
+	{
+		$$ = &RangeVar{SchemaName: system.Name($1), RelationName: system.Name($3)}
+	}
+
+/*
+ * ALTER TABLE [IF EXISTS] relname
+ *     ADD [COLUMN] column type [DEFAULT expr] [NOT NULL]
+ *     DROP [COLUMN] [IF EXISTS] column [CASCADE | RESTRICT]
+ *     ALTER [COLUMN] column [SET DATA] TYPE type
+ */
+AlterTableStmt: ALTER TABLE qualified_name alter_table_cmds
+	{
+		$$ = alterTableStmt($3, $4, false)
+	}
+		| ALTER TABLE IF_P EXISTS qualified_name alter_table_cmds
+	{
+		$$ = alterTableStmt($5, $6, true)
+	}
+
+alter_table_cmds: alter_table_cmd
+	{
+		$$ = []Node{$1}
+	}
+		| alter_table_cmds ',' alter_table_cmd
+	{
+		$$ = append($1, $3)
+	}
+
+alter_table_cmd: ADD_P columnDef
+	{
+		$$ = &AlterTableCmd{Subtype: AT_AddColumn, Def: $2.(*ColumnDef)}
+	}
+		| ADD_P COLUMN columnDef
+	{
+		$$ = &AlterTableCmd{Subtype: AT_AddColumn, Def: $3.(*ColumnDef)}
+	}
+		| DROP opt_column IF_P EXISTS ColId opt_drop_behavior
+	{
+		$$ = &AlterTableCmd{Subtype: AT_DropColumn, Name: $5, Behavior: $6, MissingOk: true}
+	}
+		| DROP opt_column ColId opt_drop_behavior
+	{
+		$$ = &AlterTableCmd{Subtype: AT_DropColumn, Name: $3, Behavior: $4}
+	}
+		| ALTER opt_column ColId opt_set_data TYPE_P Typename
+	{
+		$$ = &AlterTableCmd{
+			Subtype: AT_AlterColumnType,
+			Name: $3,
+			Def: &ColumnDef{ColName: $3, TypeName: $6},
+		}
+	}
+
+opt_column: COLUMN
+		| /* empty */
+
+opt_set_data: SET DATA_P
+		| /* empty */
+
+/*
+ * ALTER TABLE [IF EXISTS] relname RENAME TO newname
+ * ALTER TABLE [IF EXISTS] relname RENAME [COLUMN] column TO newname
+ */
+RenameStmt: ALTER TABLE qualified_name RENAME TO ColId
+	{
+		$$ = &RenameStmt{Relation: $3, NewName: $6}
+	}
+		| ALTER TABLE IF_P EXISTS qualified_name RENAME TO ColId
+	{
+		$$ = &RenameStmt{Relation: $5, NewName: $8, MissingOk: true}
+	}
+		| ALTER TABLE qualified_name RENAME opt_column ColId TO ColId
+	{
+		$$ = &RenameStmt{Relation: $3, SubName: $6, NewName: $8}
+	}
+		| ALTER TABLE IF_P EXISTS qualified_name RENAME opt_column ColId TO ColId
+	{
+		$$ = &RenameStmt{Relation: $5, SubName: $8, NewName: $10, MissingOk: true}
+	}
+
+/*
+ * BEGIN, COMMIT and ROLLBACK
+ */
+TransactionStmt: BEGIN_P opt_transaction
+	{
+		$$ = &TransactionStmt{Kind: TransStmtBegin}
+	}
+		| COMMIT opt_transaction
+	{
+		$$ = &TransactionStmt{Kind: TransStmtCommit}
+	}
+		| ROLLBACK opt_transaction
+	{
+		$$ = &TransactionStmt{Kind: TransStmtRollback}
+	}
+
+opt_transaction: WORK
+		| TRANSACTION
+		| /* empty */
+
+/*
+ * SET name TO value, of a single value, as postgres' VariableSetStmt
+ */
+VariableSetStmt: SET ColId TO var_value
+	{
+		$$ = &VariableSetStmt{Name: $2, Value: $4}
+	}
+		| SET ColId '=' var_value
+	{
+		$$ = &VariableSetStmt{Name: $2, Value: $4}
+	}
+		| SET ColId TO DEFAULT
+	{
+		$$ = &VariableSetStmt{Name: $2, Default: true}
+	}
+		| SET ColId '=' DEFAULT
+	{
+		$$ = &VariableSetStmt{Name: $2, Default: true}
+	}
+
+var_value: SCONST
+		| ColId
+		| ICONST
+	{
+		$$ = fmt.Sprint($1)
+	}
+
+/*
+ * General expressions, as postgres' a_expr.  b_expr is the restricted
+ * form without the boolean operators, for where an AND or NOT would be
+ * ambiguous, as in BETWEEN and DEFAULT.
+ */
+a_expr: c_expr
+		| a_expr TYPECAST Typename
+	{
+		$$ = &TypeCast{Arg: $1, TypeName: $3}
+	}
+		| '+' a_expr %prec UMINUS
+	{
+		$$ = &AExpr{Kind: AEXPR_OP, Name: "+", Rexpr: $2}
+	}
+		| '-' a_expr %prec UMINUS
+	{
+		$$ = doNegate($2)
+	}
+		| a_expr '+' a_expr
+	{
+		$$ = &AExpr{Kind: AEXPR_OP, Name: "+", Lexpr: $1, Rexpr: $3}
+	}
+		| a_expr '-' a_expr
+	{
+		$$ = &AExpr{Kind: AEXPR_OP, Name: "-", Lexpr: $1, Rexpr: $3}
+	}
+		| a_expr '*' a_expr
+	{
+		$$ = &AExpr{Kind: AEXPR_OP, Name: "*", Lexpr: $1, Rexpr: $3}
+	}
+		| a_expr '/' a_expr
+	{
+		$$ = &AExpr{Kind: AEXPR_OP, Name: "/", Lexpr: $1, Rexpr: $3}
+	}
+		| a_expr '%' a_expr
+	{
+		$$ = &AExpr{Kind: AEXPR_OP, Name: "%", Lexpr: $1, Rexpr: $3}
+	}
+		| a_expr '<' a_expr
+	{
+		$$ = &AExpr{Kind: AEXPR_OP, Name: "<", Lexpr: $1, Rexpr: $3}
+	}
+		| a_expr '>' a_expr
+	{
+		$$ = &AExpr{Kind: AEXPR_OP, Name: ">", Lexpr: $1, Rexpr: $3}
+	}
+		| a_expr '=' a_expr
+	{
+		$$ = &AExpr{Kind: AEXPR_OP, Name: "=", Lexpr: $1, Rexpr: $3}
+	}
+		| a_expr Op a_expr
+	{
+		$$ = &AExpr{Kind: AEXPR_OP, Name: $2, Lexpr: $1, Rexpr: $3}
+	}
+		| Op a_expr %prec Op
+	{
+		$$ = &AExpr{Kind: AEXPR_OP, Name: $1, Rexpr: $2}
+	}
+		| a_expr AND a_expr
+	{
+		$$ = &AExpr{Kind: AEXPR_AND, Lexpr: $1, Rexpr: $3}
+	}
+		| a_expr OR a_expr
+	{
+		$$ = &AExpr{Kind: AEXPR_OR, Lexpr: $1, Rexpr: $3}
+	}
+		| NOT a_expr
+	{
+		$$ = &AExpr{Kind: AEXPR_NOT, Rexpr: $2}
+	}
+		| a_expr LIKE a_expr
+	{
+		$$ = &AExpr{Kind: AEXPR_OP, Name: "~~", Lexpr: $1, Rexpr: $3}
+	}
+		| a_expr NOT LIKE a_expr %prec LIKE
+	{
+		$$ = &AExpr{Kind: AEXPR_OP, Name: "!~~", Lexpr: $1, Rexpr: $4}
+	}
+		| a_expr IS NULL_P
+	{
+		$$ = &AExpr{Kind: AEXPR_IS_NULL, Lexpr: $1}
+	}
+		| a_expr IS NOT NULL_P
+	{
+		$$ = &AExpr{Kind: AEXPR_IS_NOT_NULL, Lexpr: $1}
+	}
+		| a_expr BETWEEN b_expr AND b_expr %prec BETWEEN
+	{
+		$$ = &AExpr{Kind: AEXPR_BETWEEN, Lexpr: $1, Rexpr: []Node{$3, $5}}
+	}
+		| a_expr NOT BETWEEN b_expr AND b_expr %prec BETWEEN
+	{
+		$$ = &AExpr{Kind: AEXPR_NOT_BETWEEN, Lexpr: $1, Rexpr: []Node{$4, $6}}
+	}
+		| a_expr IN_P in_expr
+	{
+		if n, ok := $3.(*ASubLink); ok {
+			n.SubLinkType, n.Testexpr, n.OperName = ANY_SUBLINK, $1, "="
+			$$ = n
+		} else {
+			$$ = &AExpr{Kind: AEXPR_IN, Name: "=", Lexpr: $1, Rexpr: $3}
+		}
+	}
+		| a_expr NOT IN_P in_expr %prec IN_P
+	{
+		/* NOT IN of a subquery is NOT (x = ANY (...)) */
+		if n, ok := $4.(*ASubLink); ok {
+			n.SubLinkType, n.Testexpr, n.OperName = ANY_SUBLINK, $1, "="
+			$$ = &AExpr{Kind: AEXPR_NOT, Rexpr: n}
+		} else {
+			$$ = &AExpr{Kind: AEXPR_IN, Name: "<>", Lexpr: $1, Rexpr: $4}
+		}
+	}
+
+b_expr: c_expr
+		| b_expr TYPECAST Typename
+	{
+		$$ = &TypeCast{Arg: $1, TypeName: $3}
+	}
+		| '+' b_expr %prec UMINUS
+	{
+		$$ = &AExpr{Kind: AEXPR_OP, Name: "+", Rexpr: $2}
+	}
+		| '-' b_expr %prec UMINUS
+	{
+		$$ = doNegate($2)
+	}
+		| b_expr '+' b_expr
+	{
+		$$ = &AExpr{Kind: AEXPR_OP, Name: "+", Lexpr: $1, Rexpr: $3}
+	}
+		| b_expr '-' b_expr
+	{
+		$$ = &AExpr{Kind: AEXPR_OP, Name: "-", Lexpr: $1, Rexpr: $3}
+	}
+		| b_expr '*' b_expr
+	{
+		$$ = &AExpr{Kind: AEXPR_OP, Name: "*", Lexpr: $1, Rexpr: $3}
+	}
+		| b_expr '/' b_expr
+	{
+		$$ = &AExpr{Kind: AEXPR_OP, Name: "/", Lexpr: $1, Rexpr: $3}
+	}
+		| b_expr '%' b_expr
+	{
+		$$ = &AExpr{Kind: AEXPR_OP, Name: "%", Lexpr: $1, Rexpr: $3}
+	}
+		| b_expr '<' b_expr
+	{
+		$$ = &AExpr{Kind: AEXPR_OP, Name: "<", Lexpr: $1, Rexpr: $3}
+	}
+		| b_expr '>' b_expr
+	{
+		$$ = &AExpr{Kind: AEXPR_OP, Name: ">", Lexpr: $1, Rexpr: $3}
+	}
+		| b_expr '=' b_expr
+	{
+		$$ = &AExpr{Kind: AEXPR_OP, Name: "=", Lexpr: $1, Rexpr: $3}
+	}
+		| b_expr Op b_expr
+	{
+		$$ = &AExpr{Kind: AEXPR_OP, Name: $2, Lexpr: $1, Rexpr: $3}
+	}
+		| Op b_expr %prec Op
+	{
+		$$ = &AExpr{Kind: AEXPR_OP, Name: $1, Rexpr: $2}
+	}
+
+c_expr: columnref
+		| AexprConst
+		| '(' a_expr ')'
+	{
+		$$ = $2
+	}
+		| CAST '(' a_expr AS Typename ')'
+	{
+		$$ = &TypeCast{Arg: $3, TypeName: $5}
+	}
+		| func_expr
+		| case_expr
+		| COALESCE '(' expr_list ')'
+	{
+		$$ = &ACoalesce{Args: $3}
+	}
+		| EXTRACT '(' extract_arg FROM a_expr ')'
+	{
+		$$ = &FuncCall{
+			FuncName: "date_part",
+			Args:     []Node{&AConst{Kind: ConstString, Str: $3}, $5},
+		}
+	}
+		| select_with_parens
+	{
+		$$ = &ASubLink{SubLinkType: EXPR_SUBLINK, Subselect: $1}
+	}
+		| EXISTS select_with_parens
+	{
+		$$ = &ASubLink{SubLinkType: EXISTS_SUBLINK, Subselect: $2}
+	}
+
+func_expr: ColId '(' ')'
+	{
+		$$ = &FuncCall{FuncName: $1}
+	}
+		| ColId '(' expr_list ')'
+	{
+		$$ = &FuncCall{FuncName: $1, Args: $3}
+	}
+		| ColId '(' ALL expr_list ')'
+	{
+		$$ = &FuncCall{FuncName: $1, Args: $4}
+	}
+		| ColId '(' DISTINCT expr_list ')'
+	{
+		$$ = &FuncCall{FuncName: $1, Args: $4, AggDistinct: true}
+	}
+		| ColId '(' '*' ')'
+	{
+		$$ = &FuncCall{FuncName: $1, AggStar: true}
+	}
+
+/*
+ * The field of an EXTRACT, as postgres' extract_arg.  YEAR and the like
+ * are not keywords here, so they are identifiers.
+ */
+extract_arg: IDENT
+		| SCONST
+
+/*
+ * CASE [arg] WHEN expr THEN result [...] [ELSE result] END
+ */
+case_expr: CASE case_arg when_clause_list case_default END_P
+	{
+		n := &ACase{Arg: $2, DefResult: $4}
+		for _, when := range $3 {
+			n.Whens = append(n.Whens, when.(*ACaseWhen))
+		}
+		$$ = n
+	}
+
+when_clause_list: when_clause
+	{
+		$$ = []Node{$1}
+	}
+		| when_clause_list when_clause
+	{
+		$$ = append($1, $2)
+	}
+
+when_clause: WHEN a_expr THEN a_expr
+	{
+		$$ = &ACaseWhen{Expr: $2, Result: $4}
+	}
+
+case_default: ELSE a_expr
+	{
+		$$ = $2
+	}
+		| /* empty */
+	{
+		$$ = nil
+	}
+
+case_arg: a_expr
+		| /* empty */
+	{
+		$$ = nil
+	}
+
+in_expr: select_with_parens
+	{
+		$$ = &ASubLink{Subselect: $1}
+	}
+		| '(' expr_list ')'
+	{
+		$$ = $2
+	}
+
+expr_list: a_expr
+	{
+		$$ = []Node{$1}
+	}
+		| expr_list ',' a_expr
+	{
+		$$ = append($1, $3)
+	}
+
+columnref: ColId
+	{
+		$$ = &ColumnRef{fields: []string{$1}}
+	}
+		| ColId attrs
+	{
+		$$ = &ColumnRef{fields: append([]string{$1}, $2...)}
+	}
+		| ColId '.' '*'
+	{
+		$$ = &ColumnRef{fields: []string{$1}, star: true}
+	}
+		| ColId attrs '.' '*'
+	{
+		$$ = &ColumnRef{fields: append([]string{$1}, $2...), star: true}
+	}
+
+attrs: '.' attr_name
+	{
+		$$ = []string{$2}
+	}
+		| attrs '.' attr_name
+	{
+		$$ = append($1, $3)
+	}
+
+attr_name: ColLabel
+
+AexprConst: ICONST
+	{
+		$$ = &AConst{Kind: ConstInteger, Ival: $1}
+	}
+		| FCONST
+	{
+		$$ = &AConst{Kind: ConstFloat, Str: $1}
+	}
+		| SCONST
+	{
+		$$ = &AConst{Kind: ConstString, Str: $1}
+	}
+		| TRUE_P
+	{
+		$$ = makeBoolAConst(true)
+	}
+		| FALSE_P
+	{
+		$$ = makeBoolAConst(false)
+	}
+		| NULL_P
+	{
+		$$ = &AConst{Kind: ConstNull}
+	}
+
+ColId: IDENT
+		| unreserved_keyword
+
+/*
+ * Any name at all, where no keyword can be meant, as after AS.
+ */
+ColLabel: IDENT
+		| unreserved_keyword
+		| col_name_keyword
+		| type_func_name_keyword
+		| reserved_keyword
+
+unreserved_keyword: ADD_P { $$ = $1 }
+		| ALTER { $$ = $1 }
+		| BEGIN_P { $$ = $1 }
+		| BY { $$ = $1 }
+		| CASCADE { $$ = $1 }
+		| COMMIT { $$ = $1 }
+		| DATA_P { $$ = $1 }
+		| DELETE_P { $$ = $1 }
+		| DROP { $$ = $1 }
+		| FIRST_P { $$ = $1 }
+		| IF_P { $$ = $1 }
+		| INSERT { $$ = $1 }
+		| LAST_P { $$ = $1 }
+		| NULLS_P { $$ = $1 }
+		| RENAME { $$ = $1 }
+		| RESTRICT { $$ = $1 }
+		| ROLLBACK { $$ = $1 }
+		| SET { $$ = $1 }
+		| TRANSACTION { $$ = $1 }
+		| TYPE_P { $$ = $1 }
+		| UPDATE { $$ = $1 }
+		| WORK { $$ = $1 }
+
+col_name_keyword: BETWEEN { $$ = $1 }
+		| COALESCE { $$ = $1 }
+		| EXISTS { $$ = $1 }
+		| EXTRACT { $$ = $1 }
+		| VALUES { $$ = $1 }
+
+type_func_name_keyword: CROSS { $$ = $1 }
+		| FULL { $$ = $1 }
+		| INNER_P { $$ = $1 }
+		| IS { $$ = $1 }
+		| JOIN { $$ = $1 }
+		| LEFT { $$ = $1 }
+		| LIKE { $$ = $1 }
+		| NATURAL { $$ = $1 }
+		| OUTER_P { $$ = $1 }
+		| RIGHT { $$ = $1 }
+
+reserved_keyword: ALL { $$ = $1 }
+		| AND { $$ = $1 }
+		| AS { $$ = $1 }
+		| ASC { $$ = $1 }
+		| CASE { $$ = $1 }
+		| CAST { $$ = $1 }
+		| COLUMN { $$ = $1 }
+		| CREATE { $$ = $1 }
+		| DEFAULT { $$ = $1 }
+		| DESC { $$ = $1 }
+		| DISTINCT { $$ = $1 }
+		| ELSE { $$ = $1 }
+		| END_P { $$ = $1 }
+		| FALSE_P { $$ = $1 }
+		| FROM { $$ = $1 }
+		| GROUP_P { $$ = $1 }
+		| HAVING { $$ = $1 }
+		| IN_P { $$ = $1 }
+		| INTO { $$ = $1 }
+		| LIMIT { $$ = $1 }
+		| NOT { $$ = $1 }
+		| NULL_P { $$ = $1 }
+		| OFFSET { $$ = $1 }
+		| ON { $$ = $1 }
+		| OR { $$ = $1 }
+		| ORDER { $$ = $1 }
+		| RETURNING { $$ = $1 }
+		| SELECT { $$ = $1 }
+		| TABLE { $$ = $1 }
+		| THEN { $$ = $1 }
+		| TO { $$ = $1 }
+		| TRUE_P { $$ = $1 }
+		| USING { $$ = $1 }
+		| WHEN { $$ = $1 }
+		| WHERE { $$ = $1 }
+%%
+
+/*
+ * Makes the ColumnDef of a column and its constraints, as postgres'
+ * transformColumnDefinition does.
+ */
+func columnDef(name string, typeName *TypeName, quals []Node, yylex yyLexer) *ColumnDef {
+	n := &ColumnDef{ColName: name, TypeName: typeName}
+	sawNullable, sawDefault := false, false
+	for _, qual := range quals {
+		constraint := qual.(*Constraint)
+		switch constraint.Contype {
+		case ConstrNull, ConstrNotNull:
+			notNull := constraint.Contype == ConstrNotNull
+			if sawNullable && n.IsNotNull != notNull {
+				yylex.Error(fmt.Sprintf("conflicting NULL/NOT NULL declarations for column \"%s\"", name))
+			}
+			sawNullable = true
+			n.IsNotNull = notNull
+		case ConstrDefault:
+			if sawDefault {
+				yylex.Error(fmt.Sprintf("multiple default values specified for column \"%s\"", name))
+			}
+			sawDefault = true
+			n.RawDefault = constraint.RawExpr
+		}
+	}
+	return n
+}
+
+/*
+ * Makes the RangeJoin of a JOIN; qual is the name list of USING or the
+ * condition of ON, or nil for a NATURAL join.
+ */
+func makeRangeJoin(joinType JoinType, isNatural bool, larg, rarg Node, qual Node) *RangeJoin {
+	n := &RangeJoin{JoinType: joinType, IsNatural: isNatural, Larg: larg, Rarg: rarg}
+	if using, ok := qual.([]string); ok {
+		n.UsingClause = using
+	} else {
+		n.Quals = qual
+	}
+	return n
+}
+
+/*
+ * Negates the expression, folding the sign into a numeric constant as
+ * postgres' doNegate does, so that -1 is a constant and not an operator.
+ */
+func doNegate(n Node) Node {
+	if con, ok := n.(*AConst); ok {
+		switch con.Kind {
+		case ConstInteger:
+			return &AConst{Kind: ConstInteger, Ival: -con.Ival}
+		case ConstFloat:
+			if strings.HasPrefix(con.Str, "-") {
+				return &AConst{Kind: ConstFloat, Str: con.Str[1:]}
+			}
+			return &AConst{Kind: ConstFloat, Str: "-" + con.Str}
+		}
+	}
+	return &AExpr{Kind: AEXPR_OP, Name: "-", Rexpr: n}
+}
+
+/*
+ * TRUE and FALSE are string literals cast to bool, as postgres'
+ * makeBoolAConst.
+ */
+func makeBoolAConst(val bool) Node {
+	str := "f"
+	if val {
+		str = "t"
+	}
+	return &TypeCast{Arg: &AConst{Kind: ConstString, Str: str}, TypeName: &TypeName{Name: "bool"}}
+}
+
+/*
+ * Returns the name of the column a target list entry makes, as postgres'
+ * FigureColname: that of a column reference, or the type of a cast.
+ */
+func figureColname(n Node) string {
+	switch n := n.(type) {
+	case *ColumnRef:
+		if n.star {
+			break
+		}
+		return n.fields[len(n.fields)-1]
+	case *TypeCast:
+		if name := figureColname(n.Arg); name != "?column?" {
+			return name
+		}
+		return n.TypeName.Name
+	case *FuncCall:
+		return n.FuncName
+	case *ACase:
+		return "case"
+	case *ACoalesce:
+		return "coalesce"
+	case *ASubLink:
+		switch n.SubLinkType {
+		case EXISTS_SUBLINK:
+			return "exists"
+		case EXPR_SUBLINK:
+			if name := n.Subselect.targetList[0].name; name != "" {
+				return name
+			}
+		}
+	}
+	return "?column?"
+}
+
+func alterTableStmt(relation *RangeVar, cmds []Node, missingOk bool) *AlterTableStmt {
+	n := &AlterTableStmt{Relation: relation, MissingOk: missingOk}
+	for _, cmd := range cmds {
+		n.Cmds = append(n.Cmds, cmd.(*AlterTableCmd))
+	}
+	return n
+}
+
+func dropStmt(names []Node, missingOk bool, behavior DropBehavior) *DropStmt {
+	n := &DropStmt{MissingOk: missingOk, Behavior: behavior}
+	for _, name := range names {
+		n.Objects = append(n.Objects, name.(*RangeVar))
+	}
+	return n
+}
 
 func ExParse(query string) Node {
 	lexer := newLexer(query)
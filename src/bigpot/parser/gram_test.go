@@ -27,3 +27,301 @@ func (s *MySuite) TestYYParse_1(c *C) {
 	c.Check(node.targetList[1].name, Equals, "col2")
 	c.Check(node.fromList[0].(*RangeVar).RelationName, Equals, system.Name("tab1"))
 }
+
+func (s *MySuite) TestRawParseSelectNames(c *C) {
+	stmts, err := RawParse(`SELECT *, T.*, s.t.a, a + 1 AS from, b c, "B" FROM s.t AS T (x), u v`)
+	c.Assert(err, IsNil)
+	stmt := stmts[0].(*SelectStmt)
+	c.Check(stmt.targetList, DeepEquals, []*ResTarget{
+		{val: &ColumnRef{star: true}},
+		{name: "?column?", val: &ColumnRef{fields: []string{"t"}, star: true}},
+		{name: "a", val: &ColumnRef{fields: []string{"s", "t", "a"}}},
+		{name: "from", val: &AExpr{Kind: AEXPR_OP, Name: "+",
+			Lexpr: &ColumnRef{fields: []string{"a"}}, Rexpr: &AConst{Kind: ConstInteger, Ival: 1}}},
+		{name: "c", val: &ColumnRef{fields: []string{"b"}}},
+		{name: "B", val: &ColumnRef{fields: []string{"B"}}},
+	})
+	c.Check(stmt.fromList, DeepEquals, []Node{
+		&RangeVar{SchemaName: "s", RelationName: "t", Alias: &Alias{AliasName: "t", ColumnNames: []string{"x"}}},
+		&RangeVar{RelationName: "u", Alias: &Alias{AliasName: "v"}},
+	})
+
+	stmts, err = RawParse("insert into s.t (a, b) values (1, 'x'), (2, null) returning *; " +
+		"insert into t select a from u")
+	c.Assert(err, IsNil)
+	c.Check(stmts[0], DeepEquals, &InsertStmt{
+		Relation: &RangeVar{SchemaName: "s", RelationName: "t"},
+		Cols:     []string{"a", "b"},
+		SelectStmt: &SelectStmt{valuesLists: [][]Node{
+			{&AConst{Kind: ConstInteger, Ival: 1}, &AConst{Kind: ConstString, Str: "x"}},
+			{&AConst{Kind: ConstInteger, Ival: 2}, &AConst{Kind: ConstNull}},
+		}},
+		ReturningList: []*ResTarget{{val: &ColumnRef{star: true}}},
+	})
+	insert := stmts[1].(*InsertStmt)
+	c.Check(insert.Cols, HasLen, 0)
+	c.Check(insert.SelectStmt.fromList, HasLen, 1)
+
+	stmts, err = RawParse("insert into t values (default, 1); insert into t default values")
+	c.Assert(err, IsNil)
+	c.Check(stmts[0].(*InsertStmt).SelectStmt.valuesLists, DeepEquals,
+		[][]Node{{&SetToDefault{}, &AConst{Kind: ConstInteger, Ival: 1}}})
+	c.Check(stmts[1].(*InsertStmt).SelectStmt.valuesLists, DeepEquals, [][]Node{{}})
+
+	stmts, err = RawParse("update t set a = a + 1, b = 'x' from u where t.a = u.a returning b; " +
+		"delete from s.t x using u where x.a = u.a; delete from t as x")
+	c.Assert(err, IsNil)
+	c.Check(stmts[0], DeepEquals, &UpdateStmt{
+		Relation: &RangeVar{RelationName: "t"},
+		TargetList: []*ResTarget{
+			{name: "a", val: &AExpr{Kind: AEXPR_OP, Name: "+",
+				Lexpr: &ColumnRef{fields: []string{"a"}}, Rexpr: &AConst{Kind: ConstInteger, Ival: 1}}},
+			{name: "b", val: &AConst{Kind: ConstString, Str: "x"}},
+		},
+		FromClause: []Node{&RangeVar{RelationName: "u"}},
+		WhereClause: &AExpr{Kind: AEXPR_OP, Name: "=",
+			Lexpr: &ColumnRef{fields: []string{"t", "a"}}, Rexpr: &ColumnRef{fields: []string{"u", "a"}}},
+		ReturningList: []*ResTarget{{name: "b", val: &ColumnRef{fields: []string{"b"}}}},
+	})
+	del := stmts[1].(*DeleteStmt)
+	c.Check(del.Relation, DeepEquals, &RangeVar{SchemaName: "s", RelationName: "t", Alias: &Alias{AliasName: "x"}})
+	c.Check(del.UsingClause, DeepEquals, []Node{&RangeVar{RelationName: "u"}})
+	c.Check(del.WhereClause, NotNil)
+	c.Check(stmts[2].(*DeleteStmt).Relation.Alias, DeepEquals, &Alias{AliasName: "x"})
+
+	_, err = RawParse("select a.*.b from t")
+	c.Check(err, ErrorMatches, "syntax error at or near \".\"")
+}
+
+func (s *MySuite) TestRawParseJoins(c *C) {
+	stmts, err := RawParse("select * from a join b using (x) left join c on true, " +
+		"(d natural full outer join e) as f, g cross join h right join i on false")
+	c.Assert(err, IsNil)
+	rv := func(name string) *RangeVar { return &RangeVar{RelationName: system.Name(name)} }
+	c.Check(stmts[0].(*SelectStmt).fromList, DeepEquals, []Node{
+		&RangeJoin{
+			JoinType: JOIN_LEFT,
+			Larg:     &RangeJoin{JoinType: JOIN_INNER, Larg: rv("a"), Rarg: rv("b"), UsingClause: []string{"x"}},
+			Rarg:     rv("c"),
+			Quals:    makeBoolAConst(true),
+		},
+		&RangeJoin{JoinType: JOIN_FULL, IsNatural: true, Larg: rv("d"), Rarg: rv("e"), Alias: &Alias{AliasName: "f"}},
+		&RangeJoin{
+			JoinType: JOIN_RIGHT,
+			Larg:     &RangeJoin{JoinType: JOIN_INNER, Larg: rv("g"), Rarg: rv("h")},
+			Rarg:     rv("i"),
+			Quals:    makeBoolAConst(false),
+		},
+	})
+
+	_, err = RawParse("select * from a join b")
+	c.Check(err, NotNil)
+}
+
+func (s *MySuite) TestRawParseCreateDrop(c *C) {
+	stmts, err := RawParse("create table s.t (a int not null, b text[]); drop table if exists t, u cascade;")
+	c.Assert(err, IsNil)
+	c.Assert(stmts, HasLen, 2)
+	create, ok := stmts[0].(*CreateStmt)
+	c.Assert(ok, Equals, true)
+	c.Check(create.Relation.SchemaName, Equals, system.Name("s"))
+	c.Check(create.Relation.RelationName, Equals, system.Name("t"))
+	c.Check(create.TableElts, DeepEquals, []*ColumnDef{
+		{ColName: "a", TypeName: &TypeName{Name: "int"}, IsNotNull: true},
+		{ColName: "b", TypeName: &TypeName{Name: "text", IsArray: true}},
+	})
+	drop, ok := stmts[1].(*DropStmt)
+	c.Assert(ok, Equals, true)
+	c.Check(drop.Objects, HasLen, 2)
+	c.Check(drop.MissingOk, Equals, true)
+	c.Check(drop.Behavior, Equals, DropCascade)
+
+	_, err = RawParse("create table t a int")
+	c.Check(err, ErrorMatches, "syntax error at or near \"a\"")
+}
+
+func (s *MySuite) TestRawParseAlterTable(c *C) {
+	stmts, err := RawParse("alter table if exists t add column c int default -1 not null, " +
+		"drop b cascade, drop column if exists x, alter column a set data type text;" +
+		"alter table t rename column a to b; alter table t rename to u")
+	c.Assert(err, IsNil)
+	c.Assert(stmts, HasLen, 3)
+	alter := stmts[0].(*AlterTableStmt)
+	c.Check(alter.MissingOk, Equals, true)
+	c.Check(alter.Cmds, DeepEquals, []*AlterTableCmd{
+		{Subtype: AT_AddColumn, Def: &ColumnDef{ColName: "c", TypeName: &TypeName{Name: "int"},
+			IsNotNull: true, RawDefault: &AConst{Kind: ConstInteger, Ival: -1}}},
+		{Subtype: AT_DropColumn, Name: "b", Behavior: DropCascade},
+		{Subtype: AT_DropColumn, Name: "x", MissingOk: true},
+		{Subtype: AT_AlterColumnType, Name: "a", Def: &ColumnDef{ColName: "a", TypeName: &TypeName{Name: "text"}}},
+	})
+	c.Check(stmts[1], DeepEquals, &RenameStmt{Relation: &RangeVar{RelationName: "t"}, SubName: "a", NewName: "b"})
+	c.Check(stmts[2], DeepEquals, &RenameStmt{Relation: &RangeVar{RelationName: "t"}, NewName: "u"})
+
+	_, err = RawParse("create table t (a int null not null)")
+	c.Check(err, ErrorMatches, "conflicting NULL/NOT NULL declarations for column \"a\"")
+	_, err = RawParse("create table t (a int default 1 default 2)")
+	c.Check(err, ErrorMatches, "multiple default values specified for column \"a\"")
+
+	node, err := RawParseExpr("'it''s'")
+	c.Assert(err, IsNil)
+	c.Check(node.(*AConst).Deparse(), Equals, "'it''s'")
+
+	// the text of an expression reads back as the same expression
+	for _, expr := range []string{
+		"-1", "1 + 2 * 3", "- a", "'2020-01-01'::date", "cast(a as int[])", "f(1, 'x')",
+		"count(distinct a)", "count(*)", "a between 1 and 2 or not b is null", "a not in (1, 2)",
+		"case a when 1 then 'x' else 'y' end", "coalesce(a, t.b)", "extract(year from a)",
+	} {
+		node, err := RawParseExpr(expr)
+		c.Assert(err, IsNil)
+		again, err := RawParseExpr(DeparseExpr(node))
+		c.Assert(err, IsNil, Commentf(expr))
+		c.Check(again, DeepEquals, node, Commentf(expr))
+	}
+	node, err = RawParseExpr("1 + 2 * 3")
+	c.Assert(err, IsNil)
+	c.Check(DeparseExpr(node), Equals, "(1 + (2 * 3))")
+}
+
+func (s *MySuite) TestRawParseExpr(c *C) {
+	op := func(name string, l, r Node) *AExpr {
+		return &AExpr{Kind: AEXPR_OP, Name: name, Lexpr: l, Rexpr: r}
+	}
+	col := func(name string) *ColumnRef { return &ColumnRef{fields: []string{name}} }
+	num := func(ival int) *AConst { return &AConst{Kind: ConstInteger, Ival: ival} }
+
+	node, err := RawParseExpr("a + 2 * -3 >= b AND NOT c OR d IS NOT NULL")
+	c.Assert(err, IsNil)
+	c.Check(node, DeepEquals, &AExpr{Kind: AEXPR_OR,
+		Lexpr: &AExpr{Kind: AEXPR_AND,
+			Lexpr: op(">=", op("+", col("a"), op("*", num(2), num(-3))), col("b")),
+			Rexpr: &AExpr{Kind: AEXPR_NOT, Rexpr: col("c")}},
+		Rexpr: &AExpr{Kind: AEXPR_IS_NOT_NULL, Lexpr: col("d")}})
+
+	node, err = RawParseExpr("a NOT BETWEEN 1 AND 2 AND b IN (1, 2) AND c NOT LIKE 'x%'")
+	c.Assert(err, IsNil)
+	c.Check(node, DeepEquals, &AExpr{Kind: AEXPR_AND,
+		Lexpr: &AExpr{Kind: AEXPR_AND,
+			Lexpr: &AExpr{Kind: AEXPR_NOT_BETWEEN, Lexpr: col("a"), Rexpr: []Node{num(1), num(2)}},
+			Rexpr: &AExpr{Kind: AEXPR_IN, Name: "=", Lexpr: col("b"), Rexpr: []Node{num(1), num(2)}}},
+		Rexpr: op("!~~", col("c"), &AConst{Kind: ConstString, Str: "x%"})})
+
+	node, err = RawParseExpr("(a - 1)::text = cast(true as text)")
+	c.Assert(err, IsNil)
+	c.Check(node, DeepEquals, op("=",
+		&TypeCast{Arg: op("-", col("a"), num(1)), TypeName: &TypeName{Name: "text"}},
+		&TypeCast{Arg: makeBoolAConst(true), TypeName: &TypeName{Name: "text"}}))
+
+	stmts, err := RawParse("select a, b::int, 1 from t where a <> 1")
+	c.Assert(err, IsNil)
+	stmt := stmts[0].(*SelectStmt)
+	c.Check(stmt.targetList[0].name, Equals, "a")
+	c.Check(stmt.targetList[1].name, Equals, "b")
+	c.Check(stmt.targetList[2].name, Equals, "?column?")
+	c.Check(stmt.whereClause, DeepEquals, op("<>", col("a"), num(1)))
+
+	stmts, err = RawParse("select a from t order by a desc nulls last, b + 1, 2 asc offset 1 limit all")
+	c.Assert(err, IsNil)
+	stmt = stmts[0].(*SelectStmt)
+	c.Check(stmt.sortClause, DeepEquals, []*SortBy{
+		{Node: col("a"), SortbyDir: SORTBY_DESC, SortbyNulls: SORTBY_NULLS_LAST},
+		{Node: op("+", col("b"), num(1))},
+		{Node: num(2), SortbyDir: SORTBY_ASC},
+	})
+	c.Check(stmt.limitOffset, DeepEquals, num(1))
+	c.Check(stmt.limitCount, DeepEquals, &AConst{Kind: ConstNull})
+	stmts, err = RawParse("select a from t limit 10")
+	c.Assert(err, IsNil)
+	stmt = stmts[0].(*SelectStmt)
+	c.Check(stmt.sortClause, IsNil)
+	c.Check(stmt.limitOffset, IsNil)
+	c.Check(stmt.limitCount, DeepEquals, num(10))
+
+	node, err = RawParseExpr("case a when 1 then abs(b) else coalesce(c, 0) end")
+	c.Assert(err, IsNil)
+	c.Check(node, DeepEquals, &ACase{
+		Arg:       col("a"),
+		Whens:     []*ACaseWhen{{Expr: num(1), Result: &FuncCall{FuncName: "abs", Args: []Node{col("b")}}}},
+		DefResult: &ACoalesce{Args: []Node{col("c"), num(0)}},
+	})
+	c.Check(figureColname(node), Equals, "case")
+
+	node, err = RawParseExpr("extract(year from a) + extract('epoch' from b)")
+	c.Assert(err, IsNil)
+	c.Check(node, DeepEquals, op("+",
+		&FuncCall{FuncName: "date_part", Args: []Node{&AConst{Kind: ConstString, Str: "year"}, col("a")}},
+		&FuncCall{FuncName: "date_part", Args: []Node{&AConst{Kind: ConstString, Str: "epoch"}, col("b")}}))
+	_, err = RawParseExpr("extract(year, a)")
+	c.Check(err, ErrorMatches, "syntax error at or near \",\"")
+
+	_, err = RawParseExpr("a = b = ")
+	c.Check(err, ErrorMatches, "syntax error at end of input")
+	_, err = RawParseExpr("a between 1 and 2 or 3 and")
+	c.Check(err, ErrorMatches, "syntax error at end of input")
+	_, err = RawParseExpr("a not not like b")
+	c.Check(err, ErrorMatches, "syntax error at or near \"not\"")
+}
+
+func (s *MySuite) TestRawParseGrouping(c *C) {
+	col := func(name string) *ColumnRef { return &ColumnRef{fields: []string{name}} }
+	num := func(ival int) *AConst { return &AConst{Kind: ConstInteger, Ival: ival} }
+
+	stmts, err := RawParse("select distinct a, count(*), sum(distinct b), max(all c) from t " +
+		"group by a, 2 having count(*) > 1")
+	c.Assert(err, IsNil)
+	stmt := stmts[0].(*SelectStmt)
+	c.Check(stmt.distinct, Equals, true)
+	c.Check(stmt.targetList[1].val, DeepEquals, &FuncCall{FuncName: "count", AggStar: true})
+	c.Check(stmt.targetList[1].name, Equals, "count")
+	c.Check(stmt.targetList[2].val, DeepEquals,
+		&FuncCall{FuncName: "sum", Args: []Node{col("b")}, AggDistinct: true})
+	c.Check(stmt.targetList[3].val, DeepEquals, &FuncCall{FuncName: "max", Args: []Node{col("c")}})
+	c.Check(stmt.groupClause, DeepEquals, []Node{col("a"), num(2)})
+	c.Check(stmt.havingClause, DeepEquals, &AExpr{Kind: AEXPR_OP, Name: ">",
+		Lexpr: &FuncCall{FuncName: "count", AggStar: true}, Rexpr: num(1)})
+
+	stmts, err = RawParse("select all a from t")
+	c.Assert(err, IsNil)
+	stmt = stmts[0].(*SelectStmt)
+	c.Check(stmt.distinct, Equals, false)
+	c.Check(stmt.groupClause, IsNil)
+	c.Check(stmt.havingClause, IsNil)
+
+	_, err = RawParse("select a from t group by")
+	c.Check(err, ErrorMatches, "syntax error at end of input")
+	_, err = RawParse("select count(distinct *) from t")
+	c.Check(err, NotNil)
+}
+
+func (s *MySuite) TestRawParseSubqueries(c *C) {
+	col := func(name string) *ColumnRef { return &ColumnRef{fields: []string{name}} }
+
+	stmts, err := RawParse("select (select max(a) from u), exists (select 1 from u) from " +
+		"(select a from t) as s (x) where x in (select a from u) and x not in (select c from u)")
+	c.Assert(err, IsNil)
+	stmt := stmts[0].(*SelectStmt)
+	sublink := stmt.targetList[0].val.(*ASubLink)
+	c.Check(sublink.SubLinkType, Equals, EXPR_SUBLINK)
+	c.Check(stmt.targetList[0].name, Equals, "max")
+	c.Check(stmt.targetList[1].val.(*ASubLink).SubLinkType, Equals, EXISTS_SUBLINK)
+	c.Check(stmt.targetList[1].name, Equals, "exists")
+
+	c.Assert(stmt.fromList, HasLen, 1)
+	rs := stmt.fromList[0].(*RangeSubselect)
+	c.Check(rs.Alias, DeepEquals, &Alias{AliasName: "s", ColumnNames: []string{"x"}})
+	c.Check(rs.Subquery.targetList[0].val, DeepEquals, col("a"))
+
+	and := stmt.whereClause.(*AExpr)
+	c.Check(and.Kind, Equals, AEXPR_AND)
+	in := and.Lexpr.(*ASubLink)
+	c.Check(in.SubLinkType, Equals, ANY_SUBLINK)
+	c.Check(in.Testexpr, DeepEquals, col("x"))
+	c.Check(in.OperName, Equals, "=")
+	notIn := and.Rexpr.(*AExpr)
+	c.Check(notIn.Kind, Equals, AEXPR_NOT)
+	c.Check(notIn.Rexpr.(*ASubLink).SubLinkType, Equals, ANY_SUBLINK)
+
+	_, err = RawParse("select * from (select 1) s, (select 2 t")
+	c.Check(err, NotNil)
+}
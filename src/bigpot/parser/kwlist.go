@@ -24,8 +24,78 @@ type keyword struct {
  * the set of keywords at compile time.
  */
 var keywordList = []keyword{
+	{"add", ADD_P, UnreservedKeyword},
+	{"all", ALL, ReservedKeyword},
+	{"alter", ALTER, UnreservedKeyword},
+	{"and", AND, ReservedKeyword},
+	{"as", AS, ReservedKeyword},
+	{"asc", ASC, ReservedKeyword},
+	{"begin", BEGIN_P, UnreservedKeyword},
+	{"between", BETWEEN, ColNameKeyword},
+	{"by", BY, UnreservedKeyword},
+	{"cascade", CASCADE, UnreservedKeyword},
+	{"case", CASE, ReservedKeyword},
+	{"cast", CAST, ReservedKeyword},
+	{"coalesce", COALESCE, ColNameKeyword},
+	{"column", COLUMN, ReservedKeyword},
+	{"commit", COMMIT, UnreservedKeyword},
+	{"create", CREATE, ReservedKeyword},
+	{"cross", CROSS, TypeFuncNameKeyword},
+	{"data", DATA_P, UnreservedKeyword},
+	{"default", DEFAULT, ReservedKeyword},
+	{"delete", DELETE_P, UnreservedKeyword},
+	{"desc", DESC, ReservedKeyword},
+	{"distinct", DISTINCT, ReservedKeyword},
+	{"drop", DROP, UnreservedKeyword},
+	{"else", ELSE, ReservedKeyword},
+	{"end", END_P, ReservedKeyword},
+	{"exists", EXISTS, ColNameKeyword},
+	{"extract", EXTRACT, ColNameKeyword},
+	{"false", FALSE_P, ReservedKeyword},
+	{"first", FIRST_P, UnreservedKeyword},
 	{"from", FROM, ReservedKeyword},
+	{"full", FULL, TypeFuncNameKeyword},
+	{"group", GROUP_P, ReservedKeyword},
+	{"having", HAVING, ReservedKeyword},
+	{"if", IF_P, UnreservedKeyword},
+	{"in", IN_P, ReservedKeyword},
+	{"inner", INNER_P, TypeFuncNameKeyword},
+	{"insert", INSERT, UnreservedKeyword},
+	{"into", INTO, ReservedKeyword},
+	{"is", IS, TypeFuncNameKeyword},
+	{"join", JOIN, TypeFuncNameKeyword},
+	{"last", LAST_P, UnreservedKeyword},
+	{"left", LEFT, TypeFuncNameKeyword},
+	{"like", LIKE, TypeFuncNameKeyword},
+	{"limit", LIMIT, ReservedKeyword},
+	{"natural", NATURAL, TypeFuncNameKeyword},
+	{"not", NOT, ReservedKeyword},
+	{"null", NULL_P, ReservedKeyword},
+	{"nulls", NULLS_P, UnreservedKeyword},
+	{"offset", OFFSET, ReservedKeyword},
+	{"on", ON, ReservedKeyword},
+	{"or", OR, ReservedKeyword},
+	{"order", ORDER, ReservedKeyword},
+	{"outer", OUTER_P, TypeFuncNameKeyword},
+	{"rename", RENAME, UnreservedKeyword},
+	{"restrict", RESTRICT, UnreservedKeyword},
+	{"returning", RETURNING, ReservedKeyword},
+	{"right", RIGHT, TypeFuncNameKeyword},
+	{"rollback", ROLLBACK, UnreservedKeyword},
 	{"select", SELECT, ReservedKeyword},
+	{"set", SET, UnreservedKeyword},
+	{"table", TABLE, ReservedKeyword},
+	{"then", THEN, ReservedKeyword},
+	{"to", TO, ReservedKeyword},
+	{"transaction", TRANSACTION, UnreservedKeyword},
+	{"true", TRUE_P, ReservedKeyword},
+	{"type", TYPE_P, UnreservedKeyword},
+	{"update", UPDATE, UnreservedKeyword},
+	{"using", USING, ReservedKeyword},
+	{"values", VALUES, ColNameKeyword},
+	{"when", WHEN, ReservedKeyword},
+	{"where", WHERE, ReservedKeyword},
+	{"work", WORK, UnreservedKeyword},
 }
 
 func findKeyword(name string) (*keyword, error) {
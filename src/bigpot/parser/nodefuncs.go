@@ -0,0 +1,322 @@
+package parser
+
+import (
+	"reflect"
+
+	"bigpot/system"
+)
+
+// Makes the Var of the column, as postgres' makeVar.
+func MakeVar(varno uint16, attno system.AttrNumber, typid system.Oid) *Var {
+	return &Var{ExprImpl: ExprImpl{typid}, VarNo: varno, VarAttNo: attno}
+}
+
+// Makes a NULL of the type, as postgres' makeNullConst.
+func MakeNullConst(typid system.Oid) *Const {
+	return &Const{ExprImpl{typid}, nil}
+}
+
+// Makes the Param of the value, as the planner does of the columns of the
+// outer queries.
+func MakeParam(kind ParamKind, id int, typid system.Oid) *Param {
+	return &Param{ExprImpl: ExprImpl{typid}, ParamKind: kind, ParamId: id}
+}
+
+// Returns true if the expressions are the same, as postgres' equal.  The
+// operators and functions of the registries are compared by identity.
+func EqualExpr(a, b Expr) bool {
+	return reflect.DeepEqual(a, b)
+}
+
+// Calls fn on each node of the expression, parents before their
+// arguments, as postgres' expression_tree_walker.  The walk stops, and
+// true is returned, once fn returns true.
+func WalkExpr(expr Expr, fn func(Expr) bool) bool {
+	if expr == nil {
+		return false
+	} else if fn(expr) {
+		return true
+	}
+	walkList := func(exprs []Expr) bool {
+		for _, arg := range exprs {
+			if WalkExpr(arg, fn) {
+				return true
+			}
+		}
+		return false
+	}
+	switch n := expr.(type) {
+	case *OpExpr:
+		return walkList(n.Args)
+	case *FuncExpr:
+		return walkList(n.Args)
+	case *BoolExpr:
+		return walkList(n.Args)
+	case *CoalesceExpr:
+		return walkList(n.Args)
+	case *Aggref:
+		return walkList(n.Args)
+	case *SubLink:
+		return WalkExpr(n.Testexpr, fn)
+	case *SubPlan:
+		return WalkExpr(n.Testexpr, fn) || walkList(n.Args)
+	case *NullTest:
+		return WalkExpr(n.Arg, fn)
+	case *CoerceViaIO:
+		return WalkExpr(n.Arg, fn)
+	case *CaseExpr:
+		if WalkExpr(n.Arg, fn) {
+			return true
+		}
+		for _, when := range n.Args {
+			if WalkExpr(when.Expr, fn) || WalkExpr(when.Result, fn) {
+				return true
+			}
+		}
+		return WalkExpr(n.DefResult, fn)
+	}
+	return false
+}
+
+// Returns a copy of the expression in which each node fn returns an
+// expression for is replaced by it, as postgres' expression_tree_mutator.
+// The nodes fn returns nil for are copied, with their arguments mutated.
+func MutateExpr(expr Expr, fn func(Expr) Expr) Expr {
+	if expr == nil {
+		return nil
+	} else if replaced := fn(expr); replaced != nil {
+		return replaced
+	}
+	mutateList := func(exprs []Expr) []Expr {
+		mutated := make([]Expr, len(exprs))
+		for i, arg := range exprs {
+			mutated[i] = MutateExpr(arg, fn)
+		}
+		return mutated
+	}
+	switch n := expr.(type) {
+	case *Var:
+		newNode := *n
+		return &newNode
+	case *Const:
+		newNode := *n
+		return &newNode
+	case *CaseTestExpr:
+		newNode := *n
+		return &newNode
+	case *Param:
+		newNode := *n
+		return &newNode
+	case *OpExpr:
+		newNode := *n
+		newNode.Args = mutateList(n.Args)
+		return &newNode
+	case *FuncExpr:
+		newNode := *n
+		newNode.Args = mutateList(n.Args)
+		return &newNode
+	case *BoolExpr:
+		newNode := *n
+		newNode.Args = mutateList(n.Args)
+		return &newNode
+	case *CoalesceExpr:
+		newNode := *n
+		newNode.Args = mutateList(n.Args)
+		return &newNode
+	case *Aggref:
+		newNode := *n
+		newNode.Args = mutateList(n.Args)
+		return &newNode
+	case *SubLink:
+		newNode := *n
+		newNode.Testexpr = MutateExpr(n.Testexpr, fn)
+		return &newNode
+	case *SubPlan:
+		newNode := *n
+		newNode.Testexpr = MutateExpr(n.Testexpr, fn)
+		newNode.Args = mutateList(n.Args)
+		return &newNode
+	case *NullTest:
+		newNode := *n
+		newNode.Arg = MutateExpr(n.Arg, fn)
+		return &newNode
+	case *CoerceViaIO:
+		newNode := *n
+		newNode.Arg = MutateExpr(n.Arg, fn)
+		return &newNode
+	case *CaseExpr:
+		newNode := *n
+		newNode.Arg = MutateExpr(n.Arg, fn)
+		newNode.Args = make([]*CaseWhen, len(n.Args))
+		for i, when := range n.Args {
+			newNode.Args[i] = &CaseWhen{
+				Expr:   MutateExpr(when.Expr, fn),
+				Result: MutateExpr(when.Result, fn),
+			}
+		}
+		newNode.DefResult = MutateExpr(n.DefResult, fn)
+		return &newNode
+	}
+	panic("unrecognized expression node type")
+}
+
+// Calls fn on each expression of the query, as postgres'
+// query_tree_walker: the target list, the conditions of the join tree,
+// the HAVING, the OFFSET and LIMIT, the RETURNING list and the rows of a
+// VALUES.  The walk stops, and true is returned, once fn returns true.
+// The subqueries of the range table are not looked into.
+func WalkQueryExprs(query *Query, fn func(Expr) bool) bool {
+	for _, tle := range query.TargetList {
+		if fn(tle.Expr) {
+			return true
+		}
+	}
+	if query.JoinTree != nil && walkJoinTree(query.JoinTree, fn) {
+		return true
+	}
+	for _, expr := range []Expr{query.HavingQual, query.LimitOffset, query.LimitCount} {
+		if expr != nil && fn(expr) {
+			return true
+		}
+	}
+	for _, tle := range query.ReturningList {
+		if fn(tle.Expr) {
+			return true
+		}
+	}
+	for _, rte := range query.RangeTables {
+		for _, row := range rte.ValuesLists {
+			for _, expr := range row {
+				if fn(expr) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+func walkJoinTree(node Node, fn func(Expr) bool) bool {
+	switch n := node.(type) {
+	case *FromExpr:
+		for _, item := range n.FromList {
+			if walkJoinTree(item, fn) {
+				return true
+			}
+		}
+		return n.Quals != nil && fn(n.Quals)
+	case *JoinExpr:
+		return walkJoinTree(n.Larg, fn) || walkJoinTree(n.Rarg, fn) ||
+			(n.Quals != nil && fn(n.Quals))
+	}
+	return false
+}
+
+// Returns a copy of the query in which each expression WalkQueryExprs
+// would call fn on is replaced by what fn returns, as postgres'
+// query_tree_mutator.  The target lists, the join tree and the range
+// table are copied, but for the subqueries of its entries.
+func MutateQueryExprs(query *Query, fn func(Expr) Expr) *Query {
+	newQuery := *query
+	mutateTlist := func(tlist []*TargetEntry) []*TargetEntry {
+		if tlist == nil {
+			return nil
+		}
+		mutated := make([]*TargetEntry, len(tlist))
+		for i, tle := range tlist {
+			newTle := *tle
+			newTle.Expr = fn(tle.Expr)
+			mutated[i] = &newTle
+		}
+		return mutated
+	}
+	mutate := func(expr Expr) Expr {
+		if expr == nil {
+			return nil
+		}
+		return fn(expr)
+	}
+	newQuery.TargetList = mutateTlist(query.TargetList)
+	if query.JoinTree != nil {
+		newQuery.JoinTree = mutateJoinTree(query.JoinTree, mutate).(*FromExpr)
+	}
+	newQuery.HavingQual = mutate(query.HavingQual)
+	newQuery.LimitOffset = mutate(query.LimitOffset)
+	newQuery.LimitCount = mutate(query.LimitCount)
+	newQuery.ReturningList = mutateTlist(query.ReturningList)
+	newQuery.RangeTables = make([]*RangeTblEntry, len(query.RangeTables))
+	for i, rte := range query.RangeTables {
+		if rte.ValuesLists != nil {
+			newRte := *rte
+			newRte.ValuesLists = make([][]Expr, len(rte.ValuesLists))
+			for j, row := range rte.ValuesLists {
+				newRte.ValuesLists[j] = make([]Expr, len(row))
+				for k, expr := range row {
+					newRte.ValuesLists[j][k] = fn(expr)
+				}
+			}
+			rte = &newRte
+		}
+		newQuery.RangeTables[i] = rte
+	}
+	return &newQuery
+}
+
+func mutateJoinTree(node Node, mutate func(Expr) Expr) Node {
+	switch n := node.(type) {
+	case *FromExpr:
+		newNode := &FromExpr{Quals: mutate(n.Quals)}
+		for _, item := range n.FromList {
+			newNode.FromList = append(newNode.FromList, mutateJoinTree(item, mutate))
+		}
+		return newNode
+	case *JoinExpr:
+		newNode := *n
+		newNode.Larg = mutateJoinTree(n.Larg, mutate)
+		newNode.Rarg = mutateJoinTree(n.Rarg, mutate)
+		newNode.Quals = mutate(n.Quals)
+		return &newNode
+	case *RangeTblRef:
+		newNode := *n
+		return &newNode
+	}
+	panic("unrecognized join tree node")
+}
+
+// Calls fn on each node of the expression, and of the queries of its
+// SubLinks and of their subqueries, with the number of levels of
+// subqueries the node is below the expression, as the walkers of postgres
+// that count sublevels_up do.  The walk stops, and true is returned, once
+// fn returns true.
+func WalkExprTree(expr Expr, fn func(expr Expr, levelsUp int) bool) bool {
+	return walkExprTree(expr, 0, fn)
+}
+
+// Calls fn on each node of the expressions of the query, and of its
+// subqueries, as WalkExprTree does, those of the query being at level 0.
+func WalkQueryTree(query *Query, fn func(expr Expr, levelsUp int) bool) bool {
+	return walkQueryTree(query, 0, fn)
+}
+
+func walkExprTree(expr Expr, levelsUp int, fn func(Expr, int) bool) bool {
+	return WalkExpr(expr, func(e Expr) bool {
+		if fn(e, levelsUp) {
+			return true
+		} else if sublink, ok := e.(*SubLink); ok {
+			return walkQueryTree(sublink.Subselect, levelsUp+1, fn)
+		}
+		return false
+	})
+}
+
+func walkQueryTree(query *Query, levelsUp int, fn func(Expr, int) bool) bool {
+	if WalkQueryExprs(query, func(e Expr) bool { return walkExprTree(e, levelsUp, fn) }) {
+		return true
+	}
+	for _, rte := range query.RangeTables {
+		if rte.RteType == RTE_SUBQUERY && walkQueryTree(rte.Subquery, levelsUp+1, fn) {
+			return true
+		}
+	}
+	return false
+}
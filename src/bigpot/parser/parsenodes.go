@@ -0,0 +1,312 @@
+package parser
+
+// The statements other than queries, which the parser passes on as they
+// are, as postgres' utility statements in parsenodes.h.
+
+// TypeName names the type of a column, as postgres' TypeName.
+type TypeName struct {
+	Name    string
+	IsArray bool
+}
+
+// ConstKind is the kind of the value of an AConst.
+type ConstKind int
+
+const (
+	ConstInteger = ConstKind(iota)
+	ConstFloat
+	ConstString
+	ConstNull
+)
+
+// AConst is a literal constant, as postgres' A_Const.  Ival holds an
+// integer, and Str a string or the digits of a float.
+type AConst struct {
+	Kind ConstKind
+	Ival int
+	Str  string
+}
+
+// AExprKind is the kind of an AExpr.
+type AExprKind int
+
+const (
+	AEXPR_OP = AExprKind(iota)
+	AEXPR_AND
+	AEXPR_OR
+	AEXPR_NOT
+	AEXPR_IS_NULL
+	AEXPR_IS_NOT_NULL
+	AEXPR_BETWEEN
+	AEXPR_NOT_BETWEEN
+	AEXPR_IN
+)
+
+// AExpr is an operator expression as it was written, as postgres' A_Expr,
+// which also stands for the raw AND, OR, NOT and NULL tests here.  Lexpr
+// is nil for a prefix operator, and Rexpr for a NULL test.  Of BETWEEN,
+// Rexpr is the []Node of the bounds, and of IN, of the values, which
+// are compared by the operator Name, = or <>.
+type AExpr struct {
+	Kind  AExprKind
+	Name  string
+	Lexpr Node
+	Rexpr Node
+}
+
+// TypeCast is a cast written as CAST(x AS type) or x::type, as postgres'
+// TypeCast.
+type TypeCast struct {
+	Arg      Node
+	TypeName *TypeName
+}
+
+// FuncCall is a function call as it was written, as postgres' FuncCall.
+// AggStar is set for an aggregate written as agg(*), and AggDistinct for
+// agg(DISTINCT ...).
+type FuncCall struct {
+	FuncName    string
+	Args        []Node
+	AggStar     bool
+	AggDistinct bool
+}
+
+// ACase is a CASE expression as it was written, as postgres' raw
+// CaseExpr.  Arg is nil for the searched form, CASE WHEN cond THEN ...,
+// and DefResult is nil without ELSE.
+type ACase struct {
+	Arg       Node
+	Whens     []*ACaseWhen
+	DefResult Node
+}
+
+// ACaseWhen is a WHEN clause of an ACase, as postgres' raw CaseWhen.
+type ACaseWhen struct {
+	Expr   Node
+	Result Node
+}
+
+// ACoalesce is COALESCE(...) as it was written, as postgres' raw
+// CoalesceExpr.
+type ACoalesce struct {
+	Args []Node
+}
+
+// SubLinkType is the kind of a sublink, as postgres' SubLinkType.
+type SubLinkType int
+
+const (
+	EXISTS_SUBLINK = SubLinkType(iota)
+	ANY_SUBLINK
+	EXPR_SUBLINK
+)
+
+// ASubLink is a subquery within an expression as it was written, as
+// postgres' raw SubLink: EXISTS (SELECT ...), x IN (SELECT ...), which is
+// an ANY_SUBLINK comparing Testexpr to the rows by the operator OperName,
+// or a scalar (SELECT ...).
+type ASubLink struct {
+	SubLinkType SubLinkType
+	Testexpr    Node
+	OperName    string
+	Subselect   *SelectStmt
+}
+
+// SetToDefault is DEFAULT in place of a value of VALUES in an INSERT, as
+// postgres' SetToDefault.  The column is given its default.
+type SetToDefault struct{}
+
+// ConstrType is the kind of a Constraint.
+type ConstrType int
+
+const (
+	ConstrNull = ConstrType(iota)
+	ConstrNotNull
+	ConstrDefault
+)
+
+// Constraint is a column constraint, as postgres' Constraint.  RawExpr is
+// the expression of a DEFAULT.
+type Constraint struct {
+	Contype ConstrType
+	RawExpr Node
+}
+
+// ColumnDef is a column of CREATE TABLE or ALTER TABLE, as postgres'
+// ColumnDef.  RawDefault is the expression of its DEFAULT, if it has one.
+type ColumnDef struct {
+	ColName    string
+	TypeName   *TypeName
+	IsNotNull  bool
+	RawDefault Node
+}
+
+// CreateStmt is CREATE TABLE, as postgres' CreateStmt.
+type CreateStmt struct {
+	Relation  *RangeVar
+	TableElts []*ColumnDef
+}
+
+// JoinType is the kind of a join, as postgres' JoinType.  An outer join
+// keeps the rows of its left side, its right side or both that match no
+// row of the other, with NULLs for the columns of the other.
+type JoinType int
+
+const (
+	JOIN_INNER = JoinType(iota)
+	JOIN_LEFT
+	JOIN_FULL
+	JOIN_RIGHT
+)
+
+// RangeSubselect is a subquery of the FROM clause, as postgres'
+// RangeSubselect.  It must have an Alias.
+type RangeSubselect struct {
+	Subquery *SelectStmt
+	Alias    *Alias
+}
+
+// RangeJoin is a JOIN of the FROM clause as it was written, as postgres'
+// JoinExpr is before analysis.  Larg and Rarg are RangeVars,
+// RangeSubselects or RangeJoins; the condition is the column names of
+// UsingClause, the Quals of ON, or the common column names if IsNatural,
+// and none for a CROSS JOIN.
+type RangeJoin struct {
+	JoinType    JoinType
+	IsNatural   bool
+	Larg        Node
+	Rarg        Node
+	UsingClause []string
+	Quals       Node
+	Alias       *Alias
+}
+
+// SortByDir is the direction of an ORDER BY item, as postgres' SortByDir.
+type SortByDir int
+
+const (
+	SORTBY_DEFAULT = SortByDir(iota)
+	SORTBY_ASC
+	SORTBY_DESC
+)
+
+// SortByNulls tells where an ORDER BY item puts NULLs, as postgres'
+// SortByNulls.  By default they are last if ascending, and first if
+// descending, as NULL sorts after all the values.
+type SortByNulls int
+
+const (
+	SORTBY_NULLS_DEFAULT = SortByNulls(iota)
+	SORTBY_NULLS_FIRST
+	SORTBY_NULLS_LAST
+)
+
+// SortBy is an item of ORDER BY as it was written, as postgres' SortBy.
+type SortBy struct {
+	Node        Node
+	SortbyDir   SortByDir
+	SortbyNulls SortByNulls
+}
+
+// InsertStmt is INSERT, as postgres' InsertStmt.  Cols are the columns
+// named, or empty for all, and SelectStmt the SELECT or VALUES of the rows.
+type InsertStmt struct {
+	Relation      *RangeVar
+	Cols          []string
+	SelectStmt    *SelectStmt
+	ReturningList []*ResTarget
+}
+
+// UpdateStmt is UPDATE, as postgres' UpdateStmt.  TargetList holds the
+// columns set, by name, with their new values.
+type UpdateStmt struct {
+	Relation      *RangeVar
+	TargetList    []*ResTarget
+	FromClause    []Node
+	WhereClause   Node
+	ReturningList []*ResTarget
+}
+
+// DeleteStmt is DELETE, as postgres' DeleteStmt.
+type DeleteStmt struct {
+	Relation      *RangeVar
+	UsingClause   []Node
+	WhereClause   Node
+	ReturningList []*ResTarget
+}
+
+// DropBehavior tells whether DROP also drops the objects depending on
+// those named, as postgres' DropBehavior.
+type DropBehavior int
+
+const (
+	DropRestrict = DropBehavior(iota)
+	DropCascade
+)
+
+// DropStmt is DROP TABLE, as postgres' DropStmt.
+type DropStmt struct {
+	Objects   []*RangeVar
+	MissingOk bool
+	Behavior  DropBehavior
+}
+
+// AlterTableType is the kind of an AlterTableCmd, as postgres'
+// AlterTableType.
+type AlterTableType int
+
+const (
+	AT_AddColumn = AlterTableType(iota)
+	AT_DropColumn
+	AT_AlterColumnType
+)
+
+// AlterTableCmd is one of the changes of an ALTER TABLE, as postgres'
+// AlterTableCmd.  Name is the column to drop or alter, and Def the column
+// to add or, for AT_AlterColumnType, the new type.
+type AlterTableCmd struct {
+	Subtype   AlterTableType
+	Name      string
+	Def       *ColumnDef
+	Behavior  DropBehavior
+	MissingOk bool
+}
+
+// AlterTableStmt is ALTER TABLE, as postgres' AlterTableStmt.
+type AlterTableStmt struct {
+	Relation  *RangeVar
+	Cmds      []*AlterTableCmd
+	MissingOk bool
+}
+
+// RenameStmt is ALTER TABLE ... RENAME, as postgres' RenameStmt.  SubName
+// is the column to rename, or empty to rename the table.
+type RenameStmt struct {
+	Relation  *RangeVar
+	SubName   string
+	NewName   string
+	MissingOk bool
+}
+
+// TransactionStmtKind is the kind of a TransactionStmt.
+type TransactionStmtKind int
+
+const (
+	TransStmtBegin = TransactionStmtKind(iota)
+	TransStmtCommit
+	TransStmtRollback
+)
+
+// TransactionStmt is BEGIN, COMMIT or ROLLBACK, as postgres'
+// TransactionStmt.
+type TransactionStmt struct {
+	Kind TransactionStmtKind
+}
+
+// VariableSetStmt is SET name TO value, as postgres' VariableSetStmt.  The
+// value is empty for SET name TO DEFAULT.
+type VariableSetStmt struct {
+	Name    string
+	Value   string
+	Default bool
+}
@@ -1,8 +1,7 @@
 package parser
 
-import "errors"
-
 //import "bigpot/relation"
+import "fmt"
 import "bigpot/access"
 import "bigpot/system"
 
@@ -13,8 +12,12 @@ const (
 	CMD_INSERT
 	CMD_UPDATE
 	CMD_DELETE
+	CMD_UTILITY
 )
 
+// Alias is the name a FROM item is referred to by, with the names of its
+// columns, as postgres' Alias.  The user may write the names of the first
+// columns only.
 type Alias struct {
 	AliasName   string
 	ColumnNames []string
@@ -23,7 +26,7 @@ type Alias struct {
 type RangeVar struct {
 	SchemaName   system.Name
 	RelationName system.Name
-	AliasName    Alias
+	Alias        *Alias
 }
 
 type Expr interface {
@@ -34,18 +37,199 @@ type ExprImpl struct {
 	resultType system.Oid
 }
 
+// Var is a column of an entry of the range table, as postgres' Var.
+// VarNo is the index of the entry, from 1, or INNER_VAR or OUTER_VAR for
+// a column of the rows a join reads, and VarAttNo the number of the
+// column, or a system column's.  VarLevelsUp is 0 for a column of the
+// query the Var is in, and otherwise the number of levels up the query
+// it refers to is, from within a subquery.
 type Var struct {
 	ExprImpl
-	// TODO: AttributeId is int32?
-	VarNo    uint16
-	VarAttNo uint16
+	VarNo       uint16
+	VarAttNo    system.AttrNumber
+	VarLevelsUp uint16
+}
+
+const (
+	INNER_VAR = 65000
+	OUTER_VAR = 65001
+)
+
+// Const is a constant, as postgres' Const.  A NULL is a nil Value.  A
+// string literal is of UnknownType, with a Text value, until it is
+// coerced.
+type Const struct {
+	ExprImpl
+	Value system.Datum
+}
+
+// OpExpr is an operator applied to its arguments, as postgres' OpExpr.  A
+// prefix operator has a single argument.
+type OpExpr struct {
+	ExprImpl
+	Opr  *system.OperatorInfo
+	Args []Expr
+}
+
+// BoolExprType is the operator of a BoolExpr.
+type BoolExprType int
+
+const (
+	AND_EXPR = BoolExprType(iota)
+	OR_EXPR
+	NOT_EXPR
+)
+
+// BoolExpr is AND, OR or NOT of boolean arguments, as postgres' BoolExpr.
+// NOT has a single argument; AND and OR have two or more.
+type BoolExpr struct {
+	ExprImpl
+	BoolOp BoolExprType
+	Args   []Expr
+}
+
+// NullTestType tells whether a NullTest is IS NULL or IS NOT NULL.
+type NullTestType int
+
+const (
+	IS_NULL = NullTestType(iota)
+	IS_NOT_NULL
+)
+
+// NullTest is IS [NOT] NULL, as postgres' NullTest.
+type NullTest struct {
+	ExprImpl
+	Arg          Expr
+	NullTestType NullTestType
+}
+
+// CoerceViaIO converts its argument to the result type through the text
+// form of the value, as postgres' CoerceViaIO.  The types without a
+// function for their cast are converted this way.
+type CoerceViaIO struct {
+	ExprImpl
+	Arg Expr
+}
+
+// FuncExpr is a function call, as postgres' FuncExpr.
+type FuncExpr struct {
+	ExprImpl
+	Func *system.ProcInfo
+	Args []Expr
+}
+
+// Aggref is a call of an aggregate, as postgres' Aggref.  Its value is
+// that of the aggregate over the rows of the group, distinct ones only if
+// AggDistinct, which the Agg node computes; AggNo is the index of the
+// aggregate among those of the node, which the planner assigns.
+type Aggref struct {
+	ExprImpl
+	Agg         *system.AggregateInfo
+	Args        []Expr
+	AggDistinct bool
+	AggNo       int
+}
+
+// CaseExpr is a CASE expression, as postgres' CaseExpr.  If Arg is not
+// nil, each WHEN compares a CaseTestExpr standing for its value.
+// DefResult is a NULL Const if there is no ELSE.
+type CaseExpr struct {
+	ExprImpl
+	Arg       Expr
+	Args      []*CaseWhen
+	DefResult Expr
+}
+
+// CaseWhen is a WHEN clause of a CaseExpr, as postgres' CaseWhen.
+type CaseWhen struct {
+	Expr   Expr
+	Result Expr
+}
+
+// CaseTestExpr is the value of the Arg of the CaseExpr it is in, as
+// postgres' CaseTestExpr.
+type CaseTestExpr struct {
+	ExprImpl
+}
+
+// CoalesceExpr is COALESCE(...), as postgres' CoalesceExpr.
+type CoalesceExpr struct {
+	ExprImpl
+	Args []Expr
+}
+
+// SubLink is a subquery within an expression, as postgres' SubLink.  An
+// EXISTS_SUBLINK is true if the Subselect returns a row; an
+// EXPR_SUBLINK is the value of the single column of its single row, or
+// NULL if there is none; an ANY_SUBLINK is true if the Testexpr is true
+// for some row, with the column of the row as a Param of PARAM_SUBLINK.
+type SubLink struct {
+	ExprImpl
+	SubLinkType SubLinkType
+	Testexpr    Expr
+	Subselect   *Query
+}
+
+// ParamKind is the kind of a Param.
+type ParamKind int
+
+const (
+	// a value the executor sets, as a column of an outer query for a
+	// subquery, or a column of the row of a subquery for its Testexpr
+	PARAM_EXEC = ParamKind(iota)
+	// a column of the row of the subquery of a SubLink, by its number
+	PARAM_SUBLINK
+)
+
+// Param is a value given to the expression from outside it, as postgres'
+// Param.  ParamId is the index of the value among those of the executor,
+// or the number of the column of PARAM_SUBLINK.
+type Param struct {
+	ExprImpl
+	ParamKind ParamKind
+	ParamId   int
+}
+
+// SubPlan is a SubLink planned, as postgres' SubPlan.  PlanId is the
+// index of the plan of the subquery in the SubPlans of the PlanRoot, from
+// 1.  The values of the columns of the outer query the subquery reads
+// are computed by Args, and set into the Params of ParParam before it is
+// run; ExtParam are all the Params its plan reads.  The Testexpr of an
+// ANY_SUBLINK reads the columns of each row by the Params of ParamIds.
+type SubPlan struct {
+	ExprImpl
+	SubLinkType SubLinkType
+	Testexpr    Expr
+	PlanId      int
+	ParamIds    []int
+	ParParam    []int
+	Args        []Expr
+	ExtParam    []int
 }
 
+// TargetEntry is an expression of a target list, as postgres'
+// TargetEntry.  ResSortGroupRef is the number the SortGroupClauses refer
+// to it by, or 0 if none does; a junk entry is computed for them, or for
+// the executor, but not returned.
 type TargetEntry struct {
-	Expr    Expr
-	ResNo   uint16
-	ResName system.Name
-	ResJunk bool
+	Expr            Expr
+	ResNo           uint16
+	ResName         system.Name
+	ResSortGroupRef uint
+	ResJunk         bool
+}
+
+// SortGroupClause is an item of ORDER BY, GROUP BY or DISTINCT, as
+// postgres' SortGroupClause: the target entry whose ResSortGroupRef is
+// TleSortGroupRef, in descending order if Descending, with NULLs first if
+// NullsFirst.  The rows are compared by the comparison function of its
+// type, in place of the sort and equality operators, and can be grouped
+// by hashing if Hashable, its type having a hash function.
+type SortGroupClause struct {
+	TleSortGroupRef uint
+	Descending      bool
+	NullsFirst      bool
+	Hashable        bool
 }
 
 type RteType int
@@ -62,23 +246,152 @@ const (
 type RangeTblEntry struct {
 	RteType RteType
 	/* for relation */
-	RelId    system.Oid
-	RefAlias *Alias
+	RelId system.Oid
+	/* for subquery */
+	Subquery *Query
+	/* for values, each row coerced to the types of the columns */
+	ValuesLists [][]Expr
+	/*
+	 * for join, the expressions of its columns over the columns of the
+	 * tables joined, which references to them are replaced with
+	 */
+	JoinType      JoinType
+	JoinAliasVars []Expr
+	RefAlias      *Alias
+	// the types of the columns, as postgres' coltypes; those of dropped
+	// columns too
+	ColTypes []system.Oid
+}
+
+// RangeTblRef refers to an entry of the range table by its index, from 1,
+// as postgres' RangeTblRef.
+type RangeTblRef struct {
+	RtIndex int
+}
+
+// JoinExpr is a JOIN of the join tree, as postgres' JoinExpr: the join
+// of Larg and Rarg, each a RangeTblRef or a JoinExpr, by the condition
+// Quals, nil if there is none.  RtIndex is the index of its RTE_JOIN entry
+// of the range table, from 1.
+type JoinExpr struct {
+	JoinType JoinType
+	Larg     Node
+	Rarg     Node
+	Quals    Expr
+	RtIndex  int
+}
+
+// FromExpr is the FROM and WHERE clauses of a query, as postgres'
+// FromExpr.  The FromList items, RangeTblRefs or JoinExprs, are joined by
+// inner joins.  Quals is nil if there is no WHERE.
+type FromExpr struct {
+	FromList []Node
+	Quals    Expr
 }
 
 type Query struct {
 	CommandType CommandType
 	TargetList  []*TargetEntry
 	RangeTables []*RangeTblEntry
+	JoinTree    *FromExpr
+	// the index of the range table entry of the table an INSERT, UPDATE
+	// or DELETE changes, from 1, and the RETURNING list computed from the
+	// rows changed
+	ResultRelation int
+	ReturningList  []*TargetEntry
+	// whether the target list or the HAVING calls aggregates, and the
+	// GROUP BY, the HAVING and the DISTINCT, nil if not given
+	HasAggs        bool
+	GroupClause    []*SortGroupClause
+	HavingQual     Expr
+	DistinctClause []*SortGroupClause
+	// the ORDER BY, and the OFFSET and LIMIT, nil if not given
+	SortClause  []*SortGroupClause
+	LimitOffset Expr
+	LimitCount  Expr
+	// the statement of a CMD_UTILITY, as it was parsed
+	UtilityStmt Node
 }
 
 type Parser interface {
 	Parse(query_string string) *Query
 }
 
+// namespaceItem is an entry of the range table as the names of a query
+// see it, as postgres' ParseNamespaceItem: by its name if relVisible,
+// and by its columns if colsVisible.
+type namespaceItem struct {
+	rte         *RangeTblEntry
+	relVisible  bool
+	colsVisible bool
+}
+
+// parseExprKind is the construct an expression is transformed for, where
+// that limits what it may hold, as postgres' ParseExprKind.
+type parseExprKind int
+
+const (
+	exprKindNone = parseExprKind(iota)
+	exprKindColumnDefault
+)
+
 type ParserImpl struct {
-	query     string
-	namespace []*RangeTblEntry
+	query string
+	// the range table of the query, the entries of it column references
+	// can see, and the items of the FROM as they go to the join tree
+	rtable    []*RangeTblEntry
+	namespace []*namespaceItem
+	joinlist  []Node
+	// whether an aggregate was called
+	hasAggs bool
+	// the construct of the expression being transformed
+	exprKind parseExprKind
+	// the parser of the query a subquery is in, whose columns it may
+	// refer to, as postgres' parentParseState
+	parent   *ParserImpl
+	relcache *access.RelCache
+	syscache *access.SysCache
+	// the session's time zone, in which literals of timestamptz are read
+	timeZone *system.TimeZone
+}
+
+// Makes a parser looking relations up through the session's caches.
+// Literals are read in UTC until SetTimeZone is called.
+func NewParser(relcache *access.RelCache, syscache *access.SysCache) *ParserImpl {
+	return &ParserImpl{
+		relcache: relcache,
+		syscache: syscache,
+		timeZone: system.UTC,
+	}
+}
+
+// Sets the time zone literals are read in, as postgres' TimeZone setting.
+func (parser *ParserImpl) SetTimeZone(tz *system.TimeZone) {
+	parser.timeZone = tz
+}
+
+// Makes a parser of the same session, with no query state.
+func (parser *ParserImpl) newParser() *ParserImpl {
+	sub := NewParser(parser.relcache, parser.syscache)
+	sub.timeZone = parser.timeZone
+	return sub
+}
+
+// Makes the parser of a subquery of the query, as postgres'
+// make_parsestate does with the parent's state.
+func (parser *ParserImpl) newSubParser() *ParserImpl {
+	sub := parser.newParser()
+	sub.parent = parser
+	return sub
+}
+
+// Returns the parser of the query the number of levels up, as
+// IncrementVarSublevelsUp counts them.
+func (parser *ParserImpl) ancestor(levelsUp int) *ParserImpl {
+	for ; levelsUp > 0; levelsUp-- {
+		parser = parser.parent
+	}
+	return parser
 }
 
 type ParserError struct {
@@ -96,9 +409,59 @@ func parseError(msg string) error {
 }
 
 func (parser *ParserImpl) Parse(query_string string) (*Query, error) {
+	stmts, err := RawParse(query_string)
+	if err != nil {
+		return nil, err
+	} else if len(stmts) == 0 {
+		return nil, parseError("empty query")
+	}
+	return parser.Analyze(stmts[0])
+}
+
+// Parses the query string into its statements, as postgres' raw_parser.
+// Syntax errors are returned as ParserError.
+func RawParse(query_string string) (stmts []Node, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			perr, ok := r.(ParserError)
+			if !ok {
+				panic(r)
+			}
+			stmts, err = nil, perr
+		}
+	}()
+	TopList = nil
 	lexer := newLexer(query_string)
 	yyParse(lexer)
-	node := TopList[0]
+	return TopList, nil
+}
+
+// Parses the string as an expression alone, as the source text of a
+// column default is.
+func RawParseExpr(expr string) (node Node, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			perr, ok := r.(ParserError)
+			if !ok {
+				panic(r)
+			}
+			node, err = nil, perr
+		}
+	}()
+	TopList = nil
+	lexer := newLexer(expr)
+	lexer.modeToken = MODE_EXPR
+	yyParse(lexer)
+	return TopList[0], nil
+}
+
+// Transforms a statement into a Query, as postgres' parse_analyze.  The
+// parser must be fresh for each statement.
+func (parser *ParserImpl) Analyze(node Node) (*Query, error) {
+	parser.rtable = nil
+	parser.namespace = nil
+	parser.joinlist = nil
+	parser.hasAggs = false
 	return parser.transformStmt(node)
 }
 
@@ -108,6 +471,14 @@ func (parser *ParserImpl) transformStmt(node Node) (*Query, error) {
 		return nil, parseError("unknown node type")
 	case *SelectStmt:
 		return parser.transformSelectStmt(node.(*SelectStmt))
+	case *InsertStmt:
+		return parser.transformInsertStmt(node.(*InsertStmt))
+	case *UpdateStmt:
+		return parser.transformUpdateStmt(node.(*UpdateStmt))
+	case *DeleteStmt:
+		return parser.transformDeleteStmt(node.(*DeleteStmt))
+	case *CreateStmt, *DropStmt, *AlterTableStmt, *RenameStmt, *TransactionStmt, *VariableSetStmt:
+		return &Query{CommandType: CMD_UTILITY, UtilityStmt: node}, nil
 	}
 	panic("unreachable")
 }
@@ -115,75 +486,868 @@ func (parser *ParserImpl) transformStmt(node Node) (*Query, error) {
 func (parser *ParserImpl) transformSelectStmt(stmt *SelectStmt) (query *Query, err error) {
 	query = &Query{CommandType: CMD_SELECT}
 	err = nil
-	if err = parser.transformFromClause(stmt); err != nil {
+	if stmt.valuesLists != nil {
+		err = system.Ereport(system.FeatureNotSupported, "VALUES is only supported in INSERT")
+		return
+	}
+	if err = parser.transformFromClause(stmt.fromList); err != nil {
 		return
 	}
 	if query.TargetList, err =
 		parser.transformTargetList(stmt.targetList); err != nil {
 		return
 	}
+	if query.JoinTree, err = parser.makeJoinTree(stmt.whereClause); err != nil {
+		return
+	}
+	if query.HavingQual, err = parser.transformWhereClause(stmt.havingClause, "HAVING"); err != nil {
+		return
+	}
+	if query.SortClause, err = parser.transformSortClause(stmt.sortClause, query); err != nil {
+		return
+	}
+	if query.GroupClause, err = parser.transformGroupClause(stmt.groupClause, query); err != nil {
+		return
+	}
+	if stmt.distinct {
+		if query.DistinctClause, err = transformDistinctClause(query); err != nil {
+			return
+		}
+	}
+	if query.LimitOffset, err = parser.transformLimitClause(stmt.limitOffset, "OFFSET"); err != nil {
+		return
+	}
+	if query.LimitCount, err = parser.transformLimitClause(stmt.limitCount, "LIMIT"); err != nil {
+		return
+	}
+	query.HasAggs = parser.hasAggs
+	if query.HasAggs || query.GroupClause != nil || query.HavingQual != nil {
+		if err = parser.parseCheckAggregates(query); err != nil {
+			return
+		}
+	}
 
-	query.RangeTables = parser.namespace
+	query.RangeTables = parser.rtable
 
 	return
 }
 
-func (parser *ParserImpl) transformFromClause(stmt *SelectStmt) error {
-	for _, item := range stmt.fromList {
-		switch item.(type) {
-		default:
-			return parseError("unknown node type")
-		case *RangeVar:
-			rv := item.(*RangeVar)
-			rte := &RangeTblEntry{}
-			relation, err := rv.OpenRelation()
+// Transforms an INSERT, as postgres' transformInsertStmt.  The rows come
+// from the VALUES or the SELECT, the second entry of the range table; the
+// target list computes each column of the table from them, in order, with
+// the default of those not named.
+func (parser *ParserImpl) transformInsertStmt(stmt *InsertStmt) (*Query, error) {
+	query := &Query{CommandType: CMD_INSERT}
+	relation, target, err := parser.setTargetTable(stmt.Relation)
+	if err != nil {
+		return nil, err
+	}
+	attrs, err := checkInsertTargets(relation, stmt.Cols)
+	if err != nil {
+		return nil, err
+	}
+
+	// the rows are transformed before the target is in the range table,
+	// as they cannot refer to it
+	var source *RangeTblEntry
+	if lists := stmt.SelectStmt.valuesLists; lists != nil {
+		if attrs, err = checkInsertLength(attrs, len(lists[0]), len(stmt.Cols) > 0); err != nil {
+			return nil, err
+		}
+		if source, err = parser.transformValuesLists(lists, relation, attrs); err != nil {
+			return nil, err
+		}
+	} else {
+		subquery, err := parser.newParser().transformSelectStmt(stmt.SelectStmt)
+		if err != nil {
+			return nil, err
+		}
+		// the junk entries of the ORDER BY come last, and are no columns
+		alias := &Alias{AliasName: "*SELECT*"}
+		source = &RangeTblEntry{RteType: RTE_SUBQUERY, Subquery: subquery, RefAlias: alias}
+		for _, tle := range subquery.TargetList {
+			if !tle.ResJunk {
+				alias.ColumnNames = append(alias.ColumnNames, string(tle.ResName))
+				source.ColTypes = append(source.ColTypes, tle.Expr.ResultType())
+			}
+		}
+		if attrs, err = checkInsertLength(attrs, len(alias.ColumnNames), len(stmt.Cols) > 0); err != nil {
+			return nil, err
+		}
+	}
+	query.ResultRelation = parser.addRangeTblEntry(target, false)
+	parser.addRangeTblEntry(source, false)
+
+	values := map[*access.Attribute]Expr{}
+	for i, attr := range attrs {
+		variable, err := parser.makeVar(source, i)
+		if err != nil {
+			return nil, err
+		}
+		if values[attr], err = parser.transformAssignedExpr(variable, attr); err != nil {
+			return nil, err
+		}
+	}
+	for i, attr := range relation.RelDesc.Attrs {
+		expr, ok := values[attr]
+		if !ok {
+			if expr, err = parser.buildColumnDefault(relation, i); err != nil {
+				return nil, err
+			}
+		}
+		query.TargetList = append(query.TargetList,
+			&TargetEntry{Expr: expr, ResNo: uint16(i + 1), ResName: attr.Name})
+	}
+
+	parser.namespace = []*namespaceItem{{target, true, true}}
+	if query.ReturningList, err = parser.transformReturningList(stmt.ReturningList); err != nil {
+		return nil, err
+	}
+	query.RangeTables = parser.rtable
+	return query, nil
+}
+
+// Transforms an UPDATE, as postgres' transformUpdateStmt.  The table is
+// joined with the FROM items; the target list computes the new version of
+// each row that satisfies the WHERE, from the SET values and the columns
+// not set, followed by the junk ctid of the old version.
+func (parser *ParserImpl) transformUpdateStmt(stmt *UpdateStmt) (*Query, error) {
+	query := &Query{CommandType: CMD_UPDATE}
+	relation, target, err := parser.setTargetTable(stmt.Relation)
+	if err != nil {
+		return nil, err
+	}
+	query.ResultRelation = parser.addRangeTblEntry(target, true)
+	parser.joinlist = append(parser.joinlist, &RangeTblRef{RtIndex: query.ResultRelation})
+	if err := parser.transformFromClause(stmt.FromClause); err != nil {
+		return nil, err
+	}
+	if query.JoinTree, err = parser.makeJoinTree(stmt.WhereClause); err != nil {
+		return nil, err
+	}
+
+	values := map[*access.Attribute]Expr{}
+	for _, item := range stmt.TargetList {
+		var attr *access.Attribute
+		for _, candidate := range relation.RelDesc.Attrs {
+			if !candidate.IsDropped && string(candidate.Name) == item.name {
+				attr = candidate
+			}
+		}
+		if attr == nil {
+			return nil, system.Ereport(system.UndefinedColumn,
+				"column \"%s\" of relation \"%s\" does not exist", item.name, relation.RelName)
+		} else if values[attr] != nil {
+			return nil, system.Ereport(system.SyntaxError,
+				"multiple assignments to same column \"%s\"", item.name)
+		}
+		expr, err := parser.transformExpr(item.val)
+		if err != nil {
+			return nil, err
+		} else if err = checkNoAggregates(expr, "UPDATE"); err != nil {
+			return nil, err
+		}
+		if values[attr], err = parser.transformAssignedExpr(expr, attr); err != nil {
+			return nil, err
+		}
+	}
+	for i, attr := range relation.RelDesc.Attrs {
+		expr, ok := values[attr]
+		if !ok && attr.IsDropped {
+			expr = &Const{ExprImpl{attr.TypeId}, nil}
+		} else if !ok {
+			if expr, err = parser.makeVar(target, i); err != nil {
+				return nil, err
+			}
+		}
+		query.TargetList = append(query.TargetList,
+			&TargetEntry{Expr: expr, ResNo: uint16(i + 1), ResName: attr.Name})
+	}
+	query.TargetList = append(query.TargetList, makeCtidTargetEntry(query.ResultRelation, len(query.TargetList)+1))
+
+	parser.namespace = []*namespaceItem{{target, true, true}}
+	if query.ReturningList, err = parser.transformReturningList(stmt.ReturningList); err != nil {
+		return nil, err
+	}
+	query.RangeTables = parser.rtable
+	return query, nil
+}
+
+// Transforms a DELETE, as postgres' transformDeleteStmt.  The table is
+// joined with the USING items; the target list is the junk ctid of each
+// row that satisfies the WHERE.
+func (parser *ParserImpl) transformDeleteStmt(stmt *DeleteStmt) (*Query, error) {
+	query := &Query{CommandType: CMD_DELETE}
+	_, target, err := parser.setTargetTable(stmt.Relation)
+	if err != nil {
+		return nil, err
+	}
+	query.ResultRelation = parser.addRangeTblEntry(target, true)
+	parser.joinlist = append(parser.joinlist, &RangeTblRef{RtIndex: query.ResultRelation})
+	if err := parser.transformFromClause(stmt.UsingClause); err != nil {
+		return nil, err
+	}
+	if query.JoinTree, err = parser.makeJoinTree(stmt.WhereClause); err != nil {
+		return nil, err
+	}
+	query.TargetList = []*TargetEntry{makeCtidTargetEntry(query.ResultRelation, 1)}
+
+	parser.namespace = []*namespaceItem{{target, true, true}}
+	if query.ReturningList, err = parser.transformReturningList(stmt.ReturningList); err != nil {
+		return nil, err
+	}
+	query.RangeTables = parser.rtable
+	return query, nil
+}
+
+// Opens the table an INSERT, UPDATE or DELETE changes, as postgres'
+// setTargetTable, and makes its range table entry.
+func (parser *ParserImpl) setTargetTable(rv *RangeVar) (*access.HeapRelation, *RangeTblEntry, error) {
+	relation, err := parser.openRelation(rv)
+	if err != nil {
+		return nil, nil, err
+	} else if access.LookupCatalog(relation.RelId) != nil {
+		return nil, nil, system.Ereport(system.InsufficientPrivilege,
+			"permission denied: \"%s\" is a system catalog", rv.RelationName)
+	} else if relation.RelKind != access.RelKindRelation {
+		return nil, nil, system.Ereport(system.WrongObjectType,
+			"\"%s\" is not a table", rv.RelationName)
+	}
+	target := &RangeTblEntry{RteType: RTE_RELATION, RelId: relation.RelId,
+		ColTypes: relationColTypes(relation)}
+	if target.RefAlias, err = buildAlias(relation, rv.Alias); err != nil {
+		return nil, nil, err
+	}
+	return relation, target, nil
+}
+
+// Makes the junk entry of the target list that carries the ctid of the
+// row to change, as postgres' rewriteTargetListUD.
+func makeCtidTargetEntry(resultRelation int, resno int) *TargetEntry {
+	return &TargetEntry{
+		Expr:    makeCtidVar(resultRelation),
+		ResNo:   uint16(resno),
+		ResName: "ctid",
+		ResJunk: true,
+	}
+}
+
+func makeCtidVar(rtindex int) *Var {
+	return MakeVar(uint16(rtindex), system.CtidAttrNumber, system.TidType)
+}
+
+// Makes the join tree of the items of the FROM, with the WHERE clause as
+// its qual.
+func (parser *ParserImpl) makeJoinTree(whereClause Node) (*FromExpr, error) {
+	joinTree := &FromExpr{FromList: parser.joinlist}
+	var err error
+	if joinTree.Quals, err = parser.transformWhereClause(whereClause, "WHERE"); err != nil {
+		return nil, err
+	} else if err = checkNoAggregates(joinTree.Quals, "WHERE"); err != nil {
+		return nil, err
+	}
+	return joinTree, nil
+}
+
+// Transforms the RETURNING list, as postgres' transformReturningList.  It
+// sees the table changed only.
+func (parser *ParserImpl) transformReturningList(returningList []*ResTarget) ([]*TargetEntry, error) {
+	if returningList == nil {
+		return nil, nil
+	}
+	tlist, err := parser.transformTargetList(returningList)
+	if err != nil {
+		return nil, err
+	}
+	for _, tle := range tlist {
+		if err := checkNoAggregates(tle.Expr, "RETURNING"); err != nil {
+			return nil, err
+		}
+	}
+	return tlist, nil
+}
+
+// Returns the columns an INSERT names, or all the columns if it names
+// none, as postgres' checkInsertTargets.
+func checkInsertTargets(relation *access.HeapRelation, cols []string) ([]*access.Attribute, error) {
+	var attrs []*access.Attribute
+	if len(cols) == 0 {
+		for _, attr := range relation.RelDesc.Attrs {
+			if !attr.IsDropped {
+				attrs = append(attrs, attr)
+			}
+		}
+		return attrs, nil
+	}
+	seen := map[string]bool{}
+	for _, col := range cols {
+		var found *access.Attribute
+		for _, attr := range relation.RelDesc.Attrs {
+			if !attr.IsDropped && string(attr.Name) == col {
+				found = attr
+			}
+		}
+		if found == nil {
+			return nil, system.Ereport(system.UndefinedColumn,
+				"column \"%s\" of relation \"%s\" does not exist", col, relation.RelName)
+		} else if seen[col] {
+			return nil, system.Ereport(system.DuplicateColumn,
+				"column \"%s\" specified more than once", col)
+		}
+		seen[col] = true
+		attrs = append(attrs, found)
+	}
+	return attrs, nil
+}
+
+// Checks the number of expressions of the rows of an INSERT against the
+// columns it names, as postgres' transformInsertRow, and returns the
+// columns the expressions are stored in.  If it names none, the columns
+// past the expressions are left to their defaults.
+func checkInsertLength(attrs []*access.Attribute, nexprs int, explicit bool) ([]*access.Attribute, error) {
+	if nexprs > len(attrs) {
+		return nil, system.Ereport(system.SyntaxError, "INSERT has more expressions than target columns")
+	} else if nexprs < len(attrs) && explicit {
+		return nil, system.Ereport(system.SyntaxError, "INSERT has more target columns than expressions")
+	}
+	return attrs[:nexprs], nil
+}
+
+// Makes the VALUES entry of the range table, as postgres'
+// transformValuesClause, with the values coerced to the columns of the
+// INSERT.  A DEFAULT is replaced by the default of its column.
+func (parser *ParserImpl) transformValuesLists(lists [][]Node, relation *access.HeapRelation,
+	attrs []*access.Attribute) (*RangeTblEntry, error) {
+	rte := &RangeTblEntry{RteType: RTE_VALUES, RefAlias: &Alias{AliasName: "*VALUES*"}}
+	for _, list := range lists {
+		if len(list) != len(lists[0]) {
+			return nil, system.Ereport(system.SyntaxError, "VALUES lists must all be the same length")
+		}
+		row := make([]Expr, len(list))
+		for i, item := range list {
+			var err error
+			if _, ok := item.(*SetToDefault); ok {
+				if row[i], err = parser.buildColumnDefault(relation, attrIndex(relation, attrs[i])); err != nil {
+					return nil, err
+				}
+				continue
+			}
+			if row[i], err = parser.transformExpr(item); err != nil {
+				return nil, err
+			} else if err = checkNoAggregates(row[i], "VALUES"); err != nil {
+				return nil, err
+			}
+			if row[i], err = parser.transformAssignedExpr(row[i], attrs[i]); err != nil {
+				return nil, err
+			}
+		}
+		rte.ValuesLists = append(rte.ValuesLists, row)
+	}
+	for i, expr := range rte.ValuesLists[0] {
+		rte.RefAlias.ColumnNames = append(rte.RefAlias.ColumnNames, fmt.Sprintf("column%d", i+1))
+		rte.ColTypes = append(rte.ColTypes, expr.ResultType())
+	}
+	return rte, nil
+}
+
+// Coerces the value to the type of the column it is stored in, as
+// postgres' transformAssignedExpr.
+func (parser *ParserImpl) transformAssignedExpr(expr Expr, attr *access.Attribute) (Expr, error) {
+	typid := expr.ResultType()
+	if !canCoerceType(typid, attr.TypeId) {
+		return nil, system.Ereport(system.DatatypeMismatch,
+			"column \"%s\" is of type %s but expression is of type %s",
+			attr.Name, system.FormatType(attr.TypeId), system.FormatType(typid))
+	}
+	return parser.coerceType(expr, attr.TypeId, false)
+}
+
+// Returns the index of the column among those of the relation.
+func attrIndex(relation *access.HeapRelation, attr *access.Attribute) int {
+	for i, candidate := range relation.RelDesc.Attrs {
+		if candidate == attr {
+			return i
+		}
+	}
+	panic("column not found in relation")
+}
+
+// Transforms the default expression of the column and coerces it to the
+// type of the column, as postgres' cookDefault.  A default is computed
+// before there is a row, so it cannot refer to columns, nor hold
+// subqueries or aggregates.
+func (parser *ParserImpl) TransformColumnDefault(raw Node, attr *access.Attribute) (Expr, error) {
+	parser.exprKind = exprKindColumnDefault
+	defer func() { parser.exprKind = exprKindNone }()
+	expr, err := parser.transformExpr(raw)
+	if err != nil {
+		return nil, err
+	} else if err := checkNoAggregates(expr, "DEFAULT expressions"); err != nil {
+		return nil, err
+	}
+	typid := expr.ResultType()
+	if !canCoerceType(typid, attr.TypeId) {
+		return nil, system.Ereport(system.DatatypeMismatch,
+			"column \"%s\" is of type %s but default expression is of type %s",
+			attr.Name, system.FormatType(attr.TypeId), system.FormatType(typid))
+	}
+	return parser.coerceType(expr, attr.TypeId, false)
+}
+
+// Returns the default of the column, or a NULL if it has none, as
+// postgres' build_column_default.  The default is parsed again from its
+// source text in bp_attrdef, and so is read in the time zone of the
+// statement that fills the column in.
+func (parser *ParserImpl) buildColumnDefault(relation *access.HeapRelation, attidx int) (Expr, error) {
+	attr := relation.RelDesc.Attrs[attidx]
+	if !attr.IsDropped && relation.RelDesc.Constr != nil {
+		for _, def := range relation.RelDesc.Constr.Defaults {
+			if def.AttNum != system.AttrNumber(attidx+1) {
+				continue
+			}
+			raw, err := RawParseExpr(def.Src)
+			if err != nil {
+				return nil, err
+			}
+			return parser.newParser().TransformColumnDefault(raw, attr)
+		}
+	}
+	return &Const{ExprImpl{attr.TypeId}, nil}, nil
+}
+
+// Transforms the items of the FROM clause, as postgres'
+// transformFromClause, adding them to the range table, the namespace and
+// the join list.
+func (parser *ParserImpl) transformFromClause(fromList []Node) error {
+	for _, item := range fromList {
+		node, namespace, err := parser.transformFromClauseItem(item)
+		if err != nil {
+			return err
+		} else if err := checkNameSpaceConflicts(parser.namespace, namespace); err != nil {
+			return err
+		}
+		parser.namespace = append(parser.namespace, namespace...)
+		parser.joinlist = append(parser.joinlist, node)
+	}
+	return nil
+}
+
+// Transforms an item of the FROM clause, as postgres'
+// transformFromClauseItem, into its node of the join tree, a RangeTblRef
+// or a JoinExpr, and the namespace items of it.
+func (parser *ParserImpl) transformFromClauseItem(item Node) (Node, []*namespaceItem, error) {
+	switch n := item.(type) {
+	case *RangeVar:
+		relation, err := parser.openRelation(n)
+		if err != nil {
+			return nil, nil, err
+		}
+		rte := &RangeTblEntry{RteType: RTE_RELATION, RelId: relation.RelId,
+			ColTypes: relationColTypes(relation)}
+		if rte.RefAlias, err = buildAlias(relation, n.Alias); err != nil {
+			return nil, nil, err
+		}
+		rtindex := parser.addRangeTblEntry(rte, false)
+		return &RangeTblRef{RtIndex: rtindex}, []*namespaceItem{{rte, true, true}}, nil
+	case *RangeSubselect:
+		return parser.transformRangeSubselect(n)
+	case *RangeJoin:
+		return parser.transformJoin(n)
+	}
+	return nil, nil, parseError("unknown node type")
+}
+
+// Transforms a subquery of the FROM clause into its RTE_SUBQUERY entry, as
+// postgres' transformRangeSubselect.  The subquery cannot see the other
+// FROM items, but it can see the queries the query is in.  Its columns
+// are named by the alias, and then by the target list.
+func (parser *ParserImpl) transformRangeSubselect(r *RangeSubselect) (Node, []*namespaceItem, error) {
+	if r.Alias == nil {
+		return nil, nil, system.Ereport(system.SyntaxError, "subquery in FROM must have an alias")
+	}
+	saved := parser.namespace
+	parser.namespace = nil
+	subquery, err := parser.newSubParser().transformSelectStmt(r.Subquery)
+	parser.namespace = saved
+	if err != nil {
+		return nil, nil, err
+	}
+	rte := &RangeTblEntry{RteType: RTE_SUBQUERY, Subquery: subquery,
+		RefAlias: &Alias{AliasName: r.Alias.AliasName}}
+	userNames := r.Alias.ColumnNames
+	for _, tle := range subquery.TargetList {
+		if tle.ResJunk {
+			continue
+		}
+		name := string(tle.ResName)
+		if len(userNames) > 0 {
+			name, userNames = userNames[0], userNames[1:]
+		}
+		rte.RefAlias.ColumnNames = append(rte.RefAlias.ColumnNames, name)
+		rte.ColTypes = append(rte.ColTypes, tle.Expr.ResultType())
+	}
+	if len(userNames) > 0 {
+		return nil, nil, system.Ereport(system.InvalidColumnReference,
+			"table \"%s\" has %d columns available but %d columns specified",
+			r.Alias.AliasName, len(r.Alias.ColumnNames)-len(userNames), len(r.Alias.ColumnNames))
+	}
+	rtindex := parser.addRangeTblEntry(rte, false)
+	return &RangeTblRef{RtIndex: rtindex}, []*namespaceItem{{rte, true, true}}, nil
+}
+
+// Transforms a JOIN, as postgres' transformFromClauseItem does.  The join
+// has an RTE_JOIN entry whose columns are those of the USING, or the
+// common ones of a NATURAL join, followed by the other columns of the
+// left and then the right side.  The tables joined can still be referred
+// to by name, but their columns only through the join, unless it has an
+// alias, which hides them altogether.
+func (parser *ParserImpl) transformJoin(join *RangeJoin) (Node, []*namespaceItem, error) {
+	larg, lnamespace, err := parser.transformFromClauseItem(join.Larg)
+	if err != nil {
+		return nil, nil, err
+	}
+	rarg, rnamespace, err := parser.transformFromClauseItem(join.Rarg)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := checkNameSpaceConflicts(lnamespace, rnamespace); err != nil {
+		return nil, nil, err
+	}
+	lrte, rrte := parser.joinTreeEntry(larg), parser.joinTreeEntry(rarg)
+	node := &JoinExpr{JoinType: join.JoinType, Larg: larg, Rarg: rarg}
+	rte := &RangeTblEntry{RteType: RTE_JOIN, JoinType: join.JoinType, RefAlias: &Alias{}}
+
+	using := join.UsingClause
+	if join.IsNatural {
+		for _, lname := range lrte.RefAlias.ColumnNames {
+			for _, rname := range rrte.RefAlias.ColumnNames {
+				if lname != "" && lname == rname {
+					using = append(using, lname)
+					break
+				}
+			}
+		}
+	}
+	lused, rused := map[int]bool{}, map[int]bool{}
+	var quals []Expr
+	for i, name := range using {
+		for _, other := range using[:i] {
+			if other == name {
+				return nil, nil, system.Ereport(system.DuplicateColumn,
+					"column name \"%s\" appears more than once in USING clause", name)
+			}
+		}
+		lidx, err := findUsingColumn(lrte, name, "left")
+		if err != nil {
+			return nil, nil, err
+		}
+		ridx, err := findUsingColumn(rrte, name, "right")
+		if err != nil {
+			return nil, nil, err
+		}
+		lused[lidx], rused[ridx] = true, true
+		lvar, err := parser.makeVar(lrte, lidx)
+		if err != nil {
+			return nil, nil, err
+		}
+		rvar, err := parser.makeVar(rrte, ridx)
+		if err != nil {
+			return nil, nil, err
+		}
+		qual, err := parser.makeOpExpr("=", lvar, rvar)
+		if err != nil {
+			return nil, nil, err
+		}
+		quals = append(quals, qual)
+		merged, err := parser.buildMergedJoinVar(join.JoinType, lvar, rvar)
+		if err != nil {
+			return nil, nil, err
+		}
+		rte.RefAlias.ColumnNames = append(rte.RefAlias.ColumnNames, name)
+		rte.JoinAliasVars = append(rte.JoinAliasVars, merged)
+	}
+	if len(quals) == 1 {
+		node.Quals = quals[0]
+	} else if len(quals) > 1 {
+		node.Quals = &BoolExpr{ExprImpl: ExprImpl{system.BoolType}, BoolOp: AND_EXPR, Args: quals}
+	}
+	for _, side := range []struct {
+		rte  *RangeTblEntry
+		used map[int]bool
+	}{{lrte, lused}, {rrte, rused}} {
+		for attidx, name := range side.rte.RefAlias.ColumnNames {
+			if name == "" || side.used[attidx] {
+				continue
+			}
+			expr, err := parser.makeVar(side.rte, attidx)
 			if err != nil {
-				return err
+				return nil, nil, err
 			}
-			defer relation.Close()
-			rte.RelId = relation.RelId
-			rte.RefAlias = buildAlias(relation)
+			rte.RefAlias.ColumnNames = append(rte.RefAlias.ColumnNames, name)
+			rte.JoinAliasVars = append(rte.JoinAliasVars, expr)
+		}
+	}
+	for _, expr := range rte.JoinAliasVars {
+		rte.ColTypes = append(rte.ColTypes, expr.ResultType())
+	}
+	if join.Alias != nil {
+		rte.RefAlias.AliasName = join.Alias.AliasName
+		if len(join.Alias.ColumnNames) > len(rte.RefAlias.ColumnNames) {
+			return nil, nil, system.Ereport(system.SyntaxError,
+				"column alias list for \"%s\" has too many entries", join.Alias.AliasName)
+		}
+		copy(rte.RefAlias.ColumnNames, join.Alias.ColumnNames)
+	}
+	node.RtIndex = parser.addRangeTblEntry(rte, false)
 
-			parser.namespace = append(parser.namespace, rte)
+	if join.Quals != nil {
+		saved := parser.namespace
+		parser.namespace = append(append([]*namespaceItem{}, lnamespace...), rnamespace...)
+		qual, err := parser.transformExpr(join.Quals)
+		parser.namespace = saved
+		if err != nil {
+			return nil, nil, err
+		}
+		if node.Quals, err = parser.coerceToBoolean(qual, "JOIN/ON"); err != nil {
+			return nil, nil, err
+		} else if err = checkNoAggregates(node.Quals, "JOIN conditions"); err != nil {
+			return nil, nil, err
 		}
 	}
 
+	item := &namespaceItem{rte, join.Alias != nil, true}
+	if join.Alias != nil {
+		return node, []*namespaceItem{item}, nil
+	}
+	var namespace []*namespaceItem
+	for _, other := range append(lnamespace, rnamespace...) {
+		namespace = append(namespace, &namespaceItem{other.rte, other.relVisible, false})
+	}
+	return node, append(namespace, item), nil
+}
+
+// Returns the range table entry of the node of the join tree.
+func (parser *ParserImpl) joinTreeEntry(node Node) *RangeTblEntry {
+	switch n := node.(type) {
+	case *RangeTblRef:
+		return parser.rtable[n.RtIndex-1]
+	case *JoinExpr:
+		return parser.rtable[n.RtIndex-1]
+	}
+	panic("unrecognized join tree node")
+}
+
+// Returns the index of the column of a side of the join a USING names.
+func findUsingColumn(rte *RangeTblEntry, name string, side string) (int, error) {
+	found := -1
+	for attidx, attname := range rte.RefAlias.ColumnNames {
+		if attname != name {
+			continue
+		} else if found >= 0 {
+			return 0, system.Ereport(system.AmbiguousColumn,
+				"common column name \"%s\" appears more than once in %s table", name, side)
+		}
+		found = attidx
+	}
+	if found < 0 {
+		return 0, system.Ereport(system.UndefinedColumn,
+			"column \"%s\" specified in USING clause does not exist in %s table", name, side)
+	}
+	return found, nil
+}
+
+// Makes the expression of a column of a USING, as postgres'
+// buildMergedJoinVar: the column of the side whose rows the join keeps,
+// or the first of the two that is not NULL for a FULL join, in the type
+// both are coerced to.
+func (parser *ParserImpl) buildMergedJoinVar(joinType JoinType, lvar, rvar Expr) (Expr, error) {
+	typid, err := selectCommonType([]Expr{lvar, rvar}, "JOIN/USING")
+	if err != nil {
+		return nil, err
+	}
+	if lvar, err = parser.coerceType(lvar, typid, false); err != nil {
+		return nil, err
+	}
+	if rvar, err = parser.coerceType(rvar, typid, false); err != nil {
+		return nil, err
+	}
+	switch joinType {
+	case JOIN_RIGHT:
+		return rvar, nil
+	case JOIN_FULL:
+		return &CoalesceExpr{ExprImpl{typid}, []Expr{lvar, rvar}}, nil
+	}
+	return lvar, nil
+}
+
+// Checks that no two items of the namespaces have the same name, as
+// postgres' checkNameSpaceConflicts.
+func checkNameSpaceConflicts(namespace1, namespace2 []*namespaceItem) error {
+	for _, item1 := range namespace1 {
+		if !item1.relVisible {
+			continue
+		}
+		for _, item2 := range namespace2 {
+			if item2.relVisible && item1.rte.RefAlias.AliasName == item2.rte.RefAlias.AliasName {
+				return system.Ereport(system.DuplicateAlias,
+					"table name \"%s\" specified more than once", item1.rte.RefAlias.AliasName)
+			}
+		}
+	}
 	return nil
 }
 
-func buildAlias(relation *access.Relation) *Alias {
+// Adds the entry to the range table, and to the namespace if column
+// references may see it, as postgres' addRangeTableEntry and
+// addRTEtoQuery, and returns its index from 1.
+func (parser *ParserImpl) addRangeTblEntry(rte *RangeTblEntry, visible bool) int {
+	parser.rtable = append(parser.rtable, rte)
+	if visible {
+		parser.namespace = append(parser.namespace, &namespaceItem{rte, true, true})
+	}
+	return len(parser.rtable)
+}
+
+// Returns the index of the entry in the range table, from 1.
+func (parser *ParserImpl) rtindex(rte *RangeTblEntry) int {
+	for i, entry := range parser.rtable {
+		if entry == rte {
+			return i + 1
+		}
+	}
+	panic("range table entry not in range table")
+}
+
+// Returns the types of the columns of the relation.
+func relationColTypes(relation *access.HeapRelation) []system.Oid {
+	types := make([]system.Oid, len(relation.RelDesc.Attrs))
+	for i, attr := range relation.RelDesc.Attrs {
+		types[i] = attr.TypeId
+	}
+	return types
+}
+
+// Builds the names the relation and its columns are referred to by, as
+// postgres' buildRelationAliases: those of the user's alias where given,
+// and the relation's own otherwise.  A dropped column is named "", so
+// that no reference finds it.
+func buildAlias(relation *access.HeapRelation, userAlias *Alias) (*Alias, error) {
 	alias := &Alias{}
-	/* TODO: Add check for user-provided alias name */
 	alias.AliasName = string(relation.RelName)
+	var userNames []string
+	if userAlias != nil {
+		alias.AliasName = userAlias.AliasName
+		userNames = userAlias.ColumnNames
+	}
 
 	names := []string{}
 	for _, attr := range relation.RelDesc.Attrs {
-		names = append(names, string(attr.AttName))
+		if attr.IsDropped {
+			names = append(names, "")
+		} else if len(userNames) > 0 {
+			names = append(names, userNames[0])
+			userNames = userNames[1:]
+		} else {
+			names = append(names, string(attr.Name))
+		}
+	}
+	if len(userNames) > 0 {
+		return nil, system.Ereport(system.InvalidColumnReference,
+			"table \"%s\" has %d columns available but %d columns specified",
+			alias.AliasName, len(userAlias.ColumnNames)-len(userNames),
+			len(userAlias.ColumnNames))
 	}
 	alias.ColumnNames = names
 
-	return alias
+	return alias, nil
 }
 
+// Transforms the target list, as postgres' transformTargetList.  A * or
+// tab.* is expanded into the columns it stands for.
 func (parser *ParserImpl) transformTargetList(targetList []*ResTarget) (tlist []*TargetEntry, err error) {
-	for i, item := range targetList {
-		var tle *TargetEntry
-		tle, err = parser.transformTargetEntry(item)
-		tle.ResNo = uint16(i + 1)
+	for _, item := range targetList {
+		var tles []*TargetEntry
+		if colref, ok := item.val.(*ColumnRef); ok && colref.star {
+			tles, err = parser.expandColumnRefStar(colref)
+		} else {
+			var tle *TargetEntry
+			tle, err = parser.transformTargetEntry(item)
+			tles = []*TargetEntry{tle}
+		}
 		if err != nil {
 			return
 		}
-		tlist = append(tlist, tle)
+		for _, tle := range tles {
+			tle.ResNo = uint16(len(tlist) + 1)
+			tlist = append(tlist, tle)
+		}
 	}
 
 	return
 }
 
+// Expands * into the columns of all the FROM items, and tab.* into those
+// of tab, as postgres' ExpandColumnRefStar.
+func (parser *ParserImpl) expandColumnRefStar(colref *ColumnRef) ([]*TargetEntry, error) {
+	if len(colref.fields) == 0 {
+		var tlist []*TargetEntry
+		for _, item := range parser.namespace {
+			if !item.colsVisible {
+				continue
+			}
+			tles, err := parser.expandRelAttrs(item.rte)
+			if err != nil {
+				return nil, err
+			}
+			tlist = append(tlist, tles...)
+		}
+		return tlist, nil
+	}
+	rte, levelsUp, err := parser.refnameRangeTblEntry(colref.fields)
+	if err != nil {
+		return nil, err
+	}
+	tlist, err := parser.ancestor(levelsUp).expandRelAttrs(rte)
+	if err != nil {
+		return nil, err
+	}
+	for _, tle := range tlist {
+		tle.Expr = incrementVarSublevelsUp(tle.Expr, levelsUp)
+	}
+	return tlist, nil
+}
+
+// Makes a target entry for each column of the FROM item, as postgres'
+// expandRelAttrs.
+func (parser *ParserImpl) expandRelAttrs(rte *RangeTblEntry) ([]*TargetEntry, error) {
+	var tlist []*TargetEntry
+	for attidx, attname := range rte.RefAlias.ColumnNames {
+		if attname == "" {
+			continue
+		}
+		variable, err := parser.makeVar(rte, attidx)
+		if err != nil {
+			return nil, err
+		}
+		tlist = append(tlist, &TargetEntry{Expr: variable, ResName: system.Name(attname)})
+	}
+	return tlist, nil
+}
+
 func (parser *ParserImpl) transformTargetEntry(restarget *ResTarget) (tle *TargetEntry, err error) {
 	tle = &TargetEntry{}
 	err = nil
 
-	tle.Expr, err = parser.transformExpr(restarget.val)
+	if tle.Expr, err = parser.transformExpr(restarget.val); err != nil {
+		return
+	}
+	// a literal left of unknown type is text, as postgres'
+	// resolveTargetListUnknowns
+	if tle.Expr.ResultType() == system.UnknownType {
+		if tle.Expr, err = parser.coerceType(tle.Expr, system.TextType, false); err != nil {
+			return
+		}
+	}
 	/* ResName, ResNo */
 	tle.ResName = system.Name(restarget.name)
 	tle.ResJunk = false
@@ -191,69 +1355,263 @@ func (parser *ParserImpl) transformTargetEntry(restarget *ResTarget) (tle *Targe
 	return
 }
 
-func (parser *ParserImpl) transformExpr(node Node) (expr Expr, err error) {
-	switch node.(type) {
-	default:
-		return nil, parseError("unknown node type")
-	case *ColumnRef:
-		colref := node.(*ColumnRef)
-		/* TODO: use map instead of linear search? */
-		found := false
-		var variable Var
-		for rteidx, rte := range parser.namespace {
-			/* TODO: colref may have table name too */
-			for attidx, attname := range rte.RefAlias.ColumnNames {
-				if attname == colref.name {
-					if found {
-						return nil, parseError("ambiguous column reference")
-					}
-					found = true
-					relation, err := access.HeapOpen(rte.RelId)
-					if err != nil {
-						return nil, err
-					}
-					tupdesc := relation.RelDesc
-
-					variable.resultType = tupdesc.Attrs[attidx].AttType
-					variable.VarNo = uint16(rteidx + 1)
-					variable.VarAttNo = uint16(attidx + 1)
-				}
+// Transforms the WHERE or HAVING clause into a boolean expression, as
+// postgres' transformWhereClause.  It is nil if there is no clause.
+func (parser *ParserImpl) transformWhereClause(clause Node, constructName string) (Expr, error) {
+	if clause == nil {
+		return nil, nil
+	}
+	qual, err := parser.transformExpr(clause)
+	if err != nil {
+		return nil, err
+	}
+	return parser.coerceToBoolean(qual, constructName)
+}
+
+// Transforms the ORDER BY, as postgres' transformSortClause.  Each item
+// sorts by an entry of the target list of the query, which gets a junk
+// entry for an expression it does not compute.
+func (parser *ParserImpl) transformSortClause(orderlist []*SortBy, query *Query) ([]*SortGroupClause, error) {
+	var sortlist []*SortGroupClause
+	for _, sortby := range orderlist {
+		tle, err := parser.findTargetlistEntry(sortby.Node, &query.TargetList, "ORDER BY")
+		if err != nil {
+			return nil, err
+		}
+		if sortlist, err = addTargetToSortList(tle, sortlist, query.TargetList, sortby); err != nil {
+			return nil, err
+		}
+	}
+	return sortlist, nil
+}
+
+// Returns the entry of the target list an item of the clause refers to,
+// as postgres' findTargetlistEntrySQL92: the column of the output of its
+// name if it is a name alone, the column of its position if it is an
+// integer, and otherwise the entry computing the expression, which is
+// added to the list as a junk entry if there is none.  In GROUP BY, a
+// name alone is a column of the FROM items first, as in SQL92.
+func (parser *ParserImpl) findTargetlistEntry(node Node, tlist *[]*TargetEntry, clause string) (*TargetEntry, error) {
+	colref, ok := node.(*ColumnRef)
+	if ok && clause == "GROUP BY" && len(colref.fields) == 1 && !colref.star {
+		if found, err := parser.colNameToVar(colref.fields[0]); err == nil && found != nil {
+			ok = false
+		}
+	}
+	if ok && len(colref.fields) == 1 && !colref.star {
+		var target *TargetEntry
+		for _, tle := range *tlist {
+			if tle.ResJunk || string(tle.ResName) != colref.fields[0] {
+				continue
 			}
+			if target != nil && !EqualExpr(target.Expr, tle.Expr) {
+				return nil, system.Ereport(system.AmbiguousColumn,
+					"%s \"%s\" is ambiguous", clause, colref.fields[0])
+			} else if target == nil {
+				target = tle
+			}
+		}
+		if target != nil {
+			return target, nil
+		}
+	}
+	if con, ok := node.(*AConst); ok {
+		if con.Kind != ConstInteger {
+			return nil, system.Ereport(system.SyntaxError, "non-integer constant in %s", clause)
+		}
+		pos := 0
+		for _, tle := range *tlist {
+			if tle.ResJunk {
+				continue
+			}
+			if pos++; pos == con.Ival {
+				return tle, nil
+			}
+		}
+		return nil, system.Ereport(system.InvalidColumnReference,
+			"%s position %d is not in select list", clause, con.Ival)
+	}
 
+	expr, err := parser.transformExpr(node)
+	if err != nil {
+		return nil, err
+	}
+	for _, tle := range *tlist {
+		if EqualExpr(tle.Expr, expr) {
+			return tle, nil
 		}
-		if !found {
-			return nil, parseError("column reference not found")
+	}
+	if expr.ResultType() == system.UnknownType {
+		if expr, err = parser.coerceType(expr, system.TextType, false); err != nil {
+			return nil, err
 		}
+	}
+	tle := &TargetEntry{Expr: expr, ResNo: uint16(len(*tlist) + 1), ResJunk: true}
+	*tlist = append(*tlist, tle)
+	return tle, nil
+}
 
-		return Expr(&variable), nil
+// Appends the sort by the target entry to the list, unless the list sorts
+// by it already, as postgres' addTargetToSortList.  The entry is numbered
+// for the clause to refer to it.
+func addTargetToSortList(tle *TargetEntry, sortlist []*SortGroupClause,
+	tlist []*TargetEntry, sortby *SortBy) ([]*SortGroupClause, error) {
+	typid := tle.Expr.ResultType()
+	if _, err := system.LookupCompare(typid, typid); err != nil {
+		return nil, system.Ereport(system.UndefinedFunction,
+			"could not identify an ordering operator for type %s", system.FormatType(typid))
+	}
+	for _, clause := range sortlist {
+		if clause.TleSortGroupRef == tle.ResSortGroupRef {
+			return sortlist, nil
+		}
+	}
+	assignSortGroupRef(tle, tlist)
+	_, err := system.LookupHash(typid)
+	clause := &SortGroupClause{
+		TleSortGroupRef: tle.ResSortGroupRef,
+		Descending:      sortby.SortbyDir == SORTBY_DESC,
+		Hashable:        err == nil,
+	}
+	switch sortby.SortbyNulls {
+	case SORTBY_NULLS_DEFAULT:
+		clause.NullsFirst = clause.Descending
+	case SORTBY_NULLS_FIRST:
+		clause.NullsFirst = true
+	}
+	return append(sortlist, clause), nil
+}
 
+// Transforms the GROUP BY, as postgres' transformGroupClause.  Each item
+// groups by an entry of the target list, which gets a junk entry for an
+// expression it does not compute, as an item of ORDER BY does.
+func (parser *ParserImpl) transformGroupClause(grouplist []Node, query *Query) ([]*SortGroupClause, error) {
+	var result []*SortGroupClause
+	for _, node := range grouplist {
+		tle, err := parser.findTargetlistEntry(node, &query.TargetList, "GROUP BY")
+		if err != nil {
+			return nil, err
+		} else if err = checkNoAggregates(tle.Expr, "GROUP BY"); err != nil {
+			return nil, err
+		}
+		if result, err = addTargetToGroupList(tle, result, query.TargetList); err != nil {
+			return nil, err
+		}
 	}
+	return result, nil
+}
 
-	panic("unreachable")
+// Transforms the DISTINCT, as postgres' transformDistinctClause: the rows
+// are to be distinct in all the columns of the target list, those the
+// ORDER BY sorts by first, so that the rows sorted for the one are sorted
+// for the other.  The ORDER BY cannot sort by anything else, which the
+// rows left are not distinct in.
+func transformDistinctClause(query *Query) ([]*SortGroupClause, error) {
+	var result []*SortGroupClause
+	for _, clause := range query.SortClause {
+		if GetSortGroupClauseTle(clause, query.TargetList).ResJunk {
+			return nil, system.Ereport(system.InvalidColumnReference,
+				"for SELECT DISTINCT, ORDER BY expressions must appear in select list")
+		}
+		distinct := *clause
+		result = append(result, &distinct)
+	}
+	for _, tle := range query.TargetList {
+		if tle.ResJunk {
+			continue
+		}
+		var err error
+		if result, err = addTargetToGroupList(tle, result, query.TargetList); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
 }
 
-func (rv *RangeVar) OpenRelation() (rel *access.Relation, err error) {
-	/* TODO: Refactor this to RelnameGetOid() */
-	class_rel, err := access.HeapOpen(access.ClassRelId)
-	if err != nil {
-		return nil, err
+// Appends the grouping by the target entry to the list, unless the list
+// groups by it already, as postgres' addTargetToGroupList.  The entry is
+// numbered for the clause to refer to it.
+func addTargetToGroupList(tle *TargetEntry, grouplist []*SortGroupClause,
+	tlist []*TargetEntry) ([]*SortGroupClause, error) {
+	typid := tle.Expr.ResultType()
+	if _, err := system.LookupCompare(typid, typid); err != nil {
+		return nil, system.Ereport(system.UndefinedFunction,
+			"could not identify an equality operator for type %s", system.FormatType(typid))
+	}
+	for _, clause := range grouplist {
+		if clause.TleSortGroupRef == tle.ResSortGroupRef {
+			return grouplist, nil
+		}
 	}
-	defer class_rel.Close()
-	scankeys := []access.ScanKey{
-		{access.Anum_class_relname, system.Datum(rv.RelationName)},
+	assignSortGroupRef(tle, tlist)
+	_, err := system.LookupHash(typid)
+	return append(grouplist, &SortGroupClause{
+		TleSortGroupRef: tle.ResSortGroupRef,
+		Hashable:        err == nil,
+	}), nil
+}
+
+// Numbers the target entry for a SortGroupClause to refer to it, if it is
+// not already, as postgres' assignSortGroupRef.
+func assignSortGroupRef(tle *TargetEntry, tlist []*TargetEntry) {
+	if tle.ResSortGroupRef != 0 {
+		return
 	}
-	scan, err := class_rel.BeginScan(scankeys)
+	var maxRef uint
+	for _, entry := range tlist {
+		if entry.ResSortGroupRef > maxRef {
+			maxRef = entry.ResSortGroupRef
+		}
+	}
+	tle.ResSortGroupRef = maxRef + 1
+}
+
+// Returns the target entry a SortGroupClause refers to, as postgres'
+// get_sortgroupclause_tle.
+func GetSortGroupClauseTle(clause *SortGroupClause, tlist []*TargetEntry) *TargetEntry {
+	for _, tle := range tlist {
+		if tle.ResSortGroupRef == clause.TleSortGroupRef {
+			return tle
+		}
+	}
+	panic("ORDER/GROUP BY expression not found in targetlist")
+}
+
+// Transforms the expression of OFFSET or LIMIT into an int4, as postgres'
+// transformLimitClause.  It is computed once, before the rows are read,
+// and so cannot refer to their columns, though it may to those of an
+// outer query.
+func (parser *ParserImpl) transformLimitClause(clause Node, constructName string) (Expr, error) {
+	if clause == nil {
+		return nil, nil
+	}
+	expr, err := parser.transformExpr(clause)
 	if err != nil {
 		return nil, err
 	}
-	defer scan.EndScan()
-	tuple, err := scan.Next()
-	if err != nil {
-		return nil, errors.New("relation not found")
+	if expr, err = parser.coerceToSpecificType(expr, system.Int4Type, constructName); err != nil {
+		return nil, err
+	} else if err = checkNoAggregates(expr, constructName); err != nil {
+		return nil, err
+	}
+	if WalkExpr(expr, func(e Expr) bool { v, ok := e.(*Var); return ok && v.VarLevelsUp == 0 }) {
+		return nil, system.Ereport(system.InvalidColumnReference,
+			"argument of %s must not contain variables", constructName)
 	}
-	relid := tuple.Get(int32(1)).(system.Oid)
+	return expr, nil
+}
 
-	return access.HeapOpen(relid)
+// Opens the relation named by rv.  An unqualified name is looked up in
+// bp_catalog, then in public, as the default search path.
+func (parser *ParserImpl) openRelation(rv *RangeVar) (*access.HeapRelation, error) {
+	relid, err := access.RangeVarGetRelid(rv.SchemaName, rv.RelationName, parser.syscache)
+	if err != nil {
+		return nil, err
+	} else if relid == system.InvalidOid {
+		return nil, system.Ereport(system.UndefinedTable,
+			"relation \"%s\" does not exist", rv.RelationName)
+	}
+	return parser.relcache.HeapOpen(relid)
 }
 
 func (node *ExprImpl) ResultType() system.Oid {
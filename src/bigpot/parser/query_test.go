@@ -3,45 +3,134 @@ package parser
 import (
 	. "launchpad.net/gocheck"
 	//	"testing"
+	"bytes"
+	"os"
+
 	"bigpot/access"
+	"bigpot/bootstrap"
+	"bigpot/storage"
 	"bigpot/system"
 )
 
-func init() {
-	access.DatabaseDir = "../access/testdata"
-}
-
 var _ = Suite(&MySuite{})
 
 func (s *MySuite) TestBuildAlias(c *C) {
-	relation := access.Relation{
+	relation := access.HeapRelation{
 		RelName: "mytable",
 		RelDesc: &access.TupleDesc{
 			Attrs: []*access.Attribute{
-				{"mycol1", system.NameType},
-				{"mycol2", system.Int4Type},
+				{Name: "mycol1", TypeId: system.NameType},
+				{Name: "mycol2", TypeId: system.Int4Type, IsDropped: true},
+				{Name: "mycol3", TypeId: system.Int4Type},
 			},
 		},
 	}
 
-	alias := buildAlias(&relation)
+	alias, err := buildAlias(&relation, nil)
+	c.Assert(err, IsNil)
 	c.Check(alias.AliasName, Equals, "mytable")
-	c.Check(len(alias.ColumnNames), Equals, 2)
-	c.Check(alias.ColumnNames[0], Equals, "mycol1")
-	c.Check(alias.ColumnNames[1], Equals, "mycol2")
+	c.Check(alias.ColumnNames, DeepEquals, []string{"mycol1", "", "mycol3"})
+
+	alias, err = buildAlias(&relation, &Alias{AliasName: "t", ColumnNames: []string{"a"}})
+	c.Assert(err, IsNil)
+	c.Check(alias.AliasName, Equals, "t")
+	c.Check(alias.ColumnNames, DeepEquals, []string{"a", "", "mycol3"})
+
+	_, err = buildAlias(&relation, &Alias{AliasName: "t", ColumnNames: []string{"a", "b", "c"}})
+	c.Check(err, ErrorMatches, "table \"t\" has 2 columns available but 3 columns specified")
+}
+
+// Runs initdb in a new empty directory and changes to it, returning a
+// parser on the catalogs of template1 and the function that shuts the
+// cluster down and changes back.
+func newParser(c *C) (*ParserImpl, func()) {
+	cwd, err := os.Getwd()
+	c.Assert(err, IsNil)
+	c.Assert(os.Chdir(c.MkDir()), IsNil)
+	var bki bytes.Buffer
+	c.Assert(bootstrap.GenBKI(&bki), IsNil)
+	c.Assert(bootstrap.InitDB(&bki), IsNil)
+
+	bufMgr, err := storage.NewBufferManager(16)
+	c.Assert(err, IsNil)
+	queue := access.NewSharedInvalQueue()
+	relcache := access.NewRelCache(bufMgr, queue, access.TemplateDbId)
+	syscache := access.NewSysCache(bufMgr, queue, access.TemplateDbId)
+	return NewParser(relcache, syscache), func() {
+		relcache.Close()
+		syscache.Close()
+		c.Check(bufMgr.Close(), IsNil)
+		os.Chdir(cwd)
+	}
 }
 
 func (s *MySuite) TestTransform(c *C) {
-	parser := ParserImpl{}
-	query, err := parser.Parse("select oid, relname from bp_class")
+	parser, done := newParser(c)
+	defer done()
+	query, err := parser.Parse("select relname, relnamespace from bp_class")
 	if err != nil {
 		c.Error(err)
 	}
 
-	c.Check(query.TargetList[0].Expr.(*Var).VarAttNo, Equals, uint16(1))
-	c.Check(query.TargetList[0].Expr.(*Var).resultType, Equals, system.OidType)
-	c.Check(query.TargetList[1].Expr.(*Var).VarAttNo, Equals, uint16(2))
-	c.Check(query.TargetList[1].Expr.(*Var).resultType, Equals, system.NameType)
+	c.Check(query.TargetList[0].Expr.(*Var).VarAttNo, Equals, system.AttrNumber(1))
+	c.Check(query.TargetList[0].Expr.(*Var).resultType, Equals, system.NameType)
+	c.Check(query.TargetList[1].Expr.(*Var).VarAttNo, Equals, system.AttrNumber(2))
+	c.Check(query.TargetList[1].Expr.(*Var).resultType, Equals, system.OidType)
 	c.Check(query.RangeTables[0].RteType, Equals, RTE_RELATION)
 	c.Check(query.RangeTables[0].RelId, Equals, access.ClassRelId)
 }
+
+func (s *MySuite) TestTransformWhere(c *C) {
+	parser, done := newParser(c)
+	defer done()
+
+	query, err := parser.Parse("select relname, relnatts + 1.5, 'x' from bp_class " +
+		"where relnatts between 1 and 3 and relname like 'bp%' and relkind is not null")
+	c.Assert(err, IsNil)
+	c.Assert(query.TargetList, HasLen, 3)
+	sum := query.TargetList[1].Expr.(*OpExpr)
+	c.Check(sum.ResultType(), Equals, system.Float8Type)
+	c.Check(sum.Opr.Proc.Name, Equals, system.Name("float8pl"))
+	// the int4 is cast by the function of the cast
+	cast := sum.Args[0].(*FuncExpr)
+	c.Check(cast.Func.Name, Equals, system.Name("float8"))
+	c.Check(cast.Args[0].(*Var).VarAttNo, Equals, system.AttrNumber(access.Anum_class_relnatts))
+	c.Check(sum.Args[1], DeepEquals, &Const{ExprImpl{system.Float8Type}, system.Float8(1.5)})
+	c.Check(query.TargetList[2].Expr, DeepEquals, &Const{ExprImpl{system.TextType}, system.Text("x")})
+	c.Check(query.TargetList[2].ResName, Equals, system.Name("?column?"))
+
+	c.Check(query.JoinTree.FromList, DeepEquals, []Node{&RangeTblRef{RtIndex: 1}})
+	qual := query.JoinTree.Quals.(*BoolExpr)
+	c.Check(qual.BoolOp, Equals, AND_EXPR)
+	c.Assert(qual.Args, HasLen, 4)
+	lower := qual.Args[0].(*OpExpr)
+	c.Check(lower.Opr.Name, Equals, ">=")
+	c.Check(lower.Args[1], DeepEquals, &Const{ExprImpl{system.Int4Type}, system.Int4(1)})
+	like := qual.Args[2].(*OpExpr)
+	c.Check(like.Opr.Proc.Name, Equals, system.Name("textlike"))
+	c.Check(like.Args[0].ResultType(), Equals, system.TextType)
+	c.Check(like.Args[1], DeepEquals, &Const{ExprImpl{system.TextType}, system.Text("bp%")})
+	c.Check(qual.Args[3].(*NullTest).NullTestType, Equals, IS_NOT_NULL)
+
+	query, err = parser.Parse("select relname from bp_class where relnatts not in (1, null)")
+	c.Assert(err, IsNil)
+	notIn := query.JoinTree.Quals.(*BoolExpr)
+	c.Check(notIn.BoolOp, Equals, AND_EXPR)
+	c.Check(notIn.Args[1].(*OpExpr).Opr.Name, Equals, "<>")
+	c.Check(notIn.Args[1].(*OpExpr).Args[1], DeepEquals, &Const{ExprImpl{system.Int4Type}, nil})
+
+	query, err = parser.Parse("select relname from bp_class where relhasoids or '1'::int4 = 1")
+	c.Assert(err, IsNil)
+	or := query.JoinTree.Quals.(*BoolExpr)
+	c.Check(or.BoolOp, Equals, OR_EXPR)
+	c.Check(or.Args[1].(*OpExpr).Args[0], DeepEquals, &Const{ExprImpl{system.Int4Type}, system.Int4(1)})
+
+	_, err = parser.Parse("select relname from bp_class where relnatts")
+	c.Check(err, ErrorMatches, "argument of WHERE must be type boolean, not type int4")
+	_, err = parser.Parse("select relname from bp_class where relname + 1 = 2")
+	c.Check(err, ErrorMatches, "operator does not exist: name \\+ int4")
+	_, err = parser.Parse("select nosuch from bp_class")
+	c.Check(err, ErrorMatches, "column \"nosuch\" does not exist")
+	_, err = parser.Parse("select relname from bp_class where relnatts = 'x'")
+	c.Check(err, ErrorMatches, "invalid input syntax for type integer: \"x\"")
+}
@@ -0,0 +1,38 @@
+package parser
+
+import (
+	"strings"
+
+	"bigpot/access"
+	"bigpot/system"
+)
+
+// The SQL names of the types that bp_type knows by another name, as those
+// postgres' grammar translates.
+var typeNameAliases = map[string]string{
+	"boolean": "bool",
+	"float":   "float8",
+	"int":     "int4",
+	"integer": "int4",
+}
+
+// Returns the oid of the type, as postgres' typenameTypeId.  Types are
+// looked up in bp_catalog, and an array type is named by its element type.
+func TypenameTypeId(typeName *TypeName, syscache *access.SysCache) (system.Oid, error) {
+	name := strings.ToLower(typeName.Name)
+	if alias, ok := typeNameAliases[name]; ok {
+		name = alias
+	}
+	display := name
+	if typeName.IsArray {
+		name = "_" + name
+		display += "[]"
+	}
+	typid, err := syscache.GetSysCacheOid(access.TypeNameNspCache, system.Name(name), access.CatalogNamespaceId)
+	if err != nil {
+		return system.InvalidOid, err
+	} else if typid == system.InvalidOid {
+		return system.InvalidOid, system.Ereport(system.UndefinedObject, "type \"%s\" does not exist", display)
+	}
+	return typid, nil
+}
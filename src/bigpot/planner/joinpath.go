@@ -0,0 +1,238 @@
+package planner
+
+import (
+	"bigpot/parser"
+	"bigpot/system"
+)
+
+// Plans the join tree of the query, computing the target list tlist at
+// the top, or the whole rows if it is nil, as postgres' query_planner.  Each condition of the WHERE, and of the ON
+// of an inner join, is checked as soon as the rows of the relations it
+// reads are there: by the scan of its relation if it reads one only, and
+// otherwise by the lowest join that has them all.  Those reading no
+// relation are checked by the first scan.
+func (planner *PlannerImpl) planJoinTree(query *parser.Query, tlist []*parser.TargetEntry) Node {
+	plan, _ := planner.planFromList(query, query.JoinTree.FromList,
+		makeAndsImplicit(query.JoinTree.Quals), tlist)
+	return plan
+}
+
+// Plans the items of a FROM list, joined by inner joins in the order of
+// the list, as postgres' make_rel_from_joinlist, with the conditions
+// given.  The plan returns the target list tlist, or the whole rows of its
+// relations, with the layout returned, if tlist is nil.
+func (planner *PlannerImpl) planFromList(query *parser.Query, items []parser.Node,
+	quals []parser.Expr, tlist []*parser.TargetEntry) (Node, rowLayout) {
+	var plan Node
+	var layout rowLayout
+	for i, item := range items {
+		var itemQuals []parser.Expr
+		itemQuals, quals = splitQuals(quals, fromItemRels(query, item))
+		last := i == len(items)-1
+		if i == 0 {
+			var itemTlist []*parser.TargetEntry
+			if last {
+				itemTlist = tlist
+			}
+			plan, layout = planner.planFromItem(query, item, itemQuals, itemTlist)
+			continue
+		}
+		itemPlan, itemLayout := planner.planFromItem(query, item, itemQuals, nil)
+		joined := append(append(rowLayout{}, layout...), itemLayout...)
+		var joinQuals []parser.Expr
+		joinTlist := tlist
+		if last {
+			joinQuals, quals = quals, nil
+		} else {
+			joinQuals, quals = splitQuals(quals, joined)
+			joinTlist = nil
+		}
+		plan = planner.makeJoin(query, parser.JOIN_INNER, plan, itemPlan,
+			layout, itemLayout, joinQuals, nil, joinTlist)
+		layout = joined
+	}
+	return plan, layout
+}
+
+// Plans an item of the join tree, a RangeTblRef, a JoinExpr or the
+// FromExpr of a subquery pulled up, as planFromList does.  A subquery
+// left in the FROM is planned on its own, below a SubqueryScan.  The
+// conditions of an outer join are kept from the side it keeps the rows
+// of, which they would drop rows of, and those given from above from the
+// side it fills with NULLs, which they are checked after.
+func (planner *PlannerImpl) planFromItem(query *parser.Query, item parser.Node,
+	quals []parser.Expr, tlist []*parser.TargetEntry) (Node, rowLayout) {
+	switch n := item.(type) {
+	case *parser.RangeTblRef:
+		rte := query.RangeTables[n.RtIndex-1]
+		layout := rowLayout{{n.RtIndex, len(rte.ColTypes)}}
+		if tlist == nil {
+			tlist = makeRowTargetList(layout, query.RangeTables)
+		}
+		if rte.RteType == parser.RTE_SUBQUERY {
+			return &SubqueryScan{
+				Plan:       Plan{LeftTree: planner.planQuery(rte.Subquery), Qual: quals},
+				TargetList: tlist,
+			}, layout
+		}
+		scan := makeSeqScan(tlist, rte)
+		scan.ScanKeys, scan.Qual = extractScanKeys(quals)
+		return scan, layout
+	case *parser.JoinExpr:
+		joinType, larg, rarg := n.JoinType, n.Larg, n.Rarg
+		if joinType == parser.JOIN_RIGHT {
+			joinType, larg, rarg = parser.JOIN_LEFT, rarg, larg
+		}
+		lrels, rrels := fromItemRels(query, larg), fromItemRels(query, rarg)
+		joinQuals := makeAndsImplicit(n.Quals)
+		var lquals, rquals, otherQuals []parser.Expr
+		switch joinType {
+		case parser.JOIN_INNER:
+			joinQuals = append(append([]parser.Expr{}, quals...), joinQuals...)
+			lquals, joinQuals = splitQuals(joinQuals, lrels)
+			rquals, joinQuals = splitQuals(joinQuals, rrels)
+		case parser.JOIN_LEFT:
+			lquals, otherQuals = splitQuals(quals, lrels)
+			rquals, joinQuals = splitQuals(joinQuals, rrels)
+		case parser.JOIN_FULL:
+			otherQuals = quals
+		}
+		lplan, llayout := planner.planFromItem(query, larg, lquals, nil)
+		rplan, rlayout := planner.planFromItem(query, rarg, rquals, nil)
+		plan := planner.makeJoin(query, joinType, lplan, rplan, llayout, rlayout,
+			joinQuals, otherQuals, tlist)
+		return plan, append(append(rowLayout{}, llayout...), rlayout...)
+	case *parser.FromExpr:
+		quals = append(append([]parser.Expr{}, quals...), makeAndsImplicit(n.Quals)...)
+		return planner.planFromList(query, n.FromList, quals, tlist)
+	}
+	panic("unrecognized join tree node")
+}
+
+// Returns the relations of the item of the join tree, in no useful order.
+func fromItemRels(query *parser.Query, item parser.Node) rowLayout {
+	switch n := item.(type) {
+	case *parser.RangeTblRef:
+		return rowLayout{{n.RtIndex, len(query.RangeTables[n.RtIndex-1].ColTypes)}}
+	case *parser.JoinExpr:
+		return append(fromItemRels(query, n.Larg), fromItemRels(query, n.Rarg)...)
+	case *parser.FromExpr:
+		var rels rowLayout
+		for _, item := range n.FromList {
+			rels = append(rels, fromItemRels(query, item)...)
+		}
+		return rels
+	}
+	panic("unrecognized join tree node")
+}
+
+// Makes the join of the outer and the inner plans, of the rows of the
+// layouts given, as postgres' create_join_plan.  A hash join is taken if
+// some of the joinQuals are equalities of an outer and an inner
+// expression of a hashable type, or else a merge join, with the inputs
+// sorted, if some are equalities of columns of a type with a comparison
+// function; a nested loop otherwise.
+func (planner *PlannerImpl) makeJoin(query *parser.Query, joinType parser.JoinType,
+	outer, inner Node, olayout, ilayout rowLayout,
+	joinQuals, otherQuals []parser.Expr, tlist []*parser.TargetEntry) Node {
+	if tlist == nil {
+		tlist = makeRowTargetList(append(append(rowLayout{}, olayout...), ilayout...), query.RangeTables)
+	}
+	join := Join{
+		Plan:       Plan{LeftTree: outer, RightTree: inner, Qual: fixJoinExprs(otherQuals, olayout, ilayout)},
+		JoinType:   joinType,
+		TargetList: fixJoinTargetList(tlist, olayout, ilayout),
+	}
+
+	var hashClauses, mergeClauses, hashRest, mergeRest []parser.Expr
+	for _, qual := range joinQuals {
+		clause := makeJoinClause(qual, olayout, ilayout)
+		if clause != nil && isHashable(clause) {
+			hashClauses = append(hashClauses, clause)
+		} else {
+			hashRest = append(hashRest, qual)
+		}
+		if clause != nil && isMergeable(clause) {
+			mergeClauses = append(mergeClauses, clause)
+		} else {
+			mergeRest = append(mergeRest, qual)
+		}
+	}
+	switch {
+	case EnableHashJoin && len(hashClauses) > 0:
+		join.JoinQual = fixJoinExprs(hashRest, olayout, ilayout)
+		return &HashJoin{Join: join, HashClauses: fixJoinExprs(hashClauses, olayout, ilayout)}
+	case EnableMergeJoin && len(mergeClauses) > 0:
+		var okeys, ikeys []SortKey
+		for _, clause := range mergeClauses {
+			args := clause.(*parser.OpExpr).Args
+			oattno, _ := olayout.find(args[0].(*parser.Var))
+			iattno, _ := ilayout.find(args[1].(*parser.Var))
+			okeys = append(okeys, SortKey{AttNo: oattno, TypeId: args[0].ResultType()})
+			ikeys = append(ikeys, SortKey{AttNo: iattno, TypeId: args[1].ResultType()})
+		}
+		join.LeftTree = &Sort{Plan: Plan{LeftTree: outer}, SortKeys: okeys}
+		join.RightTree = &Sort{Plan: Plan{LeftTree: inner}, SortKeys: ikeys}
+		join.JoinQual = fixJoinExprs(mergeRest, olayout, ilayout)
+		return &MergeJoin{Join: join, MergeClauses: fixJoinExprs(mergeClauses, olayout, ilayout)}
+	}
+	join.JoinQual = fixJoinExprs(joinQuals, olayout, ilayout)
+	return &NestLoop{Join: join}
+}
+
+// Returns the condition as an equality of an expression of the outer
+// relations and one of the inner ones, the outer on the left, as postgres'
+// hash and merge clauses are, or nil if it is not one.
+func makeJoinClause(qual parser.Expr, olayout, ilayout rowLayout) parser.Expr {
+	op, ok := qual.(*parser.OpExpr)
+	if !ok || op.Opr.Name != "=" || len(op.Args) != 2 {
+		return nil
+	}
+	onlyOf := func(expr parser.Expr, layout, other rowLayout) bool {
+		return layout.covers(expr) && !other.covers(expr)
+	}
+	if onlyOf(op.Args[0], olayout, ilayout) && onlyOf(op.Args[1], ilayout, olayout) {
+		return op
+	} else if onlyOf(op.Args[1], olayout, ilayout) && onlyOf(op.Args[0], ilayout, olayout) {
+		commutator := op.Opr.CommutatorOperator()
+		if commutator == nil {
+			return nil
+		}
+		swapped := *op
+		swapped.Opr = commutator
+		swapped.Args = []parser.Expr{op.Args[1], op.Args[0]}
+		return &swapped
+	}
+	return nil
+}
+
+// Returns true if the join clause can be a hash clause: both sides are of
+// one type, which has a hash function, as postgres' op_hashjoinable.
+func isHashable(clause parser.Expr) bool {
+	opr := clause.(*parser.OpExpr).Opr
+	if opr.Left != opr.Right {
+		return false
+	}
+	_, err := system.LookupHash(opr.Left)
+	return err == nil
+}
+
+// Returns true if the join clause can be a merge clause: both sides are
+// columns, whose types have comparison functions, as postgres'
+// op_mergejoinable.
+func isMergeable(clause parser.Expr) bool {
+	op := clause.(*parser.OpExpr)
+	_, outerVar := op.Args[0].(*parser.Var)
+	_, innerVar := op.Args[1].(*parser.Var)
+	if !outerVar || !innerVar {
+		return false
+	}
+	for _, types := range [][2]system.Oid{
+		{op.Opr.Left, op.Opr.Right}, {op.Opr.Left, op.Opr.Left}, {op.Opr.Right, op.Opr.Right},
+	} {
+		if _, err := system.LookupCompare(types[0], types[1]); err != nil {
+			return false
+		}
+	}
+	return true
+}
@@ -1,36 +1,192 @@
 package planner
 
 import (
+	"bigpot/access"
 	"bigpot/parser"
-	//	"bigpot/system"
+	"bigpot/system"
 )
 
 type Node interface {
 }
 
+// PlanRoot is the plan of a query, as postgres' PlannedStmt.  TargetList
+// is the target list of the rows a SELECT returns, whose junk entries are
+// computed but not returned.  SubPlans are the plans of the SubPlans of
+// the expressions, by their PlanId, and NParams the number of the Params
+// of PARAM_EXEC the executor sets.
 type PlanRoot struct {
 	CommandType parser.CommandType
 	Plan        Node
 	RangeTables []*parser.RangeTblEntry
+	TargetList  []*parser.TargetEntry
+	SubPlans    []Node
+	NParams     int
 }
 
 type Planner interface {
 	Plan(query parser.Query) *PlanRoot
 }
 
+// PlannerImpl plans a query, as postgres' PlannerGlobal and the
+// PlannerInfo of each level of its subqueries.
 type PlannerImpl struct {
+	// the plans of the SubPlans, and the number of Params assigned
+	subplans []Node
+	nParams  int
+	// the columns of each level of the queries being planned, from the
+	// outermost, that the subqueries below read as Params, as postgres'
+	// plan_params
+	levels [][]planParam
 }
 
 type Plan struct {
 	LeftTree  Node
 	RightTree Node
+	// the conditions a row must satisfy, ANDed together, as postgres' qual
+	Qual []parser.Expr
+	// the Params the node and those below it read, whose values changing
+	// may change its rows, as postgres' allParam
+	AllParam []int
 }
 
 type SeqScan struct {
 	Plan
 	TargetList []*parser.TargetEntry
 	RangeTable *parser.RangeTblEntry
-	rel        uint32
+	// the conditions "column op constant" of the WHERE clause, which the
+	// heap scan checks before the tuple is returned, instead of Qual
+	ScanKeys []access.ScanKey
+	rel      uint32
+}
+
+// ValuesScan returns the target list computed from each row of a VALUES,
+// as postgres' ValuesScan.
+type ValuesScan struct {
+	Plan
+	TargetList  []*parser.TargetEntry
+	ValuesLists [][]parser.Expr
+}
+
+// SubqueryScan returns the target list computed from each row of the plan
+// of a subquery, its LeftTree, as postgres' SubqueryScan.
+type SubqueryScan struct {
+	Plan
+	TargetList []*parser.TargetEntry
+}
+
+// Join is what the join nodes share, as postgres' Join.  Each row of the
+// outer plan, the LeftTree, is joined with the rows of the inner plan, the
+// RightTree, for which the JoinQual holds; an outer join also returns the
+// rows of the side it keeps that match none, with NULLs for the other.
+// The Qual is checked on the rows joined, after.  The expressions of the
+// join read the columns of the two rows by Vars of VarNo parser.OUTER_VAR
+// and parser.INNER_VAR.  A RIGHT join is planned as a LEFT join with its
+// sides swapped.
+type Join struct {
+	Plan
+	JoinType   parser.JoinType
+	JoinQual   []parser.Expr
+	TargetList []*parser.TargetEntry
+}
+
+// NestLoop scans the inner plan again for each outer row, as postgres'
+// NestLoop.
+type NestLoop struct {
+	Join
+}
+
+// HashJoin builds a hash table of the inner rows, and looks each outer row
+// up in it, as postgres' HashJoin.  HashClauses are the conditions
+// "outer = inner" of the JoinQual the rows are hashed by, the outer
+// expression on the left.
+type HashJoin struct {
+	Join
+	HashClauses []parser.Expr
+}
+
+// MergeJoin reads the outer and the inner rows together in the order of
+// the columns of the MergeClauses, which both plans return them in, as
+// postgres' MergeJoin.  MergeClauses are the conditions "outer column =
+// inner column" of the JoinQual, the outer column on the left.
+type MergeJoin struct {
+	Join
+	MergeClauses []parser.Expr
+}
+
+// SortKey is a column a Sort orders its rows by, as the sortColIdx and
+// nullsFirst of postgres' Sort, with the comparison function of its type
+// in place of the sort operator.
+type SortKey struct {
+	AttNo      system.AttrNumber
+	TypeId     system.Oid
+	Descending bool
+	NullsFirst bool
+}
+
+// Sort returns the rows of its LeftTree ordered by the SortKeys, as
+// postgres' Sort.
+type Sort struct {
+	Plan
+	SortKeys []SortKey
+}
+
+// Limit returns the rows of its LeftTree after the first LimitOffset of
+// them, LimitCount of them at most, as postgres' Limit.  Either is nil if
+// not given.
+type Limit struct {
+	Plan
+	LimitOffset parser.Expr
+	LimitCount  parser.Expr
+}
+
+// AggStrategy is how an Agg finds the groups of its rows, as postgres'
+// AggStrategy.
+type AggStrategy int
+
+const (
+	// all the rows are a single group, which there is even with no rows
+	AGG_PLAIN = AggStrategy(iota)
+	// the rows come sorted by the columns grouped by
+	AGG_SORTED
+	// the rows are grouped in a hash table
+	AGG_HASHED
+)
+
+// Agg computes the Aggs over each group of the rows of its LeftTree, the
+// rows equal in the GroupCols, as postgres' Agg, and returns the
+// TargetList computed for each group that satisfies the Qual, the HAVING.
+// The expressions read the columns of the first row of the group by Vars
+// of VarNo parser.OUTER_VAR, and the value of each aggregate by an Aggref
+// whose AggNo is its index in Aggs; those of the Aggs read the columns of
+// each row the same way.
+type Agg struct {
+	Plan
+	AggStrategy AggStrategy
+	GroupCols   []system.AttrNumber
+	Aggs        []*parser.Aggref
+	TargetList  []*parser.TargetEntry
+}
+
+// The join and grouping methods the planner may take, as postgres'
+// enable_hashjoin, enable_mergejoin and enable_hashagg.  A nested loop is
+// taken when no other join is, and rows are grouped by sorting them when
+// they are not hashed.
+var (
+	EnableHashJoin  = true
+	EnableMergeJoin = true
+	EnableHashAgg   = true
+)
+
+// ModifyTable changes the result relation by the rows of its LeftTree, as
+// postgres' ModifyTable, and returns the RETURNING list of each row
+// changed, if any.  An INSERT stores the rows; an UPDATE stores each in
+// place of the row of the ctid in its last column, and a DELETE deletes
+// the row of the ctid in its only column.
+type ModifyTable struct {
+	Plan
+	Operation      parser.CommandType
+	ResultRelation *parser.RangeTblEntry
+	ReturningList  []*parser.TargetEntry
 }
 
 func (planner *PlannerImpl) Plan(query parser.Query) *PlanRoot {
@@ -38,13 +194,257 @@ func (planner *PlannerImpl) Plan(query parser.Query) *PlanRoot {
 
 	root.CommandType = query.CommandType
 
-	root.Plan = Node(makeSeqScan(query.TargetList, query.RangeTables[0]))
+	root.Plan = planner.planQuery(&query)
+	finalizePlan(root.Plan)
+	root.SubPlans = planner.subplans
+	root.NParams = planner.nParams
 	/* TODO: deep copy */
 	root.RangeTables = query.RangeTables
+	root.TargetList = query.TargetList
 
 	return &root
 }
 
+// Plans a query, or a subquery of one, as postgres' subquery_planner.
+// Its SubLinks are planned first, and the simple subqueries of its FROM
+// are pulled up into its join tree.
+func (planner *PlannerImpl) planQuery(query *parser.Query) Node {
+	planner.levels = append(planner.levels, nil)
+	defer func() { planner.levels = planner.levels[:len(planner.levels)-1] }()
+	query = parser.MutateQueryExprs(query, planner.preprocessExpr)
+	pullUpSubqueries(query)
+
+	switch query.CommandType {
+	case parser.CMD_INSERT:
+		return planner.planInsert(query)
+	case parser.CMD_UPDATE, parser.CMD_DELETE:
+		return &ModifyTable{
+			Plan:           Plan{LeftTree: planner.planJoinTree(query, query.TargetList)},
+			Operation:      query.CommandType,
+			ResultRelation: query.RangeTables[query.ResultRelation-1],
+			ReturningList:  query.ReturningList,
+		}
+	}
+	return planner.groupingPlanner(query)
+}
+
+// Plans a SELECT, as postgres' grouping_planner: the join tree computes
+// the target list, with the junk entries the ORDER BY sorts by, or, if the
+// query groups its rows, what an Agg computes it from.  An Agg of the
+// DISTINCT goes above that, and a Sort and a Limit on top if there is an
+// ORDER BY and a LIMIT or OFFSET.
+func (planner *PlannerImpl) groupingPlanner(query *parser.Query) Node {
+	var plan Node
+	if query.HasAggs || len(query.GroupClause) > 0 || query.HavingQual != nil {
+		plan = planner.makeGroupAgg(query)
+	} else {
+		plan = planner.planJoinTree(query, query.TargetList)
+	}
+	sorted := false
+	if len(query.DistinctClause) > 0 {
+		plan, sorted = makeDistinct(plan, query)
+	}
+	if len(query.SortClause) > 0 && !sorted {
+		plan = makeSort(plan, query.SortClause, query.TargetList)
+	}
+	if query.LimitOffset != nil || query.LimitCount != nil {
+		plan = &Limit{
+			Plan:        Plan{LeftTree: plan},
+			LimitOffset: query.LimitOffset,
+			LimitCount:  query.LimitCount,
+		}
+	}
+	return plan
+}
+
+// Makes the Sort of the rows of the plan, which returns the target list,
+// by the clauses, as postgres' make_sort_from_sortclauses.
+func makeSort(plan Node, clauses []*parser.SortGroupClause, tlist []*parser.TargetEntry) *Sort {
+	sort := &Sort{Plan: Plan{LeftTree: plan}}
+	for _, clause := range clauses {
+		tle := parser.GetSortGroupClauseTle(clause, tlist)
+		sort.SortKeys = append(sort.SortKeys, SortKey{
+			AttNo:      system.AttrNumber(tle.ResNo),
+			TypeId:     tle.Expr.ResultType(),
+			Descending: clause.Descending,
+			NullsFirst: clause.NullsFirst,
+		})
+	}
+	return sort
+}
+
+// Plans the grouping of the rows of a query, as postgres' grouping_planner
+// does with make_agg: the join tree computes the expressions grouped by
+// and the columns the rest of the target list and the HAVING read, and an
+// Agg computes those for each group.  The rows are grouped in a hash
+// table if EnableHashAgg and the types grouped by can be hashed, and
+// sorted by them otherwise.
+func (planner *PlannerImpl) makeGroupAgg(query *parser.Query) Node {
+	subTlist, groupCols := makeSubplanTargetList(query)
+	plan := planner.planJoinTree(query, subTlist)
+	agg := &Agg{AggStrategy: AGG_PLAIN, GroupCols: groupCols}
+	if len(query.GroupClause) > 0 {
+		agg.AggStrategy = AGG_HASHED
+		for _, clause := range query.GroupClause {
+			if !clause.Hashable || !EnableHashAgg {
+				agg.AggStrategy = AGG_SORTED
+			}
+		}
+	}
+	if agg.AggStrategy == AGG_SORTED {
+		sort := &Sort{Plan: Plan{LeftTree: plan}}
+		for _, attno := range groupCols {
+			sort.SortKeys = append(sort.SortKeys,
+				SortKey{AttNo: attno, TypeId: subTlist[attno-1].Expr.ResultType()})
+		}
+		plan = sort
+	}
+	agg.LeftTree = plan
+	for _, tle := range query.TargetList {
+		fixed := *tle
+		fixed.Expr = agg.fixUpperExpr(tle.Expr, subTlist)
+		agg.TargetList = append(agg.TargetList, &fixed)
+	}
+	for _, qual := range makeAndsImplicit(query.HavingQual) {
+		agg.Qual = append(agg.Qual, agg.fixUpperExpr(qual, subTlist))
+	}
+	return agg
+}
+
+// Makes the target list the plan below an Agg computes, as postgres'
+// make_subplanTargetList: the expressions of the GROUP BY, whose columns
+// are returned, and the columns of the rows that the rest of the target
+// list and the HAVING read.  It is nil if the Agg reads no column, and the
+// plan returns the whole rows.
+func makeSubplanTargetList(query *parser.Query) ([]*parser.TargetEntry, []system.AttrNumber) {
+	var tlist []*parser.TargetEntry
+	add := func(expr parser.Expr) system.AttrNumber {
+		for _, tle := range tlist {
+			if parser.EqualExpr(tle.Expr, expr) {
+				return system.AttrNumber(tle.ResNo)
+			}
+		}
+		tlist = append(tlist, &parser.TargetEntry{Expr: expr, ResNo: uint16(len(tlist) + 1)})
+		return system.AttrNumber(len(tlist))
+	}
+	var groupCols []system.AttrNumber
+	for _, clause := range query.GroupClause {
+		groupCols = append(groupCols, add(parser.GetSortGroupClauseTle(clause, query.TargetList).Expr))
+	}
+	var exprs []parser.Expr
+	for _, tle := range query.TargetList {
+		exprs = append(exprs, tle.Expr)
+	}
+	exprs = append(exprs, query.HavingQual)
+	for _, expr := range exprs {
+		// the columns read only within the expressions grouped by are not
+		// needed, so those are not looked into
+		parser.MutateExpr(expr, func(expr parser.Expr) parser.Expr {
+			for _, attno := range groupCols {
+				if parser.EqualExpr(expr, tlist[attno-1].Expr) {
+					return expr
+				}
+			}
+			if v, ok := expr.(*parser.Var); ok {
+				add(v)
+				return v
+			}
+			return nil
+		})
+	}
+	return tlist, groupCols
+}
+
+// Rewrites the expression computed by the Agg to read the columns of the
+// target list below it, as postgres' fix_upper_expr, and adds the
+// aggregates it calls to the Aggs.  An expression the target list
+// computes becomes a Var of its column.
+func (agg *Agg) fixUpperExpr(expr parser.Expr, subTlist []*parser.TargetEntry) parser.Expr {
+	return parser.MutateExpr(expr, func(expr parser.Expr) parser.Expr {
+		for _, tle := range subTlist {
+			if parser.EqualExpr(expr, tle.Expr) {
+				return parser.MakeVar(parser.OUTER_VAR, system.AttrNumber(tle.ResNo), expr.ResultType())
+			}
+		}
+		switch n := expr.(type) {
+		case *parser.Aggref:
+			fixed := *n
+			fixed.Args = make([]parser.Expr, len(n.Args))
+			for i, arg := range n.Args {
+				fixed.Args[i] = agg.fixUpperExpr(arg, subTlist)
+			}
+			agg.addAggregate(&fixed)
+			return &fixed
+		case *parser.Var:
+			panic("variable not found in subplan target list")
+		}
+		return nil
+	})
+}
+
+// Numbers the aggregate by its index in the Aggs, where it is added unless
+// the same aggregate of the same arguments is there, which is computed
+// once for both, as postgres' preprocess_aggrefs.
+func (agg *Agg) addAggregate(aggref *parser.Aggref) {
+	for _, other := range agg.Aggs {
+		aggref.AggNo = other.AggNo
+		if parser.EqualExpr(aggref, other) {
+			return
+		}
+	}
+	aggref.AggNo = len(agg.Aggs)
+	agg.Aggs = append(agg.Aggs, aggref)
+}
+
+// Makes the Agg of no aggregates that returns the distinct rows of the
+// plan, which returns the target list of the query, as postgres'
+// create_distinct_paths.  It returns the rows sorted by the DISTINCT
+// clauses, and so by the ORDER BY they begin with, if they are not
+// hashed, which is told too.
+func makeDistinct(plan Node, query *parser.Query) (Node, bool) {
+	agg := &Agg{AggStrategy: AGG_HASHED}
+	for _, clause := range query.DistinctClause {
+		tle := parser.GetSortGroupClauseTle(clause, query.TargetList)
+		agg.GroupCols = append(agg.GroupCols, system.AttrNumber(tle.ResNo))
+		if !clause.Hashable || !EnableHashAgg {
+			agg.AggStrategy = AGG_SORTED
+		}
+	}
+	for _, tle := range query.TargetList {
+		fixed := *tle
+		fixed.Expr = parser.MakeVar(parser.OUTER_VAR, system.AttrNumber(tle.ResNo), tle.Expr.ResultType())
+		agg.TargetList = append(agg.TargetList, &fixed)
+	}
+	if agg.AggStrategy == AGG_SORTED {
+		plan = makeSort(plan, query.DistinctClause, query.TargetList)
+	}
+	agg.LeftTree = plan
+	return agg, agg.AggStrategy == AGG_SORTED
+}
+
+// Plans an INSERT: a scan of the VALUES or the SELECT, the second entry of
+// the range table, computes the new rows, and a ModifyTable stores them.
+func (planner *PlannerImpl) planInsert(query *parser.Query) Node {
+	var scan Node
+	switch source := query.RangeTables[1]; source.RteType {
+	case parser.RTE_VALUES:
+		scan = &ValuesScan{TargetList: query.TargetList, ValuesLists: source.ValuesLists}
+	case parser.RTE_SUBQUERY:
+		scan = &SubqueryScan{
+			Plan:       Plan{LeftTree: planner.planQuery(source.Subquery)},
+			TargetList: query.TargetList,
+		}
+	default:
+		panic("unexpected range table entry of INSERT")
+	}
+	return &ModifyTable{
+		Plan:           Plan{LeftTree: scan},
+		Operation:      parser.CMD_INSERT,
+		ResultRelation: query.RangeTables[query.ResultRelation-1],
+		ReturningList:  query.ReturningList,
+	}
+}
+
 func makeSeqScan(tlist []*parser.TargetEntry, rte *parser.RangeTblEntry) *SeqScan {
 	scan := &SeqScan{
 		TargetList: tlist,
@@ -53,3 +453,77 @@ func makeSeqScan(tlist []*parser.TargetEntry, rte *parser.RangeTblEntry) *SeqSca
 
 	return scan
 }
+
+// Returns the list of the conditions ANDed in the qual, as postgres'
+// make_ands_implicit.  A nil qual is an empty list.
+func makeAndsImplicit(qual parser.Expr) []parser.Expr {
+	if qual == nil {
+		return nil
+	} else if and, ok := qual.(*parser.BoolExpr); ok && and.BoolOp == parser.AND_EXPR {
+		return and.Args
+	}
+	return []parser.Expr{qual}
+}
+
+// The btree strategies of the comparison operators.
+var btreeStrategies = map[string]access.StrategyNumber{
+	"<":  access.BTLessStrategyNumber,
+	"<=": access.BTLessEqualStrategyNumber,
+	"=":  access.BTEqualStrategyNumber,
+	">=": access.BTGreaterEqualStrategyNumber,
+	">":  access.BTGreaterStrategyNumber,
+}
+
+// Splits the conditions into the scan keys of those the heap scan can
+// check, as postgres' ExecIndexBuildScanKeys does for an index, and the
+// rest.  They are "column op constant" with a btree comparison operator,
+// in either order, and IS [NOT] NULL of a column.
+func extractScanKeys(quals []parser.Expr) ([]access.ScanKey, []parser.Expr) {
+	var keys []access.ScanKey
+	var rest []parser.Expr
+	for _, qual := range quals {
+		if key, ok := makeScanKey(qual); ok {
+			keys = append(keys, key)
+		} else {
+			rest = append(rest, qual)
+		}
+	}
+	return keys, rest
+}
+
+func makeScanKey(qual parser.Expr) (access.ScanKey, bool) {
+	switch n := qual.(type) {
+	case *parser.NullTest:
+		if v, ok := n.Arg.(*parser.Var); ok && v.VarAttNo > 0 {
+			return access.MakeNullScanKey(v.VarAttNo,
+				n.NullTestType == parser.IS_NULL), true
+		}
+	case *parser.OpExpr:
+		if len(n.Args) != 2 {
+			break
+		}
+		opr := n.Opr
+		v, isVar := n.Args[0].(*parser.Var)
+		con, isConst := n.Args[1].(*parser.Const)
+		if !isVar || !isConst {
+			// const op column is column op' const by the commutator
+			v, isVar = n.Args[1].(*parser.Var)
+			con, isConst = n.Args[0].(*parser.Const)
+			opr = opr.CommutatorOperator()
+			if !isVar || !isConst || opr == nil {
+				break
+			}
+		}
+		strategy, ok := btreeStrategies[opr.Name]
+		if !ok || v.VarAttNo <= 0 {
+			break
+		}
+		key, err := access.MakeScanKey(v.VarAttNo, strategy,
+			opr.Left, opr.Right, con.Value)
+		if err != nil {
+			break
+		}
+		return key, true
+	}
+	return access.ScanKey{}, false
+}
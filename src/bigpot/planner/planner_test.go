@@ -0,0 +1,245 @@
+package planner
+
+import (
+	"bytes"
+	. "launchpad.net/gocheck"
+	"os"
+	"testing"
+
+	"bigpot/access"
+	"bigpot/bootstrap"
+	"bigpot/parser"
+	"bigpot/storage"
+	"bigpot/system"
+)
+
+// Hook up gocheck into the gotest runner.
+func Test(t *testing.T) {
+	TestingT(t)
+}
+
+type MySuite struct{}
+
+var _ = Suite(&MySuite{})
+
+// Runs initdb in a new empty directory and changes to it, returning a
+// function that plans a query on template1 and the function that shuts
+// the cluster down and changes back.
+func newPlanner(c *C) (func(query string) *PlanRoot, func()) {
+	cwd, err := os.Getwd()
+	c.Assert(err, IsNil)
+	c.Assert(os.Chdir(c.MkDir()), IsNil)
+	var bki bytes.Buffer
+	c.Assert(bootstrap.GenBKI(&bki), IsNil)
+	c.Assert(bootstrap.InitDB(&bki), IsNil)
+
+	bufMgr, err := storage.NewBufferManager(16)
+	c.Assert(err, IsNil)
+	queue := access.NewSharedInvalQueue()
+	relcache := access.NewRelCache(bufMgr, queue, access.TemplateDbId)
+	syscache := access.NewSysCache(bufMgr, queue, access.TemplateDbId)
+	plan := func(query string) *PlanRoot {
+		parsed, err := parser.NewParser(relcache, syscache).Parse(query)
+		c.Assert(err, IsNil)
+		var planner PlannerImpl
+		return planner.Plan(*parsed)
+	}
+	return plan, func() {
+		relcache.Close()
+		syscache.Close()
+		c.Check(bufMgr.Close(), IsNil)
+		os.Chdir(cwd)
+	}
+}
+
+func (s *MySuite) TestScanKeys(c *C) {
+	plan, done := newPlanner(c)
+	defer done()
+
+	scan := plan("select relname from bp_class where relnatts > 3 and 10 >= relnatts and " +
+		"relhasoids is not null and relname like 'bp%' and relnatts + 1 = 5 and relnatts <> 4").Plan.(*SeqScan)
+	c.Assert(scan.ScanKeys, HasLen, 3)
+	c.Check(scan.ScanKeys[0].AttNum, Equals, system.AttrNumber(access.Anum_class_relnatts))
+	c.Check(scan.ScanKeys[0].Strategy, Equals, access.BTGreaterStrategyNumber)
+	c.Check(scan.ScanKeys[0].Val, Equals, system.Datum(system.Int4(3)))
+	// the constant on the left is turned around
+	c.Check(scan.ScanKeys[1].Strategy, Equals, access.BTLessEqualStrategyNumber)
+	c.Check(scan.ScanKeys[1].Val, Equals, system.Datum(system.Int4(10)))
+	c.Check(scan.ScanKeys[2].Flags&access.SkSearchNotNull, Not(Equals), uint16(0))
+	c.Check(scan.Qual, HasLen, 3)
+
+	// an OR stays in the qual as a whole
+	scan = plan("select relname from bp_class where relnatts = 1 or relnatts = 2").Plan.(*SeqScan)
+	c.Check(scan.ScanKeys, HasLen, 0)
+	c.Check(scan.Qual, HasLen, 1)
+
+	scan = plan("select relname from bp_class").Plan.(*SeqScan)
+	c.Check(scan.ScanKeys, HasLen, 0)
+	c.Check(scan.Qual, HasLen, 0)
+}
+
+func (s *MySuite) TestJoins(c *C) {
+	plan, done := newPlanner(c)
+	defer done()
+	defer func() { EnableHashJoin, EnableMergeJoin = true, true }()
+
+	query := "select c.relname, a.attname from bp_class c, bp_attribute a " +
+		"where a.attrelid = c.relfilenode and a.attnum > 0 and c.relname = 'bp_class'"
+	join := plan(query).Plan.(*HashJoin)
+	outer, inner := join.LeftTree.(*SeqScan), join.RightTree.(*SeqScan)
+	// the conditions on one relation go to its scan
+	c.Check(outer.ScanKeys, HasLen, 1)
+	c.Check(inner.ScanKeys, HasLen, 1)
+	// which returns all of its columns and the ctid
+	natts := len(outer.TargetList) - 1
+	c.Check(outer.TargetList[natts-1].Expr.(*parser.Var).VarAttNo, Equals, system.AttrNumber(natts))
+	c.Check(outer.TargetList[natts].Expr.(*parser.Var).VarAttNo, Equals, system.AttrNumber(system.CtidAttrNumber))
+
+	// the equality is turned around, the outer column on the left
+	c.Assert(join.HashClauses, HasLen, 1)
+	c.Check(join.JoinQual, HasLen, 0)
+	args := join.HashClauses[0].(*parser.OpExpr).Args
+	c.Check(args[0], DeepEquals, parser.MakeVar(parser.OUTER_VAR, access.Anum_class_relfilenode, system.OidType))
+	c.Check(args[1], DeepEquals, parser.MakeVar(parser.INNER_VAR, access.Anum_attribute_attrelid, system.OidType))
+	c.Check(join.TargetList[1].Expr, DeepEquals,
+		parser.MakeVar(parser.INNER_VAR, access.Anum_attribute_attname, system.NameType))
+
+	EnableHashJoin = false
+	merge := plan(query).Plan.(*MergeJoin)
+	c.Check(merge.MergeClauses, HasLen, 1)
+	c.Check(merge.LeftTree.(*Sort).SortKeys, DeepEquals,
+		[]SortKey{{AttNo: access.Anum_class_relfilenode, TypeId: system.OidType}})
+	c.Check(merge.RightTree.(*Sort).SortKeys, DeepEquals,
+		[]SortKey{{AttNo: access.Anum_attribute_attrelid, TypeId: system.OidType}})
+
+	EnableMergeJoin = false
+	loop := plan(query).Plan.(*NestLoop)
+	c.Check(loop.JoinQual, HasLen, 1)
+
+	// the ON of a LEFT JOIN is kept from its left side, and the WHERE
+	// from its right side
+	loop = plan("select 1 from bp_class c left join bp_attribute a " +
+		"on a.attrelid = c.relfilenode and c.relnatts > 3 and a.attnum > 0 " +
+		"where c.relname = 'bp_class' and a.attname is null").Plan.(*NestLoop)
+	c.Check(loop.JoinType, Equals, parser.JOIN_LEFT)
+	c.Check(loop.JoinQual, HasLen, 2)
+	c.Check(loop.Qual, HasLen, 1)
+	c.Check(loop.LeftTree.(*SeqScan).ScanKeys, HasLen, 1)
+	c.Check(loop.RightTree.(*SeqScan).ScanKeys, HasLen, 1)
+
+	// a RIGHT JOIN is a LEFT JOIN the other way round
+	loop = plan("select 1 from bp_class c right join bp_attribute a on true").Plan.(*NestLoop)
+	c.Check(loop.JoinType, Equals, parser.JOIN_LEFT)
+	c.Check(loop.LeftTree.(*SeqScan).RangeTable.RelId, Equals, access.AttributeRelId)
+}
+
+func (s *MySuite) TestSortLimit(c *C) {
+	plan, done := newPlanner(c)
+	defer done()
+
+	root := plan("select relname, relnatts as n from bp_class " +
+		"order by n desc, relnatts + 1 nulls first, 1 limit 5 offset 2")
+	limit := root.Plan.(*Limit)
+	c.Check(limit.LimitCount, NotNil)
+	c.Check(limit.LimitOffset, NotNil)
+	sort := limit.LeftTree.(*Sort)
+	// the expression not in the SELECT list is computed as a junk column
+	c.Check(sort.SortKeys, DeepEquals, []SortKey{
+		{AttNo: 2, TypeId: system.Int4Type, Descending: true, NullsFirst: true},
+		{AttNo: 3, TypeId: system.Int4Type, NullsFirst: true},
+		{AttNo: 1, TypeId: system.NameType},
+	})
+	scan := sort.LeftTree.(*SeqScan)
+	c.Assert(scan.TargetList, HasLen, 3)
+	c.Check(scan.TargetList[2].ResJunk, Equals, true)
+	c.Check(root.TargetList, DeepEquals, scan.TargetList)
+
+	// the same key twice sorts once
+	sort = plan("select relname from bp_class order by relname, 1 desc").Plan.(*Sort)
+	c.Check(sort.SortKeys, HasLen, 1)
+
+	_, ok := plan("select relname from bp_class limit 1").Plan.(*Limit).LeftTree.(*SeqScan)
+	c.Check(ok, Equals, true)
+}
+
+func (s *MySuite) TestAgg(c *C) {
+	plan, done := newPlanner(c)
+	defer done()
+	defer func() { EnableHashAgg = true }()
+
+	// the columns grouped by come first in the rows of the scan, and
+	// those read only within aggregates after them
+	root := plan("select relnatts + 1, count(*), max(relname) from bp_class " +
+		"group by relnatts + 1 having min(relname) <> max(relname)")
+	agg := root.Plan.(*Agg)
+	c.Check(agg.AggStrategy, Equals, AGG_HASHED)
+	c.Check(agg.GroupCols, DeepEquals, []system.AttrNumber{1})
+	// the aggregate of both the target list and the HAVING is computed once
+	c.Check(agg.Aggs, HasLen, 3)
+	c.Check(agg.Qual, NotNil)
+	scan := agg.LeftTree.(*SeqScan)
+	c.Check(scan.TargetList, HasLen, 2)
+	c.Check(agg.TargetList[0].Expr, DeepEquals, parser.MakeVar(parser.OUTER_VAR, 1, system.Int4Type))
+
+	EnableHashAgg = false
+	agg = plan("select relnatts, count(*) from bp_class group by relnatts").Plan.(*Agg)
+	c.Check(agg.AggStrategy, Equals, AGG_SORTED)
+	c.Check(agg.LeftTree.(*Sort).SortKeys, DeepEquals, []SortKey{{AttNo: 1, TypeId: system.Int4Type}})
+
+	agg = plan("select count(*) from bp_class").Plan.(*Agg)
+	c.Check(agg.AggStrategy, Equals, AGG_PLAIN)
+	c.Check(agg.GroupCols, HasLen, 0)
+
+	// a sorted DISTINCT returns the rows in the order of the ORDER BY
+	agg = plan("select distinct relname, relnatts from bp_class order by relnatts desc").Plan.(*Agg)
+	c.Check(agg.AggStrategy, Equals, AGG_SORTED)
+	c.Check(agg.GroupCols, DeepEquals, []system.AttrNumber{2, 1})
+	c.Check(agg.LeftTree.(*Sort).SortKeys[0], DeepEquals, SortKey{AttNo: 2, TypeId: system.Int4Type, Descending: true, NullsFirst: true})
+	EnableHashAgg = true
+	sort := plan("select distinct relnatts from bp_class order by relnatts").Plan.(*Sort)
+	c.Check(sort.LeftTree.(*Agg).AggStrategy, Equals, AGG_HASHED)
+}
+
+func (s *MySuite) TestSubqueries(c *C) {
+	plan, done := newPlanner(c)
+	defer done()
+
+	// a simple subquery is pulled up into the query, and its WHERE goes
+	// to the scan with that of the query
+	scan := plan("select x from (select relname as x, relnatts from bp_class " +
+		"where relnatts > 3) s where x = 'bp_class'").Plan.(*SeqScan)
+	c.Check(scan.ScanKeys, HasLen, 2)
+
+	// one that is not is scanned, with the WHERE of the query as its Qual
+	subscan := plan("select * from (select relnatts, count(*) from bp_class " +
+		"group by relnatts) s where relnatts > 3").Plan.(*SubqueryScan)
+	c.Check(subscan.Qual, HasLen, 1)
+	_, ok := subscan.LeftTree.(*Agg)
+	c.Check(ok, Equals, true)
+
+	// the column of the query the subquery reads is set into a Param by
+	// its SubPlan
+	root := plan("select relname from bp_class c where exists " +
+		"(select 1 from bp_attribute a where a.attrelid = c.relfilenode)")
+	c.Check(root.NParams, Equals, 1)
+	c.Assert(root.SubPlans, HasLen, 1)
+	scan = root.Plan.(*SeqScan)
+	c.Check(scan.AllParam, HasLen, 0)
+	c.Assert(scan.Qual, HasLen, 1)
+	subplan := scan.Qual[0].(*parser.SubPlan)
+	c.Check(subplan.SubLinkType, Equals, parser.EXISTS_SUBLINK)
+	c.Check(subplan.PlanId, Equals, 1)
+	c.Check(subplan.ParParam, DeepEquals, []int{0})
+	c.Check(subplan.Args, DeepEquals,
+		[]parser.Expr{parser.MakeVar(1, access.Anum_class_relfilenode, system.OidType)})
+	c.Check(subplan.ExtParam, DeepEquals, []int{0})
+	c.Check(root.SubPlans[0].(*SeqScan).AllParam, DeepEquals, []int{0})
+
+	// the column of the rows of an IN is read by a Param of its own
+	root = plan("select relname from bp_class where relnatts in (select attnum from bp_attribute)")
+	subplan = root.Plan.(*SeqScan).Qual[0].(*parser.SubPlan)
+	c.Check(subplan.SubLinkType, Equals, parser.ANY_SUBLINK)
+	c.Check(subplan.ParamIds, DeepEquals, []int{0})
+	c.Check(subplan.ParParam, HasLen, 0)
+	c.Check(subplan.ExtParam, HasLen, 0)
+}
@@ -0,0 +1,159 @@
+package planner
+
+import (
+	"bigpot/parser"
+)
+
+// Pulls the simple subqueries of the FROM of the query up into its join
+// tree, as postgres' pull_up_subqueries: the items of the FROM of each
+// take its place, as a FromExpr with its WHERE as the Quals, their
+// entries are appended to the range table, and its columns are replaced
+// by the expressions of its target list throughout the query.  The query
+// and its join tree, copied by the preprocessing, are changed in place.
+func pullUpSubqueries(query *parser.Query) {
+	if query.JoinTree != nil {
+		pullUpFromItem(query, query.JoinTree, false)
+	}
+}
+
+// Pulls up the subqueries of the item of the join tree, and returns what
+// takes its place.  An item is nullable on the side of an outer join it
+// fills with NULLs.
+func pullUpFromItem(query *parser.Query, item parser.Node, nullable bool) parser.Node {
+	switch n := item.(type) {
+	case *parser.RangeTblRef:
+		rte := query.RangeTables[n.RtIndex-1]
+		if rte.RteType == parser.RTE_SUBQUERY && isSimpleSubquery(rte.Subquery) {
+			if pulled := pullUpSimpleSubquery(query, n.RtIndex, nullable); pulled != nil {
+				return pulled
+			}
+		}
+	case *parser.FromExpr:
+		for i, child := range n.FromList {
+			n.FromList[i] = pullUpFromItem(query, child, nullable)
+		}
+	case *parser.JoinExpr:
+		n.Larg = pullUpFromItem(query, n.Larg,
+			nullable || n.JoinType == parser.JOIN_RIGHT || n.JoinType == parser.JOIN_FULL)
+		n.Rarg = pullUpFromItem(query, n.Rarg,
+			nullable || n.JoinType == parser.JOIN_LEFT || n.JoinType == parser.JOIN_FULL)
+	}
+	return item
+}
+
+// Returns true if the subquery of the FROM can be pulled up, as postgres'
+// is_simple_subquery: it only joins and filters the rows of its FROM, and
+// reads no column of the queries it is in, nor has SubLinks, which would
+// go a level up with it.
+func isSimpleSubquery(subquery *parser.Query) bool {
+	if subquery.CommandType != parser.CMD_SELECT || subquery.HasAggs ||
+		len(subquery.GroupClause) > 0 || subquery.HavingQual != nil ||
+		len(subquery.DistinctClause) > 0 || len(subquery.SortClause) > 0 ||
+		subquery.LimitOffset != nil || subquery.LimitCount != nil {
+		return false
+	}
+	if parser.WalkQueryExprs(subquery, func(expr parser.Expr) bool {
+		return parser.WalkExpr(expr, func(expr parser.Expr) bool {
+			_, ok := expr.(*parser.SubLink)
+			return ok
+		})
+	}) {
+		return false
+	}
+	if parser.WalkQueryTree(subquery, func(expr parser.Expr, levelsUp int) bool {
+		v, ok := expr.(*parser.Var)
+		return ok && int(v.VarLevelsUp) > levelsUp
+	}) {
+		return false
+	}
+	return true
+}
+
+// Pulls up the subquery of the entry of the range table, as postgres'
+// pull_up_simple_subquery, and returns the FromExpr that takes the place
+// of its RangeTblRef.  Its own subqueries are pulled up into it first.
+// The entries of its range table follow those of the query, and its Vars
+// are renumbered for them.  On a nullable side, its columns must be
+// columns of its FROM, which go NULL with the rows that match none, or it
+// is left as it is, and nil returned.
+func pullUpSimpleSubquery(query *parser.Query, rtindex int, nullable bool) parser.Node {
+	subquery := parser.MutateQueryExprs(query.RangeTables[rtindex-1].Subquery,
+		func(expr parser.Expr) parser.Expr { return expr })
+	pullUpSubqueries(subquery)
+	if nullable {
+		for _, tle := range subquery.TargetList {
+			if _, ok := tle.Expr.(*parser.Var); !ok {
+				return nil
+			}
+		}
+	}
+	offset := len(query.RangeTables)
+	subquery = parser.MutateQueryExprs(subquery, func(expr parser.Expr) parser.Expr {
+		return parser.MutateExpr(expr, func(expr parser.Expr) parser.Expr {
+			if v, ok := expr.(*parser.Var); ok {
+				renumbered := *v
+				renumbered.VarNo += uint16(offset)
+				return &renumbered
+			}
+			return nil
+		})
+	})
+	offsetJoinTree(subquery.JoinTree, offset)
+	query.RangeTables = append(query.RangeTables, subquery.RangeTables...)
+
+	replace := func(expr parser.Expr) parser.Expr {
+		return parser.MutateExpr(expr, func(expr parser.Expr) parser.Expr {
+			if v, ok := expr.(*parser.Var); ok && int(v.VarNo) == rtindex {
+				return subquery.TargetList[v.VarAttNo-1].Expr
+			}
+			return nil
+		})
+	}
+	for _, tle := range query.TargetList {
+		tle.Expr = replace(tle.Expr)
+	}
+	for _, tle := range query.ReturningList {
+		tle.Expr = replace(tle.Expr)
+	}
+	if query.HavingQual != nil {
+		query.HavingQual = replace(query.HavingQual)
+	}
+	replaceJoinTreeQuals(query.JoinTree, replace)
+	return subquery.JoinTree
+}
+
+// Adds the offset to the indexes of the range table entries of the join
+// tree.
+func offsetJoinTree(node parser.Node, offset int) {
+	switch n := node.(type) {
+	case *parser.FromExpr:
+		for _, item := range n.FromList {
+			offsetJoinTree(item, offset)
+		}
+	case *parser.JoinExpr:
+		n.RtIndex += offset
+		offsetJoinTree(n.Larg, offset)
+		offsetJoinTree(n.Rarg, offset)
+	case *parser.RangeTblRef:
+		n.RtIndex += offset
+	}
+}
+
+// Replaces each of the Quals of the join tree by what fn returns.
+func replaceJoinTreeQuals(node parser.Node, fn func(parser.Expr) parser.Expr) {
+	switch n := node.(type) {
+	case *parser.FromExpr:
+		for _, item := range n.FromList {
+			replaceJoinTreeQuals(item, fn)
+		}
+		if n.Quals != nil {
+			n.Quals = fn(n.Quals)
+		}
+	case *parser.JoinExpr:
+		replaceJoinTreeQuals(n.Larg, fn)
+		replaceJoinTreeQuals(n.Rarg, fn)
+		if n.Quals != nil {
+			n.Quals = fn(n.Quals)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package planner
+
+import (
+	"bigpot/parser"
+	"bigpot/system"
+)
+
+// relColumns is a relation of the rows a plan returns below a join: its
+// index in the range table, from 1, and its number of columns.
+type relColumns struct {
+	rtindex int
+	natts   int
+}
+
+// rowLayout tells what the rows a plan returns below a join hold: the
+// columns of each of the relations, in order, each followed by its ctid,
+// so that an UPDATE or DELETE above finds the rows to change.
+type rowLayout []relColumns
+
+// Returns the column of the row that holds the column of the Var, from 1,
+// or false if the row does not have it.
+func (layout rowLayout) find(v *parser.Var) (system.AttrNumber, bool) {
+	offset := 0
+	for _, rel := range layout {
+		if rel.rtindex != int(v.VarNo) {
+			offset += rel.natts + 1
+		} else if v.VarAttNo == system.CtidAttrNumber {
+			return system.AttrNumber(offset + rel.natts + 1), true
+		} else {
+			return system.AttrNumber(offset) + v.VarAttNo, true
+		}
+	}
+	return 0, false
+}
+
+// Returns true if the relations of the layout have all the columns the
+// expression reads, as postgres' bms_is_subset of pull_varnos.
+func (layout rowLayout) covers(expr parser.Expr) bool {
+	return !parser.WalkExpr(expr, func(expr parser.Expr) bool {
+		v, ok := expr.(*parser.Var)
+		if !ok {
+			return false
+		}
+		_, found := layout.find(v)
+		return !found
+	})
+}
+
+// Splits the conditions into those the relations of the layout have all
+// the columns of, and the rest.
+func splitQuals(quals []parser.Expr, layout rowLayout) ([]parser.Expr, []parser.Expr) {
+	var covered, rest []parser.Expr
+	for _, qual := range quals {
+		if layout.covers(qual) {
+			covered = append(covered, qual)
+		} else {
+			rest = append(rest, qual)
+		}
+	}
+	return covered, rest
+}
+
+// Makes the target list of the rows of the layout, of Vars of the columns
+// of its relations.  Those that are not tables have a NULL for a ctid.
+func makeRowTargetList(layout rowLayout, rtable []*parser.RangeTblEntry) []*parser.TargetEntry {
+	var tlist []*parser.TargetEntry
+	for _, rel := range layout {
+		rte := rtable[rel.rtindex-1]
+		for i, typid := range rte.ColTypes {
+			v := parser.MakeVar(uint16(rel.rtindex), system.AttrNumber(i+1), typid)
+			tlist = append(tlist, &parser.TargetEntry{Expr: v, ResNo: uint16(len(tlist) + 1)})
+		}
+		var ctid parser.Expr = parser.MakeNullConst(system.TidType)
+		if rte.RteType == parser.RTE_RELATION {
+			ctid = parser.MakeVar(uint16(rel.rtindex), system.CtidAttrNumber, system.TidType)
+		}
+		tlist = append(tlist, &parser.TargetEntry{Expr: ctid, ResNo: uint16(len(tlist) + 1), ResName: "ctid"})
+	}
+	return tlist
+}
+
+// Rewrites the Vars of the expression to the columns of the outer or the
+// inner row of the join, as postgres' fix_join_expr.
+func fixJoinExpr(expr parser.Expr, outer, inner rowLayout) parser.Expr {
+	return parser.MutateExpr(expr, func(expr parser.Expr) parser.Expr {
+		v, ok := expr.(*parser.Var)
+		if !ok {
+			return nil
+		}
+		fixed := *v
+		if attno, found := outer.find(v); found {
+			fixed.VarNo, fixed.VarAttNo = parser.OUTER_VAR, attno
+		} else if attno, found := inner.find(v); found {
+			fixed.VarNo, fixed.VarAttNo = parser.INNER_VAR, attno
+		} else {
+			panic("variable not found in subplan target lists")
+		}
+		return &fixed
+	})
+}
+
+func fixJoinExprs(exprs []parser.Expr, outer, inner rowLayout) []parser.Expr {
+	var fixed []parser.Expr
+	for _, expr := range exprs {
+		fixed = append(fixed, fixJoinExpr(expr, outer, inner))
+	}
+	return fixed
+}
+
+func fixJoinTargetList(tlist []*parser.TargetEntry, outer, inner rowLayout) []*parser.TargetEntry {
+	fixed := make([]*parser.TargetEntry, len(tlist))
+	for i, tle := range tlist {
+		newTle := *tle
+		newTle.Expr = fixJoinExpr(tle.Expr, outer, inner)
+		fixed[i] = &newTle
+	}
+	return fixed
+}
@@ -0,0 +1,176 @@
+package planner
+
+import (
+	"sort"
+
+	"bigpot/parser"
+)
+
+// planParam is a column of a query that a subquery reads, and the Param
+// it reads it by, as postgres' PlannerParamItem.
+type planParam struct {
+	item    *parser.Var
+	paramId int
+}
+
+// Replaces the SubLinks of the expression by SubPlans, and the columns of
+// the outer queries by Params, as postgres' preprocess_expression does by
+// SS_process_sublinks and SS_replace_correlation_vars.
+func (planner *PlannerImpl) preprocessExpr(expr parser.Expr) parser.Expr {
+	return parser.MutateExpr(expr, func(expr parser.Expr) parser.Expr {
+		switch n := expr.(type) {
+		case *parser.Var:
+			if n.VarLevelsUp > 0 {
+				return planner.replaceOuterVar(n)
+			}
+		case *parser.SubLink:
+			return planner.makeSubPlan(n)
+		}
+		return nil
+	})
+}
+
+// Returns the Param of the column of an outer query, as postgres'
+// replace_outer_var.  The column is added to those of its level, unless
+// it is there already, for the SubPlan of the subquery below it to set
+// the Param from.
+func (planner *PlannerImpl) replaceOuterVar(v *parser.Var) parser.Expr {
+	level := len(planner.levels) - 1 - int(v.VarLevelsUp)
+	item := *v
+	item.VarLevelsUp = 0
+	for _, param := range planner.levels[level] {
+		if parser.EqualExpr(param.item, &item) {
+			return parser.MakeParam(parser.PARAM_EXEC, param.paramId, v.ResultType())
+		}
+	}
+	param := planParam{&item, planner.assignParam()}
+	planner.levels[level] = append(planner.levels[level], param)
+	return parser.MakeParam(parser.PARAM_EXEC, param.paramId, v.ResultType())
+}
+
+// Returns the index of a new Param of PARAM_EXEC.
+func (planner *PlannerImpl) assignParam() int {
+	planner.nParams++
+	return planner.nParams - 1
+}
+
+// Plans the subquery of the SubLink, and makes the SubPlan that runs it,
+// as postgres' make_subplan.  The columns of the query that the subquery
+// reads, as Params, are the arguments of the SubPlan; the column of the
+// rows of an ANY_SUBLINK is read by its Testexpr as a Param too.
+func (planner *PlannerImpl) makeSubPlan(sublink *parser.SubLink) parser.Expr {
+	testexpr := planner.preprocessExpr(sublink.Testexpr)
+	level := len(planner.levels) - 1
+	planner.levels[level] = nil
+	plan := planner.planQuery(sublink.Subselect)
+	params := planner.levels[level]
+	planner.levels[level] = nil
+
+	subplan := &parser.SubPlan{ExprImpl: sublink.ExprImpl, SubLinkType: sublink.SubLinkType}
+	for _, param := range params {
+		subplan.ParParam = append(subplan.ParParam, param.paramId)
+		subplan.Args = append(subplan.Args, param.item)
+	}
+	subplan.Testexpr = parser.MutateExpr(testexpr, func(expr parser.Expr) parser.Expr {
+		param, ok := expr.(*parser.Param)
+		if !ok || param.ParamKind != parser.PARAM_SUBLINK {
+			return nil
+		}
+		for len(subplan.ParamIds) < param.ParamId {
+			subplan.ParamIds = append(subplan.ParamIds, planner.assignParam())
+		}
+		return parser.MakeParam(parser.PARAM_EXEC, subplan.ParamIds[param.ParamId-1], param.ResultType())
+	})
+	planner.subplans = append(planner.subplans, plan)
+	subplan.PlanId = len(planner.subplans)
+	subplan.ExtParam = finalizePlan(plan)
+	return subplan
+}
+
+// Sets the AllParam of each node of the plan, as postgres'
+// finalize_plan, and returns those of its top node.  A SubPlan in the
+// expressions of a node reads the Params its plan reads, but for those it
+// sets itself.
+func finalizePlan(node Node) []int {
+	plan, exprs := planExprs(node)
+	params := map[int]bool{}
+	for _, child := range []Node{plan.LeftTree, plan.RightTree} {
+		if child != nil {
+			for _, id := range finalizePlan(child) {
+				params[id] = true
+			}
+		}
+	}
+	var set []int
+	for _, expr := range exprs {
+		parser.WalkExpr(expr, func(expr parser.Expr) bool {
+			switch n := expr.(type) {
+			case *parser.Param:
+				if n.ParamKind == parser.PARAM_EXEC {
+					params[n.ParamId] = true
+				}
+			case *parser.SubPlan:
+				for _, id := range n.ExtParam {
+					params[id] = true
+				}
+				set = append(append(set, n.ParParam...), n.ParamIds...)
+			}
+			return false
+		})
+	}
+	for _, id := range set {
+		delete(params, id)
+	}
+	plan.AllParam = nil
+	for id := range params {
+		plan.AllParam = append(plan.AllParam, id)
+	}
+	sort.Ints(plan.AllParam)
+	return plan.AllParam
+}
+
+// Returns the Plan of the node, and the expressions it computes.
+func planExprs(node Node) (*Plan, []parser.Expr) {
+	tlistExprs := func(tlist []*parser.TargetEntry) []parser.Expr {
+		var exprs []parser.Expr
+		for _, tle := range tlist {
+			exprs = append(exprs, tle.Expr)
+		}
+		return exprs
+	}
+	joinExprs := func(join *Join, clauses []parser.Expr) []parser.Expr {
+		exprs := append(append([]parser.Expr{}, join.Qual...), join.JoinQual...)
+		return append(append(exprs, clauses...), tlistExprs(join.TargetList)...)
+	}
+	switch n := node.(type) {
+	case *SeqScan:
+		return &n.Plan, append(tlistExprs(n.TargetList), n.Qual...)
+	case *ValuesScan:
+		exprs := append(tlistExprs(n.TargetList), n.Qual...)
+		for _, row := range n.ValuesLists {
+			exprs = append(exprs, row...)
+		}
+		return &n.Plan, exprs
+	case *SubqueryScan:
+		return &n.Plan, append(tlistExprs(n.TargetList), n.Qual...)
+	case *NestLoop:
+		return &n.Plan, joinExprs(&n.Join, nil)
+	case *HashJoin:
+		return &n.Plan, joinExprs(&n.Join, n.HashClauses)
+	case *MergeJoin:
+		return &n.Plan, joinExprs(&n.Join, n.MergeClauses)
+	case *Sort:
+		return &n.Plan, n.Qual
+	case *Limit:
+		return &n.Plan, []parser.Expr{n.LimitOffset, n.LimitCount}
+	case *Agg:
+		exprs := append(tlistExprs(n.TargetList), n.Qual...)
+		for _, aggref := range n.Aggs {
+			exprs = append(exprs, aggref)
+		}
+		return &n.Plan, exprs
+	case *ModifyTable:
+		return &n.Plan, tlistExprs(n.ReturningList)
+	}
+	panic("unrecognized plan node type")
+}
@@ -13,6 +13,17 @@ type Block [system.BlockSize]byte
 type BufferManager interface {
 	ReadBuffer(system.RelFileNode, system.BlockNumber) (Buffer, error)
 	ReleaseBuffer(Buffer)
+	// Writes out every dirty buffer, as postgres' BufferSync at a
+	// checkpoint.
+	FlushAllBuffers() error
+	// Forgets the buffers of the relation file without writing them, as
+	// postgres' DropRelFileNodeBuffers, before the file is removed.  None
+	// of them may be pinned.
+	DropRelFileNodeBuffers(reln system.RelFileNode)
+	// Writes out every dirty buffer and records in the control file that
+	// the cluster was shut down cleanly, as postgres' ShutdownXLOG.  The
+	// buffer manager may not be used after.
+	Close() error
 }
 
 type Buffer interface {
@@ -21,8 +32,10 @@ type Buffer interface {
 	Lock()
 	RLock()
 	Unlock()
+	RUnlock()
 	GetPage() *Page
 	MarkDirty()
+	BlockNumber() system.BlockNumber
 }
 
 type bufferTag struct {
@@ -40,6 +53,11 @@ type readBufferReq struct {
 	res chan readBufferRes
 }
 
+type dropBuffersReq struct {
+	reln system.RelFileNode
+	done chan struct{}
+}
+
 // Implements BufferManager
 type bufMgr struct {
 	lookup map[bufferTag]*bufferDesc
@@ -49,6 +67,9 @@ type bufMgr struct {
 	pool        []Block
 	readChan    chan readBufferReq
 	releaseChan chan *bufferDesc
+	flushChan   chan chan error
+	dropChan    chan dropBuffersReq
+	closeChan   chan chan error
 	smgr        Smgr
 	nextVictim  int
 }
@@ -75,14 +96,34 @@ const NewBlock = system.InvalidBlockNumber
 // even if the same backend acquires the buffer.
 const _MaxUsageCount = 10
 
-// Allocates a new BufferManager, with the number of buffer nBuffers.
-func NewBufferManager(nBuffers int) BufferManager {
+// Allocates a new BufferManager, with the number of buffer nBuffers.  The
+// control file of the data directory is read first, and a data directory
+// made by an incompatible build is refused.  The cluster is then recorded
+// to be in production, as postgres' StartupXLOG does, until Close.
+func NewBufferManager(nBuffers int) (BufferManager, error) {
+	ctl, err := ReadControlFile()
+	if err != nil {
+		return nil, err
+	}
+	if err := ctl.CheckCompatibility(); err != nil {
+		return nil, err
+	}
+	err = UpdateControlFile(func(ctl *ControlFileData) {
+		ctl.State = DBInProduction
+	})
+	if err != nil {
+		return nil, err
+	}
+
 	mgr := &bufMgr{
 		lookup:      map[bufferTag]*bufferDesc{},
 		descriptors: make([]bufferDesc, nBuffers),
 		pool:        make([]Block, nBuffers),
 		readChan:    make(chan readBufferReq),
 		releaseChan: make(chan *bufferDesc),
+		flushChan:   make(chan chan error),
+		dropChan:    make(chan dropBuffersReq),
+		closeChan:   make(chan chan error),
 		nextVictim:  0,
 	}
 	mgr.smgr = NewMdSmgr()
@@ -93,7 +134,7 @@ func NewBufferManager(nBuffers int) BufferManager {
 	}
 	go mgr.ioRoutine()
 
-	return BufferManager(mgr)
+	return BufferManager(mgr), nil
 }
 
 // Implements BufferManager.ReadBuffer.  Upon return, the returned buffer
@@ -122,6 +163,66 @@ func (mgr *bufMgr) ReleaseBuffer(buf Buffer) {
 	mgr.releaseChan <- bufDesc
 }
 
+// Implements BufferManager.FlushAllBuffers.
+func (mgr *bufMgr) FlushAllBuffers() error {
+	res := make(chan error)
+	mgr.flushChan <- res
+	return <-res
+}
+
+func (mgr *bufMgr) flushAllBuffersInternal() error {
+	for i := range mgr.descriptors {
+		buf := &mgr.descriptors[i]
+		if !buf.isValid || !buf.isDirty {
+			continue
+		}
+		buf.RLock()
+		err := mgr.writeBuffer(buf)
+		buf.RUnlock()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Implements BufferManager.DropRelFileNodeBuffers.
+func (mgr *bufMgr) DropRelFileNodeBuffers(reln system.RelFileNode) {
+	req := dropBuffersReq{reln, make(chan struct{})}
+	mgr.dropChan <- req
+	<-req.done
+}
+
+// Implements BufferManager.Close.  The io routine writes out the buffers
+// and stops.
+func (mgr *bufMgr) Close() error {
+	res := make(chan error)
+	mgr.closeChan <- res
+	if err := <-res; err != nil {
+		return err
+	}
+	return UpdateControlFile(func(ctl *ControlFileData) {
+		ctl.State = DBShutdowned
+	})
+}
+
+func (mgr *bufMgr) dropRelFileNodeBuffersInternal(reln system.RelFileNode) {
+	for i := range mgr.descriptors {
+		buf := &mgr.descriptors[i]
+		if !buf.isTagValid || buf.tag.reln != reln {
+			continue
+		}
+		if buf.refCount > 0 {
+			panic(fmt.Sprintf("dropping pinned buffer of block %d", buf.tag.block))
+		}
+		delete(mgr.lookup, buf.tag)
+		buf.isValid = false
+		buf.isTagValid = false
+		buf.isDirty = false
+		buf.usageCount = 0
+	}
+}
+
 // This is a background workhose goroutine that performs requested tasks.
 func (mgr *bufMgr) ioRoutine() {
 	for {
@@ -133,6 +234,17 @@ func (mgr *bufMgr) ioRoutine() {
 
 		case bufDesc := <-mgr.releaseChan:
 			bufDesc.unpin()
+
+		case res := <-mgr.flushChan:
+			res <- mgr.flushAllBuffersInternal()
+
+		case req := <-mgr.dropChan:
+			mgr.dropRelFileNodeBuffersInternal(req.reln)
+			close(req.done)
+
+		case res := <-mgr.closeChan:
+			res <- mgr.flushAllBuffersInternal()
+			return
 		}
 	}
 }
@@ -303,6 +415,12 @@ func (buf *bufferDesc) MarkDirty() {
 	buf.isDirty = true
 }
 
+// Returns the block number the buffer holds.  This is the way to know
+// which block was allocated when reading NewBlock.
+func (buf *bufferDesc) BlockNumber() system.BlockNumber {
+	return buf.tag.block
+}
+
 func (buf *bufferDesc) pin() {
 	buf.refCount++
 
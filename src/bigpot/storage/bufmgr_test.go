@@ -10,11 +10,15 @@ import (
 func (s *MySuite) TestBufferManager(c *C) {
 	os.MkdirAll("base/1", 0700)
 	defer os.RemoveAll("base")
+	os.MkdirAll("global", 0700)
+	defer os.RemoveAll("global")
+	c.Assert(WriteControlFile(NewControlFileData()), IsNil)
 
-	mgr := NewBufferManager(16)
+	mgr, err := NewBufferManager(16)
+	c.Assert(err, IsNil)
 
 	reln := system.RelFileNode{1, system.DefaultTableSpaceOid, 1259}
-	_, err := mgr.ReadBuffer(reln, NewBlock)
+	_, err = mgr.ReadBuffer(reln, NewBlock)
 	c.Check(err, ErrorMatches, ".* no such file or directory")
 
 	// For now, create an empty file first.
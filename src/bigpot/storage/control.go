@@ -0,0 +1,160 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"sync"
+
+	"bigpot/system"
+)
+
+// The path of the control file, relative to the data directory.
+const ControlFilePath = "global/bp_control"
+
+// DBState is the state of the cluster recorded in the control file, as
+// postgres' DBState.
+type DBState uint16
+
+const (
+	DBStartup DBState = iota
+	DBShutdowned
+	DBInProduction
+)
+
+func (state DBState) String() string {
+	switch state {
+	case DBStartup:
+		return "starting up"
+	case DBShutdowned:
+		return "shut down"
+	case DBInProduction:
+		return "in production"
+	}
+	return "unrecognized status code"
+}
+
+// ControlFileData is the content of the control file, recording how the
+// data directory was made and the counters that must survive a restart, as
+// postgres' ControlFileData.  Crc covers every field before it.
+type ControlFileData struct {
+	BlockSize      uint32
+	LayoutVersion  uint16
+	State          DBState
+	CatalogVersion uint32
+	NextXid        system.Xid
+	NextOid        system.Oid
+	Crc            uint32
+}
+
+// The size of the control file, for the fields are all of fixed size.
+var sizeOfControlFileData = binary.Size(ControlFileData{})
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// Serializes the updates of the control file, as postgres' ControlFileLock.
+var controlFileLock sync.Mutex
+
+// Makes the control file data of a new data directory.
+func NewControlFileData() *ControlFileData {
+	return &ControlFileData{
+		BlockSize:      system.BlockSize,
+		LayoutVersion:  LayoutVersion,
+		State:          DBShutdowned,
+		CatalogVersion: system.CatalogVersion,
+		NextXid:        system.FirstNormalXid,
+		NextOid:        system.FirstNormalObjectId,
+	}
+}
+
+// Returns the fields in the byte order of the file, with the checksum of
+// those before it set.
+func (ctl *ControlFileData) marshal() []byte {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, ctl)
+	data := buf.Bytes()
+	crcOffset := len(data) - binary.Size(ctl.Crc)
+	crc := crc32.Checksum(data[:crcOffset], crcTable)
+	binary.LittleEndian.PutUint32(data[crcOffset:], crc)
+	return data
+}
+
+// Writes the control file, as postgres' WriteControlFile.
+func WriteControlFile(ctl *ControlFileData) error {
+	file, err := os.OpenFile(ControlFilePath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return system.Ereport(system.IoError, "could not create control file \"%s\": %v",
+			ControlFilePath, err)
+	}
+	if _, err := file.Write(ctl.marshal()); err != nil {
+		file.Close()
+		return system.Ereport(system.IoError, "could not write to control file: %v", err)
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return system.Ereport(system.IoError, "could not fsync control file: %v", err)
+	}
+	return file.Close()
+}
+
+// Reads the control file and checks its checksum, as postgres'
+// ReadControlFile.
+func ReadControlFile() (*ControlFileData, error) {
+	data, err := ioutil.ReadFile(ControlFilePath)
+	if err != nil {
+		return nil, system.Ereport(system.IoError, "could not open control file \"%s\": %v",
+			ControlFilePath, err)
+	}
+	if len(data) != sizeOfControlFileData {
+		return nil, system.Ereport(system.DataCorrupted,
+			"control file \"%s\" has wrong size: %d, expected %d",
+			ControlFilePath, len(data), sizeOfControlFileData)
+	}
+	ctl := &ControlFileData{}
+	binary.Read(bytes.NewReader(data), binary.LittleEndian, ctl)
+	if !bytes.Equal(ctl.marshal(), data) {
+		return nil, system.Ereport(system.DataCorrupted, "incorrect checksum in control file")
+	}
+	return ctl, nil
+}
+
+// Reads the control file, changes it by update and writes it back, as
+// postgres' UpdateControlFile.  The updates are made one at a time, so
+// that none of them is lost.
+func UpdateControlFile(update func(ctl *ControlFileData)) error {
+	controlFileLock.Lock()
+	defer controlFileLock.Unlock()
+
+	ctl, err := ReadControlFile()
+	if err != nil {
+		return err
+	}
+	update(ctl)
+	return WriteControlFile(ctl)
+}
+
+// Checks that the data directory was made by a compatible build, as
+// postgres' ReadControlFile does after reading it.
+func (ctl *ControlFileData) CheckCompatibility() error {
+	if ctl.LayoutVersion != LayoutVersion {
+		return system.Ereport(system.ObjectNotInPrerequisiteState,
+			"database files are incompatible with server: the database cluster was "+
+				"initialized with page layout version %d, but the server was compiled "+
+				"with page layout version %d", ctl.LayoutVersion, LayoutVersion)
+	}
+	if ctl.CatalogVersion != system.CatalogVersion {
+		return system.Ereport(system.ObjectNotInPrerequisiteState,
+			"database files are incompatible with server: the database cluster was "+
+				"initialized with catalog version %d, but the server was compiled "+
+				"with catalog version %d", ctl.CatalogVersion, system.CatalogVersion)
+	}
+	if ctl.BlockSize != system.BlockSize {
+		return system.Ereport(system.ObjectNotInPrerequisiteState,
+			"database files are incompatible with server: the database cluster was "+
+				"initialized with block size %d, but the server was compiled "+
+				"with block size %d", ctl.BlockSize, system.BlockSize)
+	}
+	return nil
+}
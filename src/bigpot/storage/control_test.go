@@ -0,0 +1,87 @@
+package storage
+
+import (
+	"io/ioutil"
+	. "launchpad.net/gocheck"
+	"os"
+
+	"bigpot/system"
+)
+
+func (s *MySuite) TestControlFile(c *C) {
+	os.MkdirAll("global", 0700)
+	defer os.RemoveAll("global")
+
+	_, err := NewBufferManager(16)
+	c.Check(err, ErrorMatches, "could not open control file \"global/bp_control\": .*")
+
+	ctl := NewControlFileData()
+	ctl.NextOid = 20000
+	c.Assert(WriteControlFile(ctl), IsNil)
+	read, err := ReadControlFile()
+	c.Assert(err, IsNil)
+	c.Check(read.NextOid, Equals, system.Oid(20000))
+	c.Check(read.State, Equals, DBShutdowned)
+	c.Check(read.State.String(), Equals, "shut down")
+	_, err = NewBufferManager(16)
+	c.Check(err, IsNil)
+
+	// a flipped bit fails the checksum
+	data, err := ioutil.ReadFile(ControlFilePath)
+	c.Assert(err, IsNil)
+	data[0] ^= 1
+	c.Assert(ioutil.WriteFile(ControlFilePath, data, 0600), IsNil)
+	_, err = NewBufferManager(16)
+	c.Check(err, ErrorMatches, "incorrect checksum in control file")
+	c.Assert(ioutil.WriteFile(ControlFilePath, data[:10], 0600), IsNil)
+	_, err = ReadControlFile()
+	c.Check(err, ErrorMatches, "control file \"global/bp_control\" has wrong size: 10, expected .*")
+
+	// a data directory of another build is refused
+	for _, t := range []struct {
+		change func(*ControlFileData)
+		err    string
+	}{
+		{func(ctl *ControlFileData) { ctl.BlockSize = 8192 }, ".*initialized with block size 8192, but the server was compiled with block size 4096"},
+		{func(ctl *ControlFileData) { ctl.LayoutVersion = 3 }, ".*initialized with page layout version 3, but .* version 4"},
+		{func(ctl *ControlFileData) { ctl.CatalogVersion-- }, ".*initialized with catalog version .*"},
+	} {
+		ctl := NewControlFileData()
+		t.change(ctl)
+		c.Assert(WriteControlFile(ctl), IsNil)
+		_, err = NewBufferManager(16)
+		c.Check(err, ErrorMatches, "database files are incompatible with server: "+t.err)
+		c.Check(err.(*system.Error).Code(), Equals, system.ObjectNotInPrerequisiteState)
+	}
+}
+
+func (s *MySuite) TestControlFileState(c *C) {
+	os.MkdirAll("global", 0700)
+	defer os.RemoveAll("global")
+	c.Assert(WriteControlFile(NewControlFileData()), IsNil)
+
+	state := func() DBState {
+		ctl, err := ReadControlFile()
+		c.Assert(err, IsNil)
+		return ctl.State
+	}
+	c.Check(state(), Equals, DBShutdowned)
+	mgr, err := NewBufferManager(16)
+	c.Assert(err, IsNil)
+	c.Check(state(), Equals, DBInProduction)
+
+	// the other fields are kept
+	c.Assert(UpdateControlFile(func(ctl *ControlFileData) { ctl.NextOid = 30000 }), IsNil)
+	c.Assert(mgr.Close(), IsNil)
+	c.Check(state(), Equals, DBShutdowned)
+	ctl, err := ReadControlFile()
+	c.Assert(err, IsNil)
+	c.Check(ctl.NextOid, Equals, system.Oid(30000))
+
+	// and the cluster may be started again
+	mgr, err = NewBufferManager(16)
+	c.Assert(err, IsNil)
+	c.Check(state(), Equals, DBInProduction)
+	c.Assert(mgr.Close(), IsNil)
+	c.Check(state(), Equals, DBShutdowned)
+}
@@ -0,0 +1,32 @@
+package storage
+
+import (
+	"io/ioutil"
+	"os"
+
+	"bigpot/system"
+)
+
+// The directory of the temporary files of queries, as postgres'
+// base/pgsql_tmp.
+const TempFileDir = "base/bp_tmp"
+
+// Creates a new temporary file, as postgres' OpenTemporaryFile.  Its name
+// is removed at once, so that the file is gone once it is closed, or the
+// server exits.
+func OpenTemporaryFile() (*os.File, error) {
+	if err := os.MkdirAll(TempFileDir, 0700); err != nil {
+		return nil, system.Ereport(system.IoError,
+			"could not create directory \"%s\": %v", TempFileDir, err)
+	}
+	file, err := ioutil.TempFile(TempFileDir, "bp_tmp")
+	if err != nil {
+		return nil, system.Ereport(system.IoError, "could not create temporary file: %v", err)
+	}
+	if err := os.Remove(file.Name()); err != nil {
+		file.Close()
+		return nil, system.Ereport(system.IoError,
+			"could not remove file \"%s\": %v", file.Name(), err)
+	}
+	return file, nil
+}
@@ -22,6 +22,10 @@ type pageHeader struct {
 const sizeOfPageHeader = uint16(unsafe.Offsetof(pageHeader{}.linp))
 const LayoutVersion = uint16(4)
 
+// The largest heap tuple that fits on a page, as postgres' MaxHeapTupleSize.
+var MaxHeapTupleSize = int(system.BlockSize -
+	system.MaxAlign(uintptr(sizeOfPageHeader)+unsafe.Sizeof(ItemId(0))))
+
 // _PageHeader.flags contains the following flag bits.  Undefined bits are initialized
 // to zero and may be used in the future.
 //
@@ -371,6 +375,31 @@ func (page *Page) Item(itemId *ItemId) []byte {
 	return page.bytes[offset : offset+length]
 }
 
+// Returns the special space of the page, which access methods use to keep
+// their own opaque data.
+func (page *Page) SpecialSpace() []byte {
+	return page.bytes[page.Special():]
+}
+
+// Returns the page contents following the header, for pages that don't
+// use line pointers such as index metapages.  Callers should set pd_lower
+// past the data they put here.
+func (page *Page) Contents() []byte {
+	return page.bytes[system.MaxAlign(uintptr(sizeOfPageHeader)):]
+}
+
+// Returns the byte offset of the given position in the contents.
+func (page *Page) ContentsOffset(length uintptr) uint16 {
+	return uint16(system.MaxAlign(uintptr(sizeOfPageHeader)) + length)
+}
+
+// Overwrites the page with the content of src.  This is used to build a
+// page in a temporary page and then copy it back, as postgres'
+// PageRestoreTempPage.
+func (page *Page) CopyFrom(src *Page) {
+	copy(page.bytes[:], src.bytes[:])
+}
+
 // Returns the size of the free (allocatable) space on a page,
 // reduced by the space needed for a new line pointer.
 // Note: this should usually only be used on index pages.  Use
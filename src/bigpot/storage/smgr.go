@@ -3,6 +3,7 @@ package storage
 import (
 	"fmt"
 	"os"
+	"sync"
 
 	"bigpot/system"
 )
@@ -12,6 +13,13 @@ type Smgr interface {
 }
 
 type SmgrRelation interface {
+	// Creates the empty file, as postgres' smgrcreate.  It is an error if
+	// the file exists.
+	Create() error
+	// Removes the file, as postgres' smgrunlink.  Its buffers must have
+	// been dropped first.
+	Unlink() error
+	Exists() bool
 	NBlocks() (system.BlockNumber, error)
 	Read(blockNum system.BlockNumber, data *Block) error
 	Write(blockNum system.BlockNumber, data *Block) error
@@ -21,6 +29,7 @@ type SmgrRelation interface {
 
 // Implements Smgr
 type mdSmgr struct {
+	lock   sync.Mutex
 	lookup map[system.RelFileNode]*mdRelation
 }
 
@@ -38,6 +47,8 @@ func NewMdSmgr() Smgr {
 }
 
 func (mgr *mdSmgr) GetRelation(reln system.RelFileNode) SmgrRelation {
+	mgr.lock.Lock()
+	defer mgr.lock.Unlock()
 	if rel, found := mgr.lookup[reln]; found {
 		return SmgrRelation(rel)
 	}
@@ -50,6 +61,29 @@ func (mgr *mdSmgr) GetRelation(reln system.RelFileNode) SmgrRelation {
 	return SmgrRelation(rel)
 }
 
+func (md *mdRelation) Create() error {
+	relpath := system.RelPath(md.node)
+	file, err := os.OpenFile(relpath, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return fmt.Errorf("could not create file \"%s\": %v", relpath, err)
+	}
+	return file.Close()
+}
+
+func (md *mdRelation) Unlink() error {
+	md.Close()
+	relpath := system.RelPath(md.node)
+	if err := os.Remove(relpath); err != nil {
+		return fmt.Errorf("could not remove file \"%s\": %v", relpath, err)
+	}
+	return nil
+}
+
+func (md *mdRelation) Exists() bool {
+	_, err := os.Stat(system.RelPath(md.node))
+	return err == nil
+}
+
 func (md *mdRelation) NBlocks() (system.BlockNumber, error) {
 	relpath := system.RelPath(md.node)
 
@@ -0,0 +1,153 @@
+package system
+
+// AggregateInfo describes an aggregate function, as postgres' pg_aggregate
+// entry.  Proc is its entry of the function registry, through which its
+// calls are resolved as those of any function; it has no Func of its own.
+//
+// The state of a group is of TransType and starts as InitValue, which may
+// be NULL.  TransFn computes the next state from the state and the
+// arguments of each row, and FinalFn, if not nil, the result from the last
+// state, which is the result otherwise.  If TransFn is strict, the rows
+// with a NULL argument are passed over, and a NULL state is replaced by
+// the first argument, as postgres' advance_transition_function.
+type AggregateInfo struct {
+	Proc      *ProcInfo
+	TransFn   *ProcInfo
+	FinalFn   *ProcInfo
+	TransType Oid
+	InitValue Datum
+}
+
+// Registry of the built-in aggregates, keyed by the oid of their function.
+var AggregateRegistry = map[Oid]*AggregateInfo{}
+
+// Registers an aggregate of the argument types and result type, along
+// with its function.  The transition and final functions are registered
+// already; finalFn may be nil.
+func RegisterAggregate(name string, argTypes []Oid, retType Oid,
+	transFn, finalFn *ProcInfo, initValue Datum) *AggregateInfo {
+	agg := &AggregateInfo{
+		Proc:      RegisterProc(name, argTypes, retType, nil),
+		TransFn:   transFn,
+		FinalFn:   finalFn,
+		TransType: transFn.RetType,
+		InitValue: initValue,
+	}
+	AggregateRegistry[agg.Proc.Id] = agg
+	return agg
+}
+
+// Returns the aggregate the function is, or nil if it is a plain function.
+func LookupAggregate(proc *ProcInfo) *AggregateInfo {
+	return AggregateRegistry[proc.Id]
+}
+
+// Returns the state once the arguments of a row are taken in, as postgres'
+// advance_transition_function.
+func (agg *AggregateInfo) Advance(state Datum, args ...Datum) (Datum, error) {
+	if agg.TransFn.Strict {
+		for _, arg := range args {
+			if arg == nil {
+				return state, nil
+			}
+		}
+		if state == nil {
+			// the first argument is of the state type
+			return args[0], nil
+		}
+	}
+	return agg.TransFn.Call(append([]Datum{state}, args...)...)
+}
+
+// Returns the result of the last state of a group, as postgres'
+// finalize_aggregate.
+func (agg *AggregateInfo) Finalize(state Datum) (Datum, error) {
+	if agg.FinalFn == nil {
+		return state, nil
+	}
+	return agg.FinalFn.Call(state)
+}
+
+// Types min and max are defined for, as those of postgres' pg_aggregate.
+var minMaxTypes = []Oid{Int4Type, OidType, Float8Type, TextType, TidType,
+	DateType, TimeType, TimestampType, TimestampTzType, IntervalType}
+
+func registerAggregates() {
+	// count is of int4 as there is no int8, as postgres' int8inc
+	int4inc := func(args ...Datum) (Datum, error) {
+		count := args[0].(Int4)
+		if count == 1<<31-1 {
+			return nil, intOutOfRange()
+		}
+		return Datum(count + 1), nil
+	}
+	RegisterAggregate("count", nil, Int4Type,
+		RegisterProc("int4inc", []Oid{Int4Type}, Int4Type, int4inc), nil, Int4(0))
+	RegisterAggregate("count", []Oid{AnyType}, Int4Type,
+		RegisterProc("int4inc_any", []Oid{Int4Type, AnyType}, Int4Type, int4inc), nil, Int4(0))
+
+	for _, typid := range []Oid{Int4Type, Float8Type} {
+		plus, err := LookupOperator("+", typid, typid)
+		if err != nil {
+			panic(err)
+		}
+		RegisterAggregate("sum", []Oid{typid}, typid, plus.Proc, nil, nil)
+	}
+
+	// avg keeps the count and the sum of the values as a float8[], as
+	// postgres' float8_accum
+	accum := func(args ...Datum) (Datum, error) {
+		state := args[0].(Array)
+		var value Float8
+		switch arg := args[1].(type) {
+		case Int4:
+			value = Float8(arg)
+		case Float8:
+			value = arg
+		}
+		n, sum := state.Elems[0].(Float8), state.Elems[1].(Float8)
+		newSum, err := checkFloat8(float64(sum+value), isInf(sum) || isInf(value), true)
+		if err != nil {
+			return nil, err
+		}
+		return Datum(MakeArray(Float8Type, n+1, newSum)), nil
+	}
+	avg := RegisterProc("float8_avg", []Oid{Float8ArrayType}, Float8Type,
+		func(args ...Datum) (Datum, error) {
+			state := args[0].(Array)
+			n, sum := state.Elems[0].(Float8), state.Elems[1].(Float8)
+			if n == 0 {
+				return nil, nil
+			}
+			return Datum(sum / n), nil
+		})
+	RegisterAggregate("avg", []Oid{Int4Type}, Float8Type,
+		RegisterProc("int4_avg_accum", []Oid{Float8ArrayType, Int4Type}, Float8ArrayType, accum),
+		avg, MakeArray(Float8Type, Float8(0), Float8(0)))
+	RegisterAggregate("avg", []Oid{Float8Type}, Float8Type,
+		RegisterProc("float8_accum", []Oid{Float8ArrayType, Float8Type}, Float8ArrayType, accum),
+		avg, MakeArray(Float8Type, Float8(0), Float8(0)))
+
+	for _, typid := range minMaxTypes {
+		cmp, err := LookupCompare(typid, typid)
+		if err != nil {
+			panic(err)
+		}
+		smaller := RegisterProc(typeProcName(typid, typid, "smaller"), []Oid{typid, typid}, typid,
+			func(args ...Datum) (Datum, error) {
+				if cmp(args[0], args[1]) <= 0 {
+					return args[0], nil
+				}
+				return args[1], nil
+			})
+		larger := RegisterProc(typeProcName(typid, typid, "larger"), []Oid{typid, typid}, typid,
+			func(args ...Datum) (Datum, error) {
+				if cmp(args[0], args[1]) >= 0 {
+					return args[0], nil
+				}
+				return args[1], nil
+			})
+		RegisterAggregate("min", []Oid{typid}, typid, smaller, nil, nil)
+		RegisterAggregate("max", []Oid{typid}, typid, larger, nil, nil)
+	}
+}
@@ -0,0 +1,277 @@
+package system
+
+import (
+	"encoding/binary"
+	"io"
+	"strings"
+)
+
+// Array is a one-dimensional array of a base type, as postgres' ArrayType
+// without the dimensions.  NULL elements are not supported.
+//
+// It is stored as varlena: the length word, the element type, the number
+// of elements, and then each element as the element type stores it.
+type Array struct {
+	ElemType Oid
+	Elems    []Datum
+}
+
+const arrayHeaderSize = VarHdrSz + 8
+
+// Makes an array of the elements of the type.
+func MakeArray(elemType Oid, elems ...Datum) Array {
+	return Array{ElemType: elemType, Elems: elems}
+}
+
+func (val Array) ToString() string {
+	strs := make([]string, len(val.Elems))
+	for i, elem := range val.Elems {
+		strs[i] = quoteArrayElement(elem.ToString())
+	}
+	return "{" + strings.Join(strs, ",") + "}"
+}
+
+// Double-quotes the element if the array input would not read it back
+// as is, as postgres' array_out.
+func quoteArrayElement(str string) string {
+	if str != "" && !strings.EqualFold(str, "NULL") &&
+		!strings.ContainsAny(str, "{},\"\\ \t\n\r\f\v") {
+		return str
+	}
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, r := range str {
+		if r == '"' || r == '\\' {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// Reads the "{elem,elem,...}" form, as postgres' array_in.
+func (val Array) FromString(str string) (Datum, error) {
+	malformed := func() (Datum, error) {
+		return nil, Ereport(InvalidTextRepresentation, "malformed array literal: \"%s\"", str)
+	}
+	elemType := TypeRegistry[val.ElemType]
+	s := strings.TrimSpace(str)
+	if len(s) < 2 || s[0] != '{' || s[len(s)-1] != '}' {
+		return malformed()
+	}
+	s = s[1 : len(s)-1]
+
+	result := Array{ElemType: val.ElemType}
+	if strings.TrimSpace(s) == "" {
+		return Datum(result), nil
+	}
+	for pos := 0; ; {
+		for pos < len(s) && isArraySpace(s[pos]) {
+			pos++
+		}
+		var b strings.Builder
+		quoted := false
+		if pos < len(s) && s[pos] == '"' {
+			quoted = true
+			pos++
+			for ; pos < len(s) && s[pos] != '"'; pos++ {
+				if s[pos] == '\\' && pos+1 < len(s) {
+					pos++
+				}
+				b.WriteByte(s[pos])
+			}
+			if pos >= len(s) {
+				return malformed()
+			}
+			pos++
+			for pos < len(s) && isArraySpace(s[pos]) {
+				pos++
+			}
+		} else {
+			for ; pos < len(s) && s[pos] != ','; pos++ {
+				if s[pos] == '{' || s[pos] == '}' || s[pos] == '"' {
+					return malformed()
+				}
+				if s[pos] == '\\' && pos+1 < len(s) {
+					pos++
+				}
+				b.WriteByte(s[pos])
+			}
+		}
+		elemStr := b.String()
+		if !quoted {
+			elemStr = strings.TrimSpace(elemStr)
+			if elemStr == "" {
+				return malformed()
+			}
+			if strings.EqualFold(elemStr, "NULL") {
+				return nil, Ereport(FeatureNotSupported, "null array elements are not supported")
+			}
+		}
+		elem, err := elemType.Zero.FromString(elemStr)
+		if err != nil {
+			return nil, err
+		}
+		result.Elems = append(result.Elems, elem)
+
+		if pos >= len(s) {
+			break
+		} else if s[pos] != ',' {
+			return malformed()
+		}
+		pos++
+	}
+	return Datum(result), nil
+}
+
+func isArraySpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f' || c == '\v'
+}
+
+func (val Array) ToBytes(writer io.Writer) (int, error) {
+	header := make([]byte, arrayHeaderSize)
+	binary.LittleEndian.PutUint32(header[0:], uint32(val.Len()))
+	binary.LittleEndian.PutUint32(header[4:], uint32(val.ElemType))
+	binary.LittleEndian.PutUint32(header[8:], uint32(len(val.Elems)))
+	total, err := writer.Write(header)
+	if err != nil {
+		return total, err
+	}
+	for _, elem := range val.Elems {
+		n, err := elem.ToBytes(writer)
+		total += n
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+func (val Array) FromBytes(reader io.Reader) Datum {
+	header := make([]byte, arrayHeaderSize)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		panic("read error")
+	}
+	result := Array{ElemType: Oid(binary.LittleEndian.Uint32(header[4:]))}
+	nelems := int(binary.LittleEndian.Uint32(header[8:]))
+	if nelems > 0 {
+		result.Elems = make([]Datum, nelems)
+	}
+	for i := range result.Elems {
+		result.Elems[i] = DatumFromBytes(reader, result.ElemType)
+	}
+	return Datum(result)
+}
+
+func (val Array) Equals(other Datum) bool {
+	oval, ok := other.(Array)
+	if !ok || oval.ElemType != val.ElemType || len(oval.Elems) != len(val.Elems) {
+		return false
+	}
+	for i := range val.Elems {
+		if !val.Elems[i].Equals(oval.Elems[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func (val Array) Len() int {
+	length := arrayHeaderSize
+	for _, elem := range val.Elems {
+		length += elem.Len()
+	}
+	return length
+}
+
+// Compares the elements in turn, and then the lengths, as postgres'
+// btarraycmp.
+func compareArray(a, b Datum) int {
+	av, bv := a.(Array), b.(Array)
+	cmp, err := LookupCompare(av.ElemType, bv.ElemType)
+	if err != nil {
+		panic(err)
+	}
+	for i := 0; i < len(av.Elems) && i < len(bv.Elems); i++ {
+		if c := cmp(av.Elems[i], bv.Elems[i]); c != 0 {
+			return c
+		}
+	}
+	return compareInt64(int64(len(av.Elems)), int64(len(bv.Elems)))
+}
+
+func hashArray(val Datum) uint32 {
+	arr := val.(Array)
+	hash, err := LookupHash(arr.ElemType)
+	if err != nil {
+		panic(err)
+	}
+	result := uint32(0)
+	for _, elem := range arr.Elems {
+		result = result*31 + hash(elem)
+	}
+	return result
+}
+
+// Returns true if every element of b is in a, as postgres'
+// arraycontains.
+func arrayContains(a, b Array) bool {
+	cmp, err := LookupCompare(a.ElemType, b.ElemType)
+	if err != nil {
+		panic(err)
+	}
+	for _, belem := range b.Elems {
+		found := false
+		for _, aelem := range a.Elems {
+			if cmp(aelem, belem) == 0 {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns true if a and b have an element in common, as postgres'
+// arrayoverlap.
+func arrayOverlap(a, b Array) bool {
+	cmp, err := LookupCompare(a.ElemType, b.ElemType)
+	if err != nil {
+		panic(err)
+	}
+	for _, belem := range b.Elems {
+		for _, aelem := range a.Elems {
+			if cmp(aelem, belem) == 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func registerArrays() {
+	for _, typid := range []Oid{Int4ArrayType, TextArrayType, OidArrayType, Float8ArrayType} {
+		RegisterCompare(typid, typid, compareArray)
+		RegisterHash(typid, hashArray)
+
+		contains := RegisterOperator("@>", "arraycontains", typid, typid, BoolType,
+			func(args ...Datum) (Datum, error) {
+				return Datum(Bool(arrayContains(args[0].(Array), args[1].(Array)))), nil
+			})
+		contains.Commutator = "<@"
+		contained := RegisterOperator("<@", "arraycontained", typid, typid, BoolType,
+			func(args ...Datum) (Datum, error) {
+				return Datum(Bool(arrayContains(args[1].(Array), args[0].(Array)))), nil
+			})
+		contained.Commutator = "@>"
+		overlap := RegisterOperator("&&", "arrayoverlap", typid, typid, BoolType,
+			func(args ...Datum) (Datum, error) {
+				return Datum(Bool(arrayOverlap(args[0].(Array), args[1].(Array)))), nil
+			})
+		overlap.Commutator = "&&"
+	}
+}
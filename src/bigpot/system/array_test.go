@@ -0,0 +1,68 @@
+package system
+
+import (
+	"bytes"
+	. "launchpad.net/gocheck"
+)
+
+func (s *MySuite) TestArrayInOut(c *C) {
+	arr, err := DatumFromString(`{1, 2,3 }`, Int4ArrayType)
+	c.Assert(err, IsNil)
+	c.Check(arr, DeepEquals, MakeArray(Int4Type, Int4(1), Int4(2), Int4(3)))
+	c.Check(arr.ToString(), Equals, "{1,2,3}")
+
+	arr, err = DatumFromString(`{abc,"a b","q\"uote",""}`, TextArrayType)
+	c.Assert(err, IsNil)
+	c.Check(arr, DeepEquals, MakeArray(TextType, Text("abc"), Text("a b"), Text(`q"uote`), Text("")))
+	c.Check(arr.ToString(), Equals, `{abc,"a b","q\"uote",""}`)
+
+	arr, err = DatumFromString("{}", Int4ArrayType)
+	c.Assert(err, IsNil)
+	c.Check(arr.ToString(), Equals, "{}")
+
+	_, err = DatumFromString("{1,2", Int4ArrayType)
+	c.Check(err, ErrorMatches, "malformed array literal: \"{1,2\"")
+
+	var buf bytes.Buffer
+	arr = MakeArray(TextType, Text("x"), Text("yz"))
+	n, err := arr.ToBytes(&buf)
+	c.Assert(err, IsNil)
+	c.Check(n, Equals, arr.Len())
+	c.Check(DatumFromBytes(&buf, TextArrayType), DeepEquals, arr)
+}
+
+func (s *MySuite) TestArrayOperators(c *C) {
+	a := MakeArray(Int4Type, Int4(1), Int4(2), Int4(3))
+	b := MakeArray(Int4Type, Int4(3), Int4(1), Int4(1))
+	call := func(name string, left, right Datum) Datum {
+		opr, err := LookupOperator(name, Int4ArrayType, Int4ArrayType)
+		c.Assert(err, IsNil)
+		res, err := opr.Proc.Call(left, right)
+		c.Assert(err, IsNil)
+		return res
+	}
+	c.Check(call("@>", a, b), Equals, Bool(true))
+	c.Check(call("<@", a, b), Equals, Bool(false))
+	c.Check(call("&&", b, MakeArray(Int4Type, Int4(4), Int4(3))), Equals, Bool(true))
+	c.Check(call("&&", a, MakeArray(Int4Type)), Equals, Bool(false))
+	c.Check(call("@>", a, MakeArray(Int4Type)), Equals, Bool(true))
+
+	cmp, err := LookupCompare(Int4ArrayType, Int4ArrayType)
+	c.Assert(err, IsNil)
+	c.Check(cmp(a, MakeArray(Int4Type, Int4(1), Int4(3))) < 0, Equals, true)
+	c.Check(cmp(a, MakeArray(Int4Type, Int4(1), Int4(2))) > 0, Equals, true)
+}
+
+func (s *MySuite) TestTextSearch(c *C) {
+	c.Check(TextLexemes(Text("The cat, the HAT and 42 cats")), DeepEquals,
+		[]Text{"42", "and", "cat", "cats", "hat", "the"})
+
+	opr, err := LookupOperator("@@", TextType, TextType)
+	c.Assert(err, IsNil)
+	res, _ := opr.Proc.Call(Text("The cat sat"), Text("CAT the"))
+	c.Check(res, Equals, Bool(true))
+	res, _ = opr.Proc.Call(Text("The cat sat"), Text("cat dog"))
+	c.Check(res, Equals, Bool(false))
+	res, _ = opr.Proc.Call(Text("The cat sat"), Text(""))
+	c.Check(res, Equals, Bool(false))
+}
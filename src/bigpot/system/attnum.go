@@ -2,6 +2,8 @@ package system
 
 type AttrNumber int16
 
+const InvalidAttrNumber AttrNumber = 0
+
 const (
 	CtidAttrNumber     = -1
 	OidAttrNumber      = -2
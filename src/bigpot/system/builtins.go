@@ -0,0 +1,353 @@
+package system
+
+import (
+	"math"
+)
+
+// Registers the built-in comparison and hash support functions, operators
+// and functions.  This is our counterpart of the bootstrap contents of
+// pg_amproc, pg_operator and pg_proc.
+func init() {
+	registerComparisons()
+	registerHashes()
+	registerArithmetic()
+	registerCasts()
+	registerDateTimeOperators()
+	registerDateTimeFunctions()
+	registerArrays()
+	registerTextSearch()
+	registerLike()
+	registerAggregates()
+}
+
+func registerComparisons() {
+	RegisterCompare(BoolType, BoolType, compareBool)
+	RegisterCompare(CharType, CharType, compareChar)
+	RegisterCompare(Int4Type, Int4Type, compareInt4)
+	RegisterCompare(OidType, OidType, compareOid)
+	RegisterCompare(Float8Type, Float8Type, compareFloat8)
+	RegisterCompare(NameType, NameType, compareName)
+	RegisterCompare(TextType, TextType, compareText)
+	RegisterCompare(TidType, TidType, compareTid)
+	RegisterCompare(DateType, DateType, compareDate)
+	RegisterCompare(TimeType, TimeType, compareTime)
+	RegisterCompare(TimestampType, TimestampType, compareTimestamp)
+	RegisterCompare(TimestampTzType, TimestampTzType, compareTimestampTz)
+	RegisterCompare(IntervalType, IntervalType, compareInterval)
+
+	RegisterCompare(DateType, TimestampType, compareDateTimestamp)
+	RegisterCompare(TimestampType, DateType, compareTimestampDate)
+}
+
+func registerHashes() {
+	for _, typid := range []Oid{BoolType, CharType, Int4Type, OidType, TidType,
+		DateType, TimeType, TimestampType, TimestampTzType} {
+		RegisterHash(typid, hashFixed)
+	}
+	RegisterHash(Float8Type, hashFloat8)
+	RegisterHash(NameType, hashName)
+	RegisterHash(TextType, hashText)
+	RegisterHash(IntervalType, hashInterval)
+}
+
+func intOutOfRange() error {
+	return Ereport(NumericValueOutOfRange, "integer out of range")
+}
+
+func divisionByZero() error {
+	return Ereport(DivisionByZero, "division by zero")
+}
+
+// Checks that a float8 result didn't overflow or underflow, where the
+// inputs were finite or nonzero respectively.
+func checkFloat8(val float64, infAllowed, zeroAllowed bool) (Datum, error) {
+	if math.IsInf(val, 0) && !infAllowed {
+		return nil, Ereport(NumericValueOutOfRange, "value out of range: overflow")
+	}
+	if val == 0 && !zeroAllowed {
+		return nil, Ereport(NumericValueOutOfRange, "value out of range: underflow")
+	}
+	return Datum(Float8(val)), nil
+}
+
+func isInf(val Datum) bool {
+	return math.IsInf(float64(val.(Float8)), 0)
+}
+
+func registerArithmetic() {
+	int4 := func(v int64) (Datum, error) {
+		if v != int64(int32(v)) {
+			return nil, intOutOfRange()
+		}
+		return Datum(Int4(v)), nil
+	}
+	RegisterOperator("+", "int4pl", Int4Type, Int4Type, Int4Type,
+		func(args ...Datum) (Datum, error) {
+			return int4(int64(args[0].(Int4)) + int64(args[1].(Int4)))
+		})
+	RegisterOperator("-", "int4mi", Int4Type, Int4Type, Int4Type,
+		func(args ...Datum) (Datum, error) {
+			return int4(int64(args[0].(Int4)) - int64(args[1].(Int4)))
+		})
+	RegisterOperator("*", "int4mul", Int4Type, Int4Type, Int4Type,
+		func(args ...Datum) (Datum, error) {
+			return int4(int64(args[0].(Int4)) * int64(args[1].(Int4)))
+		})
+	RegisterOperator("/", "int4div", Int4Type, Int4Type, Int4Type,
+		func(args ...Datum) (Datum, error) {
+			if args[1].(Int4) == 0 {
+				return nil, divisionByZero()
+			}
+			return int4(int64(args[0].(Int4)) / int64(args[1].(Int4)))
+		})
+	RegisterOperator("%", "int4mod", Int4Type, Int4Type, Int4Type,
+		func(args ...Datum) (Datum, error) {
+			if args[1].(Int4) == 0 {
+				return nil, divisionByZero()
+			}
+			return int4(int64(args[0].(Int4)) % int64(args[1].(Int4)))
+		})
+	RegisterOperator("-", "int4um", InvalidOid, Int4Type, Int4Type,
+		func(args ...Datum) (Datum, error) {
+			return int4(-int64(args[0].(Int4)))
+		})
+	RegisterProc("abs", []Oid{Int4Type}, Int4Type,
+		func(args ...Datum) (Datum, error) {
+			v := int64(args[0].(Int4))
+			if v < 0 {
+				v = -v
+			}
+			return int4(v)
+		})
+
+	RegisterOperator("+", "float8pl", Float8Type, Float8Type, Float8Type,
+		func(args ...Datum) (Datum, error) {
+			a, b := args[0].(Float8), args[1].(Float8)
+			return checkFloat8(float64(a+b), isInf(a) || isInf(b), true)
+		})
+	RegisterOperator("-", "float8mi", Float8Type, Float8Type, Float8Type,
+		func(args ...Datum) (Datum, error) {
+			a, b := args[0].(Float8), args[1].(Float8)
+			return checkFloat8(float64(a-b), isInf(a) || isInf(b), true)
+		})
+	RegisterOperator("*", "float8mul", Float8Type, Float8Type, Float8Type,
+		func(args ...Datum) (Datum, error) {
+			a, b := args[0].(Float8), args[1].(Float8)
+			return checkFloat8(float64(a*b), isInf(a) || isInf(b), a == 0 || b == 0)
+		})
+	RegisterOperator("/", "float8div", Float8Type, Float8Type, Float8Type,
+		func(args ...Datum) (Datum, error) {
+			a, b := args[0].(Float8), args[1].(Float8)
+			if b == 0 {
+				return nil, divisionByZero()
+			}
+			return checkFloat8(float64(a/b), isInf(a), a == 0 || isInf(b))
+		})
+	RegisterOperator("-", "float8um", InvalidOid, Float8Type, Float8Type,
+		func(args ...Datum) (Datum, error) {
+			return Datum(-args[0].(Float8)), nil
+		})
+	RegisterProc("abs", []Oid{Float8Type}, Float8Type,
+		func(args ...Datum) (Datum, error) {
+			return Datum(Float8(math.Abs(float64(args[0].(Float8))))), nil
+		})
+
+	RegisterOperator("||", "textcat", TextType, TextType, TextType,
+		func(args ...Datum) (Datum, error) {
+			return Datum(args[0].(Text) + args[1].(Text)), nil
+		})
+}
+
+// Registers the functions of the casts that are not made by the output
+// and input functions of the types, as the pg_cast entries with a
+// castfunc.  Each is named after its result type, as postgres' int4(float8).
+func registerCasts() {
+	RegisterProc("float8", []Oid{Int4Type}, Float8Type,
+		func(args ...Datum) (Datum, error) {
+			return Datum(Float8(args[0].(Int4))), nil
+		})
+	// rounds half away from zero
+	RegisterProc("int4", []Oid{Float8Type}, Int4Type,
+		func(args ...Datum) (Datum, error) {
+			v := math.Round(float64(args[0].(Float8)))
+			if math.IsNaN(v) || v < math.MinInt32 || v > math.MaxInt32 {
+				return nil, intOutOfRange()
+			}
+			return Datum(Int4(v)), nil
+		})
+	RegisterProc("bool", []Oid{Int4Type}, BoolType,
+		func(args ...Datum) (Datum, error) {
+			return Datum(Bool(args[0].(Int4) != 0)), nil
+		})
+	RegisterProc("int4", []Oid{BoolType}, Int4Type,
+		func(args ...Datum) (Datum, error) {
+			if args[0].(Bool) {
+				return Datum(Int4(1)), nil
+			}
+			return Datum(Int4(0)), nil
+		})
+
+	// the casts between timestamptz and the types without a zone read the
+	// wall clock in the time zone of the session
+	RegisterZonedProc("timestamptz", []Oid{TimestampType}, TimestampTzType,
+		func(tz *TimeZone, args ...Datum) (Datum, error) {
+			return datumOf(args[0].(Timestamp).ToTimestampTz(tz))
+		})
+	RegisterZonedProc("timestamptz", []Oid{DateType}, TimestampTzType,
+		func(tz *TimeZone, args ...Datum) (Datum, error) {
+			ts, err := args[0].(Date).ToTimestamp()
+			if err != nil {
+				return nil, err
+			}
+			return datumOf(ts.ToTimestampTz(tz))
+		})
+	RegisterZonedProc("timestamp", []Oid{TimestampTzType}, TimestampType,
+		func(tz *TimeZone, args ...Datum) (Datum, error) {
+			return datumOf(args[0].(TimestampTz).ToTimestamp(tz))
+		})
+}
+
+// Wraps a (value, error) pair returned by the date/time arithmetic.
+func datumOf(val Datum, err error) (Datum, error) {
+	if err != nil {
+		return nil, err
+	}
+	return val, nil
+}
+
+func registerDateTimeOperators() {
+	RegisterOperator("+", "date_pli", DateType, Int4Type, DateType,
+		func(args ...Datum) (Datum, error) {
+			return datumOf(args[0].(Date).PlusDays(args[1].(Int4)))
+		})
+	RegisterOperator("+", "integer_pl_date", Int4Type, DateType, DateType,
+		func(args ...Datum) (Datum, error) {
+			return datumOf(args[1].(Date).PlusDays(args[0].(Int4)))
+		})
+	RegisterOperator("-", "date_mii", DateType, Int4Type, DateType,
+		func(args ...Datum) (Datum, error) {
+			return datumOf(args[0].(Date).MinusDays(args[1].(Int4)))
+		})
+	RegisterOperator("-", "date_mi", DateType, DateType, Int4Type,
+		func(args ...Datum) (Datum, error) {
+			return datumOf(args[0].(Date).Minus(args[1].(Date)))
+		})
+	RegisterOperator("+", "date_pl_interval", DateType, IntervalType, TimestampType,
+		func(args ...Datum) (Datum, error) {
+			return datumOf(args[0].(Date).PlusInterval(args[1].(Interval)))
+		})
+	RegisterOperator("-", "date_mi_interval", DateType, IntervalType, TimestampType,
+		func(args ...Datum) (Datum, error) {
+			return datumOf(args[0].(Date).MinusInterval(args[1].(Interval)))
+		})
+
+	RegisterOperator("+", "time_pl_interval", TimeType, IntervalType, TimeType,
+		func(args ...Datum) (Datum, error) {
+			return Datum(args[0].(Time).PlusInterval(args[1].(Interval))), nil
+		})
+	RegisterOperator("-", "time_mi_interval", TimeType, IntervalType, TimeType,
+		func(args ...Datum) (Datum, error) {
+			return Datum(args[0].(Time).MinusInterval(args[1].(Interval))), nil
+		})
+	RegisterOperator("-", "time_mi_time", TimeType, TimeType, IntervalType,
+		func(args ...Datum) (Datum, error) {
+			return Datum(args[0].(Time).Minus(args[1].(Time))), nil
+		})
+
+	RegisterOperator("+", "timestamp_pl_interval", TimestampType, IntervalType, TimestampType,
+		func(args ...Datum) (Datum, error) {
+			return datumOf(args[0].(Timestamp).PlusInterval(args[1].(Interval)))
+		})
+	RegisterOperator("+", "interval_pl_timestamp", IntervalType, TimestampType, TimestampType,
+		func(args ...Datum) (Datum, error) {
+			return datumOf(args[1].(Timestamp).PlusInterval(args[0].(Interval)))
+		})
+	RegisterOperator("-", "timestamp_mi_interval", TimestampType, IntervalType, TimestampType,
+		func(args ...Datum) (Datum, error) {
+			return datumOf(args[0].(Timestamp).MinusInterval(args[1].(Interval)))
+		})
+	RegisterOperator("-", "timestamp_mi", TimestampType, TimestampType, IntervalType,
+		func(args ...Datum) (Datum, error) {
+			return datumOf(args[0].(Timestamp).Minus(args[1].(Timestamp)))
+		})
+
+	RegisterZonedOperator("+", "timestamptz_pl_interval", TimestampTzType, IntervalType, TimestampTzType,
+		func(tz *TimeZone, args ...Datum) (Datum, error) {
+			return datumOf(args[0].(TimestampTz).PlusInterval(args[1].(Interval), tz))
+		})
+	RegisterZonedOperator("+", "interval_pl_timestamptz", IntervalType, TimestampTzType, TimestampTzType,
+		func(tz *TimeZone, args ...Datum) (Datum, error) {
+			return datumOf(args[1].(TimestampTz).PlusInterval(args[0].(Interval), tz))
+		})
+	RegisterZonedOperator("-", "timestamptz_mi_interval", TimestampTzType, IntervalType, TimestampTzType,
+		func(tz *TimeZone, args ...Datum) (Datum, error) {
+			return datumOf(args[0].(TimestampTz).MinusInterval(args[1].(Interval), tz))
+		})
+	RegisterOperator("-", "timestamptz_mi", TimestampTzType, TimestampTzType, IntervalType,
+		func(args ...Datum) (Datum, error) {
+			return datumOf(args[0].(TimestampTz).Minus(args[1].(TimestampTz)))
+		})
+
+	RegisterOperator("+", "interval_pl", IntervalType, IntervalType, IntervalType,
+		func(args ...Datum) (Datum, error) {
+			return datumOf(args[0].(Interval).Plus(args[1].(Interval)))
+		})
+	RegisterOperator("-", "interval_mi", IntervalType, IntervalType, IntervalType,
+		func(args ...Datum) (Datum, error) {
+			return datumOf(args[0].(Interval).Minus(args[1].(Interval)))
+		})
+	RegisterOperator("-", "interval_um", InvalidOid, IntervalType, IntervalType,
+		func(args ...Datum) (Datum, error) {
+			return Datum(args[0].(Interval).Negate()), nil
+		})
+	RegisterOperator("*", "interval_mul", IntervalType, Float8Type, IntervalType,
+		func(args ...Datum) (Datum, error) {
+			return datumOf(args[0].(Interval).Mul(float64(args[1].(Float8))))
+		})
+	RegisterOperator("*", "mul_d_interval", Float8Type, IntervalType, IntervalType,
+		func(args ...Datum) (Datum, error) {
+			return datumOf(args[1].(Interval).Mul(float64(args[0].(Float8))))
+		})
+	RegisterOperator("/", "interval_div", IntervalType, Float8Type, IntervalType,
+		func(args ...Datum) (Datum, error) {
+			if args[1].(Float8) == 0 {
+				return nil, divisionByZero()
+			}
+			return datumOf(args[0].(Interval).Mul(1 / float64(args[1].(Float8))))
+		})
+}
+
+func registerDateTimeFunctions() {
+	RegisterProc("date_trunc", []Oid{TextType, TimestampType}, TimestampType,
+		func(args ...Datum) (Datum, error) {
+			return datumOf(args[1].(Timestamp).Trunc(string(args[0].(Text))))
+		})
+	RegisterZonedProc("date_trunc", []Oid{TextType, TimestampTzType}, TimestampTzType,
+		func(tz *TimeZone, args ...Datum) (Datum, error) {
+			return datumOf(args[1].(TimestampTz).Trunc(string(args[0].(Text)), tz))
+		})
+
+	// extract(field from source) is date_part(field, source).
+	type extractor interface {
+		Extract(field string) (Datum, error)
+	}
+	for _, typid := range []Oid{DateType, TimeType, TimestampType, IntervalType} {
+		RegisterProc("date_part", []Oid{TextType, typid}, Float8Type,
+			func(args ...Datum) (Datum, error) {
+				return datumOf(args[1].(extractor).Extract(string(args[0].(Text))))
+			})
+	}
+	RegisterZonedProc("date_part", []Oid{TextType, TimestampTzType}, Float8Type,
+		func(tz *TimeZone, args ...Datum) (Datum, error) {
+			return datumOf(args[1].(TimestampTz).Extract(string(args[0].(Text)), tz))
+		})
+
+	RegisterProc("justify_hours", []Oid{IntervalType}, IntervalType,
+		func(args ...Datum) (Datum, error) {
+			return Datum(args[0].(Interval).JustifyHours()), nil
+		})
+	RegisterProc("justify_days", []Oid{IntervalType}, IntervalType,
+		func(args ...Datum) (Datum, error) {
+			return Datum(args[0].(Interval).JustifyDays()), nil
+		})
+}
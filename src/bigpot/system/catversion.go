@@ -0,0 +1,6 @@
+package system
+
+// CatalogVersion identifies the layout of the system catalogs, as postgres'
+// CATALOG_VERSION_NO.  It is bumped to the date, as yyyymmddN, whenever a
+// change to the catalogs makes older data directories unreadable.
+const CatalogVersion = 202610182
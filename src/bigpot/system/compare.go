@@ -0,0 +1,250 @@
+package system
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/fnv"
+	"math"
+	"strings"
+)
+
+// CompareFunc is the btree support function of a type pair, as postgres'
+// BTORDER_PROC.  It returns a negative number, zero or a positive number
+// when a is less than, equal to or greater than b.  Neither argument may
+// be NULL.
+type CompareFunc func(a, b Datum) int
+
+// HashFunc is the hash support function of a type, as postgres'
+// HASHSTANDARD_PROC.  Values that compare equal must hash equal.
+type HashFunc func(val Datum) uint32
+
+type typePair struct {
+	left, right Oid
+}
+
+var compareProcs = map[typePair]CompareFunc{}
+var hashProcs = map[Oid]HashFunc{}
+
+// Registers the btree comparison function for the given types, and the
+// six comparison operators (<, <=, =, <>, >=, >) built on it.
+func RegisterCompare(left, right Oid, fn CompareFunc) {
+	compareProcs[typePair{left, right}] = fn
+
+	type cmpOp struct {
+		name, proc, commutator, negator string
+		test                            func(int) bool
+	}
+	ops := []cmpOp{
+		{"<", "lt", ">", ">=", func(c int) bool { return c < 0 }},
+		{"<=", "le", ">=", ">", func(c int) bool { return c <= 0 }},
+		{"=", "eq", "=", "<>", func(c int) bool { return c == 0 }},
+		{"<>", "ne", "<>", "=", func(c int) bool { return c != 0 }},
+		{">=", "ge", "<=", "<", func(c int) bool { return c >= 0 }},
+		{">", "gt", "<", "<=", func(c int) bool { return c > 0 }},
+	}
+	for _, op := range ops {
+		test := op.test
+		opr := RegisterOperator(op.name, typeProcName(left, right, op.proc), left, right, BoolType,
+			func(args ...Datum) (Datum, error) {
+				return Datum(Bool(test(fn(args[0], args[1])))), nil
+			})
+		opr.Commutator = op.commutator
+		opr.Negator = op.negator
+	}
+}
+
+// Returns the btree comparison function for the given types.
+func LookupCompare(left, right Oid) (CompareFunc, error) {
+	if fn, ok := compareProcs[typePair{left, right}]; ok {
+		return fn, nil
+	}
+	return nil, Ereport(UndefinedFunction,
+		"could not identify a comparison function for types %s and %s",
+		FormatType(left), FormatType(right))
+}
+
+// Registers the hash support function of the type.
+func RegisterHash(typid Oid, fn HashFunc) {
+	hashProcs[typid] = fn
+}
+
+// Returns the hash support function of the type.
+func LookupHash(typid Oid) (HashFunc, error) {
+	if fn, ok := hashProcs[typid]; ok {
+		return fn, nil
+	}
+	return nil, Ereport(UndefinedFunction,
+		"could not identify a hash function for type %s", FormatType(typid))
+}
+
+// Returns the name of the type for messages, as postgres' format_type_be.
+func FormatType(typid Oid) string {
+	if typid == UnknownType {
+		return "unknown"
+	} else if typid == AnyType {
+		return "\"any\""
+	}
+	if entry, ok := TypeRegistry[typid]; ok {
+		return string(entry.Name)
+	}
+	return typid.ToString()
+}
+
+// Procedures are named after their types, as postgres' int4lt or
+// date_lt_timestamp.
+func typeProcName(left, right Oid, suffix string) string {
+	if left == right {
+		return FormatType(left) + suffix
+	}
+	return FormatType(left) + "_" + suffix + "_" + FormatType(right)
+}
+
+func compareInt64(a, b int64) int {
+	if a < b {
+		return -1
+	} else if a > b {
+		return 1
+	}
+	return 0
+}
+
+func compareBool(a, b Datum) int {
+	av, bv := a.(Bool), b.(Bool)
+	if av == bv {
+		return 0
+	} else if !av {
+		return -1
+	}
+	return 1
+}
+
+func compareChar(a, b Datum) int {
+	return compareInt64(int64(a.(Char)), int64(b.(Char)))
+}
+
+func compareInt4(a, b Datum) int {
+	return compareInt64(int64(a.(Int4)), int64(b.(Int4)))
+}
+
+func compareOid(a, b Datum) int {
+	return compareInt64(int64(a.(Oid)), int64(b.(Oid)))
+}
+
+// Float8 orders NaN above every other value, including infinity, and
+// treats NaN as equal to itself so that it can be sorted and indexed.
+func compareFloat8(a, b Datum) int {
+	av, bv := float64(a.(Float8)), float64(b.(Float8))
+	if math.IsNaN(av) {
+		if math.IsNaN(bv) {
+			return 0
+		}
+		return 1
+	} else if math.IsNaN(bv) {
+		return -1
+	}
+	if av < bv {
+		return -1
+	} else if av > bv {
+		return 1
+	}
+	return 0
+}
+
+func compareName(a, b Datum) int {
+	return strings.Compare(string(a.(Name)), string(b.(Name)))
+}
+
+// Text is compared byte-wise, which is the "C" collation.
+func compareText(a, b Datum) int {
+	return strings.Compare(string(a.(Text)), string(b.(Text)))
+}
+
+func compareTid(a, b Datum) int {
+	av, bv := a.(ItemPointer), b.(ItemPointer)
+	if c := compareInt64(int64(av.block), int64(bv.block)); c != 0 {
+		return c
+	}
+	return compareInt64(int64(av.offset), int64(bv.offset))
+}
+
+func compareDate(a, b Datum) int {
+	return compareInt64(int64(a.(Date)), int64(b.(Date)))
+}
+
+func compareTime(a, b Datum) int {
+	return compareInt64(int64(a.(Time)), int64(b.(Time)))
+}
+
+func compareTimestamp(a, b Datum) int {
+	return compareInt64(int64(a.(Timestamp)), int64(b.(Timestamp)))
+}
+
+func compareTimestampTz(a, b Datum) int {
+	return compareInt64(int64(a.(TimestampTz)), int64(b.(TimestampTz)))
+}
+
+func compareInterval(a, b Datum) int {
+	days1, time1 := a.(Interval).cmpValue()
+	days2, time2 := b.(Interval).cmpValue()
+	if c := compareInt64(days1, days2); c != 0 {
+		return c
+	}
+	return compareInt64(time1, time2)
+}
+
+// Cross-type comparisons between the date/time types, a date being the
+// timestamp at its midnight.  There are none with timestamptz, which
+// depend on the time zone of the session; the date or timestamp is cast
+// to timestamptz by the function of the cast instead.
+func compareDateTimestamp(a, b Datum) int {
+	ts, _ := a.(Date).ToTimestamp()
+	return compareTimestamp(ts, b)
+}
+
+func compareTimestampDate(a, b Datum) int {
+	return -compareDateTimestamp(b, a)
+}
+
+// Hashes the binary representation of a fixed-length value.
+func hashBytes(b []byte) uint32 {
+	h := fnv.New32a()
+	h.Write(b)
+	return h.Sum32()
+}
+
+func hashFixed(val Datum) uint32 {
+	var buf bytes.Buffer
+	val.ToBytes(&buf)
+	return hashBytes(buf.Bytes())
+}
+
+func hashName(val Datum) uint32 {
+	return hashBytes([]byte(val.(Name)))
+}
+
+func hashText(val Datum) uint32 {
+	return hashBytes([]byte(val.(Text)))
+}
+
+// -0 and +0 are equal, and so are all the NaNs, so they must hash alike.
+func hashFloat8(val Datum) uint32 {
+	f := float64(val.(Float8))
+	if f == 0 {
+		f = 0
+	} else if math.IsNaN(f) {
+		f = math.NaN()
+	}
+	b := make([]byte, 8)
+	binary.LittleEndian.PutUint64(b, math.Float64bits(f))
+	return hashBytes(b)
+}
+
+// Intervals that compare equal may have different fields, so hash the
+// normalized span.
+func hashInterval(val Datum) uint32 {
+	days, usecs := val.(Interval).cmpValue()
+	b := make([]byte, 16)
+	binary.LittleEndian.PutUint64(b[0:], uint64(days))
+	binary.LittleEndian.PutUint64(b[8:], uint64(usecs))
+	return hashBytes(b)
+}
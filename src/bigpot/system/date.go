@@ -0,0 +1,248 @@
+package system
+
+import (
+	"encoding/binary"
+	"io"
+	"math"
+)
+
+// Date is the number of days since 2000-01-01.
+type Date int32
+
+// Time is the time of day in microseconds, without time zone.
+type Time int64
+
+const DateNoBegin = Date(math.MinInt32)
+const DateNoEnd = Date(math.MaxInt32)
+
+// 1970-01-01 in Date.
+const dateUnixEpoch = Date(-10957)
+
+func (val Date) IsFinite() bool {
+	return val != DateNoBegin && val != DateNoEnd
+}
+
+func (val Date) ToString() string {
+	switch val {
+	case DateNoBegin:
+		return "-infinity"
+	case DateNoEnd:
+		return "infinity"
+	}
+	fields := fieldsFromPostgresUsecs(int64(val) * usecsPerDay)
+	return fields.encodeDate() + fields.encodeEra()
+}
+
+func (val Date) FromString(str string) (Datum, error) {
+	switch specialDateTime(str) {
+	case dtEpoch:
+		return Datum(dateUnixEpoch), nil
+	case dtLateStart:
+		return Datum(DateNoEnd), nil
+	case dtEarlyEnd:
+		return Datum(DateNoBegin), nil
+	}
+
+	fields, err := parseDateTime(str)
+	if err != nil {
+		return nil, err
+	}
+	if !fields.hasDate {
+		return nil, Ereport(InvalidDatetimeFormat,
+			"invalid input syntax for type date: \"%s\"", str)
+	}
+	days := daysFromCivil(fields.year, fields.month, fields.day)
+	if !isValidTimestamp(days * usecsPerDay) {
+		return nil, Ereport(DatetimeFieldOverflow, "date out of range: \"%s\"", str)
+	}
+	return Datum(Date(days)), nil
+}
+
+func (val Date) ToBytes(writer io.Writer) (int, error) {
+	err := binary.Write(writer, binary.LittleEndian, val)
+	return val.Len(), err
+}
+
+func (val Date) FromBytes(reader io.Reader) Datum {
+	var newval Date
+	if err := binary.Read(reader, binary.LittleEndian, &newval); err != nil {
+		panic("read error")
+	}
+	return Datum(newval)
+}
+
+func (val Date) Equals(other Datum) bool {
+	if oval, ok := other.(Date); ok {
+		return val == oval
+	}
+	return false
+}
+
+func (val Date) Len() int {
+	return 4
+}
+
+// Converts the date to a timestamp at midnight.
+func (val Date) ToTimestamp() (Timestamp, error) {
+	switch val {
+	case DateNoBegin:
+		return TimestampNoBegin, nil
+	case DateNoEnd:
+		return TimestampNoEnd, nil
+	}
+	return Timestamp(int64(val) * usecsPerDay), nil
+}
+
+// date + integer
+func (val Date) PlusDays(days Int4) (Date, error) {
+	if !val.IsFinite() {
+		return val, nil
+	}
+	result := int64(val) + int64(days)
+	if !isValidTimestamp(result * usecsPerDay) {
+		return 0, Ereport(DatetimeFieldOverflow, "date out of range")
+	}
+	return Date(result), nil
+}
+
+// date - integer
+func (val Date) MinusDays(days Int4) (Date, error) {
+	return val.PlusDays(-days)
+}
+
+// date - date, in days
+func (val Date) Minus(other Date) (Int4, error) {
+	if !val.IsFinite() || !other.IsFinite() {
+		return 0, Ereport(DatetimeFieldOverflow, "cannot subtract infinite dates")
+	}
+	return Int4(val - other), nil
+}
+
+// date + interval, which yields timestamp
+func (val Date) PlusInterval(span Interval) (Timestamp, error) {
+	ts, err := val.ToTimestamp()
+	if err != nil {
+		return 0, err
+	}
+	return ts.PlusInterval(span)
+}
+
+// date - interval, which yields timestamp
+func (val Date) MinusInterval(span Interval) (Timestamp, error) {
+	return val.PlusInterval(span.Negate())
+}
+
+func (val Date) Trunc(field string) (Timestamp, error) {
+	ts, err := val.ToTimestamp()
+	if err != nil {
+		return 0, err
+	}
+	return ts.Trunc(field)
+}
+
+func (val Date) Extract(field string) (Datum, error) {
+	unit := lookupUnit(field)
+	switch unit {
+	case unitMicrosecond, unitMillisecond, unitSecond, unitMinute, unitHour,
+		unitTimeZone, unitTimeZoneHour, unitTimeZoneMinute:
+		return nil, Ereport(FeatureNotSupported,
+			"unit \"%s\" not supported for type date", field)
+	}
+	ts, err := val.ToTimestamp()
+	if err != nil {
+		return nil, err
+	}
+	return ts.Extract(field)
+}
+
+func (val Time) ToString() string {
+	fields := fieldsFromPostgresUsecs(int64(val))
+	if val == Time(usecsPerDay) {
+		fields.hour = 24
+	}
+	return fields.encodeTime()
+}
+
+func (val Time) FromString(str string) (Datum, error) {
+	fields, err := parseDateTime(str)
+	if err != nil {
+		return nil, err
+	}
+	if !fields.hasTime {
+		return nil, Ereport(InvalidDatetimeFormat,
+			"invalid input syntax for type time: \"%s\"", str)
+	}
+	usecs := int64(fields.hour)*usecsPerHour + int64(fields.min)*usecsPerMinute +
+		int64(fields.sec)*usecsPerSec + int64(fields.usec)
+	if usecs > usecsPerDay {
+		return nil, Ereport(DatetimeFieldOverflow,
+			"date/time field value out of range: \"%s\"", str)
+	}
+	return Datum(Time(usecs)), nil
+}
+
+func (val Time) ToBytes(writer io.Writer) (int, error) {
+	err := binary.Write(writer, binary.LittleEndian, val)
+	return val.Len(), err
+}
+
+func (val Time) FromBytes(reader io.Reader) Datum {
+	var newval Time
+	if err := binary.Read(reader, binary.LittleEndian, &newval); err != nil {
+		panic("read error")
+	}
+	return Datum(newval)
+}
+
+func (val Time) Equals(other Datum) bool {
+	if oval, ok := other.(Time); ok {
+		return val == oval
+	}
+	return false
+}
+
+func (val Time) Len() int {
+	return 8
+}
+
+// time + interval.  Only the time field of the interval matters, and the
+// result wraps around midnight.
+func (val Time) PlusInterval(span Interval) Time {
+	result := (int64(val) + span.Time) % usecsPerDay
+	if result < 0 {
+		result += usecsPerDay
+	}
+	return Time(result)
+}
+
+// time - interval
+func (val Time) MinusInterval(span Interval) Time {
+	return val.PlusInterval(span.Negate())
+}
+
+// time - time
+func (val Time) Minus(other Time) Interval {
+	return Interval{Time: int64(val) - int64(other)}
+}
+
+func (val Time) Extract(field string) (Datum, error) {
+	usecs := int64(val)
+	switch lookupUnit(field) {
+	case unitMicrosecond:
+		return Float8(usecs % usecsPerMinute), nil
+	case unitMillisecond:
+		return Float8(usecs%usecsPerMinute) / 1000, nil
+	case unitSecond:
+		return Float8(usecs%usecsPerMinute) / Float8(usecsPerSec), nil
+	case unitMinute:
+		return Float8(usecs % usecsPerHour / usecsPerMinute), nil
+	case unitHour:
+		return Float8(usecs / usecsPerHour), nil
+	case unitEpoch:
+		return Float8(usecs) / Float8(usecsPerSec), nil
+	case unitInvalid:
+		return nil, unrecognizedUnit(field, "time")
+	}
+	return nil, Ereport(FeatureNotSupported,
+		"unit \"%s\" not supported for type time", field)
+}
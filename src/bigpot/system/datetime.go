@@ -0,0 +1,583 @@
+package system
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Date and time values are stored in the same integer representation as
+// postgres uses, counting from the postgres epoch 2000-01-01 00:00:00.
+// Calendar computation is done by the standard time package, on the
+// proleptic Gregorian calendar in UTC.
+const (
+	usecsPerSec    = int64(1000000)
+	usecsPerMinute = int64(60) * usecsPerSec
+	usecsPerHour   = int64(60) * usecsPerMinute
+	usecsPerDay    = int64(24) * usecsPerHour
+	secsPerDay     = int64(86400)
+	daysPerMonth   = 30 // assumes exactly 30 days per month, as postgres does
+	monthsPerYear  = 12
+
+	// seconds between 1970-01-01 and 2000-01-01
+	postgresEpochUnixSecs = int64(946684800)
+)
+
+// TimeZone is a value of the postgres TimeZone setting, which each
+// session keeps for itself.  It decides how timestamptz values are read
+// without an explicit zone, and how they are displayed, and the calendar
+// their arithmetic follows.
+type TimeZone struct {
+	name string
+	loc  *time.Location
+}
+
+// UTC is the TimeZone a session starts in, and the one the values stored
+// in the catalogs are read and written in.
+var UTC = &TimeZone{"UTC", time.UTC}
+
+var tzOffsetRegexp = regexp.MustCompile(`^([+-])(\d{1,2})(?::?(\d{2}))?(?::(\d{2}))?$`)
+
+// Returns the TimeZone of the name, as postgres' check_timezone.  name is
+// either a zone name known to the system time zone database, such as
+// "Asia/Tokyo" or "UTC", or a numeric ISO 8601 offset such as "+09" or
+// "-05:30".
+func LookupTimeZone(name string) (*TimeZone, error) {
+	loc, err := lookupTimeZone(name)
+	if err != nil {
+		return nil, Ereport(InvalidParameterValue,
+			"invalid value for parameter \"TimeZone\": \"%s\"", name)
+	}
+	return &TimeZone{name, loc}, nil
+}
+
+// Returns the name of the zone as it was given.
+func (tz *TimeZone) Name() string {
+	return tz.name
+}
+
+func lookupTimeZone(name string) (*time.Location, error) {
+	if m := tzOffsetRegexp.FindStringSubmatch(name); m != nil {
+		offset, err := parseTzOffset(m)
+		if err != nil {
+			return nil, err
+		}
+		return time.FixedZone(name, offset), nil
+	}
+	switch strings.ToLower(name) {
+	case "utc", "gmt", "z", "zulu":
+		return time.UTC, nil
+	case "":
+		return nil, fmt.Errorf("empty time zone")
+	}
+	return time.LoadLocation(name)
+}
+
+// Returns the offset from UTC in seconds given by the submatches of
+// tzOffsetRegexp.
+func parseTzOffset(m []string) (int, error) {
+	hour, _ := strconv.Atoi(m[2])
+	min, sec := 0, 0
+	if m[3] != "" {
+		min, _ = strconv.Atoi(m[3])
+	}
+	if m[4] != "" {
+		sec, _ = strconv.Atoi(m[4])
+	}
+	if hour > 15 || min >= 60 || sec >= 60 {
+		return 0, fmt.Errorf("time zone displacement out of range")
+	}
+	offset := hour*3600 + min*60 + sec
+	if m[1] == "-" {
+		offset = -offset
+	}
+	return offset, nil
+}
+
+// Special input strings shared by the date/time types.
+const (
+	dtNone = iota
+	dtEpoch
+	dtLateStart // infinity
+	dtEarlyEnd  // -infinity
+)
+
+func specialDateTime(str string) int {
+	switch strings.ToLower(strings.TrimSpace(str)) {
+	case "epoch":
+		return dtEpoch
+	case "infinity", "+infinity":
+		return dtLateStart
+	case "-infinity":
+		return dtEarlyEnd
+	}
+	return dtNone
+}
+
+// Broken-down date and time, as postgres' struct pg_tm.  year is in
+// astronomical numbering, so 1 BC is year 0.
+type dateTimeFields struct {
+	year, month, day int
+	hour, min, sec   int
+	usec             int
+	hasDate, hasTime bool
+	hasZone          bool
+	zoneOffset       int // seconds east of UTC, when hasZone
+	zone             *time.Location
+}
+
+var dateRegexp = regexp.MustCompile(`^(\d{1,})-(\d{1,2})-(\d{1,2})`)
+var timeRegexp = regexp.MustCompile(`^(\d{1,2}):(\d{2})(?::(\d{2})(?:\.(\d+))?)?`)
+var eraRegexp = regexp.MustCompile(`(?i)\s+(bc|ad)$`)
+
+// Parses an ISO 8601 style date and/or time string such as
+// "2014-10-20 12:34:56.789+09".  The date part is "YYYY-MM-DD", optionally
+// followed by " BC", and the date and time are separated by a space or "T".
+// A zone is either "Z", a numeric offset, or a zone name.  Each part is
+// optional here; the caller checks that what it needs is present.
+func parseDateTime(str string) (*dateTimeFields, error) {
+	fields := &dateTimeFields{}
+	rest := strings.TrimSpace(str)
+	isBC := false
+
+	if m := eraRegexp.FindStringSubmatch(rest); m != nil {
+		isBC = strings.ToLower(m[1]) == "bc"
+		rest = rest[:len(rest)-len(m[0])]
+	}
+
+	if m := dateRegexp.FindStringSubmatch(rest); m != nil {
+		fields.year, _ = strconv.Atoi(m[1])
+		fields.month, _ = strconv.Atoi(m[2])
+		fields.day, _ = strconv.Atoi(m[3])
+		fields.hasDate = true
+		rest = rest[len(m[0]):]
+		// there is no year 0; 1 BC is before 1 AD
+		if fields.year == 0 && isBC {
+			return nil, badDateTimeSyntax(str)
+		} else if fields.year == 0 {
+			return nil, Ereport(DatetimeFieldOverflow,
+				"date/time field value out of range: \"%s\"", str)
+		} else if isBC {
+			fields.year = 1 - fields.year
+		}
+		if len(rest) > 0 && (rest[0] == 'T' || rest[0] == 't') {
+			rest = rest[1:]
+		} else if len(rest) > 0 && rest[0] != ' ' {
+			return nil, badDateTimeSyntax(str)
+		}
+		rest = strings.TrimLeft(rest, " ")
+	} else if isBC {
+		return nil, badDateTimeSyntax(str)
+	}
+
+	if m := timeRegexp.FindStringSubmatch(rest); m != nil {
+		fields.hour, _ = strconv.Atoi(m[1])
+		fields.min, _ = strconv.Atoi(m[2])
+		if m[3] != "" {
+			fields.sec, _ = strconv.Atoi(m[3])
+		}
+		if m[4] != "" {
+			fields.usec = parseFraction(m[4])
+		}
+		fields.hasTime = true
+		rest = strings.TrimSpace(rest[len(m[0]):])
+	}
+
+	if len(rest) > 0 {
+		if !fields.hasDate && !fields.hasTime {
+			return nil, badDateTimeSyntax(str)
+		}
+		if m := tzOffsetRegexp.FindStringSubmatch(rest); m != nil {
+			offset, err := parseTzOffset(m)
+			if err != nil {
+				return nil, Ereport(InvalidDatetimeFormat,
+					"time zone displacement out of range: \"%s\"", str)
+			}
+			fields.zoneOffset = offset
+		} else if loc, err := lookupTimeZone(rest); err == nil {
+			fields.zone = loc
+		} else {
+			return nil, Ereport(InvalidParameterValue,
+				"time zone \"%s\" not recognized", rest)
+		}
+		fields.hasZone = true
+	}
+
+	if fields.hasDate {
+		if fields.month < 1 || fields.month > 12 ||
+			fields.day < 1 || fields.day > daysInMonth(fields.year, fields.month) {
+			return nil, Ereport(DatetimeFieldOverflow,
+				"date/time field value out of range: \"%s\"", str)
+		}
+	}
+	if fields.min > 59 || fields.sec > 60 || fields.hour > 24 ||
+		(fields.hour == 24 && (fields.min > 0 || fields.sec > 0 || fields.usec > 0)) {
+		return nil, Ereport(DatetimeFieldOverflow,
+			"date/time field value out of range: \"%s\"", str)
+	}
+
+	return fields, nil
+}
+
+// Converts the digits after a decimal point into microseconds, rounding
+// anything beyond microsecond precision.
+func parseFraction(digits string) int {
+	if len(digits) > 7 {
+		digits = digits[:7]
+	}
+	for len(digits) < 7 {
+		digits += "0"
+	}
+	val, _ := strconv.Atoi(digits)
+	return (val + 5) / 10
+}
+
+func badDateTimeSyntax(str string) error {
+	return Ereport(InvalidDatetimeFormat,
+		"invalid input syntax for type date/time: \"%s\"", str)
+}
+
+func isLeapYear(year int) bool {
+	return year%4 == 0 && (year%100 != 0 || year%400 == 0)
+}
+
+var monthDays = [2][12]int{
+	{31, 28, 31, 30, 31, 30, 31, 31, 30, 31, 30, 31},
+	{31, 29, 31, 30, 31, 30, 31, 31, 30, 31, 30, 31},
+}
+
+func daysInMonth(year, month int) int {
+	leap := 0
+	if isLeapYear(year) {
+		leap = 1
+	}
+	return monthDays[leap][month-1]
+}
+
+// Microseconds since the postgres epoch for the given UTC wall clock,
+// without overflow checks.
+func (fields *dateTimeFields) toPostgresUsecs() int64 {
+	days := daysFromCivil(fields.year, fields.month, fields.day)
+	usecs := int64(fields.hour)*usecsPerHour + int64(fields.min)*usecsPerMinute +
+		int64(fields.sec)*usecsPerSec + int64(fields.usec)
+	return days*usecsPerDay + usecs
+}
+
+// Number of days from 2000-01-01 to the given date.
+func daysFromCivil(year, month, day int) int64 {
+	t := time.Date(year, time.Month(month), day, 0, 0, 0, 0, time.UTC)
+	return floorDiv(t.Unix()-postgresEpochUnixSecs, secsPerDay)
+}
+
+// Splits microseconds since the postgres epoch into a UTC wall clock.
+func fieldsFromPostgresUsecs(usecs int64) *dateTimeFields {
+	days := floorDiv(usecs, usecsPerDay)
+	tod := usecs - days*usecsPerDay
+	t := time.Unix(postgresEpochUnixSecs+days*secsPerDay, 0).UTC()
+	return &dateTimeFields{
+		year:    t.Year(),
+		month:   int(t.Month()),
+		day:     t.Day(),
+		hour:    int(tod / usecsPerHour),
+		min:     int(tod % usecsPerHour / usecsPerMinute),
+		sec:     int(tod % usecsPerMinute / usecsPerSec),
+		usec:    int(tod % usecsPerSec),
+		hasDate: true,
+		hasTime: true,
+	}
+}
+
+// Converts microseconds since the postgres epoch to a time.Time.
+func postgresUsecsToTime(usecs int64) time.Time {
+	return time.UnixMicro(usecs + postgresEpochUnixSecs*usecsPerSec)
+}
+
+// Converts a time.Time to microseconds since the postgres epoch.
+func timeToPostgresUsecs(t time.Time) int64 {
+	return t.UnixMicro() - postgresEpochUnixSecs*usecsPerSec
+}
+
+func floorDiv(a, b int64) int64 {
+	q := a / b
+	if (a%b != 0) && ((a < 0) != (b < 0)) {
+		q--
+	}
+	return q
+}
+
+// The range of valid timestamps.  As postgres, we accept years from 4713 BC
+// up to 294276 AD, which keeps every value inside int64 microseconds.
+var minTimestampUsecs = (&dateTimeFields{year: -4712, month: 11, day: 24}).toPostgresUsecs()
+var endTimestampUsecs = (&dateTimeFields{year: 294277, month: 1, day: 1}).toPostgresUsecs()
+
+func isValidTimestamp(usecs int64) bool {
+	return usecs >= minTimestampUsecs && usecs < endTimestampUsecs
+}
+
+// Formats the date part of fields in ISO style, "YYYY-MM-DD".  The BC
+// marker is left to the caller as it goes after the time.
+func (fields *dateTimeFields) encodeDate() string {
+	year := fields.year
+	if year <= 0 {
+		year = 1 - year
+	}
+	return fmt.Sprintf("%04d-%02d-%02d", year, fields.month, fields.day)
+}
+
+func (fields *dateTimeFields) encodeEra() string {
+	if fields.year <= 0 {
+		return " BC"
+	}
+	return ""
+}
+
+// Formats the time part of fields, "HH:MM:SS[.ffffff]" with trailing
+// zeros of the fraction removed.
+func (fields *dateTimeFields) encodeTime() string {
+	return fmt.Sprintf("%02d:%02d:%s", fields.hour, fields.min,
+		encodeSeconds(fields.sec, fields.usec))
+}
+
+func encodeSeconds(sec, usec int) string {
+	if usec == 0 {
+		return fmt.Sprintf("%02d", sec)
+	}
+	str := fmt.Sprintf("%02d.%06d", sec, usec)
+	return strings.TrimRight(str, "0")
+}
+
+// Formats a zone offset in seconds east of UTC as postgres does,
+// e.g. "+09", "-03:30".
+func encodeTimeZone(offset int) string {
+	sign := '+'
+	if offset < 0 {
+		sign = '-'
+		offset = -offset
+	}
+	hour, min, sec := offset/3600, offset%3600/60, offset%60
+	if sec != 0 {
+		return fmt.Sprintf("%c%02d:%02d:%02d", sign, hour, min, sec)
+	} else if min != 0 {
+		return fmt.Sprintf("%c%02d:%02d", sign, hour, min)
+	}
+	return fmt.Sprintf("%c%02d", sign, hour)
+}
+
+// Units accepted by interval input, date_trunc and extract.
+const (
+	unitMicrosecond = iota
+	unitMillisecond
+	unitSecond
+	unitMinute
+	unitHour
+	unitDay
+	unitWeek
+	unitMonth
+	unitQuarter
+	unitYear
+	unitDecade
+	unitCentury
+	unitMillennium
+	unitDow
+	unitIsoDow
+	unitDoy
+	unitEpoch
+	unitIsoYear
+	unitTimeZone
+	unitTimeZoneHour
+	unitTimeZoneMinute
+	unitInvalid
+)
+
+var unitNames = map[string]int{
+	"microsecond": unitMicrosecond, "microseconds": unitMicrosecond,
+	"us": unitMicrosecond, "usec": unitMicrosecond, "usecs": unitMicrosecond,
+	"millisecond": unitMillisecond, "milliseconds": unitMillisecond,
+	"ms": unitMillisecond, "msec": unitMillisecond, "msecs": unitMillisecond,
+	"second": unitSecond, "seconds": unitSecond, "s": unitSecond,
+	"sec": unitSecond, "secs": unitSecond,
+	"minute": unitMinute, "minutes": unitMinute, "m": unitMinute,
+	"min": unitMinute, "mins": unitMinute,
+	"hour": unitHour, "hours": unitHour, "h": unitHour,
+	"hr": unitHour, "hrs": unitHour,
+	"day": unitDay, "days": unitDay, "d": unitDay,
+	"week": unitWeek, "weeks": unitWeek, "w": unitWeek,
+	"month": unitMonth, "months": unitMonth, "mon": unitMonth, "mons": unitMonth,
+	"quarter": unitQuarter, "qtr": unitQuarter,
+	"year": unitYear, "years": unitYear, "y": unitYear,
+	"yr": unitYear, "yrs": unitYear,
+	"decade": unitDecade, "decades": unitDecade, "dec": unitDecade,
+	"century": unitCentury, "centuries": unitCentury, "c": unitCentury,
+	"millennium": unitMillennium, "millennia": unitMillennium,
+	"mil": unitMillennium, "mils": unitMillennium,
+	"dow": unitDow, "isodow": unitIsoDow, "doy": unitDoy,
+	"epoch": unitEpoch, "isoyear": unitIsoYear,
+	"timezone": unitTimeZone, "timezone_hour": unitTimeZoneHour,
+	"timezone_minute": unitTimeZoneMinute,
+}
+
+func lookupUnit(name string) int {
+	if unit, ok := unitNames[strings.ToLower(name)]; ok {
+		return unit
+	}
+	return unitInvalid
+}
+
+var isoIntervalRegexp = regexp.MustCompile(`^(?i)P(?:(-?[\d.]+)Y)?(?:(-?[\d.]+)M)?(?:(-?[\d.]+)W)?(?:(-?[\d.]+)D)?(?:T(?:(-?[\d.]+)H)?(?:(-?[\d.]+)M)?(?:(-?[\d.]+)S)?)?$`)
+var intervalTimeRegexp = regexp.MustCompile(`^([+-])?(\d+):(\d{2})(?::(\d{2})(?:\.(\d+))?)?$`)
+
+// Parses interval input in the postgres format, such as
+// "1 year 2 mons -3 days 04:05:06", optionally prefixed with "@" and
+// suffixed with "ago", or in the ISO 8601 format with designators,
+// such as "P1Y2M3DT4H5M6S".
+func parseInterval(str string) (Interval, error) {
+	var span Interval
+	trimmed := strings.TrimSpace(str)
+
+	if m := isoIntervalRegexp.FindStringSubmatch(trimmed); m != nil && trimmed != "P" {
+		units := []int{unitYear, unitMonth, unitWeek, unitDay,
+			unitHour, unitMinute, unitSecond}
+		for i, unit := range units {
+			if m[i+1] == "" {
+				continue
+			}
+			val, err := strconv.ParseFloat(m[i+1], 64)
+			if err != nil {
+				return span, badIntervalSyntax(str)
+			}
+			if err := span.addUnit(val, unit); err != nil {
+				return span, err
+			}
+		}
+		return span, nil
+	}
+
+	words := strings.Fields(strings.ToLower(trimmed))
+	if len(words) > 0 && words[0] == "@" {
+		words = words[1:]
+	}
+	ago := false
+	if len(words) > 0 && words[len(words)-1] == "ago" {
+		ago = true
+		words = words[:len(words)-1]
+	}
+	if len(words) == 0 {
+		return span, badIntervalSyntax(str)
+	}
+
+	for i := 0; i < len(words); i++ {
+		word := words[i]
+		if m := intervalTimeRegexp.FindStringSubmatch(word); m != nil {
+			hour, _ := strconv.ParseInt(m[2], 10, 64)
+			min, _ := strconv.ParseInt(m[3], 10, 64)
+			var sec, usec int64
+			if m[4] != "" {
+				sec, _ = strconv.ParseInt(m[4], 10, 64)
+			}
+			if m[5] != "" {
+				usec = int64(parseFraction(m[5]))
+			}
+			if min > 59 || sec > 59 {
+				return span, Ereport(DatetimeFieldOverflow,
+					"interval field value out of range: \"%s\"", str)
+			}
+			usecs := hour*usecsPerHour + min*usecsPerMinute + sec*usecsPerSec + usec
+			if m[1] == "-" {
+				usecs = -usecs
+			}
+			span.Time += usecs
+			continue
+		}
+
+		// a number, with its unit either attached or as the next word
+		numEnd := strings.IndexFunc(word, func(r rune) bool {
+			return !(r >= '0' && r <= '9' || r == '.' || r == '-' || r == '+')
+		})
+		numStr, unitStr := word, ""
+		if numEnd >= 0 {
+			numStr, unitStr = word[:numEnd], word[numEnd:]
+		}
+		val, err := strconv.ParseFloat(numStr, 64)
+		if err != nil {
+			return span, badIntervalSyntax(str)
+		}
+		if unitStr == "" {
+			if i+1 == len(words) {
+				// a bare number means seconds
+				unitStr = "second"
+			} else {
+				i++
+				unitStr = words[i]
+			}
+		}
+		unit := lookupUnit(unitStr)
+		if unit > unitMillennium {
+			return span, badIntervalSyntax(str)
+		}
+		if err := span.addUnit(val, unit); err != nil {
+			return span, err
+		}
+	}
+
+	if ago {
+		span = span.Negate()
+	}
+	return span, nil
+}
+
+func badIntervalSyntax(str string) error {
+	return Ereport(InvalidDatetimeFormat,
+		"invalid input syntax for type interval: \"%s\"", str)
+}
+
+// Adds val units to the interval.  Fractional months and days cascade
+// down into the smaller fields, as postgres does.
+func (span *Interval) addUnit(val float64, unit int) error {
+	var months, days float64
+	var usecs float64
+	switch unit {
+	case unitMicrosecond:
+		usecs = val
+	case unitMillisecond:
+		usecs = val * 1000
+	case unitSecond:
+		usecs = val * float64(usecsPerSec)
+	case unitMinute:
+		usecs = val * float64(usecsPerMinute)
+	case unitHour:
+		usecs = val * float64(usecsPerHour)
+	case unitDay:
+		days = val
+	case unitWeek:
+		days = val * 7
+	case unitMonth:
+		months = val
+	case unitQuarter:
+		months = val * 3
+	case unitYear:
+		months = val * monthsPerYear
+	case unitDecade:
+		months = val * monthsPerYear * 10
+	case unitCentury:
+		months = val * monthsPerYear * 100
+	case unitMillennium:
+		months = val * monthsPerYear * 1000
+	}
+
+	wholeMonths := math.Trunc(months)
+	days += (months - wholeMonths) * daysPerMonth
+	wholeDays := math.Trunc(days)
+	usecs += (days - wholeDays) * float64(usecsPerDay)
+
+	if math.Abs(wholeMonths) > math.MaxInt32 || math.Abs(wholeDays) > math.MaxInt32 ||
+		math.Abs(usecs) > math.MaxInt64/2 {
+		return Ereport(DatetimeFieldOverflow, "interval out of range")
+	}
+	span.Month += int32(wholeMonths)
+	span.Day += int32(wholeDays)
+	span.Time += int64(math.Round(usecs))
+	return nil
+}
@@ -0,0 +1,343 @@
+package system
+
+import (
+	"bytes"
+	. "launchpad.net/gocheck"
+	"math"
+)
+
+func (s *MySuite) TestDateInOut(c *C) {
+	checks := []struct{ in, out string }{
+		{"2014-10-20", "2014-10-20"},
+		{"2000-1-1", "2000-01-01"},
+		{"2012-02-29 12:34:56", "2012-02-29"},
+		{"0044-03-15 BC", "0044-03-15 BC"},
+		{"epoch", "1970-01-01"},
+		{"infinity", "infinity"},
+		{"-infinity", "-infinity"},
+	}
+	for _, check := range checks {
+		val, err := DatumFromString(check.in, DateType)
+		c.Assert(err, IsNil)
+		c.Check(val.ToString(), Equals, check.out)
+	}
+
+	d, _ := DatumFromString("2000-01-02", DateType)
+	c.Check(d, Equals, Date(1))
+
+	_, err := DatumFromString("2013-02-29", DateType)
+	c.Check(err, ErrorMatches, "date/time field value out of range: .*")
+	_, err = DatumFromString("20141020", DateType)
+	c.Check(err, ErrorMatches, "invalid input syntax .*")
+	_, err = DatumFromString("12:00", DateType)
+	c.Check(err, ErrorMatches, "invalid input syntax for type date: .*")
+	// there is no year 0
+	_, err = DatumFromString("0000-01-01", DateType)
+	c.Check(err, ErrorMatches, "date/time field value out of range: .*")
+	d, err = DatumFromString(" infinity ", DateType)
+	c.Assert(err, IsNil)
+	c.Check(d, Equals, DateNoEnd)
+}
+
+func (s *MySuite) TestTimeInOut(c *C) {
+	checks := []struct{ in, out string }{
+		{"04:05:06", "04:05:06"},
+		{"04:05", "04:05:00"},
+		{"04:05:06.789", "04:05:06.789"},
+		{"04:05:06.0000004", "04:05:06"},
+		{"24:00:00", "24:00:00"},
+		{"2003-04-12 04:05:06", "04:05:06"},
+	}
+	for _, check := range checks {
+		val, err := DatumFromString(check.in, TimeType)
+		c.Assert(err, IsNil)
+		c.Check(val.ToString(), Equals, check.out)
+	}
+
+	_, err := DatumFromString("25:00", TimeType)
+	c.Check(err, ErrorMatches, "date/time field value out of range: .*")
+}
+
+func (s *MySuite) TestTimestampInOut(c *C) {
+	checks := []struct{ in, out string }{
+		{"2014-10-20 12:34:56", "2014-10-20 12:34:56"},
+		{"2014-10-20T12:34:56.5", "2014-10-20 12:34:56.5"},
+		{"2014-10-20", "2014-10-20 00:00:00"},
+		{"2014-10-20 12:34:56+09", "2014-10-20 12:34:56"},
+		{"1999-12-31 23:59:59.999999", "1999-12-31 23:59:59.999999"},
+		{"0001-01-01 00:00:00 BC", "0001-01-01 00:00:00 BC"},
+		{"EPOCH", "1970-01-01 00:00:00"},
+		{"infinity", "infinity"},
+		{"-infinity", "-infinity"},
+	}
+	for _, check := range checks {
+		val, err := DatumFromString(check.in, TimestampType)
+		c.Assert(err, IsNil)
+		c.Check(val.ToString(), Equals, check.out)
+	}
+
+	val, _ := DatumFromString("2000-01-01 00:00:01", TimestampType)
+	c.Check(val, Equals, Timestamp(1000000))
+
+	_, err := DatumFromString("2014-10-20 12:60:00", TimestampType)
+	c.Check(err, ErrorMatches, "date/time field value out of range: .*")
+	_, err = DatumFromString("294277-01-01", TimestampType)
+	c.Check(err, ErrorMatches, "timestamp out of range: .*")
+}
+
+func (s *MySuite) TestTimestampTzInOut(c *C) {
+	val, err := DatumFromString("2014-10-20 12:00:00+09", TimestampTzType)
+	c.Assert(err, IsNil)
+	c.Check(val.ToString(), Equals, "2014-10-20 03:00:00+00")
+
+	val, err = DatumFromString("2014-10-20T03:00:00Z", TimestampTzType)
+	c.Assert(err, IsNil)
+	c.Check(val.ToString(), Equals, "2014-10-20 03:00:00+00")
+
+	tz, err := LookupTimeZone("-03:30")
+	c.Assert(err, IsNil)
+	c.Check(tz.Name(), Equals, "-03:30")
+	c.Check(DatumToStringInZone(val, tz), Equals, "2014-10-19 23:30:00-03:30")
+	// the output of a timestamptz alone is in UTC
+	c.Check(val.ToString(), Equals, "2014-10-20 03:00:00+00")
+
+	// without a zone, the input is read in the time zone
+	val2, err := DatumFromStringInZone("2014-10-19 23:30:00", TimestampTzType, tz)
+	c.Assert(err, IsNil)
+	c.Check(val2, Equals, val)
+
+	epoch, _ := DatumFromStringInZone(" epoch ", TimestampTzType, tz)
+	c.Check(DatumToStringInZone(epoch, tz), Equals, "1969-12-31 20:30:00-03:30")
+
+	_, err = LookupTimeZone("No/Such_Zone")
+	c.Check(err, ErrorMatches, "invalid value for parameter \"TimeZone\": .*")
+}
+
+func (s *MySuite) TestIntervalInOut(c *C) {
+	checks := []struct{ in, out string }{
+		{"1 year 2 months 3 days 04:05:06", "1 year 2 mons 3 days 04:05:06"},
+		{"@ 1 day 2 hours ago", "-1 days -02:00:00"},
+		{"-1 days +02:03", "-1 days +02:03:00"},
+		{"1.5 months", "1 mon 15 days"},
+		{"90 minutes", "01:30:00"},
+		{"2 weeks", "14 days"},
+		{"1.5", "00:00:01.5"},
+		{"0 seconds", "00:00:00"},
+		{"P1Y2M3DT4H5M6S", "1 year 2 mons 3 days 04:05:06"},
+		{"PT0.5S", "00:00:00.5"},
+		{"P1W", "7 days"},
+		{"1 century", "100 years"},
+	}
+	for _, check := range checks {
+		val, err := DatumFromString(check.in, IntervalType)
+		c.Assert(err, IsNil)
+		c.Check(val.ToString(), Equals, check.out)
+	}
+
+	_, err := DatumFromString("1 fortnight", IntervalType)
+	c.Check(err, ErrorMatches, "invalid input syntax for type interval: .*")
+
+	day, _ := DatumFromString("1 day", IntervalType)
+	hours, _ := DatumFromString("24 hours", IntervalType)
+	c.Check(day.Equals(hours), Equals, true)
+}
+
+func (s *MySuite) TestDateTimeToBytes(c *C) {
+	values := []Datum{
+		Date(-100), Time(123456789), Timestamp(-42), TimestampTz(42),
+		Interval{Time: 1, Day: -2, Month: 3}, Float8(1.5),
+	}
+	typids := []Oid{
+		DateType, TimeType, TimestampType, TimestampTzType,
+		IntervalType, Float8Type,
+	}
+	for i, val := range values {
+		var buf bytes.Buffer
+		n, err := val.ToBytes(&buf)
+		c.Assert(err, IsNil)
+		c.Check(n, Equals, int(TypeRegistry[typids[i]].Len))
+		c.Check(buf.Len(), Equals, n)
+		c.Check(DatumFromBytes(&buf, typids[i]), Equals, val)
+	}
+}
+
+func mustTimestamp(str string) Timestamp {
+	val, err := DatumFromString(str, TimestampType)
+	if err != nil {
+		panic(err)
+	}
+	return val.(Timestamp)
+}
+
+func mustInterval(str string) Interval {
+	val, err := DatumFromString(str, IntervalType)
+	if err != nil {
+		panic(err)
+	}
+	return val.(Interval)
+}
+
+func (s *MySuite) TestTimestampArithmetic(c *C) {
+	ts, err := mustTimestamp("2014-01-31 10:00:00").PlusInterval(mustInterval("1 month"))
+	c.Assert(err, IsNil)
+	c.Check(ts.ToString(), Equals, "2014-02-28 10:00:00")
+
+	ts, err = mustTimestamp("2014-03-01 00:00:00").MinusInterval(mustInterval("1 day 1 hour"))
+	c.Assert(err, IsNil)
+	c.Check(ts.ToString(), Equals, "2014-02-27 23:00:00")
+
+	ts, err = mustTimestamp("2012-02-29").PlusInterval(mustInterval("1 year"))
+	c.Assert(err, IsNil)
+	c.Check(ts.ToString(), Equals, "2013-02-28 00:00:00")
+
+	span, err := mustTimestamp("2014-10-20 12:00").Minus(mustTimestamp("2014-10-18 06:30"))
+	c.Assert(err, IsNil)
+	c.Check(span.ToString(), Equals, "2 days 05:30:00")
+
+	ts, err = TimestampNoEnd.MinusInterval(mustInterval("1 year"))
+	c.Check(ts, Equals, TimestampNoEnd)
+	_, err = TimestampNoEnd.Minus(mustTimestamp("2014-10-20"))
+	c.Check(err, ErrorMatches, "cannot subtract infinite timestamps")
+
+	_, err = mustTimestamp("294276-12-31").PlusInterval(mustInterval("1 day"))
+	c.Check(err, ErrorMatches, "timestamp out of range")
+
+	d, _ := DatumFromString("2014-10-20", DateType)
+	ts, err = d.(Date).PlusInterval(mustInterval("36 hours"))
+	c.Assert(err, IsNil)
+	c.Check(ts.ToString(), Equals, "2014-10-21 12:00:00")
+	d2, _ := d.(Date).PlusDays(11)
+	c.Check(d2.ToString(), Equals, "2014-10-31")
+	days, _ := d2.Minus(d.(Date))
+	c.Check(days, Equals, Int4(11))
+
+	t, _ := DatumFromString("23:00", TimeType)
+	c.Check(t.(Time).PlusInterval(mustInterval("2 hours")).ToString(), Equals, "01:00:00")
+
+	sum, err := mustInterval("1 day -01:00").Plus(mustInterval("2 mons 03:00"))
+	c.Assert(err, IsNil)
+	c.Check(sum.ToString(), Equals, "2 mons 1 day 02:00:00")
+	c.Check(mustInterval("36 hours").JustifyHours().ToString(), Equals, "1 day 12:00:00")
+	c.Check(mustInterval("45 days").JustifyDays().ToString(), Equals, "1 mon 15 days")
+}
+
+func (s *MySuite) TestTimestampTzArithmetic(c *C) {
+	tz, err := LookupTimeZone("America/New_York")
+	if err != nil {
+		c.Skip("no time zone database")
+	}
+
+	// the day across the daylight saving change is 23 hours long
+	val, _ := ParseTimestampTz("2014-03-08 12:00:00", tz)
+	ts := val.(TimestampTz)
+	next, err := ts.PlusInterval(mustInterval("1 day"), tz)
+	c.Assert(err, IsNil)
+	c.Check(next.Format(tz), Equals, "2014-03-09 12:00:00-04")
+	span, _ := next.Minus(ts)
+	c.Check(span.ToString(), Equals, "23:00:00")
+	// but 24 hours in UTC
+	next, err = ts.PlusInterval(mustInterval("1 day"), UTC)
+	c.Assert(err, IsNil)
+	c.Check(next.Format(tz), Equals, "2014-03-09 13:00:00-04")
+
+	trunc, err := next.Trunc("month", tz)
+	c.Assert(err, IsNil)
+	c.Check(trunc.Format(tz), Equals, "2014-03-01 00:00:00-05")
+	trunc, err = next.Trunc("day", UTC)
+	c.Assert(err, IsNil)
+	c.Check(trunc.ToString(), Equals, "2014-03-09 00:00:00+00")
+
+	hour, _ := next.Extract("timezone_hour", tz)
+	c.Check(hour, Equals, Float8(-4))
+
+	proc, err := LookupProc("date_trunc", []Oid{TextType, TimestampTzType})
+	c.Assert(err, IsNil)
+	res, err := proc.CallInZone(tz, Text("day"), next)
+	c.Assert(err, IsNil)
+	c.Check(res.(TimestampTz).Format(tz), Equals, "2014-03-09 00:00:00-05")
+}
+
+func (s *MySuite) TestDateTrunc(c *C) {
+	ts := mustTimestamp("2014-10-22 12:34:56.789")
+	checks := []struct{ field, out string }{
+		{"microseconds", "2014-10-22 12:34:56.789"},
+		{"milliseconds", "2014-10-22 12:34:56.789"},
+		{"second", "2014-10-22 12:34:56"},
+		{"minute", "2014-10-22 12:34:00"},
+		{"hour", "2014-10-22 12:00:00"},
+		{"day", "2014-10-22 00:00:00"},
+		{"week", "2014-10-20 00:00:00"},
+		{"month", "2014-10-01 00:00:00"},
+		{"quarter", "2014-10-01 00:00:00"},
+		{"YEAR", "2014-01-01 00:00:00"},
+		{"decade", "2010-01-01 00:00:00"},
+		{"century", "2001-01-01 00:00:00"},
+		{"millennium", "2001-01-01 00:00:00"},
+	}
+	for _, check := range checks {
+		trunc, err := ts.Trunc(check.field)
+		c.Assert(err, IsNil)
+		c.Check(trunc.ToString(), Equals, check.out)
+	}
+
+	_, err := ts.Trunc("fortnight")
+	c.Check(err, ErrorMatches, "unit \"fortnight\" not recognized for type timestamp without time zone")
+}
+
+func (s *MySuite) TestExtract(c *C) {
+	ts := mustTimestamp("2001-02-16 20:38:40.5")
+	checks := []struct {
+		field string
+		out   Float8
+	}{
+		{"century", 21},
+		{"day", 16},
+		{"decade", 200},
+		{"dow", 5},
+		{"doy", 47},
+		{"epoch", 982355920.5},
+		{"hour", 20},
+		{"isodow", 5},
+		{"isoyear", 2001},
+		{"microseconds", 40500000},
+		{"millennium", 3},
+		{"milliseconds", 40500},
+		{"minute", 38},
+		{"month", 2},
+		{"quarter", 1},
+		{"second", 40.5},
+		{"week", 7},
+		{"year", 2001},
+	}
+	for _, check := range checks {
+		val, err := ts.Extract(check.field)
+		c.Assert(err, IsNil)
+		c.Check(val, Equals, check.out, Commentf("field %s", check.field))
+	}
+
+	bc := mustTimestamp("0001-06-01 BC")
+	year, _ := bc.Extract("year")
+	c.Check(year, Equals, Float8(-1))
+
+	inf, err := TimestampNoEnd.Extract("year")
+	c.Assert(err, IsNil)
+	c.Check(math.IsInf(float64(inf.(Float8)), 1), Equals, true)
+	// the fields that do not grow with time are NULL
+	month, err := TimestampNoEnd.Extract("month")
+	c.Assert(err, IsNil)
+	c.Check(month, IsNil)
+	month, err = TimestampTz(TimestampNoBegin).Extract("month", UTC)
+	c.Assert(err, IsNil)
+	c.Check(month, IsNil)
+	_, err = TimestampNoEnd.Extract("fortnight")
+	c.Check(err, NotNil)
+
+	hours, err := mustInterval("1 day 25:30:00").Extract("hour")
+	c.Assert(err, IsNil)
+	c.Check(hours, Equals, Float8(25))
+	epoch, _ := mustInterval("1 year 1 day").Extract("epoch")
+	c.Check(epoch, Equals, Float8(365.25*86400+86400))
+
+	_, err = ts.Extract("timezone")
+	c.Check(err, ErrorMatches, "unit \"timezone\" not supported .*")
+}
@@ -8,6 +8,76 @@ type ErrorCode [5]byte
 
 var InvalidTextRepresentation = ErrorCode{'2', '2', 'P', '0', '2'}
 
+var InvalidDatetimeFormat = ErrorCode{'2', '2', '0', '0', '7'}
+
+var DatetimeFieldOverflow = ErrorCode{'2', '2', '0', '0', '8'}
+
+var InvalidParameterValue = ErrorCode{'2', '2', '0', '2', '3'}
+
+var InvalidRowCountInLimitClause = ErrorCode{'2', '2', '0', '1', 'W'}
+
+var InvalidRowCountInResultOffsetClause = ErrorCode{'2', '2', '0', '1', 'X'}
+
+var CardinalityViolation = ErrorCode{'2', '1', '0', '0', '0'}
+
+var FeatureNotSupported = ErrorCode{'0', 'A', '0', '0', '0'}
+
+var NumericValueOutOfRange = ErrorCode{'2', '2', '0', '0', '3'}
+
+var DivisionByZero = ErrorCode{'2', '2', '0', '1', '2'}
+
+var UndefinedFunction = ErrorCode{'4', '2', '8', '8', '3'}
+
+var UndefinedObject = ErrorCode{'4', '2', '7', '0', '4'}
+
+var WrongObjectType = ErrorCode{'4', '2', '8', '0', '9'}
+
+var GroupingError = ErrorCode{'4', '2', '8', '0', '3'}
+
+var UndefinedTable = ErrorCode{'4', '2', 'P', '0', '1'}
+
+var UndefinedColumn = ErrorCode{'4', '2', '7', '0', '3'}
+
+var DatatypeMismatch = ErrorCode{'4', '2', '8', '0', '4'}
+
+var CannotCoerce = ErrorCode{'4', '2', '8', '4', '6'}
+
+var AmbiguousColumn = ErrorCode{'4', '2', '7', '0', '2'}
+
+var AmbiguousFunction = ErrorCode{'4', '2', '7', '2', '5'}
+
+var DuplicateTable = ErrorCode{'4', '2', 'P', '0', '7'}
+
+var DuplicateColumn = ErrorCode{'4', '2', '7', '0', '1'}
+
+var DuplicateAlias = ErrorCode{'4', '2', '7', '1', '2'}
+
+var InvalidColumnReference = ErrorCode{'4', '2', 'P', '1', '0'}
+
+var SyntaxError = ErrorCode{'4', '2', '6', '0', '1'}
+
+var InsufficientPrivilege = ErrorCode{'4', '2', '5', '0', '1'}
+
+var ActiveSqlTransaction = ErrorCode{'2', '5', '0', '0', '1'}
+
+var InFailedSqlTransaction = ErrorCode{'2', '5', 'P', '0', '2'}
+
+var InvalidSchemaName = ErrorCode{'3', 'F', '0', '0', '0'}
+
+var InvalidCatalogName = ErrorCode{'3', 'D', '0', '0', '0'}
+
+var UniqueViolation = ErrorCode{'2', '3', '5', '0', '5'}
+
+var NotNullViolation = ErrorCode{'2', '3', '5', '0', '2'}
+
+var ProgramLimitExceeded = ErrorCode{'5', '4', '0', '0', '0'}
+
+var ObjectNotInPrerequisiteState = ErrorCode{'5', '5', '0', '0', '0'}
+
+var IoError = ErrorCode{'5', '8', '0', '3', '0'}
+
+var DataCorrupted = ErrorCode{'X', 'X', '0', '0', '1'}
+
 var InternalError = ErrorCode{'X', 'X', '0', '0', '0'}
 
 type Error struct {
@@ -30,3 +100,7 @@ func Elog(msg string, v ...interface{}) *Error {
 func (e *Error) Error() string {
 	return e.msg
 }
+
+func (e *Error) Code() ErrorCode {
+	return e.code
+}
@@ -0,0 +1,61 @@
+package system
+
+// Returns true if the text matches the LIKE pattern, as postgres'
+// MatchText.  In the pattern, % matches any sequence of characters, _ any
+// single character, and a backslash makes the next character match only
+// itself.
+func likeMatch(text, pattern []rune) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '%':
+			// Runs of % and _ are matched at once; the _s must each
+			// take a character, and the % the rest or any part of it.
+			for len(pattern) > 0 && (pattern[0] == '%' || pattern[0] == '_') {
+				if pattern[0] == '_' {
+					if len(text) == 0 {
+						return false
+					}
+					text = text[1:]
+				}
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 0 {
+				return true
+			}
+			for i := 0; i <= len(text); i++ {
+				if likeMatch(text[i:], pattern) {
+					return true
+				}
+			}
+			return false
+		case '_':
+			if len(text) == 0 {
+				return false
+			}
+		case '\\':
+			if len(pattern) > 1 {
+				pattern = pattern[1:]
+			}
+			fallthrough
+		default:
+			if len(text) == 0 || text[0] != pattern[0] {
+				return false
+			}
+		}
+		text, pattern = text[1:], pattern[1:]
+	}
+	return len(text) == 0
+}
+
+func registerLike() {
+	like := RegisterOperator("~~", "textlike", TextType, TextType, BoolType,
+		func(args ...Datum) (Datum, error) {
+			return Datum(Bool(likeMatch([]rune(string(args[0].(Text))), []rune(string(args[1].(Text)))))), nil
+		})
+	like.Negator = "!~~"
+	notLike := RegisterOperator("!~~", "textnlike", TextType, TextType, BoolType,
+		func(args ...Datum) (Datum, error) {
+			return Datum(Bool(!likeMatch([]rune(string(args[0].(Text))), []rune(string(args[1].(Text)))))), nil
+		})
+	notLike.Negator = "~~"
+}
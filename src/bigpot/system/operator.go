@@ -0,0 +1,223 @@
+package system
+
+import (
+	"strings"
+)
+
+// ProcFunc is the Go implementation of a built-in function.  NULL is
+// represented by a nil Datum.
+type ProcFunc func(args ...Datum) (Datum, error)
+
+// ZonedProcFunc is a built-in function that reads the TimeZone setting of
+// the session, as the date/time functions postgres marks stable.
+type ZonedProcFunc func(tz *TimeZone, args ...Datum) (Datum, error)
+
+// ProcInfo describes a function, as postgres' pg_proc entry.
+type ProcInfo struct {
+	Id       Oid
+	Name     Name
+	ArgTypes []Oid
+	RetType  Oid
+	// A strict function returns NULL on any NULL argument without being
+	// called.
+	Strict bool
+	// Func is nil for a function of ZonedFunc.
+	Func      ProcFunc
+	ZonedFunc ZonedProcFunc
+}
+
+// OperatorInfo describes an operator, as postgres' pg_operator entry.
+// Left is InvalidOid for a prefix operator.  Commutator and Negator are the
+// names of the operators with the argument types swapped and the result
+// inverted respectively, or empty if there is none.
+type OperatorInfo struct {
+	Id         Oid
+	Name       string
+	Left       Oid
+	Right      Oid
+	Result     Oid
+	Commutator string
+	Negator    string
+	Proc       *ProcInfo
+}
+
+type operatorKey struct {
+	name        string
+	left, right Oid
+}
+
+// Registries of the built-in functions and operators, keyed by oid.
+var ProcRegistry = map[Oid]*ProcInfo{}
+var OperatorRegistry = map[Oid]*OperatorInfo{}
+
+var procsByName = map[Name][]*ProcInfo{}
+var operatorsByKey = map[operatorKey]*OperatorInfo{}
+var operatorsByName = map[string][]*OperatorInfo{}
+
+// Oids of built-in functions and operators are assigned in the order of
+// registration from this value.
+const FirstBuiltinProcOid = Oid(5000)
+
+var nextBuiltinOid = FirstBuiltinProcOid
+
+// Registers a strict function.
+func RegisterProc(name string, argTypes []Oid, retType Oid, fn ProcFunc) *ProcInfo {
+	proc := &ProcInfo{
+		Id:       nextBuiltinOid,
+		Name:     Name(name),
+		ArgTypes: argTypes,
+		RetType:  retType,
+		Strict:   true,
+		Func:     fn,
+	}
+	nextBuiltinOid++
+
+	ProcRegistry[proc.Id] = proc
+	procsByName[proc.Name] = append(procsByName[proc.Name], proc)
+	return proc
+}
+
+// Registers a strict function that reads the time zone.
+func RegisterZonedProc(name string, argTypes []Oid, retType Oid, fn ZonedProcFunc) *ProcInfo {
+	proc := RegisterProc(name, argTypes, retType, nil)
+	proc.ZonedFunc = fn
+	return proc
+}
+
+// Registers an operator along with its underlying function procName.
+// Pass InvalidOid as left for a prefix operator.
+func RegisterOperator(name, procName string, left, right, result Oid, fn ProcFunc) *OperatorInfo {
+	argTypes := []Oid{left, right}
+	if left == InvalidOid {
+		argTypes = []Oid{right}
+	}
+	return registerOperator(name, left, right, result, RegisterProc(procName, argTypes, result, fn))
+}
+
+// Registers an operator whose function reads the time zone.
+func RegisterZonedOperator(name, procName string, left, right, result Oid, fn ZonedProcFunc) *OperatorInfo {
+	argTypes := []Oid{left, right}
+	if left == InvalidOid {
+		argTypes = []Oid{right}
+	}
+	return registerOperator(name, left, right, result, RegisterZonedProc(procName, argTypes, result, fn))
+}
+
+func registerOperator(name string, left, right, result Oid, proc *ProcInfo) *OperatorInfo {
+	opr := &OperatorInfo{
+		Id:     nextBuiltinOid,
+		Name:   name,
+		Left:   left,
+		Right:  right,
+		Result: result,
+		Proc:   proc,
+	}
+	nextBuiltinOid++
+
+	OperatorRegistry[opr.Id] = opr
+	operatorsByKey[operatorKey{name, left, right}] = opr
+	operatorsByName[name] = append(operatorsByName[name], opr)
+	return opr
+}
+
+// Returns the operator of the exact argument types.  Pass InvalidOid as
+// left for a prefix operator.
+func LookupOperator(name string, left, right Oid) (*OperatorInfo, error) {
+	if opr, ok := operatorsByKey[operatorKey{name, left, right}]; ok {
+		return opr, nil
+	}
+	if left == InvalidOid {
+		return nil, Ereport(UndefinedFunction,
+			"operator does not exist: %s %s", name, FormatType(right))
+	}
+	return nil, Ereport(UndefinedFunction,
+		"operator does not exist: %s %s %s",
+		FormatType(left), name, FormatType(right))
+}
+
+// Returns all the operators of the name, for the caller to resolve
+// overloads with type coercion.
+func LookupOperatorCandidates(name string) []*OperatorInfo {
+	return operatorsByName[name]
+}
+
+// Returns the function of the exact argument types.
+func LookupProc(name string, argTypes []Oid) (*ProcInfo, error) {
+	for _, proc := range procsByName[Name(strings.ToLower(name))] {
+		if sameTypes(proc.ArgTypes, argTypes) {
+			return proc, nil
+		}
+	}
+	names := make([]string, len(argTypes))
+	for i, typid := range argTypes {
+		names[i] = FormatType(typid)
+	}
+	return nil, Ereport(UndefinedFunction, "function %s(%s) does not exist",
+		name, strings.Join(names, ", "))
+}
+
+// Returns all the functions of the name, for the caller to resolve
+// overloads with type coercion.
+func LookupProcCandidates(name string) []*ProcInfo {
+	return procsByName[Name(strings.ToLower(name))]
+}
+
+// Returns the function of the cast from the type source to the type
+// target, as the castfunc of postgres' pg_cast, or nil if the value is
+// cast through the output and input functions of the types.
+func LookupCastFunc(source, target Oid) *ProcInfo {
+	proc, err := LookupProc(FormatType(target), []Oid{source})
+	if err != nil {
+		return nil
+	}
+	return proc
+}
+
+func sameTypes(a, b []Oid) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Calls the function, in UTC if it reads the time zone.
+func (proc *ProcInfo) Call(args ...Datum) (Datum, error) {
+	return proc.CallInZone(UTC, args...)
+}
+
+// Calls the function in the time zone of the session, returning NULL
+// without calling it if the function is strict and any argument is NULL.
+func (proc *ProcInfo) CallInZone(tz *TimeZone, args ...Datum) (Datum, error) {
+	if proc.Strict {
+		for _, arg := range args {
+			if arg == nil {
+				return nil, nil
+			}
+		}
+	}
+	if proc.ZonedFunc != nil {
+		return proc.ZonedFunc(tz, args...)
+	}
+	return proc.Func(args...)
+}
+
+// Returns the commutator operator, or nil if there is none.
+func (opr *OperatorInfo) CommutatorOperator() *OperatorInfo {
+	if opr.Commutator == "" {
+		return nil
+	}
+	return operatorsByKey[operatorKey{opr.Commutator, opr.Right, opr.Left}]
+}
+
+// Returns the negator operator, or nil if there is none.
+func (opr *OperatorInfo) NegatorOperator() *OperatorInfo {
+	if opr.Negator == "" {
+		return nil
+	}
+	return operatorsByKey[operatorKey{opr.Negator, opr.Left, opr.Right}]
+}
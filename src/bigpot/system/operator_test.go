@@ -0,0 +1,270 @@
+package system
+
+import (
+	"bytes"
+	. "launchpad.net/gocheck"
+	"math"
+	"sort"
+)
+
+func (s *MySuite) TestLookupCompare(c *C) {
+	cmp, err := LookupCompare(Int4Type, Int4Type)
+	c.Assert(err, IsNil)
+	c.Check(cmp(Int4(1), Int4(2)) < 0, Equals, true)
+	c.Check(cmp(Int4(2), Int4(2)), Equals, 0)
+	c.Check(cmp(Int4(-1), Int4(-2)) > 0, Equals, true)
+
+	cmp, err = LookupCompare(NameType, NameType)
+	c.Assert(err, IsNil)
+	c.Check(cmp(Name("bp_attribute"), Name("bp_class")) < 0, Equals, true)
+
+	cmp, err = LookupCompare(TidType, TidType)
+	c.Assert(err, IsNil)
+	c.Check(cmp(MakeItemPointer(1, 5), MakeItemPointer(2, 1)) < 0, Equals, true)
+	c.Check(cmp(MakeItemPointer(2, 5), MakeItemPointer(2, 1)) > 0, Equals, true)
+
+	_, err = LookupCompare(Int4Type, NameType)
+	c.Check(err, ErrorMatches, "could not identify a comparison function for types int4 and name")
+}
+
+func (s *MySuite) TestCompareSort(c *C) {
+	values := []Datum{
+		Float8(math.NaN()), Float8(1.5), Float8(math.Inf(-1)),
+		Float8(math.Inf(1)), Float8(-2),
+	}
+	cmp, _ := LookupCompare(Float8Type, Float8Type)
+	sort.Slice(values, func(i, j int) bool {
+		return cmp(values[i], values[j]) < 0
+	})
+	var out []string
+	for _, val := range values {
+		out = append(out, val.ToString())
+	}
+	c.Check(out, DeepEquals, []string{"-Infinity", "-2", "1.5", "Infinity", "NaN"})
+}
+
+func (s *MySuite) TestFloat8NaN(c *C) {
+	nan := Float8(math.NaN())
+	c.Check(nan.Equals(Float8(math.NaN())), Equals, true)
+	c.Check(nan.Equals(Float8(math.Inf(1))), Equals, false)
+	c.Check(Float8(0).Equals(Float8(math.Copysign(0, -1))), Equals, true)
+
+	// NaN is above everything, as the comparison puts it
+	call := func(name string, left, right Datum) Datum {
+		opr, err := LookupOperator(name, Float8Type, Float8Type)
+		c.Assert(err, IsNil)
+		res, err := opr.Proc.Call(left, right)
+		c.Assert(err, IsNil)
+		return res
+	}
+	for _, name := range []string{"=", ">", ">="} {
+		c.Check(call(name, nan, Float8(math.NaN())), Equals, Bool(name != ">"))
+		c.Check(call(name, nan, Float8(math.Inf(1))), Equals, Bool(name != "="))
+	}
+	arr := MakeArray(Float8Type, nan, Float8(1))
+	c.Check(arr.Equals(MakeArray(Float8Type, Float8(math.NaN()), Float8(1))), Equals, true)
+
+	hash, _ := LookupHash(Float8Type)
+	c.Check(hash(nan), Equals, hash(Float8(-math.NaN())))
+}
+
+func (s *MySuite) TestCompareCrossType(c *C) {
+	date, _ := DatumFromString("2014-10-20", DateType)
+	ts, _ := DatumFromString("2014-10-20 00:00:01", TimestampType)
+
+	cmp, err := LookupCompare(DateType, TimestampType)
+	c.Assert(err, IsNil)
+	c.Check(cmp(date, ts) < 0, Equals, true)
+	cmp, err = LookupCompare(TimestampType, DateType)
+	c.Assert(err, IsNil)
+	c.Check(cmp(ts, date) > 0, Equals, true)
+
+	day, _ := DatumFromString("1 day", IntervalType)
+	hours, _ := DatumFromString("24 hours", IntervalType)
+	month, _ := DatumFromString("1 mon", IntervalType)
+	cmp, _ = LookupCompare(IntervalType, IntervalType)
+	c.Check(cmp(day, hours), Equals, 0)
+	c.Check(cmp(month, day) > 0, Equals, true)
+}
+
+func (s *MySuite) TestLookupHash(c *C) {
+	hash, err := LookupHash(IntervalType)
+	c.Assert(err, IsNil)
+	day, _ := DatumFromString("1 day", IntervalType)
+	hours, _ := DatumFromString("24 hours", IntervalType)
+	c.Check(hash(day), Equals, hash(hours))
+
+	hash, err = LookupHash(Float8Type)
+	c.Assert(err, IsNil)
+	c.Check(hash(Float8(0)), Equals, hash(Float8(math.Copysign(0, -1))))
+
+	hash, err = LookupHash(Int4Type)
+	c.Assert(err, IsNil)
+	c.Check(hash(Int4(1)), Not(Equals), hash(Int4(2)))
+
+	hash, err = LookupHash(TextType)
+	c.Assert(err, IsNil)
+	c.Check(hash(Text("abc")), Equals, hash(Text("abc")))
+}
+
+func (s *MySuite) TestLookupOperator(c *C) {
+	opr, err := LookupOperator("<", Int4Type, Int4Type)
+	c.Assert(err, IsNil)
+	c.Check(opr.Result, Equals, BoolType)
+	c.Check(opr.Proc.Name, Equals, Name("int4lt"))
+	c.Check(ProcRegistry[opr.Proc.Id], Equals, opr.Proc)
+	c.Check(OperatorRegistry[opr.Id], Equals, opr)
+	res, err := opr.Proc.Call(Int4(1), Int4(2))
+	c.Assert(err, IsNil)
+	c.Check(res, Equals, Bool(true))
+
+	c.Check(opr.CommutatorOperator().Name, Equals, ">")
+	c.Check(opr.NegatorOperator().Name, Equals, ">=")
+
+	opr, err = LookupOperator("<=", DateType, TimestampType)
+	c.Assert(err, IsNil)
+	c.Check(opr.Proc.Name, Equals, Name("date_le_timestamp"))
+	c.Check(opr.CommutatorOperator().Proc.Name, Equals, Name("timestamp_ge_date"))
+
+	// strict functions return NULL on NULL input
+	opr, _ = LookupOperator("+", Int4Type, Int4Type)
+	res, err = opr.Proc.Call(Int4(1), nil)
+	c.Assert(err, IsNil)
+	c.Check(res, IsNil)
+
+	_, err = opr.Proc.Call(Int4(math.MaxInt32), Int4(1))
+	c.Check(err, ErrorMatches, "integer out of range")
+	opr, _ = LookupOperator("/", Int4Type, Int4Type)
+	_, err = opr.Proc.Call(Int4(1), Int4(0))
+	c.Check(err, ErrorMatches, "division by zero")
+
+	opr, err = LookupOperator("-", InvalidOid, Int4Type)
+	c.Assert(err, IsNil)
+	res, _ = opr.Proc.Call(Int4(3))
+	c.Check(res, Equals, Int4(-3))
+
+	_, err = LookupOperator("+", NameType, Int4Type)
+	c.Check(err, ErrorMatches, "operator does not exist: name \\+ int4")
+}
+
+func (s *MySuite) TestDateTimeOperators(c *C) {
+	ts, _ := DatumFromString("2014-10-20 12:00", TimestampType)
+	span, _ := DatumFromString("1 mon 2 hours", IntervalType)
+
+	opr, err := LookupOperator("-", TimestampType, IntervalType)
+	c.Assert(err, IsNil)
+	res, err := opr.Proc.Call(ts, span)
+	c.Assert(err, IsNil)
+	c.Check(res.ToString(), Equals, "2014-09-20 10:00:00")
+
+	opr, err = LookupOperator("-", TimestampType, TimestampType)
+	c.Assert(err, IsNil)
+	c.Check(opr.Result, Equals, IntervalType)
+	res, err = opr.Proc.Call(ts, res)
+	c.Assert(err, IsNil)
+	c.Check(res.ToString(), Equals, "30 days 02:00:00")
+
+	opr, err = LookupOperator("*", IntervalType, Float8Type)
+	c.Assert(err, IsNil)
+	res, err = opr.Proc.Call(span, Float8(1.5))
+	c.Assert(err, IsNil)
+	c.Check(res.ToString(), Equals, "1 mon 15 days 03:00:00")
+
+	proc, err := LookupProc("date_trunc", []Oid{TextType, TimestampType})
+	c.Assert(err, IsNil)
+	res, err = proc.Call(Text("month"), ts)
+	c.Assert(err, IsNil)
+	c.Check(res.ToString(), Equals, "2014-10-01 00:00:00")
+
+	proc, err = LookupProc("DATE_PART", []Oid{TextType, TimestampType})
+	c.Assert(err, IsNil)
+	res, err = proc.Call(Text("hour"), ts)
+	c.Assert(err, IsNil)
+	c.Check(res, Equals, Float8(12))
+
+	_, err = LookupProc("date_trunc", []Oid{TextType, Int4Type})
+	c.Check(err, ErrorMatches, "function date_trunc\\(text, int4\\) does not exist")
+	c.Check(len(LookupProcCandidates("date_part")), Equals, 5)
+}
+
+func (s *MySuite) TestBoolTextDatum(c *C) {
+	b, err := DatumFromString("yes", BoolType)
+	c.Assert(err, IsNil)
+	c.Check(b, Equals, Bool(true))
+	c.Check(b.ToString(), Equals, "t")
+	_, err = DatumFromString("maybe", BoolType)
+	c.Check(err, ErrorMatches, "invalid input syntax for type boolean: .*")
+
+	c.Check(TypeRegistry[TextType].IsVarlen(), Equals, true)
+	var buf bytes.Buffer
+	n, err := Text("hello").ToBytes(&buf)
+	c.Assert(err, IsNil)
+	c.Check(n, Equals, 9)
+	c.Check(n, Equals, Text("hello").Len())
+	c.Check(DatumFromBytes(&buf, TextType), Equals, Text("hello"))
+}
+
+func (s *MySuite) TestLikeOperators(c *C) {
+	like, err := LookupOperator("~~", TextType, TextType)
+	c.Assert(err, IsNil)
+	for _, test := range []struct {
+		text, pattern string
+		match         bool
+	}{
+		{"hello", "hello", true},
+		{"hello", "h%", true},
+		{"hello", "%llo", true},
+		{"hello", "h_l%o", true},
+		{"hello", "%_%_%_%_%_%", true},
+		{"hello", "%_%_%_%_%_%_%", false},
+		{"hello", "h_llo_", false},
+		{"", "%", true},
+		{"100%", "100\\%", true},
+		{"1000", "100\\%", false},
+		{"héllo", "h_llo", true},
+	} {
+		result, err := like.Proc.Call(Text(test.text), Text(test.pattern))
+		c.Assert(err, IsNil)
+		c.Check(result, Equals, Datum(Bool(test.match)), Commentf("%s LIKE %s", test.text, test.pattern))
+	}
+	c.Check(like.NegatorOperator().Name, Equals, "!~~")
+	result, err := like.NegatorOperator().Proc.Call(Text("abc"), Text("a%"))
+	c.Assert(err, IsNil)
+	c.Check(result, Equals, Datum(Bool(false)))
+}
+
+func (s *MySuite) TestAggregates(c *C) {
+	aggregate := func(name string, argTypes ...Oid) *AggregateInfo {
+		proc, err := LookupProc(name, argTypes)
+		c.Assert(err, IsNil)
+		agg := LookupAggregate(proc)
+		c.Assert(agg, NotNil, Commentf(name))
+		return agg
+	}
+	run := func(agg *AggregateInfo, values ...Datum) Datum {
+		state := agg.InitValue
+		for _, value := range values {
+			var err error
+			state, err = agg.Advance(state, value)
+			c.Assert(err, IsNil)
+		}
+		result, err := agg.Finalize(state)
+		c.Assert(err, IsNil)
+		return result
+	}
+	values := []Datum{Int4(3), nil, Int4(-1), Int4(4)}
+	// the strict transition functions pass over the NULLs
+	c.Check(run(aggregate("count", AnyType), values...), Equals, Datum(Int4(3)))
+	c.Check(run(aggregate("sum", Int4Type), values...), Equals, Datum(Int4(6)))
+	c.Check(run(aggregate("avg", Int4Type), values...), Equals, Datum(Float8(2)))
+	c.Check(run(aggregate("min", Int4Type), values...), Equals, Datum(Int4(-1)))
+	c.Check(run(aggregate("max", TextType), Text("b"), Text("c"), Text("a")), Equals, Datum(Text("c")))
+	// the result of no rows
+	c.Check(run(aggregate("count", AnyType)), Equals, Datum(Int4(0)))
+	c.Check(run(aggregate("sum", Float8Type)), IsNil)
+	c.Check(run(aggregate("avg", Float8Type)), IsNil)
+
+	_, err := aggregate("sum", Int4Type).Advance(Int4(1<<31-1), Int4(1))
+	c.Check(err, ErrorMatches, "integer out of range")
+	c.Check(LookupAggregate(LookupOperatorCandidates("+")[0].Proc), IsNil)
+}
@@ -15,7 +15,7 @@ type RelFileNode struct {
 
 func RelPath(rnode RelFileNode) string {
 	if rnode.Tsid == GlobalTableSpaceOid {
-		return fmt.Sprintf("base/global/%d", rnode.Relid)
+		return fmt.Sprintf("global/%d", rnode.Relid)
 	} else if rnode.Tsid == DefaultTableSpaceOid {
 		return fmt.Sprintf("base/%d/%d", rnode.Dbid, rnode.Relid)
 	} else {
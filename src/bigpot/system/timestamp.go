@@ -0,0 +1,789 @@
+package system
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+	"time"
+)
+
+// Timestamp is the number of microseconds since 2000-01-01 00:00:00,
+// without time zone.
+type Timestamp int64
+
+// TimestampTz is the number of microseconds since 2000-01-01 00:00:00 UTC.
+// It is displayed in the session time zone.
+type TimestampTz int64
+
+// Interval is a time span kept in three separate fields, since the length
+// of a month or a day is not fixed.
+type Interval struct {
+	Time  int64 // microseconds
+	Day   int32
+	Month int32
+}
+
+const TimestampNoBegin = Timestamp(math.MinInt64)
+const TimestampNoEnd = Timestamp(math.MaxInt64)
+
+// 1970-01-01 00:00:00 in Timestamp.
+const timestampUnixEpoch = Timestamp(-postgresEpochUnixSecs * usecsPerSec)
+
+func (val Timestamp) IsFinite() bool {
+	return val != TimestampNoBegin && val != TimestampNoEnd
+}
+
+func (val Timestamp) ToString() string {
+	switch val {
+	case TimestampNoBegin:
+		return "-infinity"
+	case TimestampNoEnd:
+		return "infinity"
+	}
+	fields := fieldsFromPostgresUsecs(int64(val))
+	return fields.encodeDate() + " " + fields.encodeTime() + fields.encodeEra()
+}
+
+func (val Timestamp) FromString(str string) (Datum, error) {
+	switch specialDateTime(str) {
+	case dtEpoch:
+		return Datum(timestampUnixEpoch), nil
+	case dtLateStart:
+		return Datum(TimestampNoEnd), nil
+	case dtEarlyEnd:
+		return Datum(TimestampNoBegin), nil
+	}
+
+	fields, err := parseDateTime(str)
+	if err != nil {
+		return nil, err
+	}
+	if !fields.hasDate {
+		return nil, Ereport(InvalidDatetimeFormat,
+			"invalid input syntax for type timestamp: \"%s\"", str)
+	}
+	// As postgres, the zone is silently ignored for timestamp without
+	// time zone.
+	usecs := fields.toPostgresUsecs()
+	if !isValidTimestamp(usecs) {
+		return nil, Ereport(DatetimeFieldOverflow,
+			"timestamp out of range: \"%s\"", str)
+	}
+	return Datum(Timestamp(usecs)), nil
+}
+
+func (val Timestamp) ToBytes(writer io.Writer) (int, error) {
+	err := binary.Write(writer, binary.LittleEndian, val)
+	return val.Len(), err
+}
+
+func (val Timestamp) FromBytes(reader io.Reader) Datum {
+	var newval Timestamp
+	if err := binary.Read(reader, binary.LittleEndian, &newval); err != nil {
+		panic("read error")
+	}
+	return Datum(newval)
+}
+
+func (val Timestamp) Equals(other Datum) bool {
+	if oval, ok := other.(Timestamp); ok {
+		return val == oval
+	}
+	return false
+}
+
+func (val Timestamp) Len() int {
+	return 8
+}
+
+// Interprets the timestamp as a wall clock in the time zone.
+func (val Timestamp) ToTimestampTz(tz *TimeZone) (TimestampTz, error) {
+	if !val.IsFinite() {
+		return TimestampTz(val), nil
+	}
+	fields := fieldsFromPostgresUsecs(int64(val))
+	result := fields.toTimestampTz(tz.loc)
+	if !isValidTimestamp(int64(result)) {
+		return 0, Ereport(DatetimeFieldOverflow, "timestamp out of range")
+	}
+	return result, nil
+}
+
+// timestamp + interval.  Months are added first, clamping the day to the
+// end of the resulting month, then days and the time part.
+func (val Timestamp) PlusInterval(span Interval) (Timestamp, error) {
+	if !val.IsFinite() {
+		return val, nil
+	}
+	usecs := int64(val)
+	if span.Month != 0 {
+		fields := fieldsFromPostgresUsecs(usecs)
+		fields.addMonths(int(span.Month))
+		if !fields.isValidDate() {
+			return 0, Ereport(DatetimeFieldOverflow, "timestamp out of range")
+		}
+		usecs = fields.toPostgresUsecs()
+	}
+	usecs, ok := addDaysAndTime(usecs, span)
+	if !ok || !isValidTimestamp(usecs) {
+		return 0, Ereport(DatetimeFieldOverflow, "timestamp out of range")
+	}
+	return Timestamp(usecs), nil
+}
+
+// Adds the day and time fields of span to usecs, reporting false on
+// overflow.
+func addDaysAndTime(usecs int64, span Interval) (int64, bool) {
+	// anything beyond this is out of the timestamp range anyway
+	const maxDays = int64(math.MaxInt64 / usecsPerDay / 2)
+	if abs64(int64(span.Day)) > maxDays || abs64(usecs) > math.MaxInt64/2 {
+		return 0, false
+	}
+	usecs += int64(span.Day) * usecsPerDay
+	if (span.Time > 0 && usecs > math.MaxInt64-span.Time) ||
+		(span.Time < 0 && usecs < math.MinInt64-span.Time) {
+		return 0, false
+	}
+	return usecs + span.Time, true
+}
+
+// timestamp - interval
+func (val Timestamp) MinusInterval(span Interval) (Timestamp, error) {
+	return val.PlusInterval(span.Negate())
+}
+
+// timestamp - timestamp.  The result has whole days justified out of the
+// time part, but never months.
+func (val Timestamp) Minus(other Timestamp) (Interval, error) {
+	if !val.IsFinite() || !other.IsFinite() {
+		return Interval{}, Ereport(DatetimeFieldOverflow,
+			"cannot subtract infinite timestamps")
+	}
+	span := Interval{Time: int64(val) - int64(other)}
+	return span.JustifyHours(), nil
+}
+
+// date_trunc(field, timestamp)
+func (val Timestamp) Trunc(field string) (Timestamp, error) {
+	if !val.IsFinite() {
+		return val, nil
+	}
+	fields := fieldsFromPostgresUsecs(int64(val))
+	if err := fields.trunc(field, "timestamp without time zone"); err != nil {
+		return 0, err
+	}
+	return Timestamp(fields.toPostgresUsecs()), nil
+}
+
+// extract(field from timestamp).  NULL is nil.
+func (val Timestamp) Extract(field string) (Datum, error) {
+	unit := lookupUnit(field)
+	if !val.IsFinite() {
+		return extractInfinite(unit, field, "timestamp without time zone", val == TimestampNoEnd)
+	}
+	if unit == unitEpoch {
+		return Float8(int64(val)-int64(timestampUnixEpoch)) / Float8(usecsPerSec), nil
+	}
+	fields := fieldsFromPostgresUsecs(int64(val))
+	return fields.extract(unit, field, "timestamp without time zone")
+}
+
+func (val TimestampTz) IsFinite() bool {
+	return Timestamp(val).IsFinite()
+}
+
+// Shows the timestamptz in UTC.  Format shows it in the time zone of a
+// session.
+func (val TimestampTz) ToString() string {
+	return val.Format(UTC)
+}
+
+// Returns the text form of the timestamptz as a wall clock in the time
+// zone, with the offset of the zone, as postgres' timestamptz_out.
+func (val TimestampTz) Format(tz *TimeZone) string {
+	if !val.IsFinite() {
+		return Timestamp(val).ToString()
+	}
+	fields, offset := val.localFields(tz)
+	return fields.encodeDate() + " " + fields.encodeTime() +
+		encodeTimeZone(offset) + fields.encodeEra()
+}
+
+// Reads a timestamptz without a zone in UTC.  ParseTimestampTz reads it in
+// the time zone of a session.
+func (val TimestampTz) FromString(str string) (Datum, error) {
+	return ParseTimestampTz(str, UTC)
+}
+
+// Reads the text form of a timestamptz, as postgres' timestamptz_in.  A
+// value without a zone is a wall clock in the time zone tz.
+func ParseTimestampTz(str string, tz *TimeZone) (Datum, error) {
+	switch specialDateTime(str) {
+	case dtEpoch:
+		return Datum(TimestampTz(timestampUnixEpoch)), nil
+	case dtLateStart:
+		return Datum(TimestampTz(TimestampNoEnd)), nil
+	case dtEarlyEnd:
+		return Datum(TimestampTz(TimestampNoBegin)), nil
+	}
+
+	fields, err := parseDateTime(str)
+	if err != nil {
+		return nil, err
+	}
+	if !fields.hasDate {
+		return nil, Ereport(InvalidDatetimeFormat,
+			"invalid input syntax for type timestamp with time zone: \"%s\"", str)
+	}
+
+	var result TimestampTz
+	if fields.hasZone && fields.zone == nil {
+		usecs := fields.toPostgresUsecs() - int64(fields.zoneOffset)*usecsPerSec
+		result = TimestampTz(usecs)
+	} else if fields.hasZone {
+		result = fields.toTimestampTz(fields.zone)
+	} else {
+		result = fields.toTimestampTz(tz.loc)
+	}
+	if !isValidTimestamp(int64(result)) {
+		return nil, Ereport(DatetimeFieldOverflow,
+			"timestamp out of range: \"%s\"", str)
+	}
+	return Datum(result), nil
+}
+
+func (val TimestampTz) ToBytes(writer io.Writer) (int, error) {
+	err := binary.Write(writer, binary.LittleEndian, val)
+	return val.Len(), err
+}
+
+func (val TimestampTz) FromBytes(reader io.Reader) Datum {
+	var newval TimestampTz
+	if err := binary.Read(reader, binary.LittleEndian, &newval); err != nil {
+		panic("read error")
+	}
+	return Datum(newval)
+}
+
+func (val TimestampTz) Equals(other Datum) bool {
+	if oval, ok := other.(TimestampTz); ok {
+		return val == oval
+	}
+	return false
+}
+
+func (val TimestampTz) Len() int {
+	return 8
+}
+
+// Returns the wall clock in the time zone, and the zone's offset from UTC
+// in seconds at that instant.
+func (val TimestampTz) localFields(tz *TimeZone) (*dateTimeFields, int) {
+	t := postgresUsecsToTime(int64(val)).In(tz.loc)
+	_, offset := t.Zone()
+	fields := fieldsFromPostgresUsecs(int64(val) + int64(offset)*usecsPerSec)
+	return fields, offset
+}
+
+// Converts to the wall clock in the time zone.
+func (val TimestampTz) ToTimestamp(tz *TimeZone) (Timestamp, error) {
+	if !val.IsFinite() {
+		return Timestamp(val), nil
+	}
+	fields, _ := val.localFields(tz)
+	return Timestamp(fields.toPostgresUsecs()), nil
+}
+
+// timestamptz + interval.  Months and days are added to the wall clock in
+// the time zone, so that a day across a daylight saving change is still a
+// calendar day.
+func (val TimestampTz) PlusInterval(span Interval, tz *TimeZone) (TimestampTz, error) {
+	if !val.IsFinite() {
+		return val, nil
+	}
+	result := val
+	if span.Month != 0 || span.Day != 0 {
+		fields, _ := val.localFields(tz)
+		fields.addMonths(int(span.Month))
+		fields.addDays(int(span.Day))
+		if !fields.isValidDate() {
+			return 0, Ereport(DatetimeFieldOverflow, "timestamp out of range")
+		}
+		result = fields.toTimestampTz(tz.loc)
+	}
+	usecs, ok := addDaysAndTime(int64(result), Interval{Time: span.Time})
+	result = TimestampTz(usecs)
+	if !ok || !isValidTimestamp(usecs) {
+		return 0, Ereport(DatetimeFieldOverflow, "timestamp out of range")
+	}
+	return result, nil
+}
+
+// timestamptz - interval
+func (val TimestampTz) MinusInterval(span Interval, tz *TimeZone) (TimestampTz, error) {
+	return val.PlusInterval(span.Negate(), tz)
+}
+
+// timestamptz - timestamptz
+func (val TimestampTz) Minus(other TimestampTz) (Interval, error) {
+	return Timestamp(val).Minus(Timestamp(other))
+}
+
+// date_trunc(field, timestamptz), truncating the wall clock in the time
+// zone.
+func (val TimestampTz) Trunc(field string, tz *TimeZone) (TimestampTz, error) {
+	if !val.IsFinite() {
+		return val, nil
+	}
+	fields, _ := val.localFields(tz)
+	if err := fields.trunc(field, "timestamp with time zone"); err != nil {
+		return 0, err
+	}
+	return fields.toTimestampTz(tz.loc), nil
+}
+
+// extract(field from timestamptz), of the wall clock in the time zone.
+// NULL is nil.
+func (val TimestampTz) Extract(field string, tz *TimeZone) (Datum, error) {
+	unit := lookupUnit(field)
+	if !val.IsFinite() {
+		return extractInfinite(unit, field, "timestamp with time zone", Timestamp(val) == TimestampNoEnd)
+	}
+	fields, offset := val.localFields(tz)
+	switch unit {
+	case unitEpoch:
+		return Float8(int64(val)-int64(timestampUnixEpoch)) / Float8(usecsPerSec), nil
+	case unitTimeZone:
+		return Float8(offset), nil
+	case unitTimeZoneHour:
+		return Float8(offset / 3600), nil
+	case unitTimeZoneMinute:
+		return Float8(offset / 60 % 60), nil
+	}
+	return fields.extract(unit, field, "timestamp with time zone")
+}
+
+// Converts the wall clock in fields to an absolute time in the given zone.
+func (fields *dateTimeFields) toTimestampTz(loc *time.Location) TimestampTz {
+	t := time.Date(fields.year, time.Month(fields.month), fields.day,
+		fields.hour, fields.min, fields.sec, fields.usec*1000, loc)
+	return TimestampTz(timeToPostgresUsecs(t))
+}
+
+func (fields *dateTimeFields) isValidDate() bool {
+	usecs := daysFromCivil(fields.year, fields.month, fields.day) * usecsPerDay
+	return isValidTimestamp(usecs)
+}
+
+// Adds months to the date, clamping the day to the last day of the
+// resulting month.
+func (fields *dateTimeFields) addMonths(months int) {
+	if months == 0 {
+		return
+	}
+	month := fields.year*monthsPerYear + fields.month - 1 + months
+	fields.year = int(floorDiv(int64(month), monthsPerYear))
+	fields.month = month - fields.year*monthsPerYear + 1
+	if last := daysInMonth(fields.year, fields.month); fields.day > last {
+		fields.day = last
+	}
+}
+
+func (fields *dateTimeFields) addDays(days int) {
+	if days == 0 {
+		return
+	}
+	t := time.Date(fields.year, time.Month(fields.month), fields.day+days,
+		0, 0, 0, 0, time.UTC)
+	fields.year, fields.month, fields.day = t.Year(), int(t.Month()), t.Day()
+}
+
+// Day of week, 0 for Sunday.
+func (fields *dateTimeFields) dayOfWeek() int {
+	days := daysFromCivil(fields.year, fields.month, fields.day)
+	// 2000-01-01 was a Saturday
+	return int((days%7 + 7 + 6) % 7)
+}
+
+func unrecognizedUnit(field, typeName string) error {
+	return Ereport(InvalidParameterValue,
+		"unit \"%s\" not recognized for type %s", field, typeName)
+}
+
+// Truncates the fields to the precision given by the field name, as
+// date_trunc() does.
+func (fields *dateTimeFields) trunc(field, typeName string) error {
+	unit := lookupUnit(field)
+	switch unit {
+	case unitMillennium:
+		if fields.year > 0 {
+			fields.year = ((fields.year+999)/1000)*1000 - 999
+		} else {
+			fields.year = -((999-(fields.year-1))/1000)*1000 + 1
+		}
+		fallthrough
+	case unitCentury:
+		if unit == unitCentury {
+			if fields.year > 0 {
+				fields.year = ((fields.year+99)/100)*100 - 99
+			} else {
+				fields.year = -((99-(fields.year-1))/100)*100 + 1
+			}
+		}
+		fallthrough
+	case unitDecade:
+		if unit == unitDecade {
+			if fields.year > 0 {
+				fields.year = (fields.year / 10) * 10
+			} else {
+				fields.year = -((8-(fields.year-1))/10)*10 + 1
+			}
+		}
+		fallthrough
+	case unitYear:
+		fields.month = 1
+		fallthrough
+	case unitQuarter:
+		fields.month = (3 * ((fields.month - 1) / 3)) + 1
+		fallthrough
+	case unitMonth:
+		fields.day = 1
+		fallthrough
+	case unitDay:
+		fields.hour = 0
+		fallthrough
+	case unitHour:
+		fields.min = 0
+		fallthrough
+	case unitMinute:
+		fields.sec = 0
+		fallthrough
+	case unitSecond:
+		fields.usec = 0
+	case unitMillisecond:
+		fields.usec = (fields.usec / 1000) * 1000
+	case unitMicrosecond:
+	case unitWeek:
+		// back to the monday of the ISO week
+		dow := (fields.dayOfWeek() + 6) % 7
+		fields.addDays(-dow)
+		fields.hour, fields.min, fields.sec, fields.usec = 0, 0, 0, 0
+	case unitInvalid:
+		return unrecognizedUnit(field, typeName)
+	default:
+		return Ereport(FeatureNotSupported,
+			"unit \"%s\" not supported for type %s", field, typeName)
+	}
+	return nil
+}
+
+// Returns the named field, as extract() does.  year is reported without
+// year zero, so 1 BC is -1.
+func (fields *dateTimeFields) extract(unit int, field, typeName string) (Float8, error) {
+	year := fields.year
+	switch unit {
+	case unitMicrosecond:
+		return Float8(fields.sec*1000000 + fields.usec), nil
+	case unitMillisecond:
+		return Float8(fields.sec*1000) + Float8(fields.usec)/1000, nil
+	case unitSecond:
+		return Float8(fields.sec) + Float8(fields.usec)/1000000, nil
+	case unitMinute:
+		return Float8(fields.min), nil
+	case unitHour:
+		return Float8(fields.hour), nil
+	case unitDay:
+		return Float8(fields.day), nil
+	case unitMonth:
+		return Float8(fields.month), nil
+	case unitQuarter:
+		return Float8((fields.month-1)/3 + 1), nil
+	case unitWeek, unitIsoYear:
+		t := time.Date(fields.year, time.Month(fields.month), fields.day, 0, 0, 0, 0, time.UTC)
+		isoYear, week := t.ISOWeek()
+		if unit == unitWeek {
+			return Float8(week), nil
+		}
+		if isoYear <= 0 {
+			isoYear--
+		}
+		return Float8(isoYear), nil
+	case unitYear:
+		if year <= 0 {
+			year--
+		}
+		return Float8(year), nil
+	case unitDecade:
+		if year >= 0 {
+			return Float8(year / 10), nil
+		}
+		return Float8(-((8 - (year - 1)) / 10)), nil
+	case unitCentury:
+		if year > 0 {
+			return Float8((year + 99) / 100), nil
+		}
+		return Float8(-((99 - (year - 1)) / 100)), nil
+	case unitMillennium:
+		if year > 0 {
+			return Float8((year + 999) / 1000), nil
+		}
+		return Float8(-((999 - (year - 1)) / 1000)), nil
+	case unitDow:
+		return Float8(fields.dayOfWeek()), nil
+	case unitIsoDow:
+		dow := fields.dayOfWeek()
+		if dow == 0 {
+			dow = 7
+		}
+		return Float8(dow), nil
+	case unitDoy:
+		days := daysFromCivil(fields.year, fields.month, fields.day) -
+			daysFromCivil(fields.year, 1, 1)
+		return Float8(days + 1), nil
+	case unitInvalid:
+		return 0, unrecognizedUnit(field, typeName)
+	}
+	return 0, Ereport(FeatureNotSupported,
+		"unit \"%s\" not supported for type %s", field, typeName)
+}
+
+// extract() from infinity returns infinity for the fields that grow
+// monotonically, and NULL otherwise, as postgres'
+// NonFiniteTimestampTzPart.
+func extractInfinite(unit int, field, typeName string, positive bool) (Datum, error) {
+	switch unit {
+	case unitYear, unitDecade, unitCentury, unitMillennium, unitEpoch,
+		unitIsoYear:
+		if positive {
+			return Float8(math.Inf(1)), nil
+		}
+		return Float8(math.Inf(-1)), nil
+	case unitInvalid:
+		return nil, unrecognizedUnit(field, typeName)
+	}
+	return nil, nil
+}
+
+func (val Interval) ToString() string {
+	var buf strings.Builder
+	isZero, isBefore := true, false
+
+	addPart := func(value int64, units string) {
+		if value == 0 {
+			return
+		}
+		if !isZero {
+			buf.WriteString(" ")
+		}
+		if isBefore && value > 0 {
+			buf.WriteString("+")
+		}
+		plural := "s"
+		if value == 1 {
+			plural = ""
+		}
+		fmt.Fprintf(&buf, "%d %s%s", value, units, plural)
+		isBefore = value < 0
+		isZero = false
+	}
+	addPart(int64(val.Month/monthsPerYear), "year")
+	addPart(int64(val.Month%monthsPerYear), "mon")
+	addPart(int64(val.Day), "day")
+
+	usecs := val.Time
+	hour := usecs / usecsPerHour
+	usecs -= hour * usecsPerHour
+	min := usecs / usecsPerMinute
+	usecs -= min * usecsPerMinute
+	sec := usecs / usecsPerSec
+	usecs -= sec * usecsPerSec
+
+	if isZero || val.Time != 0 {
+		if !isZero {
+			buf.WriteString(" ")
+		}
+		if val.Time < 0 {
+			buf.WriteString("-")
+		} else if isBefore {
+			buf.WriteString("+")
+		}
+		fmt.Fprintf(&buf, "%02d:%02d:%s", abs64(hour), abs64(min),
+			encodeSeconds(int(abs64(sec)), int(abs64(usecs))))
+	}
+	return buf.String()
+}
+
+func abs64(v int64) int64 {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+func (val Interval) FromString(str string) (Datum, error) {
+	span, err := parseInterval(str)
+	if err != nil {
+		return nil, err
+	}
+	return Datum(span), nil
+}
+
+func (val Interval) ToBytes(writer io.Writer) (int, error) {
+	err := binary.Write(writer, binary.LittleEndian, val)
+	return val.Len(), err
+}
+
+func (val Interval) FromBytes(reader io.Reader) Datum {
+	var newval Interval
+	if err := binary.Read(reader, binary.LittleEndian, &newval); err != nil {
+		panic("read error")
+	}
+	return Datum(newval)
+}
+
+// Intervals are equal if they span the same length of time, with a month
+// counting as 30 days and a day as 24 hours, so '1 day' equals '24 hours'.
+func (val Interval) Equals(other Datum) bool {
+	if oval, ok := other.(Interval); ok {
+		days1, time1 := val.cmpValue()
+		days2, time2 := oval.cmpValue()
+		return days1 == days2 && time1 == time2
+	}
+	return false
+}
+
+func (val Interval) Len() int {
+	return 16
+}
+
+// Returns the span normalized into whole days and the remaining
+// microseconds, which orders intervals the way postgres does without
+// overflowing int64.
+func (val Interval) cmpValue() (int64, int64) {
+	days := int64(val.Month)*daysPerMonth + int64(val.Day)
+	days += floorDiv(val.Time, usecsPerDay)
+	return days, val.Time - floorDiv(val.Time, usecsPerDay)*usecsPerDay
+}
+
+// - interval
+func (val Interval) Negate() Interval {
+	return Interval{Time: -val.Time, Day: -val.Day, Month: -val.Month}
+}
+
+// interval + interval
+func (val Interval) Plus(other Interval) (Interval, error) {
+	month := int64(val.Month) + int64(other.Month)
+	day := int64(val.Day) + int64(other.Day)
+	usecs := val.Time + other.Time
+	if month != int64(int32(month)) || day != int64(int32(day)) ||
+		(val.Time > 0 && other.Time > 0 && usecs < 0) ||
+		(val.Time < 0 && other.Time < 0 && usecs >= 0) {
+		return Interval{}, Ereport(DatetimeFieldOverflow, "interval out of range")
+	}
+	return Interval{Time: usecs, Day: int32(day), Month: int32(month)}, nil
+}
+
+// interval - interval
+func (val Interval) Minus(other Interval) (Interval, error) {
+	return val.Plus(other.Negate())
+}
+
+// interval * float8.  Fractional months and days cascade down into the
+// smaller fields.
+func (val Interval) Mul(factor float64) (Interval, error) {
+	months := float64(val.Month) * factor
+	wholeMonths := math.Trunc(months)
+	days := float64(val.Day)*factor + (months-wholeMonths)*daysPerMonth
+	wholeDays := math.Trunc(days)
+	usecs := math.Round(float64(val.Time)*factor + (days-wholeDays)*float64(usecsPerDay))
+
+	if math.IsNaN(months) || math.Abs(wholeMonths) > math.MaxInt32 ||
+		math.Abs(wholeDays) > math.MaxInt32 || math.Abs(usecs) >= math.MaxInt64 {
+		return Interval{}, Ereport(DatetimeFieldOverflow, "interval out of range")
+	}
+	return Interval{
+		Time:  int64(usecs),
+		Day:   int32(wholeDays),
+		Month: int32(wholeMonths),
+	}, nil
+}
+
+// justify_hours(interval): moves whole days out of the time part.
+func (val Interval) JustifyHours() Interval {
+	result := val
+	wholeDay := result.Time / usecsPerDay
+	result.Time -= wholeDay * usecsPerDay
+	result.Day += int32(wholeDay)
+
+	if result.Day > 0 && result.Time < 0 {
+		result.Time += usecsPerDay
+		result.Day--
+	} else if result.Day < 0 && result.Time > 0 {
+		result.Time -= usecsPerDay
+		result.Day++
+	}
+	return result
+}
+
+// justify_days(interval): moves whole months out of the day part.
+func (val Interval) JustifyDays() Interval {
+	result := val
+	wholeMonth := result.Day / daysPerMonth
+	result.Day -= wholeMonth * daysPerMonth
+	result.Month += wholeMonth
+
+	if result.Month > 0 && result.Day < 0 {
+		result.Day += daysPerMonth
+		result.Month--
+	} else if result.Month < 0 && result.Day > 0 {
+		result.Day -= daysPerMonth
+		result.Month++
+	}
+	return result
+}
+
+// extract(field from interval)
+func (val Interval) Extract(field string) (Datum, error) {
+	unit := lookupUnit(field)
+	usecs := val.Time
+	year := int64(val.Month / monthsPerYear)
+	switch unit {
+	case unitMicrosecond:
+		return Float8(usecs % usecsPerMinute), nil
+	case unitMillisecond:
+		return Float8(usecs%usecsPerMinute) / 1000, nil
+	case unitSecond:
+		return Float8(usecs%usecsPerMinute) / Float8(usecsPerSec), nil
+	case unitMinute:
+		return Float8(usecs % usecsPerHour / usecsPerMinute), nil
+	case unitHour:
+		return Float8(usecs / usecsPerHour), nil
+	case unitDay:
+		return Float8(val.Day), nil
+	case unitMonth:
+		return Float8(val.Month % monthsPerYear), nil
+	case unitQuarter:
+		return Float8(val.Month%monthsPerYear/3 + 1), nil
+	case unitYear:
+		return Float8(year), nil
+	case unitDecade:
+		return Float8(year / 10), nil
+	case unitCentury:
+		return Float8(year / 100), nil
+	case unitMillennium:
+		return Float8(year / 1000), nil
+	case unitEpoch:
+		// a year is 365.25 days here, as postgres does
+		secs := Float8(usecs) / Float8(usecsPerSec)
+		secs += Float8(year) * 365.25 * Float8(secsPerDay)
+		secs += Float8(val.Month%monthsPerYear) * daysPerMonth * Float8(secsPerDay)
+		secs += Float8(val.Day) * Float8(secsPerDay)
+		return secs, nil
+	case unitInvalid:
+		return nil, unrecognizedUnit(field, "interval")
+	}
+	return nil, Ereport(FeatureNotSupported,
+		"unit \"%s\" not supported for type interval", field)
+}
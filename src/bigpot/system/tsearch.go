@@ -0,0 +1,49 @@
+package system
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Returns the lexemes of the text: its words, lowercased, sorted and
+// without duplicates.  This is a much simplified to_tsvector, with no
+// dictionaries, stemming or stop words.
+func TextLexemes(val Text) []Text {
+	words := strings.FieldsFunc(strings.ToLower(string(val)), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	sort.Strings(words)
+	var lexemes []Text
+	for i, word := range words {
+		if i == 0 || word != words[i-1] {
+			lexemes = append(lexemes, Text(word))
+		}
+	}
+	return lexemes
+}
+
+// Returns true if the document has all the lexemes of the query, as
+// postgres' ts_match_tt with an AND of all the query words.  A query
+// without lexemes matches nothing.
+func textSearchMatch(document, query Text) bool {
+	lexemes := TextLexemes(document)
+	qlexemes := TextLexemes(query)
+	if len(qlexemes) == 0 {
+		return false
+	}
+	for _, lexeme := range qlexemes {
+		i := sort.Search(len(lexemes), func(i int) bool { return lexemes[i] >= lexeme })
+		if i == len(lexemes) || lexemes[i] != lexeme {
+			return false
+		}
+	}
+	return true
+}
+
+func registerTextSearch() {
+	RegisterOperator("@@", "ts_match_tt", TextType, TextType, BoolType,
+		func(args ...Datum) (Datum, error) {
+			return Datum(Bool(textSearchMatch(args[0].(Text), args[1].(Text)))), nil
+		})
+}
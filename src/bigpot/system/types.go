@@ -3,7 +3,9 @@ package system
 import (
 	"encoding/binary"
 	"io"
+	"math"
 	"strconv"
+	"strings"
 	"unsafe"
 )
 
@@ -15,8 +17,22 @@ type Oid uint32
 
 const InvalidOid Oid = 0
 
+// The first oid assigned to the objects users create.  Those below are
+// assigned by hand to the built-in objects, as postgres'
+// FirstNormalObjectId.
+const FirstNormalObjectId Oid = 16384
+
 type Int4 int32
 
+type Float8 float64
+
+type Bool bool
+
+// Char is the single-byte "char" type of the catalogs, as postgres' char.
+type Char byte
+
+type Text string
+
 var BoolType Oid = 16
 var ByteType Oid = 17
 var CharType Oid = 18
@@ -28,6 +44,26 @@ var TextType Oid = 25
 var OidType Oid = 26
 var TidType Oid = 27
 var XidType Oid = 28
+var Float8Type Oid = 701
+var DateType Oid = 1082
+var TimeType Oid = 1083
+var TimestampType Oid = 1114
+var TimestampTzType Oid = 1184
+var IntervalType Oid = 1186
+var Int4ArrayType Oid = 1007
+var TextArrayType Oid = 1009
+var OidArrayType Oid = 1028
+var Float8ArrayType Oid = 1022
+
+// UnknownType is the type of a string literal until the parser coerces it
+// to the type its use calls for, as postgres' UNKNOWNOID.  No value is
+// stored as of it, so it is not in the registry.
+var UnknownType Oid = 705
+
+// AnyType is the type of an argument of a function that takes a value of
+// any type, as postgres' ANYOID.  It is a pseudo-type, not in the registry
+// either.
+var AnyType Oid = 2276
 
 type Datum interface {
 	ToString() string
@@ -43,9 +79,29 @@ type TypeInfo struct {
 	Name Name
 	Len  int16
 	Zero Datum
+	// the element type of an array type, as postgres' typelem
+	Elem Oid
 }
 
 var TypeRegistry = map[Oid]*TypeInfo{
+	BoolType: &TypeInfo{
+		Id:   BoolType,
+		Name: Name("bool"),
+		Len:  int16(unsafe.Sizeof(Bool(false))),
+		Zero: Bool(false),
+	},
+	CharType: &TypeInfo{
+		Id:   CharType,
+		Name: Name("char"),
+		Len:  int16(unsafe.Sizeof(Char(0))),
+		Zero: Char(0),
+	},
+	TextType: &TypeInfo{
+		Id:   TextType,
+		Name: Name("text"),
+		Len:  -1,
+		Zero: Text(""),
+	},
 	OidType: &TypeInfo{
 		Id:   OidType,
 		Name: Name("oid"),
@@ -70,12 +126,86 @@ var TypeRegistry = map[Oid]*TypeInfo{
 		Len:  NameLen,
 		Zero: Name(""),
 	},
+	Float8Type: &TypeInfo{
+		Id:   Float8Type,
+		Name: Name("float8"),
+		Len:  int16(unsafe.Sizeof(Float8(0))),
+		Zero: Float8(0),
+	},
+	DateType: &TypeInfo{
+		Id:   DateType,
+		Name: Name("date"),
+		Len:  int16(unsafe.Sizeof(Date(0))),
+		Zero: Date(0),
+	},
+	TimeType: &TypeInfo{
+		Id:   TimeType,
+		Name: Name("time"),
+		Len:  int16(unsafe.Sizeof(Time(0))),
+		Zero: Time(0),
+	},
+	TimestampType: &TypeInfo{
+		Id:   TimestampType,
+		Name: Name("timestamp"),
+		Len:  int16(unsafe.Sizeof(Timestamp(0))),
+		Zero: Timestamp(0),
+	},
+	TimestampTzType: &TypeInfo{
+		Id:   TimestampTzType,
+		Name: Name("timestamptz"),
+		Len:  int16(unsafe.Sizeof(TimestampTz(0))),
+		Zero: TimestampTz(0),
+	},
+	IntervalType: &TypeInfo{
+		Id:   IntervalType,
+		Name: Name("interval"),
+		Len:  int16(unsafe.Sizeof(Interval{})),
+		Zero: Interval{},
+	},
+	Int4ArrayType: &TypeInfo{
+		Id:   Int4ArrayType,
+		Name: Name("_int4"),
+		Len:  -1,
+		Zero: Array{ElemType: Int4Type},
+		Elem: Int4Type,
+	},
+	TextArrayType: &TypeInfo{
+		Id:   TextArrayType,
+		Name: Name("_text"),
+		Len:  -1,
+		Zero: Array{ElemType: TextType},
+		Elem: TextType,
+	},
+	OidArrayType: &TypeInfo{
+		Id:   OidArrayType,
+		Name: Name("_oid"),
+		Len:  -1,
+		Zero: Array{ElemType: OidType},
+		Elem: OidType,
+	},
+	Float8ArrayType: &TypeInfo{
+		Id:   Float8ArrayType,
+		Name: Name("_float8"),
+		Len:  -1,
+		Zero: Array{ElemType: Float8Type},
+		Elem: Float8Type,
+	},
 }
 
 func (typ *TypeInfo) IsVarlen() bool {
 	return typ.Len == -1
 }
 
+// Returns the type named name.
+func LookupTypeByName(name string) (*TypeInfo, error) {
+	for _, typ := range TypeRegistry {
+		if string(typ.Name) == name {
+			return typ, nil
+		}
+	}
+	return nil, Ereport(UndefinedObject, "type \"%s\" does not exist", name)
+}
+
 func DatumFromString(str string, typid Oid) (Datum, error) {
 	if entry, ok := TypeRegistry[typid]; ok {
 		return entry.Zero.FromString(str)
@@ -84,6 +214,24 @@ func DatumFromString(str string, typid Oid) (Datum, error) {
 	panic("unknown type")
 }
 
+// Reads the text form of a value of the type, as DatumFromString, reading
+// a timestamptz without a zone in the time zone of the session.
+func DatumFromStringInZone(str string, typid Oid, tz *TimeZone) (Datum, error) {
+	if typid == TimestampTzType {
+		return ParseTimestampTz(str, tz)
+	}
+	return DatumFromString(str, typid)
+}
+
+// Returns the text form of the value, showing a timestamptz in the time
+// zone of the session.
+func DatumToStringInZone(val Datum, tz *TimeZone) string {
+	if tstz, ok := val.(TimestampTz); ok {
+		return tstz.Format(tz)
+	}
+	return val.ToString()
+}
+
 func DatumFromBytes(reader io.Reader, typid Oid) Datum {
 	if entry, ok := TypeRegistry[typid]; ok {
 		return entry.Zero.FromBytes(reader)
@@ -140,10 +288,9 @@ func (val Oid) ToString() string {
 }
 
 func (val Oid) FromString(str string) (Datum, error) {
-	num, err := strconv.ParseUint(str, 10, 0)
+	num, err := strconv.ParseUint(strings.TrimSpace(str), 10, 32)
 	if err != nil {
-		err2 := err.(*strconv.NumError)
-		return nil, Ereport(InvalidTextRepresentation, "%s", err2.Err)
+		return nil, intInputError(err, str, "oid")
 	}
 	return Datum(Oid(num)), nil
 }
@@ -177,14 +324,24 @@ func (val Int4) ToString() string {
 }
 
 func (val Int4) FromString(str string) (Datum, error) {
-	num, err := strconv.ParseInt(str, 10, 0)
+	num, err := strconv.ParseInt(strings.TrimSpace(str), 10, 32)
 	if err != nil {
-		err2 := err.(*strconv.NumError)
-		return nil, Ereport(InvalidTextRepresentation, "%s", err2.Err)
+		return nil, intInputError(err, str, "integer")
 	}
 	return Datum(Int4(num)), nil
 }
 
+// Reports the input of an integer type that strconv failed to read, as
+// postgres' pg_strtoint32.
+func intInputError(err error, str, typname string) error {
+	if err.(*strconv.NumError).Err == strconv.ErrRange {
+		return Ereport(NumericValueOutOfRange,
+			"value \"%s\" is out of range for type %s", str, typname)
+	}
+	return Ereport(InvalidTextRepresentation,
+		"invalid input syntax for type %s: \"%s\"", typname, str)
+}
+
 func (val Int4) ToBytes(writer io.Writer) (int, error) {
 	err := binary.Write(writer, binary.LittleEndian, val)
 	return val.Len(), err
@@ -208,3 +365,184 @@ func (val Int4) Equals(other Datum) bool {
 func (val Int4) Len() int {
 	return 4
 }
+
+func (val Float8) ToString() string {
+	f := float64(val)
+	switch {
+	case math.IsNaN(f):
+		return "NaN"
+	case math.IsInf(f, 1):
+		return "Infinity"
+	case math.IsInf(f, -1):
+		return "-Infinity"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+func (val Float8) FromString(str string) (Datum, error) {
+	switch strings.ToLower(strings.TrimSpace(str)) {
+	case "nan":
+		return Datum(Float8(math.NaN())), nil
+	case "infinity", "+infinity", "inf", "+inf":
+		return Datum(Float8(math.Inf(1))), nil
+	case "-infinity", "-inf":
+		return Datum(Float8(math.Inf(-1))), nil
+	}
+	num, err := strconv.ParseFloat(strings.TrimSpace(str), 64)
+	if err != nil && err.(*strconv.NumError).Err == strconv.ErrRange {
+		return nil, Ereport(NumericValueOutOfRange,
+			"\"%s\" is out of range for type double precision", str)
+	} else if err != nil {
+		return nil, Ereport(InvalidTextRepresentation,
+			"invalid input syntax for type double precision: \"%s\"", str)
+	}
+	return Datum(Float8(num)), nil
+}
+
+func (val Float8) ToBytes(writer io.Writer) (int, error) {
+	err := binary.Write(writer, binary.LittleEndian, val)
+	return val.Len(), err
+}
+
+func (val Float8) FromBytes(reader io.Reader) Datum {
+	var newval Float8
+	if err := binary.Read(reader, binary.LittleEndian, &newval); err != nil {
+		panic("read error")
+	}
+	return Datum(newval)
+}
+
+// Returns true if the values compare equal, as float8eq: NaN equals NaN,
+// and zero equals minus zero.
+func (val Float8) Equals(other Datum) bool {
+	if oval, ok := other.(Float8); ok {
+		return compareFloat8(val, oval) == 0
+	}
+	return false
+}
+
+func (val Float8) Len() int {
+	return 8
+}
+
+func (val Bool) ToString() string {
+	if val {
+		return "t"
+	}
+	return "f"
+}
+
+func (val Bool) FromString(str string) (Datum, error) {
+	switch strings.ToLower(strings.TrimSpace(str)) {
+	case "t", "true", "y", "yes", "on", "1":
+		return Datum(Bool(true)), nil
+	case "f", "false", "n", "no", "off", "0":
+		return Datum(Bool(false)), nil
+	}
+	return nil, Ereport(InvalidTextRepresentation,
+		"invalid input syntax for type boolean: \"%s\"", str)
+}
+
+func (val Bool) ToBytes(writer io.Writer) (int, error) {
+	err := binary.Write(writer, binary.LittleEndian, val)
+	return val.Len(), err
+}
+
+func (val Bool) FromBytes(reader io.Reader) Datum {
+	var newval Bool
+	if err := binary.Read(reader, binary.LittleEndian, &newval); err != nil {
+		panic("read error")
+	}
+	return Datum(newval)
+}
+
+func (val Bool) Equals(other Datum) bool {
+	if oval, ok := other.(Bool); ok {
+		return val == oval
+	}
+	return false
+}
+
+func (val Bool) Len() int {
+	return 1
+}
+
+func (val Char) ToString() string {
+	if val == 0 {
+		return ""
+	}
+	return string([]byte{byte(val)})
+}
+
+func (val Char) FromString(str string) (Datum, error) {
+	if len(str) == 0 {
+		return Datum(Char(0)), nil
+	}
+	return Datum(Char(str[0])), nil
+}
+
+func (val Char) ToBytes(writer io.Writer) (int, error) {
+	return writer.Write([]byte{byte(val)})
+}
+
+func (val Char) FromBytes(reader io.Reader) Datum {
+	b := make([]byte, 1)
+	if n, err := reader.Read(b); n != 1 || err != nil {
+		panic("read error")
+	}
+	return Datum(Char(b[0]))
+}
+
+func (val Char) Equals(other Datum) bool {
+	if oval, ok := other.(Char); ok {
+		return val == oval
+	}
+	return false
+}
+
+func (val Char) Len() int {
+	return 1
+}
+
+// Text is stored as varlena, a 4-byte length word that counts itself,
+// followed by the bytes.
+const VarHdrSz = 4
+
+func (val Text) ToString() string {
+	return string(val)
+}
+
+func (val Text) FromString(str string) (Datum, error) {
+	return Datum(Text(str)), nil
+}
+
+func (val Text) ToBytes(writer io.Writer) (int, error) {
+	if err := binary.Write(writer, binary.LittleEndian, uint32(val.Len())); err != nil {
+		return 0, err
+	}
+	n, err := writer.Write([]byte(val))
+	return n + VarHdrSz, err
+}
+
+func (val Text) FromBytes(reader io.Reader) Datum {
+	var length uint32
+	if err := binary.Read(reader, binary.LittleEndian, &length); err != nil {
+		panic("read error")
+	}
+	b := make([]byte, length-VarHdrSz)
+	if _, err := io.ReadFull(reader, b); err != nil {
+		panic("read error")
+	}
+	return Datum(Text(b))
+}
+
+func (val Text) Equals(other Datum) bool {
+	if oval, ok := other.(Text); ok {
+		return val == oval
+	}
+	return false
+}
+
+func (val Text) Len() int {
+	return VarHdrSz + len(val)
+}
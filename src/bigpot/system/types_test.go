@@ -32,5 +32,5 @@ func (s *MySuite) TestDatumFromStringOid(c *C) {
 	c.Check(oid1, Equals, Oid(42))
 
 	_, err = DatumFromString("-1", OidType)
-	c.Check(err.Error(), Equals, "invalid syntax")
+	c.Check(err.Error(), Equals, "invalid input syntax for type oid: \"-1\"")
 }
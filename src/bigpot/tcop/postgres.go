@@ -0,0 +1,246 @@
+package tcop
+
+import (
+	"fmt"
+
+	"bigpot/access"
+	"bigpot/executor"
+	"bigpot/parser"
+	"bigpot/planner"
+	"bigpot/storage"
+	"bigpot/system"
+)
+
+// QueryResult is what a statement returns to the client, as postgres' command
+// completion tag and the rows sent before it.
+type QueryResult struct {
+	Tag     string
+	Columns *access.TupleDesc
+	Rows    [][]system.Datum
+}
+
+// blockState is where a session stands in a transaction block, as
+// postgres' TBlockState.
+type blockState int
+
+const (
+	blockDefault    blockState = iota // no transaction block
+	blockInProgress                   // inside BEGIN
+	blockAbort                        // inside a failed BEGIN
+)
+
+// Session runs the statements of a client, as a postgres backend.  Each
+// statement runs in a transaction of its own, unless it is inside a
+// BEGIN ... COMMIT block.
+type Session struct {
+	bufMgr   storage.BufferManager
+	vars     *access.TransamVariables
+	queue    *access.SharedInvalQueue
+	dbid     system.Oid
+	relcache *access.RelCache
+	syscache *access.SysCache
+	tx       *access.Transaction
+	block    blockState
+	// the TimeZone setting, and its value at the start of the
+	// transaction, to go back to if it aborts, as postgres' AtEOXact_GUC
+	timeZone      *system.TimeZone
+	savedTimeZone *system.TimeZone
+	// the work_mem setting, in kilobytes, and its value at the start of
+	// the transaction
+	workMem      int
+	savedWorkMem int
+}
+
+// Starts a session in the database dbname, as postgres' InitPostgres.
+func NewSession(bufMgr storage.BufferManager, vars *access.TransamVariables,
+	queue *access.SharedInvalQueue, dbname system.Name) (*Session, error) {
+	dbid, err := access.GetDatabaseOid(dbname, bufMgr)
+	if err != nil {
+		return nil, err
+	}
+	return &Session{
+		bufMgr:   bufMgr,
+		vars:     vars,
+		queue:    queue,
+		dbid:     dbid,
+		relcache: access.NewRelCache(bufMgr, queue, dbid),
+		syscache: access.NewSysCache(bufMgr, queue, dbid),
+		timeZone: system.UTC,
+		workMem:  defaultWorkMem,
+	}, nil
+}
+
+// Aborts the open transaction, if any, and releases the caches.
+func (s *Session) Close() {
+	if s.tx != nil {
+		s.tx.Abort()
+		s.tx = nil
+	}
+	s.relcache.Close()
+	s.syscache.Close()
+}
+
+// Runs the statements of the query string, as postgres'
+// exec_simple_query, and returns the results of those that ran.  It stops
+// at the first error, which aborts the transaction.
+func (s *Session) Exec(query string) ([]*QueryResult, error) {
+	stmts, err := parser.RawParse(query)
+	if err != nil {
+		return nil, s.abortCurrentTransaction(err)
+	}
+	var results []*QueryResult
+	for _, stmt := range stmts {
+		result, err := s.execStatement(stmt)
+		if err != nil {
+			return results, s.abortCurrentTransaction(err)
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+func (s *Session) execStatement(stmt parser.Node) (*QueryResult, error) {
+	if txStmt, ok := stmt.(*parser.TransactionStmt); ok {
+		return s.execTransactionStmt(txStmt)
+	}
+	if s.block == blockAbort {
+		return nil, system.Ereport(system.InFailedSqlTransaction,
+			"current transaction is aborted, commands ignored until end of transaction block")
+	}
+	if err := s.startTransactionCommand(); err != nil {
+		return nil, err
+	}
+	result, err := s.execQuery(stmt)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.commitTransactionCommand(); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (s *Session) execQuery(stmt parser.Node) (*QueryResult, error) {
+	p := parser.NewParser(s.relcache, s.syscache)
+	p.SetTimeZone(s.timeZone)
+	query, err := p.Analyze(stmt)
+	if err != nil {
+		return nil, err
+	}
+	if query.CommandType == parser.CMD_UTILITY {
+		return s.processUtility(query.UtilityStmt)
+	}
+
+	var pl planner.PlannerImpl
+	exec := executor.NewExecutor(pl.Plan(*query), s.tx, s.relcache, s.timeZone, s.workMem)
+	defer exec.End()
+	if err := exec.Start(); err != nil {
+		return nil, err
+	}
+	result := &QueryResult{Columns: exec.TupleDesc}
+	if err := exec.Execute(result); err != nil {
+		return nil, err
+	}
+	switch query.CommandType {
+	case parser.CMD_INSERT:
+		result.Tag = fmt.Sprintf("INSERT 0 %d", exec.Processed)
+	case parser.CMD_UPDATE:
+		result.Tag = fmt.Sprintf("UPDATE %d", exec.Processed)
+	case parser.CMD_DELETE:
+		result.Tag = fmt.Sprintf("DELETE %d", exec.Processed)
+	default:
+		result.Tag = fmt.Sprintf("SELECT %d", len(result.Rows))
+	}
+	return result, nil
+}
+
+// Keeps the row, as postgres' printtup.
+func (result *QueryResult) Receive(tuple access.Tuple) error {
+	row := make([]system.Datum, len(result.Columns.Attrs))
+	for i := range row {
+		row[i] = tuple.Fetch(system.AttrNumber(i + 1))
+	}
+	result.Rows = append(result.Rows, row)
+	return nil
+}
+
+func (s *Session) execTransactionStmt(stmt *parser.TransactionStmt) (*QueryResult, error) {
+	switch stmt.Kind {
+	case parser.TransStmtBegin:
+		if s.block != blockDefault {
+			return nil, system.Ereport(system.ActiveSqlTransaction,
+				"there is already a transaction in progress")
+		}
+		if err := s.startTransactionCommand(); err != nil {
+			return nil, err
+		}
+		s.block = blockInProgress
+		return &QueryResult{Tag: "BEGIN"}, nil
+	case parser.TransStmtCommit:
+		if s.block == blockAbort {
+			// as postgres, COMMIT of a failed block rolls back
+			s.block = blockDefault
+			return &QueryResult{Tag: "ROLLBACK"}, nil
+		}
+		s.block = blockDefault
+		if err := s.commitTransactionCommand(); err != nil {
+			return nil, err
+		}
+		return &QueryResult{Tag: "COMMIT"}, nil
+	case parser.TransStmtRollback:
+		s.block = blockDefault
+		if s.tx != nil {
+			tx := s.tx
+			s.tx = nil
+			s.timeZone, s.workMem = s.savedTimeZone, s.savedWorkMem
+			if err := tx.Abort(); err != nil {
+				return nil, err
+			}
+		}
+		return &QueryResult{Tag: "ROLLBACK"}, nil
+	}
+	panic("unreachable")
+}
+
+// Starts a transaction unless one is open, as postgres'
+// StartTransactionCommand.
+func (s *Session) startTransactionCommand() error {
+	if s.tx != nil {
+		return nil
+	}
+	tx, err := access.BeginTransaction(s.vars, s.queue, s.bufMgr, s.dbid)
+	if err != nil {
+		return err
+	}
+	s.tx = tx
+	s.savedTimeZone, s.savedWorkMem = s.timeZone, s.workMem
+	return nil
+}
+
+// Commits the transaction unless inside a transaction block, as postgres'
+// CommitTransactionCommand.
+func (s *Session) commitTransactionCommand() error {
+	if s.block != blockDefault || s.tx == nil {
+		return nil
+	}
+	tx := s.tx
+	s.tx = nil
+	return tx.Commit()
+}
+
+// Aborts the transaction after err, as postgres'
+// AbortCurrentTransaction.  Inside a transaction block, the block stays
+// until ROLLBACK, failing every statement.  The settings the transaction
+// made are undone.
+func (s *Session) abortCurrentTransaction(err error) error {
+	if s.tx != nil {
+		tx := s.tx
+		s.tx = nil
+		s.timeZone, s.workMem = s.savedTimeZone, s.savedWorkMem
+		tx.Abort()
+	}
+	if s.block == blockInProgress {
+		s.block = blockAbort
+	}
+	return err
+}
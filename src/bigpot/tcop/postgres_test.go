@@ -0,0 +1,1174 @@
+package tcop
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	. "launchpad.net/gocheck"
+	"math"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+
+	"bigpot/access"
+	"bigpot/bootstrap"
+	"bigpot/planner"
+	"bigpot/storage"
+	"bigpot/system"
+)
+
+// Hook up gocheck into the gotest runner.
+func Test(t *testing.T) {
+	TestingT(t)
+}
+
+type MySuite struct{}
+
+var _ = Suite(&MySuite{})
+
+// Runs initdb in a new empty directory and changes to it, returning a
+// session on template1 and the function that closes the session, shuts
+// the cluster down and changes back.
+func newSession(c *C) (*Session, func()) {
+	cwd, err := os.Getwd()
+	c.Assert(err, IsNil)
+	c.Assert(os.Chdir(c.MkDir()), IsNil)
+	var bki bytes.Buffer
+	c.Assert(bootstrap.GenBKI(&bki), IsNil)
+	c.Assert(bootstrap.InitDB(&bki), IsNil)
+
+	bufMgr, err := storage.NewBufferManager(16)
+	c.Assert(err, IsNil)
+	vars, err := access.NewTransamVariables()
+	c.Assert(err, IsNil)
+	s, err := NewSession(bufMgr, vars, access.NewSharedInvalQueue(), "template1")
+	c.Assert(err, IsNil)
+	return s, func() {
+		s.Close()
+		c.Check(bufMgr.Close(), IsNil)
+		os.Chdir(cwd)
+	}
+}
+
+// Returns the file of the table relname, or nil if it is not in bp_class.
+func tableFile(c *C, s *Session, relname string) storage.SmgrRelation {
+	results, err := s.Exec("select relname, relfilenode from bp_class")
+	c.Assert(err, IsNil)
+	for _, row := range results[0].Rows {
+		if row[0] == system.Name(relname) {
+			return storage.NewMdSmgr().GetRelation(system.RelFileNode{
+				Dbid:  s.dbid,
+				Tsid:  system.DefaultTableSpaceOid,
+				Relid: row[1].(system.Oid),
+			})
+		}
+	}
+	return nil
+}
+
+func tags(results []*QueryResult) []string {
+	var tags []string
+	for _, result := range results {
+		tags = append(tags, result.Tag)
+	}
+	return tags
+}
+
+func (s *MySuite) TestCreateTable(c *C) {
+	session, done := newSession(c)
+	defer done()
+
+	results, err := session.Exec("create table t (a int not null, b text, c text[]);")
+	c.Assert(err, IsNil)
+	c.Check(tags(results), DeepEquals, []string{"CREATE TABLE"})
+	file := tableFile(c, session, "t")
+	c.Assert(file, NotNil)
+	c.Check(file.Exists(), Equals, true)
+
+	results, err = session.Exec("select a, b, c from t")
+	c.Assert(err, IsNil)
+	c.Check(results[0].Tag, Equals, "SELECT 0")
+	c.Check(results[0].Columns.Attrs[0].TypeId, Equals, system.Int4Type)
+	c.Check(results[0].Columns.Attrs[1].TypeId, Equals, system.TextType)
+	c.Check(results[0].Columns.Attrs[2].TypeId, Equals, system.TextArrayType)
+
+	_, err = session.Exec("create table t (x int)")
+	c.Check(err, ErrorMatches, "relation \"t\" already exists")
+	c.Check(err.(*system.Error).Code(), Equals, system.DuplicateTable)
+	_, err = session.Exec("create table u (x int, x text)")
+	c.Check(err, ErrorMatches, "column \"x\" specified more than once")
+	_, err = session.Exec("create table u (x nosuchtype)")
+	c.Check(err, ErrorMatches, "type \"nosuchtype\" does not exist")
+	_, err = session.Exec("create table nosuchschema.u (x int)")
+	c.Check(err, ErrorMatches, "schema \"nosuchschema\" does not exist")
+	c.Check(tableFile(c, session, "u"), IsNil)
+}
+
+func (s *MySuite) TestDropTable(c *C) {
+	session, done := newSession(c)
+	defer done()
+
+	_, err := session.Exec("create table t (a int); create table u (a int)")
+	c.Assert(err, IsNil)
+	t, u := tableFile(c, session, "t"), tableFile(c, session, "u")
+	results, err := session.Exec("drop table t, public.u cascade")
+	c.Assert(err, IsNil)
+	c.Check(tags(results), DeepEquals, []string{"DROP TABLE"})
+	c.Check(tableFile(c, session, "t"), IsNil)
+	c.Check(t.Exists(), Equals, false)
+	c.Check(u.Exists(), Equals, false)
+	_, err = session.Exec("select a from t")
+	c.Check(err, ErrorMatches, ".*\"t\" does not exist")
+
+	_, err = session.Exec("drop table t")
+	c.Check(err, ErrorMatches, "table \"t\" does not exist")
+	c.Check(err.(*system.Error).Code(), Equals, system.UndefinedTable)
+	_, err = session.Exec("drop table if exists t")
+	c.Check(err, IsNil)
+	_, err = session.Exec("drop table bp_class")
+	c.Check(err, ErrorMatches, "permission denied: \"bp_class\" is a system catalog")
+}
+
+func (s *MySuite) TestTransactionBlock(c *C) {
+	session, done := newSession(c)
+	defer done()
+
+	// a rolled back CREATE TABLE leaves neither rows nor a file behind
+	results, err := session.Exec("begin; create table t (a int)")
+	c.Assert(err, IsNil)
+	c.Check(tags(results), DeepEquals, []string{"BEGIN", "CREATE TABLE"})
+	file := tableFile(c, session, "t")
+	c.Assert(file, NotNil)
+	c.Check(file.Exists(), Equals, true)
+	_, err = session.Exec("rollback")
+	c.Assert(err, IsNil)
+	c.Check(tableFile(c, session, "t"), IsNil)
+	c.Check(file.Exists(), Equals, false)
+
+	// a rolled back DROP TABLE keeps the table
+	_, err = session.Exec("create table t (a int)")
+	c.Assert(err, IsNil)
+	file = tableFile(c, session, "t")
+	_, err = session.Exec("begin work; drop table t")
+	c.Assert(err, IsNil)
+	c.Check(tableFile(c, session, "t"), IsNil)
+	c.Check(file.Exists(), Equals, true)
+	_, err = session.Exec("rollback transaction")
+	c.Assert(err, IsNil)
+	c.Check(tableFile(c, session, "t"), NotNil)
+	c.Check(file.Exists(), Equals, true)
+
+	// an error fails the block until its end
+	_, err = session.Exec("begin; create table u (a int)")
+	c.Assert(err, IsNil)
+	_, err = session.Exec("create table t (a int)")
+	c.Check(err, ErrorMatches, "relation \"t\" already exists")
+	_, err = session.Exec("select a from t")
+	c.Check(err.(*system.Error).Code(), Equals, system.InFailedSqlTransaction)
+	results, err = session.Exec("commit")
+	c.Assert(err, IsNil)
+	c.Check(tags(results), DeepEquals, []string{"ROLLBACK"})
+	c.Check(tableFile(c, session, "u"), IsNil)
+
+	_, err = session.Exec("begin; begin")
+	c.Check(err.(*system.Error).Code(), Equals, system.ActiveSqlTransaction)
+	results, err = session.Exec("rollback")
+	c.Check(err, IsNil)
+}
+
+func (s *MySuite) TestSyntaxError(c *C) {
+	session, done := newSession(c)
+	defer done()
+
+	_, err := session.Exec("create table (a int)")
+	c.Check(err, ErrorMatches, "syntax error at or near \"\\(\"")
+	_, err = session.Exec("drop table")
+	c.Check(err, ErrorMatches, "syntax error at end of input")
+}
+
+// Inserts the rows into the table relname through the access methods.
+func insertRows(c *C, s *Session, relname string, rows ...[]system.Datum) {
+	relid, err := access.RangeVarGetRelid("", system.Name(relname), s.syscache)
+	c.Assert(err, IsNil)
+	rel, err := s.relcache.HeapOpen(relid)
+	c.Assert(err, IsNil)
+	for _, row := range rows {
+		c.Assert(rel.Insert(access.FormHeapTuple(row, rel.RelDesc), s.bufMgr), IsNil)
+	}
+}
+
+func (s *MySuite) TestAlterTable(c *C) {
+	session, done := newSession(c)
+	defer done()
+
+	_, err := session.Exec("create table t (a int, b text default 'none')")
+	c.Assert(err, IsNil)
+	insertRows(c, session, "t",
+		[]system.Datum{system.Int4(1), system.Text("x")},
+		[]system.Datum{system.Int4(2), nil})
+	file := tableFile(c, session, "t")
+
+	// the rows written before read the default of an added column
+	results, err := session.Exec("alter table t add column c int default 7, add d text not null default 'y'")
+	c.Assert(err, IsNil)
+	c.Check(tags(results), DeepEquals, []string{"ALTER TABLE"})
+	c.Check(tableFile(c, session, "t"), DeepEquals, file)
+	insertRows(c, session, "t", []system.Datum{system.Int4(3), system.Text("z"), system.Int4(8), nil})
+	results, err = session.Exec("select a, b, c, d from t")
+	c.Assert(err, IsNil)
+	c.Check(results[0].Rows, DeepEquals, [][]system.Datum{
+		{system.Int4(1), system.Text("x"), system.Int4(7), system.Text("y")},
+		{system.Int4(2), nil, system.Int4(7), system.Text("y")},
+		{system.Int4(3), system.Text("z"), system.Int4(8), nil},
+	})
+	_, err = session.Exec("alter table t add e int not null")
+	c.Check(err, ErrorMatches, "column \"e\" of relation \"t\" contains null values")
+	_, err = session.Exec("alter table t add a int")
+	c.Check(err, ErrorMatches, "column \"a\" of relation \"t\" already exists")
+	_, err = session.Exec("alter table t add e int default 'x'")
+	c.Check(err.(*system.Error).Code(), Equals, system.InvalidTextRepresentation)
+
+	// a dropped column is no longer seen, and its name can be used again
+	_, err = session.Exec("alter table t drop column b; alter table t drop if exists b")
+	c.Assert(err, IsNil)
+	_, err = session.Exec("select b from t")
+	c.Check(err, NotNil)
+	_, err = session.Exec("alter table t drop b")
+	c.Check(err, ErrorMatches, "column \"b\" of relation \"t\" does not exist")
+	_, err = session.Exec("alter table t add b int")
+	c.Assert(err, IsNil)
+	results, err = session.Exec("select a, c, b from t")
+	c.Assert(err, IsNil)
+	c.Check(results[0].Rows[2], DeepEquals, []system.Datum{system.Int4(3), system.Int4(8), nil})
+
+	_, err = session.Exec("alter table t rename column a to id; alter table t rename to u")
+	c.Assert(err, IsNil)
+	c.Check(tableFile(c, session, "t"), IsNil)
+	_, err = session.Exec("alter table u rename id to c")
+	c.Check(err, ErrorMatches, "column \"c\" of relation \"u\" already exists")
+	_, err = session.Exec("alter table u rename to bp_class")
+	c.Assert(err, IsNil)
+	_, err = session.Exec("alter table public.bp_class rename to u; create table v (a int)")
+	c.Assert(err, IsNil)
+	_, err = session.Exec("alter table u rename to v")
+	c.Check(err, ErrorMatches, "relation \"v\" already exists")
+	_, err = session.Exec("alter table if exists t rename to w")
+	c.Check(err, IsNil)
+	_, err = session.Exec("alter table bp_class add x int")
+	c.Check(err, ErrorMatches, "permission denied: \"bp_class\" is a system catalog")
+
+	// a change of type rewrites the table into a new file
+	results, err = session.Exec("alter table u alter column c type text")
+	c.Assert(err, IsNil)
+	newFile := tableFile(c, session, "u")
+	c.Check(newFile, Not(DeepEquals), file)
+	c.Check(file.Exists(), Equals, false)
+	results, err = session.Exec("select id, c, d from u")
+	c.Assert(err, IsNil)
+	c.Check(results[0].Columns.Attrs[1].TypeId, Equals, system.TextType)
+	c.Check(results[0].Rows, DeepEquals, [][]system.Datum{
+		{system.Int4(1), system.Text("7"), system.Text("y")},
+		{system.Int4(2), system.Text("7"), system.Text("y")},
+		{system.Int4(3), system.Text("8"), nil},
+	})
+	_, err = session.Exec("alter table u alter d set data type int")
+	c.Check(err, ErrorMatches, "default for column \"d\" cannot be cast automatically to type int4")
+	c.Check(tableFile(c, session, "u"), DeepEquals, newFile)
+	c.Check(newFile.Exists(), Equals, true)
+
+	// ALTER TABLE is undone at rollback
+	_, err = session.Exec("begin; alter table u add f int default 1; alter table u alter id type text")
+	c.Assert(err, IsNil)
+	_, err = session.Exec("rollback")
+	c.Assert(err, IsNil)
+	results, err = session.Exec("select id from u")
+	c.Assert(err, IsNil)
+	c.Check(results[0].Columns.Attrs[0].TypeId, Equals, system.Int4Type)
+	_, err = session.Exec("select f from u")
+	c.Check(err, NotNil)
+	c.Check(tableFile(c, session, "u"), DeepEquals, newFile)
+}
+
+func (s *MySuite) TestExpressions(c *C) {
+	session, done := newSession(c)
+	defer done()
+
+	_, err := session.Exec("create table t (a int, b float8, c text, d bool)")
+	c.Assert(err, IsNil)
+	insertRows(c, session, "t",
+		[]system.Datum{system.Int4(1), system.Float8(0.5), system.Text("one"), system.Bool(true)},
+		[]system.Datum{system.Int4(-2), nil, nil, system.Bool(false)},
+		[]system.Datum{nil, system.Float8(2), system.Text("three"), nil})
+
+	results, err := session.Exec("select a * 2 + 1, a + b, abs(a), -a, c || '!', a::text, '7'::int4 % 4 from t")
+	c.Assert(err, IsNil)
+	c.Check(results[0].Columns.Attrs[1].TypeId, Equals, system.Float8Type)
+	c.Check(results[0].Columns.Attrs[4].TypeId, Equals, system.TextType)
+	c.Check(results[0].Rows, DeepEquals, [][]system.Datum{
+		{system.Int4(3), system.Float8(1.5), system.Int4(1), system.Int4(-1), system.Text("one!"), system.Text("1"), system.Int4(3)},
+		{system.Int4(-3), nil, system.Int4(2), system.Int4(2), nil, system.Text("-2"), system.Int4(3)},
+		{nil, nil, nil, nil, system.Text("three!"), nil, system.Int4(3)},
+	})
+
+	// AND, OR and NOT with NULL follow the three-valued logic
+	results, err = session.Exec("select d and a > 0, d or a > 0, not d, d is null, " +
+		"a between -2 and 0, a in (1, null), a not in (5, 6) from t")
+	c.Assert(err, IsNil)
+	c.Check(results[0].Rows, DeepEquals, [][]system.Datum{
+		{system.Bool(true), system.Bool(true), system.Bool(false), system.Bool(false),
+			system.Bool(false), system.Bool(true), system.Bool(true)},
+		{system.Bool(false), system.Bool(false), system.Bool(true), system.Bool(false),
+			system.Bool(true), nil, system.Bool(true)},
+		{nil, nil, nil, system.Bool(true), nil, nil, nil},
+	})
+
+	results, err = session.Exec("select case when a > 0 then 'pos' when a < 0 then 'neg' end, " +
+		"case a when 1 then b else 0 end, coalesce(c, b::text, 'none'), coalesce(b, a), " +
+		"c like 't%', c not like '%e' from t")
+	c.Assert(err, IsNil)
+	c.Check(results[0].Columns.Attrs[0].Name, Equals, system.Name("case"))
+	c.Check(results[0].Columns.Attrs[1].TypeId, Equals, system.Float8Type)
+	c.Check(results[0].Columns.Attrs[3].TypeId, Equals, system.Float8Type)
+	c.Check(results[0].Rows, DeepEquals, [][]system.Datum{
+		{system.Text("pos"), system.Float8(0.5), system.Text("one"), system.Float8(0.5), system.Bool(false), system.Bool(false)},
+		{system.Text("neg"), system.Float8(0), system.Text("none"), system.Float8(-2), nil, nil},
+		{nil, system.Float8(0), system.Text("three"), system.Float8(2), system.Bool(true), system.Bool(false)},
+	})
+
+	_, err = session.Exec("select a / 0 from t")
+	c.Check(err, ErrorMatches, "division by zero")
+	_, err = session.Exec("select c::int4 from t")
+	c.Check(err.(*system.Error).Code(), Equals, system.InvalidTextRepresentation)
+	c.Check(err, ErrorMatches, "invalid input syntax for type integer: \"one\"")
+
+	// the casts with a function of their own; a float8 is rounded half away
+	// from zero
+	results, err = session.Exec("select 1.5::int, 2.5::float8::int, -2.5::int, b::int, d::int, a::bool from t")
+	c.Assert(err, IsNil)
+	c.Check(results[0].Rows, DeepEquals, [][]system.Datum{
+		{system.Int4(2), system.Int4(3), system.Int4(-3), system.Int4(1), system.Int4(1), system.Bool(true)},
+		{system.Int4(2), system.Int4(3), system.Int4(-3), nil, system.Int4(0), system.Bool(true)},
+		{system.Int4(2), system.Int4(3), system.Int4(-3), system.Int4(2), nil, nil},
+	})
+	_, err = session.Exec("select (b * 1e10)::int from t")
+	c.Check(err, ErrorMatches, "integer out of range")
+	_, err = session.Exec("alter table t alter column b type int, alter column d type int")
+	c.Assert(err, IsNil)
+	results, err = session.Exec("select b, d from t")
+	c.Assert(err, IsNil)
+	c.Check(results[0].Rows, DeepEquals, [][]system.Datum{
+		{system.Int4(1), system.Int4(1)}, {nil, system.Int4(0)}, {system.Int4(2), nil},
+	})
+	_, err = session.Exec("alter table t alter column c type int")
+	c.Check(err, ErrorMatches, "invalid input syntax for type integer: \"one\"")
+	_, err = session.Exec("select nosuch(a) from t")
+	c.Check(err, ErrorMatches, "function nosuch\\(int4\\) does not exist")
+	_, err = session.Exec("select case when a then 1 end from t")
+	c.Check(err, ErrorMatches, "argument of CASE/WHEN must be type boolean, not type int4")
+	_, err = session.Exec("select coalesce(a, c) from t")
+	c.Check(err, ErrorMatches, "COALESCE types int4 and text cannot be matched")
+}
+
+func (s *MySuite) TestTimeZone(c *C) {
+	session, done := newSession(c)
+	defer done()
+
+	_, err := session.Exec("create table t (a timestamptz, b timestamp)")
+	c.Assert(err, IsNil)
+	_, err = session.Exec("insert into t values ('2014-03-08 12:00:00+00', '2014-03-08 12:00:00')")
+	c.Assert(err, IsNil)
+	results, err := session.Exec("set TimeZone to '-03:30'")
+	c.Assert(err, IsNil)
+	c.Check(tags(results), DeepEquals, []string{"SET"})
+
+	// output, casts, comparisons and date_trunc are in the session's zone
+	results, err = session.Exec("select a::text, b::timestamptz::text, a = b, " +
+		"date_trunc('day', a)::text from t")
+	c.Assert(err, IsNil)
+	c.Check(results[0].Rows, DeepEquals, [][]system.Datum{
+		{system.Text("2014-03-08 08:30:00-03:30"), system.Text("2014-03-08 12:00:00-03:30"),
+			system.Bool(false), system.Text("2014-03-08 00:00:00-03:30")},
+	})
+	// and so is the input without a zone
+	results, err = session.Exec("select b from t where a = '2014-03-08 08:30:00'")
+	c.Assert(err, IsNil)
+	c.Check(results[0].Rows, HasLen, 1)
+
+	// the setting is the session's own
+	other, err := NewSession(session.bufMgr, session.vars, session.queue, "template1")
+	c.Assert(err, IsNil)
+	defer other.Close()
+	results, err = other.Exec("select a::text, a = b from t")
+	c.Assert(err, IsNil)
+	c.Check(results[0].Rows, DeepEquals, [][]system.Datum{
+		{system.Text("2014-03-08 12:00:00+00"), system.Bool(true)},
+	})
+
+	// a rolled back SET is undone
+	_, err = session.Exec("begin; set timezone = utc; rollback")
+	c.Assert(err, IsNil)
+	results, err = session.Exec("select a::text from t")
+	c.Assert(err, IsNil)
+	c.Check(results[0].Rows[0][0], Equals, system.Text("2014-03-08 08:30:00-03:30"))
+
+	_, err = session.Exec("set timezone to 'No/Such_Zone'")
+	c.Check(err, ErrorMatches, `invalid value for parameter "TimeZone": "No/Such_Zone"`)
+	_, err = session.Exec("set datestyle to iso")
+	c.Check(err, ErrorMatches, `unrecognized configuration parameter "datestyle"`)
+	_, err = session.Exec("set timezone to default")
+	c.Assert(err, IsNil)
+	results, err = session.Exec("select a::text from t")
+	c.Assert(err, IsNil)
+	c.Check(results[0].Rows[0][0], Equals, system.Text("2014-03-08 12:00:00+00"))
+
+	// EXTRACT is date_part, of the hour in the session's zone
+	results, err = session.Exec("set timezone to '+09'; " +
+		"select extract(hour from a), extract('year' from b), date_part('hour', a) from t")
+	c.Assert(err, IsNil)
+	c.Check(results[1].Columns.Attrs[0].Name, Equals, system.Name("date_part"))
+	c.Check(results[1].Rows, DeepEquals, [][]system.Datum{
+		{system.Float8(21), system.Float8(2014), system.Float8(21)},
+	})
+	results, err = session.Exec("select extract(month from 'infinity'::timestamp), " +
+		"extract(year from '-infinity'::timestamptz) from t")
+	c.Assert(err, IsNil)
+	c.Check(results[0].Rows, DeepEquals, [][]system.Datum{{nil, system.Float8(math.Inf(-1))}})
+}
+
+func (s *MySuite) TestWhere(c *C) {
+	session, done := newSession(c)
+	defer done()
+
+	_, err := session.Exec("create table t (a int, b text)")
+	c.Assert(err, IsNil)
+	var rows [][]system.Datum
+	for i := 0; i < 300; i++ {
+		b := system.Datum(system.Text(fmt.Sprintf("row%d", i)))
+		if i%10 == 0 {
+			b = nil
+		}
+		rows = append(rows, []system.Datum{system.Int4(i), b})
+	}
+	insertRows(c, session, "t", rows...)
+
+	count := func(query string) int {
+		results, err := session.Exec(query)
+		c.Assert(err, IsNil, Commentf(query))
+		return len(results[0].Rows)
+	}
+	// scan keys, the rest of the qual, and both
+	c.Check(count("select a from t where a = 42"), Equals, 1)
+	c.Check(count("select a from t where 100 > a and b is not null"), Equals, 90)
+	c.Check(count("select a from t where a >= 290 or a < 5"), Equals, 15)
+	c.Check(count("select a from t where b like 'row1_'"), Equals, 9)
+	c.Check(count("select a from t where a between 10 and 19 and b like '%5'"), Equals, 1)
+	c.Check(count("select a from t where b is null and a % 20 = 0"), Equals, 15)
+	c.Check(count("select a from t where a = null"), Equals, 0)
+	c.Check(count("select a from t where not (a < 295)"), Equals, 5)
+	c.Check(count("select a from t where a in (1, 2, 3) and b <> 'row2'"), Equals, 2)
+
+	results, err := session.Exec("select a, b from t where a > 295 and a <> 298")
+	c.Assert(err, IsNil)
+	c.Check(results[0].Tag, Equals, "SELECT 3")
+	c.Check(results[0].Rows, DeepEquals, [][]system.Datum{
+		{system.Int4(296), system.Text("row296")},
+		{system.Int4(297), system.Text("row297")},
+		{system.Int4(299), system.Text("row299")},
+	})
+
+	_, err = session.Exec("select a from t where b")
+	c.Check(err, ErrorMatches, "argument of WHERE must be type boolean, not type text")
+	_, err = session.Exec("select a from t where 10 / (a - 5) > 1")
+	c.Check(err, ErrorMatches, "division by zero")
+}
+
+func (s *MySuite) TestSelectNames(c *C) {
+	session, done := newSession(c)
+	defer done()
+
+	_, err := session.Exec("create table t (a int, b text, c int)")
+	c.Assert(err, IsNil)
+	_, err = session.Exec("alter table t drop column c")
+	c.Assert(err, IsNil)
+	insertRows(c, session, "t", []system.Datum{system.Int4(1), system.Text("x"), nil})
+
+	names := func(result *QueryResult) []string {
+		var names []string
+		for _, attr := range result.Columns.Attrs {
+			names = append(names, string(attr.Name))
+		}
+		return names
+	}
+	results, err := session.Exec("SELECT *, T.B AS Upper, public.t.a + 1 next FROM T")
+	c.Assert(err, IsNil)
+	c.Check(names(results[0]), DeepEquals, []string{"a", "b", "upper", "next"})
+	c.Check(results[0].Rows, DeepEquals, [][]system.Datum{
+		{system.Int4(1), system.Text("x"), system.Text("x"), system.Int4(2)},
+	})
+
+	results, err = session.Exec("select x.*, x.y * 2 from t as x (y) where x.b = 'x'")
+	c.Assert(err, IsNil)
+	c.Check(names(results[0]), DeepEquals, []string{"y", "b", "?column?"})
+	c.Check(results[0].Rows, DeepEquals, [][]system.Datum{
+		{system.Int4(1), system.Text("x"), system.Int4(2)},
+	})
+	results, err = session.Exec("select c.relname from bp_class c where c.relname = 't'")
+	c.Assert(err, IsNil)
+	c.Check(results[0].Rows, DeepEquals, [][]system.Datum{{system.Name("t")}})
+
+	_, err = session.Exec("select c from t")
+	c.Check(err, ErrorMatches, "column \"c\" does not exist")
+	_, err = session.Exec("select t.a from t x")
+	c.Check(err, ErrorMatches, "missing FROM-clause entry for table \"t\"")
+	_, err = session.Exec("select x.c from t x")
+	c.Check(err, ErrorMatches, "column x.c does not exist")
+	_, err = session.Exec("select a.b.t.a from t")
+	c.Check(err, ErrorMatches, `improper qualified name \(too many dotted names\): a.b.t`)
+	_, err = session.Exec("select a from t, t")
+	c.Check(err, ErrorMatches, "table name \"t\" specified more than once")
+	_, err = session.Exec("select t.* + 1 from t")
+	c.Check(err, ErrorMatches, "row expansion via \"\\*\" is not supported here")
+}
+
+func (s *MySuite) TestInsert(c *C) {
+	session, done := newSession(c)
+	defer done()
+
+	_, err := session.Exec("create table t (a int not null, b text default 'none', c float8, d date)")
+	c.Assert(err, IsNil)
+	results, err := session.Exec("insert into t values (1, 'x', 2, '2020-01-02'), (2, null, 2.5, null)")
+	c.Assert(err, IsNil)
+	c.Check(tags(results), DeepEquals, []string{"INSERT 0 2"})
+	// the columns not named take their defaults
+	results, err = session.Exec("insert into t (c, a) values (1 + 2, 3) returning *, a * 10 as ten")
+	c.Assert(err, IsNil)
+	c.Check(tags(results), DeepEquals, []string{"INSERT 0 1"})
+	c.Check(results[0].Rows, DeepEquals, [][]system.Datum{
+		{system.Int4(3), system.Text("none"), system.Float8(3), nil, system.Int4(30)},
+	})
+
+	date, err := system.DatumFromString("2020-01-02", system.DateType)
+	c.Assert(err, IsNil)
+	results, err = session.Exec("select * from t")
+	c.Assert(err, IsNil)
+	c.Check(results[0].Rows, DeepEquals, [][]system.Datum{
+		{system.Int4(1), system.Text("x"), system.Float8(2), date},
+		{system.Int4(2), nil, system.Float8(2.5), nil},
+		{system.Int4(3), system.Text("none"), system.Float8(3), nil},
+	})
+
+	// the rows a statement inserts are not read by its own scan
+	results, err = session.Exec("insert into t (a, b) select a + 10, b from t where a < 3")
+	c.Assert(err, IsNil)
+	c.Check(tags(results), DeepEquals, []string{"INSERT 0 2"})
+	results, err = session.Exec("insert into t select * from t")
+	c.Assert(err, IsNil)
+	c.Check(tags(results), DeepEquals, []string{"INSERT 0 5"})
+	results, err = session.Exec("select a from t where a > 10")
+	c.Assert(err, IsNil)
+	c.Check(results[0].Rows, HasLen, 4)
+
+	// a dropped column is stored as NULL
+	_, err = session.Exec("alter table t drop column b")
+	c.Assert(err, IsNil)
+	results, err = session.Exec("insert into t values (4, 1, null) returning *")
+	c.Assert(err, IsNil)
+	c.Check(results[0].Rows, DeepEquals, [][]system.Datum{{system.Int4(4), system.Float8(1), nil}})
+
+	// an aborted INSERT leaves nothing behind
+	_, err = session.Exec("begin; insert into t values (5, 0, null); rollback")
+	c.Assert(err, IsNil)
+	results, err = session.Exec("select a from t where a = 5")
+	c.Assert(err, IsNil)
+	c.Check(results[0].Rows, HasLen, 0)
+
+	// without a column list the columns past the values take their
+	// defaults, as do those given DEFAULT
+	_, err = session.Exec("create table u (a int default 1, b text default 'none', c int)")
+	c.Assert(err, IsNil)
+	results, err = session.Exec("insert into u values (2); " +
+		"insert into u values (default, 'x', default), (3, default, 4); " +
+		"insert into u select a + 4, 'y' from u where a = 1; " +
+		"insert into u default values returning *")
+	c.Assert(err, IsNil)
+	c.Check(tags(results), DeepEquals, []string{"INSERT 0 1", "INSERT 0 2", "INSERT 0 1", "INSERT 0 1"})
+	c.Check(results[3].Rows, DeepEquals, [][]system.Datum{{system.Int4(1), system.Text("none"), nil}})
+	results, err = session.Exec("select * from u")
+	c.Assert(err, IsNil)
+	c.Check(results[0].Rows, DeepEquals, [][]system.Datum{
+		{system.Int4(2), system.Text("none"), nil},
+		{system.Int4(1), system.Text("x"), nil},
+		{system.Int4(3), system.Text("none"), system.Int4(4)},
+		{system.Int4(5), system.Text("y"), nil},
+		{system.Int4(1), system.Text("none"), nil},
+	})
+
+	for query, msg := range map[string]string{
+		"insert into t (c) values (1)":                "null value in column \"a\" violates not-null constraint",
+		"insert into t values (1, 2, null, 4)":        "INSERT has more expressions than target columns",
+		"insert into t (a, c) values (1)":             "INSERT has more target columns than expressions",
+		"insert into t (a, c) select a from t":        "INSERT has more target columns than expressions",
+		"insert into t (a) values (1), (2, 3)":        "VALUES lists must all be the same length",
+		"insert into t (a) values ('x'::text)":        "column \"a\" is of type int4 but expression is of type text",
+		"insert into t (a) values ('x')":              "invalid input syntax for type integer: \"x\"",
+		"insert into t (b) values (1)":                "column \"b\" of relation \"t\" does not exist",
+		"insert into t (a, a) values (1, 1)":          "column \"a\" specified more than once",
+		"insert into t (a) values (a)":                "column \"a\" does not exist",
+		"insert into t (a) values (1) returning x":    "column \"x\" does not exist",
+		"insert into bp_class select * from bp_class": "permission denied: \"bp_class\" is a system catalog",
+	} {
+		_, err = session.Exec(query)
+		c.Check(err, ErrorMatches, regexp.QuoteMeta(msg), Commentf(query))
+	}
+}
+
+func (s *MySuite) TestColumnDefaults(c *C) {
+	session, done := newSession(c)
+	defer done()
+
+	_, err := session.Exec("create table t (a int default -1, b int default 1 + 1, " +
+		"c date default '2020-01-01'::date, d timestamptz default '2020-01-01 00:00', e int)")
+	c.Assert(err, IsNil)
+	results, err := session.Exec("select adsrc from bp_attrdef")
+	c.Assert(err, IsNil)
+	c.Check(results[0].Rows, DeepEquals, [][]system.Datum{
+		{system.Text("-1")}, {system.Text("(1 + 1)")}, {system.Text("'2020-01-01'::date")},
+		{system.Text("'2020-01-01 00:00'")},
+	})
+
+	// the defaults are computed by each INSERT, in the session's zone
+	_, err = session.Exec("set timezone to '+09'; insert into t (e) values (1)")
+	c.Assert(err, IsNil)
+	_, err = session.Exec("set timezone to utc; insert into t (e) values (2)")
+	c.Assert(err, IsNil)
+	results, err = session.Exec("select a, b, c::text, d::text from t order by e")
+	c.Assert(err, IsNil)
+	c.Check(results[0].Rows, DeepEquals, [][]system.Datum{
+		{system.Int4(-1), system.Int4(2), system.Text("2020-01-01"), system.Text("2019-12-31 15:00:00+00")},
+		{system.Int4(-1), system.Int4(2), system.Text("2020-01-01"), system.Text("2020-01-01 00:00:00+00")},
+	})
+
+	// the default of an added column is computed once, for the rows
+	// written before
+	_, err = session.Exec("alter table t add f int default 2 * 3")
+	c.Assert(err, IsNil)
+	results, err = session.Exec("select f from t")
+	c.Assert(err, IsNil)
+	c.Check(results[0].Rows, DeepEquals, [][]system.Datum{{system.Int4(6)}, {system.Int4(6)}})
+
+	for query, msg := range map[string]string{
+		"create table u (a int default b)":                 "cannot use column reference in DEFAULT expression",
+		"create table u (a int default (select 1 from t))": "cannot use subquery in DEFAULT expression",
+		"create table u (a int default count(*))":          "aggregate functions are not allowed in DEFAULT expressions",
+		"create table u (a int default 'x'::text)":         "column \"a\" is of type int4 but default expression is of type text",
+		"alter table t add g date default 1":               "column \"g\" is of type date but default expression is of type int4",
+		"create table u (a int default 'x')":               "invalid input syntax for type integer: \"x\"",
+	} {
+		_, err = session.Exec(query)
+		c.Check(err, ErrorMatches, regexp.QuoteMeta(msg), Commentf(query))
+	}
+}
+
+func (s *MySuite) TestUpdateDelete(c *C) {
+	session, done := newSession(c)
+	defer done()
+
+	_, err := session.Exec("create table t (a int not null, b text); create table u (a int, c int)")
+	c.Assert(err, IsNil)
+	_, err = session.Exec("insert into t values (1, 'x'), (2, 'y'), (3, null); " +
+		"insert into u values (1, 10), (2, 20), (2, 30)")
+	c.Assert(err, IsNil)
+
+	results, err := session.Exec("update t set b = b || '!' where a < 3 returning a, b")
+	c.Assert(err, IsNil)
+	c.Check(tags(results), DeepEquals, []string{"UPDATE 2"})
+	c.Check(results[0].Rows, DeepEquals, [][]system.Datum{
+		{system.Int4(1), system.Text("x!")},
+		{system.Int4(2), system.Text("y!")},
+	})
+	// the rows a statement stores are not read by its own scan
+	results, err = session.Exec("update t set a = a + 10")
+	c.Assert(err, IsNil)
+	c.Check(tags(results), DeepEquals, []string{"UPDATE 3"})
+
+	// a row joined with several rows of the FROM is updated once, and
+	// one joined with none not at all
+	results, err = session.Exec("update t x set b = 'u' || u.c::text from u where x.a = u.a + 10 returning x.a")
+	c.Assert(err, IsNil)
+	c.Check(tags(results), DeepEquals, []string{"UPDATE 2"})
+	results, err = session.Exec("select t.a, t.b, u.c from t, u where t.a - 10 = u.a and u.c < 30")
+	c.Assert(err, IsNil)
+	c.Check(results[0].Rows, DeepEquals, [][]system.Datum{
+		{system.Int4(11), system.Text("u10"), system.Int4(10)},
+		{system.Int4(12), system.Text("u20"), system.Int4(20)},
+	})
+
+	results, err = session.Exec("select ctid from t where a = 13")
+	c.Assert(err, IsNil)
+	c.Check(results[0].Rows, HasLen, 1)
+	ctid := results[0].Rows[0][0].ToString()
+	results, err = session.Exec("delete from t where ctid = '" + ctid + "' returning *")
+	c.Assert(err, IsNil)
+	c.Check(tags(results), DeepEquals, []string{"DELETE 1"})
+	c.Check(results[0].Rows, DeepEquals, [][]system.Datum{{system.Int4(13), nil}})
+
+	// an aborted DELETE leaves the rows
+	_, err = session.Exec("begin; delete from t; rollback")
+	c.Assert(err, IsNil)
+	results, err = session.Exec("delete from t using u where t.a = u.a + 10 and u.c = 30")
+	c.Assert(err, IsNil)
+	c.Check(tags(results), DeepEquals, []string{"DELETE 1"})
+	results, err = session.Exec("select a, b from t")
+	c.Assert(err, IsNil)
+	c.Check(results[0].Rows, DeepEquals, [][]system.Datum{{system.Int4(11), system.Text("u10")}})
+
+	for query, msg := range map[string]string{
+		"update t set a = null":            "null value in column \"a\" violates not-null constraint",
+		"update t set x = 1":               "column \"x\" of relation \"t\" does not exist",
+		"update t set a = 1, a = 2":        "multiple assignments to same column \"a\"",
+		"update t set a = 'x'::text":       "column \"a\" is of type int4 but expression is of type text",
+		"update t x set a = t.a":           "missing FROM-clause entry for table \"t\"",
+		"delete from t where c = 1":        "column \"c\" does not exist",
+		"delete from t using t":            "table name \"t\" specified more than once",
+		"delete from bp_class":             "permission denied: \"bp_class\" is a system catalog",
+		"update t set a = 1 returning u.c": "missing FROM-clause entry for table \"u\"",
+	} {
+		_, err = session.Exec(query)
+		c.Check(err, ErrorMatches, regexp.QuoteMeta(msg), Commentf(query))
+	}
+}
+
+// Returns the rows of the result as strings, in order.
+func rowStrings(result *QueryResult) []string {
+	var rows []string
+	for _, row := range result.Rows {
+		values := make([]string, len(row))
+		for i, value := range row {
+			values[i] = "null"
+			if value != nil {
+				values[i] = value.ToString()
+			}
+		}
+		rows = append(rows, strings.Join(values, "|"))
+	}
+	return rows
+}
+
+// Returns the rows of the result as strings, sorted, for the results whose
+// order is not known.
+func sortedRows(result *QueryResult) []string {
+	rows := rowStrings(result)
+	sort.Strings(rows)
+	return rows
+}
+
+func (s *MySuite) TestJoins(c *C) {
+	session, done := newSession(c)
+	defer done()
+	defer func() { planner.EnableHashJoin, planner.EnableMergeJoin = true, true }()
+
+	_, err := session.Exec("create table t (a int, b text); create table u (a int, c int); " +
+		"insert into t values (1, 'x'), (2, 'y'), (3, null), (null, 'n'); " +
+		"insert into u values (2, 20), (3, 30), (3, 31), (4, 40), (null, 0)")
+	c.Assert(err, IsNil)
+
+	results, err := session.Exec("select * from t join u using (a)")
+	c.Assert(err, IsNil)
+	var names []string
+	for _, attr := range results[0].Columns.Attrs {
+		names = append(names, string(attr.Name))
+	}
+	c.Check(names, DeepEquals, []string{"a", "b", "c"})
+
+	queries := []struct {
+		query string
+		rows  []string
+	}{
+		{"select t.a, b, c from t join u on t.a = u.a",
+			[]string{"2|y|20", "3|null|30", "3|null|31"}},
+		{"select * from t natural left join u",
+			[]string{"1|x|null", "2|y|20", "3|null|30", "3|null|31", "null|n|null"}},
+		{"select * from t right outer join u using (a)",
+			[]string{"2|y|20", "3|null|30", "3|null|31", "4|null|40", "null|null|0"}},
+		{"select * from t full join u using (a)",
+			[]string{"1|x|null", "2|y|20", "3|null|30", "3|null|31", "4|null|40", "null|null|0", "null|n|null"}},
+		// the ON decides the rows that match; those that do not are kept
+		{"select t.a, u.a from t full join u on t.a = u.a and u.c > 30",
+			[]string{"1|null", "2|null", "3|3", "null|2", "null|3", "null|4", "null|null", "null|null"}},
+		{"select t.a, u.a from t full join u on t.a >= u.a + 1",
+			[]string{"1|null", "2|null", "3|2", "null|3", "null|3", "null|4", "null|null", "null|null"}},
+		{"select t.a, u.c from t left join u on t.a = u.a and t.a > 2",
+			[]string{"1|null", "2|null", "3|30", "3|31", "null|null"}},
+		// the WHERE is checked on the rows joined
+		{"select t.a from t left join u on t.a = u.a where u.a is null",
+			[]string{"1", "null"}},
+		{"select x.a, x.c from (t join u using (a)) as x (a) where x.c > 20",
+			[]string{"3|30", "3|31"}},
+		{"select t.b, v.c from t join u on t.a = u.a join u v on v.a = u.a + 1",
+			[]string{"null|40", "null|40", "y|30", "y|31"}},
+		{"select t.a, u.a from t, u where t.a < u.a and u.c < 40",
+			[]string{"1|2", "1|3", "1|3", "2|3", "2|3"}},
+	}
+	for _, mode := range []struct{ hash, merge bool }{{true, true}, {false, true}, {false, false}} {
+		planner.EnableHashJoin, planner.EnableMergeJoin = mode.hash, mode.merge
+		for _, q := range queries {
+			results, err := session.Exec(q.query)
+			c.Assert(err, IsNil, Commentf(q.query))
+			c.Check(sortedRows(results[0]), DeepEquals, q.rows, Commentf("%s %v", q.query, mode))
+		}
+	}
+	results, err = session.Exec("select t.a from t cross join u")
+	c.Assert(err, IsNil)
+	c.Check(results[0].Rows, HasLen, 20)
+
+	// UPDATE and DELETE read the joins too
+	results, err = session.Exec("update t set b = 'z' from u left join t w on w.a = u.a " +
+		"where t.a = u.a - 2 and w.a is null")
+	c.Assert(err, IsNil)
+	c.Check(tags(results), DeepEquals, []string{"UPDATE 1"})
+	results, err = session.Exec("select b from t where a = 2")
+	c.Assert(err, IsNil)
+	c.Check(results[0].Rows, DeepEquals, [][]system.Datum{{system.Text("z")}})
+
+	for query, msg := range map[string]string{
+		"select a from t join u on true":                    "column reference \"a\" is ambiguous",
+		"select * from t join u using (c)":                  "column \"c\" specified in USING clause does not exist in left table",
+		"select * from t join u using (a, a)":               "column name \"a\" appears more than once in USING clause",
+		"select t.a from (t join u using (a)) x":            "missing FROM-clause entry for table \"t\"",
+		"select * from t join t using (a)":                  "table name \"t\" specified more than once",
+		"select * from t join u on t.b":                     "argument of JOIN/ON must be type boolean, not type text",
+		"select 1 from t, u join u v on t.a = v.a":          "missing FROM-clause entry for table \"t\"",
+		"select 1 from (t join u using (a)) x (p, q, r, s)": "column alias list for \"x\" has too many entries",
+	} {
+		_, err = session.Exec(query)
+		c.Check(err, ErrorMatches, regexp.QuoteMeta(msg), Commentf(query))
+	}
+}
+
+func (s *MySuite) TestOrderByLimit(c *C) {
+	session, done := newSession(c)
+	defer done()
+
+	_, err := session.Exec("create table t (a int, b text); " +
+		"insert into t values (2, 'x'), (null, 'y'), (1, 'z'), (3, null), (2, 'w')")
+	c.Assert(err, IsNil)
+
+	queries := []struct {
+		query string
+		rows  []string
+	}{
+		{"select a, b from t order by a, b",
+			[]string{"1|z", "2|w", "2|x", "3|null", "null|y"}},
+		{"select a, b from t order by a desc, b desc nulls last",
+			[]string{"null|y", "3|null", "2|x", "2|w", "1|z"}},
+		{"select a, b from t order by a nulls first, 2 desc",
+			[]string{"null|y", "1|z", "2|x", "2|w", "3|null"}},
+		// the name of an output column, or an expression of the columns
+		{"select b as a from t order by a", []string{"w", "x", "y", "z", "null"}},
+		{"select b from t order by -a, b", []string{"null", "w", "x", "z", "y"}},
+		{"select a from t order by a limit 2", []string{"1", "2"}},
+		{"select a from t order by a desc offset 1 limit 3", []string{"3", "2", "2"}},
+		{"select a from t order by a offset 4", []string{"null"}},
+		{"select a from t order by a limit all", []string{"1", "2", "2", "3", "null"}},
+		{"select a from t order by a limit null offset null", []string{"1", "2", "2", "3", "null"}},
+		{"select a from t limit 0", nil},
+		{"select a from t order by a limit 1 + 1 offset 10", nil},
+	}
+	for _, q := range queries {
+		results, err := session.Exec(q.query)
+		c.Assert(err, IsNil, Commentf(q.query))
+		c.Check(rowStrings(results[0]), DeepEquals, q.rows, Commentf(q.query))
+	}
+	// the junk columns of the ORDER BY are not returned
+	results, err := session.Exec("select b from t order by a + 1 limit 1")
+	c.Assert(err, IsNil)
+	c.Check(results[0].Columns.Attrs, HasLen, 1)
+	c.Check(results[0].Rows, DeepEquals, [][]system.Datum{{system.Text("z")}})
+	// nor inserted
+	results, err = session.Exec("insert into t select a + 10, b from t order by a desc limit 2 returning a")
+	c.Assert(err, IsNil)
+	c.Check(rowStrings(results[0]), DeepEquals, []string{"null", "13"})
+
+	for query, msg := range map[string]string{
+		"select a from t order by 2":              "ORDER BY position 2 is not in select list",
+		"select a from t order by 'a'":            "non-integer constant in ORDER BY",
+		"select a as x, b as x from t order by x": "ORDER BY \"x\" is ambiguous",
+		"select a from t limit -1":                "LIMIT must not be negative",
+		"select a from t offset 0 - 1":            "OFFSET must not be negative",
+		"select a from t limit a":                 "argument of LIMIT must not contain variables",
+		"select a from t limit true":              "argument of LIMIT must be type int4, not type bool",
+		"select a from t order by c":              "column \"c\" does not exist",
+	} {
+		_, err = session.Exec(query)
+		c.Check(err, ErrorMatches, regexp.QuoteMeta(msg), Commentf(query))
+	}
+}
+
+func (s *MySuite) TestExternalSort(c *C) {
+	session, done := newSession(c)
+	defer done()
+
+	var values []string
+	for i := 0; i < 500; i++ {
+		values = append(values, fmt.Sprintf("(%d, 'row %d')", (i*7919)%500, i))
+	}
+	_, err := session.Exec("create table t (a int, b text); create table u (a int); " +
+		"insert into t values " + strings.Join(values, ", ") + "; " +
+		"insert into u select a from t where a % 10 = 0")
+	c.Assert(err, IsNil)
+	expected, err := session.Exec("select a, b from t order by a desc, b")
+	c.Assert(err, IsNil)
+	c.Assert(expected[0].Rows, HasLen, 500)
+
+	// a few rows a run, and runs enough for more than one merge pass, at
+	// less work_mem than SET takes
+	session.workMem = 1
+	results, err := session.Exec("select a, b from t order by a desc, b")
+	c.Assert(err, IsNil)
+	c.Check(results[0].Rows, DeepEquals, expected[0].Rows)
+	results, err = session.Exec("select a, b from t order by a desc, b limit 3 offset 1")
+	c.Assert(err, IsNil)
+	c.Check(results[0].Rows, DeepEquals, expected[0].Rows[1:4])
+
+	// the sorts of a merge join spill too
+	planner.EnableHashJoin = false
+	defer func() { planner.EnableHashJoin = true }()
+	results, err = session.Exec("select t.a from t join u on t.a = u.a")
+	c.Assert(err, IsNil)
+	c.Check(results[0].Rows, HasLen, 50)
+
+	// the temporary files are gone
+	files, err := ioutil.ReadDir(storage.TempFileDir)
+	c.Assert(err, IsNil)
+	c.Check(files, HasLen, 0)
+
+	// work_mem is the session's own setting, in kB unless a unit is given
+	for value, workMem := range map[string]int{"64": 64, "'100 kB'": 100, "'4MB'": 4096, "default": 4096} {
+		_, err = session.Exec("set work_mem to " + value)
+		c.Assert(err, IsNil)
+		c.Check(session.workMem, Equals, workMem, Commentf(value))
+	}
+	_, err = session.Exec("begin; set work_mem = 1024; rollback")
+	c.Assert(err, IsNil)
+	c.Check(session.workMem, Equals, 4096)
+	for value, msg := range map[string]string{
+		"63":       `63 kB is outside the valid range for parameter "work_mem" (64 .. 2147483647)`,
+		"'4 PB'":   `invalid value for parameter "work_mem": "4 PB"`,
+		"'lots'":   `invalid value for parameter "work_mem": "lots"`,
+		"'4096TB'": `4398046511104 kB is outside the valid range for parameter "work_mem" (64 .. 2147483647)`,
+	} {
+		_, err = session.Exec("set work_mem = " + value)
+		c.Check(err, ErrorMatches, regexp.QuoteMeta(msg), Commentf(value))
+	}
+}
+
+func (s *MySuite) TestAggregates(c *C) {
+	session, done := newSession(c)
+	defer done()
+	defer func() { planner.EnableHashAgg = true }()
+
+	_, err := session.Exec("create table t (a int, b text, f float8); create table e (a int); " +
+		"insert into t values (1, 'x', 1.5), (2, 'y', 2), (1, 'y', null), (null, 'x', 4), " +
+		"(2, null, 0.5), (1, 'x', 1)")
+	c.Assert(err, IsNil)
+
+	queries := []struct {
+		query string
+		rows  []string
+	}{
+		{"select count(*), count(a), count(b), sum(a), sum(f), min(b), max(a) from t",
+			[]string{"6|5|5|7|9|x|2"}},
+		{"select avg(a), avg(f) from t", []string{"1.4|1.8"}},
+		// a single group of no rows
+		{"select count(*), sum(a), max(a), avg(a) from e", []string{"0|null|null|null"}},
+		{"select count(*) from t where a > 5", []string{"0"}},
+		{"select a, count(*), sum(f) from t group by a",
+			[]string{"1|3|2.5", "2|2|2.5", "null|1|4"}},
+		{"select b, min(a) + max(a) from t group by b",
+			[]string{"null|4", "x|2", "y|3"}},
+		{"select a + 1, count(b) from t group by a + 1",
+			[]string{"2|3", "3|1", "null|1"}},
+		{"select a, b, count(*) from t group by a, b",
+			[]string{"1|x|2", "1|y|1", "2|null|1", "2|y|1", "null|x|1"}},
+		{"select a from t group by a having count(*) > 1", []string{"1", "2"}},
+		{"select max(b) from t group by a having a is not null and min(f) < 1", []string{"y"}},
+		{"select count(*) from t having count(*) > 10", nil},
+		{"select count(distinct a), count(distinct b), sum(distinct a) from t", []string{"2|2|3"}},
+		{"select a, count(distinct b) from t group by a", []string{"1|2", "2|1", "null|1"}},
+		{"select distinct a from t", []string{"1", "2", "null"}},
+		{"select distinct a, b from t where a = 1", []string{"1|x", "1|y"}},
+		{"select distinct count(*) from t group by a", []string{"1", "2", "3"}},
+	}
+	for _, hashAgg := range []bool{true, false} {
+		planner.EnableHashAgg = hashAgg
+		for _, q := range queries {
+			results, err := session.Exec(q.query)
+			c.Assert(err, IsNil, Commentf(q.query))
+			c.Check(sortedRows(results[0]), DeepEquals, q.rows, Commentf("%s %v", q.query, hashAgg))
+		}
+	}
+	// DISTINCT sorts for the ORDER BY
+	results, err := session.Exec("select distinct b from t order by b desc")
+	c.Assert(err, IsNil)
+	c.Check(rowStrings(results[0]), DeepEquals, []string{"null", "y", "x"})
+	results, err = session.Exec("select a, count(*) from t group by a order by 2 desc, a limit 2")
+	c.Assert(err, IsNil)
+	c.Check(rowStrings(results[0]), DeepEquals, []string{"1|3", "2|2"})
+
+	for query, msg := range map[string]string{
+		"select a, count(*) from t":                 "column \"t.a\" must appear in the GROUP BY clause or be used in an aggregate function",
+		"select b from t group by a":                "column \"t.b\" must appear in the GROUP BY clause or be used in an aggregate function",
+		"select a from t group by a having b = 'x'": "column \"t.b\" must appear in the GROUP BY clause or be used in an aggregate function",
+		"select a from t where count(*) > 1":        "aggregate functions are not allowed in WHERE",
+		"select a from t group by count(*)":         "aggregate functions are not allowed in GROUP BY",
+		"select sum(count(*)) from t":               "aggregate function calls cannot be nested",
+		"select count() from t":                     "count(*) must be used to call a parameterless aggregate function",
+		"select abs(distinct a) from t":             "DISTINCT specified, but abs is not an aggregate function",
+		"select distinct a from t order by b":       "for SELECT DISTINCT, ORDER BY expressions must appear in select list",
+		"insert into e values (count(*))":           "aggregate functions are not allowed in VALUES",
+		"update e set a = max(a)":                   "aggregate functions are not allowed in UPDATE",
+		"select sum(b) from t":                      "function sum(text) does not exist",
+	} {
+		_, err = session.Exec(query)
+		c.Check(err, ErrorMatches, regexp.QuoteMeta(msg), Commentf(query))
+	}
+}
+
+func (s *MySuite) TestHashAggSpill(c *C) {
+	session, done := newSession(c)
+	defer done()
+	defer func() { planner.EnableHashAgg = true }()
+
+	var values []string
+	for i := 0; i < 2000; i++ {
+		values = append(values, fmt.Sprintf("(%d, 'row %d')", (i*7919)%700, i))
+	}
+	_, err := session.Exec("create table t (a int, b text); " +
+		"insert into t values " + strings.Join(values, ", "))
+	c.Assert(err, IsNil)
+	query := "select a, count(*), min(b), count(distinct b) from t group by a"
+	planner.EnableHashAgg = false
+	expected, err := session.Exec(query)
+	c.Assert(err, IsNil)
+	c.Assert(expected[0].Rows, HasLen, 700)
+
+	// the groups that do not fit are written out, more than once for some
+	planner.EnableHashAgg = true
+	session.workMem = 1
+	results, err := session.Exec(query)
+	c.Assert(err, IsNil)
+	c.Check(sortedRows(results[0]), DeepEquals, sortedRows(expected[0]))
+	results, err = session.Exec("select distinct b from t")
+	c.Assert(err, IsNil)
+	c.Check(results[0].Rows, HasLen, 2000)
+
+	// the temporary files are gone
+	files, err := ioutil.ReadDir(storage.TempFileDir)
+	c.Assert(err, IsNil)
+	c.Check(files, HasLen, 0)
+}
+
+func (s *MySuite) TestSubqueries(c *C) {
+	session, done := newSession(c)
+	defer done()
+	defer func() { planner.EnableHashJoin, planner.EnableMergeJoin = true, true }()
+
+	_, err := session.Exec("create table t (a int, b text); create table u (a int, c int); " +
+		"insert into t values (1, 'x'), (2, 'y'), (3, null), (null, 'n'); " +
+		"insert into u values (2, 20), (3, 30), (3, 31), (4, 40), (null, 0)")
+	c.Assert(err, IsNil)
+
+	results, err := session.Exec("select * from (select a, c * 2 from u) s (x)")
+	c.Assert(err, IsNil)
+	var names []string
+	for _, attr := range results[0].Columns.Attrs {
+		names = append(names, string(attr.Name))
+	}
+	c.Check(names, DeepEquals, []string{"x", "?column?"})
+
+	queries := []struct {
+		query string
+		rows  []string
+	}{
+		{"select s.a, s.d from (select a, c * 2 from u where c > 20) s (a, d)",
+			[]string{"3|60", "3|62", "4|80"}},
+		{"select x from (select a as x from t) sub where x > 1", []string{"2", "3"}},
+		{"select * from (select * from (select a from t) x where a > 1) y", []string{"2", "3"}},
+		{"select t.b, s.c from t join (select a, c from u where c < 40) s on t.a = s.a",
+			[]string{"null|30", "null|31", "y|20"}},
+		{"select t.a, s.one from t left join (select a, 1 as one from u) s on t.a = s.a",
+			[]string{"1|null", "2|1", "3|1", "3|1", "null|null"}},
+		{"select t.a, s.c from t left join (select a, c from u where c > 30) s using (a)",
+			[]string{"1|null", "2|null", "3|31", "null|null"}},
+		{"select s.a, s.n from (select a, count(*) as n from u group by a) s where s.n > 1",
+			[]string{"3|2"}},
+		{"select a, (select max(c) from u) from t where a = 1", []string{"1|40"}},
+		{"select a, (select max(c) from u where u.a = t.a) from t",
+			[]string{"1|null", "2|20", "3|31", "null|null"}},
+		{"select a, (select c from u where c > 100) from t where a = 1", []string{"1|null"}},
+		{"select a from t where a = (select min(a) from u)", []string{"2"}},
+		{"select a from t where a in (select a from u)", []string{"2", "3"}},
+		// NOT IN is NULL if the subquery returns a NULL, as = ANY is
+		{"select a from t where a not in (select a from u)", nil},
+		{"select a from t where a not in (select a from u where a is not null)", []string{"1"}},
+		{"select a, a in (select a from u) from t",
+			[]string{"1|null", "2|t", "3|t", "null|null"}},
+		{"select a from t where exists (select 1 from u where u.a = t.a)", []string{"2", "3"}},
+		{"select a from t where not exists (select 1 from u where u.a = t.a)", []string{"1", "null"}},
+		{"select a from t where exists (select 1 from u where u.a = t.a and " +
+			"exists (select 1 from u v where v.a = t.a and v.c > u.c))", []string{"3"}},
+		{"select a, (select c from u where u.a >= t.a order by c limit 1) from t",
+			[]string{"1|20", "2|20", "3|30", "null|null"}},
+		{"select a, (select count(*) from u join u v on u.a = v.a where v.a > t.a) from t",
+			[]string{"1|6", "2|5", "3|1", "null|0"}},
+		{"select a, (select max(c) from (select c from u where u.a = t.a) s) from t",
+			[]string{"1|null", "2|20", "3|31", "null|null"}},
+		{"select a, (select count(*) from u where u.a = t.a) from t group by a",
+			[]string{"1|0", "2|1", "3|2", "null|0"}},
+		{"select count(*) from t where a in (select a from u)", []string{"2"}},
+		{"select a from t group by a having count(*) = (select count(*) from u where c = 20)",
+			[]string{"1", "2", "3", "null"}},
+	}
+	for _, mode := range []struct{ hash, merge bool }{{true, true}, {false, true}, {false, false}} {
+		planner.EnableHashJoin, planner.EnableMergeJoin = mode.hash, mode.merge
+		for _, q := range queries {
+			results, err := session.Exec(q.query)
+			c.Assert(err, IsNil, Commentf(q.query))
+			c.Check(sortedRows(results[0]), DeepEquals, q.rows, Commentf("%s %v", q.query, mode))
+		}
+	}
+
+	// INSERT, UPDATE and DELETE compute subqueries too
+	_, err = session.Exec("create table w (a int, c int)")
+	c.Assert(err, IsNil)
+	for _, q := range []struct{ query, tag string }{
+		{"insert into w values (1, (select max(c) from u))", "INSERT 0 1"},
+		{"insert into w select a, (select min(c) from u where u.a = t.a) from t", "INSERT 0 4"},
+		{"update w set c = (select max(c) from u where u.a = w.a) " +
+			"where exists (select 1 from u where u.a = w.a)", "UPDATE 2"},
+		{"delete from w where a not in (select a from u where a is not null)", "DELETE 2"},
+	} {
+		results, err := session.Exec(q.query)
+		c.Assert(err, IsNil, Commentf(q.query))
+		c.Check(tags(results), DeepEquals, []string{q.tag}, Commentf(q.query))
+	}
+	results, err = session.Exec("select * from w")
+	c.Assert(err, IsNil)
+	c.Check(sortedRows(results[0]), DeepEquals, []string{"2|20", "3|31", "null|null"})
+
+	for query, msg := range map[string]string{
+		"select * from (select a from t)":                                 "subquery in FROM must have an alias",
+		"select * from (select a from t) s (x, y)":                        "table \"s\" has 1 columns available but 2 columns specified",
+		"select * from t, (select c from u where u.a = t.a) s":            "missing FROM-clause entry for table \"t\"",
+		"select (select a, b from t) from t":                              "subquery must return only one column",
+		"select 1 from t where a in (select a, c from u)":                 "subquery has too many columns",
+		"select (select a from u) from t":                                 "more than one row returned by a subquery used as an expression",
+		"select (select max(c) from u where u.c > t.a) from t group by b": "subquery uses ungrouped column \"t.a\" from outer query",
+		"select a from t where exists (select 1 from u where u.a = t.x)":  "column t.x does not exist",
+	} {
+		_, err = session.Exec(query)
+		c.Check(err, ErrorMatches, regexp.QuoteMeta(msg), Commentf(query))
+	}
+}
@@ -0,0 +1,124 @@
+package tcop
+
+import (
+	"math"
+	"strconv"
+	"strings"
+
+	"bigpot/commands"
+	"bigpot/parser"
+	"bigpot/system"
+)
+
+// Runs a utility statement, as postgres' ProcessUtility.
+func (s *Session) processUtility(stmt parser.Node) (*QueryResult, error) {
+	switch stmt := stmt.(type) {
+	case *parser.CreateStmt:
+		if _, err := commands.DefineRelation(stmt, s.tx, s.syscache, s.relcache, s.timeZone); err != nil {
+			return nil, err
+		}
+		return &QueryResult{Tag: "CREATE TABLE"}, nil
+	case *parser.DropStmt:
+		if err := commands.RemoveRelations(stmt, s.tx, s.syscache, s.relcache); err != nil {
+			return nil, err
+		}
+		return &QueryResult{Tag: "DROP TABLE"}, nil
+	case *parser.AlterTableStmt:
+		if err := commands.AlterTable(stmt, s.tx, s.syscache, s.relcache, s.timeZone); err != nil {
+			return nil, err
+		}
+		return &QueryResult{Tag: "ALTER TABLE"}, nil
+	case *parser.RenameStmt:
+		if err := commands.RenameRelation(stmt, s.tx, s.syscache, s.relcache); err != nil {
+			return nil, err
+		}
+		return &QueryResult{Tag: "ALTER TABLE"}, nil
+	case *parser.VariableSetStmt:
+		if err := s.setConfigOption(stmt); err != nil {
+			return nil, err
+		}
+		return &QueryResult{Tag: "SET"}, nil
+	}
+	panic("unknown utility statement")
+}
+
+// The default of work_mem, in kilobytes, and the least and the most it
+// may be set to, as postgres' guc.c.
+const (
+	defaultWorkMem = 4096
+	minWorkMem     = 64
+	maxWorkMem     = math.MaxInt32
+)
+
+// The units a memory setting may be given in, in kilobytes, as postgres'
+// memory_unit_conversion_table.
+var memoryUnits = map[string]int64{
+	"kB": 1,
+	"MB": 1024,
+	"GB": 1024 * 1024,
+	"TB": 1024 * 1024 * 1024,
+}
+
+// Sets the parameter of a SET, as postgres' set_config_option.  Names
+// are case insensitive; TimeZone and work_mem are the only parameters for
+// now.
+func (s *Session) setConfigOption(stmt *parser.VariableSetStmt) error {
+	switch strings.ToLower(stmt.Name) {
+	case "timezone":
+		if stmt.Default {
+			s.timeZone = system.UTC
+			return nil
+		}
+		tz, err := system.LookupTimeZone(stmt.Value)
+		if err != nil {
+			return err
+		}
+		s.timeZone = tz
+		return nil
+	case "work_mem":
+		if stmt.Default {
+			s.workMem = defaultWorkMem
+			return nil
+		}
+		workMem, err := parseMemorySetting("work_mem", stmt.Value, minWorkMem, maxWorkMem)
+		if err != nil {
+			return err
+		}
+		s.workMem = workMem
+		return nil
+	}
+	return system.Ereport(system.UndefinedObject,
+		"unrecognized configuration parameter \"%s\"", stmt.Name)
+}
+
+// Reads the value of the memory parameter name, in kilobytes unless a
+// unit follows the number, as postgres' parse_int.
+func parseMemorySetting(name, value string, min, max int64) (int, error) {
+	value = strings.TrimSpace(value)
+	end := 0
+	if end < len(value) && (value[end] == '+' || value[end] == '-') {
+		end++
+	}
+	for end < len(value) && value[end] >= '0' && value[end] <= '9' {
+		end++
+	}
+	n, err := strconv.ParseInt(value[:end], 10, 64)
+	if err != nil {
+		return 0, system.Ereport(system.InvalidParameterValue,
+			"invalid value for parameter \"%s\": \"%s\"", name, value)
+	}
+	kb := int64(1)
+	if unit := strings.TrimSpace(value[end:]); unit != "" {
+		var ok bool
+		if kb, ok = memoryUnits[unit]; !ok {
+			return 0, system.Ereport(system.InvalidParameterValue,
+				"invalid value for parameter \"%s\": \"%s\"", name, value)
+		}
+	}
+	// in floating point, so that a large value does not wrap around
+	if f := float64(n) * float64(kb); f < float64(min) || f > float64(max) {
+		return 0, system.Ereport(system.InvalidParameterValue,
+			"%.0f kB is outside the valid range for parameter \"%s\" (%d .. %d)", f, name, min, max)
+	}
+	return int(n * kb), nil
+}